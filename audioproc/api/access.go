@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// Why an outbound connection was refused. An *EgressError wraps one of
+// these and also matches ErrEgressDenied.
+var (
+	ErrEgressDenied         = core.ErrEgressDenied
+	ErrEgressDenylisted     = core.ErrEgressDenylisted
+	ErrEgressNotAllowlisted = core.ErrEgressNotAllowlisted
+	ErrEgressPrivate        = core.ErrEgressPrivate
+)
+
+// EgressError reports an outbound connection refused by policy.
+type EgressError = core.EgressError
+
+// EgressPolicy decides which resolved addresses a client may connect to.
+// Deny always wins; an explicit Allow entry overrides DenyPrivate; and
+// when Allow is set nothing outside it is reachable.
+type EgressPolicy = core.EgressPolicy
+
+// Egress builds the HTTP clients the server calls out with: webhooks,
+// alerts, the identity service, and any HTTP transcriber, which should take
+// its client from Server.Egress. They share the proxy, destination policy
+// and connection tuning in Config.
+type Egress = core.Egress
+
+// NewEgress reads cfg's egress settings. Without Config.EgressProxy the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables choose the proxy.
+func NewEgress(cfg audioproc.Config) *Egress {
+	return core.NewEgress(cfg)
+}
+
+// IdentityProvider decides whether a user ID was provisioned. ValidateUser
+// returns an error for users that do not exist.
+type IdentityProvider = core.IdentityProvider
+
+// StaticIdentityProvider accepts a fixed set of user IDs.
+type StaticIdentityProvider = core.StaticIdentityProvider
+
+// NewStaticIdentityProvider accepts exactly users.
+func NewStaticIdentityProvider(users []string) StaticIdentityProvider {
+	return core.NewStaticIdentityProvider(users)
+}
+
+// HTTPIdentityProvider asks an external service whether a user exists with
+// GET <URL>?user_id=<id>: 200 means yes, 404 means no, anything else is an
+// outage. Answers are cached for CacheTTL (known users) or NegativeTTL
+// (unknown users), and concurrent lookups of one user share a request.
+// Outages are never cached; FailOpen decides whether they admit the user.
+type HTTPIdentityProvider = core.HTTPIdentityProvider
+
+// NewHTTPIdentityProvider checks users against cfg's identity service,
+// calling it through egress.
+func NewHTTPIdentityProvider(cfg audioproc.Config, egress *Egress) *HTTPIdentityProvider {
+	return core.NewHTTPIdentityProvider(cfg, egress)
+}
+
+// KeyRing authenticates requests against the configured static keys and the
+// keys minted through /admin/keys. Stored keys are cached for ttl, so a key
+// revoked or expired by another process stops working within ttl; changes
+// made through this KeyRing take effect immediately.
+type KeyRing = core.KeyRing
+
+// NewKeyRing mints and checks API keys kept in store.
+func NewKeyRing(cfg audioproc.Config, st *store.MemoryStore) *KeyRing {
+	return core.NewKeyRing(cfg, st)
+}
+
+// ShareLink grants read-only access to one session until ExpiresAt. A link
+// with MaxDownloads set stops working altogether once that many audio
+// downloads have been made with it; a single-use link has 1.
+type ShareLink = core.ShareLink
+
+// ShareLinks mints and checks share tokens. A token is the link encoded as
+// JSON and signed with HMAC-SHA256, so nothing but each link's owner,
+// revocation and download count is stored.
+// Without a configured secret a random one is used, and links stop working
+// when the process restarts.
+type ShareLinks = core.ShareLinks
+
+// NewShareLinks signs links with cfg.ShareSecret, or with a random secret
+// when none is set.
+func NewShareLinks(cfg audioproc.Config, st *store.MemoryStore) *ShareLinks {
+	return core.NewShareLinks(cfg, st)
+}
+
+// RunShareSweeper prunes expired share links' state every interval until
+// ctx ends.
+func RunShareSweeper(ctx context.Context, st *store.MemoryStore, interval time.Duration) {
+	core.RunShareSweeper(ctx, st, interval)
+}
+
+// Presigner hands out URLs that the blob backend serves itself, such as S3
+// presigned GETs, so the audio does not pass through this service.
+type Presigner = core.Presigner
+
+// SignedURL is a time-limited link to a chunk's audio that needs no API
+// key. Direct is set when the URL points at the blob backend rather than
+// at GET /chunks/{id}/audio.
+type SignedURL = core.SignedURL
+
+// URLSigner signs audio download URLs with the share links' secret. The
+// signature covers the path, the expiry and the bound IP, so none of them
+// can be changed without invalidating it.
+type URLSigner = core.URLSigner
+
+// NewURLSigner signs with the secret of shares.
+func NewURLSigner(cfg audioproc.Config, st *store.MemoryStore, shares *ShareLinks) *URLSigner {
+	return core.NewURLSigner(cfg, st, shares)
+}
@@ -0,0 +1,35 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// Mount the service in another program's HTTP server.
+func ExampleServer_Handler() {
+	srv := api.New(audioproc.DefaultConfig(), store.NewMemoryStore(), nil)
+	stop := srv.Start()
+	defer stop()
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	wav := pipeline.SineWAV(440, time.Second, 8000)
+	resp, err := http.Post(ts.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(wav))
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	var meta store.Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	fmt.Println(meta.Status, meta.Transcript)
+	// Output: processed Hello World
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// ObjectStore is where scheduled exports are written, such as an S3
+// bucket. Put replaces any object already at key.
+type ObjectStore = core.ObjectStore
+
+// DirObjectStore is an ObjectStore on the local filesystem, keys being
+// slash-separated paths under the directory, as for a mounted bucket.
+type DirObjectStore = core.DirObjectStore
+
+// AutoExportRun reports one scheduled export. The window is the time
+// since the previous run; sessions closed in it are exported, along with
+// earlier ones whose export failed. Skipped counts sessions in the window
+// that were already exported.
+type AutoExportRun = core.AutoExportRun
+
+// AutoExportStatus is the body of GET /admin/auto-exports, runs newest
+// first.
+type AutoExportStatus = core.AutoExportStatus
+
+// AutoExportManifest is a session bundle's manifest.json, written after
+// every other object in it, so its presence marks a complete bundle.
+type AutoExportManifest = core.AutoExportManifest
+
+// AutoExporter exports closed sessions to Objects on a cron schedule,
+// each as a bundle of chunk metadata in NDJSON, the chunks' audio and a
+// manifest under prefix/<closed date>/<user>/<session>/r<revision>/.
+// Exported sessions are marked in the store, so a session is exported
+// once however many runs see it; one that fails is tried again next run.
+type AutoExporter = core.AutoExporter
+
+// NewAutoExporter writes to cfg.AutoExportDir unless Objects is replaced.
+// It does nothing without cfg.AutoExportSchedule; with one, sessions keeps
+// closed sessions until they are exported. Call it before sessions is in
+// use.
+func NewAutoExporter(cfg audioproc.Config, st *store.MemoryStore, sessions *SessionTracker) *AutoExporter {
+	return core.NewAutoExporter(cfg, st, sessions)
+}
+
+// RunAutoExports runs scheduled exports until ctx ends, checking every
+// minute, the schedule's resolution. While ro is on nothing runs; windows
+// missed meanwhile fold into the next run.
+func RunAutoExports(ctx context.Context, a *AutoExporter, ro *store.ReadOnly) {
+	core.RunAutoExports(ctx, a, ro)
+}
+
+// BillingMeter charges users for the audio sent to the transcriber, at the
+// rate in force when each chunk is transcribed.
+type BillingMeter = core.BillingMeter
+
+// NewBillingMeter charges usage to store at cfg.TranscriptionRate.
+func NewBillingMeter(cfg audioproc.Config, st *store.MemoryStore) *BillingMeter {
+	return core.NewBillingMeter(cfg, st)
+}
+
+// CronSchedule is a five-field cron expression: minute, hour, day of
+// month, month and day of week (0 or 7 is Sunday). Each field is *, a
+// number, a range a-b or a list of them, any of which may take a /step.
+// Times are matched in UTC.
+type CronSchedule = core.CronSchedule
+
+// ParseCronSchedule parses expr, e.g. "30 2 * * *" or "@daily".
+func ParseCronSchedule(expr string) (*CronSchedule, error) {
+	return core.ParseCronSchedule(expr)
+}
+
+// ExportStatus reports an export's progress. DownloadURL is set once the
+// bundle is built and stops working at ExpiresAt; the status itself is
+// kept for one more link lifetime after that. A failed export's status is
+// kept until its ExpiresAt. Encryption is set for bundles sealed with a
+// passphrase; see audioctl decrypt.
+type ExportStatus = core.ExportStatus
+
+// ExportManifest is the bundle's manifest.json, listing every other file
+// in it.
+type ExportManifest = core.ExportManifest
+
+// ExportFile is one file in an export archive's manifest.
+type ExportFile = core.ExportFile
+
+// Exporter builds per-user data export bundles for subject access
+// requests. A bundle is a zip written to Dir, with the user's chunk
+// metadata, session summaries, annotations and audit events, and
+// optionally their audio. Bundles are served through signed links that
+// expire after ttl, when the file is removed too.
+type Exporter = core.Exporter
+
+// NewExporter builds exports on goroutines and links finished ones through
+// shares.
+func NewExporter(cfg audioproc.Config, st *store.MemoryStore, goroutines *Goroutines, shares *ShareLinks) *Exporter {
+	return core.NewExporter(cfg, st, goroutines, shares)
+}
+
+// RunExportSweeper removes expired exports every interval until ctx ends.
+// Nothing is removed while ro is on.
+func RunExportSweeper(ctx context.Context, e *Exporter, ro *store.ReadOnly, interval time.Duration) {
+	core.RunExportSweeper(ctx, e, ro, interval)
+}
+
+// QuotaWarning is the payload of a quota_warning event: UserID's use of
+// Resource ("bytes" or "chunks") reached Threshold percent of Limit.
+type QuotaWarning = core.QuotaWarning
+
+// SoftQuotas warns users as their storage nears Config.QuotaBytes or
+// Config.QuotaChunks. Nothing is refused: when usage reaches one of
+// Config.QuotaWarnAt a quota_warning event is published on the bus, at
+// most once per resource and threshold per day. A nil *SoftQuotas does
+// nothing.
+type SoftQuotas = core.SoftQuotas
+
+// NewSoftQuotas returns nil unless a quota is configured.
+func NewSoftQuotas(cfg audioproc.Config, st store.QuotaStore, bus *store.Bus) *SoftQuotas {
+	return core.NewSoftQuotas(cfg, st, bus)
+}
+
+// ParseQuotaThresholds reads comma-separated percentages such as "80,95".
+func ParseQuotaThresholds(s string) ([]int, error) {
+	return core.ParseQuotaThresholds(s)
+}
+
+// RunSoftQuotas checks the user of every processed chunk, however it was
+// uploaded, until ctx ends.
+func RunSoftQuotas(ctx context.Context, q *SoftQuotas) {
+	core.RunSoftQuotas(ctx, q)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// AlertErrorRate fires when the share of 5xx responses over the rule's
+// window exceeds the threshold.
+const AlertErrorRate = core.AlertErrorRate
+
+// AlertQueueDepth fires when more jobs than the threshold have waited
+// for a worker for the rule's duration.
+const AlertQueueDepth = core.AlertQueueDepth
+
+// AlertDeadLetters fires when more chunks than the threshold failed
+// processing over the rule's window; failed chunks are the pipeline's
+// dead-letter queue. It watches the queue grow rather than its size,
+// so failures nobody has reprocessed yet stop firing once they age out
+// of the window.
+const AlertDeadLetters = core.AlertDeadLetters
+
+// AlertStoreP99 fires when the 99th percentile of recent store writes,
+// in milliseconds, exceeds the threshold for the rule's duration.
+const AlertStoreP99 = core.AlertStoreP99
+
+// AlertRule is one condition the alert engine watches. For is the error
+// rate's window; for the other kinds it is how long the condition must hold
+// before the alert fires.
+type AlertRule = core.AlertRule
+
+// ParseAlertRules reads comma-separated rules of the form
+// kind>threshold[/duration], e.g. "error_rate>0.05/5m,queue_depth>100/1m,
+// dlq>5/15m,store_p99>250ms/1m". store_p99 thresholds are durations.
+func ParseAlertRules(s string) ([]AlertRule, error) {
+	return core.ParseAlertRules(s)
+}
+
+// AlertSources are the readings the rules are evaluated against. Any left
+// nil reads as zero.
+type AlertSources = core.AlertSources
+
+// AlertStatus is a rule's current state, as listed by /healthz.
+type AlertStatus = core.AlertStatus
+
+// Alerts evaluates alert rules against the service's counters, posting to
+// a webhook when an alert fires and again when it resolves.
+type Alerts = core.Alerts
+
+// NewAlerts builds an engine for cfg.AlertRules that reads src and posts
+// to cfg.AlertWebhookURL, if set, through egress.
+func NewAlerts(cfg audioproc.Config, egress *Egress, clock store.Clock, src AlertSources) *Alerts {
+	return core.NewAlerts(cfg, egress, clock, src)
+}
+
+// RunAlerts evaluates the rules every interval until ctx ends.
+func RunAlerts(ctx context.Context, a *Alerts, interval time.Duration) {
+	core.RunAlerts(ctx, a, interval)
+}
+
+// MQTTBridge feeds audio published by devices over MQTT into the
+// pipeline. A payload published to a topic matching Config.MQTTTopic is
+// ingested as one chunk of the user and session named by the topic, and
+// its metadata, or an error, is published to Config.MQTTResponseTopic.
+type MQTTBridge = core.MQTTBridge
+
+// NewMQTTBridge checks that cfg.MQTTTopic names both the user and the
+// session level.
+func NewMQTTBridge(cfg audioproc.Config, st *store.MemoryStore, jobs chan<- pipeline.Job, sessions *SessionTracker, identity IdentityProvider) (*MQTTBridge, error) {
+	return core.NewMQTTBridge(cfg, st, jobs, sessions, identity)
+}
+
+// Receipts signs upload receipts with Config.ReceiptKeys. A nil *Receipts
+// issues none.
+type Receipts = core.Receipts
+
+// NewReceipts returns nil when no receipt keys are configured.
+func NewReceipts(cfg audioproc.Config) *Receipts {
+	return core.NewReceipts(cfg)
+}
+
+// WebhookRetry is a subscription's retry policy. A failed attempt is
+// retried after BackoffMS, doubling each time, until MaxAttempts have been
+// made.
+type WebhookRetry = core.WebhookRetry
+
+// WebhookSubscription posts the events of the listed types to URL. UserIDs,
+// when set, limit it to events about those users' chunks and sessions.
+// Deliveries are signed with Secret, which is generated unless the
+// subscription sets one; see package webhook for how receivers check them.
+type WebhookSubscription = core.WebhookSubscription
+
+// WebhookDelivery is the outcome of delivering one event to one
+// subscription, after any retries.
+type WebhookDelivery = core.WebhookDelivery
+
+// Webhooks delivers bus events to the subscriptions kept in the store. Each
+// subscription has its own bus queue and delivery goroutine, so one that is
+// slow or retrying does not hold up the others. The newest deliveries are
+// kept for inspection.
+type Webhooks = core.Webhooks
+
+// NewWebhooks delivers events published on bus to the subscriptions in
+// store through egress once Start is called.
+func NewWebhooks(cfg audioproc.Config, egress *Egress, st *store.MemoryStore, bus *store.Bus) *Webhooks {
+	return core.NewWebhooks(cfg, egress, st, bus)
+}
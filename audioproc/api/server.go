@@ -0,0 +1,74 @@
+// Package api serves the audio processor over HTTP, WebSocket and MQTT.
+// New wires a store and a pipeline into a Server whose Handler can be
+// mounted in another program or served with Run.
+package api
+
+import (
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// ConcurrencyLimiter caps how many requests are served at once, globally
+// and per route, so memory held by request bodies stays bounded. Streaming
+// routes hold their connection for as long as the client likes, so they
+// draw on a separate budget instead of the global one.
+type ConcurrencyLimiter = core.ConcurrencyLimiter
+
+// NewConcurrencyLimiter applies cfg's global and per-route limits.
+func NewConcurrencyLimiter(cfg audioproc.Config) *ConcurrencyLimiter {
+	return core.NewConcurrencyLimiter(cfg)
+}
+
+// Goroutines owns a server's long-lived goroutines: the workers and
+// sweepers it starts, and the WebSocket and SSE handlers that outlive an
+// ordinary request. Each is counted under a component label, runs with a
+// context that ends when the server stops, and is waited for by Wait.
+type Goroutines = core.Goroutines
+
+// Harness runs the full service (router, store and worker pool) on a random
+// local port so integrations can be tested end to end over real HTTP and
+// WebSocket connections.
+type Harness = core.Harness
+
+// NewHarness runs the service on a fresh MemoryStore.
+func NewHarness(cfg audioproc.Config) *Harness {
+	return core.NewHarness(cfg)
+}
+
+// NewHarnessWithStore runs the service on an existing store, as if it were
+// restarting against a durable backend.
+func NewHarnessWithStore(cfg audioproc.Config, st *store.MemoryStore) *Harness {
+	return core.NewHarnessWithStore(cfg, st)
+}
+
+// Server wires the store, pipeline, background loops and HTTP routes into
+// one unit that can be embedded in another program or run by main.
+type Server = core.Server
+
+// New builds a Server around store and pipeline. A nil pipeline uses the
+// stub transcriber, and a pipeline without a Limiter gets an adaptive one
+// that watches the server's job queue.
+func New(cfg audioproc.Config, st *store.MemoryStore, p *pipeline.Pipeline) *Server {
+	return core.New(cfg, st, p)
+}
+
+// BuildInfo identifies the running binary.
+type BuildInfo = core.BuildInfo
+
+// CurrentBuild returns the running binary's build details.
+func CurrentBuild() BuildInfo {
+	return core.CurrentBuild()
+}
+
+// Capabilities describes what a deployment offers, built from its live
+// configuration so clients can adapt to it rather than assume.
+type Capabilities = core.Capabilities
+
+// Limits are the bounds a client can run into; zero means unlimited.
+type Limits = core.Limits
+
+// FormatSupport lists the formats accepted on upload and offered on
+// download. Download formats map to the bitrates they offer in kbps.
+type FormatSupport = core.FormatSupport
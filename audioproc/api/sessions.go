@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// AdminSession is one session in the cross-user listing of
+// GET /admin/sessions.
+type AdminSession = core.AdminSession
+
+// AdminSessionPage is a page of GET /admin/sessions. NextCursor is empty
+// on the last page.
+type AdminSessionPage = core.AdminSessionPage
+
+// ChunkComparison is what POST /chunks/{id}/compare returns: how the
+// stored metadata differs from what the current pipeline makes of the same
+// audio. Changed lists the fields that differ materially, by JSON name.
+type ChunkComparison = core.ChunkComparison
+
+// NumberDiff compares a measurement.
+type NumberDiff = core.NumberDiff
+
+// TranscriptDiff lists the word edits that turn the stored transcript into
+// the new one. Position is where in the stored words each edit applies.
+type TranscriptDiff = core.TranscriptDiff
+
+// WordEdit is an insert, delete or replace of a run of words.
+type WordEdit = core.WordEdit
+
+// SessionComparison summarises comparing every chunk of a session. Changed
+// counts chunks per materially changed field; Chunks lists only the chunks
+// that changed.
+type SessionComparison = core.SessionComparison
+
+// SessionCompareStatus is a session comparison's progress. Result is set
+// once State is done.
+type SessionCompareStatus = core.SessionCompareStatus
+
+// SessionComparer compares sessions in the background, since a session
+// can take far longer to reprocess than a request should stay open.
+type SessionComparer = core.SessionComparer
+
+// NewSessionComparer runs comparisons through p on goroutines.
+func NewSessionComparer(st *store.MemoryStore, p *pipeline.Pipeline, goroutines *Goroutines) *SessionComparer {
+	return core.NewSessionComparer(st, p, goroutines)
+}
+
+// ReplayHeader describes a recorded WebSocket session. URL and Header are
+// redacted the same way as debug captures.
+type ReplayHeader = core.ReplayHeader
+
+// ReplayFrame is one inbound message, OffsetMS after the connection opened.
+type ReplayFrame = core.ReplayFrame
+
+// SessionRecorder writes replay files to Dir for the WebSocket sessions an
+// operator has enabled. Users in OptOut are never recorded.
+type SessionRecorder = core.SessionRecorder
+
+// NewSessionRecorder writes to cfg.ReplayDir and never records the users in
+// cfg.DebugCaptureOptOut.
+func NewSessionRecorder(cfg audioproc.Config) *SessionRecorder {
+	return core.NewSessionRecorder(cfg)
+}
+
+// ReadReplay parses a replay file.
+func ReadReplay(r io.Reader) (ReplayHeader, []ReplayFrame, error) {
+	return core.ReadReplay(r)
+}
+
+// ReplayResult is what a replay produced.
+type ReplayResult = core.ReplayResult
+
+// ReviewQueue holds the chunks whose transcripts scored under
+// Config.ReviewThreshold and that nobody has reviewed yet. It follows the
+// store's writes, so chunks leave it when they are reviewed, trashed or
+// reprocessed to a better score, and come back when restored.
+type ReviewQueue = core.ReviewQueue
+
+// NewReviewQueue queues store's low-confidence chunks and follows its
+// writes from then on. It returns nil, which queues nothing, when
+// cfg.ReviewThreshold is 0.
+func NewReviewQueue(cfg audioproc.Config, st *store.MemoryStore) *ReviewQueue {
+	return core.NewReviewQueue(cfg, st)
+}
+
+// ReviewQueuePage is a response of GET /review/queue. Pending counts every
+// chunk waiting for review in the requested scope, not just those listed.
+type ReviewQueuePage = core.ReviewQueuePage
+
+// SessionSummary describes a session for listings.
+type SessionSummary = core.SessionSummary
+
+// SessionEvent reports a session opening or closing.
+type SessionEvent = core.SessionEvent
+
+// SessionTracker records activity per user session and closes sessions that
+// go quiet for longer than the idle timeout. A chunk for a closed session
+// either starts a new revision of it or, in strict mode, is rejected.
+type SessionTracker = core.SessionTracker
+
+// NewSessionTracker closes sessions idle for cfg.SessionIdleTimeout, and
+// splits them into cfg.SessionShards shards.
+func NewSessionTracker(cfg audioproc.Config, clock store.Clock) *SessionTracker {
+	return core.NewSessionTracker(cfg, clock)
+}
+
+// RunSessionSweeper closes idle sessions every interval until ctx ends.
+// Sessions are left open while ro is on, since closing one writes.
+func RunSessionSweeper(ctx context.Context, t *SessionTracker, ro *store.ReadOnly, interval time.Duration) {
+	core.RunSessionSweeper(ctx, t, ro, interval)
+}
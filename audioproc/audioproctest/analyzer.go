@@ -0,0 +1,69 @@
+package audioproctest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+)
+
+// AnalysisResponse is one scripted analysis, returned after Latency unless
+// the context ends first.
+type AnalysisResponse struct {
+	pipeline.Analysis
+	Latency time.Duration
+	Err     error
+}
+
+// FakeAnalyzer is a scripted pipeline.Analyzer. Chunks whose checksum has
+// no script get Default.
+type FakeAnalyzer struct {
+	Default AnalysisResponse
+
+	mu     sync.Mutex
+	script script[AnalysisResponse]
+	calls  []pipeline.AudioChunk
+}
+
+var _ pipeline.Analyzer = (*FakeAnalyzer)(nil)
+
+// NewFakeAnalyzer returns a FakeAnalyzer that measures every chunk as a
+// until scripted otherwise.
+func NewFakeAnalyzer(a pipeline.Analysis) *FakeAnalyzer {
+	return &FakeAnalyzer{Default: AnalysisResponse{Analysis: a}}
+}
+
+// Script queues responses for the chunk with checksum, used as
+// FakeTranscriber.Script's are.
+func (f *FakeAnalyzer) Script(checksum string, responses ...AnalysisResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script.add(checksum, responses)
+}
+
+// Analyze answers with the next scripted response for chunk.
+func (f *FakeAnalyzer) Analyze(ctx context.Context, chunk pipeline.AudioChunk) (pipeline.Analysis, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, chunk)
+	resp, ok := f.script.next(chunkChecksum(chunk))
+	if !ok {
+		resp = f.Default
+	}
+	f.mu.Unlock()
+
+	if err := wait(ctx, resp.Latency); err != nil {
+		return pipeline.Analysis{}, err
+	}
+	if resp.Err != nil {
+		return pipeline.Analysis{}, resp.Err
+	}
+	return resp.Analysis, nil
+}
+
+// Calls returns the chunks analysed so far, in call order.
+func (f *FakeAnalyzer) Calls() []pipeline.AudioChunk {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]pipeline.AudioChunk(nil), f.calls...)
+}
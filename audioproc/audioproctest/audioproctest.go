@@ -0,0 +1,83 @@
+// Package audioproctest provides deterministic test doubles for programs
+// that embed audioproc: a scripted FakeTranscriber and FakeAnalyzer for the
+// Pipeline, a RecordingStore that captures every call made to a Store, and
+// FakeClock.
+//
+// Fakes answer by chunk checksum, the hex SHA-256 of the audio as it was
+// received; Checksum computes it for a fixture. Every fake is safe for
+// concurrent use by the pipeline's workers.
+package audioproctest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// FakeClock is the store's manually advanced Clock, re-exported so tests
+// need only this package.
+type FakeClock = store.FakeClock
+
+// NewFakeClock returns a FakeClock stopped at start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return store.NewFakeClock(start)
+}
+
+// Checksum returns the checksum the fakes key their scripts on.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkChecksum is the checksum chunk arrived with, or its data's when it
+// has none, as when a test calls a fake directly.
+func chunkChecksum(chunk pipeline.AudioChunk) string {
+	if chunk.Checksum != "" {
+		return chunk.Checksum
+	}
+	return Checksum(chunk.Data)
+}
+
+// wait sleeps for d, returning early with ctx's error if it ends first.
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// script holds responses queued per checksum. Each checksum's responses
+// are used in order and the last one keeps answering.
+type script[R any] struct {
+	queued map[string][]R
+}
+
+func (s *script[R]) add(checksum string, responses []R) {
+	if s.queued == nil {
+		s.queued = make(map[string][]R)
+	}
+	s.queued[checksum] = append(s.queued[checksum], responses...)
+}
+
+func (s *script[R]) next(checksum string) (R, bool) {
+	q := s.queued[checksum]
+	if len(q) == 0 {
+		var zero R
+		return zero, false
+	}
+	if len(q) > 1 {
+		s.queued[checksum] = q[1:]
+	}
+	return q[0], true
+}
@@ -0,0 +1,83 @@
+package audioproctest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+func TestFakeTranscriberScriptOrder(t *testing.T) {
+	fake := NewFakeTranscriber("default")
+	fake.Script("sum", Response{Text: "first"}, Response{Text: "second"})
+	chunk := pipeline.AudioChunk{Checksum: "sum"}
+	for _, want := range []string{"first", "second", "second"} {
+		if got, err := fake.Transcribe(context.Background(), chunk); err != nil || got.Text != want {
+			t.Errorf("Expected %q, but got %+v %v", want, got, err)
+		}
+	}
+	if got, _ := fake.Transcribe(context.Background(), pipeline.AudioChunk{Checksum: "other"}); got.Text != "default" {
+		t.Errorf("Expected the default for an unscripted chunk, but got %+v", got)
+	}
+	if n := len(fake.Calls()); n != 4 {
+		t.Errorf("Expected 4 recorded calls, but got %d", n)
+	}
+}
+
+func TestFakeTranscriberLatencyHonoursContext(t *testing.T) {
+	fake := NewFakeTranscriber("")
+	fake.Default = Response{Text: "late", Partials: []string{"la"}, Latency: time.Minute}
+	var partials []string
+	fake.OnPartial = func(chunk pipeline.AudioChunk, text string) { partials = append(partials, text) }
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := fake.Transcribe(ctx, pipeline.AudioChunk{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline as the error, but got %v", err)
+	}
+	if time.Since(start) > time.Second || len(partials) != 0 {
+		t.Errorf("Expected to give up at the deadline before any partial, but got %v", partials)
+	}
+}
+
+func TestFakeAnalyzerError(t *testing.T) {
+	fake := NewFakeAnalyzer(pipeline.Analysis{FFT: "440Hz"})
+	data := []byte("audio")
+	fake.Script(Checksum(data), AnalysisResponse{Err: errors.New("boom")})
+	if _, err := fake.Analyze(context.Background(), pipeline.AudioChunk{Data: data}); err == nil {
+		t.Errorf("Expected the scripted error")
+	}
+	if a, err := fake.Analyze(context.Background(), pipeline.AudioChunk{Data: []byte("other")}); err != nil || a.FFT != "440Hz" {
+		t.Errorf("Expected the default analysis, but got %+v %v", a, err)
+	}
+}
+
+func TestRecordingStoreTransactions(t *testing.T) {
+	rec := NewRecordingStore(store.NewMemoryStore())
+	fail := errors.New("abort")
+	err := rec.WithTx(func(tx store.Store) error {
+		tx.Save(store.Metadata{ChunkID: "c1"})
+		return fail
+	})
+	if !errors.Is(err, fail) {
+		t.Fatalf("Expected the transaction's error, but got %v", err)
+	}
+	calls := rec.Calls()
+	if len(calls) != 2 || calls[0].Method != "Save" || calls[1].Method != "WithTx" || !errors.Is(calls[1].Err, fail) {
+		t.Errorf("Expected the save then the failed transaction, but got %+v", calls)
+	}
+	if _, err := rec.Get("c1"); !errors.Is(err, audioproc.ErrNotFound) {
+		t.Errorf("Expected the save to be rolled back, but got %v", err)
+	}
+	if got := rec.CallsTo("Get"); len(got) != 1 || !errors.Is(got[0].Err, audioproc.ErrNotFound) {
+		t.Errorf("Expected the failed Get to be recorded, but got %+v", got)
+	}
+	rec.Reset()
+	if len(rec.Calls()) != 0 {
+		t.Errorf("Expected Reset to forget the calls")
+	}
+}
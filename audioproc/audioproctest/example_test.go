@@ -0,0 +1,78 @@
+package audioproctest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/audioproctest"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// Script a transcript for one fixture and a failure for another.
+func ExampleFakeTranscriber() {
+	hello := pipeline.SineWAV(440, time.Second, 8000)
+	broken := pipeline.SineWAV(880, time.Second, 8000)
+	fake := audioproctest.NewFakeTranscriber("(default)")
+	fake.Script(audioproctest.Checksum(hello), audioproctest.Response{Text: "hello there"})
+	fake.Script(audioproctest.Checksum(broken), audioproctest.Response{Err: errors.New("engine down")})
+
+	p := pipeline.NewPipeline(audioproc.DefaultConfig(), fake, func() int { return 0 })
+	for _, data := range [][]byte{hello, broken, pipeline.SineWAV(220, time.Second, 8000)} {
+		meta := p.Process(context.Background(), pipeline.AudioChunk{ChunkID: "c1", UserID: "user1", Data: data})
+		fmt.Printf("%s %q\n", meta.Status, meta.Transcript)
+	}
+	fmt.Println(len(fake.Calls()))
+	// Output:
+	// processed "hello there"
+	// failed ""
+	// processed "(default)"
+	// 3
+}
+
+// Stream partial transcripts before the final one.
+func ExampleFakeTranscriber_partials() {
+	data := pipeline.SineWAV(440, time.Second, 8000)
+	fake := audioproctest.NewFakeTranscriber("")
+	fake.Script(audioproctest.Checksum(data), audioproctest.Response{
+		Partials: []string{"turn", "turn off the"},
+		Text:     "turn off the lights",
+	})
+	fake.OnPartial = func(chunk pipeline.AudioChunk, text string) {
+		fmt.Println("partial:", text)
+	}
+	t, _ := fake.Transcribe(context.Background(), pipeline.AudioChunk{Data: data})
+	fmt.Println("final:", t.Text)
+	// Output:
+	// partial: turn
+	// partial: turn off the
+	// final: turn off the lights
+}
+
+// Replace the built-in analysis with fixed measurements.
+func ExampleFakeAnalyzer() {
+	p := pipeline.NewPipeline(audioproc.DefaultConfig(), audioproctest.NewFakeTranscriber("hi"), func() int { return 0 })
+	p.Analyzer = audioproctest.NewFakeAnalyzer(pipeline.Analysis{DurationMS: 2500, FFT: "440Hz"})
+	meta := p.Process(context.Background(), pipeline.AudioChunk{ChunkID: "c1", UserID: "user1", Data: []byte("not audio")})
+	fmt.Println(meta.DurationMS, meta.FFT, meta.Transcript)
+	// Output: 2500 440Hz hi
+}
+
+// Assert on what a component did to its store.
+func ExampleRecordingStore() {
+	rec := audioproctest.NewRecordingStore(store.NewMemoryStore())
+	reader := store.NewCachedReader(rec, 10, time.Minute)
+	rec.Save(store.Metadata{ChunkID: "c1", UserID: "user1"})
+	for i := 0; i < 3; i++ {
+		reader.Get("c1")
+	}
+	for _, c := range rec.Calls() {
+		fmt.Println(c.Method, c.ID)
+	}
+	// Output:
+	// Save c1
+	// Get c1
+}
@@ -0,0 +1,134 @@
+package audioproctest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/audioproctest"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// These tests drive the pipeline and handlers through the audioproctest
+// fakes only, as a program embedding the service would.
+
+func TestPipelineWithFakeAnalyzer(t *testing.T) {
+	cfg := audioproc.DefaultConfig()
+	cfg.SkipAnomalousTranscription = true
+	fake := audioproctest.NewFakeTranscriber("hello")
+	p := pipeline.NewPipeline(cfg, fake, func() int { return 0 })
+	analyzer := audioproctest.NewFakeAnalyzer(pipeline.Analysis{DurationMS: 1500, FFT: "220Hz", Fingerprint: "abc"})
+	p.Analyzer = analyzer
+
+	silent := []byte("silence")
+	analyzer.Script(audioproctest.Checksum(silent), audioproctest.AnalysisResponse{Analysis: pipeline.Analysis{Anomalies: []string{"silent"}}})
+	broken := []byte("broken")
+	analyzer.Script(audioproctest.Checksum(broken), audioproctest.AnalysisResponse{Err: errors.New("decoder crashed")})
+
+	process := func(data []byte) store.Metadata {
+		return p.Process(context.Background(), pipeline.AudioChunk{ChunkID: "c1", UserID: "user1", Data: data})
+	}
+	if meta := process([]byte("speech")); meta.DurationMS != 1500 || meta.FFT != "220Hz" || meta.Fingerprint != "abc" || meta.Transcript != "hello" {
+		t.Errorf("Expected the fake's analysis and transcript, but got %+v", meta)
+	}
+	if meta := process(silent); meta.TranscriptSkipReason == "" || meta.Transcript != "" {
+		t.Errorf("Expected anomalies from the analyzer to skip transcription, but got %+v", meta)
+	}
+	if meta := process(broken); meta.DurationMS != 0 || meta.Transcript != "hello" {
+		t.Errorf("Expected a failed analysis to leave the fields empty, but got %+v", meta)
+	}
+	if calls := fake.Calls(); len(calls) != 2 || calls[0].Checksum != audioproctest.Checksum([]byte("speech")) {
+		t.Errorf("Expected the transcriber to see two chunks with their checksums, but got %+v", calls)
+	}
+}
+
+func TestPipelineFakeLatencyRunsPastDeadline(t *testing.T) {
+	cfg := audioproc.DefaultConfig()
+	cfg.ProcessingDeadline = 50 * time.Millisecond
+	fake := audioproctest.NewFakeTranscriber("")
+	fake.Default = audioproctest.Response{Text: "too late", Latency: time.Minute}
+	p := pipeline.NewPipeline(cfg, fake, func() int { return 0 })
+
+	meta := p.Process(context.Background(), pipeline.AudioChunk{ChunkID: "c1", UserID: "user1", Data: pipeline.SineWAV(440, 100*time.Millisecond, 8000)})
+	if meta.Status != "partial" || !slices.Contains(meta.TimedOut, "transcript") || meta.DurationMS != 100 {
+		t.Errorf("Expected a partial chunk with its analysis, but got %+v", meta)
+	}
+}
+
+func TestUnscoredTranscriptSkipsReview(t *testing.T) {
+	cfg := audioproc.DefaultConfig()
+	p := pipeline.NewPipeline(cfg, audioproctest.NewFakeTranscriber("no scores"), func() int { return 0 })
+	meta := p.Process(context.Background(), pipeline.AudioChunk{ChunkID: "c1", UserID: "user1", SessionID: "s1", Data: pipeline.SineWAV(440, 100*time.Millisecond, 8000)})
+	if meta.Transcript != "no scores" || meta.Confidence != nil {
+		t.Fatalf("Expected the transcript left unscored, but got %q %v", meta.Transcript, meta.Confidence)
+	}
+	s := store.NewMemoryStore()
+	if err := s.Save(meta); err != nil {
+		t.Fatal(err)
+	}
+	if _, pending := api.NewReviewQueue(cfg, s).Pending("", 10); pending != 0 {
+		t.Errorf("Expected nothing queued for review, but got %d", pending)
+	}
+}
+
+func TestUploadHandlerWithFakeTranscriber(t *testing.T) {
+	h := api.NewHarness(audioproc.DefaultConfig())
+	defer h.Close()
+	fake := audioproctest.NewFakeTranscriber("unscripted")
+	h.Pipeline.Transcriber = fake
+	wav := pipeline.SineWAV(440, 200*time.Millisecond, 8000)
+	fake.Script(audioproctest.Checksum(wav),
+		audioproctest.Response{Text: "first take"},
+		audioproctest.Response{Err: errors.New("engine down")},
+	)
+
+	upload := func() store.Metadata {
+		t.Helper()
+		resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(wav))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var meta store.Metadata
+		if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+			t.Fatal(err)
+		}
+		return meta
+	}
+	if meta := upload(); meta.Status != "processed" || meta.Transcript != "first take" {
+		t.Errorf("Expected the scripted transcript, but got %+v", meta)
+	}
+	failed := upload()
+	if failed.Status != "failed" {
+		t.Errorf("Expected the scripted failure, but got %+v", failed)
+	}
+	if saved, err := h.Store.Get(failed.ChunkID); err != nil || saved.Status != "failed" {
+		t.Errorf("Expected the failed chunk to be saved, but got %+v %v", saved, err)
+	}
+	calls := fake.Calls()
+	if len(calls) != 2 || calls[1].UserID != "user1" || calls[1].SessionID != "s1" {
+		t.Errorf("Expected the transcriber to see both uploads, but got %+v", calls)
+	}
+}
+
+func TestCachedReaderCoalescesStoreReads(t *testing.T) {
+	rec := audioproctest.NewRecordingStore(store.NewMemoryStore())
+	rec.Save(store.Metadata{ChunkID: "c1", UserID: "user1"})
+	reader := store.NewCachedReader(rec, 10, time.Minute)
+	reader.NegativeTTL = time.Minute
+	for i := 0; i < 5; i++ {
+		reader.Get("c1")
+		reader.Get("missing")
+	}
+	if gets := rec.CallsTo("Get"); len(gets) != 2 || !errors.Is(gets[1].Err, audioproc.ErrNotFound) {
+		t.Errorf("Expected one backend read per ID, but got %+v", gets)
+	}
+}
@@ -0,0 +1,159 @@
+package audioproctest
+
+import (
+	"sync"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// Call is one call made through a RecordingStore. ID is the chunk or user
+// ID it named, empty for List and Changes.
+type Call struct {
+	Method string
+	ID     string
+	Err    error
+}
+
+// RecordingStore wraps a Store and records every call made through it.
+// Calls made inside WithTx are recorded too, followed by a "WithTx" call
+// that carries the transaction's outcome.
+type RecordingStore struct {
+	store store.Store
+
+	mu    sync.Mutex
+	calls []Call
+	// parent is set on the recorder handed to a transaction, which
+	// records into its parent's calls.
+	parent *RecordingStore
+}
+
+var (
+	_ store.Store         = (*RecordingStore)(nil)
+	_ store.Transactional = (*RecordingStore)(nil)
+)
+
+// NewRecordingStore records the calls made to backend.
+func NewRecordingStore(backend store.Store) *RecordingStore {
+	return &RecordingStore{store: backend}
+}
+
+func (r *RecordingStore) record(method, id string, err error) {
+	root := r
+	if r.parent != nil {
+		root = r.parent
+	}
+	root.mu.Lock()
+	defer root.mu.Unlock()
+	root.calls = append(root.calls, Call{Method: method, ID: id, Err: err})
+}
+
+// Calls returns the calls recorded so far, oldest first.
+func (r *RecordingStore) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsTo returns the recorded calls to method, oldest first.
+func (r *RecordingStore) CallsTo(method string) []Call {
+	var calls []Call
+	for _, c := range r.Calls() {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls recorded so far.
+func (r *RecordingStore) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+func (r *RecordingStore) Get(id string) (store.Metadata, error) {
+	meta, err := r.store.Get(id)
+	r.record("Get", id, err)
+	return meta, err
+}
+
+func (r *RecordingStore) Save(meta store.Metadata) error {
+	err := r.store.Save(meta)
+	r.record("Save", meta.ChunkID, err)
+	return err
+}
+
+func (r *RecordingStore) Delete(id string) error {
+	err := r.store.Delete(id)
+	r.record("Delete", id, err)
+	return err
+}
+
+func (r *RecordingStore) Restore(id string) (store.Metadata, error) {
+	meta, err := r.store.Restore(id)
+	r.record("Restore", id, err)
+	return meta, err
+}
+
+func (r *RecordingStore) ListByUser(userID string) []store.Metadata {
+	metas := r.store.ListByUser(userID)
+	r.record("ListByUser", userID, nil)
+	return metas
+}
+
+func (r *RecordingStore) List(match func(store.Metadata) bool) []store.Metadata {
+	metas := r.store.List(match)
+	r.record("List", "", nil)
+	return metas
+}
+
+func (r *RecordingStore) SaveBlob(id string, data []byte) error {
+	err := r.store.SaveBlob(id, data)
+	r.record("SaveBlob", id, err)
+	return err
+}
+
+func (r *RecordingStore) GetBlob(id string) ([]byte, error) {
+	data, err := r.store.GetBlob(id)
+	r.record("GetBlob", id, err)
+	return data, err
+}
+
+func (r *RecordingStore) Changes(since int64, limit int) store.ChangePage {
+	page := r.store.Changes(since, limit)
+	r.record("Changes", "", nil)
+	return page
+}
+
+func (r *RecordingStore) UserSettings(userID string) (store.UserSettings, error) {
+	settings, err := r.store.UserSettings(userID)
+	r.record("UserSettings", userID, err)
+	return settings, err
+}
+
+func (r *RecordingStore) SaveUserSettings(userID string, settings store.UserSettings) error {
+	err := r.store.SaveUserSettings(userID, settings)
+	r.record("SaveUserSettings", userID, err)
+	return err
+}
+
+// WithTx runs fn in a transaction when the wrapped store supports them,
+// and applies fn's writes directly otherwise.
+func (r *RecordingStore) WithTx(fn func(tx store.Store) error) error {
+	root := r
+	if r.parent != nil {
+		root = r.parent
+	}
+	run := func(tx store.Store) error {
+		return fn(&RecordingStore{store: tx, parent: root})
+	}
+	var err error
+	if ts, ok := r.store.(store.Transactional); ok {
+		err = ts.WithTx(run)
+	} else {
+		err = run(r.store)
+	}
+	r.record("WithTx", "", err)
+	return err
+}
@@ -0,0 +1,86 @@
+package audioproctest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+)
+
+// Response is one scripted transcription. Partials are reported through
+// FakeTranscriber.OnPartial, in order, before the final transcript.
+// Latency is spread evenly over the partials and the final transcript, and
+// a context that ends during it is returned as the error.
+type Response struct {
+	Text     string
+	Words    []pipeline.Word
+	Partials []string
+	Latency  time.Duration
+	Err      error
+}
+
+// FakeTranscriber is a scripted pipeline.Transcriber. Chunks whose
+// checksum has no script get Default.
+type FakeTranscriber struct {
+	Default Response
+	// OnPartial, when set, receives each partial transcript as it is
+	// produced.
+	OnPartial func(chunk pipeline.AudioChunk, text string)
+
+	mu     sync.Mutex
+	script script[Response]
+	calls  []pipeline.AudioChunk
+}
+
+var _ pipeline.Transcriber = (*FakeTranscriber)(nil)
+
+// NewFakeTranscriber returns a FakeTranscriber that transcribes every
+// chunk as text until scripted otherwise.
+func NewFakeTranscriber(text string) *FakeTranscriber {
+	return &FakeTranscriber{Default: Response{Text: text}}
+}
+
+// Script queues responses for the chunk with checksum. They are used one
+// per call, and the last keeps answering once the others are used up.
+func (f *FakeTranscriber) Script(checksum string, responses ...Response) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script.add(checksum, responses)
+}
+
+// Transcribe answers with the next scripted response for chunk.
+func (f *FakeTranscriber) Transcribe(ctx context.Context, chunk pipeline.AudioChunk) (pipeline.Transcription, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, chunk)
+	resp, ok := f.script.next(chunkChecksum(chunk))
+	if !ok {
+		resp = f.Default
+	}
+	onPartial := f.OnPartial
+	f.mu.Unlock()
+
+	step := resp.Latency / time.Duration(len(resp.Partials)+1)
+	for _, p := range resp.Partials {
+		if err := wait(ctx, step); err != nil {
+			return pipeline.Transcription{}, err
+		}
+		if onPartial != nil {
+			onPartial(chunk, p)
+		}
+	}
+	if err := wait(ctx, step); err != nil {
+		return pipeline.Transcription{}, err
+	}
+	if resp.Err != nil {
+		return pipeline.Transcription{}, resp.Err
+	}
+	return pipeline.Transcription{Text: resp.Text, Words: resp.Words}, nil
+}
+
+// Calls returns the chunks transcribed so far, in call order.
+func (f *FakeTranscriber) Calls() []pipeline.AudioChunk {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]pipeline.AudioChunk(nil), f.calls...)
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// CapabilitiesPath is where a server describes its features, limits and
+// formats.
+const CapabilitiesPath = "/capabilities"
+
+// Capabilities is what a server reports it offers. Features maps each
+// optional feature to whether it is on; limits of zero are unlimited.
+type Capabilities struct {
+	APIVersion string          `json:"api_version"`
+	Features   map[string]bool `json:"features"`
+	Limits     struct {
+		MaxChunkDurationMS     int64          `json:"max_chunk_duration_ms"`
+		MaxAudioDurationMS     int64          `json:"max_audio_duration_ms"`
+		MinUploadBytes         int            `json:"min_upload_bytes"`
+		MaxClientMetadataBytes int            `json:"max_client_metadata_bytes"`
+		MaxConnectBodyBytes    int64          `json:"max_connect_body_bytes"`
+		MaxConcurrentRequests  int            `json:"max_concurrent_requests"`
+		RouteConcurrency       map[string]int `json:"route_concurrency"`
+		MaxStreams             int            `json:"max_streams"`
+		QuotaBytes             int64          `json:"quota_bytes"`
+		QuotaChunks            int            `json:"quota_chunks"`
+		UploadTimeoutMS        int64          `json:"upload_timeout_ms"`
+	} `json:"limits"`
+	Formats struct {
+		Upload []string `json:"upload"`
+		// Download maps the formats audio can be downloaded in to the
+		// bitrates offered, in kbps.
+		Download    map[string][]int `json:"download"`
+		Transcripts []string         `json:"transcripts"`
+	} `json:"formats"`
+}
+
+// Has reports whether the server has feature turned on. Features the
+// server does not know of are off.
+func (c *Capabilities) Has(feature string) bool {
+	return c != nil && c.Features[feature]
+}
+
+// FetchCapabilities downloads the capability document at capabilitiesURL.
+func FetchCapabilities(ctx context.Context, httpClient *http.Client, capabilitiesURL string) (Capabilities, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, capabilitiesURL, nil)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Capabilities{}, fmt.Errorf("client: fetching capabilities: %s", resp.Status)
+	}
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return Capabilities{}, fmt.Errorf("client: fetching capabilities: %w", err)
+	}
+	return caps, nil
+}
+
+// CapabilitiesURLFor returns where the server behind a ws:// or wss://
+// endpoint describes its capabilities.
+func CapabilitiesURLFor(wsURL string) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path, u.RawQuery = CapabilitiesPath, ""
+	return u.String(), nil
+}
+
+// fetchCapabilities replaces c.Capabilities with those of the server at
+// c.URL.
+func (c *Client) fetchCapabilities(ctx context.Context) error {
+	capsURL := c.CapabilitiesURL
+	if capsURL == "" {
+		var err error
+		if capsURL, err = CapabilitiesURLFor(c.URL); err != nil {
+			return err
+		}
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	caps, err := FetchCapabilities(ctx, httpClient, capsURL)
+	if err != nil {
+		return err
+	}
+	c.Capabilities = &caps
+	return nil
+}
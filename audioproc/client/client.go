@@ -0,0 +1,292 @@
+// Package client streams audio chunks to an audio-processor server over
+// WebSocket and recovers from dropped connections without losing or
+// duplicating chunks.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/receipt"
+	"github.com/Kundhavi2798/audio-processor/validate"
+	"github.com/gorilla/websocket"
+)
+
+// Ack is the server's reply to a chunk. Duplicate is set when the server had
+// already acknowledged the seq and did not process it again. Index is the
+// chunk's position in the session, which is its seq. Receipt is
+// set when the server signs receipts, and has been verified against the
+// chunk sent.
+type Ack struct {
+	Type      string `json:"type"`
+	Ack       bool   `json:"ack"`
+	Seq       int64  `json:"seq"`
+	ChunkID   string `json:"chunk_id"`
+	Index     int64  `json:"index"`
+	Duplicate bool   `json:"duplicate"`
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+
+	Receipt *receipt.Receipt `json:"receipt,omitempty"`
+}
+
+type envelope struct {
+	Type      string `json:"type"`
+	Data      []byte `json:"data,omitempty"`
+	Seq       int64  `json:"seq,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Resume    bool   `json:"resume,omitempty"`
+}
+
+type hello struct {
+	Type      string `json:"type"`
+	SessionID string `json:"session_id"`
+	LastSeq   int64  `json:"last_seq"`
+	// Count is set on observer updates.
+	Count int `json:"count"`
+}
+
+// frame is any frame the server sends while a chunk is in flight; Count is
+// set on observer updates.
+type frame struct {
+	Ack
+	Count int `json:"count"`
+}
+
+// Client sends chunks for one session. Chunks are numbered from 1 and kept
+// until acked, so Resume can retransmit whatever the server never saw.
+type Client struct {
+	// URL is the server's ws:// or wss:// /ws endpoint; Resume reads it
+	// again, so it may be pointed at a new address between connections.
+	URL       string
+	UserID    string
+	SessionID string
+	Dialer    *websocket.Dialer
+	// RetryInterval paces reconnection attempts after the server drains.
+	RetryInterval time.Duration
+	// ProbeCount is how many probes MeasureLatency sends.
+	ProbeCount int
+	// KeysURL is where receipt keys are fetched from; by default the
+	// well-known path on URL's host. HTTPClient fetches them.
+	KeysURL    string
+	HTTPClient *http.Client
+	// Capabilities are those of the server last connected to, fetched
+	// from CapabilitiesURL, by default the well-known path on URL's host.
+	// They are nil when the server does not publish them.
+	Capabilities    *Capabilities
+	CapabilitiesURL string
+	// Observers is how many observers the server last said are listening
+	// to the session. Updates are read along with acks, so it is only as
+	// fresh as the last chunk sent.
+	Observers int
+
+	conn     *websocket.Conn
+	nextSeq  int64
+	pending  map[int64][]byte
+	draining bool
+	keys     *receipt.KeySet
+}
+
+// errDraining means the server refused a chunk because it is shutting down.
+var errDraining = errors.New("client: server is draining")
+
+// Dial connects and resumes the session, so a new Client continues
+// numbering after the last chunk the server acknowledged. IDs that break
+// validate.DefaultRules are rejected with validate.Errors before dialing.
+func Dial(ctx context.Context, rawURL, userID, sessionID string) (*Client, error) {
+	if err := validate.DefaultRules().IDs(userID, sessionID); err != nil {
+		return nil, err
+	}
+	c := &Client{
+		URL:       rawURL,
+		UserID:    userID,
+		SessionID: sessionID,
+		Dialer:    websocket.DefaultDialer,
+		nextSeq:   1,
+		pending:   make(map[int64][]byte),
+
+		RetryInterval: 100 * time.Millisecond,
+		ProbeCount:    5,
+	}
+	if _, err := c.Resume(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Send transmits one chunk and waits for its ack. If the connection fails
+// the chunk stays pending and is retransmitted by Resume.
+//
+// Once the server announces it is draining, Send finishes the chunk in
+// flight and then moves to a new connection, retrying the dial until a
+// replacement server accepts it or ctx is done.
+func (c *Client) Send(ctx context.Context, data []byte) (Ack, error) {
+	seq := c.nextSeq
+	c.nextSeq++
+	c.pending[seq] = data
+	if c.draining {
+		return c.reconnect(ctx, seq)
+	}
+	ack, err := c.send(ctx, seq)
+	var closeErr *websocket.CloseError
+	if errors.Is(err, errDraining) || errors.As(err, &closeErr) && closeErr.Code == websocket.CloseGoingAway {
+		return c.reconnect(ctx, seq)
+	}
+	return ack, err
+}
+
+// reconnect leaves a draining server and resumes elsewhere, which
+// retransmits seq along with anything else pending.
+func (c *Client) reconnect(ctx context.Context, seq int64) (Ack, error) {
+	c.Close()
+	c.conn = nil
+	for {
+		acks, err := c.Resume(ctx)
+		if err == nil {
+			for _, ack := range acks {
+				if ack.Seq == seq {
+					return ack, nil
+				}
+			}
+			// The old server acked seq before it went away.
+			return Ack{Ack: true, Seq: seq, Duplicate: true}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return Ack{}, err
+		case <-time.After(c.RetryInterval):
+		}
+	}
+}
+
+func (c *Client) send(ctx context.Context, seq int64) (Ack, error) {
+	if c.conn == nil {
+		return Ack{}, errors.New("client: not connected")
+	}
+	if d, ok := ctx.Deadline(); ok {
+		c.conn.SetReadDeadline(d)
+		c.conn.SetWriteDeadline(d)
+	}
+	if err := c.conn.WriteJSON(envelope{Type: "chunk", Data: c.pending[seq], Seq: seq}); err != nil {
+		return Ack{}, err
+	}
+	var f frame
+	for {
+		if err := c.conn.ReadJSON(&f); err != nil {
+			return Ack{}, err
+		}
+		// Probe replies and observer updates can arrive while a chunk is
+		// in flight.
+		if f.Type == "draining" {
+			c.draining = true
+		} else if f.Type == "observer_update" {
+			c.Observers = f.Count
+		} else if f.Type != "probe" {
+			break
+		}
+		f = frame{}
+	}
+	ack := f.Ack
+	if ack.Error == "draining" {
+		c.draining = true
+		return ack, errDraining
+	}
+	if !ack.Ack {
+		return ack, fmt.Errorf("client: chunk %d rejected: %s: %s", seq, ack.Error, ack.Message)
+	}
+	data := c.pending[seq]
+	delete(c.pending, seq)
+	if ack.Receipt != nil {
+		// The chunk was accepted, so it is not resent, but a receipt that
+		// does not verify proves nothing and is reported.
+		if err := c.verifyReceipt(ctx, *ack.Receipt, data); err != nil {
+			return ack, err
+		}
+	}
+	return ack, nil
+}
+
+// Resume (re)connects to URL, fetches the server's capabilities, learns the
+// last seq the server acknowledged, drops pending chunks up to it and
+// retransmits the rest in order.
+func (c *Client) Resume(ctx context.Context) ([]Ack, error) {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("user_id", c.UserID)
+	q.Set("session_id", c.SessionID)
+	u.RawQuery = q.Encode()
+
+	conn, _, err := c.Dialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.WriteJSON(envelope{Type: "hello", SessionID: c.SessionID, Resume: true}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var h hello
+	for {
+		if err := conn.ReadJSON(&h); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		// The session's observer count can come before the reply.
+		if h.Type != "observer_update" {
+			break
+		}
+		c.Observers = h.Count
+		h = hello{}
+	}
+	c.conn = conn
+	c.draining = false
+	if err := c.fetchCapabilities(ctx); err != nil {
+		// Capabilities are advisory; an older server has none to offer.
+		c.Capabilities = nil
+	}
+
+	if h.LastSeq >= c.nextSeq {
+		c.nextSeq = h.LastSeq + 1
+	}
+	var retry []int64
+	for seq := range c.pending {
+		if seq <= h.LastSeq {
+			delete(c.pending, seq)
+		} else {
+			retry = append(retry, seq)
+		}
+	}
+	sort.Slice(retry, func(i, j int) bool { return retry[i] < retry[j] })
+	var acks []Ack
+	for _, seq := range retry {
+		ack, err := c.send(ctx, seq)
+		if err != nil {
+			return acks, err
+		}
+		acks = append(acks, ack)
+	}
+	return acks, nil
+}
+
+// Pending reports how many chunks have been sent but not acknowledged.
+func (c *Client) Pending() int {
+	return len(c.pending)
+}
+
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ProbeSample is one probe's round trip: sent and received by the client,
+// received and answered by the server, each by its own clock.
+type ProbeSample struct {
+	ClientSent     time.Time
+	ServerReceived time.Time
+	ServerSent     time.Time
+	ClientReceived time.Time
+}
+
+// RTT is the time the probe spent on the network, leaving out the time the
+// server held it.
+func (s ProbeSample) RTT() time.Duration {
+	return s.ClientReceived.Sub(s.ClientSent) - s.ServerSent.Sub(s.ServerReceived)
+}
+
+// Offset is how far the server's clock is ahead of the client's, assuming
+// the probe took as long to arrive as its reply.
+func (s ProbeSample) Offset() time.Duration {
+	return (s.ServerReceived.Sub(s.ClientSent) + s.ServerSent.Sub(s.ClientReceived)) / 2
+}
+
+// Latency summarises a burst of probes. QueueDepth is how many of this
+// connection's frames the server had yet to answer at the last probe.
+type Latency struct {
+	RTT        time.Duration
+	Offset     time.Duration
+	Samples    int
+	QueueDepth int
+}
+
+// EstimateLatency takes the RTT and offset of the fastest sample, the one
+// least inflated by queueing on the way.
+func EstimateLatency(samples []ProbeSample) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	best := samples[0]
+	for _, s := range samples[1:] {
+		if s.RTT() < best.RTT() {
+			best = s
+		}
+	}
+	return Latency{RTT: best.RTT(), Offset: best.Offset(), Samples: len(samples)}
+}
+
+type probe struct {
+	Type     string `json:"type"`
+	ClientTS int64  `json:"client_ts"`
+}
+
+type probeReply struct {
+	Type         string `json:"type"`
+	ClientTS     int64  `json:"client_ts"`
+	ServerRecvTS int64  `json:"server_recv_ts"`
+	ServerSendTS int64  `json:"server_send_ts"`
+	QueueDepth   int    `json:"queue_depth"`
+	// Count is set on observer updates.
+	Count int `json:"count"`
+}
+
+// MeasureLatency sends ProbeCount probes one after another and estimates
+// the round trip and clock offset from their replies. Probes do not touch
+// the session, so it can be called between any two Sends.
+func (c *Client) MeasureLatency(ctx context.Context) (Latency, error) {
+	if c.conn == nil {
+		return Latency{}, errors.New("client: not connected")
+	}
+	if d, ok := ctx.Deadline(); ok {
+		c.conn.SetReadDeadline(d)
+		c.conn.SetWriteDeadline(d)
+	}
+	n := c.ProbeCount
+	if n <= 0 {
+		n = 1
+	}
+	samples := make([]ProbeSample, 0, n)
+	var depth int
+	for i := 0; i < n; i++ {
+		sent := time.Now()
+		if err := c.conn.WriteJSON(probe{Type: "probe", ClientTS: sent.UnixMicro()}); err != nil {
+			return Latency{}, err
+		}
+		var reply probeReply
+		for {
+			if err := c.conn.ReadJSON(&reply); err != nil {
+				return Latency{}, err
+			}
+			if reply.Type == "draining" {
+				c.draining = true
+			}
+			if reply.Type == "observer_update" {
+				c.Observers = reply.Count
+			}
+			// Replies to probes from an earlier, abandoned burst are skipped.
+			if reply.Type == "probe" && reply.ClientTS == sent.UnixMicro() {
+				break
+			}
+			reply = probeReply{}
+		}
+		samples = append(samples, ProbeSample{
+			ClientSent:     sent,
+			ServerReceived: time.UnixMicro(reply.ServerRecvTS),
+			ServerSent:     time.UnixMicro(reply.ServerSendTS),
+			ClientReceived: time.Now(),
+		})
+		depth = reply.QueueDepth
+	}
+	l := EstimateLatency(samples)
+	l.QueueDepth = depth
+	return l, nil
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+// FetchKeys downloads the receipt keys a server publishes at keysURL.
+func FetchKeys(ctx context.Context, httpClient *http.Client, keysURL string) (receipt.KeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keysURL, nil)
+	if err != nil {
+		return receipt.KeySet{}, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return receipt.KeySet{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return receipt.KeySet{}, fmt.Errorf("client: fetching receipt keys: %s", resp.Status)
+	}
+	var keys receipt.KeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return receipt.KeySet{}, fmt.Errorf("client: fetching receipt keys: %w", err)
+	}
+	return keys, nil
+}
+
+// KeysURLFor returns where the server behind a ws:// or wss:// endpoint
+// publishes its receipt keys.
+func KeysURLFor(wsURL string) (string, error) {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	}
+	u.Path, u.RawQuery = receipt.KeysPath, ""
+	return u.String(), nil
+}
+
+// verifyReceipt checks that r is signed by the server and covers data. The
+// keys are fetched once and again when r names a key not yet seen, which
+// is how a rotation reaches a long-lived client.
+func (c *Client) verifyReceipt(ctx context.Context, r receipt.Receipt, data []byte) error {
+	if c.keys != nil {
+		err := c.keys.VerifyData(r, data)
+		if !errors.Is(err, receipt.ErrUnknownKey) {
+			return wrapReceiptErr(r, err)
+		}
+	}
+	keysURL := c.KeysURL
+	if keysURL == "" {
+		var err error
+		if keysURL, err = KeysURLFor(c.URL); err != nil {
+			return err
+		}
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	keys, err := FetchKeys(ctx, httpClient, keysURL)
+	if err != nil {
+		return err
+	}
+	c.keys = &keys
+	return wrapReceiptErr(r, keys.VerifyData(r, data))
+}
+
+func wrapReceiptErr(r receipt.Receipt, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("client: receipt for chunk %s: %w", r.ChunkID, err)
+}
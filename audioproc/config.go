@@ -0,0 +1,20 @@
+package audioproc
+
+import (
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// Config holds every server option. Start from DefaultConfig or LoadConfig
+// and override fields before calling New.
+type Config = core.Config
+
+// DefaultConfig returns the settings used when no AUDIO_* variable
+// overrides them.
+func DefaultConfig() Config {
+	return core.DefaultConfig()
+}
+
+// LoadConfig starts from DefaultConfig and applies AUDIO_* environment overrides.
+func LoadConfig() Config {
+	return core.LoadConfig()
+}
@@ -0,0 +1,18 @@
+// Package audioproc ingests audio chunks over HTTP, WebSocket and MQTT,
+// analyses and transcribes them, and stores their metadata and audio.
+//
+// This package holds what every part of the service shares: Config, with
+// DefaultConfig and LoadConfig, and the error kinds stores and handlers
+// return. The service itself is split by layer:
+//
+//   - store keeps chunk metadata and audio: MemoryStore, its write-ahead
+//     log, and the Store interface other backends implement.
+//   - pipeline turns an AudioChunk into Metadata: analysis, transcription
+//     and the dispatcher that feeds workers.
+//   - api serves it all: New wires a store and a pipeline into a Server
+//     whose Handler can be mounted in another program or served with Run.
+//
+// Package client streams audio to a running server, and package
+// audioproctest has test doubles for the Transcriber, Analyzer and Store.
+// The server binary is cmd/server.
+package audioproc
@@ -0,0 +1,28 @@
+package audioproc
+
+import (
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// Errors returned by stores, the pipeline and services. Wrap them with %w
+// to add detail; the api package maps them to HTTP statuses.
+var (
+	ErrNotFound           = core.ErrNotFound
+	ErrConflict           = core.ErrConflict
+	ErrGone               = core.ErrGone
+	ErrForbidden          = core.ErrForbidden
+	ErrQuotaExceeded      = core.ErrQuotaExceeded
+	ErrBackendUnavailable = core.ErrBackendUnavailable
+	ErrReadOnly           = core.ErrReadOnly
+	ErrDeadlineExceeded   = core.ErrDeadlineExceeded
+	ErrNotAcceptable      = core.ErrNotAcceptable
+	ErrResourceExhausted  = core.ErrResourceExhausted
+)
+
+// FieldError is one invalid field of a ValidationError.
+type FieldError = core.FieldError
+
+// ValidationError reports every invalid field of a request. Invalid request
+// parameters are a 400; anything else is a 422. Details, when set, is sent
+// alongside the fields to explain what was found.
+type ValidationError = core.ValidationError
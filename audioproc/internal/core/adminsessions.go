@@ -0,0 +1,197 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxAdminSessionsLimit = 1000
+
+// AdminSession is one session in the cross-user listing of
+// GET /admin/sessions.
+type AdminSession struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	Chunks    int    `json:"chunks"`
+	// Bytes is the audio stored for the session, at its archived size
+	// for chunks that have been archived.
+	Bytes        int64     `json:"bytes"`
+	LastActivity time.Time `json:"last_activity"`
+	// Live is set while a WebSocket is streaming into the session, and
+	// Observers counts the observers attached to it.
+	Live      bool `json:"live"`
+	Observers int  `json:"observers"`
+}
+
+// AdminSessionPage is a page of GET /admin/sessions. NextCursor is empty
+// on the last page.
+type AdminSessionPage struct {
+	Sessions   []AdminSession `json:"sessions"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// sessionActivity returns a summary of every session with visible chunks,
+// of userID's sessions if it is set, from the session index. Scans stand
+// in while the indexes are not trusted.
+func (s *MemoryStore) sessionActivity(userID string) map[string]*AdminSession {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]*AdminSession)
+	add := func(m Metadata) {
+		if m.DeletedAt != nil || (userID != "" && m.UserID != userID) {
+			return
+		}
+		key := sessionKey(m.UserID, m.SessionID)
+		a := out[key]
+		if a == nil {
+			a = &AdminSession{UserID: m.UserID, SessionID: m.SessionID}
+			out[key] = a
+		}
+		a.Chunks++
+		if data, ok := s.blobs[m.ChunkID]; ok {
+			a.Bytes += int64(len(data))
+		} else if m.Archive != nil {
+			a.Bytes += m.Archive.Size
+		}
+		if m.Timestamp.After(a.LastActivity) {
+			a.LastActivity = m.Timestamp
+		}
+	}
+	if !s.index.trusted {
+		s.eachLocked(add)
+		return out
+	}
+	for key, ids := range s.index.live.bySession {
+		if userID != "" && !strings.HasPrefix(key, userID+"/") {
+			continue
+		}
+		for id := range ids {
+			if m, ok := s.lookupLocked(id); ok {
+				add(m)
+			}
+		}
+	}
+	return out
+}
+
+// live returns the sessions WebSockets are streaming into, with when the
+// earliest of them attached.
+func (t *wsConns) live() map[string]time.Time {
+	out := make(map[string]time.Time)
+	t.each(func(c *wsConn) {
+		key, since := c.attachment()
+		if at, ok := out[key]; !ok || since.Before(at) {
+			out[key] = since
+		}
+	})
+	return out
+}
+
+// adminSessionCursor encodes the position after a.
+func adminSessionCursor(a AdminSession) string {
+	raw := strconv.FormatInt(a.LastActivity.UnixNano(), 10) + "," + sessionKey(a.UserID, a.SessionID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseAdminSessionCursor(cursor string) (time.Time, string, bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	nanos, key, ok := strings.Cut(string(raw), ",")
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if !ok || err != nil {
+		return time.Time{}, "", false
+	}
+	return time.Unix(0, n), key, true
+}
+
+// handleAdminSessions lists sessions across users, most recently active
+// first. active_since takes a time or a duration back from now; user_id
+// narrows the listing to one user. Sessions with a WebSocket attached but
+// no chunks yet count as active from when it attached.
+func handleAdminSessions(store *MemoryStore, conns *wsConns) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var since time.Time
+		if v := q.Get("active_since"); v != "" {
+			if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+				since = time.Now().Add(-d)
+			} else if t, err := time.Parse(time.RFC3339, v); err == nil {
+				since = t
+			} else {
+				writeError(w, invalidParam("active_since", "invalid_active_since", "active_since must be an RFC 3339 time or a duration"))
+				return
+			}
+		}
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, invalidParam("limit", "invalid_limit", "limit must be a positive integer"))
+				return
+			}
+			limit = min(n, maxAdminSessionsLimit)
+		}
+		var after AdminSession
+		cursor := q.Get("cursor")
+		if cursor != "" {
+			at, key, ok := parseAdminSessionCursor(cursor)
+			if !ok {
+				writeError(w, invalidParam("cursor", "invalid_cursor", "cursor must be a next_cursor returned by /admin/sessions"))
+				return
+			}
+			after.LastActivity = at
+			after.UserID, after.SessionID, _ = strings.Cut(key, "/")
+		}
+		userID := q.Get("user_id")
+
+		sessions := store.sessionActivity(userID)
+		for key, attached := range conns.live() {
+			a := sessions[key]
+			if a == nil {
+				u, sid, _ := strings.Cut(key, "/")
+				if userID != "" && u != userID {
+					continue
+				}
+				a = &AdminSession{UserID: u, SessionID: sid, LastActivity: attached}
+				sessions[key] = a
+			}
+			a.Live = true
+		}
+		for key, n := range conns.observerCounts() {
+			if a := sessions[key]; a != nil {
+				a.Observers = n
+			}
+		}
+		list := make([]AdminSession, 0, len(sessions))
+		for _, a := range sessions {
+			if !a.LastActivity.Before(since) {
+				list = append(list, *a)
+			}
+		}
+		before := func(a, b AdminSession) bool {
+			if !a.LastActivity.Equal(b.LastActivity) {
+				return a.LastActivity.After(b.LastActivity)
+			}
+			return sessionKey(a.UserID, a.SessionID) < sessionKey(b.UserID, b.SessionID)
+		}
+		sort.Slice(list, func(i, j int) bool { return before(list[i], list[j]) })
+		if cursor != "" {
+			list = list[sort.Search(len(list), func(i int) bool { return before(after, list[i]) }):]
+		}
+
+		page := AdminSessionPage{Sessions: list}
+		if len(list) > limit {
+			page.Sessions = list[:limit]
+			page.NextCursor = adminSessionCursor(list[limit-1])
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestAdminSessionsAcrossUsers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	now := time.Now()
+	for i, c := range []struct{ user, session string }{
+		{"user1", "s1"}, {"user2", "s2"}, {"user1", "s1"}, {"user3", "s3"}, {"user1", "stale"},
+	} {
+		at := now.Add(time.Duration(i-4) * time.Minute)
+		if c.session == "stale" {
+			at = now.Add(-3 * time.Hour)
+		}
+		id := "chunk" + string(rune('a'+i))
+		h.Store.Save(Metadata{ChunkID: id, UserID: c.user, SessionID: c.session, Timestamp: at})
+		h.Store.SaveBlob(id, make([]byte, 100))
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user2&session_id=other"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Switching sessions moves the live flag with it.
+	conn.WriteJSON(map[string]any{"type": "hello", "session_id": "s2"})
+	var hello map[string]any
+	if err := conn.ReadJSON(&hello); err != nil || hello["session_id"] != "s2" {
+		t.Fatalf("Expected a hello for s2, but got %v %v", hello, err)
+	}
+
+	var page AdminSessionPage
+	if status := adminDo(t, h, "GET", "/admin/sessions?active_since=1h&limit=2", nil, &page); status != 200 {
+		t.Fatalf("Expected 200, but got %v", status)
+	}
+	var got []string
+	var cursor string
+	for {
+		for _, s := range page.Sessions {
+			got = append(got, sessionKey(s.UserID, s.SessionID))
+			if s.Live != (s.UserID == "user2") {
+				t.Errorf("Expected only user2/s2 to be live, but got %+v", s)
+			}
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+		page = AdminSessionPage{}
+		adminDo(t, h, "GET", "/admin/sessions?active_since=1h&limit=2&cursor="+cursor, nil, &page)
+	}
+	want := []string{"user3/s3", "user1/s1", "user2/s2"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Fatalf("Expected %v by activity, but got %v", want, got)
+	}
+
+	page = AdminSessionPage{}
+	adminDo(t, h, "GET", "/admin/sessions?user_id=user1", nil, &page)
+	if len(page.Sessions) != 2 || page.Sessions[0].SessionID != "s1" || page.Sessions[0].Chunks != 2 || page.Sessions[0].Bytes != 200 {
+		t.Errorf("Expected user1's two sessions with s1 totals, but got %+v", page.Sessions)
+	}
+
+	if status := adminDo(t, h, "GET", "/admin/sessions?cursor=@@", nil, nil); status != 400 {
+		t.Errorf("Expected 400 for a bad cursor, but got %v", status)
+	}
+	resp, err := http.Get(h.URL + "/admin/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the listing to need the admin token, but got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,463 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Alert rule kinds.
+const (
+	// AlertErrorRate fires when the share of 5xx responses over the rule's
+	// window exceeds the threshold.
+	AlertErrorRate = "error_rate"
+	// AlertQueueDepth fires when more jobs than the threshold have waited
+	// for a worker for the rule's duration.
+	AlertQueueDepth = "queue_depth"
+	// AlertDeadLetters fires when more chunks than the threshold failed
+	// processing over the rule's window; failed chunks are the pipeline's
+	// dead-letter queue. It watches the queue grow rather than its size,
+	// so failures nobody has reprocessed yet stop firing once they age out
+	// of the window.
+	AlertDeadLetters = "dlq"
+	// AlertStoreP99 fires when the 99th percentile of recent store writes,
+	// in milliseconds, exceeds the threshold for the rule's duration.
+	AlertStoreP99 = "store_p99"
+)
+
+var alertKinds = []string{AlertErrorRate, AlertQueueDepth, AlertDeadLetters, AlertStoreP99}
+
+// Alert states. A rule is pending while its condition holds but has not
+// yet held for long enough to fire.
+const (
+	alertOK       = "ok"
+	alertPending  = "pending"
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// alertMinRequests is how many requests an error rate window needs before
+// it can fire, so one failure on an idle server does not page anyone.
+const alertMinRequests = 10
+
+var (
+	// httpResponses counts responses by status class, e.g. "5xx".
+	httpResponses = expvar.NewMap("http_responses")
+	// alertNotifications counts notifications sent and failed, keyed
+	// "<state>" and "failed".
+	alertNotifications = expvar.NewMap("alert_notifications")
+)
+
+// AlertRule is one condition the alert engine watches. For is the error
+// rate's window; for the other kinds it is how long the condition must hold
+// before the alert fires.
+type AlertRule struct {
+	Kind      string        `json:"kind"`
+	Threshold float64       `json:"threshold"`
+	For       time.Duration `json:"for"`
+}
+
+// String is the rule in the form ParseAlertRules reads, which also names
+// its alert.
+func (r AlertRule) String() string {
+	s := r.Kind + ">" + strconv.FormatFloat(r.Threshold, 'g', -1, 64)
+	if r.Kind == AlertStoreP99 {
+		s = r.Kind + ">" + (time.Duration(r.Threshold * float64(time.Millisecond))).String()
+	}
+	if r.For > 0 {
+		s += "/" + r.For.String()
+	}
+	return s
+}
+
+// ParseAlertRules reads comma-separated rules of the form
+// kind>threshold[/duration], e.g. "error_rate>0.05/5m,queue_depth>100/1m,
+// dlq>5/15m,store_p99>250ms/1m". store_p99 thresholds are durations.
+func ParseAlertRules(s string) ([]AlertRule, error) {
+	var rules []AlertRule
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		kind, rest, ok := strings.Cut(spec, ">")
+		if !ok || !slices.Contains(alertKinds, kind) {
+			return nil, fmt.Errorf("alert rule %q: kind must be %s", spec, strings.Join(alertKinds, ", "))
+		}
+		threshold, window, _ := strings.Cut(rest, "/")
+		rule := AlertRule{Kind: kind}
+		if kind == AlertStoreP99 {
+			d, err := time.ParseDuration(threshold)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("alert rule %q: threshold must be a positive duration", spec)
+			}
+			rule.Threshold = float64(d) / float64(time.Millisecond)
+		} else {
+			v, err := strconv.ParseFloat(threshold, 64)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("alert rule %q: threshold must be a non-negative number", spec)
+			}
+			rule.Threshold = v
+		}
+		if window != "" {
+			d, err := time.ParseDuration(window)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("alert rule %q: invalid duration", spec)
+			}
+			rule.For = d
+		}
+		if (kind == AlertErrorRate || kind == AlertDeadLetters) && rule.For <= 0 {
+			return nil, fmt.Errorf("alert rule %q: %s needs a window", spec, kind)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// AlertSources are the readings the rules are evaluated against. Any left
+// nil reads as zero.
+type AlertSources struct {
+	// Responses returns the running totals of responses and of 5xx ones.
+	Responses  func() (total, errors int64)
+	QueueDepth func() int
+	// DeadLetters returns the running total of chunks that failed.
+	DeadLetters func() int64
+	StoreP99    func() time.Duration
+}
+
+// httpResponseTotals reads httpResponses as an AlertSources.Responses.
+func httpResponseTotals() (total, errors int64) {
+	httpResponses.Do(func(kv expvar.KeyValue) {
+		n := kv.Value.(*expvar.Int).Value()
+		total += n
+		if kv.Key == "5xx" {
+			errors += n
+		}
+	})
+	return total, errors
+}
+
+// AlertStatus is a rule's current state, as listed by /healthz.
+type AlertStatus struct {
+	Rule      string  `json:"rule"`
+	State     string  `json:"state"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	// Since is when the rule entered its state; unset while it is ok.
+	Since *time.Time `json:"since,omitempty"`
+}
+
+// alertNotice is the JSON posted to the alert webhook. Text makes it
+// readable by Slack-style incoming webhooks as is.
+type alertNotice struct {
+	Text      string    `json:"text"`
+	Rule      string    `json:"rule"`
+	State     string    `json:"state"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	At        time.Time `json:"at"`
+}
+
+type alertState struct {
+	rule  AlertRule
+	name  string
+	state string
+	value float64
+	since time.Time
+	// notified is the last state delivered: "", firing or resolved. A
+	// transition is posted once, and retried on later evaluations until a
+	// delivery succeeds.
+	notified string
+}
+
+// alertSample is a reading of the running totals the windowed rules
+// measure from.
+type alertSample struct {
+	at            time.Time
+	total, errors int64
+	failed        int64
+}
+
+// Alerts evaluates alert rules against the service's counters, posting to
+// a webhook when an alert fires and again when it resolves.
+type Alerts struct {
+	Client *http.Client
+	url    string
+	clock  Clock
+	src    AlertSources
+
+	mu      sync.Mutex
+	rules   []*alertState
+	samples []alertSample
+	// window is the longest error rate or dlq window, which bounds
+	// samples.
+	window time.Duration
+}
+
+// NewAlerts builds an engine for cfg.AlertRules that reads src and posts
+// to cfg.AlertWebhookURL, if set, through egress.
+func NewAlerts(cfg Config, egress *Egress, clock Clock, src AlertSources) *Alerts {
+	a := &Alerts{
+		Client: egress.Client("alerts", cfg.WebhookTimeout, false),
+		url:    cfg.AlertWebhookURL,
+		clock:  clock,
+		src:    src,
+	}
+	for _, r := range cfg.AlertRules {
+		a.rules = append(a.rules, &alertState{rule: r, name: r.String(), state: alertOK})
+		if r.Kind == AlertErrorRate || r.Kind == AlertDeadLetters {
+			a.window = max(a.window, r.For)
+		}
+	}
+	return a
+}
+
+// since returns the sample to measure window back from now, and the
+// latest one.
+func (a *Alerts) since(now time.Time, window time.Duration) (base, last alertSample) {
+	base, last = a.samples[0], a.samples[len(a.samples)-1]
+	for _, s := range a.samples {
+		if s.at.After(now.Add(-window)) {
+			break
+		}
+		base = s
+	}
+	return base, last
+}
+
+// errorRate is the share of 5xx responses since the start of window, or 0
+// below alertMinRequests.
+func (a *Alerts) errorRate(now time.Time, window time.Duration) float64 {
+	base, last := a.since(now, window)
+	if n := last.total - base.total; n >= alertMinRequests {
+		return float64(last.errors-base.errors) / float64(n)
+	}
+	return 0
+}
+
+// read returns the current value of rule's metric.
+func (a *Alerts) read(rule AlertRule, now time.Time) float64 {
+	switch rule.Kind {
+	case AlertErrorRate:
+		return a.errorRate(now, rule.For)
+	case AlertQueueDepth:
+		if a.src.QueueDepth != nil {
+			return float64(a.src.QueueDepth())
+		}
+	case AlertDeadLetters:
+		base, last := a.since(now, rule.For)
+		return float64(last.failed - base.failed)
+	case AlertStoreP99:
+		if a.src.StoreP99 != nil {
+			return float64(a.src.StoreP99()) / float64(time.Millisecond)
+		}
+	}
+	return 0
+}
+
+// Evaluate reads the sources once, moves each rule between ok, pending and
+// firing, and posts the transitions not yet delivered.
+func (a *Alerts) Evaluate(ctx context.Context) {
+	now := a.clock.Now()
+	a.mu.Lock()
+	if a.window > 0 {
+		sample := alertSample{at: now}
+		if a.src.Responses != nil {
+			sample.total, sample.errors = a.src.Responses()
+		}
+		if a.src.DeadLetters != nil {
+			sample.failed = a.src.DeadLetters()
+		}
+		a.samples = append(a.samples, sample)
+		// Keep one sample at or before the window's start to measure from.
+		drop := 0
+		for drop+1 < len(a.samples) && !a.samples[drop+1].at.After(now.Add(-a.window)) {
+			drop++
+		}
+		a.samples = a.samples[drop:]
+	}
+
+	var notices []alertNotice
+	var pending []*alertState
+	for _, st := range a.rules {
+		st.value = 0
+		if len(a.samples) > 0 || st.rule.Kind != AlertErrorRate && st.rule.Kind != AlertDeadLetters {
+			st.value = a.read(st.rule, now)
+		}
+		breached := st.value > st.rule.Threshold
+		switch {
+		case breached && st.state == alertOK:
+			st.state, st.since = alertPending, now
+			fallthrough
+		case breached && st.state == alertPending:
+			if st.rule.Kind == AlertErrorRate || st.rule.Kind == AlertDeadLetters || !now.Before(st.since.Add(st.rule.For)) {
+				st.state, st.since = alertFiring, now
+			}
+		case !breached && st.state != alertOK:
+			st.state, st.since = alertOK, time.Time{}
+		}
+
+		want := ""
+		switch {
+		case st.state == alertFiring && st.notified != alertFiring:
+			want = alertFiring
+		case st.state != alertFiring && st.notified == alertFiring:
+			want = alertResolved
+		}
+		if want != "" && a.url != "" {
+			notices = append(notices, alertNotice{
+				Text:      fmt.Sprintf("[%s] %s: %g (threshold %g)", strings.ToUpper(want), st.name, st.value, st.rule.Threshold),
+				Rule:      st.name,
+				State:     want,
+				Value:     st.value,
+				Threshold: st.rule.Threshold,
+				At:        now,
+			})
+			pending = append(pending, st)
+		}
+	}
+	a.mu.Unlock()
+
+	for i, n := range notices {
+		if err := a.post(ctx, n); err != nil {
+			alertNotifications.Add("failed", 1)
+			log.Printf("Alert %s %s not delivered: %v", n.Rule, n.State, err)
+			continue
+		}
+		alertNotifications.Add(n.State, 1)
+		a.mu.Lock()
+		pending[i].notified = n.State
+		a.mu.Unlock()
+	}
+}
+
+func (a *Alerts) post(ctx context.Context, n alertNotice) error {
+	body, _ := json.Marshal(n)
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Status lists every rule's current state.
+func (a *Alerts) Status() []AlertStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]AlertStatus, 0, len(a.rules))
+	for _, st := range a.rules {
+		s := AlertStatus{Rule: st.name, State: st.state, Value: st.value, Threshold: st.rule.Threshold}
+		if st.state != alertOK {
+			since := st.since
+			s.Since = &since
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// Firing reports whether any alert is firing.
+func (a *Alerts) Firing() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, st := range a.rules {
+		if st.state == alertFiring {
+			return true
+		}
+	}
+	return false
+}
+
+// RunAlerts evaluates the rules every interval until ctx ends.
+func RunAlerts(ctx context.Context, a *Alerts, interval time.Duration) {
+	if interval <= 0 || len(a.rules) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Evaluate(ctx)
+		}
+	}
+}
+
+// latencySamples keeps the most recent durations of an operation for
+// percentiles.
+type latencySamples struct {
+	mu   sync.Mutex
+	ring [1024]time.Duration
+	n    int
+}
+
+func (l *latencySamples) observe(d time.Duration) {
+	l.mu.Lock()
+	l.ring[l.n%len(l.ring)] = d
+	l.n++
+	l.mu.Unlock()
+}
+
+// p99 is the 99th percentile of the samples kept, or 0 without any.
+func (l *latencySamples) p99() time.Duration {
+	l.mu.Lock()
+	sorted := slices.Clone(l.ring[:min(l.n, len(l.ring))])
+	l.mu.Unlock()
+	if len(sorted) == 0 {
+		return 0
+	}
+	slices.Sort(sorted)
+	return sorted[(len(sorted)*99-1)/100]
+}
+
+// SaveLatencyP99 is the 99th percentile of recent Save calls, lock waits
+// included.
+func (s *MemoryStore) SaveLatencyP99() time.Duration {
+	return s.saveLatency.p99()
+}
+
+// FailedTotal is how many times a chunk has been saved as failed, having
+// not been failed before, since the store was opened.
+func (s *MemoryStore) FailedTotal() int64 {
+	return s.failedTotal.Load()
+}
+
+// health is the body of GET /healthz.
+type health struct {
+	Status string        `json:"status"`
+	Alerts []AlertStatus `json:"alerts"`
+}
+
+// handleHealthz reports liveness with the alerts' states. It answers 200
+// even while alerts fire, reporting "degraded", so a restart loop does not
+// follow an overloaded queue.
+func handleHealthz(alerts *Alerts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := health{Status: "ok", Alerts: alerts.Status()}
+		if alerts.Firing() {
+			body.Status = "degraded"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertReceiver records the notices posted to it, answering 503 while
+// down is set.
+type alertReceiver struct {
+	mu      sync.Mutex
+	notices []alertNotice
+	down    bool
+}
+
+func (rcv *alertReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if rcv.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var n alertNotice
+	json.NewDecoder(r.Body).Decode(&n)
+	rcv.notices = append(rcv.notices, n)
+}
+
+// take returns and forgets the notices received so far, as "rule state".
+func (rcv *alertReceiver) take() []string {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	var out []string
+	for _, n := range rcv.notices {
+		out = append(out, n.Rule+" "+n.State)
+	}
+	rcv.notices = nil
+	return out
+}
+
+func stateOf(a *Alerts, rule string) string {
+	for _, st := range a.Status() {
+		if st.Rule == rule {
+			return st.State
+		}
+	}
+	return ""
+}
+
+func TestAlertsFireDedupeAndResolve(t *testing.T) {
+	rcv := &alertReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	var total, errors, failed int64
+	depth := 0
+	cfg := DefaultConfig()
+	cfg.AlertWebhookURL = srv.URL
+	cfg.AlertRules, _ = ParseAlertRules("error_rate>0.1/1m,queue_depth>5/30s,dlq>0/1m")
+	clock := NewFakeClock(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	a := NewAlerts(cfg, NewEgress(cfg), clock, AlertSources{
+		Responses:   func() (int64, int64) { return total, errors },
+		QueueDepth:  func() int { return depth },
+		DeadLetters: func() int64 { return failed },
+	})
+	ctx := context.Background()
+	step := func(d time.Duration) []string {
+		clock.Advance(d)
+		a.Evaluate(ctx)
+		return rcv.take()
+	}
+	step(0)
+
+	// 5 errors in 20 requests fires at once; the window is the smoothing.
+	total, errors = 20, 5
+	if got := step(10 * time.Second); len(got) != 1 || got[0] != "error_rate>0.1/1m0s firing" {
+		t.Fatalf("Expected the error rate to fire, but got %v", got)
+	}
+	// Still breached: no repeat.
+	total, errors = 40, 10
+	if got := step(10 * time.Second); len(got) != 0 {
+		t.Errorf("Expected no repeat while firing, but got %v", got)
+	}
+	// Clean traffic pushes the errors out of the window and resolves it.
+	total = 400
+	if got := step(50 * time.Second); len(got) != 1 || got[0] != "error_rate>0.1/1m0s resolved" {
+		t.Errorf("Expected the error rate to resolve, but got %v", got)
+	}
+	if got := step(10 * time.Second); len(got) != 0 {
+		t.Errorf("Expected one resolve, but got %v", got)
+	}
+
+	// The queue must stay deep for 30s; a dip resets the wait.
+	depth = 10
+	step(10 * time.Second)
+	if state := stateOf(a, "queue_depth>5/30s"); state != alertPending {
+		t.Errorf("Expected a pending queue alert, but got %q", state)
+	}
+	depth = 0
+	step(10 * time.Second)
+	depth = 10
+	step(10 * time.Second)
+	if got := step(20 * time.Second); len(got) != 0 {
+		t.Errorf("Expected the dip to reset the wait, but got %v", got)
+	}
+	if got := step(10 * time.Second); len(got) != 1 || got[0] != "queue_depth>5/30s firing" {
+		t.Errorf("Expected the queue alert after 30s, but got %v", got)
+	}
+
+	// A failed delivery is retried until it goes through, once.
+	rcv.mu.Lock()
+	rcv.down = true
+	rcv.mu.Unlock()
+	failed = 2
+	step(time.Second)
+	if !a.Firing() || stateOf(a, "dlq>0/1m0s") != alertFiring {
+		t.Fatalf("Expected the DLQ alert to fire, but got %+v", a.Status())
+	}
+	rcv.mu.Lock()
+	rcv.down = false
+	rcv.mu.Unlock()
+	if got := step(time.Second); len(got) != 1 || got[0] != "dlq>0/1m0s firing" {
+		t.Errorf("Expected the DLQ alert on the retry, but got %v", got)
+	}
+	if got := step(time.Second); len(got) != 0 {
+		t.Errorf("Expected no more DLQ notices, but got %v", got)
+	}
+	// Resolves are retried the same way. The failed chunks are never
+	// reprocessed, but the DLQ alert resolves once they age out of its
+	// window.
+	rcv.mu.Lock()
+	rcv.down = true
+	rcv.mu.Unlock()
+	depth = 0
+	step(time.Second)
+	if stateOf(a, "dlq>0/1m0s") != alertFiring {
+		t.Errorf("Expected the DLQ alert to fire within its window, but got %+v", a.Status())
+	}
+	step(time.Minute)
+	rcv.mu.Lock()
+	rcv.down = false
+	rcv.mu.Unlock()
+	if got := step(time.Second); len(got) != 2 {
+		t.Errorf("Expected both resolves on the retry, but got %v", got)
+	}
+	if a.Firing() {
+		t.Errorf("Expected nothing firing, but got %+v", a.Status())
+	}
+}
+
+func TestAlertsIgnoreQuietErrorWindows(t *testing.T) {
+	var total, errors int64
+	cfg := DefaultConfig()
+	cfg.AlertRules, _ = ParseAlertRules("error_rate>0.5/1m")
+	clock := NewFakeClock(time.Now())
+	a := NewAlerts(cfg, NewEgress(cfg), clock, AlertSources{Responses: func() (int64, int64) { return total, errors }})
+	a.Evaluate(context.Background())
+	total, errors = 2, 2
+	clock.Advance(time.Second)
+	a.Evaluate(context.Background())
+	if a.Firing() {
+		t.Errorf("Expected too few requests not to fire, but got %+v", a.Status())
+	}
+}
+
+func TestParseAlertRules(t *testing.T) {
+	rules, err := ParseAlertRules("error_rate>0.05/5m, queue_depth>100/1m,dlq>5/15m,store_p99>250ms/1m")
+	if err != nil || len(rules) != 4 {
+		t.Fatalf("Expected four rules, but got %+v %v", rules, err)
+	}
+	if r := rules[3]; r.Threshold != 250 || r.For != time.Minute || r.String() != "store_p99>250ms/1m0s" {
+		t.Errorf("Expected a 250ms p99 rule, but got %+v %s", r, r)
+	}
+	for _, bad := range []string{"cpu>1", "error_rate>0.1", "dlq>0", "queue_depth>x", "store_p99>5"} {
+		if _, err := ParseAlertRules(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestHealthzListsAlerts(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	resp, err := http.Get(h.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body health
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.Status != "ok" || len(body.Alerts) != len(DefaultConfig().AlertRules) {
+		t.Errorf("Expected every rule ok, but got %v %+v", resp.StatusCode, body)
+	}
+}
+
+func TestFailedTotalCountsNewFailures(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", Status: "failed"})
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", Status: "failed"})
+	store.Save(Metadata{ChunkID: "c2", UserID: "user1", Status: "processed"})
+	if got := store.FailedTotal(); got != 1 {
+		t.Errorf("Expected a failure saved twice to count once, but got %d", got)
+	}
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", Status: "processed"})
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", Status: "failed"})
+	if got := store.FailedTotal(); got != 2 {
+		t.Errorf("Expected a chunk failing again to count again, but got %d", got)
+	}
+}
@@ -0,0 +1,134 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const maxAnnotationText = 2000
+
+var errAnnotationNotFound = newKindError(ErrNotFound, "annotation not found")
+
+// Annotation is a reviewer comment anchored at an offset within a chunk.
+type Annotation struct {
+	ID        string    `json:"id"`
+	ChunkID   string    `json:"chunk_id"`
+	OffsetMS  int64     `json:"offset_ms"`
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	// Source is "cue" for a marker embedded in the audio; see Marker.
+	Source string `json:"source,omitempty"`
+}
+
+// annotatedChunk is a chunk in a session export that asked for annotations.
+type annotatedChunk struct {
+	Metadata
+	Annotations []Annotation `json:"annotations"`
+}
+
+// chunkDuration returns the length of a chunk's audio when its format is
+// recognised.
+func chunkDuration(store *MemoryStore, id string) (time.Duration, bool) {
+	data, err := store.GetBlob(id)
+	if err != nil {
+		return 0, false
+	}
+	info, err := probeAudio(data)
+	if err != nil || info.SampleRate == 0 {
+		return 0, false
+	}
+	return info.Duration(), true
+}
+
+func validateAnnotation(store *MemoryStore, a Annotation) error {
+	if a.Text == "" || len(a.Text) > maxAnnotationText {
+		return invalidField("text", "invalid_annotation", fmt.Sprintf("must be 1 to %d bytes", maxAnnotationText))
+	}
+	if a.OffsetMS < 0 {
+		return invalidField("offset_ms", "invalid_annotation", "must not be negative")
+	}
+	if d, ok := chunkDuration(store, a.ChunkID); ok && a.OffsetMS > d.Milliseconds() {
+		return invalidField("offset_ms", "invalid_annotation", fmt.Sprintf("%d is beyond the chunk's %dms duration", a.OffsetMS, d.Milliseconds()))
+	}
+	return nil
+}
+
+func handleAddAnnotation(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		meta, err := store.Get(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		var a Annotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, "Invalid annotation", http.StatusBadRequest)
+			return
+		}
+		a.ID = uuid.New().String()
+		a.ChunkID = id
+		a.CreatedAt = time.Now()
+		if a.Author == "" {
+			a.Author = authUserID(r.Context())
+		}
+		if err := validateAnnotation(store, a); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := store.AddAnnotation(a); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+func handleListAnnotations(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		meta, err := store.Get(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chunkAnnotations(store, meta))
+	}
+}
+
+func handleDeleteAnnotation(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		meta, err := store.Get(vars["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if !store.DeleteAnnotation(vars["id"], vars["annotation_id"]) {
+			writeError(w, errAnnotationNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func annotate(t *testing.T, handler http.HandlerFunc, id, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/chunks/"+id+"/annotations", bytes.NewBufferString(body))
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAnnotationsCRUD(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "chunk1", UserID: "user1"})
+	store.SaveBlob("chunk1", SineWAV(440, 2*time.Second, 8000))
+	add := handleAddAnnotation(store, DefaultConfig())
+
+	for _, body := range []string{
+		`{"offset_ms": 1500, "text": "door slam", "author": "rev"}`,
+		`{"offset_ms": 200, "text": "background noise", "author": "rev"}`,
+	} {
+		if rr := annotate(t, add, "chunk1", body); rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, but got %v: %s", rr.Code, rr.Body)
+		}
+	}
+	if rr := annotate(t, add, "chunk1", `{"offset_ms": 2500, "text": "too late"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an offset beyond the duration, but got %v", rr.Code)
+	}
+	if rr := annotate(t, add, "missing", `{"offset_ms": 0, "text": "x"}`); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown chunk, but got %v", rr.Code)
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/chunks/chunk1/annotations", nil), map[string]string{"id": "chunk1"})
+	rr := httptest.NewRecorder()
+	handleListAnnotations(store, DefaultConfig()).ServeHTTP(rr, req)
+	var listed []Annotation
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed) != 2 || listed[0].OffsetMS != 200 || listed[1].OffsetMS != 1500 {
+		t.Fatalf("Expected annotations sorted by offset, but got %+v", listed)
+	}
+
+	req = mux.SetURLVars(httptest.NewRequest("DELETE", "/", nil), map[string]string{"id": "chunk1", "annotation_id": listed[0].ID})
+	rr = httptest.NewRecorder()
+	handleDeleteAnnotation(store, DefaultConfig()).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent || len(store.Annotations("chunk1")) != 1 {
+		t.Errorf("Expected the annotation to be deleted, but got %v", rr.Code)
+	}
+}
+
+func TestAnnotationsCascadeOnPurge(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	store := NewMemoryStore()
+	store.Clock = clock
+	store.Save(Metadata{ChunkID: "chunk1", UserID: "user1"})
+	store.AddAnnotation(Annotation{ID: "a1", ChunkID: "chunk1", Text: "note"})
+
+	store.Delete("chunk1")
+	if len(store.Annotations("chunk1")) != 1 {
+		t.Errorf("Expected annotations to survive a soft delete")
+	}
+	clock.Advance(store.Retention + time.Hour)
+	store.PurgeDeleted()
+	if len(store.Annotations("chunk1")) != 0 {
+		t.Errorf("Expected annotations to be purged with their chunk")
+	}
+}
+
+func TestSessionExportIncludesAnnotations(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "chunk1", UserID: "user1"})
+	store.AddAnnotation(Annotation{ID: "a1", ChunkID: "chunk1", Text: "note"})
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/sessions/user1?include_annotations=true", nil), map[string]string{"user_id": "user1"})
+	rr := httptest.NewRecorder()
+	handleGetUserSessions(store, DefaultConfig()).ServeHTTP(rr, req)
+	var exported []annotatedChunk
+	json.NewDecoder(rr.Body).Decode(&exported)
+	if len(exported) != 1 || exported[0].ChunkID != "chunk1" || len(exported[0].Annotations) != 1 {
+		t.Errorf("Expected the export to carry annotations, but got %+v", exported)
+	}
+}
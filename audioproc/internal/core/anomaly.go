@@ -0,0 +1,76 @@
+package core
+
+import (
+	"expvar"
+	"math"
+)
+
+var anomalyStats = expvar.NewMap("audio_anomalies")
+
+// Anomaly flags recorded on Metadata.Anomalies.
+const (
+	anomalySilent   = "silent"
+	anomalyConstant = "constant"
+	anomalyDCOffset = "dc_offset"
+)
+
+// AnomalyDetector flags audio that is not worth transcribing: silence from
+// muted mics, a signal stuck at one value, or a heavy DC offset from broken
+// hardware. Samples within Tolerance of each other count as constant, and
+// a mean beyond DCOffsetThreshold counts as an offset. With
+// SkipTranscription, silent and constant chunks never reach the transcriber.
+type AnomalyDetector struct {
+	Tolerance         float64
+	DCOffsetThreshold float64
+	SkipTranscription bool
+}
+
+// NewAnomalyDetector builds a detector with cfg's anomaly thresholds.
+func NewAnomalyDetector(cfg Config) *AnomalyDetector {
+	return &AnomalyDetector{
+		Tolerance:         cfg.AnomalyTolerance,
+		DCOffsetThreshold: cfg.DCOffsetThreshold,
+		SkipTranscription: cfg.SkipAnomalousTranscription,
+	}
+}
+
+// Detect returns the anomalies found in pcm, in a fixed order.
+func (d *AnomalyDetector) Detect(pcm PCM) []string {
+	if len(pcm.Samples) == 0 {
+		return []string{anomalySilent}
+	}
+	lo, hi, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, s := range pcm.Samples {
+		lo, hi = math.Min(lo, s), math.Max(hi, s)
+		sum += s
+	}
+	var anomalies []string
+	switch {
+	case math.Max(math.Abs(lo), math.Abs(hi)) <= d.Tolerance:
+		anomalies = append(anomalies, anomalySilent)
+	case hi-lo <= d.Tolerance:
+		anomalies = append(anomalies, anomalyConstant)
+	}
+	if math.Abs(sum/float64(len(pcm.Samples))) > d.DCOffsetThreshold {
+		anomalies = append(anomalies, anomalyDCOffset)
+	}
+	for _, a := range anomalies {
+		anomalyStats.Add(a, 1)
+	}
+	return anomalies
+}
+
+// skipReason explains why a chunk with these anomalies is not transcribed,
+// or returns "" if it should be.
+func (d *AnomalyDetector) skipReason(anomalies []string) string {
+	if !d.SkipTranscription {
+		return ""
+	}
+	for _, a := range anomalies {
+		if a == anomalySilent || a == anomalyConstant {
+			anomalyStats.Add("transcriptions_skipped", 1)
+			return "audio is " + a
+		}
+	}
+	return ""
+}
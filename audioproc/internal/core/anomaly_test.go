@@ -0,0 +1,66 @@
+package core
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+type countingTranscriber struct {
+	calls atomic.Int64
+}
+
+func (c *countingTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	c.calls.Add(1)
+	return Transcription{Text: "speech"}, nil
+}
+
+func samplesWAV(n int, f func(i int) float64) []byte {
+	samples := make([]int16, n)
+	for i := range samples {
+		samples[i] = int16(f(i) * math.MaxInt16)
+	}
+	return EncodeWAV(samples, 8000)
+}
+
+func TestAnomalyDetection(t *testing.T) {
+	sine := func(i int) float64 { return 0.5 * math.Sin(2*math.Pi*440*float64(i)/8000) }
+	cases := []struct {
+		name      string
+		audio     []byte
+		anomalies []string
+		skipped   bool
+	}{
+		{"zeros", samplesWAV(8000, func(int) float64 { return 0 }), []string{anomalySilent}, true},
+		{"constant", samplesWAV(8000, func(int) float64 { return 0.5 }), []string{anomalyConstant, anomalyDCOffset}, true},
+		{"dc offset sine", samplesWAV(8000, func(i int) float64 { return 0.3 + sine(i)/2 }), []string{anomalyDCOffset}, false},
+		{"sine", samplesWAV(8000, sine), nil, false},
+	}
+
+	for _, skip := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.SkipAnomalousTranscription = skip
+		transcriber := &countingTranscriber{}
+		p := NewPipeline(cfg, transcriber, func() int { return 0 })
+
+		for _, tc := range cases {
+			before := transcriber.calls.Load()
+			meta := p.Process(context.Background(), AudioChunk{ChunkID: tc.name, Data: tc.audio})
+			if !reflect.DeepEqual(meta.Anomalies, tc.anomalies) {
+				t.Errorf("%s: expected anomalies %v, but got %v", tc.name, tc.anomalies, meta.Anomalies)
+			}
+			skipped := transcriber.calls.Load() == before
+			if want := skip && tc.skipped; skipped != want {
+				t.Errorf("%s (skip=%v): expected skipped=%v, but got %v", tc.name, skip, want, skipped)
+			}
+			if skipped && (meta.Transcript != "" || meta.TranscriptSkipReason == "" || meta.Status != "processed") {
+				t.Errorf("%s: expected an empty transcript with a reason, but got %+v", tc.name, meta)
+			}
+			if !skipped && (meta.Transcript != "speech" || meta.TranscriptSkipReason != "") {
+				t.Errorf("%s: expected a transcript, but got %+v", tc.name, meta)
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package core
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// ArchiveLocation points at one chunk inside a day's archive file.
+type ArchiveLocation struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+}
+
+// Archive moves the audio of old chunks into one compressed archive file per
+// day and serves it back on demand. Each chunk is appended as its own gzip
+// member holding a single tar entry, so the file as a whole reads as a
+// .tar.gz while any chunk can be extracted from its offset without
+// decompressing the rest of the day.
+type Archive struct {
+	Dir   string
+	After time.Duration
+	Clock Clock
+
+	store *MemoryStore
+
+	// mu serializes appends to archive files.
+	mu sync.Mutex
+
+	cacheMu   sync.Mutex
+	cacheSize int
+	cache     map[string]*list.Element
+	lru       *list.List
+}
+
+type archivedBlob struct {
+	id   string
+	data []byte
+}
+
+// NewArchive builds an archive for store and makes store read through it.
+func NewArchive(cfg Config, store *MemoryStore) *Archive {
+	a := &Archive{
+		Dir:       cfg.ArchiveDir,
+		After:     cfg.ArchiveAfter,
+		Clock:     realClock{},
+		store:     store,
+		cacheSize: cfg.ArchiveCacheSize,
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+	store.Archive = a
+	return a
+}
+
+// Sweep archives every chunk older than After and reports how many it moved.
+func (a *Archive) Sweep() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := os.MkdirAll(a.Dir, 0o700); err != nil {
+		return 0, err
+	}
+	archived := 0
+	for _, m := range a.store.archiveCandidates(a.Clock.Now().Add(-a.After)) {
+		data, err := a.store.GetBlob(m.ChunkID)
+		if err != nil {
+			continue
+		}
+		loc, err := a.append(m, data)
+		if err != nil {
+			return archived, fmt.Errorf("archiving %s: %w", m.ChunkID, err)
+		}
+		a.store.markArchived(m.ChunkID, loc)
+		archived++
+	}
+	return archived, nil
+}
+
+func (a *Archive) append(m Metadata, data []byte) (ArchiveLocation, error) {
+	name := m.Timestamp.UTC().Format("2006-01-02") + ".tar.gz"
+	f, err := os.OpenFile(filepath.Join(a.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return ArchiveLocation{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return ArchiveLocation{}, err
+	}
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	hdr := &tar.Header{Name: m.ChunkID, Mode: 0o600, Size: int64(len(data)), ModTime: m.Timestamp}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return ArchiveLocation{}, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return ArchiveLocation{}, err
+	}
+	// Flush rather than Close: the end-of-archive marker would stop tar
+	// readers at the first member.
+	if err := tw.Flush(); err != nil {
+		return ArchiveLocation{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return ArchiveLocation{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return ArchiveLocation{}, err
+	}
+	end, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return ArchiveLocation{}, err
+	}
+	return ArchiveLocation{File: name, Offset: info.Size(), Size: end - info.Size()}, nil
+}
+
+// Read extracts a chunk's audio, consulting a small cache of recent
+// extractions first.
+func (a *Archive) Read(id string, loc ArchiveLocation) ([]byte, error) {
+	if data, ok := a.cached(id); ok {
+		return data, nil
+	}
+	f, err := os.Open(filepath.Join(a.Dir, filepath.Base(loc.File)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(io.NewSectionReader(f, loc.Offset, loc.Size))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != id {
+		return nil, fmt.Errorf("archive entry at %s:%d is %s, not %s", loc.File, loc.Offset, hdr.Name, id)
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, err
+	}
+	a.remember(id, data)
+	return data, nil
+}
+
+func (a *Archive) cached(id string) ([]byte, bool) {
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	if el, ok := a.cache[id]; ok {
+		a.lru.MoveToFront(el)
+		return el.Value.(*archivedBlob).data, true
+	}
+	return nil, false
+}
+
+func (a *Archive) remember(id string, data []byte) {
+	if a.cacheSize <= 0 {
+		return
+	}
+	a.cacheMu.Lock()
+	defer a.cacheMu.Unlock()
+	if _, ok := a.cache[id]; ok {
+		return
+	}
+	a.cache[id] = a.lru.PushFront(&archivedBlob{id: id, data: data})
+	if a.lru.Len() > a.cacheSize {
+		oldest := a.lru.Remove(a.lru.Back()).(*archivedBlob)
+		delete(a.cache, oldest.id)
+	}
+}
+
+// RestoreSession moves every archived chunk of a session back into the blob
+// store ahead of playback. The archived copies stay in their files.
+func (a *Archive) RestoreSession(userID, sessionID string) (int, error) {
+	restored := 0
+	for _, m := range a.store.ListByUser(userID) {
+		if m.SessionID != sessionID || m.Archive == nil {
+			continue
+		}
+		data, err := a.Read(m.ChunkID, *m.Archive)
+		if err != nil {
+			return restored, fmt.Errorf("restoring %s: %w", m.ChunkID, err)
+		}
+		a.store.unarchive(m.ChunkID, data)
+		restored++
+	}
+	return restored, nil
+}
+
+// RunArchiveSweeper does nothing unless an archive threshold is configured.
+// Sweeps are skipped while ro is on.
+func RunArchiveSweeper(ctx context.Context, a *Archive, ro *ReadOnly, interval time.Duration) {
+	if a.After <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ro.Enabled() {
+				continue
+			}
+			n, err := a.Sweep()
+			if err != nil {
+				log.Printf("Archive sweep failed: %v", err)
+			}
+			if n > 0 {
+				log.Printf("Archived %d chunks", n)
+			}
+		}
+	}
+}
+
+func handleGetAudio(store *MemoryStore, tc *Transcoder, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		meta, err := store.Get(id)
+		if err != nil {
+			writeChunkError(w, store, id, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeChunkAudio(w, r, store, tc, meta)
+	}
+}
+
+func handleRestoreSession(a *Archive, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := checkUserAccess(cfg, r, vars["user_id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		n, err := a.RestoreSession(vars["user_id"], vars["session_id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"restored": n})
+	}
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveRoundTrip(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ArchiveDir = t.TempDir()
+	cfg.ArchiveAfter = 24 * time.Hour
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(day)
+	h.Archive.Clock = clock
+
+	blobs := map[string][]byte{}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("chunk%d", i)
+		blobs[id] = SineWAV(float64(300+100*i), 100*time.Millisecond, 8000)
+		h.Store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "sess1", Timestamp: day.Add(time.Duration(i) * time.Minute)})
+		h.Store.SaveBlob(id, blobs[id])
+	}
+
+	if n, _ := h.Archive.Sweep(); n != 0 {
+		t.Fatalf("Expected nothing archived before the threshold, but got %v", n)
+	}
+	clock.Advance(48 * time.Hour)
+	if n, err := h.Archive.Sweep(); n != 3 || err != nil {
+		t.Fatalf("Expected 3 chunks archived, but got %v, %v", n, err)
+	}
+
+	for id, want := range blobs {
+		h.Store.mu.RLock()
+		_, hot := h.Store.blobs[id]
+		h.Store.mu.RUnlock()
+		if hot {
+			t.Errorf("Expected the original blob of %v to be removed", id)
+		}
+		if m, _ := h.Store.Get(id); m.Archive == nil || m.Archive.File != "2024-03-01.tar.gz" {
+			t.Errorf("Expected %v to record its archive location, but got %+v", id, m.Archive)
+		}
+
+		resp, err := http.Get(h.URL + "/chunks/" + id + "/audio")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !bytes.Equal(got, want) {
+			t.Errorf("Expected byte-identical audio for %v from the archive", id)
+		}
+	}
+
+	// The day's file is a readable .tar.gz holding all three chunks.
+	f, err := os.Open(filepath.Join(cfg.ArchiveDir, "2024-03-01.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, _ := gzip.NewReader(f)
+	tr := tar.NewReader(zr)
+	var entries int
+	for {
+		if _, err := tr.Next(); err != nil {
+			break
+		}
+		entries++
+	}
+	if entries != 3 {
+		t.Errorf("Expected 3 tar entries, but got %v", entries)
+	}
+
+	resp, err := http.Post(h.URL+"/sessions/user1/sess1/unarchive", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored map[string]int
+	json.NewDecoder(resp.Body).Decode(&restored)
+	resp.Body.Close()
+	if restored["restored"] != 3 {
+		t.Errorf("Expected 3 chunks restored, but got %v", restored)
+	}
+	if m, _ := h.Store.Get("chunk0"); m.Archive != nil {
+		t.Errorf("Expected the archive location to be cleared on restore")
+	}
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var (
+	errNotWAV       = errors.New("not a PCM WAV file")
+	errUnknownAudio = errors.New("unrecognised audio format")
+)
+
+// audioFormats are the containers decodeAudio and probeAudio recognise by
+// their leading bytes.
+var audioFormats = []struct {
+	magic  string
+	name   string
+	decode func([]byte) (PCM, error)
+	probe  func([]byte) (StreamInfo, error)
+}{
+	{"RIFF", "wav", decodeWAV, probeWAV},
+	{"fLaC", "flac", decodeFLAC, func(data []byte) (StreamInfo, error) {
+		info, _, err := probeFLAC(data)
+		return info, err
+	}},
+}
+
+// decodeAudio decodes any format in audioFormats.
+func decodeAudio(data []byte) (PCM, error) {
+	for _, f := range audioFormats {
+		if len(data) >= len(f.magic) && string(data[:len(f.magic)]) == f.magic {
+			return f.decode(data)
+		}
+	}
+	return PCM{}, errUnknownAudio
+}
+
+// probeAudio reads a stream's parameters from its header, without decoding
+// the audio.
+func probeAudio(data []byte) (StreamInfo, error) {
+	for _, f := range audioFormats {
+		if len(data) >= len(f.magic) && string(data[:len(f.magic)]) == f.magic {
+			return f.probe(data)
+		}
+	}
+	return StreamInfo{}, errUnknownAudio
+}
+
+// checkAudio rejects audio in a recognised format that fails to decode.
+// Unrecognised data passes, since the pipeline accepts opaque audio.
+func checkAudio(data []byte) error {
+	var ferr *flacError
+	if _, err := decodeAudio(data); errors.As(err, &ferr) {
+		details := inspectAudio(data)
+		details.Rule = audioRuleDecodable
+		return rejectAudio(details, "corrupt_audio", err.Error())
+	}
+	return nil
+}
+
+// loudnessDBFS is the RMS level of pcm relative to full scale, and false
+// for digital silence.
+func loudnessDBFS(pcm PCM) (float64, bool) {
+	if len(pcm.Samples) == 0 {
+		return 0, false
+	}
+	rms := frameRMS(pcm.Samples)
+	if rms == 0 {
+		return 0, false
+	}
+	return 20 * math.Log10(rms), true
+}
+
+// PCM is decoded audio downmixed to mono, with samples in [-1, 1].
+type PCM struct {
+	Samples    []float64
+	SampleRate int
+}
+
+// decodeWAV decodes 8- and 16-bit integer PCM WAV files.
+func decodeWAV(data []byte) (PCM, error) {
+	channels, rate, bits, pcm, err := parseWAV(data)
+	if err != nil {
+		return PCM{}, err
+	}
+	frameBytes := channels * bits / 8
+	n := len(pcm) / frameBytes
+	samples := make([]float64, n)
+	for i := 0; i < n; i++ {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			p := pcm[i*frameBytes+c*bits/8:]
+			if bits == 8 {
+				sum += (float64(p[0]) - 128) / 128
+			} else {
+				sum += float64(int16(binary.LittleEndian.Uint16(p))) / 32768
+			}
+		}
+		samples[i] = sum / float64(channels)
+	}
+	return PCM{Samples: samples, SampleRate: rate}, nil
+}
+
+func probeWAV(data []byte) (StreamInfo, error) {
+	channels, rate, bits, pcm, err := parseWAV(data)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+	return StreamInfo{
+		SampleRate:    rate,
+		Channels:      channels,
+		BitsPerSample: bits,
+		TotalSamples:  int64(len(pcm) / (channels * bits / 8)),
+	}, nil
+}
+
+// parseWAV returns the format and sample data of a WAV file.
+func parseWAV(data []byte) (channels, rate, bits int, pcm []byte, err error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return 0, 0, 0, nil, errNotWAV
+	}
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8:]
+		if size > len(body) {
+			size = len(body)
+		}
+		body = body[:size]
+		switch id {
+		case "fmt ":
+			if size < 16 || binary.LittleEndian.Uint16(body[0:2]) != 1 {
+				return 0, 0, 0, nil, errNotWAV
+			}
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+		case "data":
+			pcm = body
+		}
+		off += 8 + size + size%2
+	}
+	if channels == 0 || rate == 0 || pcm == nil || (bits != 8 && bits != 16) {
+		return 0, 0, 0, nil, errNotWAV
+	}
+	return channels, rate, bits, pcm, nil
+}
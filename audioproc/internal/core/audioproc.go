@@ -0,0 +1,1453 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/receipt"
+	"github.com/gorilla/mux"
+)
+
+// AudioChunk is an uploaded piece of audio on its way through the pipeline.
+type AudioChunk struct {
+	ChunkID        string          `json:"chunk_id"`
+	UserID         string          `json:"user_id"`
+	SessionID      string          `json:"session_id"`
+	Timestamp      time.Time       `json:"timestamp"`
+	ClientMetadata json.RawMessage `json:"client_metadata,omitempty"`
+	Data           []byte          `json:"-"`
+	// Checksum is the hex SHA-256 of Data, computed as the audio is
+	// received; Process computes it when it is empty.
+	Checksum string `json:"-"`
+	// RecordedAt is when the client captured the chunk, on the server's
+	// clock; see correctRecordedAt.
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+	// Index is the client's seq for the chunk, if it sent one; ingest
+	// claims it, or the next free one, as the chunk's Metadata.Index.
+	Index int64 `json:"index,omitempty"`
+
+	SessionRevision int `json:"session_revision,omitempty"`
+	// ParentChunkID and OffsetMS are set on the pieces of an upload that
+	// was split for exceeding Config.MaxChunkDuration.
+	ParentChunkID string `json:"parent_chunk_id,omitempty"`
+	OffsetMS      int64  `json:"offset_ms,omitempty"`
+	SourceIP      string `json:"-"`
+	ReplayOf      string `json:"-"`
+	// DerivedFrom is set on chunks cut from another by a trim.
+	DerivedFrom string `json:"derived_from,omitempty"`
+	// Priority orders the chunk against other queued work; see Dispatcher.
+	Priority Priority `json:"-"`
+	// Settings are the user's overrides as of upload; Language is the
+	// resolved hint the transcriber should use.
+	Settings UserSettings `json:"-"`
+	Language string       `json:"-"`
+	// Reprocess marks a chunk that was already processed once, and DryRun
+	// one processed only to compare the result; see handleCompareChunk.
+	Reprocess bool `json:"-"`
+	DryRun    bool `json:"-"`
+	// Markers, when set, replace those read from Data, for audio that was
+	// re-encoded without its cue chunk.
+	Markers []Marker `json:"-"`
+
+	// deadline is when the caller stops waiting for the chunk, and
+	// progress tracks how far it got; see submitJob.
+	deadline time.Time
+	progress *jobProgress
+}
+
+// Metadata is what the service stores and returns for a processed chunk.
+type Metadata struct {
+	SchemaVersion int `json:"schema_version"`
+
+	ChunkID   string `json:"chunk_id"`
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+	// SessionRevision counts how many times the session was reopened after
+	// an idle close; see SessionTracker.
+	SessionRevision int `json:"session_revision,omitempty"`
+	// Index is the chunk's position in its session, counting from 1: the
+	// client's seq when it sent one no other chunk has, otherwise the next
+	// free index when the chunk was queued. See SessionIndexer.
+	Index     int64     `json:"index,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	// RecordedAt is when the client captured the chunk, corrected for its
+	// clock skew.
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+	// ParentChunkID names the upload this chunk was split from, and
+	// OffsetMS is where in that upload it starts.
+	ParentChunkID string `json:"parent_chunk_id,omitempty"`
+	OffsetMS      int64  `json:"offset_ms,omitempty"`
+	// DerivedFrom names the chunk this one was trimmed from; OffsetMS is
+	// then where in that chunk the trim starts. Deleting the source leaves
+	// the chunks derived from it in place.
+	DerivedFrom string `json:"derived_from,omitempty"`
+	// SourceIP is the uploading client's address; see clientIP.
+	SourceIP string `json:"source_ip,omitempty"`
+	// ReplayOf names the recorded session this chunk was replayed from.
+	ReplayOf string `json:"replay_of,omitempty"`
+	// DurationMS is the audio length and LoudnessDBFS its RMS level, known
+	// only for audio that decodes. LoudnessDBFS is nil until measured, as
+	// 0 dBFS is a real level.
+	DurationMS   int64    `json:"duration_ms,omitempty"`
+	LoudnessDBFS *float64 `json:"loudness_dbfs,omitempty"`
+	Checksum     string   `json:"checksum"`
+	FFT          string   `json:"fft"`
+	Transcript   string   `json:"transcript"`
+	// Words times each transcript word relative to the start of the chunk.
+	Words []Word `json:"words,omitempty"`
+	// Truncated is set when the transcript and words were too large to
+	// keep inline; see Config.TranscriptInlineMax. Transcript is then the
+	// opening of the text, Words is empty, and TranscriptBlob names the
+	// blob holding both in full, as GET /chunks/{id}/transcript serves
+	// them. Search only sees the opening.
+	Truncated      bool   `json:"truncated,omitempty"`
+	TranscriptBlob string `json:"transcript_blob,omitempty"`
+	// Confidence is how sure the transcriber was of the transcript, from 0
+	// to 1, and is unset for chunks it did not transcribe. Chunks under
+	// Config.ReviewThreshold wait in the review queue until ReviewedAt is
+	// set; see ReviewQueue.
+	Confidence *float64   `json:"confidence,omitempty"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+	// Anomalies flags silent, constant or DC-offset audio. When such audio
+	// is not transcribed, TranscriptSkipReason says why.
+	Anomalies            []string `json:"anomalies,omitempty"`
+	TranscriptSkipReason string   `json:"transcript_skip_reason,omitempty"`
+	// Skipped maps the stages that were disabled or stubbed when the
+	// chunk was processed to why; see StageControl.
+	Skipped map[string]string `json:"skipped,omitempty"`
+	// Redactions counts, by category, the PII replaced in Transcript with
+	// placeholders before it was stored; see RedactionRule.
+	Redactions map[string]int `json:"redactions,omitempty"`
+	// TimedOut lists the fields left empty because the chunk ran out of
+	// processing time; Status is then "partial".
+	TimedOut []string `json:"timed_out,omitempty"`
+	// Markers are the cue points embedded in a WAV upload, with their
+	// labels.
+	Markers []Marker `json:"markers,omitempty"`
+	// EmbeddedTags are the title, artist and such found in the file's ID3
+	// or RIFF INFO tags.
+	EmbeddedTags *EmbeddedTags `json:"embedded_tags,omitempty"`
+	// Fingerprint is a compact acoustic hash of WAV audio; see Fingerprint.
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	Status      string     `json:"status,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// Archive is set once the audio has moved out of the blob store.
+	Archive *ArchiveLocation `json:"archive,omitempty"`
+	// IntegrityStatus is "missing_blob" when reconciliation found no audio
+	// behind this record; see Reconciler.
+	IntegrityStatus string `json:"integrity_status,omitempty"`
+
+	// ClientMetadata is opaque client context, stored and returned verbatim.
+	ClientMetadata json.RawMessage `json:"client_metadata,omitempty"`
+}
+
+var (
+	errNotFound         = newKindError(ErrNotFound, "chunk not found")
+	errNotDeleted       = newKindError(ErrConflict, "chunk is not deleted")
+	errRetentionExpired = newKindError(ErrGone, "restore window has expired")
+	errBlobNotFound     = newKindError(ErrNotFound, "chunk audio not found")
+)
+
+// MemoryStore is the in-process Store. It also keeps the bookkeeping the
+// server needs beyond chunks: settings, checkpoints, acks, keys and usage.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	metadata map[string]Metadata
+	blobs    map[string][]byte
+	// blobSaved is when each blob was written, so reconciliation can leave
+	// uploads whose metadata is not saved yet alone.
+	blobSaved map[string]time.Time
+	// revisions keeps the newest RevisionDepth versions of each chunk's
+	// metadata, oldest first.
+	revisions map[string][]Revision
+	// legacy holds records persisted under an older schema. They are
+	// upgraded on read and move to metadata on their next Save.
+	legacy map[string]json.RawMessage
+
+	checkpoints map[string]ReprocessStatus
+	annotations map[string][]Annotation
+	exported    map[string]time.Time // auto-exported session revisions
+	quotaWarned map[string]string    // day each soft quota warning was sent
+	warnedDay   string               // the day quotaWarned holds marks for
+	storage     map[string]storageTotal
+	charged     map[string]storageCharge // what each chunk adds to storage
+	settings    map[string]UserSettings
+	usage       map[string]BillingUsage // by user and month
+	claimed     map[string]bool         // client-supplied chunk IDs being uploaded
+	audit       []AuditEvent
+	shares      map[string]shareState // revoked or limited share links
+	apiKeys     map[string]APIKey     // by hash
+	webhooks    map[string]WebhookSubscription
+	hookCounts  map[string]int64 // webhook delivery counters, keyed as in webhookDeliveries
+	index       indexState
+	onChange    []func(id string)
+	onWrite     []func(id string, meta Metadata, err error)
+	changes     []Change
+	changeSeq   int64
+
+	// sessions holds the acks and chunk numbering of each session in
+	// SessionShards shards, each behind its own lock rather than mu.
+	sessions     []*storeShard
+	sessionsOnce sync.Once
+
+	// Clock and Retention control soft-delete bookkeeping; set them before
+	// use. Users can override Retention in their settings.
+	Clock     Clock
+	Retention time.Duration
+	// ChangeLogSize bounds how many changes are kept for GET /changes.
+	ChangeLogSize int
+	// Archive, when set, serves blobs that have been moved to cold storage.
+	Archive *Archive
+	// Events, when set, receives chunk events for committed changes.
+	Events *Bus
+	// ReadOnly, when on, has chunks that finish processing refused rather
+	// than saved, and running reprocess jobs interrupted. The store itself
+	// still takes writes, as maintenance needs it to.
+	ReadOnly *ReadOnly
+	// RevisionDepth bounds the revision history kept per chunk; 0 keeps
+	// none.
+	RevisionDepth int
+	// TranscriptInlineMax is the size above which Save stores a chunk's
+	// transcript as a blob; 0 keeps every transcript inline. Records
+	// saved inline before it was lowered move out on their next save.
+	TranscriptInlineMax int
+	// SessionShards is how many shards session state is split into; set
+	// it before the first session is acked or numbered.
+	SessionShards int
+
+	// saveLatency times Save for the store_p99 alert.
+	saveLatency latencySamples
+	// failedTotal counts chunks saved as failed for the dlq alert.
+	failedTotal atomic.Int64
+	// wal, when set, logs every change; see OpenMemoryStore. logging
+	// reports whether it is set to writers that would otherwise take mu
+	// only to find out.
+	wal     *wal
+	logging atomic.Bool
+}
+
+// NewMemoryStore returns an empty store with default retention.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		metadata: make(map[string]Metadata),
+		blobs:    make(map[string][]byte),
+		legacy:   make(map[string]json.RawMessage),
+
+		checkpoints: make(map[string]ReprocessStatus),
+		annotations: make(map[string][]Annotation),
+		exported:    make(map[string]time.Time),
+		quotaWarned: make(map[string]string),
+		storage:     make(map[string]storageTotal),
+		charged:     make(map[string]storageCharge),
+		settings:    make(map[string]UserSettings),
+		usage:       make(map[string]BillingUsage),
+		claimed:     make(map[string]bool),
+		blobSaved:   make(map[string]time.Time),
+		revisions:   make(map[string][]Revision),
+		shares:      make(map[string]shareState),
+		apiKeys:     make(map[string]APIKey),
+		webhooks:    make(map[string]WebhookSubscription),
+		hookCounts:  make(map[string]int64),
+		index:       indexState{live: newIndexes(), trusted: true, status: IndexStatus{State: indexesOK}},
+		Clock:       realClock{},
+		Retention:   DefaultConfig().TrashRetention,
+
+		ChangeLogSize: DefaultConfig().ChangeLogSize,
+		RevisionDepth: DefaultConfig().RevisionDepth,
+
+		TranscriptInlineMax: DefaultConfig().TranscriptInlineMax,
+		SessionShards:       DefaultConfig().SessionShards,
+	}
+}
+
+// Save writes meta, replacing any record with the same chunk ID.
+func (s *MemoryStore) Save(meta Metadata) error {
+	defer func(start time.Time) { s.saveLatency.observe(time.Since(start)) }(time.Now())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := s.saveOpLocked(meta.ChunkID)
+	if blob := s.saveLocked(meta, ""); blob != "" {
+		s.logBlobLocked(blob)
+	}
+	s.logRevisionLocked(meta.ChunkID)
+	s.changedLocked(op, meta.ChunkID)
+	return nil
+}
+
+// saveLocked writes meta and records it as a revision. An empty cause is
+// initial for a new chunk and overwrite for an existing one. A large
+// transcript goes to the blob store, and the ID of the transcript blob
+// written or dropped is returned for the caller to log.
+func (s *MemoryStore) saveLocked(meta Metadata, cause string) (transcriptBlob string) {
+	meta.SchemaVersion = currentSchemaVersion
+	// Reprocessing rewrites metadata but not the audio, so an archived
+	// chunk keeps its location until its blob is saved again.
+	if old, ok := s.metadata[meta.ChunkID]; ok && meta.Archive == nil && old.Archive != nil {
+		if _, hot := s.blobs[meta.ChunkID]; !hot {
+			meta.Archive = old.Archive
+		}
+	}
+	old, exists := s.lookupLocked(meta.ChunkID)
+	if exists {
+		s.unindexLocked(old)
+	}
+	transcriptBlob = s.storeTranscriptLocked(&meta, old)
+	if cause == "" {
+		cause = revisionInitial
+		if exists {
+			cause = revisionOverwrite
+		}
+	}
+	if meta.Status == "failed" && (!exists || old.Status != "failed") {
+		s.failedTotal.Add(1)
+	}
+	s.metadata[meta.ChunkID] = meta
+	delete(s.legacy, meta.ChunkID)
+	s.indexLocked(meta)
+	s.recordRevisionLocked(meta, cause)
+	return transcriptBlob
+}
+
+// OnChange registers fn to be called with the ID of every record that is
+// written, deleted or restored. fn runs under the store lock and must not
+// call back into the store.
+func (s *MemoryStore) OnChange(fn func(id string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = append(s.onChange, fn)
+}
+
+// OnWrite is OnChange with the record as Get would now return it, for
+// write-through caches. Like OnChange, fn runs under the store lock.
+func (s *MemoryStore) OnWrite(fn func(id string, meta Metadata, err error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onWrite = append(s.onWrite, fn)
+}
+
+func (s *MemoryStore) changedLocked(op, id string) {
+	s.chargeLocked(id)
+	s.recordChangeLocked(op, id)
+	for _, fn := range s.onChange {
+		fn(id)
+	}
+	if len(s.onWrite) > 0 {
+		meta, err := s.getLocked(id)
+		for _, fn := range s.onWrite {
+			fn(id, meta, err)
+		}
+	}
+	s.publishLocked(op, id)
+}
+
+// publishLocked turns saves and deletes into bus events. Publish never
+// blocks, so it is safe under the store lock.
+func (s *MemoryStore) publishLocked(op, id string) {
+	m, ok := s.lookupLocked(id)
+	if !ok {
+		return
+	}
+	if typ := chunkEventType(op, m); typ != "" {
+		s.Events.Publish(Event{Type: typ, Seq: s.changeSeq, At: s.Clock.Now(), Chunk: &m})
+	}
+}
+
+// chunkEventType is the bus event a change of op to m is published as, or
+// "" for changes that are not published.
+func chunkEventType(op string, m Metadata) string {
+	switch op {
+	case changeSave:
+		if m.Status == "failed" {
+			return EventChunkFailed
+		}
+		return EventChunkProcessed
+	case changeDelete:
+		return EventChunkDeleted
+	}
+	return ""
+}
+
+// PutRaw stores a record exactly as persisted, whatever its schema version.
+func (s *MemoryStore) PutRaw(id string, raw json.RawMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op := changeSave
+	if old, ok := s.lookupLocked(id); ok {
+		s.unindexLocked(old)
+		op = changeUpdate
+	}
+	delete(s.metadata, id)
+	s.legacy[id] = raw
+	if m, ok := s.lookupLocked(id); ok {
+		s.indexLocked(m)
+	}
+	s.changedLocked(op, id)
+}
+
+// SchemaVersion reports the version a record is persisted under, before any
+// upgrade on read.
+func (s *MemoryStore) SchemaVersion(id string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if m, ok := s.metadata[id]; ok {
+		return m.SchemaVersion, true
+	}
+	if raw, ok := s.legacy[id]; ok {
+		return rawSchemaVersion(raw), true
+	}
+	return 0, false
+}
+
+// SchemaVersions counts persisted records by schema version.
+func (s *MemoryStore) SchemaVersions() map[int]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[int]int)
+	for _, m := range s.metadata {
+		counts[m.SchemaVersion]++
+	}
+	for _, raw := range s.legacy {
+		counts[rawSchemaVersion(raw)]++
+	}
+	return counts
+}
+
+// LegacyIDs lists records persisted under an older schema.
+func (s *MemoryStore) LegacyIDs() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := make([]string, 0, len(s.legacy))
+	for id := range s.legacy {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// UpgradeLegacy rewrites a legacy record under the current schema. It is a
+// no-op if the record was saved since it was listed.
+func (s *MemoryStore) UpgradeLegacy(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.legacy[id]; !ok {
+		return false
+	}
+	m, ok := s.lookupLocked(id)
+	if !ok {
+		return false
+	}
+	m.SchemaVersion = currentSchemaVersion
+	if blob := s.storeTranscriptLocked(&m, Metadata{}); blob != "" {
+		s.logBlobLocked(blob)
+	}
+	s.metadata[id] = m
+	delete(s.legacy, id)
+	s.changedLocked(changeUpdate, id)
+	return true
+}
+
+// lookupLocked finds a record, upgrading legacy ones. Callers hold s.mu.
+func (s *MemoryStore) lookupLocked(id string) (Metadata, bool) {
+	if m, ok := s.metadata[id]; ok {
+		return m, true
+	}
+	raw, ok := s.legacy[id]
+	if !ok {
+		return Metadata{}, false
+	}
+	m, err := upgradeRecord(raw)
+	if err != nil {
+		log.Printf("Skipping unreadable record %s: %v", id, err)
+		return Metadata{}, false
+	}
+	return m, true
+}
+
+// eachLocked calls fn for every record, upgrading legacy ones. Callers hold s.mu.
+func (s *MemoryStore) eachLocked(fn func(Metadata)) {
+	for _, m := range s.metadata {
+		fn(m)
+	}
+	for id := range s.legacy {
+		if m, ok := s.lookupLocked(id); ok {
+			fn(m)
+		}
+	}
+}
+
+// SaveBlob keeps the raw audio for a chunk so it can be reprocessed later.
+// With a write-ahead log the audio goes to its own file before the store
+// is locked, and only a reference to the file is logged under the lock.
+func (s *MemoryStore) SaveBlob(id string, data []byte) error {
+	s.mu.RLock()
+	w := s.wal
+	s.mu.RUnlock()
+	var file string
+	if w != nil {
+		name, err := w.writeBlobFile(data)
+		if err != nil {
+			return fmt.Errorf("writing blob %s: %w", id, err)
+		}
+		defer w.release(name)
+		file = name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[id] = data
+	s.blobSaved[id] = s.Clock.Now()
+	s.chargeLocked(id)
+	if w != nil && s.wal == w {
+		s.logBlobFileLocked(id, file)
+	} else {
+		s.logBlobLocked(id)
+	}
+	return nil
+}
+
+// GetBlob returns a chunk's audio, extracting it from the archive if it has
+// been moved there.
+func (s *MemoryStore) GetBlob(id string) ([]byte, error) {
+	s.mu.RLock()
+	data, ok := s.blobs[id]
+	m, _ := s.lookupLocked(id)
+	s.mu.RUnlock()
+	return s.blobOrArchive(id, data, ok, m)
+}
+
+func (s *MemoryStore) blobOrArchive(id string, data []byte, ok bool, m Metadata) ([]byte, error) {
+	if ok {
+		return data, nil
+	}
+	if m.Archive == nil || s.Archive == nil {
+		return nil, errBlobNotFound
+	}
+	data, err := s.Archive.Read(id, *m.Archive)
+	if err != nil {
+		return nil, fmt.Errorf("reading archived chunk %s: %w: %v", id, ErrBackendUnavailable, err)
+	}
+	return data, nil
+}
+
+// archiveCandidates returns visible chunks with hot blobs older than cutoff.
+func (s *MemoryStore) archiveCandidates(cutoff time.Time) []Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var result []Metadata
+	s.eachLocked(func(m Metadata) {
+		if _, hot := s.blobs[m.ChunkID]; hot && m.DeletedAt == nil && m.Timestamp.Before(cutoff) {
+			result = append(result, m)
+		}
+	})
+	return result
+}
+
+// markArchived records where a chunk's audio was archived and drops the
+// hot copy.
+func (s *MemoryStore) markArchived(id string, loc ArchiveLocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.lookupLocked(id)
+	if !ok {
+		return
+	}
+	m.Archive = &loc
+	m.SchemaVersion = currentSchemaVersion
+	s.metadata[id] = m
+	delete(s.legacy, id)
+	delete(s.blobs, id)
+	delete(s.blobSaved, id)
+	s.logBlobLocked(id)
+	s.changedLocked(changeUpdate, id)
+}
+
+// unarchive puts a chunk's audio back in the blob store.
+func (s *MemoryStore) unarchive(id string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.lookupLocked(id)
+	if !ok {
+		return
+	}
+	m.Archive = nil
+	m.SchemaVersion = currentSchemaVersion
+	s.metadata[id] = m
+	delete(s.legacy, id)
+	s.blobs[id] = data
+	s.blobSaved[id] = s.Clock.Now()
+	s.logBlobLocked(id)
+	s.changedLocked(changeUpdate, id)
+}
+
+// SaveReprocessCheckpoint records a reprocessing job's progress.
+func (s *MemoryStore) SaveReprocessCheckpoint(st ReprocessStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[st.ID] = st
+	s.logEntryLocked(walCheckpoints, st.ID, st)
+}
+
+// ReprocessCheckpoints returns the recorded progress of every job.
+func (s *MemoryStore) ReprocessCheckpoints() []ReprocessStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]ReprocessStatus, 0, len(s.checkpoints))
+	for _, st := range s.checkpoints {
+		result = append(result, st)
+	}
+	return result
+}
+
+// SessionAcks returns the WS acknowledgement state of a session, keyed by
+// sessionKey.
+func (s *MemoryStore) SessionAcks(key string) SessionAcks {
+	sh := s.sessionShard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.acks[key]
+}
+
+// RecordAck marks seq acknowledged in the session keyed by key.
+func (s *MemoryStore) RecordAck(key string, seq int64) {
+	sh := s.sessionShard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	acks := sh.acks[key].record(seq)
+	sh.acks[key] = acks
+	s.logAcks(key, acks)
+}
+
+// SessionExported reports when the session revision keyed by key was
+// exported by the AutoExporter, if it was.
+func (s *MemoryStore) SessionExported(key string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	at, ok := s.exported[key]
+	return at, ok
+}
+
+// MarkSessionExported records that the session revision keyed by key was
+// exported at at.
+func (s *MemoryStore) MarkSessionExported(key string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exported[key] = at
+	s.logEntryLocked(walExported, key, at)
+}
+
+// ForgetSessionExported drops the record MarkSessionExported made, once
+// the session revision can no longer come up for export.
+func (s *MemoryStore) ForgetSessionExported(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.exported, key)
+	s.logEntryLocked(walExported, key, nil)
+}
+
+// AddAnnotation attaches a to its chunk, which must exist and not be deleted.
+func (s *MemoryStore) AddAnnotation(a Annotation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.lookupLocked(a.ChunkID); !ok || m.DeletedAt != nil {
+		return errNotFound
+	}
+	s.annotations[a.ChunkID] = append(s.annotations[a.ChunkID], a)
+	s.logAnnotationsLocked(a.ChunkID)
+	return nil
+}
+
+// Annotations returns a chunk's annotations ordered by offset. They survive
+// a soft delete and are removed along with the chunk when it is purged.
+func (s *MemoryStore) Annotations(chunkID string) []Annotation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := append([]Annotation{}, s.annotations[chunkID]...)
+	sort.SliceStable(result, func(i, j int) bool { return result[i].OffsetMS < result[j].OffsetMS })
+	return result
+}
+
+// DeleteAnnotation removes an annotation and reports whether it existed.
+func (s *MemoryStore) DeleteAnnotation(chunkID, annotationID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.annotations[chunkID]
+	for i, a := range list {
+		if a.ID == annotationID {
+			s.annotations[chunkID] = append(list[:i:i], list[i+1:]...)
+			s.logAnnotationsLocked(chunkID)
+			return true
+		}
+	}
+	return false
+}
+
+// logAnnotationsLocked logs a chunk's annotations as they now stand.
+func (s *MemoryStore) logAnnotationsLocked(chunkID string) {
+	if list := s.annotations[chunkID]; len(list) > 0 {
+		s.logEntryLocked(walAnnotations, chunkID, list)
+		return
+	}
+	s.logEntryLocked(walAnnotations, chunkID, nil)
+}
+
+// Get returns errNotFound for missing and deleted chunks alike.
+func (s *MemoryStore) Get(id string) (Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.getLocked(id)
+}
+
+// owner returns the user a stored chunk belongs to, trashed or not.
+func (s *MemoryStore) owner(id string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, ok := s.lookupLocked(id)
+	return m.UserID, ok
+}
+
+func (s *MemoryStore) getLocked(id string) (Metadata, error) {
+	m, ok := s.lookupLocked(id)
+	if !ok || m.DeletedAt != nil {
+		return Metadata{}, errNotFound
+	}
+	return m, nil
+}
+
+// ListByUser returns a user's chunks, leaving out deleted ones.
+func (s *MemoryStore) ListByUser(userID string) []Metadata {
+	return s.listByUser(userID, false)
+}
+
+// ListByUserWithDeleted also returns records that are in the trash.
+func (s *MemoryStore) ListByUserWithDeleted(userID string) []Metadata {
+	return s.listByUser(userID, true)
+}
+
+func (s *MemoryStore) listByUser(userID string, includeDeleted bool) []Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listByUserLocked(userID, includeDeleted)
+}
+
+func (s *MemoryStore) listByUserLocked(userID string, includeDeleted bool) []Metadata {
+	var result []Metadata
+	visit := func(m Metadata) {
+		if m.UserID == userID && (includeDeleted || m.DeletedAt == nil) {
+			result = append(result, m)
+		}
+	}
+	chunks, ok := s.indexedLocked(func(x *indexes) map[string]map[string]bool { return x.byUser }, userID)
+	if !ok {
+		s.eachLocked(visit)
+	}
+	for _, m := range chunks {
+		visit(m)
+	}
+	return result
+}
+
+// List returns every visible chunk for which match reports true.
+func (s *MemoryStore) List(match func(Metadata) bool) []Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked(match)
+}
+
+func (s *MemoryStore) listLocked(match func(Metadata) bool) []Metadata {
+	var result []Metadata
+	s.eachLocked(func(m Metadata) {
+		if m.DeletedAt == nil && match(m) {
+			result = append(result, m)
+		}
+	})
+	return result
+}
+
+// Delete moves a chunk to the trash. It stays restorable until Retention
+// has passed, after which PurgeDeleted removes it for good.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.deleteLocked(id); err != nil {
+		return err
+	}
+	s.changedLocked(changeDelete, id)
+	return nil
+}
+
+func (s *MemoryStore) deleteLocked(id string) error {
+	m, ok := s.lookupLocked(id)
+	if !ok || m.DeletedAt != nil {
+		return errNotFound
+	}
+	now := s.Clock.Now()
+	m.DeletedAt = &now
+	m.SchemaVersion = currentSchemaVersion
+	s.metadata[id] = m
+	delete(s.legacy, id)
+	s.reheadLocked(m)
+	return nil
+}
+
+// Restore takes a chunk out of the trash within its retention window.
+func (s *MemoryStore) Restore(id string) (Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.restoreLocked(id)
+	if err != nil {
+		return Metadata{}, err
+	}
+	s.changedLocked(changeRestore, id)
+	return m, nil
+}
+
+func (s *MemoryStore) restoreLocked(id string) (Metadata, error) {
+	m, ok := s.lookupLocked(id)
+	if !ok {
+		return Metadata{}, errNotFound
+	}
+	if m.DeletedAt == nil {
+		return Metadata{}, errNotDeleted
+	}
+	if s.Clock.Now().Sub(*m.DeletedAt) > s.retentionLocked(m.UserID) {
+		return Metadata{}, errRetentionExpired
+	}
+	m.DeletedAt = nil
+	m.SchemaVersion = currentSchemaVersion
+	s.metadata[id] = m
+	delete(s.legacy, id)
+	s.reheadLocked(m)
+	return m, nil
+}
+
+// PurgeDeleted permanently removes trashed chunks older than Retention and
+// reports how many were removed.
+func (s *MemoryStore) PurgeDeleted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.Clock.Now()
+	purged := 0
+	for id, m := range s.metadata {
+		if m.DeletedAt != nil && now.Sub(*m.DeletedAt) > s.retentionLocked(m.UserID) {
+			s.unindexLocked(m)
+			delete(s.metadata, id)
+			delete(s.blobs, id)
+			delete(s.blobSaved, id)
+			delete(s.blobs, transcriptBlobID(id))
+			delete(s.blobSaved, transcriptBlobID(id))
+			if s.wal != nil {
+				delete(s.wal.files, id)
+				delete(s.wal.files, transcriptBlobID(id))
+			}
+			delete(s.revisions, id)
+			delete(s.annotations, id)
+			s.changedLocked(changePurge, id)
+			purged++
+		}
+	}
+	return purged
+}
+
+// RunTrashSweeper purges expired trash every interval until ctx ends,
+// skipping runs while ro is on.
+func RunTrashSweeper(ctx context.Context, store *MemoryStore, ro *ReadOnly, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ro.Enabled() {
+				continue
+			}
+			if n := store.PurgeDeleted(); n > 0 {
+				log.Printf("Purged %d deleted chunks", n)
+			}
+		}
+	}
+}
+
+// Job is a chunk queued for the workers; its Metadata is sent on Result.
+type Job struct {
+	Chunk  AudioChunk
+	Result chan Metadata
+	// done, when set, is called once the chunk is processed, before the
+	// result is sent; see Dispatcher.
+	done func()
+	// refused, when set, is sent the reason a Dispatcher turned the job
+	// away instead of queueing it; see MemoryBudget.
+	refused chan error
+}
+
+// submitJob runs chunk through the worker pool, giving up if ctx ends first.
+// A deadline the caller set on ctx travels with the chunk, so workers stop
+// on it too, and missing it is reported with how far the chunk got.
+func submitJob(ctx context.Context, jobs chan<- Job, chunk AudioChunk) (Metadata, error) {
+	if deadline, start, ok := clientDeadline(ctx); ok {
+		chunk.deadline = deadline
+		chunk.progress = newJobProgress(start)
+	}
+	result, refused := make(chan Metadata, 1), make(chan error, 1)
+	select {
+	case jobs <- Job{Chunk: chunk, Result: result, refused: refused}:
+	case <-ctx.Done():
+		return Metadata{}, jobAborted(ctx, chunk.progress)
+	}
+	select {
+	case meta := <-result:
+		if chunk.progress != nil && !time.Now().Before(chunk.deadline) {
+			// Cut short by the caller's deadline, so not worth saving.
+			return Metadata{}, jobAborted(ctx, chunk.progress)
+		}
+		return meta, nil
+	case err := <-refused:
+		return Metadata{}, err
+	case <-ctx.Done():
+		return Metadata{}, jobAborted(ctx, chunk.progress)
+	}
+}
+
+// TransformStage runs a worker with the default stub pipeline.
+func TransformStage(ctx context.Context, in <-chan Job) {
+	(&Pipeline{Transcriber: stubTranscriber{confidence: 1}}).Run(ctx, in)
+}
+
+// StartWorkers runs n pipeline workers until ctx is cancelled. The returned
+// WaitGroup completes once every worker has exited.
+func StartWorkers(ctx context.Context, p *Pipeline, n int, jobs <-chan Job) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Run(ctx, jobs)
+		}()
+	}
+	return &wg
+}
+
+// ingest processes a new chunk and stores both its metadata and audio. The
+// chunk is refused if it breaks rules. It counts as activity on its
+// session when sessions is non-nil, and is attributed to the client
+// address captured for ctx. Stores that are SessionIndexers number it
+// within its session.
+func ingest(ctx context.Context, store Store, jobs chan<- Job, sessions *SessionTracker, rules AudioRules, chunk AudioChunk) (meta Metadata, err error) {
+	if err := rules.check(chunk.Data); err != nil {
+		return Metadata{}, err
+	}
+	if err := checkAudio(chunk.Data); err != nil {
+		return Metadata{}, err
+	}
+	// Hash here rather than in a worker, whose time is better spent on
+	// analysis and transcription.
+	if chunk.Checksum == "" {
+		chunk.Checksum = chunkChecksum(chunk.Data)
+	}
+	if chunk.SourceIP == "" {
+		chunk.SourceIP = clientIPFrom(ctx)
+	}
+	if sessions != nil {
+		rev, err := sessions.Touch(chunk.UserID, chunk.SessionID, len(chunk.Data))
+		if err != nil {
+			return Metadata{}, err
+		}
+		chunk.SessionRevision = rev
+	}
+	settings, err := store.UserSettings(chunk.UserID)
+	if err != nil {
+		return Metadata{}, err
+	}
+	chunk.Settings = settings
+	if ix, ok := store.(SessionIndexer); ok {
+		index, release, claimErr := ix.ClaimSessionIndex(chunk.UserID, chunk.SessionID, chunk.ChunkID, chunk.Index)
+		if errors.Is(claimErr, errSeqTaken) {
+			// The client's seq is another chunk's index, given out by the
+			// server or claimed over another connection. The chunk is
+			// numbered after them instead, which the caller can tell from
+			// its index.
+			seqConflicts.Add(1)
+			index, release, claimErr = ix.ClaimSessionIndex(chunk.UserID, chunk.SessionID, chunk.ChunkID, 0)
+		}
+		if claimErr != nil {
+			return Metadata{}, claimErr
+		}
+		chunk.Index = index
+		// A chunk that is never saved gives its index back.
+		defer func() {
+			if err != nil {
+				release()
+			}
+		}()
+	}
+	meta, err = submitJob(ctx, jobs, chunk)
+	if err != nil {
+		return Metadata{}, err
+	}
+	// Read-only mode may have come on while the chunk was processed.
+	if ms, ok := store.(*MemoryStore); ok && ms.ReadOnly.Enabled() {
+		readOnlyRefusals.Add("in_flight", 1)
+		return Metadata{}, ms.ReadOnly.err()
+	}
+	if err := store.SaveBlob(meta.ChunkID, chunk.Data); err != nil {
+		return Metadata{}, err
+	}
+	if err := store.Save(meta); err != nil {
+		return Metadata{}, err
+	}
+	return meta, nil
+}
+
+// readUpload returns the audio bytes, their checksum and the raw client
+// metadata of an upload. Multipart bodies carry them in the "audio" and
+// "client_metadata" fields; otherwise the body is the audio and metadata
+// comes from X-Client-Metadata.
+func readUpload(r *http.Request) (data []byte, checksum string, clientMeta []byte, err error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, "", nil, err
+		}
+		file, _, err := r.FormFile("audio")
+		if err != nil {
+			return nil, "", nil, err
+		}
+		defer file.Close()
+		data, checksum, err := readHashed(file)
+		return data, checksum, []byte(r.FormValue("client_metadata")), err
+	}
+	data, checksum, err = readHashed(r.Body)
+	return data, checksum, []byte(r.Header.Get("X-Client-Metadata")), err
+}
+
+// uploadRecordedAt reads the recorded_at parameter of an upload, for
+// recordings made well before they are uploaded. It is taken as given,
+// without the clock skew correction WebSocket clients get.
+func uploadRecordedAt(r *http.Request) (*time.Time, error) {
+	v := r.URL.Query().Get("recorded_at")
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, invalidParam("recorded_at", "invalid_recorded_at", "must be an RFC 3339 time")
+	}
+	return &t, nil
+}
+
+// uploadPriority reads the priority parameter of an upload. Anyone may
+// demote their upload to batch; only admins may raise it to realtime.
+func uploadPriority(cfg Config, r *http.Request) (Priority, error) {
+	v := r.URL.Query().Get("priority")
+	if v == "" {
+		return PriorityInteractive, nil
+	}
+	p, err := parsePriority(v)
+	if err != nil {
+		return 0, invalidParam("priority", "invalid_priority", err.Error())
+	}
+	if p == PriorityRealtime && !isAdmin(cfg, r) {
+		return 0, errAdminRequired
+	}
+	return p, nil
+}
+
+func handleUpload(store *MemoryStore, jobs chan Job, sessions *SessionTracker, identity IdentityProvider, receipts *Receipts, quotas *SoftQuotas, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		sessionID := r.URL.Query().Get("session_id")
+		if err := validateIDs(cfg, userID, sessionID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := validateUser(r.Context(), identity, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		priority, err := uploadPriority(cfg, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		recordedAt, err := uploadRecordedAt(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		data, checksum, rawClientMeta, err := readUpload(r)
+		if isTimeout(err) {
+			writeReadTimeout(w)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "unreadable_body", "Failed to read body")
+			return
+		}
+		clientMeta, err := validateClientMetadata(rawClientMeta, cfg.MaxClientMetadataBytes)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := verifyChecksum(checksum, requestChecksum(r)); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if emptyAudio(data) && r.URL.Query().Get("allow_empty") == "true" {
+			sessions.KeepAlive(userID, sessionID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		chunkID, err := chunkIDFor(cfg, r.URL.Query().Get("chunk_id"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if r.URL.Query().Has("chunk_id") {
+			existing, release, err := store.claimChunkID(chunkID, userID, checksum)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			if existing != nil {
+				receipts.setHeader(w, existing.ChunkID, checksum, len(data), existing.Timestamp)
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(existing)
+				return
+			}
+			defer release()
+		}
+
+		chunk := AudioChunk{
+			ChunkID:   chunkID,
+			UserID:    userID,
+			SessionID: sessionID,
+			Timestamp: time.Now(),
+			Data:      data,
+			Checksum:  checksum,
+			Priority:  priority,
+
+			RecordedAt:     recordedAt,
+			ClientMetadata: clientMeta,
+		}
+
+		if children := splitChunk(chunk, cfg.MaxChunkDuration); children != nil {
+			// The rules judge the upload whole, not the pieces it is cut
+			// into, the last of which may be shorter than MinDuration.
+			if err := cfg.AudioRules.check(data); err != nil {
+				writeError(w, err)
+				return
+			}
+			metas, err := ingestSplit(r.Context(), store, jobs, sessions, children)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			receipts.setHeader(w, chunk.ChunkID, checksum, len(data), chunk.Timestamp)
+			quotas.setHeader(w, userID)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(splitUpload{ParentChunkID: chunk.ChunkID, Chunks: metas})
+			return
+		}
+
+		meta, err := ingest(r.Context(), store, jobs, sessions, cfg.AudioRules, chunk)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		receipts.setHeader(w, meta.ChunkID, checksum, len(data), chunk.Timestamp)
+		quotas.setHeader(w, userID)
+		w.Header().Set("X-Chunk-Location", cfg.PublicURL+"/chunks/"+meta.ChunkID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+	}
+}
+
+func handleGetChunk(reader ChunkReader, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		fields, ok := parseProjection(w, r)
+		if !ok {
+			return
+		}
+		meta, err := readChunk(reader, r, id)
+		if err != nil {
+			writeChunkError(w, reader, id, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fields.one(meta))
+	}
+}
+
+func handleDeleteChunk(store Store, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		meta, err := store.Get(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := store.Delete(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDeleteSession moves every chunk of a session to the trash in one
+// transaction, so a failure part way leaves the whole session in place.
+func handleDeleteSession(store Store, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := checkUserAccess(cfg, r, vars["user_id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		var deleted int
+		err := withTx(store, func(tx Store) error {
+			chunks := tx.List(func(m Metadata) bool {
+				return m.UserID == vars["user_id"] && m.SessionID == vars["session_id"]
+			})
+			if len(chunks) == 0 {
+				return fmt.Errorf("session %s: %w", vars["session_id"], ErrNotFound)
+			}
+			for _, m := range chunks {
+				if err := tx.Delete(m.ChunkID); err != nil {
+					return fmt.Errorf("deleting chunk %s: %w", m.ChunkID, err)
+				}
+			}
+			deleted = len(chunks)
+			return nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+	}
+}
+
+func handleRestoreChunk(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if owner, ok := store.owner(id); ok {
+			if err := checkUserAccess(cfg, r, owner); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		meta, err := store.Restore(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+	}
+}
+
+func handleGetUserSessions(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["user_id"]
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		fields, ok := parseProjection(w, r)
+		if !ok {
+			return
+		}
+		collapse := r.URL.Query().Get("collapse")
+		if collapse != "" && collapse != "children" {
+			writeJSONError(w, http.StatusBadRequest, "invalid_collapse", "collapse must be \"children\"")
+			return
+		}
+		if collapse != "" && (fields != nil || r.URL.Query().Get("include_annotations") == "true") {
+			writeJSONError(w, http.StatusBadRequest, "invalid_collapse", "collapse cannot be combined with fields or include_annotations")
+			return
+		}
+		var result []Metadata
+		if r.URL.Query().Get("include_deleted") == "true" {
+			if !isAdmin(cfg, r) {
+				writeError(w, errAdminRequired)
+				return
+			}
+			result = store.ListByUserWithDeleted(userID)
+		} else {
+			result = store.ListByUser(userID)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		withAnnotations := r.URL.Query().Get("include_annotations") == "true"
+		if collapse != "" {
+			json.NewEncoder(w).Encode(collapseChildren(result))
+			return
+		}
+		if fields != nil {
+			projected := make([]map[string]any, len(result))
+			for i, m := range result {
+				projected[i] = fields.apply(m)
+				if withAnnotations {
+					projected[i]["annotations"] = chunkAnnotations(store, m)
+				}
+			}
+			json.NewEncoder(w).Encode(projected)
+			return
+		}
+		if withAnnotations {
+			annotated := make([]annotatedChunk, len(result))
+			for i, m := range result {
+				annotated[i] = annotatedChunk{Metadata: m, Annotations: chunkAnnotations(store, m)}
+			}
+			json.NewEncoder(w).Encode(annotated)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// handleAdminChunks lists chunks across users, optionally narrowed by
+// user_id and by source_ip, which takes an address or a CIDR.
+func handleAdminChunks(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		userID := q.Get("user_id")
+		var source []netip.Prefix
+		if s := q.Get("source_ip"); s != "" {
+			var err error
+			if source, err = parsePrefixes(s); err != nil || len(source) != 1 {
+				writeJSONError(w, http.StatusBadRequest, "invalid_source_ip", "source_ip must be an IP address or CIDR")
+				return
+			}
+		}
+		result := store.List(func(m Metadata) bool {
+			if userID != "" && m.UserID != userID {
+				return false
+			}
+			if source != nil {
+				ip, ok := parseIP(m.SourceIP)
+				return ok && inPrefixes(ip, source)
+			}
+			return true
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func newRouter(s *Server) *mux.Router {
+	cfg, store, jobs := s.Config, s.Store, s.jobs
+	r := mux.NewRouter()
+	r.Use(logRequests, compressResponses(cfg), captureClientIP(cfg), checkChunkIDs(store), requireAuth(s.Keys), s.ReadOnly.Middleware, s.Concurrency.Middleware, routeTimeouts(cfg), requestDeadlines)
+	r.HandleFunc("/upload", s.Captures.Wrap(handleUpload(store, jobs, s.Sessions, s.Identity, s.Receipts, s.Quotas, cfg))).Methods("POST")
+	r.HandleFunc("/chunks/{id}", handleGetChunk(newChunkReader(cfg, store), cfg)).Methods("GET")
+	r.HandleFunc("/chunks/{id}", handleDeleteChunk(store, cfg)).Methods("DELETE")
+	r.HandleFunc("/chunks/{id}", handlePatchChunk(store, cfg)).Methods("PATCH")
+	r.HandleFunc("/chunks/{id}/revisions", handleListRevisions(store, cfg)).Methods("GET")
+	r.HandleFunc("/chunks/{id}/audio", s.Signer.signedAudio(s.Transcoder, handleGetAudio(store, s.Transcoder, cfg))).Methods("GET")
+	r.HandleFunc("/chunks/{id}/signed-url", handleCreateSignedURL(s.Signer, cfg)).Methods("POST")
+	r.HandleFunc("/chunks/{id}/trim", handleTrimChunk(store, jobs, cfg)).Methods("POST")
+	r.HandleFunc("/chunks/{id}/similar", handleSimilar(store, cfg)).Methods("GET")
+	r.HandleFunc("/search", handleSearch(store, cfg)).Methods("GET")
+	r.HandleFunc("/checksums/{sha256}", handleFindByChecksum(store, cfg)).Methods("GET")
+	r.HandleFunc("/chunks/{id}/annotations", handleAddAnnotation(store, cfg)).Methods("POST")
+	r.HandleFunc("/chunks/{id}/annotations", handleListAnnotations(store, cfg)).Methods("GET")
+	r.HandleFunc("/chunks/{id}/annotations/{annotation_id}", handleDeleteAnnotation(store, cfg)).Methods("DELETE")
+	r.HandleFunc("/chunks/{id}/transcript", handleGetTranscript(store, cfg)).Methods("GET")
+	r.HandleFunc("/chunks/{id}/restore", handleRestoreChunk(store, cfg)).Methods("POST")
+	r.HandleFunc("/chunks/{id}/review", handleReviewChunk(store, cfg)).Methods("POST")
+	r.HandleFunc("/review/queue", handleReviewQueue(store, s.Reviews, cfg)).Methods("GET")
+	r.HandleFunc("/chunks/{id}/compare", requireAdmin(cfg, handleCompareChunk(newChunkReader(cfg, store), store, s.Pipeline))).Methods("POST")
+	r.HandleFunc("/sessions/{user_id}", handleGetUserSessions(store, cfg)).Methods("GET")
+	r.HandleFunc("/sessions/{user_id}/{session_id}", handleDeleteSession(store, cfg)).Methods("DELETE")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/transcript", handleGetSessionTranscript(store, cfg)).Methods("GET")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/latest", handleLatestChunk(store, cfg)).Methods("GET")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/latest/transcript", handleLatestTranscript(store, cfg)).Methods("GET")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/unarchive", handleRestoreSession(s.Archive, cfg)).Methods("POST")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/share", requireAdmin(cfg, handleCreateShare(s.Shares, cfg))).Methods("POST")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/share/{share_id}", requireAdmin(cfg, handleRevokeShare(s.Shares))).Methods("DELETE")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/compare", requireAdmin(cfg, handleCompareSession(s.Comparisons, cfg))).Methods("POST")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/compare/{job_id}", requireAdmin(cfg, handleGetSessionComparison(s.Comparisons))).Methods("GET")
+	r.HandleFunc("/shared/{token}/chunks", handleSharedList(s.Shares)).Methods("GET")
+	r.HandleFunc("/shared/{token}/chunks/{id}", handleSharedChunk(s.Shares)).Methods("GET")
+	r.HandleFunc("/shared/{token}/chunks/{id}/audio", handleSharedAudio(s.Shares, s.Transcoder)).Methods("GET")
+	r.HandleFunc("/ws", handleWebSocket(store, jobs, s.Sessions, s.Identity, s.Keys, s.Receipts, s.ReadOnly, s.Goroutines, s.wsConns, s.Recorder, cfg)).Methods("GET")
+	r.HandleFunc("/sessions/{user_id}/{session_id}/observe", handleObserve(store, s.Events, s.Keys, s.wsConns, s.Goroutines, cfg)).Methods("GET")
+	r.HandleFunc("/ws/transcript", handleTranscriptSocket(store, s.Events, s.Keys, s.Goroutines, cfg)).Methods("GET")
+	r.HandleFunc("/events", handleEvents(s.Events, s.Goroutines, cfg)).Methods("GET")
+	r.HandleFunc("/users/{id}/settings", handleGetSettings(store, cfg)).Methods("GET")
+	r.HandleFunc("/users/{id}/settings", handlePutSettings(store, cfg)).Methods("PUT")
+	r.HandleFunc("/users/{id}/billing", handleGetBilling(store, cfg)).Methods("GET")
+	r.HandleFunc("/users/{id}/export", handleStartExport(s.Exports, cfg)).Methods("POST")
+	r.HandleFunc("/users/{id}/export/{job_id}", handleGetExport(s.Exports, cfg)).Methods("GET")
+	r.HandleFunc("/exports/{token}", handleDownloadExport(s.Exports)).Methods("GET")
+	r.HandleFunc("/changes", requireAdmin(cfg, handleChanges(store))).Methods("GET")
+	r.HandleFunc("/admin/reprocess", requireAdmin(cfg, handleStartReprocess(s.Reprocessor))).Methods("POST")
+	r.HandleFunc("/admin/reprocess/{job_id}", requireAdmin(cfg, handleGetReprocess(s.Reprocessor))).Methods("GET")
+	r.HandleFunc("/admin/reprocess/{job_id}", requireAdmin(cfg, handleCancelReprocess(s.Reprocessor))).Methods("DELETE")
+	r.HandleFunc("/admin/reprocess/{job_id}/resume", requireAdmin(cfg, handleResumeReprocess(s.Reprocessor))).Methods("POST")
+	r.HandleFunc("/admin/migrate", requireAdmin(cfg, handleMigrate(s.Migrator, store))).Methods("GET", "POST")
+	r.HandleFunc("/admin/chunks", requireAdmin(cfg, handleAdminChunks(store))).Methods("GET")
+	r.HandleFunc("/admin/sessions", requireAdmin(cfg, handleAdminSessions(store, s.wsConns))).Methods("GET")
+	r.HandleFunc("/admin/audit", requireAdmin(cfg, handleAudit(store))).Methods("GET")
+	r.HandleFunc("/admin/recordings/{user_id}/{session_id}", requireAdmin(cfg, handleSetRecording(s.Recorder))).Methods("PUT", "DELETE")
+	r.HandleFunc("/admin/recordings/{id}", requireAdmin(cfg, handleGetRecording(s.Recorder))).Methods("GET")
+	r.HandleFunc("/admin/replay", requireAdmin(cfg, handleReplay(store, jobs, s.Sessions, cfg.AudioRules))).Methods("POST")
+	r.HandleFunc("/admin/keys", requireAdmin(cfg, handleMintKey(s.Keys))).Methods("POST")
+	r.HandleFunc("/admin/keys", requireAdmin(cfg, handleListKeys(s.Keys))).Methods("GET")
+	r.HandleFunc("/admin/keys/{id}", requireAdmin(cfg, handleRevokeKey(s.Keys))).Methods("DELETE")
+	r.HandleFunc("/admin/goroutines", requireAdmin(cfg, handleGoroutines(s.Goroutines))).Methods("GET")
+	r.HandleFunc("/admin/billing", requireAdmin(cfg, handleBillingRollup(store))).Methods("GET")
+	r.HandleFunc("/admin/faults", requireAdmin(cfg, handleArmFault(s.Faults))).Methods("POST")
+	r.HandleFunc("/admin/faults", requireAdmin(cfg, handleListFaults(s.Faults))).Methods("GET")
+	r.HandleFunc("/admin/faults/{id}", requireAdmin(cfg, handleDisarmFault(s.Faults))).Methods("DELETE")
+	r.HandleFunc("/admin/webhooks", requireAdmin(cfg, handleCreateWebhook(s.Webhooks, cfg))).Methods("POST")
+	r.HandleFunc("/admin/webhooks", requireAdmin(cfg, handleListWebhooks(s.Webhooks))).Methods("GET")
+	r.HandleFunc("/admin/webhooks/{id}", requireAdmin(cfg, handleGetWebhook(s.Webhooks))).Methods("GET")
+	r.HandleFunc("/admin/webhooks/{id}", requireAdmin(cfg, handleUpdateWebhook(s.Webhooks, cfg))).Methods("PUT")
+	r.HandleFunc("/admin/webhooks/{id}", requireAdmin(cfg, handleDeleteWebhook(s.Webhooks))).Methods("DELETE")
+	r.HandleFunc("/admin/webhooks/{id}/deliveries", requireAdmin(cfg, handleWebhookDeliveries(s.Webhooks))).Methods("GET")
+	r.HandleFunc("/admin/reconcile", requireAdmin(cfg, handleReconcile(s.Reconciler))).Methods("GET")
+	r.HandleFunc("/admin/auto-exports", requireAdmin(cfg, handleAutoExports(s.AutoExports))).Methods("GET")
+	r.HandleFunc("/admin/read-only", requireAdmin(cfg, handleReadOnly(s.ReadOnly))).Methods("GET", "PUT")
+	r.HandleFunc("/admin/stages", requireAdmin(cfg, handleListStages(s.Pipeline.Stages))).Methods("GET")
+	r.HandleFunc("/admin/stages/{name}", requireAdmin(cfg, handleSetStage(s.Pipeline.Stages))).Methods("POST")
+	r.HandleFunc("/admin/captures", requireAdmin(cfg, handleListCaptures(s.Captures))).Methods("GET")
+	r.HandleFunc("/admin/captures/{id}/body", requireAdmin(cfg, handleCaptureBody(s.Captures))).Methods("GET")
+	r.HandleFunc("/readyz", handleReadyz(store, s.Concurrency, s.Pipeline.Stages, s.Warmups, s.ReadOnly)).Methods("GET")
+	r.HandleFunc("/healthz", handleHealthz(s.Alerts)).Methods("GET")
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	r.HandleFunc("/openapi.json", handleOpenAPI(cfg)).Methods("GET")
+	r.HandleFunc("/version", handleVersion).Methods("GET")
+	r.HandleFunc("/capabilities", handleCapabilities(s)).Methods("GET")
+	r.HandleFunc(receipt.KeysPath, handleReceiptKeys(s.Receipts)).Methods("GET")
+	if cfg.SwaggerUI {
+		r.HandleFunc("/docs", handleSwaggerUI).Methods("GET")
+	}
+	registerConnect(r, cfg, store, jobs, s.Sessions, s.Identity, s.ReadOnly)
+	return r
+}
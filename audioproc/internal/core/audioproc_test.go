@@ -0,0 +1,380 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func TestMemoryStore_SaveAndGet(t *testing.T) {
+	store := NewMemoryStore()
+
+	meta := Metadata{
+		ChunkID:    "chunk1",
+		UserID:     "user1",
+		SessionID:  "session1",
+		Timestamp:  time.Now(),
+		Checksum:   "checksum1",
+		FFT:        "100Hz",
+		Transcript: "Test transcript",
+	}
+
+	store.Save(meta)
+
+	retrievedMeta, err := store.Get("chunk1")
+	if err != nil {
+		t.Errorf("Expected metadata for chunk1, but got %v", err)
+	}
+
+	if retrievedMeta.ChunkID != meta.ChunkID {
+		t.Errorf("Expected ChunkID %v, but got %v", meta.ChunkID, retrievedMeta.ChunkID)
+	}
+}
+
+func TestMemoryStore_ListByUser(t *testing.T) {
+	store := NewMemoryStore()
+
+	meta1 := Metadata{
+		ChunkID:    "chunk1",
+		UserID:     "user1",
+		SessionID:  "session1",
+		Timestamp:  time.Now(),
+		Checksum:   "checksum1",
+		FFT:        "100Hz",
+		Transcript: "Test transcript",
+	}
+
+	meta2 := Metadata{
+		ChunkID:    "chunk2",
+		UserID:     "user1",
+		SessionID:  "session2",
+		Timestamp:  time.Now(),
+		Checksum:   "checksum2",
+		FFT:        "200Hz",
+		Transcript: "Test transcript 2",
+	}
+
+	store.Save(meta1)
+	store.Save(meta2)
+
+	metadataList := store.ListByUser("user1")
+	if len(metadataList) != 2 {
+		t.Errorf("Expected 2 metadata for user1, but got %v", len(metadataList))
+	}
+}
+
+func TestTransformStage(t *testing.T) {
+	in := make(chan Job, 1)
+	result := make(chan Metadata)
+
+	chunk := AudioChunk{
+		ChunkID:   "chunk1",
+		UserID:    "user1",
+		SessionID: "session1",
+		Timestamp: time.Now(),
+		Data:      []byte("audio data"),
+	}
+
+	job := Job{Chunk: chunk, Result: result}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go TransformStage(ctx, in)
+
+	in <- job
+
+	meta := <-result
+
+	if meta.ChunkID != chunk.ChunkID {
+		t.Errorf("Expected ChunkID %v, but got %v", chunk.ChunkID, meta.ChunkID)
+	}
+
+	if meta.Checksum == "" {
+		t.Errorf("Expected a checksum, but got an empty value")
+	}
+
+	if meta.Transcript == "" {
+		t.Errorf("Expected a transcript, but got an empty value")
+	}
+}
+
+func TestHandleUpload(t *testing.T) {
+	fileContent := SineWAV(440, time.Second, 16000)
+
+	req, err := http.NewRequest("POST", "/upload?user_id=user1&session_id=sess1", bytes.NewReader(fileContent))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	rr := httptest.NewRecorder()
+
+	store := NewMemoryStore()
+	jobs := make(chan Job, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go TransformStage(ctx, jobs)
+
+	handler := handleUpload(store, jobs, nil, nil, nil, nil, DefaultConfig())
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", rr.Code)
+	}
+
+	var response map[string]interface{}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+
+	if _, ok := response["chunk_id"]; !ok {
+		t.Errorf("Expected chunk_id in response, but got none")
+	}
+	if _, ok := response["checksum"]; !ok {
+		t.Errorf("Expected checksum in response, but got none")
+	}
+
+	checksum, _ := response["checksum"].(string)
+	expectedChecksum := fmt.Sprintf("%x", sha256.Sum256(fileContent))
+	if checksum != expectedChecksum {
+		t.Errorf("Expected checksum %v, but got %v", expectedChecksum, checksum)
+	}
+}
+
+func TestHandleGetChunk(t *testing.T) {
+	store := NewMemoryStore()
+
+	meta := Metadata{
+		ChunkID:    "chunk1",
+		UserID:     "user1",
+		SessionID:  "session1",
+		Timestamp:  time.Now(),
+		Checksum:   "checksum1",
+		FFT:        "100Hz",
+		Transcript: "Test transcript",
+	}
+	store.Save(meta)
+
+	req, err := http.NewRequest("GET", "/chunks/chunk1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": "chunk1"})
+
+	rr := httptest.NewRecorder()
+
+	handler := handleGetChunk(store, DefaultConfig())
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", rr.Code)
+	}
+
+	var response map[string]interface{}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+
+	if response["chunk_id"] != "chunk1" {
+		t.Errorf("Expected chunk_id 'chunk1', but got %v", response["chunk_id"])
+	}
+}
+
+func TestHandleGetUserSessions(t *testing.T) {
+	store := NewMemoryStore()
+
+	meta1 := Metadata{
+		ChunkID:    "chunk1",
+		UserID:     "user1",
+		SessionID:  "session1",
+		Timestamp:  time.Now(),
+		Checksum:   "checksum1",
+		FFT:        "100Hz",
+		Transcript: "Test transcript",
+	}
+
+	meta2 := Metadata{
+		ChunkID:    "chunk2",
+		UserID:     "user1",
+		SessionID:  "session2",
+		Timestamp:  time.Now(),
+		Checksum:   "checksum2",
+		FFT:        "200Hz",
+		Transcript: "Test transcript 2",
+	}
+
+	store.Save(meta1)
+	store.Save(meta2)
+
+	req, err := http.NewRequest("GET", "/sessions/user1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = mux.SetURLVars(req, map[string]string{"user_id": "user1"})
+
+	rr := httptest.NewRecorder()
+
+	handler := handleGetUserSessions(store, DefaultConfig())
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", rr.Code)
+	}
+
+	var response []map[string]interface{}
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+
+	if len(response) != 2 {
+		t.Errorf("Expected 2 sessions for user1, but got %v", len(response))
+	}
+}
+
+func TestSoftDeleteRestoreAndPurge(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	store.Clock = clock
+
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	cfg.TrashRetention = time.Hour
+	router := New(cfg, store, nil).Handler()
+
+	store.Save(Metadata{ChunkID: "chunk1", UserID: "user1", SessionID: "session1"})
+	store.Save(Metadata{ChunkID: "chunk2", UserID: "user1", SessionID: "session1"})
+
+	do := func(method, url string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("DELETE", "/chunks/chunk1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code 204 on delete, but got %v", rr.Code)
+	}
+	if rr := do("GET", "/chunks/chunk1", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected deleted chunk to be hidden, but got %v", rr.Code)
+	}
+	if got := len(store.ListByUser("user1")); got != 1 {
+		t.Errorf("Expected 1 visible chunk after delete, but got %v", got)
+	}
+
+	if rr := do("GET", "/sessions/user1?include_deleted=true", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected include_deleted to require admin, but got %v", rr.Code)
+	}
+	rr := do("GET", "/sessions/user1?include_deleted=true", http.Header{"X-Admin-Token": {"secret"}})
+	var listed []Metadata
+	json.NewDecoder(rr.Body).Decode(&listed)
+	if len(listed) != 2 {
+		t.Errorf("Expected 2 chunks with include_deleted, but got %v", len(listed))
+	}
+
+	if rr := do("POST", "/chunks/chunk1/restore", nil); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200 on restore, but got %v", rr.Code)
+	}
+	if rr := do("GET", "/chunks/chunk1", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected restored chunk to be visible, but got %v", rr.Code)
+	}
+
+	do("DELETE", "/chunks/chunk1", nil)
+	clock.Advance(30 * time.Minute)
+	if n := store.PurgeDeleted(); n != 0 {
+		t.Errorf("Expected nothing purged inside the window, but purged %v", n)
+	}
+	clock.Advance(time.Hour)
+	if rr := do("POST", "/chunks/chunk1/restore", nil); rr.Code != http.StatusGone {
+		t.Errorf("Expected status code 410 after the window, but got %v", rr.Code)
+	}
+	if n := store.PurgeDeleted(); n != 1 {
+		t.Errorf("Expected 1 chunk purged, but purged %v", n)
+	}
+	if rr := do("POST", "/chunks/chunk1/restore", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status code 404 after purge, but got %v", rr.Code)
+	}
+}
+
+func TestRoutesRefuseOtherUsers(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	chunk := uploadTo(t, h, "user1", "s1", []byte("chunk data"))
+	trashed := uploadTo(t, h, "user1", "s2", []byte("trashed data"))
+	h.Store.Delete(trashed.ChunkID)
+	h.Store.AddAnnotation(Annotation{ID: "a1", ChunkID: chunk.ChunkID, Text: "note"})
+	_, user1 := h.Keys.Mint("user1", nil, 0)
+	_, user2 := h.Keys.Mint("user2", nil, 0)
+
+	do := func(method, path, body, key string) int {
+		req, _ := http.NewRequest(method, h.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tc := range []struct{ method, path, body string }{
+		{"POST", "/upload?user_id=user1&session_id=s1", "other data"},
+		{"GET", "/chunks/" + chunk.ChunkID, ""},
+		{"DELETE", "/chunks/" + chunk.ChunkID, ""},
+		{"POST", "/chunks/" + trashed.ChunkID + "/restore", ""},
+		{"GET", "/chunks/" + chunk.ChunkID + "/audio", ""},
+		{"GET", "/chunks/" + chunk.ChunkID + "/transcript", ""},
+		{"GET", "/chunks/" + chunk.ChunkID + "/annotations", ""},
+		{"POST", "/chunks/" + chunk.ChunkID + "/annotations", `{"text": "other note"}`},
+		{"DELETE", "/chunks/" + chunk.ChunkID + "/annotations/a1", ""},
+		{"GET", "/sessions/user1", ""},
+		{"DELETE", "/sessions/user1/s1", ""},
+		{"GET", "/sessions/user1/s1/transcript", ""},
+		{"POST", "/sessions/user1/s1/unarchive", ""},
+	} {
+		if got := do(tc.method, tc.path, tc.body, user2); got != http.StatusForbidden {
+			t.Errorf("%s %s: expected status code %d for another user's key, but got %d", tc.method, tc.path, http.StatusForbidden, got)
+		}
+	}
+	for _, path := range []string{"/ws?user_id=user1&session_id=s1", "/sessions/user1/s1/observe", "/ws/transcript?user_id=user1&session_id=s1"} {
+		conn, resp, err := websocket.DefaultDialer.Dial(h.WSURL(path), http.Header{"Authorization": {"Bearer " + user2}})
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("%s: expected a handshake response, but got error %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected status code %d for another user's key, but got %d", path, http.StatusForbidden, resp.StatusCode)
+		}
+	}
+
+	if got := do("GET", "/chunks/"+chunk.ChunkID, "", user1); got != http.StatusOK {
+		t.Errorf("Expected the owner to read the chunk, but got status code %d", got)
+	}
+	if got := do("POST", "/chunks/"+trashed.ChunkID+"/restore", "", user1); got != http.StatusOK {
+		t.Errorf("Expected the owner to restore the chunk, but got status code %d", got)
+	}
+	if list := h.Store.ListByUser("user1"); len(list) != 2 {
+		t.Errorf("Expected the refused requests to change nothing, but user1 has %d chunks", len(list))
+	}
+	if notes := chunkAnnotations(h.Store, chunk); len(notes) != 1 {
+		t.Errorf("Expected the refused requests to leave 1 annotation, but got %+v", notes)
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Rules an upload's audio can break, named in AudioDetails.Rule.
+const (
+	audioRuleFormat     = "format"
+	audioRuleSampleRate = "sample_rate"
+	audioRuleDuration   = "max_duration"
+	audioRuleDecodable  = "decodable"
+	audioRuleNonEmpty   = "non_empty"
+	audioRuleMinBytes   = "min_bytes"
+	audioRuleMinLength  = "min_duration"
+)
+
+// audioRejections counts refused uploads by the rule they broke.
+var audioRejections = expvar.NewMap("audio_rejections")
+
+// audioFormatUnknown is the format of audio in no container the server
+// recognises, which the pipeline accepts as opaque bytes.
+const audioFormatUnknown = "unknown"
+
+// AudioRules limit the audio uploads accept, judged from the stream's
+// header. The zero value accepts anything.
+type AudioRules struct {
+	// Formats lists the accepted formats: wav, flac, or unknown for data in
+	// neither. Empty accepts every format.
+	Formats []string
+	// MinSampleRate and MaxSampleRate bound recognised formats' sample
+	// rates; zero leaves that end open.
+	MinSampleRate int
+	MaxSampleRate int
+	// MaxDuration refuses longer recognised audio outright, where
+	// Config.MaxChunkDuration would split it.
+	MaxDuration time.Duration
+	// MinBytes and MinDuration refuse audio too short to be worth
+	// processing; MinDuration applies to recognised formats only. Empty
+	// audio is always refused.
+	MinBytes    int
+	MinDuration time.Duration
+}
+
+// emptyAudio reports whether data holds nothing but whitespace, as from a
+// client that sent a blank body or frame.
+func emptyAudio(data []byte) bool {
+	return len(bytes.TrimSpace(data)) == 0
+}
+
+// AudioDetails is what the server could tell about audio it refused, sent
+// as the error's details so the client can see what to fix. Limit is the
+// configured bound of the broken Rule.
+type AudioDetails struct {
+	Format        string `json:"format"`
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	BitsPerSample int    `json:"bits_per_sample,omitempty"`
+	DurationMS    int64  `json:"duration_ms,omitempty"`
+	Rule          string `json:"rule"`
+	Limit         any    `json:"limit,omitempty"`
+}
+
+// inspectAudio fills in what data's header says about it. A header that
+// fails to parse leaves everything but the format empty.
+func inspectAudio(data []byte) AudioDetails {
+	for _, f := range audioFormats {
+		if len(data) >= len(f.magic) && string(data[:len(f.magic)]) == f.magic {
+			details := AudioDetails{Format: f.name}
+			if info, err := f.probe(data); err == nil {
+				details.SampleRate = info.SampleRate
+				details.Channels = info.Channels
+				details.BitsPerSample = info.BitsPerSample
+				details.DurationMS = info.Duration().Milliseconds()
+			}
+			return details
+		}
+	}
+	return AudioDetails{Format: audioFormatUnknown}
+}
+
+// check refuses data that breaks one of the rules, reporting the first.
+func (rules AudioRules) check(data []byte) error {
+	d := inspectAudio(data)
+	switch {
+	case emptyAudio(data):
+		d.Rule = audioRuleNonEmpty
+		return rejectAudio(d, "empty_audio", "the audio is empty; set allow_empty for heartbeats")
+	case rules.MinBytes > 0 && len(data) < rules.MinBytes:
+		d.Rule, d.Limit = audioRuleMinBytes, rules.MinBytes
+		return rejectAudio(d, "audio_too_short", fmt.Sprintf("audio is %d bytes, shorter than the minimum of %d", len(data), rules.MinBytes))
+	case d.SampleRate > 0 && rules.MinDuration > 0 && d.DurationMS < rules.MinDuration.Milliseconds():
+		d.Rule, d.Limit = audioRuleMinLength, rules.MinDuration.Milliseconds()
+		return rejectAudio(d, "audio_too_short", fmt.Sprintf("audio lasts %dms, shorter than the minimum of %dms", d.DurationMS, rules.MinDuration.Milliseconds()))
+	case len(rules.Formats) > 0 && !slices.Contains(rules.Formats, d.Format):
+		d.Rule, d.Limit = audioRuleFormat, rules.Formats
+		return rejectAudio(d, "unsupported_audio", fmt.Sprintf("%s audio is not accepted; send %s", d.Format, strings.Join(rules.Formats, " or ")))
+	case d.SampleRate > 0 && rules.MinSampleRate > 0 && d.SampleRate < rules.MinSampleRate:
+		d.Rule, d.Limit = audioRuleSampleRate, map[string]int{"min": rules.MinSampleRate, "max": rules.MaxSampleRate}
+		return rejectAudio(d, "unsupported_audio", fmt.Sprintf("sample rate %d Hz is below the minimum of %d Hz", d.SampleRate, rules.MinSampleRate))
+	case d.SampleRate > 0 && rules.MaxSampleRate > 0 && d.SampleRate > rules.MaxSampleRate:
+		d.Rule, d.Limit = audioRuleSampleRate, map[string]int{"min": rules.MinSampleRate, "max": rules.MaxSampleRate}
+		return rejectAudio(d, "unsupported_audio", fmt.Sprintf("sample rate %d Hz is above the maximum of %d Hz", d.SampleRate, rules.MaxSampleRate))
+	case rules.MaxDuration > 0 && d.DurationMS > rules.MaxDuration.Milliseconds():
+		d.Rule, d.Limit = audioRuleDuration, rules.MaxDuration.Milliseconds()
+		return rejectAudio(d, "audio_too_long", fmt.Sprintf("audio lasts %dms, longer than the maximum of %dms", d.DurationMS, rules.MaxDuration.Milliseconds()))
+	}
+	return nil
+}
+
+// rejectAudio logs a refused upload and builds its validation error.
+func rejectAudio(details AudioDetails, code, message string) *ValidationError {
+	b, _ := json.Marshal(details)
+	log.Printf("Rejected audio: %s %s", message, b)
+	audioRejections.Add(details.Rule, 1)
+	err := invalidField("audio", code, message)
+	err.Details = details
+	return err
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type audioRejection struct {
+	Error   string       `json:"error"`
+	Details AudioDetails `json:"details"`
+}
+
+func TestUploadRejectionDetails(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AudioRules = AudioRules{Formats: []string{"wav", "flac"}, MinSampleRate: 8000, MaxSampleRate: 48000, MaxDuration: time.Second}
+	h := NewHarness(cfg)
+	defer h.Close()
+	corrupt := toneFLAC()
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	tests := []struct {
+		name string
+		body []byte
+		code string
+		want AudioDetails
+	}{
+		{"opaque", []byte("not audio"), "unsupported_audio", AudioDetails{Format: "unknown", Rule: "format"}},
+		{"low rate", SineWAV(440, 500*time.Millisecond, 4000), "unsupported_audio",
+			AudioDetails{Format: "wav", SampleRate: 4000, Channels: 1, BitsPerSample: 16, DurationMS: 500, Rule: "sample_rate"}},
+		{"too long", SineWAV(440, 2*time.Second, 8000), "audio_too_long",
+			AudioDetails{Format: "wav", SampleRate: 8000, Channels: 1, BitsPerSample: 16, DurationMS: 2000, Rule: "max_duration"}},
+		{"corrupt", corrupt, "corrupt_audio", AudioDetails{Format: "flac", Rule: "decodable"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "application/octet-stream", bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var body audioRejection
+			json.NewDecoder(resp.Body).Decode(&body)
+			if resp.StatusCode != http.StatusUnprocessableEntity || body.Error != tt.code {
+				t.Fatalf("Expected 422 %s, but got %d %+v", tt.code, resp.StatusCode, body)
+			}
+			got := body.Details
+			got.Limit = nil
+			if tt.name == "corrupt" {
+				// The stream header is intact, so only the format and rule are pinned.
+				got = AudioDetails{Format: got.Format, Rule: got.Rule}
+			}
+			if got != tt.want {
+				t.Errorf("Expected details %+v, but got %+v", tt.want, body.Details)
+			}
+		})
+	}
+}
+
+func TestUploadRejectionLimits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AudioRules = AudioRules{MaxSampleRate: 16000, MaxDuration: time.Second}
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	var body struct {
+		Details struct {
+			Rule  string          `json:"rule"`
+			Limit json.RawMessage `json:"limit"`
+		} `json:"details"`
+	}
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(SineWAV(440, 100*time.Millisecond, 44100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if body.Details.Rule != "sample_rate" || string(body.Details.Limit) != `{"max":16000,"min":0}` {
+		t.Errorf("Expected the sample rate limits, but got %+v", body.Details)
+	}
+	// Opaque data is still accepted when no formats are configured.
+	uploadTo(t, h, "user1", "s1", []byte("opaque"))
+}
+
+func TestWebSocketRejectionDetails(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AudioRules = AudioRules{MaxDuration: time.Second}
+	h := NewHarness(cfg)
+	defer h.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.WriteMessage(websocket.BinaryMessage, SineWAV(440, 3*time.Second, 8000))
+	var frame struct {
+		Type    string       `json:"type"`
+		Error   string       `json:"error"`
+		Details AudioDetails `json:"details"`
+	}
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Type != "error" || frame.Error != "audio_too_long" || frame.Details.DurationMS != 3000 || frame.Details.Limit != float64(1000) {
+		t.Errorf("Expected an audio_too_long frame with details, but got %+v", frame)
+	}
+}
+
+func TestShortUploadsRejected(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AudioRules = AudioRules{MinBytes: 8, MinDuration: 100 * time.Millisecond}
+	h := NewHarness(cfg)
+	defer h.Close()
+	empties := expvarInt(audioRejections, audioRuleNonEmpty)
+
+	post := func(query string, body []byte) (int, string) {
+		t.Helper()
+		resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1"+query, "application/octet-stream", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var rejection audioRejection
+		json.NewDecoder(resp.Body).Decode(&rejection)
+		return resp.StatusCode, rejection.Error
+	}
+	tests := []struct {
+		name   string
+		body   []byte
+		status int
+		code   string
+	}{
+		{"empty", nil, http.StatusUnprocessableEntity, "empty_audio"},
+		{"whitespace", []byte(" \r\n\t"), http.StatusUnprocessableEntity, "empty_audio"},
+		{"one byte", []byte("x"), http.StatusUnprocessableEntity, "audio_too_short"},
+		{"just over the byte minimum", []byte("12345678"), http.StatusOK, ""},
+		{"short WAV", SineWAV(440, 99*time.Millisecond, 8000), http.StatusUnprocessableEntity, "audio_too_short"},
+		{"just over the duration minimum", SineWAV(440, 101*time.Millisecond, 8000), http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		if status, code := post("", tt.body); status != tt.status || code != tt.code {
+			t.Errorf("%s: expected %d %q, but got %d %q", tt.name, tt.status, tt.code, status, code)
+		}
+	}
+	if n := h.Store.List(func(Metadata) bool { return true }); len(n) != 2 {
+		t.Errorf("Expected only the two long enough uploads stored, but got %d", len(n))
+	}
+	if status, _ := post("&allow_empty=true", nil); status != http.StatusNoContent {
+		t.Errorf("Expected a heartbeat to get 204, but got %v", status)
+	}
+	if got := expvarInt(audioRejections, audioRuleNonEmpty) - empties; got != 2 {
+		t.Errorf("Expected two empty rejections counted, but got %d", got)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send := func(env wsEnvelope) map[string]any {
+		t.Helper()
+		conn.WriteJSON(env)
+		var reply map[string]any
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	if reply := send(wsEnvelope{Type: "chunk"}); reply["error"] != "empty_audio" {
+		t.Errorf("Expected an empty frame refused, but got %v", reply)
+	}
+	if reply := send(wsEnvelope{Type: "chunk", AllowEmpty: true}); reply["type"] != "heartbeat" {
+		t.Errorf("Expected a heartbeat reply, but got %v", reply)
+	}
+	if reply := send(wsEnvelope{Type: "chunk", Data: []byte("x")}); reply["error"] != "audio_too_short" {
+		t.Errorf("Expected a one-byte frame refused, but got %v", reply)
+	}
+	if reply := send(wsEnvelope{Type: "chunk", Data: []byte("12345678")}); reply["ack"] != true {
+		t.Errorf("Expected a long enough frame acked, but got %v", reply)
+	}
+}
+
+func TestAudioRulesHoldForEveryIngest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AudioRules = AudioRules{MinDuration: 200 * time.Millisecond}
+	cfg.MaxChunkDuration = 500 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	// The last piece of a split upload is shorter than the minimum, but
+	// the upload as a whole is not.
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(SineWAV(440, 1100*time.Millisecond, 8000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		t.Fatalf("Expected the split upload to be accepted, but got %d", resp.StatusCode)
+	}
+
+	source := uploadTo(t, h, "user1", "s2", SineWAV(440, 400*time.Millisecond, 8000))
+	if status, _ := postTrim(t, h, source.ChunkID, `{"start_ms": 0, "end_ms": 100}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a trim shorter than the minimum to be refused, but got %d", status)
+	}
+	if status, _ := postTrim(t, h, source.ChunkID, `{"start_ms": 0, "end_ms": 300}`); status != http.StatusCreated {
+		t.Errorf("Expected a long enough trim to be accepted, but got %d", status)
+	}
+}
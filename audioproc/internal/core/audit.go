@@ -0,0 +1,69 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// maxAuditEvents bounds the in-memory audit log; the oldest events go first.
+const maxAuditEvents = 100000
+
+// AuditEvent records who touched whose data. Actor is the authenticated
+// user, "admin" for the admin token, "share:<id>" for a share link, or
+// "signed_url" for a signed audio URL.
+type AuditEvent struct {
+	At        time.Time `json:"at"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	UserID    string    `json:"user_id,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	ChunkID   string    `json:"chunk_id,omitempty"`
+}
+
+// requestActor names the caller of an authenticated request for the audit log.
+func requestActor(r *http.Request) string {
+	if id := authUserID(r.Context()); id != "" {
+		return id
+	}
+	return "admin"
+}
+
+// RecordAudit appends e to the audit log.
+func (s *MemoryStore) RecordAudit(e AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.At.IsZero() {
+		e.At = s.Clock.Now()
+	}
+	s.audit = append(s.audit, e)
+	if len(s.audit) > maxAuditEvents {
+		s.audit = s.audit[len(s.audit)-maxAuditEvents:]
+	}
+}
+
+// AuditEvents returns the events for which match reports true, oldest first.
+func (s *MemoryStore) AuditEvents(match func(AuditEvent) bool) []AuditEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := []AuditEvent{}
+	for _, e := range s.audit {
+		if match(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// handleAudit lists audit events, optionally narrowed by user_id and actor.
+func handleAudit(store *MemoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		userID, actor := q.Get("user_id"), q.Get("actor")
+		events := store.AuditEvents(func(e AuditEvent) bool {
+			return (userID == "" || e.UserID == userID) && (actor == "" || e.Actor == actor)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events)
+	}
+}
@@ -0,0 +1,332 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// autoExportStats counts scheduled export runs and what they wrote.
+var autoExportStats = expvar.NewMap("auto_exports")
+
+// autoExportRunsKept bounds the history served by GET /admin/auto-exports.
+const autoExportRunsKept = 50
+
+// maxRunErrors caps the errors a run keeps for its report.
+const maxRunErrors = 10
+
+// ObjectStore is where scheduled exports are written, such as an S3
+// bucket. Put replaces any object already at key.
+type ObjectStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// DirObjectStore is an ObjectStore on the local filesystem, keys being
+// slash-separated paths under the directory, as for a mounted bucket.
+type DirObjectStore string
+
+// errObjectKey refuses a key that would name a file outside the
+// directory.
+var errObjectKey = errors.New("object key must be a relative path without dot segments")
+
+// path maps key to a file under the directory. Keys are built from user,
+// session and chunk IDs, so they are checked segment by segment as well
+// as after cleaning.
+func (d DirObjectStore) path(key string) (string, error) {
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." || strings.ContainsRune(seg, '\\') {
+			return "", fmt.Errorf("%w: %q", errObjectKey, key)
+		}
+	}
+	rel := filepath.FromSlash(key)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %q", errObjectKey, key)
+	}
+	return filepath.Join(string(d), rel), nil
+}
+
+// Put writes beside the destination and renames, so a failed write leaves
+// no partial object.
+func (d DirObjectStore) Put(ctx context.Context, key string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// AutoExportRun reports one scheduled export. The window is the time
+// since the previous run; sessions closed in it are exported, along with
+// earlier ones whose export failed. Skipped counts sessions in the window
+// that were already exported.
+type AutoExportRun struct {
+	ID          string    `json:"run_id"`
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Exported    int       `json:"exported"`
+	Skipped     int       `json:"skipped"`
+	Failed      int       `json:"failed"`
+	Objects     int       `json:"objects"`
+	Bytes       int64     `json:"bytes"`
+	Errors      []string  `json:"errors,omitempty"`
+}
+
+// AutoExportStatus is the body of GET /admin/auto-exports, runs newest
+// first.
+type AutoExportStatus struct {
+	Schedule string          `json:"schedule,omitempty"`
+	NextRun  *time.Time      `json:"next_run,omitempty"`
+	Runs     []AutoExportRun `json:"runs"`
+}
+
+// AutoExportManifest is a session bundle's manifest.json, written after
+// every other object in it, so its presence marks a complete bundle.
+type AutoExportManifest struct {
+	UserID     string       `json:"user_id"`
+	SessionID  string       `json:"session_id"`
+	Revision   int          `json:"revision"`
+	ClosedAt   time.Time    `json:"closed_at"`
+	ExportedAt time.Time    `json:"exported_at"`
+	RunID      string       `json:"run_id"`
+	Files      []ExportFile `json:"files"`
+}
+
+// AutoExporter exports closed sessions to Objects on a cron schedule,
+// each as a bundle of chunk metadata in NDJSON, the chunks' audio and a
+// manifest under prefix/<closed date>/<user>/<session>/r<revision>/.
+// Exported sessions are marked in the store, so a session is exported
+// once however many runs see it; one that fails is tried again next run.
+type AutoExporter struct {
+	Objects ObjectStore
+	Clock   Clock
+
+	store    *MemoryStore
+	sessions *SessionTracker
+	schedule *CronSchedule
+	prefix   string
+
+	mu   sync.Mutex
+	from time.Time // start of the next run's window
+	next time.Time // when the next run is due
+	runs []AutoExportRun
+}
+
+// NewAutoExporter writes to cfg.AutoExportDir unless Objects is replaced.
+// It does nothing without cfg.AutoExportSchedule; with one, sessions keeps
+// closed sessions until they are exported. Call it before sessions is in
+// use.
+func NewAutoExporter(cfg Config, store *MemoryStore, sessions *SessionTracker) *AutoExporter {
+	a := &AutoExporter{Clock: realClock{}, store: store, sessions: sessions, schedule: cfg.AutoExportSchedule, prefix: cfg.AutoExportPrefix}
+	sessions.keepClosed = a.schedule != nil
+	if cfg.AutoExportDir != "" {
+		a.Objects = DirObjectStore(cfg.AutoExportDir)
+	}
+	return a
+}
+
+func (a *AutoExporter) enabled() bool {
+	return a.schedule != nil && a.Objects != nil
+}
+
+// Tick runs an export if one is due and reports whether it did. The first
+// call starts the schedule. Runs missed while the server was down fold
+// into the next one.
+func (a *AutoExporter) Tick(ctx context.Context) (AutoExportRun, bool) {
+	if !a.enabled() {
+		return AutoExportRun{}, false
+	}
+	now := a.Clock.Now()
+	a.mu.Lock()
+	if a.next.IsZero() {
+		a.from, a.next = now, a.schedule.Next(now)
+	}
+	if a.next.IsZero() || now.Before(a.next) {
+		a.mu.Unlock()
+		return AutoExportRun{}, false
+	}
+	from, to := a.from, a.next
+	for n := a.schedule.Next(to); !n.IsZero() && !n.After(now); n = a.schedule.Next(n) {
+		to = n
+	}
+	a.from, a.next = to, a.schedule.Next(to)
+	a.mu.Unlock()
+	return a.Run(ctx, from, to), true
+}
+
+// Run exports the sessions closed before to that are not exported yet.
+// Exported sessions that closed before from can no longer be skipped by a
+// run, so both the tracker and the store forget them.
+func (a *AutoExporter) Run(ctx context.Context, from, to time.Time) AutoExportRun {
+	run := AutoExportRun{ID: uuid.NewString(), WindowStart: from, WindowEnd: to, StartedAt: a.Clock.Now()}
+	for _, s := range a.sessions.ClosedBefore(to) {
+		key := sessionKey(s.UserID, s.SessionID) + "#" + strconv.Itoa(s.Revision)
+		if _, done := a.store.SessionExported(key); done {
+			if s.ClosedAt.Before(from) {
+				a.sessions.Forget(s)
+				a.store.ForgetSessionExported(key)
+			} else {
+				run.Skipped++
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		objects, n, err := a.exportSession(ctx, run.ID, s)
+		run.Objects += objects
+		run.Bytes += n
+		if err != nil {
+			run.Failed++
+			if len(run.Errors) < maxRunErrors {
+				run.Errors = append(run.Errors, key+": "+err.Error())
+			}
+			continue
+		}
+		a.store.MarkSessionExported(key, a.Clock.Now())
+		run.Exported++
+	}
+	run.FinishedAt = a.Clock.Now()
+
+	autoExportStats.Add("runs", 1)
+	autoExportStats.Add("sessions", int64(run.Exported))
+	autoExportStats.Add("failures", int64(run.Failed))
+	autoExportStats.Add("objects", int64(run.Objects))
+	autoExportStats.Add("bytes", run.Bytes)
+	if run.Failed > 0 {
+		log.Printf("Auto export %s: %d sessions failed and will be retried next run", run.ID, run.Failed)
+	}
+	a.mu.Lock()
+	a.runs = append([]AutoExportRun{run}, a.runs[:min(len(a.runs), autoExportRunsKept-1)]...)
+	a.mu.Unlock()
+	return run
+}
+
+// exportSession writes one session revision's bundle and returns how many
+// objects and bytes it wrote.
+func (a *AutoExporter) exportSession(ctx context.Context, runID string, s SessionSummary) (objects int, n int64, err error) {
+	chunks := slices.DeleteFunc(a.store.ListBySession(s.UserID, s.SessionID), func(m Metadata) bool {
+		return m.SessionRevision != s.Revision
+	})
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Timestamp.Before(chunks[j].Timestamp) })
+	base := a.prefix + s.ClosedAt.UTC().Format("2006-01-02") + "/" + s.UserID + "/" + s.SessionID + "/r" + strconv.Itoa(s.Revision) + "/"
+	manifest := AutoExportManifest{UserID: s.UserID, SessionID: s.SessionID, Revision: s.Revision, ClosedAt: s.ClosedAt, RunID: runID}
+	put := func(name string, data []byte, records int) error {
+		if err := a.Objects.Put(ctx, base+name, data); err != nil {
+			return err
+		}
+		objects++
+		n += int64(len(data))
+		if name != "manifest.json" {
+			sum := sha256.Sum256(data)
+			manifest.Files = append(manifest.Files, ExportFile{Name: name, Records: records, Bytes: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+		}
+		return nil
+	}
+
+	var ndjson bytes.Buffer
+	enc := json.NewEncoder(&ndjson)
+	for _, m := range chunks {
+		enc.Encode(m)
+	}
+	if err := put("metadata.ndjson", ndjson.Bytes(), len(chunks)); err != nil {
+		return objects, n, err
+	}
+	for _, m := range chunks {
+		data, err := a.store.GetBlob(m.ChunkID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err == nil {
+			err = put("audio/"+m.ChunkID+audioExtension(data), data, 0)
+		}
+		if err != nil {
+			return objects, n, err
+		}
+	}
+	manifest.ExportedAt = a.Clock.Now()
+	raw, _ := json.MarshalIndent(manifest, "", "  ")
+	return objects, n, put("manifest.json", raw, 0)
+}
+
+// Status returns the schedule, when it next runs and the recent runs.
+func (a *AutoExporter) Status() AutoExportStatus {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := AutoExportStatus{Runs: append([]AutoExportRun{}, a.runs...)}
+	if a.schedule != nil {
+		st.Schedule = a.schedule.String()
+	}
+	if !a.next.IsZero() && a.enabled() {
+		next := a.next
+		st.NextRun = &next
+	}
+	return st
+}
+
+// RunAutoExports runs scheduled exports until ctx ends, checking every
+// minute, the schedule's resolution. While ro is on nothing runs; windows
+// missed meanwhile fold into the next run.
+func RunAutoExports(ctx context.Context, a *AutoExporter, ro *ReadOnly) {
+	if !a.enabled() {
+		return
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	if !ro.Enabled() {
+		a.Tick(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !ro.Enabled() {
+				a.Tick(ctx)
+			}
+		}
+	}
+}
+
+func handleAutoExports(a *AutoExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.Status())
+	}
+}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyObjects fails writes under prefixes listed in failing.
+type flakyObjects struct {
+	DirObjectStore
+	failing []string
+}
+
+func (f *flakyObjects) Put(ctx context.Context, key string, data []byte) error {
+	for _, p := range f.failing {
+		if strings.Contains(key, p) {
+			return errors.New("bucket unavailable")
+		}
+	}
+	return f.DirObjectStore.Put(ctx, key, data)
+}
+
+func TestAutoExportOverTwoWindows(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AutoExportSchedule, _ = ParseCronSchedule("0 2 * * *")
+	clock := NewFakeClock(time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	sessions := NewSessionTracker(cfg, clock)
+	a := NewAutoExporter(cfg, store, sessions)
+	dir := t.TempDir()
+	objects := &flakyObjects{DirObjectStore: DirObjectStore(dir), failing: []string{"/user2/"}}
+	a.Objects, a.Clock = objects, clock
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	closeSession := func(user, session, chunkID string) {
+		rev, _ := sessions.Touch(user, session, len(wav))
+		store.Save(Metadata{ChunkID: chunkID, UserID: user, SessionID: session, SessionRevision: rev, Timestamp: clock.Now()})
+		store.SaveBlob(chunkID, wav)
+		sessions.End(user, session)
+	}
+	ctx := context.Background()
+	runsBefore := expvarInt(autoExportStats, "runs")
+
+	if _, ran := a.Tick(ctx); ran {
+		t.Fatal("Expected the first tick only to start the schedule")
+	}
+	closeSession("user1", "s1", "c1")
+	closeSession("user2", "s1", "c2")
+	clock.Advance(time.Hour)
+	if _, ran := a.Tick(ctx); ran {
+		t.Fatal("Expected no run before 02:00")
+	}
+
+	clock.Advance(15 * time.Hour) // 2024-06-02 02:00
+	first, ran := a.Tick(ctx)
+	if !ran || first.Exported != 1 || first.Failed != 1 || first.Objects != 3 || len(first.Errors) != 1 {
+		t.Fatalf("Expected user1's session exported and user2's failed, but got %+v", first)
+	}
+	if !first.WindowEnd.Equal(time.Date(2024, 6, 2, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the window to end at 02:00, but got %v", first.WindowEnd)
+	}
+	bundle := filepath.Join(dir, "sessions", "2024-06-01", "user1", "s1", "r1")
+	raw, err := os.ReadFile(filepath.Join(bundle, "manifest.json"))
+	var manifest AutoExportManifest
+	json.Unmarshal(raw, &manifest)
+	if err != nil || manifest.RunID != first.ID || len(manifest.Files) != 2 || manifest.Files[0].Name != "metadata.ndjson" || manifest.Files[0].Records != 1 {
+		t.Fatalf("Expected a manifest listing the metadata and audio, but got %+v %v", manifest, err)
+	}
+	if audio, err := os.ReadFile(filepath.Join(bundle, "audio", "c1.wav")); err != nil || len(audio) != len(wav) {
+		t.Errorf("Expected the chunk's audio in the bundle, but got %d bytes %v", len(audio), err)
+	}
+
+	// Running the same window again exports nothing twice.
+	if again := a.Run(ctx, first.WindowStart, first.WindowEnd); again.Exported != 0 || again.Skipped != 1 || again.Failed != 1 {
+		t.Errorf("Expected user1's session skipped, but got %+v", again)
+	}
+
+	// Next night the failed session is retried with the new one, and
+	// user1's, exported before that window, is forgotten.
+	objects.failing = nil
+	clock.Advance(8 * time.Hour)
+	closeSession("user1", "s2", "c3")
+	clock.Advance(16 * time.Hour) // 2024-06-03 02:00
+	second, ran := a.Tick(ctx)
+	if !ran || second.Exported != 2 || second.Failed != 0 || second.Skipped != 0 || !second.WindowStart.Equal(first.WindowEnd) {
+		t.Fatalf("Expected the retry and the new session exported, but got %+v", second)
+	}
+	if _, ok := store.SessionExported("user1/s1#1"); ok || len(sessions.ClosedBefore(second.WindowEnd)) != 2 {
+		t.Errorf("Expected the session exported before the window forgotten, but got %+v", sessions.ClosedBefore(second.WindowEnd))
+	}
+	for _, path := range []string{"2024-06-01/user2/s1/r1/manifest.json", "2024-06-02/user1/s2/r1/audio/c3.wav"} {
+		if _, err := os.Stat(filepath.Join(dir, "sessions", filepath.FromSlash(path))); err != nil {
+			t.Errorf("Expected %s to be written: %v", path, err)
+		}
+	}
+
+	// Nights missed while the server was down fold into one run.
+	clock.Advance(72 * time.Hour)
+	late, ran := a.Tick(ctx)
+	if !ran || late.Exported != 0 || !late.WindowEnd.Equal(time.Date(2024, 6, 6, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected one run up to the latest 02:00, but got %+v", late)
+	}
+	if got := expvarInt(autoExportStats, "runs") - runsBefore; got != 4 {
+		t.Errorf("Expected 4 runs counted, but got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	handleAutoExports(a)(rec, httptest.NewRequest("GET", "/admin/auto-exports", nil))
+	var status AutoExportStatus
+	json.NewDecoder(rec.Body).Decode(&status)
+	if status.Schedule != "0 2 * * *" || len(status.Runs) != 4 || status.Runs[0].ID != late.ID || status.NextRun == nil || !status.NextRun.Equal(time.Date(2024, 6, 7, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the recent runs newest first, but got %+v", status)
+	}
+}
+
+func TestDirObjectStoreRefusesEscapingKeys(t *testing.T) {
+	root := t.TempDir()
+	d := DirObjectStore(filepath.Join(root, "bucket"))
+	for _, key := range []string{"../outside", "sessions/../../outside", "sessions/./x", "/abs", "a//b", `a\..\..\b`, ""} {
+		if err := d.Put(context.Background(), key, []byte("x")); !errors.Is(err, errObjectKey) {
+			t.Errorf("%q: expected the key refused, but got %v", key, err)
+		}
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Errorf("Expected nothing written, but got %v", entries)
+	}
+	if err := d.Put(context.Background(), "sessions/u/s/r1/manifest.json", []byte("x")); err != nil {
+		t.Errorf("Expected a plain key written, but got %v", err)
+	}
+}
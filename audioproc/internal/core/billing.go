@@ -0,0 +1,154 @@
+package core
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const billingMonthLayout = "2006-01"
+
+var billedSeconds = expvar.NewFloat("transcription_billed_seconds")
+
+// BillingUsage is the transcription a user was billed for in a month.
+type BillingUsage struct {
+	UserID  string  `json:"user_id,omitempty"`
+	Month   string  `json:"month"`
+	Chunks  int     `json:"chunks"`
+	Seconds float64 `json:"billable_seconds"`
+	Cost    float64 `json:"cost"`
+}
+
+func (u *BillingUsage) add(other BillingUsage) {
+	u.Chunks += other.Chunks
+	u.Seconds += other.Seconds
+	u.Cost += other.Cost
+}
+
+// BillingMeter charges users for the audio sent to the transcriber, at the
+// rate in force when each chunk is transcribed.
+type BillingMeter struct {
+	Clock Clock
+
+	store       *MemoryStore
+	rate        float64
+	reprocesses bool
+}
+
+// NewBillingMeter charges usage to store at cfg.TranscriptionRate.
+func NewBillingMeter(cfg Config, store *MemoryStore) *BillingMeter {
+	return &BillingMeter{Clock: realClock{}, store: store, rate: cfg.TranscriptionRate, reprocesses: cfg.BillReprocessing}
+}
+
+// Record bills chunk's user for durationMS of decoded audio. A nil meter,
+// audio that could not be decoded and, unless configured otherwise,
+// reprocessing are not billed.
+func (b *BillingMeter) Record(chunk AudioChunk, durationMS int64) {
+	if b == nil || durationMS <= 0 || (chunk.Reprocess && !b.reprocesses) {
+		return
+	}
+	seconds := float64(durationMS) / 1000
+	month := b.Clock.Now().UTC().Format(billingMonthLayout)
+	b.store.AddUsage(BillingUsage{UserID: chunk.UserID, Month: month, Chunks: 1, Seconds: seconds, Cost: seconds * b.rate})
+	billedSeconds.Add(seconds)
+}
+
+func usageKey(userID, month string) string {
+	return userID + "\x00" + month
+}
+
+// AddUsage adds u to its user's total for the month.
+func (s *MemoryStore) AddUsage(u BillingUsage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := usageKey(u.UserID, u.Month)
+	total := s.usage[key]
+	total.UserID, total.Month = u.UserID, u.Month
+	total.add(u)
+	s.usage[key] = total
+	s.logEntryLocked(walUsage, key, total)
+}
+
+// Usage returns what userID was billed in month, which is zero if nothing.
+func (s *MemoryStore) Usage(userID, month string) BillingUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.usage[usageKey(userID, month)]; ok {
+		return u
+	}
+	return BillingUsage{UserID: userID, Month: month}
+}
+
+// MonthUsage returns every user's usage in month, by user ID.
+func (s *MemoryStore) MonthUsage(month string) []BillingUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var result []BillingUsage
+	for _, u := range s.usage {
+		if u.Month == month {
+			result = append(result, u)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].UserID < result[j].UserID })
+	return result
+}
+
+// billingMonth returns the month query parameter, defaulting to the
+// current month.
+func billingMonth(r *http.Request) (string, error) {
+	month := r.URL.Query().Get("month")
+	if month == "" {
+		return time.Now().UTC().Format(billingMonthLayout), nil
+	}
+	if _, err := time.Parse(billingMonthLayout, month); err != nil {
+		return "", invalidParam("month", "invalid_month", "month must be formatted as YYYY-MM")
+	}
+	return month, nil
+}
+
+func handleGetBilling(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		month, err := billingMonth(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.Usage(userID, month))
+	}
+}
+
+// billingRollup is every user's usage in a month and their sum.
+type billingRollup struct {
+	Month string         `json:"month"`
+	Total BillingUsage   `json:"total"`
+	Users []BillingUsage `json:"users"`
+}
+
+func handleBillingRollup(store *MemoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		month, err := billingMonth(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		rollup := billingRollup{Month: month, Total: BillingUsage{Month: month}, Users: store.MonthUsage(month)}
+		if rollup.Users == nil {
+			rollup.Users = []BillingUsage{}
+		}
+		for _, u := range rollup.Users {
+			rollup.Total.add(u)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rollup)
+	}
+}
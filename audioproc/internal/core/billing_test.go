@@ -0,0 +1,105 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func getBilling(t *testing.T, h *Harness, path string, out any) int {
+	t.Helper()
+	req, _ := http.NewRequest("GET", h.URL+path, nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(out)
+	return resp.StatusCode
+}
+
+func sameUsage(got BillingUsage, chunks int, seconds, cost float64) bool {
+	return got.Chunks == chunks && math.Abs(got.Seconds-seconds) < 1e-9 && math.Abs(got.Cost-cost) < 1e-9
+}
+
+func TestBillingAccumulatesTranscribedAudio(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.TranscriptionRate = 0.01
+	cfg.SkipAnomalousTranscription = true
+	store := NewMemoryStore()
+	h := NewHarnessWithStore(cfg, store)
+	h.Pipeline.Transcriber = &countingTranscriber{}
+	month := time.Now().UTC().Format(billingMonthLayout)
+
+	uploadTo(t, h, "user1", "s1", SineWAV(440, time.Second, 8000))
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 2*time.Second, 8000))
+	uploadTo(t, h, "user2", "s1", SineWAV(440, time.Second/2, 8000))
+	if silent := uploadTo(t, h, "user1", "s1", samplesWAV(8000, func(int) float64 { return 0 })); silent.TranscriptSkipReason == "" {
+		t.Errorf("Expected the silent chunk to skip transcription, but got %+v", silent)
+	}
+	uploadTo(t, h, "user1", "s1", []byte("not audio"))
+
+	// The retransmitted seq is acked as a duplicate and not transcribed again.
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=live"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	wav := SineWAV(440, time.Second, 8000)
+	for _, seq := range []int64{1, 1} {
+		conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: seq})
+		var ack map[string]any
+		conn.ReadJSON(&ack)
+	}
+	conn.Close()
+
+	job := h.Reprocessor.Start(ReprocessFilter{UserID: "user1"})
+	waitForState(t, h.Reprocessor, job.ID, jobDone)
+
+	var usage BillingUsage
+	if status := getBilling(t, h, "/users/user1/billing?month="+month, &usage); status != http.StatusOK {
+		t.Fatalf("Expected 200, but got %v", status)
+	}
+	if !sameUsage(usage, 3, 4, 0.04) || usage.Month != month {
+		t.Errorf("Expected 3 chunks and 4s billed, but got %+v", usage)
+	}
+	var rollup billingRollup
+	getBilling(t, h, "/admin/billing", &rollup)
+	if len(rollup.Users) != 2 || !sameUsage(rollup.Total, 4, 4.5, 0.045) {
+		t.Errorf("Expected both users in the rollup, but got %+v", rollup)
+	}
+	if status := getBilling(t, h, "/users/user1/billing?month=2026-13", &usage); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid month, but got %v", status)
+	}
+	if getBilling(t, h, "/users/user1/billing?month=2001-01", &usage); usage.Chunks != 0 {
+		t.Errorf("Expected nothing billed in another month, but got %+v", usage)
+	}
+	h.Close()
+
+	restarted := NewHarnessWithStore(cfg, store)
+	defer restarted.Close()
+	getBilling(t, restarted, "/users/user1/billing", &usage)
+	if !sameUsage(usage, 3, 4, 0.04) {
+		t.Errorf("Expected the usage to survive a restart, but got %+v", usage)
+	}
+}
+
+func TestBillingReprocessingWhenEnabled(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BillReprocessing = true
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Pipeline.Transcriber = &countingTranscriber{}
+	uploadTo(t, h, "user1", "s1", SineWAV(440, time.Second, 8000))
+
+	job := h.Reprocessor.Start(ReprocessFilter{UserID: "user1"})
+	waitForState(t, h.Reprocessor, job.ID, jobDone)
+	if usage := h.Store.Usage("user1", time.Now().UTC().Format(billingMonthLayout)); !sameUsage(usage, 2, 2, 0) {
+		t.Errorf("Expected the reprocessed chunk billed again at no rate, but got %+v", usage)
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// busDropped counts events each subscriber missed because its queue was
+// full, keyed by subscriber name.
+var busDropped = expvar.NewMap("bus_dropped")
+
+// Event types published on the Bus.
+const (
+	EventChunkProcessed = "chunk_processed"
+	EventChunkFailed    = "chunk_failed"
+	EventChunkDeleted   = "chunk_deleted"
+	EventSessionClosed  = "session_closed"
+	EventQuotaWarning   = "quota_warning"
+)
+
+// Event is a notification published on the Bus. Chunk is set for chunk
+// events, Session for session events and Quota for quota warnings. Seq is
+// the change feed position of chunk events.
+type Event struct {
+	Type    string
+	Seq     int64
+	At      time.Time
+	Chunk   *Metadata
+	Session *SessionSummary
+	Quota   *QuotaWarning
+}
+
+// Bus fans events out to subscribers without making publishers wait for
+// them. Each subscriber has its own bounded queue drained by its own
+// goroutine; when the queue is full the event is dropped for that
+// subscriber only.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*busSub]bool
+}
+
+type busSub struct {
+	name  string
+	types map[string]bool
+	queue chan Event
+	fn    func(Event)
+	done  chan struct{}
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+	dropped int64
+}
+
+// NewBus returns a Bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*busSub]bool)}
+}
+
+// Subscribe calls fn, one event at a time, for every published event of the
+// given types, or of any type when none are given. At most size events wait
+// for fn; more are dropped. The returned function stops delivery and waits
+// for fn to return.
+func (b *Bus) Subscribe(name string, size int, fn func(Event), types ...string) (unsubscribe func()) {
+	s := &busSub{
+		name:  name,
+		types: make(map[string]bool),
+		queue: make(chan Event, size),
+		fn:    fn,
+		done:  make(chan struct{}),
+	}
+	s.idle = sync.NewCond(&s.mu)
+	for _, t := range types {
+		s.types[t] = true
+	}
+	go s.run()
+	b.mu.Lock()
+	b.subs[s] = true
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		delete(b.subs, s)
+		b.mu.Unlock()
+		close(s.queue)
+		<-s.done
+	}
+}
+
+func (s *busSub) run() {
+	defer close(s.done)
+	for ev := range s.queue {
+		s.fn(ev)
+		s.mu.Lock()
+		s.pending--
+		if s.pending == 0 {
+			s.idle.Broadcast()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Publish queues ev for every interested subscriber and never blocks.
+func (b *Bus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if len(s.types) > 0 && !s.types[ev.Type] {
+			continue
+		}
+		s.mu.Lock()
+		select {
+		case s.queue <- ev:
+			s.pending++
+		default:
+			s.dropped++
+			busDropped.Add(s.name, 1)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Flush waits until every event published so far has been handled or
+// dropped.
+func (b *Bus) Flush() {
+	b.mu.Lock()
+	subs := make([]*busSub, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.mu.Lock()
+		for s.pending > 0 {
+			s.idle.Wait()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Dropped reports how many events each current subscriber has missed.
+func (b *Bus) Dropped() map[string]int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	dropped := make(map[string]int64, len(b.subs))
+	for s := range b.subs {
+		s.mu.Lock()
+		dropped[s.name] += s.dropped
+		s.mu.Unlock()
+	}
+	return dropped
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBusSlowSubscriberDropsAlone(t *testing.T) {
+	store := NewMemoryStore()
+	store.Events = NewBus()
+
+	var fast atomic.Int64
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	defer store.Events.Subscribe("fast", 100, func(Event) { fast.Add(1) }, EventChunkProcessed)()
+	defer store.Events.Subscribe("slow", 2, func(Event) {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-release
+	})()
+
+	start := time.Now()
+	store.Save(Metadata{ChunkID: "first", UserID: "u"})
+	<-entered
+	for i := 1; i < 50; i++ {
+		store.Save(Metadata{ChunkID: string(rune('a' + i)), UserID: "u"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected saves not to wait for a stuck subscriber, but they took %v", elapsed)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fast.Load() < 50 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := fast.Load(); n != 50 {
+		t.Errorf("Expected the fast subscriber to see all 50 saves, but it saw %d", n)
+	}
+	close(release)
+	store.Events.Flush()
+
+	dropped := store.Events.Dropped()
+	// The slow subscriber holds one event in its handler and two queued.
+	if dropped["fast"] != 0 || dropped["slow"] != 47 {
+		t.Errorf("Expected only the slow subscriber to drop events, but got %v", dropped)
+	}
+}
+
+func TestBusDeliversDeletes(t *testing.T) {
+	store := NewMemoryStore()
+	store.Events = NewBus()
+	var got []Event
+	defer store.Events.Subscribe("test", 10, func(ev Event) { got = append(got, ev) }, EventChunkDeleted)()
+
+	store.Save(Metadata{ChunkID: "c1", UserID: "u"})
+	store.Delete("c1")
+	store.Events.Flush()
+	if len(got) != 1 || got[0].Chunk.ChunkID != "c1" || got[0].Chunk.DeletedAt == nil {
+		t.Errorf("Expected one chunk_deleted event, but got %+v", got)
+	}
+}
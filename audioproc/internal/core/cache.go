@@ -0,0 +1,231 @@
+package core
+
+import (
+	"container/list"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var chunkCacheStats = expvar.NewMap("chunk_cache")
+
+// ChunkReader reads chunk metadata by ID; Store and CachedReader both
+// implement it.
+type ChunkReader interface {
+	Get(id string) (Metadata, error)
+}
+
+// CachedReader is an optional LRU in front of a slower store. Misses read
+// through to the backend and writes made through this instance are written
+// through to the cache, so a chunk just saved or patched is served without
+// a round trip. Entries expire after a TTL; writes made by other instances
+// are only seen once the entry expires or a client sends
+// Cache-Control: no-cache.
+//
+// Concurrent misses for an ID share one backend read, and IDs the backend
+// does not have are remembered for NegativeTTL, so a client polling a
+// chunk that does not exist costs one backend read per window. Saving the
+// chunk through this instance replaces the negative entry at once.
+type CachedReader struct {
+	backend ChunkReader
+	clock   Clock
+	size    int
+	ttl     time.Duration
+	// NegativeTTL is how long a not-found answer is cached; zero caches
+	// none.
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	// fills counts backend reads in flight per ID. A write that lands
+	// during one marks it stale, so the read, which may have seen the
+	// record from before the write, does not replace the newer entry.
+	fills map[string]*cacheFill
+}
+
+type cacheFill struct {
+	readers int
+	stale   bool
+	// done is closed when the first read of the fill returns meta and
+	// err, which the Gets that joined it meanwhile share.
+	done chan struct{}
+	meta Metadata
+	err  error
+}
+
+// cacheEntry is a chunk, or with err set, the backend's word that there
+// is none.
+type cacheEntry struct {
+	id      string
+	meta    Metadata
+	err     error
+	expires time.Time
+}
+
+// NewCachedReader caches up to size entries read from backend for ttl.
+func NewCachedReader(backend ChunkReader, size int, ttl time.Duration) *CachedReader {
+	return &CachedReader{
+		backend: backend,
+		clock:   realClock{},
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		fills:   make(map[string]*cacheFill),
+	}
+}
+
+// newChunkReader returns store itself unless a read cache is configured.
+func newChunkReader(cfg Config, store *MemoryStore) ChunkReader {
+	if cfg.ReadCacheSize <= 0 {
+		return store
+	}
+	c := NewCachedReader(store, cfg.ReadCacheSize, cfg.ReadCacheTTL)
+	c.NegativeTTL = cfg.ReadCacheNegativeTTL
+	store.OnWrite(c.Write)
+	return c
+}
+
+// Processing asks the backend whether id is still being uploaded, since a
+// cached miss cannot say.
+func (c *CachedReader) Processing(id string) bool {
+	p, ok := c.backend.(processingChecker)
+	return ok && p.Processing(id)
+}
+
+// Get returns a cached entry while it is fresh and reads through to the
+// backend otherwise, joining a read of id already in flight.
+func (c *CachedReader) Get(id string) (Metadata, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[id]; ok {
+		entry := el.Value.(*cacheEntry)
+		if c.clock.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			if entry.err != nil {
+				chunkCacheStats.Add("negative_hits", 1)
+			} else {
+				chunkCacheStats.Add("hits", 1)
+			}
+			return entry.meta, entry.err
+		}
+		c.removeLocked(id)
+	}
+	if fill := c.fills[id]; fill != nil && fill.done != nil {
+		select {
+		case <-fill.done:
+		default:
+			c.mu.Unlock()
+			chunkCacheStats.Add("coalesced", 1)
+			<-fill.done
+			return fill.meta, fill.err
+		}
+	}
+	c.mu.Unlock()
+	chunkCacheStats.Add("misses", 1)
+	return c.read(id)
+}
+
+// GetFresh bypasses the cache and refreshes it with the backend's answer,
+// unless the chunk was written while the backend was being read.
+func (c *CachedReader) GetFresh(id string) (Metadata, error) {
+	return c.read(id)
+}
+
+func (c *CachedReader) read(id string) (Metadata, error) {
+	c.mu.Lock()
+	fill := c.fills[id]
+	first := fill == nil
+	if first {
+		fill = &cacheFill{done: make(chan struct{})}
+		c.fills[id] = fill
+	}
+	fill.readers++
+	c.mu.Unlock()
+
+	meta, err := c.backend.Get(id)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if first {
+		fill.meta, fill.err = meta, err
+		close(fill.done)
+	}
+	if fill.readers--; fill.readers == 0 {
+		delete(c.fills, id)
+	}
+	if fill.stale {
+		chunkCacheStats.Add("stale_fills", 1)
+		return meta, err
+	}
+	switch {
+	case errors.Is(err, ErrNotFound) && c.NegativeTTL > 0:
+		chunkCacheStats.Add("negative_fills", 1)
+		c.putLocked(&cacheEntry{id: id, err: err, expires: c.clock.Now().Add(c.NegativeTTL)})
+	case err != nil:
+		c.removeLocked(id)
+	default:
+		c.putLocked(&cacheEntry{id: id, meta: meta, expires: c.clock.Now().Add(c.ttl)})
+	}
+	return meta, err
+}
+
+// Write is the store's write hook: it caches meta, or drops the entry when
+// err says the chunk is gone, and marks reads in flight for id as stale.
+func (c *CachedReader) Write(id string, meta Metadata, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fill := c.fills[id]; fill != nil {
+		fill.stale = true
+	}
+	if err != nil {
+		chunkCacheStats.Add("invalidations", 1)
+		c.removeLocked(id)
+		return
+	}
+	chunkCacheStats.Add("writes", 1)
+	c.putLocked(&cacheEntry{id: id, meta: meta, expires: c.clock.Now().Add(c.ttl)})
+}
+
+func (c *CachedReader) putLocked(entry *cacheEntry) {
+	if el, exists := c.entries[entry.id]; exists {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[entry.id] = c.lru.PushFront(entry)
+		if c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			c.removeLocked(oldest.Value.(*cacheEntry).id)
+		}
+	}
+}
+
+// Invalidate drops id's entry, so the next Get reads the backend.
+func (c *CachedReader) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fill := c.fills[id]; fill != nil {
+		fill.stale = true
+	}
+	c.removeLocked(id)
+}
+
+func (c *CachedReader) removeLocked(id string) {
+	if el, ok := c.entries[id]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, id)
+	}
+}
+
+// readChunk honours Cache-Control: no-cache by reading through to the store.
+func readChunk(reader ChunkReader, r *http.Request, id string) (Metadata, error) {
+	if c, ok := reader.(*CachedReader); ok && strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+		chunkCacheStats.Add("bypass", 1)
+		return c.GetFresh(id)
+	}
+	return reader.Get(id)
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func getChunkVia(t *testing.T, handler http.HandlerFunc, id string, header http.Header) Metadata {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/chunks/"+id, nil)
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var meta Metadata
+	json.NewDecoder(rr.Body).Decode(&meta)
+	return meta
+}
+
+func TestCachedReader_NoCacheSeesRemoteWrite(t *testing.T) {
+	backend := NewMemoryStore()
+	// Not subscribed to backend changes, as if another instance wrote them.
+	handler := handleGetChunk(NewCachedReader(backend, 10, time.Minute), DefaultConfig())
+
+	backend.Save(Metadata{ChunkID: "chunk1", Transcript: "first"})
+	if got := getChunkVia(t, handler, "chunk1", nil); got.Transcript != "first" {
+		t.Fatalf("Expected transcript 'first', but got %q", got.Transcript)
+	}
+
+	backend.Save(Metadata{ChunkID: "chunk1", Transcript: "second"})
+	if got := getChunkVia(t, handler, "chunk1", nil); got.Transcript != "first" {
+		t.Errorf("Expected the cached transcript until expiry, but got %q", got.Transcript)
+	}
+	if got := getChunkVia(t, handler, "chunk1", http.Header{"Cache-Control": {"no-cache"}}); got.Transcript != "second" {
+		t.Errorf("Expected no-cache to read the fresh write, but got %q", got.Transcript)
+	}
+	if got := getChunkVia(t, handler, "chunk1", nil); got.Transcript != "second" {
+		t.Errorf("Expected no-cache to refresh the cache, but got %q", got.Transcript)
+	}
+}
+
+func TestCachedReader_LocalWritesInvalidateAndEvict(t *testing.T) {
+	store := NewMemoryStore()
+	cfg := DefaultConfig()
+	cfg.ReadCacheSize = 2
+	reader := newChunkReader(cfg, store).(*CachedReader)
+	clock := NewFakeClock(time.Now())
+	reader.clock = clock
+
+	store.Save(Metadata{ChunkID: "a", Transcript: "first"})
+	reader.Get("a")
+	store.Save(Metadata{ChunkID: "a", Transcript: "second"})
+	if m, _ := reader.Get("a"); m.Transcript != "second" {
+		t.Errorf("Expected a local write to invalidate the entry, but got %q", m.Transcript)
+	}
+
+	store.Delete("a")
+	if _, err := reader.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a deleted chunk to be gone from the cache")
+	}
+
+	for _, id := range []string{"b", "c", "d"} {
+		store.Save(Metadata{ChunkID: id})
+		reader.Get(id)
+	}
+	if len(reader.entries) != 2 {
+		t.Errorf("Expected the LRU to hold 2 entries, but got %v", len(reader.entries))
+	}
+	if _, ok := reader.entries["b"]; ok {
+		t.Errorf("Expected the least recently used entry to be evicted")
+	}
+
+	clock.Advance(cfg.ReadCacheTTL + time.Second)
+	before := chunkCacheStats.Get("misses").String()
+	reader.Get("d")
+	if chunkCacheStats.Get("misses").String() == before {
+		t.Errorf("Expected an expired entry to count as a miss")
+	}
+}
+
+func TestCachedReader_PatchAndDeleteThroughAPI(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReadCacheSize = 16
+	cfg.ReadCacheTTL = time.Hour
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Store.Save(Metadata{ChunkID: "chunk1", UserID: "user1", Transcript: "first"})
+
+	var got Metadata
+	adminDo(t, h, "GET", "/chunks/chunk1", nil, &got)
+	adminDo(t, h, "PATCH", "/chunks/chunk1", map[string]string{"transcript": "patched"}, nil)
+	hits := expvarInt(chunkCacheStats, "hits")
+	if adminDo(t, h, "GET", "/chunks/chunk1", nil, &got); got.Transcript != "patched" {
+		t.Errorf("Expected the patch to be visible at once, but got %q", got.Transcript)
+	}
+	if expvarInt(chunkCacheStats, "hits") != hits+1 {
+		t.Errorf("Expected the patched record to be served from the cache")
+	}
+
+	if status := adminDo(t, h, "DELETE", "/chunks/chunk1", nil, nil); status >= 300 {
+		t.Fatalf("Expected the delete to succeed, but got %v", status)
+	}
+	if status := adminDo(t, h, "GET", "/chunks/chunk1", nil, nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 after the delete, but got %v", status)
+	}
+}
+
+// blockingReader holds reads of a chunk until released, like a slow
+// remote backend.
+type blockingReader struct {
+	store   *MemoryStore
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingReader) Get(id string) (Metadata, error) {
+	m, err := b.store.Get(id)
+	b.entered <- struct{}{}
+	<-b.release
+	return m, err
+}
+
+func TestCachedReader_WriteDuringFillWins(t *testing.T) {
+	store := NewMemoryStore()
+	backend := &blockingReader{store: store, entered: make(chan struct{}), release: make(chan struct{})}
+	reader := NewCachedReader(backend, 10, time.Hour)
+	store.OnWrite(reader.Write)
+	store.Save(Metadata{ChunkID: "chunk1", Transcript: "old"})
+	reader.Invalidate("chunk1")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reader.Get("chunk1")
+	}()
+	<-backend.entered
+	// The read has seen "old"; a write lands before it returns.
+	store.Save(Metadata{ChunkID: "chunk1", Transcript: "new"})
+	close(backend.release)
+	wg.Wait()
+
+	if m, _ := reader.Get("chunk1"); m.Transcript != "new" {
+		t.Errorf("Expected the write to win over the read in flight, but got %q", m.Transcript)
+	}
+}
+
+// remoteReader stands in for a store behind a network round trip.
+type remoteReader struct {
+	store   *MemoryStore
+	latency time.Duration
+}
+
+func (r remoteReader) Get(id string) (Metadata, error) {
+	time.Sleep(r.latency)
+	return r.store.Get(id)
+}
+
+// countingReader counts backend reads, each taking latency.
+type countingReader struct {
+	remoteReader
+	calls atomic.Int64
+}
+
+func (c *countingReader) Get(id string) (Metadata, error) {
+	c.calls.Add(1)
+	return c.remoteReader.Get(id)
+}
+
+func TestCachedReader_HotMissIsCoalescedAndCached(t *testing.T) {
+	store := NewMemoryStore()
+	backend := &countingReader{remoteReader: remoteReader{store: store, latency: 20 * time.Millisecond}}
+	reader := NewCachedReader(backend, 10, time.Minute)
+	reader.NegativeTTL = 2 * time.Second
+	clock := NewFakeClock(time.Now())
+	reader.clock = clock
+	store.OnWrite(reader.Write)
+	negativeHits := expvarInt(chunkCacheStats, "negative_hits")
+
+	hammer := func() {
+		var wg sync.WaitGroup
+		for range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := reader.Get("missing"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Expected not found, but got %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	hammer()
+	if n := backend.calls.Load(); n != 1 {
+		t.Fatalf("Expected concurrent misses to share one backend read, but got %d", n)
+	}
+	hammer()
+	if n := backend.calls.Load(); n != 1 {
+		t.Errorf("Expected the miss to be cached, but got %d backend reads", n)
+	}
+	if hits := expvarInt(chunkCacheStats, "negative_hits") - negativeHits; hits < 50 {
+		t.Errorf("Expected at least 50 negative hits, but got %d", hits)
+	}
+	clock.Advance(2 * time.Second)
+	hammer()
+	if n := backend.calls.Load(); n != 2 {
+		t.Errorf("Expected one more backend read once the miss expired, but got %d", n)
+	}
+
+	// Saving the chunk makes it visible at once.
+	store.Save(Metadata{ChunkID: "missing", Transcript: "here"})
+	if m, err := reader.Get("missing"); err != nil || m.Transcript != "here" {
+		t.Errorf("Expected the saved chunk, but got %+v %v", m, err)
+	}
+	if n := backend.calls.Load(); n != 2 {
+		t.Errorf("Expected the save to be served from the cache, but got %d backend reads", n)
+	}
+}
+
+// BenchmarkCachedReaderHotReads reads a small hot set of chunks straight
+// from a remote backend and through the cache.
+func BenchmarkCachedReaderHotReads(b *testing.B) {
+	store := NewMemoryStore()
+	ids := make([]string, 32)
+	for i := range ids {
+		ids[i] = "chunk" + string(rune('a'+i))
+		store.Save(Metadata{ChunkID: ids[i], Transcript: "hello"})
+	}
+	backend := remoteReader{store: store, latency: 100 * time.Microsecond}
+	for _, bc := range []struct {
+		name   string
+		reader ChunkReader
+	}{
+		{"remote", backend},
+		{"cached", NewCachedReader(backend, 64, time.Minute)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					bc.reader.Get(ids[i%len(ids)])
+				}
+			})
+		})
+	}
+}
+
+func TestUploadReturnsChunkLocation(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.PublicURL = "http://node-a.internal:9090"
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=sess1", "audio/wav", strings.NewReader("audio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	if want := cfg.PublicURL + "/chunks/" + meta.ChunkID; resp.Header.Get("X-Chunk-Location") != want {
+		t.Errorf("Expected X-Chunk-Location %v, but got %v", want, resp.Header.Get("X-Chunk-Location"))
+	}
+}
@@ -0,0 +1,322 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var debugCaptureStats = expvar.NewMap("debug_captures")
+
+// redactedHeaders never reach a capture file.
+var redactedHeaders = []string{"Authorization", "Cookie", "X-Admin-Token", "X-Api-Key"}
+
+// Capture is a quarantined copy of one upload, kept so a bad result can be
+// reproduced. The body is stored next to it in <id>.body.
+type Capture struct {
+	ID         string      `json:"id"`
+	UserID     string      `json:"user_id"`
+	CapturedAt time.Time   `json:"captured_at"`
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header"`
+	BodyBytes  int         `json:"body_bytes"`
+	Status     int         `json:"status"`
+	Metadata   *Metadata   `json:"metadata,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// DebugCapturer writes raw uploads for selected users, or a random sample of
+// all users, to Dir. Users in OptOut are never captured. Captures count
+// against MaxCount and MaxBytes and are purged after TTL.
+type DebugCapturer struct {
+	Dir      string
+	Users    map[string]bool
+	OptOut   map[string]bool
+	Rate     float64
+	MaxCount int
+	MaxBytes int64
+	TTL      time.Duration
+	Clock    Clock
+
+	mu sync.Mutex
+}
+
+// NewDebugCapturer returns a capturer that is idle until a capture is
+// started.
+func NewDebugCapturer(cfg Config) *DebugCapturer {
+	c := &DebugCapturer{
+		Dir:      cfg.DebugCaptureDir,
+		Users:    make(map[string]bool),
+		OptOut:   make(map[string]bool),
+		Rate:     cfg.DebugCaptureRate,
+		MaxCount: cfg.DebugCaptureMaxCount,
+		MaxBytes: cfg.DebugCaptureMaxBytes,
+		TTL:      cfg.DebugCaptureTTL,
+		Clock:    realClock{},
+	}
+	for _, u := range cfg.DebugCaptureUsers {
+		c.Users[u] = true
+	}
+	for _, u := range cfg.DebugCaptureOptOut {
+		c.OptOut[u] = true
+	}
+	return c
+}
+
+func (c *DebugCapturer) shouldCapture(userID string) bool {
+	if c == nil || c.OptOut[userID] {
+		return false
+	}
+	return c.Users[userID] || (c.Rate > 0 && rand.Float64() < c.Rate)
+}
+
+// Wrap captures requests to next that are selected for capture.
+func (c *DebugCapturer) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.URL.Query().Get("user_id")
+		if !c.shouldCapture(userID) {
+			next(w, r)
+			return
+		}
+		body := &cappedBuffer{max: int(c.MaxBytes)}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, body), r.Body}
+		rec := &captureRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		if body.overflow {
+			debugCaptureStats.Add("dropped", 1)
+			return
+		}
+
+		capture := Capture{
+			ID:         uuid.New().String(),
+			UserID:     userID,
+			CapturedAt: c.Clock.Now(),
+			Method:     r.Method,
+			URL:        redactURL(r),
+			Header:     redactHeader(r.Header),
+			BodyBytes:  body.Len(),
+			Status:     rec.status,
+		}
+		var meta Metadata
+		if rec.status == http.StatusOK && json.Unmarshal(rec.body.Bytes(), &meta) == nil {
+			capture.Metadata = &meta
+		} else {
+			capture.Error = strings.TrimSpace(rec.body.String())
+		}
+		if err := c.save(capture, body.Bytes()); err != nil {
+			debugCaptureStats.Add("dropped", 1)
+			log.Printf("Debug capture for user %s dropped: %v", userID, err)
+			return
+		}
+		debugCaptureStats.Add("saved", 1)
+	}
+}
+
+var errCaptureQuota = newKindError(ErrQuotaExceeded, "debug capture quota exceeded")
+
+func (c *DebugCapturer) save(capture Capture, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return err
+	}
+	count, size, err := c.usageLocked()
+	if err != nil {
+		return err
+	}
+	record, err := json.MarshalIndent(capture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if count+1 > c.MaxCount || size+int64(len(record)+len(body)) > c.MaxBytes {
+		return errCaptureQuota
+	}
+	if err := os.WriteFile(filepath.Join(c.Dir, capture.ID+".body"), body, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(c.Dir, capture.ID+".json"), record, 0o600)
+}
+
+func (c *DebugCapturer) usageLocked() (int, int64, error) {
+	entries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var count int
+	var size int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		size += info.Size()
+		if strings.HasSuffix(e.Name(), ".json") {
+			count++
+		}
+	}
+	return count, size, nil
+}
+
+// List returns all captures, newest first.
+func (c *DebugCapturer) List() ([]Capture, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	paths, err := filepath.Glob(filepath.Join(c.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	captures := []Capture{}
+	for _, p := range paths {
+		var capture Capture
+		data, err := os.ReadFile(p)
+		if err != nil || json.Unmarshal(data, &capture) != nil {
+			continue
+		}
+		captures = append(captures, capture)
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].CapturedAt.After(captures[j].CapturedAt) })
+	return captures, nil
+}
+
+// Body returns the raw request body of a capture.
+func (c *DebugCapturer) Body(id string) ([]byte, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(c.Dir, id+".body"))
+}
+
+// Purge removes captures older than the TTL and reports how many it removed.
+func (c *DebugCapturer) Purge() int {
+	captures, err := c.List()
+	if err != nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := c.Clock.Now().Add(-c.TTL)
+	var n int
+	for _, capture := range captures {
+		if capture.CapturedAt.Before(cutoff) {
+			os.Remove(filepath.Join(c.Dir, capture.ID+".json"))
+			os.Remove(filepath.Join(c.Dir, capture.ID+".body"))
+			n++
+		}
+	}
+	return n
+}
+
+// RunCaptureSweeper ends expired captures every interval until ctx ends.
+func RunCaptureSweeper(ctx context.Context, c *DebugCapturer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := c.Purge(); n > 0 {
+				log.Printf("Purged %d expired debug captures", n)
+			}
+		}
+	}
+}
+
+func redactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range redactedHeaders {
+		if out.Get(name) != "" {
+			out.Set(name, "REDACTED")
+		}
+	}
+	return out
+}
+
+func redactURL(r *http.Request) string {
+	u := *r.URL
+	q := u.Query()
+	if q.Has("api_key") {
+		q.Set("api_key", "REDACTED")
+		u.RawQuery = q.Encode()
+	}
+	return u.RequestURI()
+}
+
+// cappedBuffer keeps at most max bytes and notes whether more were written.
+type cappedBuffer struct {
+	bytes.Buffer
+	max      int
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		b.overflow = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// captureRecorder keeps a copy of the response for the capture record.
+type captureRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *captureRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *captureRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func handleListCaptures(c *DebugCapturer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		captures, err := c.List()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(captures)
+	}
+}
+
+func handleCaptureBody(c *DebugCapturer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := c.Body(mux.Vars(r)["id"])
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%w: capture %s", ErrNotFound, mux.Vars(r)["id"])
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(body)
+	}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugCapture(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.DebugCaptureDir = t.TempDir()
+	cfg.DebugCaptureUsers = []string{"user1"}
+	cfg.DebugCaptureOptOut = []string{"user2"}
+	cfg.DebugCaptureRate = 1
+	h := NewHarness(cfg)
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Captures.Clock = clock
+
+	for _, user := range []string{"user1", "user2"} {
+		req, _ := http.NewRequest("POST", h.URL+"/upload?user_id="+user+"&session_id=sess1", strings.NewReader("raw audio"))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	req, _ := http.NewRequest("GET", h.URL+"/admin/captures", nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var captures []Capture
+	json.NewDecoder(resp.Body).Decode(&captures)
+	resp.Body.Close()
+	if len(captures) != 1 || captures[0].UserID != "user1" {
+		t.Fatalf("Expected a single capture for user1, but got %+v", captures)
+	}
+	c := captures[0]
+	if got := c.Header.Get("Authorization"); got != "REDACTED" {
+		t.Errorf("Expected Authorization to be redacted, but got %q", got)
+	}
+	if c.Metadata == nil || c.Metadata.UserID != "user1" {
+		t.Errorf("Expected the resulting metadata to be captured, but got %+v", c.Metadata)
+	}
+
+	req, _ = http.NewRequest("GET", h.URL+"/admin/captures/"+c.ID+"/body", nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "raw audio" {
+		t.Errorf("Expected the raw body, but got %q", body)
+	}
+
+	clock.Advance(cfg.DebugCaptureTTL + time.Minute)
+	if n := h.Captures.Purge(); n != 1 {
+		t.Errorf("Expected 1 capture purged, but got %v", n)
+	}
+	if left, _ := h.Captures.List(); len(left) != 0 {
+		t.Errorf("Expected no captures after the purge, but got %v", len(left))
+	}
+}
+
+func TestDebugCaptureQuota(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DebugCaptureDir = t.TempDir()
+	cfg.DebugCaptureUsers = []string{"user1"}
+	cfg.DebugCaptureMaxCount = 2
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	for i := 0; i < 3; i++ {
+		uploadTo(t, h, "user1", "sess1", []byte("audio"))
+	}
+	if captures, _ := h.Captures.List(); len(captures) != 2 {
+		t.Errorf("Expected the count quota to cap captures at 2, but got %v", len(captures))
+	}
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Change operations recorded in the change log.
+const (
+	changeSave    = "save"
+	changeUpdate  = "update"
+	changeDelete  = "delete"
+	changeRestore = "restore"
+	changePurge   = "purge"
+)
+
+// Change is one entry of the metadata change feed. Seq increases by one per
+// change and doubles as the cursor. Metadata is the record as it stood
+// after the change; it is omitted for purges.
+type Change struct {
+	Seq      int64     `json:"seq"`
+	Op       string    `json:"op"`
+	ChunkID  string    `json:"chunk_id"`
+	At       time.Time `json:"at"`
+	Metadata *Metadata `json:"metadata,omitempty"`
+}
+
+// ChangePage is a slice of the change feed. Cursor is the value to pass as
+// since on the next call. Truncated means changes after since are no longer
+// available, so the caller must resync from a full listing before applying
+// Changes.
+type ChangePage struct {
+	Changes   []Change `json:"changes"`
+	Cursor    int64    `json:"cursor"`
+	Truncated bool     `json:"truncated"`
+}
+
+// saveOpLocked is the change a save of chunk id is recorded as: a save
+// for a new chunk and an update for one the store already holds.
+func (s *MemoryStore) saveOpLocked(id string) string {
+	if _, ok := s.lookupLocked(id); ok {
+		return changeUpdate
+	}
+	return changeSave
+}
+
+func (s *MemoryStore) recordChangeLocked(op, id string) {
+	s.changeSeq++
+	c := Change{Seq: s.changeSeq, Op: op, ChunkID: id, At: s.Clock.Now()}
+	if op != changePurge {
+		if m, ok := s.lookupLocked(id); ok {
+			c.Metadata = &m
+		}
+	}
+	s.changes = append(s.changes, c)
+	if len(s.changes) > s.ChangeLogSize {
+		s.changes = s.changes[len(s.changes)-s.ChangeLogSize:]
+	}
+	s.appendWALLocked(walRecord{Change: &c})
+}
+
+// Changes returns up to limit changes after since, oldest first. Without a
+// WAL the log only lives as long as the process, so a cursor from before a
+// restart is reported as truncated; see OpenMemoryStore.
+func (s *MemoryStore) Changes(since int64, limit int) ChangePage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.changesLocked(since, limit)
+}
+
+func (s *MemoryStore) changesLocked(since int64, limit int) ChangePage {
+	page := ChangePage{Changes: []Change{}, Cursor: since}
+	oldest := s.changeSeq + 1
+	if len(s.changes) > 0 {
+		oldest = s.changes[0].Seq
+	}
+	if since+1 < oldest || since > s.changeSeq {
+		page.Truncated = true
+		since = oldest - 1
+		page.Cursor = since
+	}
+	start := int(since + 1 - oldest)
+	for _, c := range s.changes[start:] {
+		if len(page.Changes) == limit {
+			break
+		}
+		page.Changes = append(page.Changes, c)
+		page.Cursor = c.Seq
+	}
+	return page
+}
+
+const maxChangesLimit = 1000
+
+func handleChanges(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var since int64
+		if v := r.URL.Query().Get("since"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeJSONError(w, http.StatusBadRequest, "invalid_cursor", "since must be a cursor returned by /changes")
+				return
+			}
+			since = n
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "invalid_limit", "limit must be a positive integer")
+				return
+			}
+			limit = min(n, maxChangesLimit)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(store.Changes(since, limit))
+	}
+}
@@ -0,0 +1,105 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChangesOrderAcrossOperations(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	store.Clock = clock
+	store.Retention = time.Hour
+
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", Transcript: "first"})
+	store.Save(Metadata{ChunkID: "b", UserID: "user1"})
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", Transcript: "second"})
+	store.Delete("b")
+	store.Restore("b")
+	store.Delete("a")
+	clock.Advance(2 * time.Hour)
+	store.PurgeDeleted()
+
+	page := store.Changes(0, 100)
+	want := []struct{ op, id string }{
+		{changeSave, "a"}, {changeSave, "b"}, {changeUpdate, "a"},
+		{changeDelete, "b"}, {changeRestore, "b"}, {changeDelete, "a"}, {changePurge, "a"},
+	}
+	if len(page.Changes) != len(want) || page.Truncated {
+		t.Fatalf("Expected %d changes, but got %+v", len(want), page)
+	}
+	for i, c := range page.Changes {
+		if c.Seq != int64(i+1) || c.Op != want[i].op || c.ChunkID != want[i].id {
+			t.Errorf("Change %d: expected %v, but got %+v", i, want[i], c)
+		}
+	}
+	if m := page.Changes[2].Metadata; m == nil || m.Transcript != "second" {
+		t.Errorf("Expected the update to carry its snapshot, but got %+v", m)
+	}
+	if page.Changes[3].Metadata.DeletedAt == nil || page.Changes[6].Metadata != nil {
+		t.Errorf("Expected the delete to show DeletedAt and the purge no snapshot")
+	}
+
+	first := store.Changes(0, 3)
+	rest := store.Changes(first.Cursor, 100)
+	if first.Cursor != 3 || len(rest.Changes) != 4 || rest.Changes[0].Seq != 4 || rest.Cursor != 7 {
+		t.Errorf("Expected paging to resume after the cursor, but got %+v then %+v", first, rest)
+	}
+	if tail := store.Changes(rest.Cursor, 100); len(tail.Changes) != 0 || tail.Cursor != 7 || tail.Truncated {
+		t.Errorf("Expected an empty page at the head, but got %+v", tail)
+	}
+}
+
+func TestChangesTruncation(t *testing.T) {
+	store := NewMemoryStore()
+	store.ChangeLogSize = 3
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		store.Save(Metadata{ChunkID: id})
+	}
+
+	page := store.Changes(1, 100)
+	if !page.Truncated || len(page.Changes) != 3 || page.Changes[0].ChunkID != "c" || page.Cursor != 5 {
+		t.Errorf("Expected a truncated page starting at the oldest retained change, but got %+v", page)
+	}
+	if page := store.Changes(2, 100); page.Truncated || len(page.Changes) != 3 {
+		t.Errorf("Expected a cursor just before the oldest change to be intact, but got %+v", page)
+	}
+	// A cursor ahead of the log came from before a restart.
+	if page := store.Changes(50, 100); !page.Truncated {
+		t.Errorf("Expected a cursor from the future to be truncated, but got %+v", page)
+	}
+}
+
+func TestChangesEndpoint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	store := NewMemoryStore()
+	router := New(cfg, store, nil).Handler()
+	store.Save(Metadata{ChunkID: "a"})
+	store.Delete("a")
+
+	get := func(url string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if admin {
+			req.Header.Set("X-Admin-Token", "secret")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := get("/changes", false); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the feed to require an admin token, but got %v", rr.Code)
+	}
+	if rr := get("/changes?since=x", true); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed cursor, but got %v", rr.Code)
+	}
+	rr := get("/changes?since=1&limit=10", true)
+	var page ChangePage
+	json.NewDecoder(rr.Body).Decode(&page)
+	if rr.Code != http.StatusOK || len(page.Changes) != 1 || page.Changes[0].Op != changeDelete || page.Cursor != 2 {
+		t.Errorf("Expected the delete after cursor 1, but got %v %+v", rr.Code, page)
+	}
+}
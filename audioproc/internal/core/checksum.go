@@ -0,0 +1,104 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// checksumHeader carries the hex SHA-256 of an upload's audio. Clients that
+// know it up front send it as a header; streaming clients declare
+// "Trailer: X-Content-Checksum" and send it after a chunked body instead.
+//
+// WebSocket clients get the same two options: a "checksum" field on the
+// chunk envelope, or "trailer": "checksum" on the envelope followed by a
+// {"type": "checksum", "checksum": "..."} frame. Either way the chunk is
+// verified before it is enqueued.
+const checksumHeader = "X-Content-Checksum"
+
+// chunkChecksum is the hex SHA-256 that identifies a chunk's audio.
+func chunkChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// readHashed reads r to EOF, hashing the bytes as they arrive so the
+// checksum costs no second pass over the data.
+func readHashed(r io.Reader) ([]byte, string, error) {
+	h := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(r, h))
+	return data, hex.EncodeToString(h.Sum(nil)), err
+}
+
+// verifyChecksum reports whether got, the checksum computed at ingest,
+// matches want. An empty want means the client sent no checksum and always
+// passes.
+func verifyChecksum(got, want string) error {
+	if want == "" {
+		return nil
+	}
+	if !strings.EqualFold(got, strings.TrimSpace(want)) {
+		return invalidField("checksum", "checksum_mismatch", fmt.Sprintf("got %s, want %s", got, want))
+	}
+	return nil
+}
+
+// requestChecksum returns the checksum sent with r. Trailers are only
+// populated once the body has been read to EOF, so call it after reading.
+func requestChecksum(r *http.Request) string {
+	if v := r.Header.Get(checksumHeader); v != "" {
+		return v
+	}
+	return r.Trailer.Get(checksumHeader)
+}
+
+// handleFindByChecksum lists the chunks whose audio hashes to the hex
+// SHA-256 in the path, oldest first. Matches are limited to user_id, which
+// defaults to the caller's API key user, unless an admin passes
+// all_users=true.
+func handleFindByChecksum(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		checksum := strings.ToLower(mux.Vars(r)["sha256"])
+		if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != 2*sha256.Size {
+			writeError(w, invalidParam("sha256", "invalid_checksum", "checksum must be 64 hex digits"))
+			return
+		}
+		q := r.URL.Query()
+		userID := q.Get("user_id")
+		if userID == "" {
+			userID = authUserID(r.Context())
+		}
+		switch {
+		case q.Get("all_users") == "true":
+			if !isAdmin(cfg, r) {
+				writeError(w, errAdminRequired)
+				return
+			}
+			userID = ""
+		case userID == "":
+			writeError(w, invalidParam("user_id", "missing_user_id", "user_id is required"))
+			return
+		default:
+			if err := checkUserAccess(cfg, r, userID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		result := []Metadata{}
+		for _, m := range store.FindByChecksum(checksum) {
+			if userID == "" || m.UserID == userID {
+				result = append(result, m)
+			}
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Timestamp.Before(result[j].Timestamp) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
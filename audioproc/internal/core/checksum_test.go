@@ -0,0 +1,232 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// postWithTrailer sends a chunked upload over a raw connection so the
+// checksum arrives as a real trailer after the body.
+func postWithTrailer(t *testing.T, h *Harness, body []byte, checksum string) *http.Response {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(h.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	fmt.Fprintf(conn, "POST /upload?user_id=user1&session_id=sess1 HTTP/1.1\r\n")
+	fmt.Fprintf(conn, "Host: %s\r\nTransfer-Encoding: chunked\r\nTrailer: %s\r\n\r\n", conn.RemoteAddr(), checksumHeader)
+	half := len(body) / 2
+	for _, part := range [][]byte{body[:half], body[half:]} {
+		fmt.Fprintf(conn, "%x\r\n%s\r\n", len(part), part)
+	}
+	fmt.Fprintf(conn, "0\r\n%s: %s\r\n\r\n", checksumHeader, checksum)
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestUploadChecksumTrailer(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	body := []byte("streamed audio bytes")
+	good := fmt.Sprintf("%x", sha256.Sum256(body))
+
+	if resp := postWithTrailer(t, h, body, good); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a matching trailer to be accepted, but got %v", resp.StatusCode)
+	}
+	if resp := postWithTrailer(t, h, body, strings.Repeat("0", 64)); resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a mismatched trailer, but got %v", resp.StatusCode)
+	}
+	if n := len(h.Store.ListByUser("user1")); n != 1 {
+		t.Errorf("Expected only the verified upload to be stored, but got %v", n)
+	}
+}
+
+func TestUploadChecksumHeaderMismatch(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=user1&session_id=sess1", strings.NewReader("audio"))
+	req.Header.Set(checksumHeader, "deadbeef")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, but got %v", resp.StatusCode)
+	}
+}
+
+func TestWebSocketChecksumFrame(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	data := []byte("ws audio")
+	send := func(checksum string) map[string]any {
+		conn.WriteJSON(wsEnvelope{Type: "chunk", Data: data, Trailer: "checksum"})
+		conn.WriteJSON(wsEnvelope{Type: "checksum", Checksum: checksum})
+		var reply map[string]any
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		return reply
+	}
+
+	if reply := send(fmt.Sprintf("%x", sha256.Sum256(data))); reply["ack"] != true {
+		t.Errorf("Expected an ack for a matching checksum frame, but got %v", reply)
+	}
+	if reply := send("deadbeef"); reply["error"] != "checksum_mismatch" {
+		t.Errorf("Expected checksum_mismatch, but got %v", reply)
+	}
+}
+
+func findByChecksum(t *testing.T, h *Harness, path, key string, admin bool) (int, []Metadata) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", h.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	if admin {
+		req.Header.Set("X-Admin-Token", "admin")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	var chunks []Metadata
+	json.NewDecoder(resp.Body).Decode(&chunks)
+	return resp.StatusCode, chunks
+}
+
+func TestFindByChecksum(t *testing.T) {
+	h := NewHarness(keysConfig())
+	defer h.Close()
+	shared := fmt.Sprintf("%x", sha256.Sum256([]byte("shared audio")))
+	single := fmt.Sprintf("%x", sha256.Sum256([]byte("single audio")))
+	start := time.Now().UTC()
+	for i, m := range []Metadata{
+		{ChunkID: "a", UserID: "user1", SessionID: "s2", Checksum: shared},
+		{ChunkID: "b", UserID: "user1", SessionID: "s1", Checksum: shared},
+		{ChunkID: "c", UserID: "user2", SessionID: "s1", Checksum: shared},
+		{ChunkID: "d", UserID: "user1", SessionID: "s1", Checksum: single},
+	} {
+		m.Timestamp = start.Add(time.Duration(i) * time.Second)
+		h.Store.Save(m)
+	}
+	key := mintKey(t, h, `{"user_id": "user1"}`).Key
+
+	if status, chunks := findByChecksum(t, h, "/checksums/"+strings.ToUpper(single), key, false); status != http.StatusOK || len(chunks) != 1 || chunks[0].ChunkID != "d" {
+		t.Errorf("Expected the single match, but got %v %+v", status, chunks)
+	}
+	status, chunks := findByChecksum(t, h, "/checksums/"+shared, key, false)
+	if status != http.StatusOK || len(chunks) != 2 || chunks[0].SessionID != "s2" || chunks[1].SessionID != "s1" || chunks[0].Timestamp.IsZero() {
+		t.Errorf("Expected the caller's matches from both sessions, oldest first, but got %v %+v", status, chunks)
+	}
+	if status, _ := findByChecksum(t, h, "/checksums/"+shared+"?user_id=user2", key, false); status != http.StatusForbidden {
+		t.Errorf("Expected 403 for another user's chunks, but got %v", status)
+	}
+	if status, _ := findByChecksum(t, h, "/checksums/"+shared+"?all_users=true", key, false); status != http.StatusForbidden {
+		t.Errorf("Expected 403 searching all users without the admin token, but got %v", status)
+	}
+	if status, chunks := findByChecksum(t, h, "/checksums/"+shared+"?all_users=true", "ops-key", true); status != http.StatusOK || len(chunks) != 3 {
+		t.Errorf("Expected an admin to see every user's matches, but got %v %+v", status, chunks)
+	}
+	for _, bad := range []string{"xyz", shared[:63], shared + "00", strings.Repeat("g", 64)} {
+		if status, _ := findByChecksum(t, h, "/checksums/"+bad, key, false); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, but got %v", bad, status)
+		}
+	}
+}
+
+func TestIngestChecksumMatchesData(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, time.Second, 8000)
+	want := fmt.Sprintf("%x", sha256.Sum256(wav))
+
+	if meta := uploadTo(t, h, "user1", "s1", wav); meta.Checksum != want {
+		t.Errorf("Expected the streamed checksum %s, but got %s", want, meta.Checksum)
+	}
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("audio", "tone.wav")
+	part.Write(wav)
+	mw.Close()
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	if meta.Checksum != want {
+		t.Errorf("Expected the multipart checksum %s, but got %s", want, meta.Checksum)
+	}
+}
+
+func TestPipelineTrustsIngestChecksum(t *testing.T) {
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	p := NewPipeline(DefaultConfig(), stubTranscriber{}, func() int { return 0 })
+	want := chunkChecksum(wav)
+	if got := p.Process(context.Background(), AudioChunk{ChunkID: "c1", Data: wav}).Checksum; got != want {
+		t.Errorf("Expected the worker to hash a chunk without a checksum, but got %s", got)
+	}
+	if got := p.Process(context.Background(), AudioChunk{ChunkID: "c2", Data: wav, Checksum: "precomputed"}).Checksum; got != "precomputed" {
+		t.Errorf("Expected the ingest checksum to be trusted, but got %s", got)
+	}
+
+	p.VerifyChecksums = true
+	before := checksumMismatches.Value()
+	if got := p.Process(context.Background(), AudioChunk{ChunkID: "c3", Data: wav, Checksum: "precomputed"}).Checksum; got != want {
+		t.Errorf("Expected verify mode to use the real checksum, but got %s", got)
+	}
+	if checksumMismatches.Value() != before+1 {
+		t.Errorf("Expected the mismatch to be counted")
+	}
+}
+
+// BenchmarkProcessChecksum compares a worker hashing the chunk itself with
+// one trusting the checksum computed while the upload was read.
+func BenchmarkProcessChecksum(b *testing.B) {
+	wav := SineWAV(440, 10*time.Second, 16000)
+	p := NewPipeline(DefaultConfig(), stubTranscriber{}, func() int { return 0 })
+	for _, bc := range []struct {
+		name     string
+		checksum string
+	}{
+		{"worker_hash", ""},
+		{"ingest_hash", chunkChecksum(wav)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(wav)))
+			for i := 0; i < b.N; i++ {
+				p.Process(context.Background(), AudioChunk{ChunkID: "c1", Data: wav, Checksum: bc.checksum})
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// safeChunkID is what every chunk ID looks like, whatever
+// Config.ChunkIDPattern admits: no separators and no leading dot, so that
+// an ID is safe as a file or archive entry name.
+var safeChunkID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// errChunkIDInUse is returned while another upload holds a chunk ID.
+var errChunkIDInUse = newKindError(ErrConflict, "another upload with this chunk_id is in progress")
+
+// chunkIDConflict reports a client-supplied chunk ID that already names a
+// chunk with different audio. Checksum is the existing chunk's, so the
+// client can tell a true duplicate from a clash; it is empty when the ID
+// names another user's chunk or an upload that was split into several
+// chunks.
+type chunkIDConflict struct {
+	ID       string
+	Checksum string
+}
+
+func (e *chunkIDConflict) Error() string {
+	return fmt.Sprintf("chunk %s already exists with different audio", e.ID)
+}
+
+func (e *chunkIDConflict) Is(target error) bool { return target == ErrConflict }
+
+// body is the error response for the conflict, over HTTP or WebSocket.
+func (e *chunkIDConflict) body() map[string]any {
+	return map[string]any{"error": "chunk_id_conflict", "message": e.Error(), "existing_checksum": e.Checksum}
+}
+
+// chunkIDFor returns requested if it is a valid client-supplied chunk ID:
+// a UUID in its canonical form, or a match for cfg.ChunkIDPattern that is
+// also a safeChunkID. Without a request it returns a fresh UUID.
+func chunkIDFor(cfg Config, requested string) (string, error) {
+	if requested == "" {
+		return uuid.New().String(), nil
+	}
+	if safeChunkID.MatchString(requested) {
+		if _, err := uuid.Parse(requested); err == nil {
+			return requested, nil
+		}
+		if cfg.ChunkIDPattern != nil && cfg.ChunkIDPattern.MatchString(requested) {
+			return requested, nil
+		}
+	}
+	msg := "chunk_id must be a UUID"
+	if cfg.ChunkIDPattern != nil {
+		msg += " or match " + cfg.ChunkIDPattern.String()
+	}
+	return "", invalidParam("chunk_id", "invalid_chunk_id", msg)
+}
+
+// checkChunkIDs refuses requests to a chunk route whose {id} no chunk can
+// have, before any handler looks it up. Chunks stored before safeChunkID
+// was enforced, under a more permissive ChunkIDPattern, can still be read:
+// a GET or HEAD for an ID the store holds is let through, since reads only
+// look the ID up and never use it as a path.
+func checkChunkIDs(store *MemoryStore) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if id, ok := mux.Vars(r)["id"]; ok && !safeChunkID.MatchString(id) {
+				if route := mux.CurrentRoute(r); route != nil {
+					tmpl, _ := route.GetPathTemplate()
+					read := r.Method == http.MethodGet || r.Method == http.MethodHead
+					if strings.Contains(tmpl, "/chunks/{id}") && !(read && store.Stored(id)) {
+						writeError(w, invalidParam("id", "invalid_chunk_id", "not a chunk ID"))
+						return
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// claimChunkID reserves a client-supplied chunk ID for an upload of data by
+// userID until release is called. If the ID already names a chunk of the
+// user's with the same audio, that chunk is returned instead and nothing
+// is reserved.
+func (s *MemoryStore) claimChunkID(id, userID, checksum string) (existing *Metadata, release func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claimed[id] {
+		return nil, nil, errChunkIDInUse
+	}
+	if m, ok := s.lookupLocked(id); ok {
+		if m.UserID != userID {
+			return nil, nil, &chunkIDConflict{ID: id}
+		}
+		if m.Checksum == checksum {
+			return &m, nil, nil
+		}
+		return nil, nil, &chunkIDConflict{ID: id, Checksum: m.Checksum}
+	}
+	for _, m := range s.listByUserLocked(userID, true) {
+		if m.ParentChunkID == id {
+			return nil, nil, &chunkIDConflict{ID: id}
+		}
+	}
+	s.claimed[id] = true
+	return nil, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.claimed, id)
+	}, nil
+}
+
+// Processing reports whether id is claimed by an upload that has not been
+// saved yet.
+func (s *MemoryStore) Processing(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.claimed[id]
+}
+
+// Stored reports whether id names a chunk in the store, trashed or not.
+func (s *MemoryStore) Stored(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.lookupLocked(id)
+	return ok
+}
+
+// processingChecker is implemented by readers that know which chunk IDs
+// are held by uploads still being processed.
+type processingChecker interface {
+	Processing(id string) bool
+}
+
+// chunkProcessing is the body of a 202 for a chunk not saved yet.
+type chunkProcessing struct {
+	ChunkID string `json:"chunk_id"`
+	Status  string `json:"status"`
+}
+
+// writeChunkError writes err from looking up chunk id. A chunk whose
+// upload is still being processed is on its way, so instead of a 404 the
+// client would take as final it gets 202 and a Retry-After.
+func writeChunkError(w http.ResponseWriter, reader ChunkReader, id string, err error) {
+	if p, ok := reader.(processingChecker); ok && errors.Is(err, ErrNotFound) && p.Processing(id) {
+		w.Header().Set("Retry-After", "1")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(chunkProcessing{ChunkID: id, Status: "processing"})
+		return
+	}
+	writeError(w, err)
+}
@@ -0,0 +1,278 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func uploadWithID(t *testing.T, h *Harness, user, chunkID string, body []byte) (int, map[string]any) {
+	t.Helper()
+	resp, err := http.Post(h.URL+"/upload?user_id="+user+"&session_id=s1&chunk_id="+chunkID, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var reply map[string]any
+	json.NewDecoder(resp.Body).Decode(&reply)
+	return resp.StatusCode, reply
+}
+
+func TestClientChunkID(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ChunkIDPattern = regexp.MustCompile(`^(?:dev-[0-9]+)$`)
+	h := NewHarness(cfg)
+	defer h.Close()
+	wav := SineWAV(440, time.Second, 8000)
+	const id = "6f1c2a3e-8d4b-4c5a-9e7f-0a1b2c3d4e5f"
+
+	status, first := uploadWithID(t, h, "user1", id, wav)
+	if status != http.StatusOK || first["chunk_id"] != id {
+		t.Fatalf("Expected the chunk stored under the client's ID, but got %v %v", status, first)
+	}
+	if status, reply := uploadWithID(t, h, "user1", "dev-42", wav); status != http.StatusOK || reply["chunk_id"] != "dev-42" {
+		t.Errorf("Expected an ID matching the pattern to be accepted, but got %v %v", status, reply)
+	}
+	for _, bad := range []string{"dev-", "not-a-uuid", "dev-1/../x"} {
+		if status, reply := uploadWithID(t, h, "user1", bad, wav); status != http.StatusBadRequest || reply["error"] != "invalid_chunk_id" {
+			t.Errorf("%s: expected 400 invalid_chunk_id, but got %v %v", bad, status, reply)
+		}
+	}
+
+	// The same audio again is a retry and gets the stored chunk back.
+	if status, again := uploadWithID(t, h, "user1", id, wav); status != http.StatusOK || again["timestamp"] != first["timestamp"] {
+		t.Errorf("Expected the existing chunk for identical audio, but got %v %v", status, again)
+	}
+	if n := len(h.Store.ListByUser("user1")); n != 2 {
+		t.Errorf("Expected 2 stored chunks, but got %d", n)
+	}
+	status, conflict := uploadWithID(t, h, "user1", id, SineWAV(220, time.Second, 8000))
+	if status != http.StatusConflict || conflict["error"] != "chunk_id_conflict" || conflict["existing_checksum"] != first["checksum"] {
+		t.Errorf("Expected 409 with the existing checksum, but got %v %v", status, conflict)
+	}
+	if status, conflict := uploadWithID(t, h, "user2", id, wav); status != http.StatusConflict || conflict["existing_checksum"] != "" {
+		t.Errorf("Expected 409 without a checksum for another user's chunk, but got %v %v", status, conflict)
+	}
+	if m, _ := h.Store.Get(id); m.Checksum != first["checksum"] {
+		t.Errorf("Expected the stored chunk to be untouched, but got %+v", m)
+	}
+}
+
+func TestClientChunkIDOverWebSocket(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	const id = "0d9e8f7a-6b5c-4d3e-8f1a-2b3c4d5e6f70"
+	send := func(chunkID string, data []byte) map[string]any {
+		t.Helper()
+		conn.WriteJSON(wsEnvelope{Type: "chunk", Data: data, ChunkID: chunkID})
+		var reply map[string]any
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		return reply
+	}
+
+	wav := SineWAV(440, time.Second, 8000)
+	if ack := send(id, wav); ack["chunk_id"] != id || ack["duplicate"] != nil {
+		t.Errorf("Expected the chunk acked under the client's ID, but got %v", ack)
+	}
+	if ack := send(id, wav); ack["chunk_id"] != id || ack["duplicate"] != true {
+		t.Errorf("Expected identical audio acked as a duplicate, but got %v", ack)
+	}
+	if reply := send(id, []byte("other audio")); reply["error"] != "chunk_id_conflict" || reply["existing_checksum"] == "" {
+		t.Errorf("Expected a conflict with the existing checksum, but got %v", reply)
+	}
+	if reply := send("bad id", wav); reply["error"] != "invalid_chunk_id" {
+		t.Errorf("Expected the ID to be refused, but got %v", reply)
+	}
+}
+
+func TestChunkReadsWhileProcessing(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReadCacheSize = 16
+	h := NewHarness(cfg)
+	defer h.Close()
+	gate := &gateTranscriber{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+	const id = "5f0c1d2e-3a4b-4c5d-8e6f-7a8b9c0d1e2f"
+	paths := []string{"/chunks/" + id, "/chunks/" + id + "/transcript", "/chunks/" + id + "/audio"}
+	get := func(path string) (*http.Response, map[string]any) {
+		t.Helper()
+		resp, err := http.Get(h.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]any
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+
+	for _, path := range paths {
+		if resp, _ := get(path); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for an unknown ID, but got %v", path, resp.StatusCode)
+		}
+	}
+
+	uploaded := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1&chunk_id="+id, "audio/wav", bytes.NewReader(SineWAV(440, 100*time.Millisecond, 8000)))
+		if err != nil {
+			uploaded <- 0
+			return
+		}
+		resp.Body.Close()
+		uploaded <- resp.StatusCode
+	}()
+	<-gate.entered
+	for _, path := range paths {
+		resp, body := get(path)
+		if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Retry-After") == "" || body["status"] != "processing" || body["chunk_id"] != id {
+			t.Errorf("%s: expected 202 processing while the upload runs, but got %v %v", path, resp.StatusCode, body)
+		}
+	}
+
+	close(gate.release)
+	if status := <-uploaded; status != http.StatusOK {
+		t.Fatalf("Expected the upload to succeed, but got %v", status)
+	}
+	for _, path := range paths {
+		if resp, _ := get(path); resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200 once saved, but got %v", path, resp.StatusCode)
+		}
+	}
+}
+
+// traversalIDs are chunk IDs, as they appear in a URL path, that try to
+// reach outside the data directory.
+var traversalIDs = []string{
+	"..",
+	"%2e%2e",
+	"..%2Fsecret.wav",
+	"..%2F..%2F..%2Fetc%2Fpasswd",
+	"..%5Csecret.wav",
+	"%2E%2E%5C%2E%2E%5Cetc%5Cpasswd",
+	".secret",
+	"secret.wav%00",
+	"urn:uuid:6f1c2a3e-8d4b-4c5a-9e7f-0a1b2c3d4e5f",
+}
+
+func TestChunkIDTraversal(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.wav")
+	os.WriteFile(secret, []byte("SECRET"), 0o600)
+	cfg := DefaultConfig()
+	cfg.ArchiveDir = filepath.Join(dir, "data")
+	// Even an operator pattern admitting anything cannot let these in.
+	cfg.ChunkIDPattern = regexp.MustCompile(`^.+$`)
+	h := NewHarness(cfg)
+	defer h.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+
+	for _, id := range traversalIDs {
+		raw, _ := url.PathUnescape(id)
+		if status, reply := uploadWithID(t, h, "user1", url.QueryEscape(raw), wav); status != http.StatusBadRequest || reply["error"] != "invalid_chunk_id" {
+			t.Errorf("upload %s: expected 400 invalid_chunk_id, but got %v %v", id, status, reply)
+		}
+		for _, req := range []struct{ method, path string }{
+			{"GET", "/chunks/" + id},
+			{"GET", "/chunks/" + id + "/audio"},
+			{"POST", "/chunks/" + id + "/trim"},
+			{"DELETE", "/chunks/" + id},
+		} {
+			r, _ := http.NewRequest(req.method, h.URL+req.path, strings.NewReader(`{"start_ms": 0, "end_ms": 50}`))
+			resp, err := http.DefaultTransport.RoundTrip(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusBadRequest || bytes.Contains(body, []byte("SECRET")) {
+				t.Errorf("%s %s: expected 400, but got %d %s", req.method, req.path, resp.StatusCode, body)
+			}
+		}
+	}
+	if data, err := os.ReadFile(secret); err != nil || string(data) != "SECRET" {
+		t.Errorf("Expected the file outside the data directory untouched, but got %q %v", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected nothing written beside the data directory, but found %d entries", len(entries))
+	}
+	if len(h.Store.ListByUser("user1")) != 0 {
+		t.Errorf("Expected no chunks stored")
+	}
+}
+
+// TestLegacyChunkIDsReadable checks that a chunk stored under an ID an
+// earlier, more permissive ChunkIDPattern admitted can still be read, but
+// not changed.
+func TestLegacyChunkIDsReadable(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	const id = "legacy chunk:1"
+	if err := h.Store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "s1", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []struct {
+		method, id string
+		status     int
+	}{
+		{"GET", id, http.StatusOK},
+		{"DELETE", id, http.StatusBadRequest},
+		{"GET", "never stored:1", http.StatusBadRequest},
+	} {
+		r, _ := http.NewRequest(req.method, h.URL+"/chunks/"+url.PathEscape(req.id)+"?user_id=user1", nil)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != req.status {
+			t.Errorf("%s %q: expected status code %d, but got %d", req.method, req.id, req.status, resp.StatusCode)
+		}
+	}
+}
+
+// FuzzChunkIDFor checks that no ID chunkIDFor accepts, even under a
+// pattern admitting anything, can name a path outside the directory it is
+// stored in. The seed corpus is in testdata/fuzz/FuzzChunkIDFor.
+func FuzzChunkIDFor(f *testing.F) {
+	for _, id := range traversalIDs {
+		raw, _ := url.PathUnescape(id)
+		f.Add(raw)
+	}
+	f.Add("6f1c2a3e-8d4b-4c5a-9e7f-0a1b2c3d4e5f")
+	f.Add("dev-42")
+	cfg := DefaultConfig()
+	cfg.ChunkIDPattern = regexp.MustCompile(`^(?s:.+)$`)
+	f.Fuzz(func(t *testing.T, id string) {
+		got, err := chunkIDFor(cfg, id)
+		if err != nil {
+			return
+		}
+		if id != "" && got != id {
+			t.Fatalf("Expected %q back, but got %q", id, got)
+		}
+		if strings.ContainsAny(got, "/\\\x00") || strings.HasPrefix(got, ".") {
+			t.Fatalf("Accepted %q, which is not safe as a file name", got)
+		}
+		if dir := filepath.Dir(filepath.Join("data", got)); dir != "data" {
+			t.Fatalf("Accepted %q, which leaves its directory for %s", got, dir)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPFrom returns the address captureClientIP resolved for the
+// request, if any.
+func clientIPFrom(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey).(string)
+	return ip
+}
+
+// captureClientIP resolves the client address once per request so every
+// chunk it carries, including those on a WebSocket, is attributed to it.
+func captureClientIP(cfg Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := clientIP(r, cfg.TrustedProxies)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+		})
+	}
+}
+
+// clientIP returns the address of the client behind r. Forwarding headers
+// are only believed when the peer is a trusted proxy: X-Forwarded-For is
+// walked from the nearest hop outwards and the first untrusted address
+// wins, falling back to X-Real-IP when there is no usable hop.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	peer, ok := parseIP(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !inPrefixes(peer, trusted) {
+		return peer.String()
+	}
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	client := netip.Addr{}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, ok := parseIP(hops[i])
+		if !ok {
+			break
+		}
+		client = ip
+		if !inPrefixes(ip, trusted) {
+			break
+		}
+	}
+	if !client.IsValid() {
+		if ip, ok := parseIP(r.Header.Get("X-Real-IP")); ok {
+			client = ip
+		}
+	}
+	if !client.IsValid() {
+		return peer.String()
+	}
+	return client.String()
+}
+
+// parseIP accepts a bare address or host:port, IPv6 with or without
+// brackets, and maps IPv4-in-IPv6 addresses back to IPv4.
+func parseIP(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.Unmap().WithZone(""), true
+}
+
+func inPrefixes(ip netip.Addr, prefixes []netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes reads a comma-separated list of CIDRs or single addresses,
+// skipping empty entries. It fails on the first entry that does not parse.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+		} else if ip, ok := parseIP(s); ok {
+			prefixes = append(prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+		} else {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", s)
+		}
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func mustParsePrefixes(list string) []netip.Prefix {
+	prefixes, err := parsePrefixes(list)
+	if err != nil {
+		panic(err)
+	}
+	return prefixes
+}
+
+func TestParsePrefixes(t *testing.T) {
+	got, err := parsePrefixes(" 10.0.0.0/8,,192.0.2.1 ,")
+	if err != nil || len(got) != 2 || got[1] != netip.MustParsePrefix("192.0.2.1/32") {
+		t.Errorf("Expected two prefixes with the empty entries skipped, but got %v %v", got, err)
+	}
+	for _, list := range []string{"10.0.0.0/33", "10.0.0.0/8,example.com", "10.0.0.0/8;192.0.2.0/24"} {
+		if got, err := parsePrefixes(list); err == nil {
+			t.Errorf("%q: expected an error, but got %v", list, got)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := mustParsePrefixes("10.0.0.0/8, fd00::/8, 192.0.2.1")
+	for _, tc := range []struct {
+		name   string
+		peer   string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{name: "untrusted peer ignores headers", peer: "203.0.113.9:1234", xff: []string{"198.51.100.1"}, realIP: "198.51.100.2", want: "203.0.113.9"},
+		{name: "trusted peer without headers", peer: "10.1.2.3:80", want: "10.1.2.3"},
+		{name: "single hop", peer: "10.1.2.3:80", xff: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed leftmost hop is skipped", peer: "10.1.2.3:80", xff: []string{"1.1.1.1, 198.51.100.1, 10.9.9.9"}, want: "198.51.100.1"},
+		{name: "hops across headers", peer: "192.0.2.1:443", xff: []string{"1.1.1.1", "198.51.100.7, 10.0.0.2"}, want: "198.51.100.7"},
+		{name: "all hops trusted", peer: "10.1.2.3:80", xff: []string{"10.0.0.5, 10.0.0.6"}, want: "10.0.0.5"},
+		{name: "garbage hop stops the walk", peer: "10.1.2.3:80", xff: []string{"198.51.100.1, nonsense"}, want: "10.1.2.3"},
+		{name: "real ip fallback", peer: "10.1.2.3:80", realIP: "198.51.100.3", want: "198.51.100.3"},
+		{name: "ipv6 peer", peer: "[2001:db8::1]:443", want: "2001:db8::1"},
+		{name: "ipv6 hops", peer: "[fd00::1]:443", xff: []string{"2001:db8::2, [fd00::3]:8080"}, want: "2001:db8::2"},
+		{name: "ipv4 mapped ipv6", peer: "[::ffff:10.1.2.3]:80", xff: []string{"::ffff:198.51.100.4"}, want: "198.51.100.4"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.peer
+			for _, h := range tc.xff {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			if got := clientIP(r, trusted); got != tc.want {
+				t.Errorf("Expected %s, but got %s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestUploadRecordsSourceIP(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.TrustedProxies = mustParsePrefixes("127.0.0.1")
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	direct := uploadTo(t, h, "u1", "s", []byte("audio"))
+	if direct.SourceIP != "127.0.0.1" {
+		t.Errorf("Expected the peer address, but got %q", direct.SourceIP)
+	}
+	req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=u2&session_id=s", strings.NewReader("audio"))
+	req.Header.Set("X-Forwarded-For", "198.51.100.20")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	req, _ = http.NewRequest("GET", h.URL+"/admin/chunks?source_ip=198.51.100.0/24", nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var listed []Metadata
+	json.NewDecoder(resp.Body).Decode(&listed)
+	if len(listed) != 1 || listed[0].UserID != "u2" || listed[0].SourceIP != "198.51.100.20" {
+		t.Errorf("Expected only the forwarded upload, but got %+v", listed)
+	}
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+func invalidClientMetadata(format string, args ...any) error {
+	return invalidField("client_metadata", "invalid_client_metadata", fmt.Sprintf(format, args...))
+}
+
+// validateClientMetadata checks that raw is a JSON object no larger than
+// maxBytes whose leaves are all scalars. Nested objects are allowed; arrays
+// are not. The input is returned unchanged so it round-trips verbatim.
+func validateClientMetadata(raw []byte, maxBytes int) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if len(raw) > maxBytes {
+		return nil, invalidClientMetadata("exceeds %d bytes", maxBytes)
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, invalidClientMetadata("%v", err)
+	}
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, invalidClientMetadata("must be a JSON object")
+	}
+	if err := checkScalarLeaves(obj, ""); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(raw), nil
+}
+
+func checkScalarLeaves(obj map[string]any, prefix string) error {
+	for k, v := range obj {
+		switch v := v.(type) {
+		case map[string]any:
+			if err := checkScalarLeaves(v, prefix+k+"."); err != nil {
+				return err
+			}
+		case []any:
+			return invalidClientMetadata("%s%s must be a scalar, not an array", prefix, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestValidateClientMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"flat scalars", `{"device":"pixel","app_version":3,"beta":true,"note":null}`, false},
+		{"nested scalars", `{"gps":{"lat":51.5,"lon":-0.12},"app":{"build":{"id":"abc"}}}`, false},
+		{"top-level array", `[{"device":"pixel"}]`, true},
+		{"top-level scalar", `"pixel"`, true},
+		{"nested array", `{"tags":["a","b"]}`, true},
+		{"malformed", `{"device":`, true},
+		{"oversized", `{"blob":"` + string(bytes.Repeat([]byte("x"), 100)) + `"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validateClientMetadata([]byte(tt.raw), 64)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, but got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestClientMetadataRoundTrip(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	clientMeta := `{"device":"pixel 8","gps":{"lat":51.5,"lon":-0.12},"app_version":"2.1.0"}`
+
+	req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=user1&session_id=sess1", bytes.NewReader(SineWAV(440, 50*time.Millisecond, 8000)))
+	req.Header.Set("X-Client-Metadata", clientMeta)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded Metadata
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+
+	resp, err = http.Get(h.URL + "/chunks/" + uploaded.ChunkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Metadata
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if string(got.ClientMetadata) != clientMeta {
+		t.Errorf("Expected client metadata %s, but got %s", clientMeta, got.ClientMetadata)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, _ := mw.CreateFormFile("audio", "chunk.wav")
+	part.Write(SineWAV(440, 50*time.Millisecond, 8000))
+	mw.WriteField("client_metadata", `["not","an","object"]`)
+	mw.Close()
+	resp, err = http.Post(h.URL+"/upload?user_id=user1&session_id=sess1", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status code 422 for a top-level array, but got %v", resp.StatusCode)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(map[string]any{
+		"type":            "chunk",
+		"data":            SineWAV(440, 50*time.Millisecond, 8000),
+		"client_metadata": json.RawMessage(clientMeta),
+	})
+	var ack struct {
+		Metadata Metadata `json:"metadata"`
+	}
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(ack.Metadata.ClientMetadata) != clientMeta {
+		t.Errorf("Expected WS client metadata %s, but got %s", clientMeta, ack.Metadata.ClientMetadata)
+	}
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"strconv"
+	"time"
+)
+
+// What to do with a WebSocket client whose clock is off by more than
+// Config.WSClockSkewThreshold.
+const (
+	clockSkewWarn   = "warn"
+	clockSkewReject = "reject"
+)
+
+// rawRecordedAtKey is the client_metadata key that keeps a chunk's
+// recorded_at as the client sent it, before the skew correction.
+const rawRecordedAtKey = "raw_recorded_at"
+
+// clockSkewBuckets are the upper bounds of the ws_clock_skew histogram,
+// keyed "le_<seconds>"; larger skews count under "gt_300". Buckets are not
+// cumulative.
+var clockSkewBuckets = []time.Duration{time.Second, 5 * time.Second, 30 * time.Second, time.Minute, 5 * time.Minute}
+
+var wsClockSkew = expvar.NewMap("ws_clock_skew")
+
+// observeClockSkew counts an offset's magnitude in the ws_clock_skew
+// histogram.
+func observeClockSkew(offset time.Duration) {
+	offset = max(offset, -offset)
+	for _, b := range clockSkewBuckets {
+		if offset <= b {
+			wsClockSkew.Add("le_"+formatSeconds(b), 1)
+			return
+		}
+	}
+	wsClockSkew.Add("gt_"+formatSeconds(clockSkewBuckets[len(clockSkewBuckets)-1]), 1)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Second))
+}
+
+// clockOffset is how far ahead of the server a client's clock runs, as
+// measured from the client_time it sent in a hello. Transit time is not
+// subtracted, so it counts against the client; it is small next to the
+// skews this is meant to catch.
+func clockOffset(clientTime, received time.Time) time.Duration {
+	return clientTime.Sub(received).Round(time.Millisecond)
+}
+
+// correctRecordedAt moves recordedAt onto the server's clock and keeps the
+// client's value in clientMeta under raw_recorded_at. Metadata that
+// already has that key keeps the client's own value.
+func correctRecordedAt(recordedAt time.Time, offset time.Duration, clientMeta json.RawMessage) (time.Time, json.RawMessage) {
+	if offset == 0 {
+		return recordedAt, clientMeta
+	}
+	corrected := recordedAt.Add(-offset)
+	raw, _ := json.Marshal(recordedAt)
+	field := append([]byte(`"`+rawRecordedAtKey+`":`), raw...)
+	if len(clientMeta) == 0 {
+		return corrected, json.RawMessage(append(append([]byte("{"), field...), '}'))
+	}
+	var existing map[string]json.RawMessage
+	if err := json.Unmarshal(clientMeta, &existing); err != nil || existing[rawRecordedAtKey] != nil {
+		return corrected, clientMeta
+	}
+	// Splice the key in so the rest of the object round-trips verbatim.
+	body := bytes.TrimSpace(clientMeta[1:])
+	out := append([]byte("{"), field...)
+	if !bytes.HasPrefix(body, []byte("}")) {
+		out = append(out, ',')
+	}
+	return corrected, json.RawMessage(append(out, body...))
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func skewedHello(t *testing.T, h *Harness, offset time.Duration) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	clientTime := time.Now().Add(offset)
+	conn.WriteJSON(wsEnvelope{Type: "hello", ClientTime: &clientTime})
+	return conn
+}
+
+func skewCount(bucket string) int64 {
+	if v, ok := wsClockSkew.Get(bucket).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func near(got, want, tolerance time.Duration) bool {
+	return got >= want-tolerance && got <= want+tolerance
+}
+
+func TestWSClockSkewWarnsAndCorrects(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	before := skewCount("le_300")
+	conn := skewedHello(t, h, 90*time.Second)
+	defer conn.Close()
+
+	var hello struct {
+		Type          string `json:"type"`
+		ClockOffsetMS int64  `json:"clock_offset_ms"`
+	}
+	conn.ReadJSON(&hello)
+	if hello.Type != "hello" || !near(time.Duration(hello.ClockOffsetMS)*time.Millisecond, 90*time.Second, time.Second) {
+		t.Errorf("Expected a measured offset of about 90s, but got %+v", hello)
+	}
+	var warning map[string]any
+	if conn.ReadJSON(&warning); warning["type"] != "clock_skew" || warning["threshold_ms"] != float64(30000) {
+		t.Errorf("Expected a clock_skew warning, but got %v", warning)
+	}
+	if got := skewCount("le_300"); got != before+1 {
+		t.Errorf("Expected the skew to be counted in the le_300 bucket, but got %d after %d", got, before)
+	}
+
+	// The client stamps a chunk captured a second ago by its own clock.
+	captured := time.Now().Add(-time.Second)
+	raw := captured.Add(90 * time.Second)
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: SineWAV(440, 100*time.Millisecond, 8000), RecordedAt: &raw, ClientMetadata: json.RawMessage(`{"device": "mic-1"}`)})
+	var ack struct {
+		Metadata Metadata `json:"metadata"`
+	}
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if m := ack.Metadata; m.RecordedAt == nil || !near(m.RecordedAt.Sub(captured), 0, time.Second) {
+		t.Errorf("Expected recorded_at corrected to about %v, but got %v", captured, m.RecordedAt)
+	}
+	var clientMeta struct {
+		Device        string    `json:"device"`
+		RawRecordedAt time.Time `json:"raw_recorded_at"`
+	}
+	json.Unmarshal(ack.Metadata.ClientMetadata, &clientMeta)
+	if clientMeta.Device != "mic-1" || !clientMeta.RawRecordedAt.Equal(raw) {
+		t.Errorf("Expected the raw recorded_at kept beside the client's metadata, but got %s", ack.Metadata.ClientMetadata)
+	}
+}
+
+func TestWSClockSkewReject(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WSClockSkewAction = clockSkewReject
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	ok := skewedHello(t, h, 2*time.Second)
+	defer ok.Close()
+	var hello map[string]any
+	if ok.ReadJSON(&hello); hello["type"] != "hello" {
+		t.Errorf("Expected a small skew to be accepted, but got %v", hello)
+	}
+
+	conn := skewedHello(t, h, 90*time.Second)
+	defer conn.Close()
+	var reply map[string]any
+	if conn.ReadJSON(&reply); reply["error"] != "clock_skew" || !near(time.Duration(reply["offset_ms"].(float64))*time.Millisecond, 90*time.Second, time.Second) {
+		t.Errorf("Expected a clock_skew error with the offset, but got %v", reply)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+}
+
+func TestCorrectRecordedAt(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{``, `{"raw_recorded_at":"2024-03-01T12:00:00Z"}`},
+		{`{}`, `{"raw_recorded_at":"2024-03-01T12:00:00Z"}`},
+		{`{"a": 1}`, `{"raw_recorded_at":"2024-03-01T12:00:00Z","a": 1}`},
+		{`{"raw_recorded_at": "mine"}`, `{"raw_recorded_at": "mine"}`},
+	} {
+		corrected, meta := correctRecordedAt(at, time.Minute, json.RawMessage(tc.in))
+		if !corrected.Equal(at.Add(-time.Minute)) || string(meta) != tc.want {
+			t.Errorf("%s: expected %s, but got %v %s", tc.in, tc.want, corrected, meta)
+		}
+	}
+	if _, meta := correctRecordedAt(at, 0, nil); meta != nil {
+		t.Errorf("Expected no raw value without a skew, but got %s", meta)
+	}
+}
@@ -0,0 +1,373 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Differences below these are not material: the frequency is measured to
+// a few hertz, and loudness to a hundredth of a decibel.
+const (
+	materialFrequencyHz = 5
+	materialLoudnessDB  = 0.5
+)
+
+// maxCompareChunks bounds how many chunks of a session one comparison
+// reprocesses.
+const maxCompareChunks = 500
+
+// compareResultTTL is how long a finished session comparison can be read
+// back; the next comparison started after that drops it.
+const compareResultTTL = time.Hour
+
+// maxWordDiffCells bounds the word diff's table; longer transcripts are
+// reported as replaced wholesale.
+const maxWordDiffCells = 4 << 20
+
+// ChunkComparison is what POST /chunks/{id}/compare returns: how the
+// stored metadata differs from what the current pipeline makes of the same
+// audio. Changed lists the fields that differ materially, by JSON name.
+type ChunkComparison struct {
+	ChunkID    string          `json:"chunk_id"`
+	Changed    []string        `json:"changed"`
+	Transcript *TranscriptDiff `json:"transcript,omitempty"`
+	Frequency  *NumberDiff     `json:"frequency_hz,omitempty"`
+	Loudness   *NumberDiff     `json:"loudness_dbfs,omitempty"`
+	Duration   *NumberDiff     `json:"duration_ms,omitempty"`
+	// Fields holds the other differing fields, stored and new values.
+	Fields map[string][2]any `json:"fields,omitempty"`
+	// DryRun is the metadata the pipeline produced. It was not saved.
+	DryRun Metadata `json:"dry_run"`
+}
+
+// NumberDiff compares a measurement.
+type NumberDiff struct {
+	Stored   float64 `json:"stored"`
+	New      float64 `json:"new"`
+	Delta    float64 `json:"delta"`
+	Material bool    `json:"material"`
+}
+
+// TranscriptDiff lists the word edits that turn the stored transcript into
+// the new one. Position is where in the stored words each edit applies.
+type TranscriptDiff struct {
+	Stored string     `json:"stored"`
+	New    string     `json:"new"`
+	Edits  []WordEdit `json:"edits"`
+}
+
+// WordEdit is an insert, delete or replace of a run of words.
+type WordEdit struct {
+	Op       string   `json:"op"`
+	Position int      `json:"position"`
+	Stored   []string `json:"stored,omitempty"`
+	New      []string `json:"new,omitempty"`
+}
+
+// SessionComparison summarises comparing every chunk of a session. Changed
+// counts chunks per materially changed field; Chunks lists only the chunks
+// that changed.
+type SessionComparison struct {
+	UserID    string            `json:"user_id"`
+	SessionID string            `json:"session_id"`
+	Compared  int               `json:"compared"`
+	Unchanged int               `json:"unchanged"`
+	Failed    int               `json:"failed"`
+	Truncated bool              `json:"truncated,omitempty"`
+	Changed   map[string]int    `json:"changed"`
+	Chunks    []ChunkComparison `json:"chunks"`
+}
+
+var errCompareNotFound = newKindError(ErrNotFound, "comparison not found")
+
+// SessionCompareStatus is a session comparison's progress. Result is set
+// once State is done.
+type SessionCompareStatus struct {
+	ID         string             `json:"id"`
+	UserID     string             `json:"user_id"`
+	SessionID  string             `json:"session_id"`
+	State      string             `json:"state"`
+	CreatedAt  time.Time          `json:"created_at"`
+	FinishedAt *time.Time         `json:"finished_at,omitempty"`
+	Result     *SessionComparison `json:"result,omitempty"`
+}
+
+// SessionComparer compares sessions in the background, since a session
+// can take far longer to reprocess than a request should stay open.
+type SessionComparer struct {
+	Clock Clock
+
+	store      *MemoryStore
+	pipeline   *Pipeline
+	goroutines *Goroutines
+
+	mu   sync.Mutex
+	jobs map[string]*SessionCompareStatus
+}
+
+// NewSessionComparer runs comparisons through p on goroutines.
+func NewSessionComparer(store *MemoryStore, p *Pipeline, goroutines *Goroutines) *SessionComparer {
+	return &SessionComparer{Clock: realClock{}, store: store, pipeline: p, goroutines: goroutines, jobs: make(map[string]*SessionCompareStatus)}
+}
+
+// Start queues a comparison of the session and returns its initial
+// status. Comparisons finished more than compareResultTTL ago are dropped.
+func (c *SessionComparer) Start(userID, sessionID string) SessionCompareStatus {
+	now := c.Clock.Now().UTC()
+	st := &SessionCompareStatus{ID: uuid.New().String(), UserID: userID, SessionID: sessionID, State: jobRunning, CreatedAt: now}
+	c.mu.Lock()
+	for id, old := range c.jobs {
+		if old.FinishedAt != nil && now.Sub(*old.FinishedAt) > compareResultTTL {
+			delete(c.jobs, id)
+		}
+	}
+	c.jobs[st.ID] = st
+	snapshot := *st
+	c.mu.Unlock()
+	c.goroutines.Go("compare", func(ctx context.Context) { c.run(ctx, st) })
+	return snapshot
+}
+
+// Status returns a comparison of the session; comparisons of other
+// sessions are reported as missing.
+func (c *SessionComparer) Status(userID, sessionID, id string) (SessionCompareStatus, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st, ok := c.jobs[id]
+	if !ok || st.UserID != userID || st.SessionID != sessionID {
+		return SessionCompareStatus{}, false
+	}
+	return *st, true
+}
+
+func (c *SessionComparer) run(ctx context.Context, st *SessionCompareStatus) {
+	result := compareSession(ctx, c.store, c.pipeline, st.UserID, st.SessionID)
+	finished := c.Clock.Now().UTC()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st.FinishedAt = &finished
+	if ctx.Err() != nil {
+		st.State = jobInterrupted
+		log.Printf("Comparison %s of %s/%s stopped after %d chunks", st.ID, st.UserID, st.SessionID, result.Compared)
+		return
+	}
+	st.State = jobDone
+	st.Result = &result
+}
+
+// compareSession compares the session's chunks, oldest first, until ctx
+// ends.
+func compareSession(ctx context.Context, store *MemoryStore, p *Pipeline, userID, sessionID string) SessionComparison {
+	chunks := store.ListBySession(userID, sessionID)
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Timestamp.Before(chunks[j].Timestamp) })
+	result := SessionComparison{UserID: userID, SessionID: sessionID, Changed: map[string]int{}, Chunks: []ChunkComparison{}}
+	if len(chunks) > maxCompareChunks {
+		chunks, result.Truncated = chunks[:maxCompareChunks], true
+	}
+	for _, m := range chunks {
+		if ctx.Err() != nil {
+			break
+		}
+		c, err := compareChunk(ctx, store, p, m)
+		result.Compared++
+		switch {
+		case err != nil:
+			result.Failed++
+		case len(c.Changed) == 0:
+			result.Unchanged++
+		default:
+			for _, field := range c.Changed {
+				result.Changed[field]++
+			}
+			result.Chunks = append(result.Chunks, c)
+		}
+	}
+	return result
+}
+
+// compareChunk processes m's stored audio through p without saving the
+// result, and diffs the result against m.
+func compareChunk(ctx context.Context, store *MemoryStore, p *Pipeline, m Metadata) (ChunkComparison, error) {
+	data, err := store.GetBlob(m.ChunkID)
+	if err != nil {
+		return ChunkComparison{}, err
+	}
+	if m, err = withFullTranscript(store, m); err != nil {
+		return ChunkComparison{}, err
+	}
+	settings, _ := store.UserSettings(m.UserID)
+	chunk := storedChunk(m, data, settings)
+	chunk.DryRun = true
+	return diffMetadata(m, p.Process(ctx, chunk)), nil
+}
+
+// diffMetadata compares what the pipeline determines about a chunk; what
+// the upload supplied, such as IDs and client metadata, is left out.
+func diffMetadata(stored, fresh Metadata) ChunkComparison {
+	c := ChunkComparison{ChunkID: stored.ChunkID, Changed: []string{}, DryRun: fresh}
+	if stored.Transcript != fresh.Transcript {
+		c.Transcript = &TranscriptDiff{Stored: stored.Transcript, New: fresh.Transcript, Edits: diffWords(strings.Fields(stored.Transcript), strings.Fields(fresh.Transcript))}
+		c.Changed = append(c.Changed, "transcript")
+	}
+	number := func(field string, a, b, material float64) *NumberDiff {
+		if a == b {
+			return nil
+		}
+		d := &NumberDiff{Stored: a, New: b, Delta: math.Round((b-a)*100) / 100, Material: math.Abs(b-a) >= material}
+		if d.Material {
+			c.Changed = append(c.Changed, field)
+		}
+		return d
+	}
+	storedHz, okStored := parseHz(stored.FFT)
+	freshHz, okFresh := parseHz(fresh.FFT)
+	if okStored && okFresh {
+		c.Frequency = number("frequency_hz", storedHz, freshHz, materialFrequencyHz)
+	}
+	if stored.LoudnessDBFS != nil && fresh.LoudnessDBFS != nil {
+		c.Loudness = number("loudness_dbfs", *stored.LoudnessDBFS, *fresh.LoudnessDBFS, materialLoudnessDB)
+	}
+	c.Duration = number("duration_ms", float64(stored.DurationMS), float64(fresh.DurationMS), 1)
+
+	fields := map[string][2]any{}
+	other := func(name string, a, b any, equal bool) {
+		if !equal {
+			fields[name] = [2]any{a, b}
+			c.Changed = append(c.Changed, name)
+		}
+	}
+	if !okStored || !okFresh {
+		other("fft", stored.FFT, fresh.FFT, stored.FFT == fresh.FFT)
+	}
+	if stored.LoudnessDBFS == nil || fresh.LoudnessDBFS == nil {
+		other("loudness_dbfs", stored.LoudnessDBFS, fresh.LoudnessDBFS, (stored.LoudnessDBFS == nil) == (fresh.LoudnessDBFS == nil))
+	}
+	other("status", stored.Status, fresh.Status, stored.Status == fresh.Status)
+	other("fingerprint", stored.Fingerprint, fresh.Fingerprint, stored.Fingerprint == fresh.Fingerprint)
+	other("anomalies", stored.Anomalies, fresh.Anomalies, slices.Equal(stored.Anomalies, fresh.Anomalies))
+	other("transcript_skip_reason", stored.TranscriptSkipReason, fresh.TranscriptSkipReason, stored.TranscriptSkipReason == fresh.TranscriptSkipReason)
+	if len(fields) > 0 {
+		c.Fields = fields
+	}
+	return c
+}
+
+// parseHz reads an FFT value such as "440Hz".
+func parseHz(s string) (float64, bool) {
+	hz, err := strconv.ParseFloat(strings.TrimSuffix(s, "Hz"), 64)
+	return hz, err == nil && strings.HasSuffix(s, "Hz")
+}
+
+// diffWords returns the edits of a longest-common-subsequence diff from a
+// to b, adjacent deletes and inserts merged into replaces.
+func diffWords(a, b []string) []WordEdit {
+	if len(a)*len(b) > maxWordDiffCells {
+		return []WordEdit{{Op: "replace", Stored: a, New: b}}
+	}
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var edits []WordEdit
+	var cur *WordEdit
+	flush := func() {
+		if cur != nil {
+			switch {
+			case len(cur.Stored) > 0 && len(cur.New) > 0:
+				cur.Op = "replace"
+			case len(cur.Stored) > 0:
+				cur.Op = "delete"
+			default:
+				cur.Op = "insert"
+			}
+			edits = append(edits, *cur)
+			cur = nil
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			i, j = i+1, j+1
+			continue
+		case cur == nil:
+			cur = &WordEdit{Position: i}
+		}
+		if j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1] {
+			cur.Stored = append(cur.Stored, a[i])
+			i++
+		} else {
+			cur.New = append(cur.New, b[j])
+			j++
+		}
+	}
+	flush()
+	return edits
+}
+
+func handleCompareChunk(reader ChunkReader, store *MemoryStore, p *Pipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		m, err := reader.Get(id)
+		if err != nil {
+			writeChunkError(w, reader, id, err)
+			return
+		}
+		c, err := compareChunk(r.Context(), store, p, m)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	}
+}
+
+func handleCompareSession(c *SessionComparer, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, sessionID := mux.Vars(r)["user_id"], mux.Vars(r)["session_id"]
+		if err := validateIDs(cfg, userID, sessionID); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(c.Start(userID, sessionID))
+	}
+}
+
+func handleGetSessionComparison(c *SessionComparer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		st, ok := c.Status(vars["user_id"], vars["session_id"], vars["job_id"])
+		if !ok {
+			writeError(w, errCompareNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareHighlightsTranscriptChange(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Pipeline.Transcriber = scriptTranscriber("hello world")
+	wav := SineWAV(440, time.Second, 8000)
+	first := uploadTo(t, h, "user1", "s1", wav)
+	second := uploadTo(t, h, "user1", "s1", wav)
+	before, _ := h.Store.Get(first.ChunkID)
+	if before.FFT != "440Hz" {
+		t.Errorf("Expected the dominant frequency to be measured, but got %q", before.FFT)
+	}
+
+	var same ChunkComparison
+	if code := adminDo(t, h, "POST", "/chunks/"+first.ChunkID+"/compare", nil, &same); code != http.StatusOK || len(same.Changed) != 0 || same.Transcript != nil {
+		t.Fatalf("Expected no change under the same pipeline, but got %d %+v", code, same)
+	}
+
+	h.Pipeline.Transcriber = scriptTranscriber("hello there world")
+	var diff ChunkComparison
+	if code := adminDo(t, h, "POST", "/chunks/"+first.ChunkID+"/compare", nil, &diff); code != http.StatusOK {
+		t.Fatalf("Expected 200, but got %d", code)
+	}
+	if !reflect.DeepEqual(diff.Changed, []string{"transcript"}) || diff.Frequency != nil || diff.Loudness != nil || diff.Fields != nil {
+		t.Errorf("Expected only the transcript to change, but got %+v", diff)
+	}
+	want := []WordEdit{{Op: "insert", Position: 1, New: []string{"there"}}}
+	if diff.Transcript == nil || !reflect.DeepEqual(diff.Transcript.Edits, want) {
+		t.Errorf("Expected %+v, but got %+v", want, diff.Transcript)
+	}
+	if diff.DryRun.Transcript != "hello there world" {
+		t.Errorf("Expected the dry run's metadata, but got %+v", diff.DryRun)
+	}
+	if after, _ := h.Store.Get(first.ChunkID); !reflect.DeepEqual(after, before) {
+		t.Errorf("Expected the stored metadata untouched, but got %+v", after)
+	}
+
+	var started SessionCompareStatus
+	if code := adminDo(t, h, "POST", "/sessions/user1/s1/compare", nil, &started); code != http.StatusAccepted || started.State != jobRunning {
+		t.Fatalf("Expected 202 and a running comparison, but got %d %+v", code, started)
+	}
+	if code := adminDo(t, h, "GET", "/sessions/user1/s2/compare/"+started.ID, nil, nil); code != http.StatusNotFound {
+		t.Errorf("Expected another session's comparison to be missing, but got %d", code)
+	}
+	var status SessionCompareStatus
+	for deadline := time.Now().Add(5 * time.Second); status.State != jobDone && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if code := adminDo(t, h, "GET", "/sessions/user1/s1/compare/"+started.ID, nil, &status); code != http.StatusOK {
+			t.Fatalf("Expected 200, but got %d", code)
+		}
+	}
+	if status.State != jobDone || status.Result == nil {
+		t.Fatalf("Expected the comparison done, but got %+v", status)
+	}
+	session := *status.Result
+	if session.Compared != 2 || session.Unchanged != 0 || session.Failed != 0 || !reflect.DeepEqual(session.Changed, map[string]int{"transcript": 2}) || len(session.Chunks) != 2 {
+		t.Errorf("Expected both chunks' transcripts counted, but got %+v", session)
+	}
+	if session.Chunks[0].ChunkID != first.ChunkID || session.Chunks[1].ChunkID != second.ChunkID {
+		t.Errorf("Expected the chunks in session order, but got %s %s", session.Chunks[0].ChunkID, session.Chunks[1].ChunkID)
+	}
+}
+
+func TestSessionComparerDropsOldResults(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	g := newGoroutines(context.Background())
+	c := NewSessionComparer(NewMemoryStore(), nil, g)
+	c.Clock = clock
+	old := c.Start("user1", "s1")
+	g.Wait()
+	if st, ok := c.Status("user1", "s1", old.ID); !ok || st.State != jobDone || st.Result.Compared != 0 {
+		t.Fatalf("Expected an empty session compared, but got %+v", st)
+	}
+
+	clock.Advance(compareResultTTL + time.Second)
+	kept := c.Start("user1", "s2")
+	g.Wait()
+	if _, ok := c.Status("user1", "s1", old.ID); ok {
+		t.Errorf("Expected the old comparison dropped")
+	}
+	if _, ok := c.Status("user1", "s2", kept.ID); !ok {
+		t.Errorf("Expected the new comparison kept")
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	words := func(s ...string) []string { return s }
+	got := diffWords(words("the", "quick", "brown", "fox", "jumps"), words("a", "quick", "fox", "leaps", "high"))
+	want := []WordEdit{
+		{Op: "replace", Position: 0, Stored: words("the"), New: words("a")},
+		{Op: "delete", Position: 2, Stored: words("brown")},
+		{Op: "replace", Position: 4, Stored: words("jumps"), New: words("leaps", "high")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, but got %+v", want, got)
+	}
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// compressEncodings are the content codings compressResponses can produce,
+// most preferred first.
+var compressEncodings = []string{"gzip"}
+
+// compressibleTypes are the media types worth compressing. Audio is only
+// compressed when the request asks for it with compress=true, and types
+// that are already compressed, such as ZIP exports, never are.
+var compressibleTypes = map[string]bool{
+	"application/json":     true,
+	"application/x-ndjson": true,
+	"text/csv":             true,
+}
+
+// compressResponses gzips responses for clients whose Accept-Encoding
+// allows it. Responses are held back until they reach CompressMinBytes,
+// so small ones go out as they are with their Content-Length; compressed
+// ones lose it. Streaming routes and upgrades are left alone.
+func compressResponses(cfg Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Compress {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, _ := mux.CurrentRoute(r).GetPathTemplate()
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || streamingRoutes[route] || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				level:          cfg.CompressLevel,
+				minBytes:       cfg.CompressMinBytes,
+				audio:          r.URL.Query().Get("compress") == "true",
+				status:         http.StatusOK,
+			}
+			// A panicking handler leaves the response unwritten, so the
+			// server can still drop the connection.
+			next.ServeHTTP(cw, r)
+			cw.close()
+		})
+	}
+}
+
+// negotiateEncoding picks the supported coding the client weights highest
+// in an Accept-Encoding header, or "" for none. A "*" covers codings the
+// header does not name.
+func negotiateEncoding(header string) string {
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		weights[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range compressEncodings {
+		q, ok := weights[enc]
+		if !ok {
+			q = weights["*"]
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// compressWriter buffers a response until it knows whether to compress
+// it: once minBytes have been written, when the handler flushes, or when
+// it finishes.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minBytes int
+	audio    bool
+
+	status  int
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	// As with net/http, a status after the body has started is ignored.
+	if w.decided || len(w.buf) > 0 {
+		return
+	}
+	w.status = status
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minBytes {
+			return len(p), nil
+		}
+		if err := w.decide(w.eligible()); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush commits to compressing an eligible response whatever its size so
+// far, since a handler that flushes is streaming it.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(w.eligible())
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.decide(len(w.buf) > 0 && len(w.buf) >= w.minBytes && w.eligible())
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// compressibleType reports whether the response's media type may be
+// compressed for this request.
+func (w *compressWriter) compressibleType() bool {
+	mt, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if strings.HasPrefix(mt, "audio/") || mt == "application/octet-stream" {
+		return w.audio
+	}
+	return compressibleTypes[mt]
+}
+
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || !w.compressibleType() {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < w.minBytes {
+		return false
+	}
+	return true
+}
+
+// decide sends the header, compressed or not, followed by whatever has
+// been buffered.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if w.compressibleType() {
+		h.Add("Vary", "Accept-Encoding")
+	}
+	if compress {
+		// An invalid level leaves the response uncompressed.
+		w.gz, _ = gzip.NewWriterLevel(w.ResponseWriter, w.level)
+	}
+	if w.gz != nil {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
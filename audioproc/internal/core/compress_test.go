@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// rawGet fetches path with the given Accept-Encoding and returns the body
+// as sent, without the client's transparent decompression.
+func rawGet(t *testing.T, h *Harness, path, acceptEncoding string) (*http.Response, []byte) {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	req, _ := http.NewRequest("GET", h.URL+path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected a gzip body: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Expected a complete gzip body: %v", err)
+	}
+	return out
+}
+
+func TestCompressedListing(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	for i := 0; i < 200; i++ {
+		h.Store.Save(Metadata{ChunkID: fmt.Sprintf("chunk%03d", i), UserID: "user1", SessionID: "s1", Transcript: "Hello World", Timestamp: time.Now()})
+	}
+
+	plain, want := rawGet(t, h, "/sessions/user1", "")
+	if plain.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("Expected no compression without Accept-Encoding, but got %q", plain.Header.Get("Content-Encoding"))
+	}
+	resp, body := rawGet(t, h, "/sessions/user1", "zstd, gzip;q=0.8")
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected a gzipped listing, but got %v", resp.Header)
+	}
+	if resp.ContentLength != -1 && resp.ContentLength != int64(len(body)) {
+		t.Errorf("Expected any Content-Length to be the compressed size %d, but got %d", len(body), resp.ContentLength)
+	}
+	if len(body) >= len(want) {
+		t.Errorf("Expected the listing to shrink from %d bytes, but got %d", len(want), len(body))
+	}
+	var listed []Metadata
+	if err := json.Unmarshal(gunzip(t, body), &listed); err != nil || len(listed) != 200 {
+		t.Errorf("Expected the listing to round-trip, but got %d chunks, %v", len(listed), err)
+	}
+
+	// Small responses are not worth compressing.
+	resp, _ = rawGet(t, h, "/chunks/chunk000", "gzip")
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected a small response to go out as it is, but got %v", resp.Header)
+	}
+	if resp, _ := rawGet(t, h, "/sessions/user1", "gzip;q=0"); resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected gzip;q=0 to refuse compression, but got %v", resp.Header)
+	}
+}
+
+func TestCompressionLeavesAudioAlone(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, time.Second, 8000)
+	meta := uploadTo(t, h, "user1", "s1", wav)
+
+	resp, body := rawGet(t, h, "/chunks/"+meta.ChunkID+"/audio", "gzip")
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, wav) {
+		t.Errorf("Expected the audio untouched, but got %v and %d bytes", resp.Header, len(body))
+	}
+	resp, body = rawGet(t, h, "/chunks/"+meta.ChunkID+"/audio?compress=true", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || !bytes.Equal(gunzip(t, body), wav) {
+		t.Errorf("Expected the audio gzipped on request, but got %v", resp.Header)
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                    "",
+		"gzip":                "gzip",
+		"GZIP":                "gzip",
+		"zstd":                "",
+		"gzip;q=0":            "",
+		"*":                   "gzip",
+		"br, gzip;q=0.5":      "gzip",
+		"identity, deflate":   "",
+		"gzip;q=0, *;q=0.1":   "",
+		"gzip; q=0.2, zstd":   "gzip",
+		"gzip;q=bad, deflate": "",
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("%q: expected %q, but got %q", header, want, got)
+		}
+	}
+}
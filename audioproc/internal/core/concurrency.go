@@ -0,0 +1,141 @@
+package core
+
+import (
+	"expvar"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	// requestsInFlight counts requests holding a slot: "global" and
+	// "streams" for the shared budgets and the path template of each
+	// limited route.
+	requestsInFlight      = expvar.NewMap("requests_inflight")
+	concurrencyRejections = expvar.NewMap("concurrency_rejections")
+)
+
+const (
+	globalBudget = "global"
+	streamBudget = "streams"
+)
+
+// ConcurrencyLimiter caps how many requests are served at once, globally
+// and per route, so memory held by request bodies stays bounded. Streaming
+// routes hold their connection for as long as the client likes, so they
+// draw on a separate budget instead of the global one.
+type ConcurrencyLimiter struct {
+	budgets map[string]*budget
+	wait    time.Duration
+}
+
+// budget is a counting semaphore whose slots can be waited for.
+type budget struct {
+	name  string
+	slots chan struct{}
+
+	mu       sync.Mutex
+	inFlight int
+}
+
+func newBudget(name string, n int) *budget {
+	if n <= 0 {
+		return nil
+	}
+	return &budget{name: name, slots: make(chan struct{}, n)}
+}
+
+func (b *budget) acquire(timer <-chan time.Time) bool {
+	if b == nil {
+		return true
+	}
+	select {
+	case b.slots <- struct{}{}:
+	default:
+		select {
+		case b.slots <- struct{}{}:
+		case <-timer:
+			concurrencyRejections.Add(b.name, 1)
+			return false
+		}
+	}
+	b.mu.Lock()
+	b.inFlight++
+	b.mu.Unlock()
+	requestsInFlight.Add(b.name, 1)
+	return true
+}
+
+func (b *budget) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+	requestsInFlight.Add(b.name, -1)
+	<-b.slots
+}
+
+// NewConcurrencyLimiter applies cfg's global and per-route limits.
+func NewConcurrencyLimiter(cfg Config) *ConcurrencyLimiter {
+	l := &ConcurrencyLimiter{budgets: make(map[string]*budget), wait: cfg.ConcurrencyWait}
+	if b := newBudget(globalBudget, cfg.MaxConcurrentRequests); b != nil {
+		l.budgets[globalBudget] = b
+	}
+	if b := newBudget(streamBudget, cfg.MaxStreams); b != nil {
+		l.budgets[streamBudget] = b
+	}
+	for route, n := range cfg.RouteConcurrency {
+		if b := newBudget(route, n); b != nil {
+			l.budgets[route] = b
+		}
+	}
+	return l
+}
+
+// InFlight reports how many requests hold a slot in each budget.
+func (l *ConcurrencyLimiter) InFlight() map[string]int {
+	counts := make(map[string]int, len(l.budgets))
+	for name, b := range l.budgets {
+		b.mu.Lock()
+		counts[name] = b.inFlight
+		b.mu.Unlock()
+	}
+	return counts
+}
+
+// Middleware takes a slot in the request's route budget and then the
+// global or stream budget, waiting up to the configured time for both
+// before refusing the request with 503.
+func (l *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		shared := l.budgets[globalBudget]
+		if streamingRoutes[route] {
+			shared = l.budgets[streamBudget]
+		}
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+
+		perRoute := l.budgets[route]
+		if !perRoute.acquire(timer.C) {
+			writeOverloaded(w)
+			return
+		}
+		defer perRoute.release()
+		if !shared.acquire(timer.C) {
+			writeOverloaded(w)
+			return
+		}
+		defer shared.release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeOverloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	writeJSONError(w, http.StatusServiceUnavailable, "overloaded", "too many concurrent requests; retry shortly")
+}
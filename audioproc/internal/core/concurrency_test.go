@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// gateTranscriber holds every chunk until release is closed, keeping the
+// uploads that carry them in flight.
+type gateTranscriber struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g *gateTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	g.entered <- struct{}{}
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+	}
+	return Transcription{Text: "ok"}, nil
+}
+
+func postUpload(h *Harness, session string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/upload?user_id=user1&session_id=%s", h.URL, session)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader([]byte("audio")))
+	if err == nil {
+		resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestRouteConcurrencyLimit(t *testing.T) {
+	const n = 3
+	cfg := DefaultConfig()
+	cfg.Workers = n + 1
+	cfg.RouteConcurrency = map[string]int{"/upload": n}
+	cfg.ConcurrencyWait = 50 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+	gate := &gateTranscriber{entered: make(chan struct{}, n+1), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+
+	done := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			resp, err := postUpload(h, fmt.Sprintf("held%d", i))
+			if err != nil {
+				done <- 0
+				return
+			}
+			done <- resp.StatusCode
+		}(i)
+	}
+	for i := 0; i < n; i++ {
+		<-gate.entered
+	}
+	if got := h.Concurrency.InFlight()["/upload"]; got != n {
+		t.Errorf("Expected %d uploads in flight, but have %d", n, got)
+	}
+
+	resp, err := postUpload(h, "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("Expected upload %d to be refused with 503, but got %v", n+1, resp.StatusCode)
+	}
+	// Other routes have slots of their own.
+	if resp, err := http.Get(h.URL + "/sessions/user1"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected reads to be served meanwhile, but got %v, %v", resp, err)
+	}
+
+	close(gate.release)
+	for i := 0; i < n; i++ {
+		if status := <-done; status != http.StatusOK {
+			t.Errorf("Expected the held uploads to finish, but got %v", status)
+		}
+	}
+	if resp, err := postUpload(h, "after"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a freed slot to admit the next upload, but got %v, %v", resp, err)
+	}
+}
+
+func TestStreamsUseTheirOwnBudget(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrentRequests = 1
+	cfg.MaxStreams = 1
+	cfg.ConcurrencyWait = 20 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	stream, err := http.Get(h.URL + "/events")
+	if err != nil || stream.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the event stream to open, but got %v, %v", stream, err)
+	}
+	if resp, err := http.Get(h.URL + "/sessions/user1"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected an open stream to leave the global budget alone, but got %v, %v", resp, err)
+	}
+	if resp, err := http.Get(h.URL + "/events"); err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a second stream to be refused, but got %v, %v", resp, err)
+	}
+
+	resp, err := http.Get(h.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ready struct {
+		InFlight map[string]int `json:"in_flight"`
+	}
+	json.NewDecoder(resp.Body).Decode(&ready)
+	resp.Body.Close()
+	if ready.InFlight["streams"] != 1 || ready.InFlight["global"] != 1 {
+		t.Errorf("Expected /readyz to count the stream and itself, but got %v", ready.InFlight)
+	}
+
+	stream.Body.Close()
+	for deadline := time.Now().Add(5 * time.Second); h.Concurrency.InFlight()["streams"] != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected closing the stream to free its slot")
+		}
+	}
+	again, err := http.Get(h.URL + "/events")
+	if err != nil || again.StatusCode != http.StatusOK {
+		t.Fatalf("Expected a new stream after the first closed, but got %v, %v", again, err)
+	}
+	again.Body.Close()
+}
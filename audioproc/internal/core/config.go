@@ -0,0 +1,882 @@
+package core
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/receipt"
+	"github.com/Kundhavi2798/audio-processor/validate"
+)
+
+// Config holds every server option. Start from DefaultConfig or LoadConfig
+// and override fields before calling New.
+type Config struct {
+	Addr string
+	// PublicURL is this instance's externally reachable base URL. Uploads
+	// return it in X-Chunk-Location so clients can make sticky reads.
+	PublicURL  string
+	AdminToken string
+	Workers    int
+	// OrderedSessions hashes each session to one worker so its chunks are
+	// processed one at a time, in the order they arrived.
+	OrderedSessions bool
+	// VerifyChecksums has workers rehash each chunk instead of trusting the
+	// checksum computed at ingest.
+	VerifyChecksums bool
+	// TrustedProxies are the peers whose X-Forwarded-For and X-Real-IP
+	// headers are believed when attributing uploads to a client address.
+	TrustedProxies []netip.Prefix
+
+	// The adaptive limiter keeps effective analysis concurrency between
+	// MinConcurrency and Workers, backing off when a chunk takes longer than
+	// TargetLatency or the queue grows past QueueDepthThreshold.
+	MinConcurrency      int
+	TargetLatency       time.Duration
+	QueueDepthThreshold int
+	MaxAnalysisBytes    int64
+	// ProcessingDeadline bounds each chunk's pass through the pipeline. A
+	// chunk that runs out of time is saved with the results that finished
+	// and Status "partial"; zero means no deadline.
+	ProcessingDeadline time.Duration
+	// MemoryBudget caps the bytes workers hold, all told, decoding and
+	// transcribing chunks; a worker waits for room before it decodes. While
+	// one is waiting, chunks submitted with MemoryBudgetBacklog already
+	// queued are refused with 503, and a chunk bigger than the whole budget
+	// is refused with 422. Zero disables the budget.
+	MemoryBudget        int64
+	MemoryBudgetBacklog int
+	// DispatchLaneDepth caps the jobs of each priority class queued on each
+	// dispatch lane; chunks submitted to a full class are refused with 503.
+	// Zero leaves lanes unbounded.
+	DispatchLaneDepth int
+
+	// AllowedOrigins lists browser origins permitted to open WebSockets. When
+	// empty only same-host origins are accepted, unless AllowAllOrigins is set
+	// (intended for local development).
+	AllowedOrigins  []string
+	AllowAllOrigins bool
+	// APIKeys maps API keys to user IDs. Keys can also be minted at runtime
+	// through /admin/keys; authentication is off until there are any.
+	// Minted keys are cached for APIKeyCacheTTL, which bounds how long a key
+	// revoked elsewhere keeps working.
+	APIKeys        map[string]string
+	APIKeyCacheTTL time.Duration
+
+	// AllowedUsers, or an IdentityURL to check against, restricts uploads to
+	// provisioned users. IdentityFailOpen admits users while the identity
+	// service is unreachable.
+	AllowedUsers        []string
+	IdentityURL         string
+	IdentityCacheTTL    time.Duration
+	IdentityNegativeTTL time.Duration
+	IdentityFailOpen    bool
+
+	MaxClientMetadataBytes int
+	// FingerprintThreshold is the largest FingerprintDistance reported as similar.
+	FingerprintThreshold float64
+
+	// Audio whose samples stay within AnomalyTolerance of each other is
+	// flagged silent or constant, and a mean beyond DCOffsetThreshold is
+	// flagged as a DC offset; see AnomalyDetector.
+	AnomalyTolerance           float64
+	DCOffsetThreshold          float64
+	SkipAnomalousTranscription bool
+
+	// Processing defaults for users who have not overridden them in their
+	// settings; see UserSettings.
+	Transcribe bool
+	Language   string
+	Normalize  bool
+
+	// ReprocessRate caps bulk reprocessing in chunks per second; 0 is unlimited.
+	ReprocessConcurrency int
+	ReprocessRate        float64
+
+	// TranscriptionRate is what the ASR provider charges per second of
+	// audio transcribed; see BillingMeter. Reprocessing is only billed
+	// with BillReprocessing.
+	TranscriptionRate float64
+	BillReprocessing  bool
+
+	TrashRetention time.Duration
+	SweepInterval  time.Duration
+
+	// WALDir, when set, makes OpenMemoryStore keep a write-ahead log and
+	// snapshot there and rebuild the store from them on startup. WALSync
+	// is when the log is fsynced: WALSyncAlways, WALSyncNever or every
+	// WALSyncInterval. The log is compacted into the snapshot, in the
+	// background, once it holds WALCompactEvery records. Chunk audio is
+	// kept in files under WALDir/blobs that the log refers to.
+	WALDir          string
+	WALSync         string
+	WALSyncInterval time.Duration
+	WALCompactEvery int
+
+	// ReadOnly starts the server refusing writes, for maintenance; it can
+	// be toggled at /admin/read-only. Refusals ask clients to retry after
+	// ReadOnlyRetryAfter. See ReadOnly.
+	ReadOnly           bool
+	ReadOnlyRetryAfter time.Duration
+
+	// Connection limits for the HTTP server. HandlerTimeout bounds each
+	// non-streaming request, and UploadTimeout bounds uploads; WebSockets
+	// and server-sent events are exempt. Zero disables a limit.
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	HandlerTimeout    time.Duration
+	UploadTimeout     time.Duration
+	// H2C accepts cleartext HTTP/2 so internal clients can multiplex
+	// uploads over one connection.
+	H2C bool
+	// Compress gzips JSON, NDJSON and CSV responses of at least
+	// CompressMinBytes for clients that accept it, at CompressLevel.
+	Compress         bool
+	CompressLevel    int
+	CompressMinBytes int
+
+	// MaxConcurrentRequests caps the requests served at once and
+	// RouteConcurrency caps single routes, keyed by path template. WebSockets
+	// and other streaming routes count against MaxStreams instead of the
+	// global cap. A request over a cap waits up to ConcurrencyWait for a
+	// slot before it is refused with 503. Zero disables a cap.
+	MaxConcurrentRequests int
+	RouteConcurrency      map[string]int
+	MaxStreams            int
+	ConcurrencyWait       time.Duration
+
+	// Faults enables /admin/faults for chaos testing. Armed faults expire
+	// after FaultTTL unless the request sets its own.
+	Faults   bool
+	FaultTTL time.Duration
+
+	// DrainGrace is how long WebSocket clients get to finish and disconnect
+	// after a draining notice before they are closed with 1001.
+	DrainGrace time.Duration
+	// WSIdleTimeout closes a WebSocket that sends nothing, not even a pong
+	// to the server's pings, for that long. Zero disables it.
+	WSIdleTimeout time.Duration
+	// A WebSocket in streaming mode is cut into a chunk every
+	// WSStreamChunkDuration of audio, or sooner once WSStreamChunkBytes
+	// have arrived.
+	WSStreamChunkDuration time.Duration
+	WSStreamChunkBytes    int
+	// WSClockSkewThreshold is how far a client's clock, measured from the
+	// client_time in its hello, may be off before WSClockSkewAction, "warn"
+	// or "reject", applies. Zero disables the check but not the correction
+	// of recorded_at.
+	WSClockSkewThreshold time.Duration
+	WSClockSkewAction    string
+
+	// SessionIdleTimeout closes a session after that long without a chunk.
+	// With StrictSessions, chunks for a closed session are rejected instead
+	// of opening a new revision of it.
+	SessionIdleTimeout time.Duration
+	StrictSessions     bool
+	// SessionShards splits session-scoped state (WebSocket connections,
+	// session activity, chunk numbering and acks) into this many shards,
+	// each with its own lock, so that past tens of thousands of concurrent
+	// sessions they do not all contend on one.
+	SessionShards int
+
+	// Debug capture quarantines raw uploads from DebugCaptureUsers, plus a
+	// DebugCaptureRate sample of everyone else, for troubleshooting. Users in
+	// DebugCaptureOptOut are never captured.
+	DebugCaptureDir      string
+	DebugCaptureUsers    []string
+	DebugCaptureOptOut   []string
+	DebugCaptureRate     float64
+	DebugCaptureMaxCount int
+	DebugCaptureMaxBytes int64
+	DebugCaptureTTL      time.Duration
+
+	// ReplayDir holds recordings of WebSocket sessions enabled through
+	// /admin/recordings, for replay with POST /admin/replay.
+	ReplayDir string
+
+	// SwaggerUI serves a Swagger UI page for /openapi.json at /docs.
+	SwaggerUI bool
+
+	// ArchiveAfter moves the audio of chunks older than it into compressed
+	// per-day files under ArchiveDir; zero disables archiving.
+	// ArchiveCacheSize bounds how many extracted chunks are kept in memory.
+	ArchiveDir       string
+	ArchiveAfter     time.Duration
+	ArchiveCacheSize int
+
+	// ReconcileInterval is how often blobs and metadata are checked against
+	// each other; zero disables the sweeper. Blobs younger than
+	// ReconcileGrace may belong to an upload still in flight and are left
+	// alone. ReconcileRate caps deletions and flags per second; 0 is
+	// unlimited.
+	ReconcileInterval time.Duration
+	ReconcileGrace    time.Duration
+	ReconcileRate     float64
+
+	// ReadCacheSize enables an LRU of that many chunks in front of the store.
+	// Reads go straight to the store when it is zero. Chunks the store
+	// does not have are remembered for ReadCacheNegativeTTL.
+	ReadCacheSize        int
+	ReadCacheTTL         time.Duration
+	ReadCacheNegativeTTL time.Duration
+
+	// IDRules constrain the user and session IDs clients send.
+	IDRules validate.Rules
+	// ChunkIDPattern, matched against the whole ID, admits client-supplied
+	// chunk IDs that are not UUIDs. It can only narrow what is safe as a
+	// file name: letters, digits, '.', '_' and '-', not starting with '.'.
+	ChunkIDPattern *regexp.Regexp
+
+	// ChangeLogSize is how many recent changes GET /changes can replay.
+	ChangeLogSize int
+	// WarmupTimeout bounds each critical startup warm-up.
+	WarmupTimeout time.Duration
+	// RevisionDepth is how many versions of a chunk's metadata
+	// GET /chunks/{id}/revisions keeps; 0 keeps none.
+	RevisionDepth int
+	// TranscriptInlineMax is how many bytes a chunk's transcript and word
+	// timings may take on its metadata. Larger ones are stored as a blob
+	// and listings show a preview marked truncated; 0 keeps them inline.
+	TranscriptInlineMax int
+	// ReviewThreshold is the transcript confidence below which a chunk
+	// waits in the review queue until someone reviews it; 0 queues
+	// nothing. StubConfidence is the confidence the stub transcriber
+	// reports, for exercising the queue without an ASR backend.
+	ReviewThreshold float64
+	StubConfidence  float64
+
+	// ShareSecret signs share link tokens; set it so links survive restarts.
+	// Links last ShareTTL unless the request asks for up to ShareMaxTTL.
+	ShareSecret string
+	ShareTTL    time.Duration
+	ShareMaxTTL time.Duration
+	// Signed audio URLs, signed with ShareSecret, last SignedURLTTL unless
+	// the request asks for up to SignedURLMaxTTL.
+	SignedURLTTL    time.Duration
+	SignedURLMaxTTL time.Duration
+	// FFmpegPath, when set, lets audio downloads ask for ?format=mp3 or
+	// opus, encoded by that ffmpeg. Encodings are cached up to
+	// TranscodeCacheBytes. At most TranscodeConcurrency encodes run at once,
+	// each for at most TranscodeTimeout; a download that finds them all busy
+	// for ConcurrencyWait is refused with 503.
+	FFmpegPath           string
+	TranscodeCacheBytes  int64
+	TranscodeConcurrency int
+	TranscodeTimeout     time.Duration
+	// ReceiptKeys sign upload receipts with the first key; the rest are
+	// published so receipts signed before a rotation still verify. Uploads
+	// get no receipts when it is empty. See receipt.ParseKeys.
+	ReceiptKeys []receipt.Key
+
+	// ExportDir holds user data export bundles, whose download links last
+	// ExportTTL.
+	ExportDir string
+	ExportTTL time.Duration
+	// AutoExportSchedule, when set, exports closed sessions on that cron
+	// schedule, evaluated in UTC, as bundles under AutoExportPrefix in
+	// AutoExportDir. Set Server.AutoExports.Objects to write to another
+	// object store instead.
+	AutoExportSchedule *CronSchedule
+	AutoExportDir      string
+	AutoExportPrefix   string
+
+	// Webhook subscriptions that set no retry policy get WebhookMaxAttempts
+	// attempts, backing off from WebhookBackoff. Each attempt is bounded by
+	// WebhookTimeout, and the last WebhookRecentDeliveries outcomes are kept
+	// for inspection.
+	WebhookTimeout          time.Duration
+	WebhookMaxAttempts      int
+	WebhookBackoff          time.Duration
+	WebhookRecentDeliveries int
+
+	// QuotaBytes and QuotaChunks are soft limits on what each user keeps;
+	// zero leaves a resource unlimited. Uploads report usage against them
+	// and a quota_warning event is published as usage reaches each of
+	// QuotaWarnAt, in percent. See SoftQuotas.
+	QuotaBytes  int64
+	QuotaChunks int
+	QuotaWarnAt []int
+
+	// Outbound calls go through one Egress; see NewEgress. EgressProxy, a
+	// URL that may carry user:password, overrides HTTP_PROXY and
+	// HTTPS_PROXY. EgressAllow, when set, lists the only destinations
+	// reachable and EgressDeny those never reachable, matched against
+	// resolved addresses. Webhooks also may not reach private addresses
+	// unless WebhookAllowPrivate is set. The remaining fields tune the
+	// shared connections.
+	EgressProxy            string
+	EgressAllow            []netip.Prefix
+	EgressDeny             []netip.Prefix
+	WebhookAllowPrivate    bool
+	EgressDialTimeout      time.Duration
+	EgressTLSTimeout       time.Duration
+	EgressIdleTimeout      time.Duration
+	EgressIdleConnsPerHost int
+
+	// RedactionRules scrub PII from transcripts before they are stored or
+	// indexed. See ParseRedactionRules.
+	RedactionRules []RedactionRule
+
+	// AlertRules are evaluated every AlertInterval against the service's
+	// counters; firing and resolved alerts are posted to AlertWebhookURL
+	// when it is set. See ParseAlertRules.
+	AlertRules      []AlertRule
+	AlertInterval   time.Duration
+	AlertWebhookURL string
+
+	// ObserveReplayMax caps how many past events a session observer can
+	// ask to have replayed before the live ones.
+	ObserveReplayMax int
+
+	// MQTTBroker, a tcp:// URL, turns on the MQTT bridge for devices that
+	// cannot speak HTTP; see MQTTBridge. MQTTTopic names where chunks are
+	// published and MQTTResponseTopic where their metadata goes, with
+	// {user} and {session} standing for whole topic levels. Reconnects
+	// back off up to MQTTMaxBackoff. MQTTClientID names the broker session
+	// the bridge keeps and must differ between instances; empty derives it
+	// from the host name.
+	MQTTBroker        string
+	MQTTClientID      string
+	MQTTTopic         string
+	MQTTResponseTopic string
+	MQTTMaxBackoff    time.Duration
+
+	// MaxChunkDuration splits longer WAV uploads into child chunks, cutting
+	// at silences where possible. Zero turns splitting off.
+	MaxChunkDuration time.Duration
+
+	// AudioRules refuse uploads by format, sample rate or length, from
+	// AUDIO_FORMATS, AUDIO_MIN_SAMPLE_RATE, AUDIO_MAX_SAMPLE_RATE,
+	// AUDIO_MAX_DURATION, AUDIO_MIN_BYTES and AUDIO_MIN_DURATION.
+	AudioRules AudioRules
+
+	// errs collects the settings LoadConfig could not use; see Err.
+	errs []error
+}
+
+// Err reports the AUDIO_* settings LoadConfig refused. Server.Run will not
+// start with them, since ignoring one could quietly widen access.
+func (cfg Config) Err() error {
+	return errors.Join(cfg.errs...)
+}
+
+// DefaultConfig returns the settings used when no AUDIO_* variable
+// overrides them.
+func DefaultConfig() Config {
+	return Config{
+		Addr:    ":9090",
+		Workers: 4,
+
+		MinConcurrency:      1,
+		TargetLatency:       2 * time.Second,
+		QueueDepthThreshold: 50,
+		MaxAnalysisBytes:    256 << 20,
+		ProcessingDeadline:  30 * time.Second,
+		MemoryBudget:        1 << 30,
+		MemoryBudgetBacklog: 100,
+		DispatchLaneDepth:   1000,
+
+		IdentityCacheTTL:    5 * time.Minute,
+		IdentityNegativeTTL: 30 * time.Second,
+
+		MaxClientMetadataBytes:  4096,
+		FingerprintThreshold:    0.35,
+		AnomalyTolerance:        0.001,
+		DCOffsetThreshold:       0.1,
+		Transcribe:              true,
+		Compress:                true,
+		CompressLevel:           gzip.DefaultCompression,
+		CompressMinBytes:        1024,
+		Normalize:               true,
+		ReprocessConcurrency:    4,
+		TrashRetention:          7 * 24 * time.Hour,
+		SweepInterval:           time.Minute,
+		WALSync:                 WALSyncInterval,
+		WALSyncInterval:         time.Second,
+		WALCompactEvery:         10000,
+		ReadOnlyRetryAfter:      time.Minute,
+		DrainGrace:              5 * time.Second,
+		FaultTTL:                5 * time.Minute,
+		WSIdleTimeout:           time.Minute,
+		WSStreamChunkDuration:   5 * time.Second,
+		WSStreamChunkBytes:      1 << 20,
+		WSClockSkewThreshold:    30 * time.Second,
+		WSClockSkewAction:       clockSkewWarn,
+		ReconcileInterval:       time.Hour,
+		ReconcileGrace:          time.Hour,
+		ReconcileRate:           50,
+		ReadHeaderTimeout:       5 * time.Second,
+		ReadTimeout:             time.Minute,
+		WriteTimeout:            time.Minute,
+		IdleTimeout:             2 * time.Minute,
+		MaxHeaderBytes:          64 << 10,
+		HandlerTimeout:          15 * time.Second,
+		UploadTimeout:           45 * time.Second,
+		MaxConcurrentRequests:   512,
+		RouteConcurrency:        map[string]int{"/upload": 64, "/chunks/{id}/audio": 16},
+		MaxStreams:              1024,
+		ConcurrencyWait:         100 * time.Millisecond,
+		SessionIdleTimeout:      5 * time.Minute,
+		SessionShards:           64,
+		ReadCacheTTL:            5 * time.Second,
+		ReadCacheNegativeTTL:    2 * time.Second,
+		ChangeLogSize:           10000,
+		RevisionDepth:           3,
+		TranscriptInlineMax:     64 << 10,
+		ReviewThreshold:         0.5,
+		StubConfidence:          1,
+		WarmupTimeout:           30 * time.Second,
+		IDRules:                 validate.DefaultRules(),
+		ShareTTL:                24 * time.Hour,
+		APIKeyCacheTTL:          30 * time.Second,
+		ShareMaxTTL:             7 * 24 * time.Hour,
+		SignedURLTTL:            15 * time.Minute,
+		SignedURLMaxTTL:         24 * time.Hour,
+		TranscodeCacheBytes:     64 << 20,
+		TranscodeConcurrency:    4,
+		TranscodeTimeout:        30 * time.Second,
+		WebhookTimeout:          10 * time.Second,
+		WebhookMaxAttempts:      3,
+		WebhookBackoff:          time.Second,
+		WebhookRecentDeliveries: 100,
+		ObserveReplayMax:        100,
+		MaxChunkDuration:        5 * time.Minute,
+
+		EgressDialTimeout:      5 * time.Second,
+		EgressTLSTimeout:       5 * time.Second,
+		EgressIdleTimeout:      90 * time.Second,
+		EgressIdleConnsPerHost: 8,
+
+		QuotaWarnAt: []int{80, 95},
+
+		AlertRules: []AlertRule{
+			{Kind: AlertErrorRate, Threshold: 0.05, For: 5 * time.Minute},
+			{Kind: AlertQueueDepth, Threshold: 100, For: time.Minute},
+			{Kind: AlertDeadLetters, Threshold: 5, For: 15 * time.Minute},
+			{Kind: AlertStoreP99, Threshold: 250, For: time.Minute},
+		},
+		AlertInterval: 15 * time.Second,
+
+		ArchiveDir:       "archive",
+		ArchiveCacheSize: 16,
+
+		ExportDir: "exports",
+		ExportTTL: 24 * time.Hour,
+
+		AutoExportDir:    "auto-exports",
+		AutoExportPrefix: "sessions/",
+
+		MQTTTopic:         "audio/{user}/{session}/chunk",
+		MQTTResponseTopic: "audio/{user}/{session}/metadata",
+		MQTTMaxBackoff:    30 * time.Second,
+
+		DebugCaptureDir:      "debug-captures",
+		DebugCaptureMaxCount: 100,
+		DebugCaptureMaxBytes: 512 << 20,
+		DebugCaptureTTL:      24 * time.Hour,
+		ReplayDir:            "replays",
+	}
+}
+
+// LoadConfig starts from DefaultConfig and applies AUDIO_* environment overrides.
+func LoadConfig() Config {
+	cfg := DefaultConfig()
+	if v := os.Getenv("AUDIO_ADDR"); v != "" {
+		cfg.Addr = v
+	}
+	cfg.AdminToken = os.Getenv("AUDIO_ADMIN_TOKEN")
+	cfg.TrustedProxies = cfg.prefixes("AUDIO_TRUSTED_PROXIES")
+	cfg.ShareSecret = os.Getenv("AUDIO_SHARE_SECRET")
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_ID_MAX_LENGTH")); err == nil && n > 0 {
+		cfg.IDRules.MaxLength = n
+	}
+	if re, err := regexp.Compile(os.Getenv("AUDIO_ID_PATTERN")); err == nil && re.String() != "" {
+		cfg.IDRules.Allowed = regexp.MustCompile(`^(?:` + re.String() + `)$`)
+	}
+	cfg.IDRules.SessionUUID = os.Getenv("AUDIO_SESSION_ID_UUID") == "true"
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_API_KEY_CACHE_TTL")); err == nil && d >= 0 {
+		cfg.APIKeyCacheTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_SHARE_TTL")); err == nil && d > 0 {
+		cfg.ShareTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_SHARE_MAX_TTL")); err == nil && d > 0 {
+		cfg.ShareMaxTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_SIGNED_URL_TTL")); err == nil && d > 0 {
+		cfg.SignedURLTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_SIGNED_URL_MAX_TTL")); err == nil && d > 0 {
+		cfg.SignedURLMaxTTL = d
+	}
+	cfg.FFmpegPath = os.Getenv("AUDIO_FFMPEG_PATH")
+	if n, err := strconv.ParseInt(os.Getenv("AUDIO_TRANSCODE_CACHE_BYTES"), 10, 64); err == nil && n >= 0 {
+		cfg.TranscodeCacheBytes = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_TRANSCODE_CONCURRENCY")); err == nil && n > 0 {
+		cfg.TranscodeConcurrency = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_TRANSCODE_TIMEOUT")); err == nil && d > 0 {
+		cfg.TranscodeTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WEBHOOK_TIMEOUT")); err == nil && d > 0 {
+		cfg.WebhookTimeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_WEBHOOK_MAX_ATTEMPTS")); err == nil && n >= 1 && n <= webhookMaxAttempts {
+		cfg.WebhookMaxAttempts = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WEBHOOK_BACKOFF")); err == nil && d >= 0 && d <= time.Minute {
+		cfg.WebhookBackoff = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_WEBHOOK_RECENT_DELIVERIES")); err == nil && n > 0 {
+		cfg.WebhookRecentDeliveries = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("AUDIO_QUOTA_BYTES"), 10, 64); err == nil && n >= 0 {
+		cfg.QuotaBytes = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_QUOTA_CHUNKS")); err == nil && n >= 0 {
+		cfg.QuotaChunks = n
+	}
+	if t, err := ParseQuotaThresholds(os.Getenv("AUDIO_QUOTA_WARN_AT")); err == nil {
+		cfg.QuotaWarnAt = t
+	}
+	cfg.EgressProxy = os.Getenv("AUDIO_EGRESS_PROXY")
+	cfg.EgressAllow = cfg.prefixes("AUDIO_EGRESS_ALLOW")
+	cfg.EgressDeny = cfg.prefixes("AUDIO_EGRESS_DENY")
+	cfg.WebhookAllowPrivate = os.Getenv("AUDIO_WEBHOOK_ALLOW_PRIVATE") == "true"
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_EGRESS_DIAL_TIMEOUT")); err == nil && d > 0 {
+		cfg.EgressDialTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_EGRESS_TLS_TIMEOUT")); err == nil && d > 0 {
+		cfg.EgressTLSTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_EGRESS_IDLE_TIMEOUT")); err == nil && d > 0 {
+		cfg.EgressIdleTimeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_EGRESS_IDLE_CONNS_PER_HOST")); err == nil && n > 0 {
+		cfg.EgressIdleConnsPerHost = n
+	}
+	if keys, err := receipt.ParseKeys(os.Getenv("AUDIO_RECEIPT_KEYS")); err == nil {
+		cfg.ReceiptKeys = keys
+	}
+	if rules, err := ParseRedactionRules(os.Getenv("AUDIO_REDACT")); err == nil {
+		cfg.RedactionRules = rules
+	}
+	if rules, err := ParseAlertRules(os.Getenv("AUDIO_ALERT_RULES")); err == nil && len(rules) > 0 {
+		cfg.AlertRules = rules
+	}
+	// AUDIO_ALERT_INTERVAL=0 turns alert evaluation off.
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_ALERT_INTERVAL")); err == nil && d >= 0 {
+		cfg.AlertInterval = d
+	}
+	cfg.AlertWebhookURL = os.Getenv("AUDIO_ALERT_WEBHOOK_URL")
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_OBSERVE_REPLAY_MAX")); err == nil && n >= 0 {
+		cfg.ObserveReplayMax = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_MAX_CHUNK_DURATION")); err == nil && d >= 0 {
+		cfg.MaxChunkDuration = d
+	}
+	if v := os.Getenv("AUDIO_FORMATS"); v != "" {
+		cfg.AudioRules.Formats = strings.Split(v, ",")
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MIN_SAMPLE_RATE")); err == nil && n >= 0 {
+		cfg.AudioRules.MinSampleRate = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MAX_SAMPLE_RATE")); err == nil && n >= 0 {
+		cfg.AudioRules.MaxSampleRate = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_MAX_DURATION")); err == nil && d >= 0 {
+		cfg.AudioRules.MaxDuration = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MIN_BYTES")); err == nil && n >= 0 {
+		cfg.AudioRules.MinBytes = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_MIN_DURATION")); err == nil && d >= 0 {
+		cfg.AudioRules.MinDuration = d
+	}
+	cfg.PublicURL = strings.TrimSuffix(os.Getenv("AUDIO_PUBLIC_URL"), "/")
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_READ_CACHE_SIZE")); err == nil {
+		cfg.ReadCacheSize = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_READ_CACHE_TTL")); err == nil {
+		cfg.ReadCacheTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_READ_CACHE_NEGATIVE_TTL")); err == nil && d >= 0 {
+		cfg.ReadCacheNegativeTTL = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_CHANGE_LOG_SIZE")); err == nil && n > 0 {
+		cfg.ChangeLogSize = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WARMUP_TIMEOUT")); err == nil && d > 0 {
+		cfg.WarmupTimeout = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_REVISION_DEPTH")); err == nil && n >= 0 {
+		cfg.RevisionDepth = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_TRANSCRIPT_INLINE_MAX")); err == nil && n >= 0 {
+		cfg.TranscriptInlineMax = n
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_REVIEW_THRESHOLD"), 64); err == nil && f >= 0 && f <= 1 {
+		cfg.ReviewThreshold = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_STUB_CONFIDENCE"), 64); err == nil && f >= 0 && f <= 1 {
+		cfg.StubConfidence = f
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_WORKERS")); err == nil && n > 0 {
+		cfg.Workers = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_PROCESSING_DEADLINE")); err == nil && d >= 0 {
+		cfg.ProcessingDeadline = d
+	}
+	cfg.OrderedSessions = os.Getenv("AUDIO_ORDERED_SESSIONS") == "true"
+	cfg.VerifyChecksums = os.Getenv("AUDIO_VERIFY_CHECKSUMS") == "true"
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_TRASH_RETENTION")); err == nil {
+		cfg.TrashRetention = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_SWEEP_INTERVAL")); err == nil {
+		cfg.SweepInterval = d
+	}
+	cfg.WALDir = os.Getenv("AUDIO_WAL_DIR")
+	switch v := os.Getenv("AUDIO_WAL_SYNC"); v {
+	case WALSyncAlways, WALSyncInterval, WALSyncNever:
+		cfg.WALSync = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WAL_SYNC_INTERVAL")); err == nil && d > 0 {
+		cfg.WALSyncInterval = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_WAL_COMPACT_EVERY")); err == nil && n > 0 {
+		cfg.WALCompactEvery = n
+	}
+	cfg.ReadOnly = os.Getenv("AUDIO_READ_ONLY") == "true"
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_READ_ONLY_RETRY_AFTER")); err == nil && d >= time.Second {
+		cfg.ReadOnlyRetryAfter = d
+	}
+	cfg.Faults = os.Getenv("AUDIO_FAULTS") == "true"
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_FAULT_TTL")); err == nil && d > 0 && d <= faultMaxTTL {
+		cfg.FaultTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_DRAIN_GRACE")); err == nil {
+		cfg.DrainGrace = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WS_IDLE_TIMEOUT")); err == nil && d >= 0 {
+		cfg.WSIdleTimeout = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WS_STREAM_CHUNK_DURATION")); err == nil && d > 0 {
+		cfg.WSStreamChunkDuration = d
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_WS_STREAM_CHUNK_BYTES")); err == nil && n > 0 {
+		cfg.WSStreamChunkBytes = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_WS_CLOCK_SKEW_THRESHOLD")); err == nil && d >= 0 {
+		cfg.WSClockSkewThreshold = d
+	}
+	if v := os.Getenv("AUDIO_WS_CLOCK_SKEW_ACTION"); v == clockSkewWarn || v == clockSkewReject {
+		cfg.WSClockSkewAction = v
+	}
+	for name, d := range map[string]*time.Duration{
+		"AUDIO_READ_HEADER_TIMEOUT": &cfg.ReadHeaderTimeout,
+		"AUDIO_READ_TIMEOUT":        &cfg.ReadTimeout,
+		"AUDIO_WRITE_TIMEOUT":       &cfg.WriteTimeout,
+		"AUDIO_IDLE_TIMEOUT":        &cfg.IdleTimeout,
+		"AUDIO_HANDLER_TIMEOUT":     &cfg.HandlerTimeout,
+		"AUDIO_UPLOAD_TIMEOUT":      &cfg.UploadTimeout,
+		"AUDIO_CONCURRENCY_WAIT":    &cfg.ConcurrencyWait,
+	} {
+		if v, err := time.ParseDuration(os.Getenv(name)); err == nil {
+			*d = v
+		}
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MAX_HEADER_BYTES")); err == nil && n > 0 {
+		cfg.MaxHeaderBytes = n
+	}
+	cfg.H2C = os.Getenv("AUDIO_H2C") == "true"
+	if b, err := strconv.ParseBool(os.Getenv("AUDIO_COMPRESS")); err == nil {
+		cfg.Compress = b
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_COMPRESS_LEVEL")); err == nil && n >= gzip.HuffmanOnly && n <= gzip.BestCompression {
+		cfg.CompressLevel = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_COMPRESS_MIN_BYTES")); err == nil && n >= 0 {
+		cfg.CompressMinBytes = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MAX_CONCURRENT_REQUESTS")); err == nil && n >= 0 {
+		cfg.MaxConcurrentRequests = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MAX_STREAMS")); err == nil && n >= 0 {
+		cfg.MaxStreams = n
+	}
+	// AUDIO_ROUTE_CONCURRENCY replaces the defaults, e.g. "/upload=32".
+	if v := os.Getenv("AUDIO_ROUTE_CONCURRENCY"); v != "" {
+		cfg.RouteConcurrency = make(map[string]int)
+		for _, pair := range strings.Split(v, ",") {
+			route, limit, _ := strings.Cut(pair, "=")
+			if n, err := strconv.Atoi(limit); err == nil {
+				cfg.RouteConcurrency[route] = n
+			}
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_SESSION_IDLE_TIMEOUT")); err == nil && d > 0 {
+		cfg.SessionIdleTimeout = d
+	}
+	cfg.StrictSessions = os.Getenv("AUDIO_STRICT_SESSIONS") == "true"
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_SESSION_SHARDS")); err == nil && n > 0 {
+		cfg.SessionShards = n
+	}
+	cfg.SwaggerUI = os.Getenv("AUDIO_SWAGGER_UI") == "true"
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MIN_CONCURRENCY")); err == nil && n > 0 {
+		cfg.MinConcurrency = n
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_TARGET_LATENCY")); err == nil {
+		cfg.TargetLatency = d
+	}
+	if n, err := strconv.ParseInt(os.Getenv("AUDIO_MAX_ANALYSIS_BYTES"), 10, 64); err == nil && n > 0 {
+		cfg.MaxAnalysisBytes = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("AUDIO_MEMORY_BUDGET"), 10, 64); err == nil && n >= 0 {
+		cfg.MemoryBudget = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_MEMORY_BUDGET_BACKLOG")); err == nil && n >= 0 {
+		cfg.MemoryBudgetBacklog = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("AUDIO_DISPATCH_LANE_DEPTH")); err == nil && n >= 0 {
+		cfg.DispatchLaneDepth = n
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_ANOMALY_TOLERANCE"), 64); err == nil {
+		cfg.AnomalyTolerance = f
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_DC_OFFSET_THRESHOLD"), 64); err == nil {
+		cfg.DCOffsetThreshold = f
+	}
+	cfg.SkipAnomalousTranscription = os.Getenv("AUDIO_SKIP_ANOMALOUS_TRANSCRIPTION") == "true"
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_TRANSCRIPTION_RATE"), 64); err == nil && f >= 0 {
+		cfg.TranscriptionRate = f
+	}
+	cfg.BillReprocessing = os.Getenv("AUDIO_BILL_REPROCESSING") == "true"
+	if b, err := strconv.ParseBool(os.Getenv("AUDIO_TRANSCRIBE")); err == nil {
+		cfg.Transcribe = b
+	}
+	cfg.Language = os.Getenv("AUDIO_LANGUAGE")
+	if b, err := strconv.ParseBool(os.Getenv("AUDIO_NORMALIZE")); err == nil {
+		cfg.Normalize = b
+	}
+	if v := os.Getenv("AUDIO_ALLOWED_USERS"); v != "" {
+		cfg.AllowedUsers = strings.Split(v, ",")
+	}
+	cfg.IdentityURL = os.Getenv("AUDIO_IDENTITY_URL")
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_IDENTITY_CACHE_TTL")); err == nil {
+		cfg.IdentityCacheTTL = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_IDENTITY_NEGATIVE_TTL")); err == nil {
+		cfg.IdentityNegativeTTL = d
+	}
+	cfg.IdentityFailOpen = os.Getenv("AUDIO_IDENTITY_FAIL_OPEN") == "true"
+	if v := os.Getenv("AUDIO_ARCHIVE_DIR"); v != "" {
+		cfg.ArchiveDir = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_ARCHIVE_AFTER")); err == nil {
+		cfg.ArchiveAfter = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_RECONCILE_INTERVAL")); err == nil {
+		cfg.ReconcileInterval = d
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_RECONCILE_GRACE")); err == nil && d >= 0 {
+		cfg.ReconcileGrace = d
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_RECONCILE_RATE"), 64); err == nil && f >= 0 {
+		cfg.ReconcileRate = f
+	}
+	if v := os.Getenv("AUDIO_EXPORT_DIR"); v != "" {
+		cfg.ExportDir = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_EXPORT_TTL")); err == nil && d > 0 {
+		cfg.ExportTTL = d
+	}
+	if sched, err := ParseCronSchedule(os.Getenv("AUDIO_AUTO_EXPORT_SCHEDULE")); err == nil {
+		cfg.AutoExportSchedule = sched
+	}
+	if v := os.Getenv("AUDIO_AUTO_EXPORT_DIR"); v != "" {
+		cfg.AutoExportDir = v
+	}
+	if v, ok := os.LookupEnv("AUDIO_AUTO_EXPORT_PREFIX"); ok {
+		cfg.AutoExportPrefix = v
+	}
+	if v := os.Getenv("AUDIO_CHUNK_ID_PATTERN"); v != "" {
+		if re, err := regexp.Compile(`^(?:` + v + `)$`); err == nil {
+			cfg.ChunkIDPattern = re
+		}
+	}
+	cfg.MQTTBroker = os.Getenv("AUDIO_MQTT_BROKER")
+	if v := os.Getenv("AUDIO_MQTT_CLIENT_ID"); v != "" {
+		cfg.MQTTClientID = v
+	}
+	if v := os.Getenv("AUDIO_MQTT_TOPIC"); v != "" {
+		cfg.MQTTTopic = v
+	}
+	if v := os.Getenv("AUDIO_MQTT_RESPONSE_TOPIC"); v != "" {
+		cfg.MQTTResponseTopic = v
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_MQTT_MAX_BACKOFF")); err == nil && d > 0 {
+		cfg.MQTTMaxBackoff = d
+	}
+	if v := os.Getenv("AUDIO_DEBUG_CAPTURE_DIR"); v != "" {
+		cfg.DebugCaptureDir = v
+	}
+	if v := os.Getenv("AUDIO_REPLAY_DIR"); v != "" {
+		cfg.ReplayDir = v
+	}
+	if v := os.Getenv("AUDIO_DEBUG_CAPTURE_USERS"); v != "" {
+		cfg.DebugCaptureUsers = strings.Split(v, ",")
+	}
+	if v := os.Getenv("AUDIO_DEBUG_CAPTURE_OPT_OUT"); v != "" {
+		cfg.DebugCaptureOptOut = strings.Split(v, ",")
+	}
+	if f, err := strconv.ParseFloat(os.Getenv("AUDIO_DEBUG_CAPTURE_RATE"), 64); err == nil {
+		cfg.DebugCaptureRate = f
+	}
+	if d, err := time.ParseDuration(os.Getenv("AUDIO_DEBUG_CAPTURE_TTL")); err == nil {
+		cfg.DebugCaptureTTL = d
+	}
+	if v := os.Getenv("AUDIO_ALLOWED_ORIGINS"); v != "" {
+		cfg.AllowedOrigins = strings.Split(v, ",")
+	}
+	cfg.AllowAllOrigins = os.Getenv("AUDIO_ALLOW_ALL_ORIGINS") == "true"
+	if v := os.Getenv("AUDIO_API_KEYS"); v != "" {
+		cfg.APIKeys = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			if key, user, ok := strings.Cut(pair, ":"); ok {
+				cfg.APIKeys[key] = user
+			}
+		}
+	}
+	return cfg
+}
+
+// prefixes reads the address list in the environment variable name,
+// recording an error if any entry does not parse.
+func (cfg *Config) prefixes(name string) []netip.Prefix {
+	prefixes, err := parsePrefixes(os.Getenv(name))
+	if err != nil {
+		cfg.errs = append(cfg.errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return prefixes
+}
+
+func isAdmin(cfg Config, r *http.Request) bool {
+	return hasAdminToken(cfg, r.Header)
+}
+
+func hasAdminToken(cfg Config, header http.Header) bool {
+	token := header.Get("X-Admin-Token")
+	return cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
@@ -0,0 +1,277 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	audioprocessorv1 "github.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1"
+	"github.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1/audioprocessorv1connect"
+)
+
+// AudioService, defined in proto/audioprocessor/v1/audio.proto, serves
+// UploadChunk, GetChunk and ListByUser over the Connect protocol
+// (https://connectrpc.com/docs/protocol) so browsers can call them as plain
+// HTTP POSTs, and over gRPC and gRPC-Web. Messages may be binary protobuf
+// or JSON; JSON uses proto field names, matching the REST JSON. Regenerate
+// the code in gen with buf generate after editing the schema.
+const connectService = "/" + audioprocessorv1connect.AudioServiceName + "/"
+
+const connectMaxBodyBytes = 4 << 20
+
+// protoNamesCodec is Connect's JSON codec with proto field names rather
+// than lowerCamelCase ones.
+type protoNamesCodec struct{ name string }
+
+func (c protoNamesCodec) Name() string { return c.name }
+
+func (protoNamesCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return protojson.MarshalOptions{UseProtoNames: true}.Marshal(m)
+}
+
+func (protoNamesCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, m)
+}
+
+// connectCodes translates the error kinds in errors.go to Connect codes.
+var connectCodes = []struct {
+	err  error
+	code connect.Code
+}{
+	{ErrNotFound, connect.CodeNotFound},
+	{ErrConflict, connect.CodeFailedPrecondition},
+	{ErrGone, connect.CodeNotFound},
+	{ErrForbidden, connect.CodePermissionDenied},
+	{ErrQuotaExceeded, connect.CodeResourceExhausted},
+	{ErrBackendUnavailable, connect.CodeUnavailable},
+	{ErrReadOnly, connect.CodeUnavailable},
+}
+
+func toConnectError(err error) *connect.Error {
+	var cerr *connect.Error
+	if errors.As(err, &cerr) {
+		return cerr
+	}
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+	for _, c := range connectCodes {
+		if errors.Is(err, c.err) {
+			return connect.NewError(c.code, err)
+		}
+	}
+	return connect.NewError(connect.CodeInternal, errors.New(errorMessage(err)))
+}
+
+// connectErrors gives the errors handlers return their Connect codes.
+func connectErrors(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		res, err := next(ctx, req)
+		if err != nil {
+			return nil, toConnectError(err)
+		}
+		return res, nil
+	}
+}
+
+// connectCORS answers browser preflights and adds CORS headers for origins
+// allowed by the WebSocket origin policy.
+func connectCORS(cfg Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			allowed := origin != "" && originAllowed(cfg, r)
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Add("Vary", "Origin")
+				w.Header().Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message, Grpc-Status-Details-Bin")
+			}
+			if r.Method == http.MethodOptions {
+				if allowed {
+					w.Header().Set("Access-Control-Allow-Methods", "POST")
+					w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Connect-Protocol-Version, Connect-Timeout-Ms, Grpc-Timeout, X-Grpc-Web, X-User-Agent, Authorization")
+					w.Header().Set("Access-Control-Max-Age", "7200")
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// audioService implements AudioService with the same checks as the REST
+// routes it mirrors.
+type audioService struct {
+	cfg      Config
+	store    *MemoryStore
+	jobs     chan Job
+	sessions *SessionTracker
+	identity IdentityProvider
+	ro       *ReadOnly
+}
+
+func (s *audioService) UploadChunk(ctx context.Context, req *connect.Request[audioprocessorv1.UploadChunkRequest]) (*connect.Response[audioprocessorv1.Chunk], error) {
+	if s.ro.Enabled() {
+		readOnlyRefusals.Add("connect", 1)
+		return nil, s.ro.err()
+	}
+	msg := req.Msg
+	if err := validateIDs(s.cfg, msg.UserId, msg.SessionId); err != nil {
+		return nil, err
+	}
+	if err := checkCallerAccess(ctx, s.cfg, req.Header(), msg.UserId); err != nil {
+		return nil, err
+	}
+	if err := validateUser(ctx, s.identity, msg.UserId); err != nil {
+		return nil, err
+	}
+	var raw []byte
+	if msg.ClientMetadata != nil {
+		raw, _ = json.Marshal(msg.ClientMetadata.AsMap())
+	}
+	clientMeta, err := validateClientMetadata(raw, s.cfg.MaxClientMetadataBytes)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ingest(ctx, s.store, s.jobs, s.sessions, s.cfg.AudioRules, AudioChunk{
+		ChunkID:        uuid.New().String(),
+		UserID:         msg.UserId,
+		SessionID:      msg.SessionId,
+		Timestamp:      time.Now(),
+		Data:           msg.Data,
+		ClientMetadata: clientMeta,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(chunkProto(meta)), nil
+}
+
+func (s *audioService) GetChunk(ctx context.Context, req *connect.Request[audioprocessorv1.GetChunkRequest]) (*connect.Response[audioprocessorv1.Chunk], error) {
+	meta, err := s.store.Get(req.Msg.ChunkId)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkCallerAccess(ctx, s.cfg, req.Header(), meta.UserID); err != nil {
+		return nil, err
+	}
+	return connect.NewResponse(chunkProto(meta)), nil
+}
+
+func (s *audioService) ListByUser(ctx context.Context, req *connect.Request[audioprocessorv1.ListByUserRequest]) (*connect.Response[audioprocessorv1.ListByUserResponse], error) {
+	if err := checkCallerAccess(ctx, s.cfg, req.Header(), req.Msg.UserId); err != nil {
+		return nil, err
+	}
+	res := &audioprocessorv1.ListByUserResponse{}
+	for _, meta := range s.store.ListByUser(req.Msg.UserId) {
+		res.Chunks = append(res.Chunks, chunkProto(meta))
+	}
+	return connect.NewResponse(res), nil
+}
+
+// chunkProto converts meta to its message in audio.proto.
+func chunkProto(meta Metadata) *audioprocessorv1.Chunk {
+	c := &audioprocessorv1.Chunk{
+		SchemaVersion:        int32(meta.SchemaVersion),
+		ChunkId:              meta.ChunkID,
+		UserId:               meta.UserID,
+		SessionId:            meta.SessionID,
+		SessionRevision:      int32(meta.SessionRevision),
+		Index:                meta.Index,
+		Timestamp:            timestamppb.New(meta.Timestamp),
+		RecordedAt:           timestampProto(meta.RecordedAt),
+		ParentChunkId:        meta.ParentChunkID,
+		OffsetMs:             meta.OffsetMS,
+		DerivedFrom:          meta.DerivedFrom,
+		SourceIp:             meta.SourceIP,
+		ReplayOf:             meta.ReplayOf,
+		DurationMs:           meta.DurationMS,
+		LoudnessDbfs:         meta.LoudnessDBFS,
+		Checksum:             meta.Checksum,
+		Fft:                  meta.FFT,
+		Transcript:           meta.Transcript,
+		Truncated:            meta.Truncated,
+		TranscriptBlob:       meta.TranscriptBlob,
+		Confidence:           meta.Confidence,
+		ReviewedAt:           timestampProto(meta.ReviewedAt),
+		Anomalies:            meta.Anomalies,
+		TranscriptSkipReason: meta.TranscriptSkipReason,
+		Skipped:              meta.Skipped,
+		TimedOut:             meta.TimedOut,
+		Fingerprint:          meta.Fingerprint,
+		Status:               meta.Status,
+		DeletedAt:            timestampProto(meta.DeletedAt),
+		IntegrityStatus:      meta.IntegrityStatus,
+	}
+	for _, w := range meta.Words {
+		c.Words = append(c.Words, &audioprocessorv1.Word{Text: w.Text, StartMs: int32(w.StartMS), EndMs: int32(w.EndMS), Confidence: w.Confidence})
+	}
+	if len(meta.Redactions) > 0 {
+		c.Redactions = make(map[string]int32, len(meta.Redactions))
+		for category, n := range meta.Redactions {
+			c.Redactions[category] = int32(n)
+		}
+	}
+	for _, m := range meta.Markers {
+		c.Markers = append(c.Markers, &audioprocessorv1.Marker{OffsetMs: m.OffsetMS, Label: m.Label, Note: m.Note})
+	}
+	if t := meta.EmbeddedTags; t != nil {
+		c.EmbeddedTags = &audioprocessorv1.EmbeddedTags{Title: t.Title, Artist: t.Artist, Album: t.Album, Date: t.Date}
+	}
+	if a := meta.Archive; a != nil {
+		c.Archive = &audioprocessorv1.ArchiveLocation{File: a.File, Offset: a.Offset, Size: a.Size}
+	}
+	if len(meta.ClientMetadata) > 0 {
+		c.ClientMetadata = &structpb.Struct{}
+		if err := protojson.Unmarshal(meta.ClientMetadata, c.ClientMetadata); err != nil {
+			c.ClientMetadata = nil
+		}
+	}
+	return c
+}
+
+func timestampProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func registerConnect(r *mux.Router, cfg Config, store *MemoryStore, jobs chan Job, sessions *SessionTracker, identity IdentityProvider, ro *ReadOnly) {
+	_, handler := audioprocessorv1connect.NewAudioServiceHandler(
+		&audioService{cfg: cfg, store: store, jobs: jobs, sessions: sessions, identity: identity, ro: ro},
+		connect.WithCodec(protoNamesCodec{"json"}),
+		connect.WithCodec(protoNamesCodec{"json; charset=utf-8"}),
+		connect.WithReadMaxBytes(connectMaxBodyBytes),
+		connect.WithInterceptors(connect.UnaryInterceptorFunc(connectErrors)),
+	)
+	handler = connectCORS(cfg)(handler)
+	for _, procedure := range []string{
+		audioprocessorv1connect.AudioServiceUploadChunkProcedure,
+		audioprocessorv1connect.AudioServiceGetChunkProcedure,
+		audioprocessorv1connect.AudioServiceListByUserProcedure,
+	} {
+		r.Handle(procedure, handler).Methods("POST", "OPTIONS")
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	audioprocessorv1 "github.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1"
+	"github.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1/audioprocessorv1connect"
+)
+
+// withKey sends key as the caller's API key.
+func withKey[T any](key string, msg *T) *connect.Request[T] {
+	req := connect.NewRequest(msg)
+	if key != "" {
+		req.Header().Set("Authorization", "Bearer "+key)
+	}
+	return req
+}
+
+func TestConnectService(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIKeys = map[string]string{"key1": "user1", "key2": "user2"}
+	cfg.AllowedOrigins = []string{"https://app.example.com"}
+	h := NewHarness(cfg)
+	defer h.Close()
+	ctx := context.Background()
+
+	for _, codec := range []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{"proto", nil},
+		{"json", []connect.ClientOption{connect.WithProtoJSON()}},
+	} {
+		t.Run(codec.name, func(t *testing.T) {
+			client := audioprocessorv1connect.NewAudioServiceClient(http.DefaultClient, h.URL, codec.opts...)
+			sessionID := "sess-" + codec.name
+			clientMeta, _ := structpb.NewStruct(map[string]any{"device": "browser"})
+
+			uploaded, err := client.UploadChunk(ctx, withKey("key1", &audioprocessorv1.UploadChunkRequest{
+				UserId:         "user1",
+				SessionId:      sessionID,
+				Data:           SineWAV(440, 50*time.Millisecond, 8000),
+				ClientMetadata: clientMeta,
+			}))
+			if err != nil || uploaded.Msg.ChunkId == "" || uploaded.Msg.Checksum == "" {
+				t.Fatalf("Expected UploadChunk to succeed, but got %v %+v", err, uploaded)
+			}
+			if got := uploaded.Msg.ClientMetadata.GetFields()["device"].GetStringValue(); got != "browser" {
+				t.Errorf("Expected the client metadata back, but got %v", uploaded.Msg.ClientMetadata)
+			}
+
+			got, err := client.GetChunk(ctx, withKey("key1", &audioprocessorv1.GetChunkRequest{ChunkId: uploaded.Msg.ChunkId}))
+			if err != nil {
+				t.Fatalf("Expected GetChunk to succeed, but got %v", err)
+			}
+			if got.Msg.Checksum != uploaded.Msg.Checksum || !got.Msg.Timestamp.AsTime().Equal(uploaded.Msg.Timestamp.AsTime()) {
+				t.Errorf("Expected the uploaded chunk %+v, but got %+v", uploaded.Msg, got.Msg)
+			}
+
+			_, err = client.GetChunk(ctx, withKey("key1", &audioprocessorv1.GetChunkRequest{ChunkId: "missing"}))
+			if connect.CodeOf(err) != connect.CodeNotFound {
+				t.Errorf("Expected not_found, but got %v", err)
+			}
+
+			listed, err := client.ListByUser(ctx, withKey("key1", &audioprocessorv1.ListByUserRequest{UserId: "user1"}))
+			if err != nil {
+				t.Fatalf("Expected ListByUser to succeed, but got %v", err)
+			}
+			found := false
+			for _, c := range listed.Msg.Chunks {
+				found = found || c.ChunkId == uploaded.Msg.ChunkId
+			}
+			if !found {
+				t.Errorf("Expected %s among user1's chunks, but got %v", uploaded.Msg.ChunkId, listed.Msg.Chunks)
+			}
+
+			if _, err := client.ListByUser(ctx, withKey("", &audioprocessorv1.ListByUserRequest{UserId: "user1"})); connect.CodeOf(err) != connect.CodeUnauthenticated {
+				t.Errorf("Expected unauthenticated without credentials, but got %v", err)
+			}
+
+			// Another user's key reaches none of user1's chunks.
+			before := len(h.Store.ListByUser("user1"))
+			calls := map[string]func() error{
+				"UploadChunk": func() error {
+					_, err := client.UploadChunk(ctx, withKey("key2", &audioprocessorv1.UploadChunkRequest{UserId: "user1", SessionId: sessionID, Data: SineWAV(440, 50*time.Millisecond, 8000)}))
+					return err
+				},
+				"GetChunk": func() error {
+					_, err := client.GetChunk(ctx, withKey("key2", &audioprocessorv1.GetChunkRequest{ChunkId: uploaded.Msg.ChunkId}))
+					return err
+				},
+				"ListByUser": func() error {
+					_, err := client.ListByUser(ctx, withKey("key2", &audioprocessorv1.ListByUserRequest{UserId: "user1"}))
+					return err
+				},
+			}
+			for method, call := range calls {
+				if err := call(); connect.CodeOf(err) != connect.CodePermissionDenied {
+					t.Errorf("%s: expected permission_denied for another user's key, but got %v", method, err)
+				}
+			}
+			if after := len(h.Store.ListByUser("user1")); after != before {
+				t.Errorf("Expected nothing uploaded for user1 with user2's key, but got %d chunks after %d", after, before)
+			}
+		})
+	}
+
+	// JSON bodies use proto field names, as the REST routes do.
+	body, _ := json.Marshal(map[string]string{"user_id": "user1"})
+	req, _ := http.NewRequest("POST", h.URL+audioprocessorv1connect.AudioServiceListByUserProcedure, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer key1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed struct {
+		Chunks []struct {
+			ChunkID string `json:"chunk_id"`
+		} `json:"chunks"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(listed.Chunks) != 2 || listed.Chunks[0].ChunkID == "" {
+		t.Errorf("Expected user1's two chunks by proto field names, but got %v %+v", resp.StatusCode, listed)
+	}
+
+	// Other codecs are refused, naming the ones served.
+	xml, _ := http.NewRequest("POST", h.URL+audioprocessorv1connect.AudioServiceGetChunkProcedure, bytes.NewReader([]byte("<chunk/>")))
+	xml.Header.Set("Content-Type", "application/xml")
+	xml.Header.Set("Authorization", "Bearer key1")
+	resp, err = http.DefaultClient.Do(xml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if accept := resp.Header.Get("Accept-Post"); resp.StatusCode != http.StatusUnsupportedMediaType || !bytes.Contains([]byte(accept), []byte("application/proto")) || !bytes.Contains([]byte(accept), []byte("application/json")) {
+		t.Errorf("Expected 415 naming the proto and JSON codecs, but got %v %v", resp.StatusCode, resp.Header)
+	}
+
+	preflight, _ := http.NewRequest("OPTIONS", h.URL+audioprocessorv1connect.AudioServiceGetChunkProcedure, nil)
+	preflight.Header.Set("Origin", "https://app.example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "POST")
+	resp, err = http.DefaultClient.Do(preflight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("Expected an allowed preflight, but got %v %v", resp.StatusCode, resp.Header)
+	}
+}
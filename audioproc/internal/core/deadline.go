@@ -0,0 +1,185 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Callers bound how long they will wait with X-Request-Deadline, a
+// duration such as "2s" or a number of milliseconds, or with gRPC's
+// grpc-timeout, an integer followed by one of H, M, S, m, u or n.
+const (
+	requestDeadlineHeader = "X-Request-Deadline"
+	grpcTimeoutHeader     = "Grpc-Timeout"
+)
+
+var (
+	deadlineStats = expvar.NewMap("request_deadlines")
+
+	grpcTimeoutPattern = regexp.MustCompile(`^([0-9]{1,8})([HMSmun])$`)
+	grpcTimeoutUnits   = map[string]time.Duration{
+		"H": time.Hour, "M": time.Minute, "S": time.Second,
+		"m": time.Millisecond, "u": time.Microsecond, "n": time.Nanosecond,
+	}
+)
+
+// parseRequestDeadline reads the caller's deadline from h; ok is false
+// when it set none.
+func parseRequestDeadline(h http.Header) (time.Duration, bool, error) {
+	if v := h.Get(requestDeadlineHeader); v != "" {
+		d, err := time.ParseDuration(v)
+		if ms, merr := strconv.ParseInt(v, 10, 64); merr == nil {
+			d, err = time.Duration(ms)*time.Millisecond, nil
+		}
+		if err != nil || d <= 0 {
+			return 0, false, invalidParam(requestDeadlineHeader, "invalid_deadline", "must be a positive duration such as 2s, or milliseconds")
+		}
+		return d, true, nil
+	}
+	if v := h.Get(grpcTimeoutHeader); v != "" {
+		m := grpcTimeoutPattern.FindStringSubmatch(v)
+		if m == nil {
+			return 0, false, invalidParam(grpcTimeoutHeader, "invalid_deadline", "must be up to 8 digits followed by H, M, S, m, u or n")
+		}
+		n, _ := strconv.ParseInt(m[1], 10, 64)
+		if n == 0 {
+			return 0, false, invalidParam(grpcTimeoutHeader, "invalid_deadline", "must be positive")
+		}
+		return time.Duration(n) * grpcTimeoutUnits[m[2]], true, nil
+	}
+	return 0, false, nil
+}
+
+// requestDeadlines gives a request the deadline its caller asked for. The
+// route's own timeout still applies, so whichever is sooner wins.
+// Streaming routes ignore the headers; WebSocket clients set deadline_ms
+// per chunk instead.
+func requestDeadlines(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, _ := mux.CurrentRoute(r).GetPathTemplate(); streamingRoutes[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d, ok, err := parseRequestDeadline(r.Header)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		deadlineStats.Add("set", 1)
+		ctx, cancel := withClientDeadline(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withClientDeadline bounds ctx by a deadline d from now that the caller
+// asked for, so that running past it is reported as the caller's deadline
+// rather than the server giving up.
+func withClientDeadline(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	start := time.Now()
+	ctx = context.WithValue(ctx, clientDeadlineKey, deadlineSpan{start: start, deadline: start.Add(d)})
+	return context.WithDeadline(ctx, start.Add(d))
+}
+
+// deadlineSpan is a caller's deadline and when it was set.
+type deadlineSpan struct {
+	start, deadline time.Time
+}
+
+// clientDeadline returns the deadline the caller set on ctx, if any, and
+// when it was set.
+func clientDeadline(ctx context.Context) (deadline, start time.Time, ok bool) {
+	span, ok := ctx.Value(clientDeadlineKey).(deadlineSpan)
+	return span.deadline, span.start, ok
+}
+
+// Job stages reported by jobProgress.
+const (
+	progressQueued        = "queued"
+	progressAnalysis      = "analysis"
+	progressTranscription = "transcription"
+	progressDone          = "done"
+)
+
+// jobProgress tracks how far a chunk with a caller's deadline got, for
+// the error returned when the deadline passes first. A nil jobProgress
+// tracks nothing.
+type jobProgress struct {
+	mu    sync.Mutex
+	start time.Time
+	stage string
+	done  []string
+}
+
+// newJobProgress times the job from start, when its deadline was set, so
+// that the time reported is measured against the same clock as the
+// deadline.
+func newJobProgress(start time.Time) *jobProgress {
+	return &jobProgress{start: start, stage: progressQueued}
+}
+
+// enter records that the job moved on to stage.
+func (p *jobProgress) enter(stage string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = append(p.done, p.stage)
+	p.stage = stage
+}
+
+// DeadlineProgress is how far a chunk got before its caller's deadline
+// passed: the stage it was in, the stages it finished and how long it
+// had been since the deadline was set.
+type DeadlineProgress struct {
+	Stage     string   `json:"stage"`
+	Completed []string `json:"completed"`
+	ElapsedMS int64    `json:"elapsed_ms"`
+}
+
+func (p *jobProgress) snapshot() DeadlineProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return DeadlineProgress{
+		Stage:     p.stage,
+		Completed: append([]string{}, p.done...),
+		ElapsedMS: time.Since(p.start).Milliseconds(),
+	}
+}
+
+// deadlineError is returned for a chunk whose caller's deadline passed
+// before it was processed. It matches ErrDeadlineExceeded.
+type deadlineError struct {
+	progress DeadlineProgress
+}
+
+func (e *deadlineError) Error() string {
+	return fmt.Sprintf("request deadline passed after %dms, during %s", e.progress.ElapsedMS, e.progress.Stage)
+}
+
+func (e *deadlineError) Is(target error) bool { return target == ErrDeadlineExceeded }
+
+// jobAborted explains why a job was given up on: the caller's deadline,
+// with how far the job got, or the server stopping or its own timeout.
+// The deadline is checked against the clock rather than ctx.Err, since
+// the pipeline can notice it before ctx's own timer fires.
+func jobAborted(ctx context.Context, progress *jobProgress) error {
+	if deadline, _, ok := clientDeadline(ctx); ok && progress != nil && !time.Now().Before(deadline) {
+		deadlineStats.Add("exceeded", 1)
+		return &deadlineError{progress: progress.snapshot()}
+	}
+	return fmt.Errorf("processing aborted: %w: %w", ErrBackendUnavailable, ctx.Err())
+}
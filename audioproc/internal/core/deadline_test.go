@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestParseRequestDeadline(t *testing.T) {
+	for _, tc := range []struct {
+		header, value string
+		want          time.Duration
+		invalid       bool
+	}{
+		{"X-Request-Deadline", "2s", 2 * time.Second, false},
+		{"X-Request-Deadline", "1500", 1500 * time.Millisecond, false},
+		{"X-Request-Deadline", "0", 0, true},
+		{"X-Request-Deadline", "-1s", 0, true},
+		{"X-Request-Deadline", "soon", 0, true},
+		{"Grpc-Timeout", "250m", 250 * time.Millisecond, false},
+		{"Grpc-Timeout", "3S", 3 * time.Second, false},
+		{"Grpc-Timeout", "1H", time.Hour, false},
+		{"Grpc-Timeout", "123456789S", 0, true},
+		{"Grpc-Timeout", "5s", 0, true},
+	} {
+		h := http.Header{}
+		h.Set(tc.header, tc.value)
+		d, ok, err := parseRequestDeadline(h)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s: %s: expected an error, but got %v", tc.header, tc.value, d)
+			}
+			continue
+		}
+		if err != nil || !ok || d != tc.want {
+			t.Errorf("%s: %s: expected %v, but got %v %v %v", tc.header, tc.value, tc.want, d, ok, err)
+		}
+	}
+	if _, ok, err := parseRequestDeadline(http.Header{}); ok || err != nil {
+		t.Errorf("Expected no deadline without the headers")
+	}
+}
+
+// postWithDeadline uploads wav with header set to value and decodes the
+// reply into out.
+func postWithDeadline(t *testing.T, h *Harness, session, header, value string, wav []byte, out any) int {
+	t.Helper()
+	req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=user1&session_id="+session, bytes.NewReader(wav))
+	req.Header.Set(header, value)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(out)
+	return resp.StatusCode
+}
+
+type deadlineReply struct {
+	Error    string           `json:"error"`
+	Progress DeadlineProgress `json:"progress"`
+}
+
+func TestUploadDeadlineDuringTranscription(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	h.Pipeline.Transcriber = sleepyTranscriber{d: time.Second}
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+
+	var missed deadlineReply
+	start := time.Now()
+	if status := postWithDeadline(t, h, "slow", "X-Request-Deadline", "200ms", wav, &missed); status != http.StatusGatewayTimeout {
+		t.Fatalf("Expected 504, but got %d %+v", status, missed)
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("Expected the reply at the deadline, but it took %v", elapsed)
+	}
+	p := missed.Progress
+	if missed.Error != "deadline_exceeded" || p.Stage != progressTranscription || !slices.Equal(p.Completed, []string{progressQueued, progressAnalysis}) || p.ElapsedMS < 200 {
+		t.Errorf("Expected the progress to stop in transcription, but got %+v", missed)
+	}
+	if chunks := h.Store.ListBySession("user1", "slow"); len(chunks) != 0 {
+		t.Errorf("Expected the abandoned chunk not to be saved, but got %+v", chunks)
+	}
+
+	var meta Metadata
+	if status := postWithDeadline(t, h, "fast", "X-Request-Deadline", "5000", wav, &meta); status != http.StatusOK || meta.Transcript != "done" {
+		t.Errorf("Expected a generous deadline to succeed, but got %d %+v", status, meta)
+	}
+}
+
+func TestUploadDeadlineDuringInjectedAnalysisLatency(t *testing.T) {
+	h := faultHarness()
+	defer h.Close()
+	if status := adminDo(t, h, "POST", "/admin/faults", faultRequest{Kind: faultAnalysisLatency, Probability: 1, LatencyMS: 1000}, nil); status != http.StatusCreated {
+		t.Fatalf("Expected the fault to be armed, but got %d", status)
+	}
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	exceeded := expvarInt(deadlineStats, "exceeded")
+
+	var missed deadlineReply
+	if status := postWithDeadline(t, h, "s1", "Grpc-Timeout", "200m", wav, &missed); status != http.StatusGatewayTimeout || missed.Progress.Stage != progressAnalysis {
+		t.Errorf("Expected 504 during analysis, but got %d %+v", status, missed)
+	}
+	if expvarInt(deadlineStats, "exceeded") != exceeded+1 {
+		t.Errorf("Expected the missed deadline to be counted")
+	}
+
+	var meta Metadata
+	if status := postWithDeadline(t, h, "s1", "Grpc-Timeout", "5S", wav, &meta); status != http.StatusOK || meta.Status != "processed" {
+		t.Errorf("Expected a generous deadline to ride out the latency, but got %d %+v", status, meta)
+	}
+}
+
+func TestUploadRejectsBadDeadline(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	var reply map[string]any
+	if status := postWithDeadline(t, h, "s1", "X-Request-Deadline", "whenever", SineWAV(440, 100*time.Millisecond, 8000), &reply); status != http.StatusBadRequest || reply["error"] != "invalid_deadline" {
+		t.Errorf("Expected 400 invalid_deadline, but got %d %v", status, reply)
+	}
+}
+
+func TestWebSocketChunkDeadline(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	h.Pipeline.Transcriber = sleepyTranscriber{d: 500 * time.Millisecond}
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=slow"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+
+	conn.WriteJSON(map[string]any{"type": "chunk", "data": wav, "seq": 1, "deadline_ms": 100})
+	var nack struct {
+		Type     string           `json:"type"`
+		Error    string           `json:"error"`
+		Seq      int64            `json:"seq"`
+		Progress DeadlineProgress `json:"progress"`
+	}
+	if err := conn.ReadJSON(&nack); err != nil {
+		t.Fatal(err)
+	}
+	if nack.Type != "error" || nack.Error != "deadline_exceeded" || nack.Seq != 1 || nack.Progress.Stage != progressTranscription {
+		t.Errorf("Expected a deadline_exceeded frame for seq 1, but got %+v", nack)
+	}
+
+	conn.WriteJSON(map[string]any{"type": "chunk", "data": wav, "seq": 2, "deadline_ms": 5000})
+	var ack struct {
+		Ack        bool   `json:"ack"`
+		Seq        int64  `json:"seq"`
+		Transcript string `json:"transcript"`
+	}
+	if err := conn.ReadJSON(&ack); err != nil {
+		t.Fatal(err)
+	}
+	if !ack.Ack || ack.Seq != 2 || ack.Transcript != "done" {
+		t.Errorf("Expected the connection to carry on and ack seq 2, but got %+v", ack)
+	}
+}
@@ -0,0 +1,412 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// dispatchStats exposes, per priority class, the number of queued jobs
+// ("<class>_depth"), the jobs handed to workers ("<class>_dispatched"),
+// their summed queue wait ("<class>_wait_ms") and the jobs refused because
+// their lane was full ("<class>_refused").
+var dispatchStats = expvar.NewMap("dispatch")
+
+// errLaneFull is sent to a job whose lane already holds LaneDepth jobs of
+// its class.
+var errLaneFull = newKindError(ErrResourceExhausted, "too many chunks are waiting to be processed; retry shortly")
+
+// Priority is a processing class. The zero value is interactive, the class
+// of plain HTTP uploads.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota
+	PriorityRealtime
+	PriorityBatch
+)
+
+var priorityNames = map[Priority]string{
+	PriorityRealtime:    "realtime",
+	PriorityInteractive: "interactive",
+	PriorityBatch:       "batch",
+}
+
+func (p Priority) String() string {
+	return priorityNames[p]
+}
+
+// parsePriority accepts the name of a priority class.
+func parsePriority(s string) (Priority, error) {
+	for p, name := range priorityNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown priority %q", s)
+}
+
+// dispatchWeights are the shares of worker hand-offs each class gets while
+// all of them have work queued. The dispatcher picks classes by smooth
+// weighted round robin, so a realtime job waits for at most a couple of
+// other hand-offs and batch work still gets one slot in every 21.
+var dispatchWeights = map[Priority]int{
+	PriorityRealtime:    16,
+	PriorityInteractive: 4,
+	PriorityBatch:       1,
+}
+
+type queuedJob struct {
+	Job
+	queued time.Time
+}
+
+// classQueues queue jobs per priority class and pick the next one by
+// smooth weighted round robin. The Dispatcher's lock guards them.
+type classQueues struct {
+	queues  map[Priority][]queuedJob
+	current map[Priority]int
+}
+
+func newClassQueues() classQueues {
+	return classQueues{queues: make(map[Priority][]queuedJob), current: make(map[Priority]int)}
+}
+
+func (q *classQueues) len() int {
+	var n int
+	for _, jobs := range q.queues {
+		n += len(jobs)
+	}
+	return n
+}
+
+func (q *classQueues) push(job Job) {
+	p := job.Chunk.Priority
+	q.queues[p] = append(q.queues[p], queuedJob{Job: job, queued: time.Now()})
+	dispatchStats.Add(p.String()+"_depth", 1)
+}
+
+// peek returns the job smooth weighted round robin would hand out next,
+// without advancing the rotation.
+func (q *classQueues) peek() (queuedJob, Priority, bool) {
+	best, found := Priority(0), false
+	for p, w := range dispatchWeights {
+		if len(q.queues[p]) == 0 {
+			continue
+		}
+		if !found || q.current[p]+w > q.current[best]+dispatchWeights[best] ||
+			(q.current[p]+w == q.current[best]+dispatchWeights[best] && w > dispatchWeights[best]) {
+			best, found = p, true
+		}
+	}
+	if !found {
+		return queuedJob{}, 0, false
+	}
+	return q.queues[best][0], best, true
+}
+
+// pop removes the job peek returned and advances the rotation among the
+// classes that had work queued.
+func (q *classQueues) pop(class Priority, job queuedJob) {
+	total := 0
+	for p, w := range dispatchWeights {
+		if len(q.queues[p]) > 0 {
+			q.current[p] += w
+			total += w
+		}
+	}
+	q.current[class] -= total
+	q.queues[class] = q.queues[class][1:]
+	if len(q.queues[class]) == 0 {
+		// An idle class starts afresh rather than saving up credit.
+		q.current[class] = 0
+	}
+	name := class.String()
+	dispatchStats.Add(name+"_depth", -1)
+	dispatchStats.Add(name+"_dispatched", 1)
+	dispatchStats.Add(name+"_wait_ms", time.Since(job.queued).Milliseconds())
+}
+
+// dispatchLane is a queue feeding the workers that read out. wake tells
+// the lane's goroutine that a job was queued.
+type dispatchLane struct {
+	out   chan Job
+	queue classQueues
+	wake  chan struct{}
+}
+
+func newDispatchLane(out chan Job) *dispatchLane {
+	return &dispatchLane{out: out, queue: newClassQueues(), wake: make(chan struct{}, 1)}
+}
+
+// sessionPin keeps a session on one lane while it has jobs queued or in
+// flight.
+type sessionPin struct {
+	lane int
+	jobs int
+}
+
+// Dispatcher sits between submitters and pipeline workers, queueing jobs
+// per priority class and handing them out in weighted order. Submitters
+// send on In as they would to a plain jobs channel; workers read Out, or
+// in ordered mode their own Lane.
+//
+// In ordered mode each worker has a lane, and sessions are assigned to
+// lanes by consistent hashing, so a session's chunks are processed one at
+// a time in the order they were submitted while other sessions run in
+// parallel. Chunks of one session submitted at different priorities are
+// ordered within each priority. Resize moves sessions between lanes only
+// once they have nothing queued or in flight.
+type Dispatcher struct {
+	In  chan Job
+	Out chan Job
+	// Budget, when set, turns jobs away instead of queueing them while it
+	// is exhausted and the queue is full.
+	Budget *MemoryBudget
+	// LaneDepth, when positive, caps the jobs of each priority class
+	// queued on each lane; jobs for a full class are turned away, so a
+	// batch backlog never crowds out realtime work. A job with no refused
+	// channel has no way to hear that and is queued regardless.
+	LaneDepth int
+
+	ordered bool
+	mu      sync.Mutex
+	lanes   []*dispatchLane
+	ring    *sessionRing
+	pins    map[string]*sessionPin
+	// run starts a lane's goroutine once Run has started.
+	run func(*dispatchLane)
+}
+
+// NewDispatcher returns a Dispatcher that feeds every worker from one
+// shared lane.
+func NewDispatcher() *Dispatcher {
+	out := make(chan Job)
+	return &Dispatcher{
+		In:    make(chan Job),
+		Out:   out,
+		lanes: []*dispatchLane{newDispatchLane(out)},
+	}
+}
+
+// NewOrderedDispatcher returns a Dispatcher in ordered mode with one lane
+// per worker.
+func NewOrderedDispatcher(workers int) *Dispatcher {
+	d := &Dispatcher{In: make(chan Job), ordered: true, pins: make(map[string]*sessionPin)}
+	d.Resize(workers)
+	return d
+}
+
+// Lane is the channel worker i reads.
+func (d *Dispatcher) Lane(i int) <-chan Job {
+	if !d.ordered {
+		return d.Out
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lanes[i].out
+}
+
+// Resize spreads new sessions over n lanes of an ordered Dispatcher,
+// adding lanes as needed; the caller starts a worker on each new Lane.
+// Lanes beyond n get no new sessions but finish the ones pinned to them.
+func (d *Dispatcher) Resize(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.lanes) < n {
+		l := newDispatchLane(make(chan Job))
+		d.lanes = append(d.lanes, l)
+		if d.run != nil {
+			d.run(l)
+		}
+	}
+	d.ring = newSessionRing(n)
+}
+
+// Len is the number of jobs waiting for a worker.
+func (d *Dispatcher) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lenLocked()
+}
+
+func (d *Dispatcher) lenLocked() int {
+	var n int
+	for _, l := range d.lanes {
+		n += l.queue.len()
+	}
+	return n
+}
+
+// Run moves jobs from In to the lanes until ctx is cancelled. Out is
+// served here, so a job queued ahead of a hand-off is seen by it; ordered
+// lanes each get a goroutine.
+func (d *Dispatcher) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if d.ordered {
+		d.mu.Lock()
+		d.run = func(l *dispatchLane) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.serve(ctx, l)
+			}()
+		}
+		for _, l := range d.lanes {
+			d.run(l)
+		}
+		d.mu.Unlock()
+	}
+	for {
+		var out chan Job
+		var next queuedJob
+		var class Priority
+		if !d.ordered {
+			d.mu.Lock()
+			var ok bool
+			if next, class, ok = d.lanes[0].queue.peek(); ok {
+				out = d.Out
+			}
+			d.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-d.In:
+			d.push(job)
+		case out <- next.Job:
+			d.mu.Lock()
+			d.lanes[0].queue.pop(class, next)
+			d.mu.Unlock()
+		}
+	}
+}
+
+// serve hands a lane's jobs to its workers.
+func (d *Dispatcher) serve(ctx context.Context, l *dispatchLane) {
+	for {
+		d.mu.Lock()
+		next, class, ok := l.queue.peek()
+		d.mu.Unlock()
+		var out chan Job
+		if ok {
+			out = l.out
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.wake:
+		case out <- next.Job:
+			d.mu.Lock()
+			l.queue.pop(class, next)
+			d.mu.Unlock()
+		}
+	}
+}
+
+func (d *Dispatcher) push(job Job) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if job.refused != nil {
+		if err := d.Budget.admit(chunkMemory(job.Chunk.Data), d.lenLocked()); err != nil {
+			job.refused <- err
+			return
+		}
+	}
+	l := d.lanes[0]
+	var key string
+	var pin *sessionPin
+	if d.ordered {
+		key = sessionKey(job.Chunk.UserID, job.Chunk.SessionID)
+		pin = d.pins[key]
+		lane := d.ring.lane(key)
+		if pin != nil {
+			lane = pin.lane
+		}
+		l = d.lanes[lane]
+		if d.full(l, job) {
+			return
+		}
+		if pin == nil {
+			pin = &sessionPin{lane: lane}
+			d.pins[key] = pin
+		}
+		pin.jobs++
+		job.done = func() { d.finish(key) }
+	} else if d.full(l, job) {
+		return
+	}
+	l.queue.push(job)
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// full turns job away if its lane holds LaneDepth jobs of its class.
+func (d *Dispatcher) full(l *dispatchLane, job Job) bool {
+	if d.LaneDepth <= 0 || job.refused == nil || len(l.queue.queues[job.Chunk.Priority]) < d.LaneDepth {
+		return false
+	}
+	dispatchStats.Add(job.Chunk.Priority.String()+"_refused", 1)
+	job.refused <- errLaneFull
+	return true
+}
+
+// finish unpins a session once its last job has been processed.
+func (d *Dispatcher) finish(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pin := d.pins[key]; pin != nil {
+		if pin.jobs--; pin.jobs == 0 {
+			delete(d.pins, key)
+		}
+	}
+}
+
+// ringReplicas is how many points each lane has on the hash ring, which
+// evens out how many sessions each gets.
+const ringReplicas = 64
+
+// sessionRing assigns keys to lanes by consistent hashing: resizing from
+// n to n+1 lanes moves only about 1/(n+1) of the keys.
+type sessionRing struct {
+	points []uint32
+	lanes  map[uint32]int
+}
+
+func newSessionRing(n int) *sessionRing {
+	r := &sessionRing{lanes: make(map[uint32]int, n*ringReplicas)}
+	for lane := 0; lane < n; lane++ {
+		for i := 0; i < ringReplicas; i++ {
+			h := ringHash(fmt.Sprintf("lane-%d-%d", lane, i))
+			if _, taken := r.lanes[h]; !taken {
+				r.lanes[h] = lane
+				r.points = append(r.points, h)
+			}
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// lane returns the lane owning the first point at or after key's hash.
+func (r *sessionRing) lane(key string) int {
+	h := ringHash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.lanes[r.points[i]]
+}
+
+func ringHash(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
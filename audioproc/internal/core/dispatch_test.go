@@ -0,0 +1,276 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func queueJobs(t *testing.T, d *Dispatcher, p Priority, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		d.In <- Job{Chunk: AudioChunk{ChunkID: p.String(), Priority: p}, Result: make(chan Metadata, 1)}
+	}
+}
+
+func TestDispatcherRealtimeWaitUnderBatchLoad(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher()
+	go d.Run(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-d.Out:
+					time.Sleep(2 * time.Millisecond)
+					job.Result <- Metadata{ChunkID: job.Chunk.ChunkID}
+				}
+			}
+		}()
+	}
+
+	// 200 batch jobs keep both workers busy for about 200ms.
+	queueJobs(t, d, PriorityBatch, 200)
+	start := time.Now()
+	meta, err := submitJob(ctx, d.In, AudioChunk{ChunkID: "live", Priority: PriorityRealtime})
+	if err != nil || meta.ChunkID != "live" {
+		t.Fatalf("Expected the realtime job to complete, but got %+v, %v", meta, err)
+	}
+	if wait := time.Since(start); wait > 50*time.Millisecond {
+		t.Errorf("Expected the realtime job to skip the batch backlog, but it took %v", wait)
+	}
+	if d.Len() < 150 {
+		t.Errorf("Expected most of the batch backlog to still be queued, but %d jobs are", d.Len())
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestDispatcherDoesNotStarveBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher()
+	go d.Run(ctx)
+	queueJobs(t, d, PriorityRealtime, 100)
+	queueJobs(t, d, PriorityBatch, 1)
+
+	for i := 1; i <= 17; i++ {
+		if job := <-d.Out; job.Chunk.Priority == PriorityBatch {
+			return
+		}
+	}
+	t.Errorf("Expected batch work within one weighted round of 17 hand-offs")
+}
+
+func TestDispatcherPrefersHigherClasses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewDispatcher()
+	go d.Run(ctx)
+	queueJobs(t, d, PriorityBatch, 3)
+	queueJobs(t, d, PriorityInteractive, 1)
+	queueJobs(t, d, PriorityRealtime, 1)
+
+	var order []string
+	for i := 0; i < 5; i++ {
+		order = append(order, (<-d.Out).Chunk.ChunkID)
+	}
+	if got := strings.Join(order, ","); got != "realtime,interactive,batch,batch,batch" {
+		t.Errorf("Unexpected dispatch order %s", got)
+	}
+}
+
+// orderedWorkers starts a worker on each of d's lanes from..to-1 that records, per session,
+// the chunks it completes and fails the test if a session ever has two
+// chunks in flight.
+func orderedWorkers(t *testing.T, ctx context.Context, d *Dispatcher, from, to int, hold time.Duration) (func(session string) []string, *sync.WaitGroup) {
+	var mu sync.Mutex
+	done := make(map[string][]string)
+	active := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := from; i < to; i++ {
+		lane := d.Lane(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-lane:
+					s := job.Chunk.SessionID
+					mu.Lock()
+					if active[s] {
+						t.Errorf("Session %s has two chunks in flight", s)
+					}
+					active[s] = true
+					mu.Unlock()
+					time.Sleep(hold)
+					mu.Lock()
+					active[s] = false
+					done[s] = append(done[s], job.Chunk.ChunkID)
+					mu.Unlock()
+					job.done()
+					job.Result <- Metadata{ChunkID: job.Chunk.ChunkID}
+				}
+			}
+		}()
+	}
+	return func(session string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), done[session]...)
+	}, &wg
+}
+
+func TestDispatcherLaneDepth(t *testing.T) {
+	for _, d := range []*Dispatcher{NewDispatcher(), NewOrderedDispatcher(1)} {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.LaneDepth = 3
+		go d.Run(ctx)
+
+		// Nothing reads the lane, so the first three jobs stay queued.
+		queueJobs(t, d, PriorityBatch, 3)
+
+		// Realtime work has its own share of the lane.
+		admitted := make(chan error, 1)
+		d.In <- Job{Chunk: AudioChunk{ChunkID: "realtime", UserID: "user1", SessionID: "s2", Priority: PriorityRealtime}, Result: make(chan Metadata, 1), refused: admitted}
+		for deadline := time.Now().Add(time.Second); d.Len() < 4 && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		select {
+		case err := <-admitted:
+			t.Errorf("Expected a realtime job to be queued behind a full batch class, but got %v", err)
+		default:
+		}
+
+		refused := expvarInt(dispatchStats, "batch_refused")
+		_, err := submitJob(ctx, d.In, AudioChunk{ChunkID: "over", UserID: "user1", SessionID: "s1", Priority: PriorityBatch})
+		if !errors.Is(err, ErrResourceExhausted) {
+			t.Errorf("Expected a job for a full class to be refused, but got %v", err)
+		}
+		if got := expvarInt(dispatchStats, "batch_refused"); got != refused+1 {
+			t.Errorf("Expected the refusal counted, but got %d", got-refused)
+		}
+		if n := d.Len(); n != 4 {
+			t.Errorf("Expected 4 jobs still queued, but got %d", n)
+		}
+		d.mu.Lock()
+		if pin := d.pins[sessionKey("user1", "s1")]; pin != nil {
+			t.Errorf("Expected a refused job to leave its session unpinned, but got %+v", pin)
+		}
+		d.mu.Unlock()
+		cancel()
+	}
+}
+
+func TestOrderedDispatcherKeepsSessionOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewOrderedDispatcher(4)
+	go d.Run(ctx)
+	completed, wg := orderedWorkers(t, ctx, d, 0, 4, time.Millisecond)
+
+	var results []chan Metadata
+	want := map[string][]string{}
+	for i := 0; i < 20; i++ {
+		for _, session := range []string{"a", "b"} {
+			id := fmt.Sprintf("%s%02d", session, i)
+			want[session] = append(want[session], id)
+			res := make(chan Metadata, 1)
+			results = append(results, res)
+			d.In <- Job{Chunk: AudioChunk{ChunkID: id, UserID: "user1", SessionID: session}, Result: res}
+		}
+	}
+	for _, res := range results {
+		<-res
+	}
+	for session, ids := range want {
+		if got := completed(session); fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Errorf("Session %s: expected %v, but got %v", session, ids, got)
+		}
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestOrderedDispatcherResizeKeepsInFlightSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := NewOrderedDispatcher(1)
+	go d.Run(ctx)
+	completed, wg := orderedWorkers(t, ctx, d, 0, 1, 20*time.Millisecond)
+	submit := func(id, session string) chan Metadata {
+		res := make(chan Metadata, 1)
+		d.In <- Job{Chunk: AudioChunk{ChunkID: id, UserID: "user1", SessionID: session}, Result: res}
+		return res
+	}
+
+	// Find a session that the grown ring moves off lane 0.
+	grown := newSessionRing(4)
+	session := ""
+	for i := 0; session == ""; i++ {
+		if s := fmt.Sprintf("s%d", i); grown.lane(sessionKey("user1", s)) != 0 {
+			session = s
+		}
+	}
+	first := submit("first", session)
+	d.Resize(4)
+	more, _ := orderedWorkers(t, ctx, d, 1, 4, 0)
+	second := submit("second", session)
+	<-first
+	<-second
+	if got := completed(session); fmt.Sprint(got) != "[first second]" {
+		t.Errorf("Expected the in-flight session to stay on its lane, but it completed %v there and %v elsewhere", got, more(session))
+	}
+
+	// Once idle, the session follows the ring to its new lane.
+	<-submit("third", session)
+	if got := more(session); fmt.Sprint(got) != "[third]" {
+		t.Errorf("Expected the idle session to move, but the new lanes completed %v", got)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestUploadPriorityParam(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	for _, tc := range []struct {
+		priority string
+		admin    bool
+		status   int
+	}{
+		{"batch", false, http.StatusOK},
+		{"realtime", false, http.StatusForbidden},
+		{"realtime", true, http.StatusOK},
+		{"urgent", false, http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=u&session_id=s&priority="+tc.priority, strings.NewReader("audio"))
+		if tc.admin {
+			req.Header.Set("X-Admin-Token", "admin")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("priority=%s admin=%v: expected %d, but got %d", tc.priority, tc.admin, tc.status, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Package core implements the audio processing service: ingest over HTTP,
+// WebSocket and MQTT, the analysis and transcription pipeline, and the
+// store that keeps chunk metadata and audio.
+//
+// The pieces depend on each other too closely to live in separate
+// packages, so they are implemented here together and published through
+// package audioproc and its store, pipeline and api subpackages, which
+// re-export what other programs may use.
+package core
@@ -0,0 +1,269 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsConn is a WebSocket whose writes are serialized, so shutdown can send
+// frames while the handler is writing acks.
+type wsConn struct {
+	*websocket.Conn
+
+	mu       sync.Mutex
+	draining bool
+	// faults, when set, may drop ack frames; see FaultInjector.
+	faults *FaultInjector
+	// session is the user/session key chunks are streamed into, and
+	// attached when the connection was opened.
+	session  string
+	attached time.Time
+	// observerSeq numbers the last observer count sent; see
+	// sendObservers.
+	observerSeq uint64
+}
+
+func (c *wsConn) WriteJSON(v any) error {
+	if ack, ok := v.(map[string]any); ok && ack["ack"] == true && c.faults.dropAck() {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteJSON(v)
+}
+
+// setSession records the session a hello switched the connection to.
+func (c *wsConn) setSession(userID, sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.session = sessionKey(userID, sessionID)
+}
+
+func (c *wsConn) attachment() (session string, since time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session, c.attached
+}
+
+func (c *wsConn) isDraining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// drain tells the client to finish up and stop sending by deadline.
+func (c *wsConn) drain(deadline time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return
+	}
+	c.draining = true
+	_ = c.Conn.WriteJSON(map[string]any{"type": "draining", "deadline": deadline})
+}
+
+// goAway sends a 1001 close frame and closes the connection, which ends the
+// handler's read loop.
+func (c *wsConn) goAway() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	_ = c.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	c.Conn.Close()
+}
+
+// keepAlive pings c every interval until ctx ends. Clients answer with a
+// pong, which extends the read deadline of an otherwise idle connection.
+func keepAlive(ctx context.Context, c *wsConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// wsConns tracks open WebSocket connections so they can be drained on
+// shutdown instead of being reset. Connections are kept in shards by the
+// session they stream into, together with that session's observer count,
+// so that opening, closing and observing a connection only locks its
+// session's shard.
+type wsConns struct {
+	shards []*connShard
+	faults *FaultInjector
+	// open counts the connections in every shard.
+	open atomic.Int64
+	// observerSeq numbers observer counts in the order they were taken.
+	observerSeq atomic.Uint64
+
+	// mu guards the drain state. Drain sets deadline and idle, which is
+	// closed once the last connection is removed, and raises draining,
+	// which add checks without taking mu.
+	mu       sync.Mutex
+	deadline time.Time
+	idle     chan struct{}
+	draining atomic.Bool
+}
+
+// connShard holds the connections of the sessions in one shard, by
+// sessionKey.
+type connShard struct {
+	mu    sync.Mutex
+	conns map[string]map[*wsConn]bool
+	// observers counts the observers attached to each session; see
+	// observerJoined.
+	observers map[string]int
+}
+
+func newWSConns(shards int) *wsConns {
+	t := &wsConns{shards: make([]*connShard, max(shards, 1))}
+	for i := range t.shards {
+		t.shards[i] = &connShard{conns: make(map[string]map[*wsConn]bool), observers: make(map[string]int)}
+	}
+	return t
+}
+
+func (t *wsConns) shard(key string) *connShard {
+	return t.shards[sessionShard(key, len(t.shards))]
+}
+
+// lockSession locks the shard of the session c streams into and returns
+// it with the session's key. c cannot switch sessions until the shard is
+// unlocked.
+func (t *wsConns) lockSession(c *wsConn) (*connShard, string) {
+	for {
+		key, _ := c.attachment()
+		sh := t.shard(key)
+		sh.mu.Lock()
+		if now, _ := c.attachment(); now == key {
+			return sh, key
+		}
+		sh.mu.Unlock()
+	}
+}
+
+func (sh *connShard) insert(key string, c *wsConn) {
+	if sh.conns[key] == nil {
+		sh.conns[key] = make(map[*wsConn]bool)
+	}
+	sh.conns[key][c] = true
+}
+
+// delete removes c from the session keyed by key and reports whether it
+// was there.
+func (sh *connShard) delete(key string, c *wsConn) bool {
+	conns := sh.conns[key]
+	if !conns[c] {
+		return false
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(sh.conns, key)
+	}
+	return true
+}
+
+func (t *wsConns) add(conn *websocket.Conn, userID, sessionID string) *wsConn {
+	c := &wsConn{Conn: conn, faults: t.faults, session: sessionKey(userID, sessionID), attached: time.Now()}
+	sh := t.shard(c.session)
+	sh.mu.Lock()
+	sh.insert(c.session, c)
+	t.open.Add(1)
+	n := sh.observers[c.session]
+	var seq uint64
+	if n > 0 {
+		seq = t.observerSeq.Add(1)
+	}
+	sh.mu.Unlock()
+	if n > 0 {
+		c.sendObservers(seq, n)
+	}
+	// Drain raises draining before it walks the shards, so a connection
+	// added after the walk passed its shard sees it here.
+	if t.draining.Load() {
+		t.mu.Lock()
+		deadline := t.deadline
+		t.mu.Unlock()
+		c.drain(deadline)
+	}
+	return c
+}
+
+func (t *wsConns) remove(c *wsConn) {
+	sh, key := t.lockSession(c)
+	removed := sh.delete(key, c)
+	sh.mu.Unlock()
+	if !removed {
+		return
+	}
+	if t.open.Add(-1) == 0 && t.draining.Load() {
+		t.markIdle()
+	}
+}
+
+// markIdle closes idle if no connection is left.
+func (t *wsConns) markIdle() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idle != nil && t.open.Load() == 0 {
+		close(t.idle)
+		t.idle = nil
+	}
+}
+
+// each calls f for every connection, one shard at a time.
+func (t *wsConns) each(f func(c *wsConn)) {
+	for _, sh := range t.shards {
+		sh.mu.Lock()
+		for _, conns := range sh.conns {
+			for c := range conns {
+				f(c)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// Drain sends every connection a draining notice, waits up to grace for
+// clients to close, then closes whatever is left with 1001 Going Away.
+// Chunks arriving on a draining connection are refused with "draining".
+func (t *wsConns) Drain(grace time.Duration) {
+	t.mu.Lock()
+	if t.open.Load() == 0 {
+		t.mu.Unlock()
+		return
+	}
+	idle := make(chan struct{})
+	t.idle = idle
+	t.deadline = time.Now().Add(grace)
+	deadline := t.deadline
+	t.draining.Store(true)
+	t.mu.Unlock()
+	// The last connection may have gone before draining was raised.
+	t.markIdle()
+	t.each(func(c *wsConn) { c.drain(deadline) })
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return
+	case <-timer.C:
+	}
+
+	var remaining []*wsConn
+	t.each(func(c *wsConn) { remaining = append(remaining, c) })
+	for _, c := range remaining {
+		c.goAway()
+	}
+}
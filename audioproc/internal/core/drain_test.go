@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/gorilla/websocket"
+)
+
+func runServer(t *testing.T, cfg Config, store *MemoryStore) (*Server, context.CancelFunc, <-chan error) {
+	t.Helper()
+	srv := New(cfg, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Run(ctx) }()
+	select {
+	case <-srv.Ready():
+	case err := <-errc:
+		t.Fatalf("Run error: %v", err)
+	}
+	return srv, cancel, errc
+}
+
+func waitDraining(t *testing.T, srv *Server) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		srv.wsConns.mu.Lock()
+		draining := srv.wsConns.idle != nil
+		srv.wsConns.mu.Unlock()
+		if draining {
+			return
+		}
+	}
+	t.Fatal("Timed out waiting for the server to drain")
+}
+
+func TestShutdownDrainsWebSockets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.DrainGrace = 200 * time.Millisecond
+	srv, cancel, errc := runServer(t, cfg, NewMemoryStore())
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr().String()+"/ws?user_id=user1&session_id=s", nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: []byte("before"), Seq: 1})
+	var ack client.Ack
+	if err := conn.ReadJSON(&ack); err != nil || !ack.Ack {
+		t.Fatalf("Expected the first chunk to be acked, but got %+v, %v", ack, err)
+	}
+
+	cancel()
+	var notice struct {
+		Type     string    `json:"type"`
+		Deadline time.Time `json:"deadline"`
+	}
+	if err := conn.ReadJSON(&notice); err != nil || notice.Type != "draining" || notice.Deadline.IsZero() {
+		t.Fatalf("Expected a draining notice with a deadline, but got %+v, %v", notice, err)
+	}
+
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: []byte("after"), Seq: 2})
+	var nack client.Ack
+	if err := conn.ReadJSON(&nack); err != nil || nack.Ack || nack.Error != "draining" || nack.Seq != 2 {
+		t.Fatalf("Expected seq 2 to be refused as draining, but got %+v, %v", nack, err)
+	}
+
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a 1001 close frame after the grace period, but got %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Expected a clean shutdown, but got %v", err)
+	}
+}
+
+func TestClientReconnectsAfterDrain(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.DrainGrace = 5 * time.Second
+	store := NewMemoryStore()
+	old, cancel, errc := runServer(t, cfg, store)
+	ctx, stop := context.WithTimeout(context.Background(), 10*time.Second)
+	defer stop()
+
+	c, err := client.Dial(ctx, "ws://"+old.Addr().String()+"/ws", "user1", "deploy")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c.Close()
+	c.RetryInterval = 10 * time.Millisecond
+	if _, err := c.Send(ctx, []byte("one")); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	cancel()
+	waitDraining(t, old)
+	// The replacement process takes over the address once the old one exits,
+	// which is as soon as the client lets go rather than after the grace.
+	replaced := make(chan context.CancelFunc, 1)
+	go func() {
+		<-errc
+		cfg.Addr = old.Addr().String()
+		ctx, cancel := context.WithCancel(context.Background())
+		go New(cfg, store, nil).Run(ctx)
+		replaced <- cancel
+	}()
+
+	start := time.Now()
+	ack, err := c.Send(ctx, []byte("two"))
+	if err != nil || ack.Seq != 2 || ack.Duplicate {
+		t.Fatalf("Expected seq 2 to be acked by the new server, but got %+v, %v", ack, err)
+	}
+	if elapsed := time.Since(start); elapsed >= cfg.DrainGrace {
+		t.Errorf("Expected the client to leave before the grace period, but it took %v", elapsed)
+	}
+	(<-replaced)()
+	if n := len(store.ListByUser("user1")); n != 2 {
+		t.Errorf("Expected 2 stored chunks, but got %v", n)
+	}
+}
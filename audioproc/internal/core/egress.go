@@ -0,0 +1,225 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// egressRefusals counts outbound connections refused by policy, keyed
+// "<client>.<reason>".
+var egressRefusals = expvar.NewMap("egress_refusals")
+
+// Why an outbound connection was refused. An *EgressError wraps one of
+// these and also matches ErrEgressDenied.
+var (
+	ErrEgressDenied         = errors.New("egress denied")
+	ErrEgressDenylisted     = errors.New("destination is denylisted")
+	ErrEgressNotAllowlisted = errors.New("destination is not allowlisted")
+	ErrEgressPrivate        = errors.New("destination is a private address")
+)
+
+var egressReasons = map[error]string{
+	ErrEgressDenylisted:     "denylisted",
+	ErrEgressNotAllowlisted: "not_allowlisted",
+	ErrEgressPrivate:        "private",
+}
+
+// privatePrefixes are the destinations webhooks may not reach unless
+// Config.WebhookAllowPrivate is set: loopback, RFC 1918 and unique local
+// networks, carrier-grade NAT, and link-local addresses, where cloud
+// metadata services live. A sidecar receiving webhooks on loopback needs
+// an explicit Config.EgressAllow entry.
+var privatePrefixes = []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("::/128"),
+}
+
+// EgressError reports an outbound connection refused by policy.
+type EgressError struct {
+	Client string
+	Host   string
+	IP     netip.Addr
+	Reason error
+}
+
+func (e *EgressError) Error() string {
+	return fmt.Sprintf("%s may not connect to %s (%s): %v", e.Client, e.Host, e.IP, e.Reason)
+}
+
+func (e *EgressError) Unwrap() error { return e.Reason }
+
+func (e *EgressError) Is(target error) bool { return target == ErrEgressDenied }
+
+// EgressPolicy decides which resolved addresses a client may connect to.
+// Deny always wins; an explicit Allow entry overrides DenyPrivate; and
+// when Allow is set nothing outside it is reachable.
+type EgressPolicy struct {
+	Allow       []netip.Prefix
+	Deny        []netip.Prefix
+	DenyPrivate bool
+}
+
+func (p EgressPolicy) check(ip netip.Addr) error {
+	ip = ip.Unmap()
+	switch {
+	case inPrefixes(ip, p.Deny):
+		return ErrEgressDenylisted
+	case inPrefixes(ip, p.Allow):
+		return nil
+	case p.DenyPrivate && inPrefixes(ip, privatePrefixes):
+		return ErrEgressPrivate
+	case len(p.Allow) > 0:
+		return ErrEgressNotAllowlisted
+	}
+	return nil
+}
+
+// Egress builds the HTTP clients the server calls out with: webhooks,
+// alerts, the identity service, and any HTTP transcriber, which should take
+// its client from Server.Egress. They share the proxy, destination policy
+// and connection tuning in Config.
+//
+// Destinations are checked against the addresses actually dialed, so a
+// name that resolves differently at connect time cannot slip past. When a
+// request goes through the proxy the target's name is resolved here and
+// checked before the proxy is asked to connect to it.
+type Egress struct {
+	policy   EgressPolicy
+	proxy    func(*url.URL) (*url.URL, error)
+	proxies  map[string]bool
+	resolver *net.Resolver
+
+	dialTimeout  time.Duration
+	tlsTimeout   time.Duration
+	idleTimeout  time.Duration
+	idlePerHost  int
+	allowPrivate bool
+}
+
+// NewEgress reads cfg's egress settings. Without Config.EgressProxy the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY variables choose the proxy.
+func NewEgress(cfg Config) *Egress {
+	pc := httpproxy.FromEnvironment()
+	if cfg.EgressProxy != "" {
+		pc.HTTPProxy, pc.HTTPSProxy = cfg.EgressProxy, cfg.EgressProxy
+	}
+	e := &Egress{
+		policy:       EgressPolicy{Allow: cfg.EgressAllow, Deny: cfg.EgressDeny},
+		proxy:        pc.ProxyFunc(),
+		proxies:      make(map[string]bool),
+		resolver:     net.DefaultResolver,
+		dialTimeout:  cfg.EgressDialTimeout,
+		tlsTimeout:   cfg.EgressTLSTimeout,
+		idleTimeout:  cfg.EgressIdleTimeout,
+		idlePerHost:  cfg.EgressIdleConnsPerHost,
+		allowPrivate: cfg.WebhookAllowPrivate,
+	}
+	for _, raw := range []string{pc.HTTPProxy, pc.HTTPSProxy} {
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			e.proxies[hostPort(u)] = true
+		}
+	}
+	return e
+}
+
+// hostPort is the address the transport dials for u.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// Client returns a client for the named caller with the given request
+// timeout. Webhooks pass webhook true, which also refuses private
+// addresses unless Config.WebhookAllowPrivate is set.
+func (e *Egress) Client(name string, timeout time.Duration, webhook bool) *http.Client {
+	g := &egressGuard{egress: e, name: name, policy: e.policy}
+	g.policy.DenyPrivate = webhook && !e.allowPrivate
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 g.proxyFor,
+			DialContext:           g.dial,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   e.tlsTimeout,
+			IdleConnTimeout:       e.idleTimeout,
+			MaxIdleConnsPerHost:   e.idlePerHost,
+			ExpectContinueTimeout: time.Second,
+		},
+	}
+}
+
+// egressGuard applies one client's policy to its connections.
+type egressGuard struct {
+	egress *Egress
+	name   string
+	policy EgressPolicy
+}
+
+func (g *egressGuard) refuse(host string, ip netip.Addr, reason error) error {
+	egressRefusals.Add(g.name+"."+egressReasons[reason], 1)
+	return &EgressError{Client: g.name, Host: host, IP: ip, Reason: reason}
+}
+
+// proxyFor picks the proxy for req and, when there is one, checks the
+// target the proxy will connect to.
+func (g *egressGuard) proxyFor(req *http.Request) (*url.URL, error) {
+	proxy, err := g.egress.proxy(req.URL)
+	if err != nil || proxy == nil {
+		return proxy, err
+	}
+	host := req.URL.Hostname()
+	ips, err := g.egress.resolver.LookupNetIP(req.Context(), "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if reason := g.policy.check(ip); reason != nil {
+			return nil, g.refuse(host, ip, reason)
+		}
+	}
+	return proxy, nil
+}
+
+// dial connects to addr, checking each address it resolves to just before
+// connecting. The proxy itself is trusted configuration and is not checked.
+func (g *egressGuard) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{Timeout: g.egress.dialTimeout, KeepAlive: 30 * time.Second}
+	if !g.egress.proxies[addr] {
+		host, _, _ := net.SplitHostPort(addr)
+		d.Control = func(_, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if reason := g.policy.check(ap.Addr()); reason != nil {
+				return g.refuse(host, ap.Addr(), reason)
+			}
+			return nil
+		}
+	}
+	return d.DialContext(ctx, network, addr)
+}
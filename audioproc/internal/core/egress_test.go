@@ -0,0 +1,98 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEgressRefusesWebhookToMetadataService(t *testing.T) {
+	wh := NewWebhooks(DefaultConfig(), NewEgress(DefaultConfig()), NewMemoryStore(), NewBus())
+	before := expvarInt(egressRefusals, "webhooks.private")
+	sub := WebhookSubscription{ID: "w1", URL: "http://169.254.169.254/latest/meta-data/"}
+	_, err := wh.post(context.Background(), sub, "d1", []byte("{}"))
+	var egressErr *EgressError
+	if !errors.Is(err, ErrEgressPrivate) || !errors.Is(err, ErrEgressDenied) || !errors.As(err, &egressErr) || egressErr.IP != netip.MustParseAddr("169.254.169.254") {
+		t.Fatalf("Expected the metadata service refused as private, but got %v", err)
+	}
+	if got := expvarInt(egressRefusals, "webhooks.private") - before; got != 1 {
+		t.Errorf("Expected 1 refusal counted, but got %d", got)
+	}
+	for _, url := range []string{"http://127.0.0.1:9/", "http://[::1]:9/", "http://[fe80::1]:9/"} {
+		if _, err := wh.post(context.Background(), WebhookSubscription{ID: "w1", URL: url}, "d1", []byte("{}")); !errors.Is(err, ErrEgressPrivate) {
+			t.Errorf("%s: expected the webhook refused as private, but got %v", url, err)
+		}
+	}
+
+	// The deny list applies to every client, loopback included.
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	cfg := DefaultConfig()
+	cfg.EgressDeny = mustParsePrefixes("127.0.0.0/8")
+	client := NewEgress(cfg).Client("identity", time.Second, false)
+	if _, err := client.Get(target.URL); !errors.Is(err, ErrEgressDenylisted) {
+		t.Errorf("Expected loopback refused as denylisted, but got %v", err)
+	}
+}
+
+func TestEgressInvalidListRefusesStartup(t *testing.T) {
+	t.Setenv("AUDIO_EGRESS_ALLOW", "203.0.113.0/24, 203.0.113.300")
+	cfg := LoadConfig()
+	if err := cfg.Err(); err == nil || !strings.Contains(err.Error(), "AUDIO_EGRESS_ALLOW") {
+		t.Fatalf("Expected the bad entry reported, but got %v", err)
+	}
+	cfg.Addr = "127.0.0.1:0"
+	if err := New(cfg, NewMemoryStore(), nil).Run(context.Background()); err == nil {
+		t.Errorf("Expected the server not to start")
+	}
+}
+
+func TestEgressPolicy(t *testing.T) {
+	p := EgressPolicy{Allow: mustParsePrefixes("10.1.0.0/16,203.0.113.0/24"), Deny: mustParsePrefixes("10.1.2.0/24"), DenyPrivate: true}
+	for ip, want := range map[string]error{
+		"10.1.1.1":        nil,
+		"10.1.2.1":        ErrEgressDenylisted,
+		"10.2.0.1":        ErrEgressPrivate,
+		"203.0.113.7":     nil,
+		"198.51.100.1":    ErrEgressNotAllowlisted,
+		"::ffff:10.1.2.9": ErrEgressDenylisted,
+	} {
+		if got := p.check(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("%s: expected %v, but got %v", ip, want, got)
+		}
+	}
+}
+
+func TestEgressProxyCarriesAuth(t *testing.T) {
+	var auth, host string
+	var hits int
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		auth, host = r.Header.Get("Proxy-Authorization"), r.URL.Host
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+	cfg := DefaultConfig()
+	cfg.EgressProxy = strings.Replace(proxy.URL, "http://", "http://relay:s3cret@", 1)
+	client := NewEgress(cfg).Client("webhooks", 5*time.Second, true)
+
+	resp, err := client.Post("http://203.0.113.10/hook", "application/json", strings.NewReader("{}"))
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected the proxy's answer, but got %v %v", resp, err)
+	}
+	resp.Body.Close()
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("relay:s3cret")); auth != want || host != "203.0.113.10" {
+		t.Errorf("Expected the request for 203.0.113.10 with %q, but got %q for %q", want, auth, host)
+	}
+
+	// Targets are still checked when the proxy would make the connection.
+	if _, err := client.Get("http://169.254.169.254/"); !errors.Is(err, ErrEgressPrivate) || hits != 1 {
+		t.Errorf("Expected the metadata service refused before reaching the proxy, but got %v after %d requests", err, hits)
+	}
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Kundhavi2798/audio-processor/validate"
+)
+
+// Errors returned by stores, the pipeline and services. Wrap them with %w
+// to add detail; writeError maps them to HTTP statuses.
+var (
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrGone               = errors.New("gone")
+	ErrForbidden          = errors.New("forbidden")
+	ErrQuotaExceeded      = errors.New("quota exceeded")
+	ErrBackendUnavailable = errors.New("backend unavailable")
+	ErrReadOnly           = errors.New("read only")
+	ErrDeadlineExceeded   = errors.New("deadline exceeded")
+	ErrNotAcceptable      = errors.New("not acceptable")
+	ErrResourceExhausted  = errors.New("resource exhausted")
+)
+
+// kindError is a package sentinel that also matches one of the exported
+// error kinds, so callers can test for either.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func newKindError(kind error, msg string) error {
+	return &kindError{msg: msg, kind: kind}
+}
+
+func (e *kindError) Error() string        { return e.msg }
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+var errAdminRequired = newKindError(ErrForbidden, "admin token required")
+
+// FieldError is one invalid field of a ValidationError.
+type FieldError = validate.FieldError
+
+// ValidationError reports every invalid field of a request. Invalid request
+// parameters are a 400; anything else is a 422. Details, when set, is sent
+// alongside the fields to explain what was found.
+type ValidationError struct {
+	Fields  []FieldError
+	Details any
+	params  bool
+}
+
+func invalidField(field, code, message string) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Code: code, Message: message}}}
+}
+
+// invalidParam is invalidField for a request parameter.
+func invalidParam(field, code, message string) *ValidationError {
+	err := invalidField(field, code, message)
+	err.params = true
+	return err
+}
+
+// validateIDs checks a request's user and session IDs against cfg.IDRules.
+func validateIDs(cfg Config, userID, sessionID string) error {
+	var errs validate.Errors
+	if errors.As(cfg.IDRules.IDs(userID, sessionID), &errs) {
+		return &ValidationError{Fields: errs, params: true}
+	}
+	return nil
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid " + strings.Join(msgs, "; ")
+}
+
+// code is the field's own code for single-field errors.
+func (e *ValidationError) code() string {
+	if len(e.Fields) == 1 {
+		return e.Fields[0].Code
+	}
+	return "invalid_request"
+}
+
+var errorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrGone, http.StatusGone, "gone"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrQuotaExceeded, http.StatusTooManyRequests, "quota_exceeded"},
+	{ErrBackendUnavailable, http.StatusServiceUnavailable, "unavailable"},
+	{ErrReadOnly, http.StatusServiceUnavailable, "read_only"},
+	{ErrDeadlineExceeded, http.StatusGatewayTimeout, "deadline_exceeded"},
+	{ErrNotAcceptable, http.StatusNotAcceptable, "not_acceptable"},
+	{ErrResourceExhausted, http.StatusServiceUnavailable, "resource_exhausted"},
+}
+
+// errorStatus translates err to an HTTP status and machine-readable code.
+// Unrecognised errors are internal errors.
+func errorStatus(err error) (int, string) {
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		if verr.params {
+			return http.StatusBadRequest, verr.code()
+		}
+		return http.StatusUnprocessableEntity, verr.code()
+	}
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			return e.status, e.code
+		}
+	}
+	return http.StatusInternalServerError, "internal"
+}
+
+// errorMessage is what a client is told about err. The message of an
+// internal error can name files, hosts or queries, so it is logged
+// instead and the client gets a generic one.
+func errorMessage(err error) string {
+	if status, _ := errorStatus(err); status == http.StatusInternalServerError {
+		log.Printf("Internal error: %v", err)
+		return "internal error"
+	}
+	return err.Error()
+}
+
+// writeError is the one place handlers turn errors into responses. Bodies
+// are {"error": code, "message": ...}, plus "fields" and any "details" for
+// validation errors, "existing_checksum" for chunk ID conflicts and
+// "progress" for missed deadlines.
+func writeError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	var conflict *chunkIDConflict
+	if errors.As(err, &conflict) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(conflict.body())
+		return
+	}
+	var missed *deadlineError
+	if errors.As(err, &missed) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]any{"error": code, "message": err.Error(), "progress": missed.progress})
+		return
+	}
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		writeJSONError(w, status, code, errorMessage(err))
+		return
+	}
+	body := map[string]any{"error": code, "message": err.Error(), "fields": verr.Fields}
+	if verr.Details != nil {
+		body["details"] = verr.Details
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
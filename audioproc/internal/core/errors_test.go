@@ -0,0 +1,152 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreErrorKinds(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	store.Clock = clock
+	store.Retention = time.Hour
+
+	if _, err := store.Get("missing"); !errors.Is(err, ErrNotFound) || !errors.Is(err, errNotFound) {
+		t.Errorf("Expected ErrNotFound, but got %v", err)
+	}
+	if _, err := store.GetBlob("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing blob, but got %v", err)
+	}
+	if err := store.Delete("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound deleting a missing chunk, but got %v", err)
+	}
+
+	store.Save(Metadata{ChunkID: "chunk1", UserID: "user1"})
+	if _, err := store.Restore("chunk1"); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict restoring a live chunk, but got %v", err)
+	}
+	store.Delete("chunk1")
+	clock.Advance(2 * time.Hour)
+	if _, err := store.Restore("chunk1"); !errors.Is(err, ErrGone) {
+		t.Errorf("Expected ErrGone past retention, but got %v", err)
+	}
+
+	var verr *ValidationError
+	if err := verifyChecksum(chunkChecksum([]byte("audio")), "deadbeef"); !errors.As(err, &verr) || verr.Fields[0].Field != "checksum" {
+		t.Errorf("Expected a ValidationError on checksum, but got %v", err)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("loading: %w", errNotFound), http.StatusNotFound, "not_found"},
+		{errSessionClosed, http.StatusConflict, "conflict"},
+		{errRetentionExpired, http.StatusGone, "gone"},
+		{errAdminRequired, http.StatusForbidden, "forbidden"},
+		{errCaptureQuota, http.StatusTooManyRequests, "quota_exceeded"},
+		{errIdentityUnavailable, http.StatusServiceUnavailable, "unavailable"},
+		{invalidField("text", "invalid_annotation", "empty"), http.StatusUnprocessableEntity, "invalid_annotation"},
+		{errors.New("disk on fire"), http.StatusInternalServerError, "internal"},
+	} {
+		if status, code := errorStatus(tc.err); status != tc.status || code != tc.code {
+			t.Errorf("%v: expected %d %s, but got %d %s", tc.err, tc.status, tc.code, status, code)
+		}
+	}
+}
+
+func TestWriteErrorHidesInternalErrors(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeError(rr, errors.New("open /var/lib/audio/blobs/x: permission denied"))
+	var body map[string]string
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusInternalServerError || body["error"] != "internal" || body["message"] != "internal error" {
+		t.Errorf("Expected a generic internal error, but got %d %v", rr.Code, body)
+	}
+
+	rr = httptest.NewRecorder()
+	writeError(rr, fmt.Errorf("chunk c1: %w", errNotFound))
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusNotFound || body["message"] != "chunk c1: chunk not found" {
+		t.Errorf("Expected a known error's own message, but got %d %v", rr.Code, body)
+	}
+}
+
+func TestHandlersTranslateErrors(t *testing.T) {
+	store := NewMemoryStore()
+	router := New(DefaultConfig(), store, nil).Handler()
+	do := func(method, url string, body []byte, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, bytes.NewReader(body))
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("GET", "/chunks/missing", nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing chunk, but got %v", rr.Code)
+	}
+	if rr := do("DELETE", "/chunks/missing", nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting a missing chunk, but got %v", rr.Code)
+	}
+
+	rr := do("POST", "/upload?user_id=user1&session_id=sess1", []byte("audio"), http.Header{checksumHeader: {"deadbeef"}})
+	var body struct {
+		Error  string       `json:"error"`
+		Fields []FieldError `json:"fields"`
+	}
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusUnprocessableEntity || body.Error != "checksum_mismatch" || len(body.Fields) != 1 || body.Fields[0].Field != "checksum" {
+		t.Errorf("Expected 422 with a checksum field error, but got %v %+v", rr.Code, body)
+	}
+}
+
+func TestUploadRejectsInvalidIDs(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	cases := []struct {
+		query string
+		codes []string
+	}{
+		{"user_id=&session_id=s1", []string{"required"}},
+		{"user_id=user1&session_id=" + strings.Repeat("s", 200), []string{"too_long"}},
+		{"user_id=user%00one&session_id=s%0A1", []string{"invalid_characters", "invalid_characters"}},
+	}
+	for _, tc := range cases {
+		resp, err := http.Post(h.URL+"/upload?"+tc.query, "application/octet-stream", strings.NewReader("audio"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error  string       `json:"error"`
+			Fields []FieldError `json:"fields"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		var codes []string
+		for _, f := range body.Fields {
+			codes = append(codes, f.Code)
+		}
+		if resp.StatusCode != http.StatusBadRequest || fmt.Sprint(codes) != fmt.Sprint(tc.codes) {
+			t.Errorf("%s: expected 400 with %v, but got %v %+v", tc.query, tc.codes, resp.StatusCode, body)
+		}
+		if len(tc.codes) > 1 && body.Error != "invalid_request" {
+			t.Errorf("%s: expected invalid_request for several fields, but got %q", tc.query, body.Error)
+		}
+	}
+	if n := len(h.Store.List(func(Metadata) bool { return true })); n != 0 {
+		t.Errorf("Expected nothing to be stored, but got %d chunks", n)
+	}
+}
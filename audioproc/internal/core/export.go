@@ -0,0 +1,564 @@
+package core
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/envelope"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	jobFailed  = "failed"
+	jobExpired = "expired"
+)
+
+var (
+	errExportNotFound = newKindError(ErrNotFound, "export not found")
+	errExportExpired  = newKindError(ErrGone, "export link has expired")
+	errExportNotReady = newKindError(ErrConflict, "export is not finished")
+	errOtherUser      = newKindError(ErrForbidden, "API key belongs to another user")
+)
+
+// checkUserAccess refuses requests about userID made with another user's
+// API key, unless they also carry the admin token.
+func checkUserAccess(cfg Config, r *http.Request, userID string) error {
+	return checkCallerAccess(r.Context(), cfg, r.Header, userID)
+}
+
+// checkCallerAccess is checkUserAccess for Connect handlers, which get the
+// request's context and headers rather than the request.
+func checkCallerAccess(ctx context.Context, cfg Config, header http.Header, userID string) error {
+	if caller := authUserID(ctx); caller != "" && caller != userID && !hasAdminToken(cfg, header) {
+		return errOtherUser
+	}
+	return nil
+}
+
+// minPassphrase is the shortest passphrase an export can be encrypted with.
+const minPassphrase = 8
+
+// ExportStatus reports an export's progress. DownloadURL is set once the
+// bundle is built and stops working at ExpiresAt; the status itself is
+// kept for one more link lifetime after that. A failed export's status is
+// kept until its ExpiresAt. Encryption is set for bundles sealed with a
+// passphrase; see audioctl decrypt.
+type ExportStatus struct {
+	ID           string         `json:"job_id"`
+	UserID       string         `json:"user_id"`
+	IncludeAudio bool           `json:"include_audio"`
+	Encryption   *envelope.Info `json:"encryption,omitempty"`
+	State        string         `json:"state"`
+	Chunks       int            `json:"chunks"`
+	Exported     int            `json:"exported"`
+	Bytes        int64          `json:"bytes"`
+	Error        string         `json:"error,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ExpiresAt    time.Time      `json:"expires_at,omitempty"`
+	DownloadURL  string         `json:"download_url,omitempty"`
+}
+
+// ExportManifest is the bundle's manifest.json, listing every other file
+// in it.
+type ExportManifest struct {
+	UserID       string       `json:"user_id"`
+	JobID        string       `json:"job_id"`
+	CreatedAt    time.Time    `json:"created_at"`
+	IncludeAudio bool         `json:"include_audio"`
+	Files        []ExportFile `json:"files"`
+}
+
+// ExportFile is one file in an export archive's manifest.
+type ExportFile struct {
+	Name string `json:"name"`
+	// Records counts the lines of NDJSON files and the entries of JSON
+	// arrays.
+	Records int    `json:"records,omitempty"`
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// exportSession summarises one session from the chunks stored for it.
+type exportSession struct {
+	SessionID     string    `json:"session_id"`
+	Revision      int       `json:"revision"`
+	Chunks        int       `json:"chunks"`
+	DeletedChunks int       `json:"deleted_chunks"`
+	DurationMS    int64     `json:"duration_ms"`
+	FirstChunkAt  time.Time `json:"first_chunk_at"`
+	LastChunkAt   time.Time `json:"last_chunk_at"`
+}
+
+// Exporter builds per-user data export bundles for subject access
+// requests. A bundle is a zip written to Dir, with the user's chunk
+// metadata, session summaries, annotations and audit events, and
+// optionally their audio. Bundles are served through signed links that
+// expire after ttl, when the file is removed too.
+type Exporter struct {
+	Dir   string
+	Clock Clock
+
+	store      *MemoryStore
+	goroutines *Goroutines
+	secret     func(string) string
+	publicURL  string
+	ttl        time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*ExportStatus
+}
+
+// NewExporter builds exports on goroutines and links finished ones through
+// shares.
+func NewExporter(cfg Config, store *MemoryStore, goroutines *Goroutines, shares *ShareLinks) *Exporter {
+	return &Exporter{
+		Dir:        cfg.ExportDir,
+		Clock:      realClock{},
+		store:      store,
+		goroutines: goroutines,
+		secret:     shares.sign,
+		publicURL:  cfg.PublicURL,
+		ttl:        cfg.ExportTTL,
+		jobs:       make(map[string]*ExportStatus),
+	}
+}
+
+// Start queues an export of userID's data and returns its initial status.
+// A non-empty passphrase encrypts the bundle; it is kept only until the
+// bundle is built.
+func (e *Exporter) Start(userID string, includeAudio bool, passphrase string) ExportStatus {
+	st := &ExportStatus{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		IncludeAudio: includeAudio,
+		State:        jobRunning,
+		CreatedAt:    e.Clock.Now().UTC(),
+	}
+	e.mu.Lock()
+	e.jobs[st.ID] = st
+	snapshot := *st
+	e.mu.Unlock()
+	e.goroutines.Go("export", func(ctx context.Context) { e.run(ctx, st, passphrase) })
+	return snapshot
+}
+
+// Status returns an export of userID's data; other users' exports are
+// reported as missing.
+func (e *Exporter) Status(userID, id string) (ExportStatus, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.jobs[id]
+	if !ok || st.UserID != userID {
+		return ExportStatus{}, false
+	}
+	return *st, true
+}
+
+func (e *Exporter) update(st *ExportStatus, fn func(*ExportStatus)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fn(st)
+}
+
+func (e *Exporter) path(id string) string {
+	return filepath.Join(e.Dir, id+".zip")
+}
+
+func (e *Exporter) run(ctx context.Context, st *ExportStatus, passphrase string) {
+	err := e.build(ctx, st, passphrase)
+	e.update(st, func(st *ExportStatus) {
+		if err != nil {
+			st.State = jobFailed
+			st.Error = err.Error()
+			st.ExpiresAt = e.Clock.Now().UTC().Add(e.ttl).Truncate(time.Second)
+			return
+		}
+		st.State = jobDone
+		st.ExpiresAt = e.Clock.Now().UTC().Add(e.ttl).Truncate(time.Second)
+		st.DownloadURL = e.publicURL + "/exports/" + e.token(st.ID, st.ExpiresAt)
+	})
+	if err != nil {
+		log.Printf("Export %s for %s failed: %v", st.ID, st.UserID, err)
+	}
+}
+
+// build writes the bundle to a temporary file, renamed into place once it
+// is complete so a half-written zip is never served. With a passphrase the
+// zip is encrypted as it is written.
+func (e *Exporter) build(ctx context.Context, st *ExportStatus, passphrase string) (err error) {
+	if err := os.MkdirAll(e.Dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(e.Dir, st.ID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	chunks := e.store.ListByUserWithDeleted(st.UserID)
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Timestamp.Before(chunks[j].Timestamp) })
+	e.update(st, func(st *ExportStatus) { st.Chunks = len(chunks) })
+
+	var out io.Writer = f
+	var sealed io.WriteCloser
+	if passphrase != "" {
+		var info envelope.Info
+		if sealed, info, err = envelope.NewWriter(f, passphrase, envelope.Options{}); err != nil {
+			return err
+		}
+		out = sealed
+		e.update(st, func(st *ExportStatus) { st.Encryption = &info })
+	}
+	b := &bundle{zip: zip.NewWriter(out)}
+	// Transcripts kept out of line are inlined, so the export stands alone.
+	err = b.ndjson("metadata.ndjson", len(chunks), func(i int) any {
+		m, terr := withFullTranscript(e.store, chunks[i])
+		if terr != nil {
+			log.Printf("Exporting chunk %s with its transcript preview: %v", m.ChunkID, terr)
+		}
+		return m
+	})
+	if err == nil {
+		sessions := summarizeSessions(chunks)
+		err = b.json("sessions.json", len(sessions), sessions)
+	}
+	if err == nil {
+		var annotations []Annotation
+		for _, m := range chunks {
+			annotations = append(annotations, e.store.Annotations(m.ChunkID)...)
+		}
+		err = b.ndjson("annotations.ndjson", len(annotations), func(i int) any { return annotations[i] })
+	}
+	if err == nil {
+		events := e.store.AuditEvents(func(a AuditEvent) bool { return a.UserID == st.UserID || a.Actor == st.UserID })
+		err = b.ndjson("audit.ndjson", len(events), func(i int) any { return events[i] })
+	}
+	for i := 0; err == nil && i < len(chunks); i++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if st.IncludeAudio {
+			err = b.audio(e.store, chunks[i].ChunkID)
+		}
+		e.update(st, func(st *ExportStatus) { st.Exported = i + 1 })
+	}
+	if err == nil {
+		err = b.json("manifest.json", 0, ExportManifest{
+			UserID:       st.UserID,
+			JobID:        st.ID,
+			CreatedAt:    st.CreatedAt,
+			IncludeAudio: st.IncludeAudio,
+			Files:        b.files,
+		})
+	}
+	if err == nil {
+		err = b.zip.Close()
+	}
+	if err == nil && sealed != nil {
+		err = sealed.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return err
+	}
+	e.update(st, func(st *ExportStatus) { st.Bytes = info.Size() })
+	return os.Rename(f.Name(), e.path(st.ID))
+}
+
+// bundle writes zip entries, recording each one for the manifest.
+type bundle struct {
+	zip   *zip.Writer
+	files []ExportFile
+}
+
+// countingHash hashes and counts what is written through it.
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.Hash.Write(p)
+}
+
+func (b *bundle) create(name string) (io.Writer, func(records int), error) {
+	w, err := b.zip.Create(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	sum := &countingHash{Hash: sha256.New()}
+	return io.MultiWriter(w, sum), func(records int) {
+		if name == "manifest.json" {
+			return
+		}
+		b.files = append(b.files, ExportFile{Name: name, Records: records, Bytes: sum.n, SHA256: hex.EncodeToString(sum.Sum(nil))})
+	}, nil
+}
+
+func (b *bundle) ndjson(name string, n int, record func(i int) any) error {
+	w, done, err := b.create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for i := 0; i < n; i++ {
+		if err := enc.Encode(record(i)); err != nil {
+			return err
+		}
+	}
+	done(n)
+	return nil
+}
+
+func (b *bundle) json(name string, records int, v any) error {
+	w, done, err := b.create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	done(records)
+	return nil
+}
+
+// audio copies one chunk's audio into the bundle. Chunks whose audio is
+// already gone are left out rather than failing the export.
+func (b *bundle) audio(store *MemoryStore, chunkID string) error {
+	data, err := store.GetBlob(chunkID)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w, done, err := b.create("audio/" + chunkID + audioExtension(data))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	done(0)
+	return nil
+}
+
+func audioExtension(data []byte) string {
+	switch {
+	case isFLAC(data):
+		return ".flac"
+	case len(data) >= 4 && string(data[:4]) == "RIFF":
+		return ".wav"
+	}
+	return ".bin"
+}
+
+func summarizeSessions(chunks []Metadata) []exportSession {
+	byID := make(map[string]*exportSession)
+	var sessions []*exportSession
+	for _, m := range chunks {
+		s, ok := byID[m.SessionID]
+		if !ok {
+			s = &exportSession{SessionID: m.SessionID, FirstChunkAt: m.Timestamp}
+			byID[m.SessionID] = s
+			sessions = append(sessions, s)
+		}
+		s.Chunks++
+		if m.DeletedAt != nil {
+			s.DeletedChunks++
+		}
+		s.Revision = max(s.Revision, m.SessionRevision)
+		s.DurationMS += m.DurationMS
+		if m.Timestamp.Before(s.FirstChunkAt) {
+			s.FirstChunkAt = m.Timestamp
+		}
+		if m.Timestamp.After(s.LastChunkAt) {
+			s.LastChunkAt = m.Timestamp
+		}
+	}
+	result := make([]exportSession, len(sessions))
+	for i, s := range sessions {
+		result[i] = *s
+	}
+	return result
+}
+
+// token signs an export's ID and expiry, so the download link is its own
+// credential.
+func (e *Exporter) token(id string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(id + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return payload + "." + e.secret("export:"+payload)
+}
+
+// Open verifies a download token and opens the bundle it names.
+func (e *Exporter) Open(token string) (*os.File, ExportStatus, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(e.secret("export:"+payload))) {
+		return nil, ExportStatus{}, errExportNotFound
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	id, expiry, ok := strings.Cut(string(raw), "|")
+	unix, perr := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !ok || perr != nil {
+		return nil, ExportStatus{}, errExportNotFound
+	}
+	if !e.Clock.Now().Before(time.Unix(unix, 0)) {
+		return nil, ExportStatus{}, errExportExpired
+	}
+	e.mu.Lock()
+	st, found := e.jobs[id]
+	var snapshot ExportStatus
+	if found {
+		snapshot = *st
+	}
+	e.mu.Unlock()
+	if !found {
+		return nil, ExportStatus{}, errExportNotFound
+	}
+	f, err := os.Open(e.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ExportStatus{}, errExportExpired
+	}
+	return f, snapshot, err
+}
+
+// Sweep removes bundles whose links have expired, and drops the status of
+// exports that expired a link lifetime ago or failed as long ago.
+func (e *Exporter) Sweep() {
+	now := e.Clock.Now()
+	e.mu.Lock()
+	var expired []string
+	for id, st := range e.jobs {
+		switch {
+		case st.State == jobDone && !now.Before(st.ExpiresAt):
+			expired = append(expired, id)
+			st.State = jobExpired
+			st.DownloadURL = ""
+		case st.State == jobExpired && !now.Before(st.ExpiresAt.Add(e.ttl)),
+			st.State == jobFailed && !now.Before(st.ExpiresAt):
+			delete(e.jobs, id)
+		}
+	}
+	e.mu.Unlock()
+	for _, id := range expired {
+		if err := os.Remove(e.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Removing export %s failed: %v", id, err)
+		}
+	}
+}
+
+// RunExportSweeper removes expired exports every interval until ctx ends.
+// Nothing is removed while ro is on.
+func RunExportSweeper(ctx context.Context, e *Exporter, ro *ReadOnly, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !ro.Enabled() {
+				e.Sweep()
+			}
+		}
+	}
+}
+
+type exportRequest struct {
+	IncludeAudio bool   `json:"include_audio"`
+	Passphrase   string `json:"passphrase,omitempty"`
+}
+
+func handleStartExport(e *Exporter, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		var req exportRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_export", "body must be {\"include_audio\": true|false}")
+			return
+		}
+		if req.Passphrase != "" && len(req.Passphrase) < minPassphrase {
+			writeError(w, invalidField("passphrase", "weak_passphrase", fmt.Sprintf("passphrase must be at least %d characters", minPassphrase)))
+			return
+		}
+		st := e.Start(userID, req.IncludeAudio, req.Passphrase)
+		e.store.RecordAudit(AuditEvent{Actor: requestActor(r), Action: "export.start", UserID: userID})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+func handleGetExport(e *Exporter, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := checkUserAccess(cfg, r, vars["id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		st, ok := e.Status(vars["id"], vars["job_id"])
+		if !ok {
+			writeError(w, errExportNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+// handleDownloadExport serves a bundle to anyone holding its link.
+func handleDownloadExport(e *Exporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, st, err := e.Open(mux.Vars(r)["token"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer f.Close()
+		e.store.RecordAudit(AuditEvent{Actor: "export:" + st.ID, Action: "export.download", UserID: st.UserID})
+		name, contentType := "export-"+st.UserID+".zip", "application/zip"
+		if st.Encryption != nil {
+			name, contentType = name+".enc", "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		http.ServeContent(w, r, "", st.CreatedAt, f)
+	}
+}
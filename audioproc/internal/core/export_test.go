@@ -0,0 +1,241 @@
+package core
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/envelope"
+)
+
+func exportConfig(t *testing.T) Config {
+	cfg := shareConfig()
+	cfg.ExportDir = t.TempDir()
+	return cfg
+}
+
+func exportRequestAs(t *testing.T, h *Harness, method, path, key, body string) (int, ExportStatus) {
+	t.Helper()
+	req, _ := http.NewRequest(method, h.URL+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var st ExportStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	return resp.StatusCode, st
+}
+
+func waitExport(t *testing.T, h *Harness, userID, jobID, key string) ExportStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, st := exportRequestAs(t, h, "GET", "/users/"+userID+"/export/"+jobID, key, "")
+		if st.State != jobRunning {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Export %s still running", jobID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readZip(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Bundle is not a zip: %v", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	return files
+}
+
+func ndjsonLines(data []byte) []string {
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+func TestUserExportBundle(t *testing.T) {
+	h := NewHarness(exportConfig(t))
+	defer h.Close()
+	wav := SineWAV(440, time.Second, 8000)
+	first := uploadTo(t, h, "user1", "s1", wav)
+	uploadTo(t, h, "user1", "s1", wav)
+	uploadTo(t, h, "user1", "s2", wav)
+	uploadTo(t, h, "user2", "s1", []byte("someone else's audio"))
+	h.Store.AddAnnotation(Annotation{ID: "a1", ChunkID: first.ChunkID, Text: "door slam", Author: "rev"})
+
+	_, user1 := h.Keys.Mint("user1", nil, 0)
+	_, user2 := h.Keys.Mint("user2", nil, 0)
+
+	status, started := exportRequestAs(t, h, "POST", "/users/user1/export", user1, `{"include_audio": true}`)
+	if status != http.StatusAccepted || started.ID == "" {
+		t.Fatalf("Expected 202 with a job ID, but got %v %+v", status, started)
+	}
+	if status, _ := exportRequestAs(t, h, "POST", "/users/user1/export", user2, `{}`); status != http.StatusForbidden {
+		t.Errorf("Expected another user's key to be refused, but got %v", status)
+	}
+	if status, _ := exportRequestAs(t, h, "GET", "/users/user2/export/"+started.ID, user2, ""); status != http.StatusNotFound {
+		t.Errorf("Expected another user's export to be hidden, but got %v", status)
+	}
+
+	st := waitExport(t, h, "user1", started.ID, user1)
+	if st.State != jobDone || st.Chunks != 3 || st.Exported != 3 || st.DownloadURL == "" {
+		t.Fatalf("Expected a finished export of 3 chunks, but got %+v", st)
+	}
+
+	resp, err := http.Get(h.URL + st.DownloadURL)
+	if err != nil {
+		t.Fatalf("Download error: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("Expected the bundle, but got %v %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	files := readZip(t, data)
+
+	var manifest ExportManifest
+	if err := json.Unmarshal(files["manifest.json"], &manifest); err != nil {
+		t.Fatalf("Bad manifest: %v", err)
+	}
+	if manifest.UserID != "user1" || manifest.JobID != started.ID || !manifest.IncludeAudio {
+		t.Errorf("Unexpected manifest header: %+v", manifest)
+	}
+	if len(manifest.Files) != len(files)-1 {
+		t.Errorf("Expected the manifest to list %d files, but it lists %d", len(files)-1, len(manifest.Files))
+	}
+	for _, f := range manifest.Files {
+		sum := sha256.Sum256(files[f.Name])
+		if int64(len(files[f.Name])) != f.Bytes || hex.EncodeToString(sum[:]) != f.SHA256 {
+			t.Errorf("%s: manifest size or hash does not match the file", f.Name)
+		}
+	}
+
+	metadata := ndjsonLines(files["metadata.ndjson"])
+	if len(metadata) != 3 || strings.Contains(string(files["metadata.ndjson"]), "user2") {
+		t.Errorf("Expected user1's 3 chunks only, but got %s", files["metadata.ndjson"])
+	}
+	var sessions []exportSession
+	json.Unmarshal(files["sessions.json"], &sessions)
+	if len(sessions) != 2 || sessions[0].SessionID != "s1" || sessions[0].Chunks != 2 || sessions[1].Chunks != 1 {
+		t.Errorf("Expected summaries of s1 and s2, but got %+v", sessions)
+	}
+	if lines := ndjsonLines(files["annotations.ndjson"]); len(lines) != 1 || !strings.Contains(lines[0], "door slam") {
+		t.Errorf("Expected the annotation, but got %q", lines)
+	}
+	if !strings.Contains(string(files["audit.ndjson"]), `"export.start"`) {
+		t.Errorf("Expected the export itself in the audit log, but got %s", files["audit.ndjson"])
+	}
+	if audio := files["audio/"+first.ChunkID+".wav"]; !bytes.Equal(audio, wav) {
+		t.Errorf("Expected the chunk's audio in the bundle, but got %d bytes", len(audio))
+	}
+}
+
+func TestUserExportWithoutAudio(t *testing.T) {
+	h := NewHarness(exportConfig(t))
+	defer h.Close()
+	uploadTo(t, h, "user1", "s1", []byte("audio"))
+
+	_, started := exportRequestAs(t, h, "POST", "/users/user1/export", "", "")
+	st := waitExport(t, h, "user1", started.ID, "")
+	resp, err := http.Get(h.URL + st.DownloadURL)
+	if err != nil {
+		t.Fatalf("Download error: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for name := range readZip(t, data) {
+		if strings.HasPrefix(name, "audio/") {
+			t.Errorf("Expected no audio without include_audio, but found %s", name)
+		}
+	}
+}
+
+func TestUserExportLinkExpires(t *testing.T) {
+	h := NewHarness(exportConfig(t))
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Exports.Clock = clock
+	uploadTo(t, h, "user1", "s1", []byte("audio"))
+
+	_, started := exportRequestAs(t, h, "POST", "/users/user1/export", "", "")
+	st := waitExport(t, h, "user1", started.ID, "")
+	forged := strings.Replace(st.DownloadURL, "/exports/", "/exports/x", 1)
+	if status := getWithKey(t, h, forged, ""); status != http.StatusNotFound {
+		t.Errorf("Expected a tampered link to be refused, but got %v", status)
+	}
+
+	clock.Advance(h.Config.ExportTTL)
+	if status := getWithKey(t, h, st.DownloadURL, ""); status != http.StatusGone {
+		t.Errorf("Expected 410 for an expired link, but got %v", status)
+	}
+	h.Exports.Sweep()
+	if _, st := exportRequestAs(t, h, "GET", "/users/user1/export/"+started.ID, "", ""); st.State != jobExpired || st.DownloadURL != "" {
+		t.Errorf("Expected the sweep to expire the export, but got %+v", st)
+	}
+	clock.Advance(h.Config.ExportTTL)
+	h.Exports.Sweep()
+	if status, _ := exportRequestAs(t, h, "GET", "/users/user1/export/"+started.ID, "", ""); status != http.StatusNotFound {
+		t.Errorf("Expected the expired export dropped a link lifetime later, but got %v", status)
+	}
+}
+
+func TestUserExportEncrypted(t *testing.T) {
+	h := NewHarness(exportConfig(t))
+	defer h.Close()
+	uploadTo(t, h, "user1", "s1", []byte("audio"))
+
+	if status, _ := exportRequestAs(t, h, "POST", "/users/user1/export", "", `{"passphrase": "short"}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a short passphrase, but got %v", status)
+	}
+	_, started := exportRequestAs(t, h, "POST", "/users/user1/export", "", `{"passphrase": "correct horse"}`)
+	st := waitExport(t, h, "user1", started.ID, "")
+	if st.State != jobDone || st.Encryption == nil || st.Encryption.Scheme != envelope.Scheme || st.Encryption.KDF != envelope.KDF {
+		t.Fatalf("Expected a finished export with encryption metadata, but got %+v", st)
+	}
+
+	resp, err := http.Get(h.URL + st.DownloadURL)
+	if err != nil {
+		t.Fatalf("Download error: %v", err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/octet-stream" || !strings.Contains(resp.Header.Get("Content-Disposition"), ".zip.enc") {
+		t.Errorf("Expected an encrypted download, but got %s %s", resp.Header.Get("Content-Type"), resp.Header.Get("Content-Disposition"))
+	}
+	if _, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Fatal("Expected the bundle not to open as a zip")
+	}
+	r, err := envelope.NewReader(bytes.NewReader(data), "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := readZip(t, plain); len(ndjsonLines(files["metadata.ndjson"])) != 1 {
+		t.Errorf("Expected the decrypted bundle to hold the chunk, but got %s", files["metadata.ndjson"])
+	}
+}
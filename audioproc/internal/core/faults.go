@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Fault kinds that can be armed through /admin/faults.
+const (
+	faultTranscriptionError = "transcription_error"
+	faultAnalysisLatency    = "analysis_latency"
+	faultDropAck            = "drop_ack"
+)
+
+// faultMaxTTL bounds how long a fault stays armed, so a forgotten one
+// cannot outlive a test run by much.
+const faultMaxTTL = time.Hour
+
+var (
+	faultsInjected = expvar.NewMap("faults_injected")
+
+	errFaultsDisabled = newKindError(ErrForbidden, "fault injection is disabled; start the server with AUDIO_FAULTS=true")
+	errUnknownFault   = newKindError(ErrNotFound, "unknown fault")
+	errInjectedFault  = errors.New("injected fault")
+)
+
+// Fault is an armed failure. Probability applies to transcription_error,
+// LatencyMS to analysis_latency and EveryN to drop_ack. Injected counts
+// how often it has fired.
+type Fault struct {
+	ID          string    `json:"id"`
+	Kind        string    `json:"kind"`
+	Probability float64   `json:"probability,omitempty"`
+	LatencyMS   int64     `json:"latency_ms,omitempty"`
+	EveryN      int64     `json:"every_n,omitempty"`
+	ArmedAt     time.Time `json:"armed_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Injected    int64     `json:"injected"`
+
+	// seen counts the acks a drop_ack fault has looked at.
+	seen int64
+}
+
+// FaultInjector holds the faults armed for chaos testing. It only exists
+// when Config.Faults is set; a nil FaultInjector injects nothing, so the
+// hooks below cost nothing in production.
+type FaultInjector struct {
+	Clock Clock
+
+	mu     sync.Mutex
+	faults map[string]*Fault
+	ttl    time.Duration
+}
+
+// NewFaultInjector returns nil unless fault injection is enabled.
+func NewFaultInjector(cfg Config) *FaultInjector {
+	if !cfg.Faults {
+		return nil
+	}
+	return &FaultInjector{Clock: realClock{}, faults: make(map[string]*Fault), ttl: cfg.FaultTTL}
+}
+
+// faultRequest arms a fault. TTLSeconds defaults to Config.FaultTTL.
+type faultRequest struct {
+	Kind        string  `json:"kind"`
+	Probability float64 `json:"probability,omitempty"`
+	LatencyMS   int64   `json:"latency_ms,omitempty"`
+	EveryN      int64   `json:"every_n,omitempty"`
+	TTLSeconds  int64   `json:"ttl_seconds,omitempty"`
+}
+
+func (req faultRequest) validate() error {
+	switch req.Kind {
+	case faultTranscriptionError:
+		if req.Probability <= 0 || req.Probability > 1 {
+			return invalidField("probability", "invalid_fault", "probability must be above 0 and at most 1")
+		}
+	case faultAnalysisLatency:
+		if req.LatencyMS <= 0 || req.LatencyMS > time.Minute.Milliseconds() {
+			return invalidField("latency_ms", "invalid_fault", "latency_ms must be 1 to 60000")
+		}
+	case faultDropAck:
+		if req.EveryN < 1 {
+			return invalidField("every_n", "invalid_fault", "every_n must be at least 1")
+		}
+	default:
+		return invalidField("kind", "invalid_fault", "kind must be transcription_error, analysis_latency or drop_ack")
+	}
+	if req.TTLSeconds < 0 || time.Duration(req.TTLSeconds)*time.Second > faultMaxTTL {
+		return invalidField("ttl_seconds", "invalid_fault", fmt.Sprintf("ttl_seconds must be 0 to %d", int64(faultMaxTTL/time.Second)))
+	}
+	return nil
+}
+
+// Arm validates and arms a fault.
+func (f *FaultInjector) Arm(req faultRequest) (Fault, error) {
+	if f == nil {
+		return Fault{}, errFaultsDisabled
+	}
+	if err := req.validate(); err != nil {
+		return Fault{}, err
+	}
+	ttl := f.ttl
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	now := f.Clock.Now().UTC()
+	fault := &Fault{
+		ID:          uuid.New().String(),
+		Kind:        req.Kind,
+		Probability: req.Probability,
+		LatencyMS:   req.LatencyMS,
+		EveryN:      req.EveryN,
+		ArmedAt:     now,
+		ExpiresAt:   now.Add(ttl),
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults[fault.ID] = fault
+	return *fault, nil
+}
+
+// Disarm removes a fault before it expires.
+func (f *FaultInjector) Disarm(id string) error {
+	if f == nil {
+		return errFaultsDisabled
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.faults[id]; !ok {
+		return errUnknownFault
+	}
+	delete(f.faults, id)
+	return nil
+}
+
+// List returns the armed faults, oldest first.
+func (f *FaultInjector) List() ([]Fault, error) {
+	if f == nil {
+		return nil, errFaultsDisabled
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expireLocked()
+	faults := make([]Fault, 0, len(f.faults))
+	for _, fault := range f.faults {
+		faults = append(faults, *fault)
+	}
+	sort.Slice(faults, func(i, j int) bool { return faults[i].ArmedAt.Before(faults[j].ArmedAt) })
+	return faults, nil
+}
+
+func (f *FaultInjector) expireLocked() {
+	now := f.Clock.Now()
+	for id, fault := range f.faults {
+		if !now.Before(fault.ExpiresAt) {
+			delete(f.faults, id)
+		}
+	}
+}
+
+// fire counts every armed fault of kind for which trigger is true and
+// reports whether any was.
+func (f *FaultInjector) fire(kind string, trigger func(*Fault) bool) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expireLocked()
+	fired := false
+	for _, fault := range f.faults {
+		if fault.Kind == kind && trigger(fault) {
+			fault.Injected++
+			faultsInjected.Add(kind, 1)
+			fired = true
+		}
+	}
+	return fired
+}
+
+// analysisLatency holds up the analysis stage for the armed latency.
+func (f *FaultInjector) analysisLatency(ctx context.Context) {
+	var delay time.Duration
+	f.fire(faultAnalysisLatency, func(fault *Fault) bool {
+		delay += time.Duration(fault.LatencyMS) * time.Millisecond
+		return true
+	})
+	if delay <= 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(delay):
+	}
+}
+
+// dropAck reports whether the next ack frame should be dropped.
+func (f *FaultInjector) dropAck() bool {
+	return f.fire(faultDropAck, func(fault *Fault) bool {
+		fault.seen++
+		return fault.seen%fault.EveryN == 0
+	})
+}
+
+// faultyTranscriber fails transcription as armed and otherwise defers to
+// Transcriber.
+type faultyTranscriber struct {
+	Transcriber
+	faults *FaultInjector
+}
+
+func (t faultyTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	if t.faults.fire(faultTranscriptionError, func(fault *Fault) bool { return rand.Float64() < fault.Probability }) {
+		return Transcription{}, errInjectedFault
+	}
+	return t.Transcriber.Transcribe(ctx, chunk)
+}
+
+func handleArmFault(f *FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req faultRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_fault_body", err.Error())
+			return
+		}
+		fault, err := f.Arm(req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Armed %s fault %s until %s (%s)", fault.Kind, fault.ID, fault.ExpiresAt.Format(time.RFC3339), requestActor(r))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(fault)
+	}
+}
+
+func handleListFaults(f *FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		faults, err := f.List()
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(faults)
+	}
+}
+
+func handleDisarmFault(f *FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := f.Disarm(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Disarmed fault %s (%s)", id, requestActor(r))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func adminDo(t *testing.T, h *Harness, method, path string, body any, out any) int {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	req, _ := http.NewRequest(method, h.URL+path, &buf)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+func faultHarness() *Harness {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.Faults = true
+	return NewHarness(cfg)
+}
+
+func TestFaultsNeedExplicitFlag(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	var reply map[string]any
+	if status := adminDo(t, h, "POST", "/admin/faults", faultRequest{Kind: faultTranscriptionError, Probability: 1}, &reply); status != http.StatusForbidden {
+		t.Errorf("Expected 403 without AUDIO_FAULTS, but got %d %v", status, reply)
+	}
+}
+
+func TestTranscriptionFaultAndRecovery(t *testing.T) {
+	h := faultHarness()
+	defer h.Close()
+	wav := SineWAV(440, time.Second, 8000)
+
+	var fault Fault
+	if status := adminDo(t, h, "POST", "/admin/faults", faultRequest{Kind: faultTranscriptionError, Probability: 1}, &fault); status != http.StatusCreated {
+		t.Fatalf("Expected 201, but got %d", status)
+	}
+	for i := 0; i < 2; i++ {
+		if meta := uploadTo(t, h, "user1", "s1", wav); meta.Status != "failed" || meta.Transcript != "" {
+			t.Errorf("Expected the injected failure, but got %+v", meta)
+		}
+	}
+	var armed []Fault
+	adminDo(t, h, "GET", "/admin/faults", nil, &armed)
+	if len(armed) != 1 || armed[0].ID != fault.ID || armed[0].Injected != 2 {
+		t.Errorf("Expected the fault to have fired twice, but got %+v", armed)
+	}
+
+	if status := adminDo(t, h, "DELETE", "/admin/faults/"+fault.ID, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Expected 204, but got %d", status)
+	}
+	if meta := uploadTo(t, h, "user1", "s1", wav); meta.Status != "processed" || meta.Transcript != "Hello World" {
+		t.Errorf("Expected transcription to recover, but got %+v", meta)
+	}
+
+	// The failed chunks are left for reprocessing, which now succeeds.
+	st := h.Reprocessor.Start(ReprocessFilter{UserID: "user1", FailedOnly: true})
+	if st = waitForState(t, h.Reprocessor, st.ID, jobDone); st.Processed != 2 || st.Failed != 0 {
+		t.Errorf("Expected both failed chunks reprocessed, but got %+v", st)
+	}
+	for _, m := range h.Store.ListByUser("user1") {
+		if m.Status != "processed" {
+			t.Errorf("Expected chunk %s recovered, but got %q", m.ChunkID, m.Status)
+		}
+	}
+}
+
+func TestDropAckFault(t *testing.T) {
+	h := faultHarness()
+	defer h.Close()
+	adminDo(t, h, "POST", "/admin/faults", faultRequest{Kind: faultDropAck, EveryN: 2}, nil)
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	for seq := int64(1); seq <= 3; seq++ {
+		conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: seq})
+	}
+	var acks []int64
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		var ack struct {
+			Seq int64 `json:"seq"`
+		}
+		if err := conn.ReadJSON(&ack); err != nil {
+			break
+		}
+		acks = append(acks, ack.Seq)
+	}
+	if len(acks) != 2 || acks[0] != 1 || acks[1] != 3 {
+		t.Errorf("Expected the second ack dropped, but got %v", acks)
+	}
+	if n := len(h.Store.ListByUser("user1")); n != 3 {
+		t.Errorf("Expected all 3 chunks stored, but got %d", n)
+	}
+}
+
+func TestFaultsExpire(t *testing.T) {
+	h := faultHarness()
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Faults.Clock = clock
+
+	var reply map[string]any
+	if status := adminDo(t, h, "POST", "/admin/faults", faultRequest{Kind: faultAnalysisLatency}, &reply); status != http.StatusUnprocessableEntity || reply["error"] != "invalid_fault" {
+		t.Errorf("Expected a latency fault without latency_ms to be refused, but got %d %v", status, reply)
+	}
+	adminDo(t, h, "POST", "/admin/faults", faultRequest{Kind: faultAnalysisLatency, LatencyMS: 50, TTLSeconds: 60}, nil)
+	start := time.Now()
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected analysis to be delayed by 50ms, but took %v", elapsed)
+	}
+
+	clock.Advance(time.Minute)
+	var armed []Fault
+	if adminDo(t, h, "GET", "/admin/faults", nil, &armed); len(armed) != 0 {
+		t.Errorf("Expected the fault to have expired, but got %+v", armed)
+	}
+}
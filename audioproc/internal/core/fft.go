@@ -0,0 +1,97 @@
+package core
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft computes an in-place radix-2 FFT; len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// powerSpectrum returns |X(k)|^2 for k in [0, n/2] of a Hann-windowed frame.
+func powerSpectrum(frame []float64) []float64 {
+	n := len(frame)
+	x := make([]complex128, n)
+	for i, v := range frame {
+		w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(n-1))
+		x[i] = complex(v*w, 0)
+	}
+	fft(x)
+	power := make([]float64, n/2+1)
+	for k := range power {
+		power[k] = real(x[k])*real(x[k]) + imag(x[k])*imag(x[k])
+	}
+	return power
+}
+
+func nextPow2(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// dominantFrameLen is the longest frame dominantFrequency analyses.
+const dominantFrameLen = 4096
+
+// dominantFrequency returns the frequency with the most energy summed over
+// the audio's frames, or false for silence and audio too short to analyse.
+// The peak is placed between bins by fitting a parabola to the log power
+// around it.
+func dominantFrequency(pcm PCM) (float64, bool) {
+	n := min(dominantFrameLen, nextPow2(len(pcm.Samples)))
+	if n > len(pcm.Samples) {
+		n /= 2
+	}
+	if n < 4 || pcm.SampleRate <= 0 {
+		return 0, false
+	}
+	total := make([]float64, n/2+1)
+	for start := 0; start+n <= len(pcm.Samples); start += n {
+		for k, p := range powerSpectrum(pcm.Samples[start : start+n]) {
+			total[k] += p
+		}
+	}
+	// Bin 0 is the DC offset, not a frequency.
+	peak := 1
+	for k := 2; k < len(total); k++ {
+		if total[k] > total[peak] {
+			peak = k
+		}
+	}
+	if total[peak] == 0 {
+		return 0, false
+	}
+	bin := float64(peak)
+	if peak+1 < len(total) && total[peak-1] > 0 && total[peak+1] > 0 {
+		a, b, c := math.Log(total[peak-1]), math.Log(total[peak]), math.Log(total[peak+1])
+		if d := a - 2*b + c; d < 0 {
+			bin += 0.5 * (a - c) / d
+		}
+	}
+	return bin * float64(pcm.SampleRate) / float64(n), true
+}
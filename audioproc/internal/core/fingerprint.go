@@ -0,0 +1,159 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// The acoustic fingerprint splits audio into overlapping frames and, for
+// each, marks which of fpBands log-spaced bands between fpMinHz and fpMaxHz
+// are among the fpPeaks strongest. Bands are defined in Hz and frames in
+// seconds, so re-encodes at another bit depth or sample rate still match.
+const (
+	fpBands      = 32
+	fpPeaks      = 3
+	fpMinHz      = 300.0
+	fpMaxHz      = 3400.0
+	fpFrameSecs  = 0.128
+	fpHopDivisor = 8
+	fpMaxShift   = 64 // frames of start trim tolerated when comparing
+	fpFloor      = 1e-3
+)
+
+// Fingerprint returns the hex-encoded per-frame band masks of pcm.
+func Fingerprint(pcm PCM) string {
+	frameLen := nextPow2(int(fpFrameSecs * float64(pcm.SampleRate)))
+	hop := frameLen / fpHopDivisor
+	if len(pcm.Samples) < frameLen || pcm.SampleRate < 2*int(fpMaxHz) {
+		return ""
+	}
+
+	edges := make([]int, fpBands+1)
+	for i := range edges {
+		hz := fpMinHz * math.Pow(fpMaxHz/fpMinHz, float64(i)/fpBands)
+		edges[i] = int(hz * float64(frameLen) / float64(pcm.SampleRate))
+	}
+
+	var out []byte
+	for start := 0; start+frameLen <= len(pcm.Samples); start += hop {
+		power := powerSpectrum(pcm.Samples[start : start+frameLen])
+		energy := make([]float64, fpBands)
+		var total float64
+		for b := 0; b < fpBands; b++ {
+			for k := edges[b]; k < edges[b+1] || k == edges[b]; k++ {
+				energy[b] += power[k]
+			}
+			total += energy[b]
+		}
+		out = binary.BigEndian.AppendUint32(out, peakMask(energy, total*fpFloor))
+	}
+	return hex.EncodeToString(out)
+}
+
+// peakMask sets a bit for each of the fpPeaks strongest bands above floor.
+func peakMask(energy []float64, floor float64) uint32 {
+	order := make([]int, len(energy))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return energy[order[i]] > energy[order[j]] })
+	var mask uint32
+	for _, b := range order[:fpPeaks] {
+		if energy[b] > floor && energy[b] > 0 {
+			mask |= 1 << b
+		}
+	}
+	return mask
+}
+
+func decodeFingerprint(fp string) []uint32 {
+	raw, err := hex.DecodeString(fp)
+	if err != nil {
+		return nil
+	}
+	frames := make([]uint32, len(raw)/4)
+	for i := range frames {
+		frames[i] = binary.BigEndian.Uint32(raw[i*4:])
+	}
+	return frames
+}
+
+// FingerprintDistance returns 0 for identical audio up to 1 for unrelated
+// audio: the share of differing peak bits at the best alignment of the two
+// fingerprints within fpMaxShift frames.
+func FingerprintDistance(a, b string) float64 {
+	fa, fb := decodeFingerprint(a), decodeFingerprint(b)
+	minOverlap := min(len(fa), len(fb)) / 2
+	if minOverlap < 4 {
+		return 1
+	}
+	best := 1.0
+	for shift := -fpMaxShift; shift <= fpMaxShift; shift++ {
+		var diff, union, overlap int
+		for i := range fa {
+			j := i + shift
+			if j < 0 || j >= len(fb) {
+				continue
+			}
+			overlap++
+			diff += bits.OnesCount32(fa[i] ^ fb[j])
+			union += bits.OnesCount32(fa[i] | fb[j])
+		}
+		if overlap < minOverlap || union == 0 {
+			continue
+		}
+		if d := float64(diff) / float64(union); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// SimilarChunk is a match returned by GET /chunks/{id}/similar.
+type SimilarChunk struct {
+	Metadata
+	Distance float64 `json:"distance"`
+}
+
+// handleSimilar lists chunks whose fingerprint is within the configured
+// distance of the given chunk. Only the chunk's owner or an admin may ask.
+// Matches are limited to the same user unless an admin passes all_users=true.
+func handleSimilar(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		src, err := store.Get(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, src.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		allUsers := r.URL.Query().Get("all_users") == "true"
+		if allUsers && !isAdmin(cfg, r) {
+			writeError(w, errAdminRequired)
+			return
+		}
+		result := []SimilarChunk{}
+		if src.Fingerprint != "" {
+			candidates := store.List(func(m Metadata) bool {
+				return m.ChunkID != src.ChunkID && m.Fingerprint != "" && (allUsers || m.UserID == src.UserID)
+			})
+			for _, m := range candidates {
+				if d := FingerprintDistance(src.Fingerprint, m.Fingerprint); d <= cfg.FingerprintThreshold {
+					result = append(result, SimilarChunk{Metadata: m, Distance: d})
+				}
+			}
+			sort.Slice(result, func(i, j int) bool { return result[i].Distance < result[j].Distance })
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
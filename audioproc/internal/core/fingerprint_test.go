@@ -0,0 +1,104 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// sweep returns a linear sine sweep from f0 to f1 Hz, quantized to the given
+// number of bits to mimic lower-bitrate encodes.
+func sweep(f0, f1, seconds float64, sampleRate, quantBits int) []int16 {
+	n := int(seconds * float64(sampleRate))
+	levels := math.Pow(2, float64(quantBits-1))
+	samples := make([]int16, n)
+	var phase float64
+	for i := range samples {
+		f := f0 + (f1-f0)*float64(i)/float64(n)
+		phase += 2 * math.Pi * f / float64(sampleRate)
+		v := math.Round(0.5*math.Sin(phase)*levels) / levels
+		samples[i] = int16(v * math.MaxInt16)
+	}
+	return samples
+}
+
+func fingerprintOf(t *testing.T, samples []int16, sampleRate int) string {
+	t.Helper()
+	pcm, err := decodeWAV(EncodeWAV(samples, sampleRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := Fingerprint(pcm)
+	if fp == "" {
+		t.Fatal("Expected a fingerprint")
+	}
+	return fp
+}
+
+func TestFingerprintDistance(t *testing.T) {
+	const rate = 16000
+	original := fingerprintOf(t, sweep(400, 3000, 3, rate, 16), rate)
+
+	// Requantize to 8 bits and trim 0.25s off the start.
+	requantized := sweep(400, 3000, 3, rate, 8)
+	trimmed := fingerprintOf(t, requantized[rate/4:], rate)
+
+	different := fingerprintOf(t, sweep(3000, 400, 3, rate, 16), rate)
+
+	if d := FingerprintDistance(original, trimmed); d > DefaultConfig().FingerprintThreshold {
+		t.Errorf("Expected the requantized, trimmed encode to match, but distance is %.3f", d)
+	}
+	if d := FingerprintDistance(original, different); d <= DefaultConfig().FingerprintThreshold {
+		t.Errorf("Expected a different signal not to match, but distance is %.3f", d)
+	}
+}
+
+func TestHandleSimilar(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	const rate = 16000
+	hi := EncodeWAV(sweep(400, 3000, 2, rate, 16), rate)
+	lo := EncodeWAV(sweep(400, 3000, 2, rate, 8)[rate/10:], rate)
+	other := EncodeWAV(sweep(3000, 400, 2, rate, 16), rate)
+
+	src := uploadTo(t, h, "user1", "sess1", hi)
+	match := uploadTo(t, h, "user1", "sess2", lo)
+	uploadTo(t, h, "user1", "sess3", other)
+	uploadTo(t, h, "user2", "sess1", hi)
+
+	resp, err := http.Get(h.URL + "/chunks/" + src.ChunkID + "/similar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var similar []SimilarChunk
+	json.NewDecoder(resp.Body).Decode(&similar)
+	if len(similar) != 1 || similar[0].ChunkID != match.ChunkID {
+		t.Errorf("Expected only %v to be similar within user1, but got %+v", match.ChunkID, similar)
+	}
+	if src.Fingerprint == "" {
+		t.Errorf("Expected uploads to carry a fingerprint")
+	}
+}
+
+func TestHandleSimilarOtherUser(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	src := uploadTo(t, h, "user1", "sess1", EncodeWAV(sweep(400, 3000, 2, 16000, 16), 16000))
+	_, user1 := h.Keys.Mint("user1", nil, 0)
+	_, user2 := h.Keys.Mint("user2", nil, 0)
+	for key, want := range map[string]int{user1: http.StatusOK, user2: http.StatusForbidden} {
+		req, _ := http.NewRequest("GET", h.URL+"/chunks/"+src.ChunkID+"/similar", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected status code %d, but got %d", want, resp.StatusCode)
+		}
+	}
+}
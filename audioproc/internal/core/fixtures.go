@@ -0,0 +1,40 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+// SineWAV generates a mono 16-bit PCM WAV file containing a sine tone, for
+// use as a deterministic audio fixture.
+func SineWAV(freqHz float64, duration time.Duration, sampleRate int) []byte {
+	n := int(duration.Seconds() * float64(sampleRate))
+	samples := make([]int16, n)
+	for i := range samples {
+		v := math.Sin(2 * math.Pi * freqHz * float64(i) / float64(sampleRate))
+		samples[i] = int16(v * 0.5 * math.MaxInt16)
+	}
+	return EncodeWAV(samples, sampleRate)
+}
+
+// EncodeWAV wraps mono 16-bit samples in a canonical RIFF/WAVE container.
+func EncodeWAV(samples []int16, sampleRate int) []byte {
+	dataLen := len(samples) * 2
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataLen))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // mono
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*2))
+	binary.Write(&buf, binary.LittleEndian, uint16(2))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataLen))
+	binary.Write(&buf, binary.LittleEndian, samples)
+	return buf.Bytes()
+}
@@ -0,0 +1,436 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+var errNotFLAC = errors.New("not a FLAC file")
+
+// StreamInfo describes an audio stream without decoding it.
+type StreamInfo struct {
+	SampleRate    int   `json:"sample_rate"`
+	Channels      int   `json:"channels"`
+	BitsPerSample int   `json:"bits_per_sample"`
+	TotalSamples  int64 `json:"total_samples"`
+}
+
+// Duration is the stream's length computed from its sample count.
+func (s StreamInfo) Duration() time.Duration {
+	if s.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(s.TotalSamples) * time.Second / time.Duration(s.SampleRate)
+}
+
+// flacError reports the block of a FLAC stream that failed to decode.
+type flacError struct {
+	Block  string
+	Offset int
+	Err    error
+}
+
+func (e *flacError) Error() string {
+	return fmt.Sprintf("corrupt FLAC %s at byte %d: %v", e.Block, e.Offset, e.Err)
+}
+
+func (e *flacError) Unwrap() error { return e.Err }
+
+func isFLAC(data []byte) bool {
+	return len(data) >= 4 && string(data[:4]) == "fLaC"
+}
+
+// probeFLAC reads STREAMINFO and returns it with the offset of the first
+// audio frame.
+func probeFLAC(data []byte) (StreamInfo, int, error) {
+	if !isFLAC(data) {
+		return StreamInfo{}, 0, errNotFLAC
+	}
+	var info StreamInfo
+	var seen bool
+	off := 4
+	for {
+		if off+4 > len(data) {
+			return info, 0, &flacError{"metadata block header", off, errors.New("truncated")}
+		}
+		last := data[off]&0x80 != 0
+		typ := data[off] & 0x7f
+		size := int(data[off+1])<<16 | int(data[off+2])<<8 | int(data[off+3])
+		body := data[off+4:]
+		if size > len(body) {
+			return info, 0, &flacError{fmt.Sprintf("metadata block type %d", typ), off, errors.New("truncated")}
+		}
+		body = body[:size]
+		if typ == 0 {
+			if size != 34 {
+				return info, 0, &flacError{"STREAMINFO", off, fmt.Errorf("length %d, want 34", size)}
+			}
+			packed := binary.BigEndian.Uint64(body[10:18])
+			info = StreamInfo{
+				SampleRate:    int(packed >> 44),
+				Channels:      int(packed>>41&0x7) + 1,
+				BitsPerSample: int(packed>>36&0x1f) + 1,
+				TotalSamples:  int64(packed & (1<<36 - 1)),
+			}
+			if info.SampleRate == 0 {
+				return info, 0, &flacError{"STREAMINFO", off, errors.New("sample rate is zero")}
+			}
+			seen = true
+		}
+		off += 4 + size
+		if last {
+			break
+		}
+	}
+	if !seen {
+		return info, 0, &flacError{"STREAMINFO", 4, errors.New("missing")}
+	}
+	return info, off, nil
+}
+
+// decodeFLAC decodes a FLAC stream, checking every frame's CRCs.
+func decodeFLAC(data []byte) (PCM, error) {
+	info, off, err := probeFLAC(data)
+	if err != nil {
+		return PCM{}, err
+	}
+	// TotalSamples comes from the upload, so it only sizes the first
+	// allocation as far as the frames that follow could plausibly fill
+	// it; a forged count grows the slice no further than the audio does.
+	var samples []float64
+	if info.TotalSamples > 0 {
+		samples = make([]float64, 0, min(info.TotalSamples, int64(len(data)-off)))
+	}
+	scale := float64(int64(1) << (info.BitsPerSample - 1))
+	for n := 0; off < len(data); n++ {
+		if info.TotalSamples > 0 && int64(len(samples)) >= info.TotalSamples {
+			break
+		}
+		block, size, err := decodeFLACFrame(data[off:], info)
+		if err != nil {
+			return PCM{}, &flacError{fmt.Sprintf("frame %d", n), off, err}
+		}
+		for i := range block[0] {
+			var sum float64
+			for _, ch := range block {
+				sum += float64(ch[i])
+			}
+			samples = append(samples, sum/float64(len(block))/scale)
+		}
+		off += size
+	}
+	return PCM{Samples: samples, SampleRate: info.SampleRate}, nil
+}
+
+// decodeFLACFrame decodes one frame and returns its samples per channel and
+// its length in bytes.
+func decodeFLACFrame(data []byte, info StreamInfo) ([][]int64, int, error) {
+	br := &bitReader{data: data}
+	if br.read(15) != 0x7ffc {
+		return nil, 0, errors.New("missing frame sync code")
+	}
+	br.read(1) // blocking strategy
+	sizeCode, rateCode := br.read(4), br.read(4)
+	chanCode, bpsCode := br.read(4), br.read(3)
+	br.read(1)
+	br.utf8()
+	var blockSize int
+	switch {
+	case sizeCode == 1:
+		blockSize = 192
+	case sizeCode >= 2 && sizeCode <= 5:
+		blockSize = 576 << (sizeCode - 2)
+	case sizeCode == 6:
+		blockSize = int(br.read(8)) + 1
+	case sizeCode == 7:
+		blockSize = int(br.read(16)) + 1
+	case sizeCode >= 8:
+		blockSize = 256 << (sizeCode - 8)
+	default:
+		return nil, 0, errors.New("reserved block size")
+	}
+	switch rateCode {
+	case 12:
+		br.read(8)
+	case 13, 14:
+		br.read(16)
+	case 15:
+		return nil, 0, errors.New("invalid sample rate")
+	}
+	bps := info.BitsPerSample
+	if bpsCode != 0 {
+		bps = []int{0, 8, 12, 0, 16, 20, 24, 32}[bpsCode]
+		if bps == 0 {
+			return nil, 0, errors.New("reserved sample size")
+		}
+	}
+	if br.err != nil {
+		return nil, 0, br.err
+	}
+	if headerLen := br.pos / 8; crc8(data[:headerLen]) != byte(br.read(8)) {
+		return nil, 0, errors.New("frame header CRC mismatch")
+	}
+
+	channels := int(chanCode) + 1
+	if chanCode >= 8 {
+		if chanCode > 10 {
+			return nil, 0, errors.New("reserved channel assignment")
+		}
+		channels = 2
+	}
+	if channels != info.Channels {
+		return nil, 0, fmt.Errorf("frame has %d channels, stream has %d", channels, info.Channels)
+	}
+	block := make([][]int64, channels)
+	for c := range block {
+		chBPS := bps
+		// The side channel needs one extra bit.
+		if (chanCode == 8 && c == 1) || (chanCode == 9 && c == 0) || (chanCode == 10 && c == 1) {
+			chBPS++
+		}
+		samples, err := br.subframe(blockSize, chBPS)
+		if err != nil {
+			return nil, 0, fmt.Errorf("subframe %d: %w", c, err)
+		}
+		block[c] = samples
+	}
+	br.align()
+	end := br.pos / 8
+	if crc := br.read(16); br.err != nil || crc16(data[:end]) != uint16(crc) {
+		return nil, 0, errors.New("frame CRC mismatch")
+	}
+
+	switch chanCode {
+	case 8: // left, side
+		for i, side := range block[1] {
+			block[1][i] = block[0][i] - side
+		}
+	case 9: // side, right
+		for i, side := range block[0] {
+			block[0][i] = side + block[1][i]
+		}
+	case 10: // mid, side
+		for i := range block[0] {
+			mid, side := block[0][i]<<1|block[1][i]&1, block[1][i]
+			block[0][i], block[1][i] = (mid+side)>>1, (mid-side)>>1
+		}
+	}
+	return block, br.pos / 8, nil
+}
+
+var fixedCoefficients = [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+func (br *bitReader) subframe(n, bps int) ([]int64, error) {
+	if br.read(1) != 0 {
+		return nil, errors.New("nonzero padding bit")
+	}
+	typ := br.read(6)
+	wasted := 0
+	if br.read(1) == 1 {
+		wasted = int(br.unary()) + 1
+		bps -= wasted
+	}
+	if bps <= 0 {
+		return nil, errors.New("no bits per sample left")
+	}
+	samples := make([]int64, n)
+	switch {
+	case typ == 0:
+		v := br.signed(bps)
+		for i := range samples {
+			samples[i] = v
+		}
+	case typ == 1:
+		for i := range samples {
+			samples[i] = br.signed(bps)
+		}
+	case typ >= 8 && typ <= 12:
+		coeffs := fixedCoefficients[typ-8]
+		if len(coeffs) > n {
+			return nil, errors.New("predictor order exceeds block size")
+		}
+		for i := range coeffs {
+			samples[i] = br.signed(bps)
+		}
+		if err := br.residual(samples, len(coeffs)); err != nil {
+			return nil, err
+		}
+		predict(samples, coeffs, 0)
+	case typ >= 32:
+		order := int(typ-32) + 1
+		if order > n {
+			return nil, errors.New("predictor order exceeds block size")
+		}
+		for i := 0; i < order; i++ {
+			samples[i] = br.signed(bps)
+		}
+		precision := int(br.read(4)) + 1
+		if precision == 16 {
+			return nil, errors.New("invalid LPC precision")
+		}
+		shift := br.signed(5)
+		if shift < 0 {
+			return nil, errors.New("negative LPC shift")
+		}
+		coeffs := make([]int64, order)
+		for i := range coeffs {
+			coeffs[i] = br.signed(precision)
+		}
+		if err := br.residual(samples, order); err != nil {
+			return nil, err
+		}
+		predict(samples, coeffs, int(shift))
+	default:
+		return nil, fmt.Errorf("reserved subframe type %d", typ)
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	if wasted > 0 {
+		for i := range samples {
+			samples[i] <<= wasted
+		}
+	}
+	return samples, nil
+}
+
+// predict turns residuals after the warm-up samples into samples.
+func predict(samples, coeffs []int64, shift int) {
+	for i := len(coeffs); i < len(samples); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += c * samples[i-1-j]
+		}
+		samples[i] += sum >> shift
+	}
+}
+
+// residual reads Rice-coded residuals into samples[order:].
+func (br *bitReader) residual(samples []int64, order int) error {
+	method := br.read(2)
+	if method > 1 {
+		return errors.New("reserved residual coding method")
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partOrder := br.read(4)
+	parts := 1 << partOrder
+	if len(samples)%parts != 0 || len(samples)/parts < order {
+		return errors.New("invalid residual partition order")
+	}
+	i := order
+	for p := 0; p < parts; p++ {
+		end := (p + 1) * len(samples) / parts
+		k := br.read(paramBits)
+		if k == escape {
+			raw := int(br.read(5))
+			for ; i < end; i++ {
+				if raw == 0 {
+					samples[i] = 0
+				} else {
+					samples[i] = br.signed(raw)
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			v := br.unary()<<k | br.read(uint(k))
+			samples[i] = int64(v>>1) ^ -int64(v&1)
+		}
+		if br.err != nil {
+			return br.err
+		}
+	}
+	return br.err
+}
+
+// bitReader reads big-endian bit fields. Reading past the end sets err and
+// returns zeros.
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+var errTruncated = errors.New("truncated")
+
+func (br *bitReader) read(n uint) uint64 {
+	var v uint64
+	for ; n > 0; n-- {
+		if br.pos/8 >= len(br.data) {
+			br.err = errTruncated
+			return 0
+		}
+		bit := br.data[br.pos/8] >> (7 - br.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		br.pos++
+	}
+	return v
+}
+
+func (br *bitReader) signed(n int) int64 {
+	v := br.read(uint(n))
+	return int64(v<<(64-n)) >> (64 - n)
+}
+
+// unary counts zero bits up to the next one bit.
+func (br *bitReader) unary() uint64 {
+	var n uint64
+	for br.err == nil && br.read(1) == 0 {
+		n++
+	}
+	return n
+}
+
+// utf8 reads FLAC's UTF-8-like coded frame or sample number.
+func (br *bitReader) utf8() uint64 {
+	first := br.read(8)
+	extra := bits.LeadingZeros8(^uint8(first))
+	if extra == 0 {
+		return first
+	}
+	v := first & (0xff >> (extra + 1))
+	for i := 1; i < extra; i++ {
+		v = v<<6 | br.read(8)&0x3f
+	}
+	return v
+}
+
+func (br *bitReader) align() {
+	br.pos = (br.pos + 7) &^ 7
+}
+
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bitWriter is the encoding side of bitReader, for building test streams.
+type bitWriter struct {
+	buf  []byte
+	bits int
+}
+
+func (w *bitWriter) write(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (7 - w.bits%8)
+		w.bits++
+	}
+}
+
+func (w *bitWriter) align() {
+	w.bits = (w.bits + 7) &^ 7
+}
+
+// encodeFLAC builds a FLAC stream of 16-bit audio in fixed-size blocks.
+// Mono blocks are stored verbatim; stereo blocks are coded left/side with
+// the left channel verbatim and the side channel through a second-order
+// fixed predictor, so both subframe paths are exercised.
+func encodeFLAC(channels [][]int16, rate, blockSize int) []byte {
+	total := len(channels[0])
+	var out bytes.Buffer
+	out.WriteString("fLaC")
+	out.Write([]byte{0x80, 0, 0, 34})
+	binary.Write(&out, binary.BigEndian, uint16(blockSize))
+	binary.Write(&out, binary.BigEndian, uint16(blockSize))
+	out.Write(make([]byte, 6))
+	binary.Write(&out, binary.BigEndian, uint64(rate)<<44|uint64(len(channels)-1)<<41|uint64(15)<<36|uint64(total))
+	out.Write(make([]byte, 16))
+
+	for n, start := 0, 0; start < total; n, start = n+1, start+blockSize {
+		end := min(start+blockSize, total)
+		w := &bitWriter{}
+		w.write(0xfff8, 16)
+		w.write(7, 4) // block size in 16 bits after the header
+		w.write(0, 4) // sample rate from STREAMINFO
+		if len(channels) == 2 {
+			w.write(8, 4)
+		} else {
+			w.write(uint64(len(channels)-1), 4)
+		}
+		w.write(4, 3) // 16 bits per sample
+		w.write(0, 1)
+		w.write(uint64(n), 8)
+		w.write(uint64(end-start-1), 16)
+		w.write(uint64(crc8(w.buf)), 8)
+
+		left := channels[0][start:end]
+		w.write(1<<1, 8) // verbatim
+		for _, s := range left {
+			w.write(uint64(uint16(s)), 16)
+		}
+		if len(channels) == 2 {
+			right := channels[1][start:end]
+			side := make([]int64, len(left))
+			for i := range side {
+				side[i] = int64(left[i]) - int64(right[i])
+			}
+			w.write(10<<1, 8) // fixed, order 2
+			for _, s := range side[:2] {
+				w.write(uint64(s)&(1<<17-1), 17)
+			}
+			const k = 6
+			w.write(0, 2)
+			w.write(0, 4)
+			w.write(k, 4)
+			for i := 2; i < len(side); i++ {
+				res := side[i] - (2*side[i-1] - side[i-2])
+				v := uint64(res<<1) ^ uint64(res>>63)
+				for q := v >> k; q > 0; q-- {
+					w.write(0, 1)
+				}
+				w.write(1, 1)
+				w.write(v&(1<<k-1), k)
+			}
+		}
+		w.align()
+		out.Write(w.buf)
+		binary.Write(&out, binary.BigEndian, crc16(w.buf))
+	}
+	return out.Bytes()
+}
+
+// toneFLAC is 0.5s of a 440Hz tone at 8kHz, at half scale on the left
+// and quarter scale on the right.
+func toneFLAC() []byte {
+	const rate = 8000
+	left, right := make([]int16, rate/2), make([]int16, rate/2)
+	for i := range left {
+		v := math.Sin(2 * math.Pi * 440 * float64(i) / rate)
+		left[i], right[i] = int16(v*0.5*math.MaxInt16), int16(v*0.25*math.MaxInt16)
+	}
+	return encodeFLAC([][]int16{left, right}, rate, 1024)
+}
+
+func TestFLACFixture(t *testing.T) {
+	data, err := os.ReadFile("testdata/tone.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := probeAudio(data)
+	want := StreamInfo{SampleRate: 8000, Channels: 2, BitsPerSample: 16, TotalSamples: 4000}
+	if err != nil || info != want {
+		t.Fatalf("Expected stream info %+v, but got %+v, %v", want, info, err)
+	}
+	if info.Duration() != 500*time.Millisecond {
+		t.Errorf("Expected 500ms, but got %v", info.Duration())
+	}
+
+	pcm, err := decodeAudio(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcm.Samples) != 4000 || pcm.SampleRate != 8000 {
+		t.Fatalf("Expected 4000 samples at 8kHz, but got %d at %d", len(pcm.Samples), pcm.SampleRate)
+	}
+	// The downmix is a 0.375 amplitude sine, whose RMS is 0.375/sqrt(2).
+	if rms := frameRMS(pcm.Samples); math.Abs(rms-0.375/math.Sqrt2) > 0.001 {
+		t.Errorf("Expected RMS %.4f, but got %.4f", 0.375/math.Sqrt2, rms)
+	}
+	if !bytes.Equal(data, toneFLAC()) {
+		t.Errorf("Expected the fixture to match toneFLAC; regenerate testdata/tone.flac")
+	}
+}
+
+func TestFLACMonoAndShortFinalBlock(t *testing.T) {
+	samples := []int16{0, 1000, -1000, 32767, -32768, 5}
+	pcm, err := decodeFLAC(encodeFLAC([][]int16{samples}, 16000, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range samples {
+		if got := pcm.Samples[i] * 32768; got != float64(s) {
+			t.Errorf("Expected sample %d to be %d, but got %v", i, s, got)
+		}
+	}
+}
+
+func TestFLACForgedSampleCount(t *testing.T) {
+	samples := []int16{0, 1000, -1000, 5}
+	data := encodeFLAC([][]int16{samples}, 16000, 4)
+	// Claim 2^36-1 samples, half a terabyte once decoded.
+	packed := binary.BigEndian.Uint64(data[18:26])
+	binary.BigEndian.PutUint64(data[18:26], packed|(1<<36-1))
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	pcm, err := decodeFLAC(data)
+	runtime.ReadMemStats(&after)
+	if err != nil || len(pcm.Samples) != len(samples) {
+		t.Fatalf("Expected the %d samples actually there, but got %d, %v", len(samples), len(pcm.Samples), err)
+	}
+	if grew := after.TotalAlloc - before.TotalAlloc; grew > 1<<20 {
+		t.Errorf("Expected decoding to allocate for the frames present, but it allocated %d bytes", grew)
+	}
+}
+
+func TestUploadFLAC(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	meta := uploadTo(t, h, "archive", "s", toneFLAC())
+	if meta.DurationMS != 500 || meta.Fingerprint == "" || meta.LoudnessDBFS == nil || math.Abs(*meta.LoudnessDBFS-(-11.53)) > 0.05 {
+		t.Errorf("Expected duration, fingerprint and loudness for FLAC, but got %+v", meta)
+	}
+
+	corrupt := toneFLAC()
+	corrupt[len(corrupt)/2] ^= 0xff
+	resp, err := http.Post(h.URL+"/upload?user_id=archive&session_id=s", "audio/flac", bytes.NewReader(corrupt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(string(body), "corrupt FLAC frame 1") {
+		t.Errorf("Expected a 422 naming the corrupt frame, but got %v %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync"
+)
+
+// Goroutines owns a server's long-lived goroutines: the workers and
+// sweepers it starts, and the WebSocket and SSE handlers that outlive an
+// ordinary request. Each is counted under a component label, runs with a
+// context that ends when the server stops, and is waited for by Wait.
+type Goroutines struct {
+	ctx context.Context
+	wg  sync.WaitGroup
+	// streams ends with ctx or, earlier, when CloseStreams is called.
+	streams      context.Context
+	closeStreams context.CancelFunc
+
+	mu       sync.Mutex
+	counts   map[string]int
+	stopping bool
+}
+
+func newGoroutines(ctx context.Context) *Goroutines {
+	streams, closeStreams := context.WithCancel(ctx)
+	return &Goroutines{ctx: ctx, streams: streams, closeStreams: closeStreams, counts: make(map[string]int)}
+}
+
+// add counts one goroutine of component, or reports false once Wait has
+// been called.
+func (g *Goroutines) add(component string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopping {
+		return false
+	}
+	g.wg.Add(1)
+	g.counts[component]++
+	return true
+}
+
+func (g *Goroutines) done(component string) {
+	g.mu.Lock()
+	g.counts[component]--
+	if g.counts[component] == 0 {
+		delete(g.counts, component)
+	}
+	g.mu.Unlock()
+	g.wg.Done()
+}
+
+// Go runs fn on a new goroutine with the server's context.
+func (g *Goroutines) Go(component string, fn func(ctx context.Context)) {
+	if !g.add(component) {
+		return
+	}
+	go func() {
+		defer g.done(component)
+		fn(g.ctx)
+	}()
+}
+
+// Track counts the calling goroutine as component until done is called. The
+// returned context ends with parent or when the server stops, whichever is
+// first; once the server is stopping it is already done.
+func (g *Goroutines) Track(parent context.Context, component string) (ctx context.Context, done func()) {
+	return g.track(parent, g.ctx, component)
+}
+
+// TrackStream is Track for open-ended streams with nothing to finish, which
+// also end at CloseStreams.
+func (g *Goroutines) TrackStream(parent context.Context, component string) (ctx context.Context, done func()) {
+	return g.track(parent, g.streams, component)
+}
+
+// CloseStreams ends the streams tracked with TrackStream, so that an HTTP
+// server shutdown need not wait for their clients to hang up.
+func (g *Goroutines) CloseStreams() {
+	g.closeStreams()
+}
+
+func (g *Goroutines) track(parent, until context.Context, component string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	if !g.add(component) {
+		cancel()
+		return ctx, func() {}
+	}
+	stop := context.AfterFunc(until, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		g.done(component)
+	}
+}
+
+// Counts reports how many goroutines of each component are running.
+func (g *Goroutines) Counts() map[string]int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	counts := make(map[string]int, len(g.counts))
+	for c, n := range g.counts {
+		counts[c] = n
+	}
+	return counts
+}
+
+// Total is the number of tracked goroutines.
+func (g *Goroutines) Total() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, c := range g.counts {
+		n += c
+	}
+	return n
+}
+
+// Wait refuses new goroutines and waits for the running ones, which should
+// be on their way out once the server's context is cancelled.
+func (g *Goroutines) Wait() {
+	g.mu.Lock()
+	g.stopping = true
+	g.mu.Unlock()
+	g.wg.Wait()
+}
+
+type goroutineReport struct {
+	// Goroutines is every goroutine in the process, tracked or not.
+	Goroutines int            `json:"goroutines"`
+	Tracked    int            `json:"tracked"`
+	Components map[string]int `json:"components"`
+}
+
+func handleGoroutines(g *Goroutines) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		counts := g.Counts()
+		report := goroutineReport{Goroutines: runtime.NumGoroutine(), Components: counts}
+		for _, n := range counts {
+			report.Tracked += n
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
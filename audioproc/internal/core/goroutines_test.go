@@ -0,0 +1,262 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+) `)
+
+// packagePrefix qualifies this package's functions in stack traces, both
+// in frames and in "created by" lines of goroutines yet to start.
+var packagePrefix = func() string {
+	name := runtime.FuncForPC(reflect.ValueOf(waitTracked).Pointer()).Name()
+	return strings.TrimSuffix(name, "waitTracked")
+}()
+
+// serviceGoroutines returns the stacks of goroutines running or started
+// by this package's code, by goroutine ID.
+func serviceGoroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		m := goroutineHeader.FindStringSubmatch(stack)
+		if m == nil || !strings.Contains(stack, packagePrefix) {
+			continue
+		}
+		stacks[m[1]] = stack
+	}
+	return stacks
+}
+
+// expectNoLeaks fails the test if, once it and its cleanups have finished,
+// goroutines running this package's code are left that were not there when
+// it started. Register it before anything whose cleanup stops goroutines.
+func expectNoLeaks(t *testing.T) {
+	t.Helper()
+	before := serviceGoroutines()
+	t.Cleanup(func() {
+		http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+		var leaked []string
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+			leaked = leaked[:0]
+			for id, stack := range serviceGoroutines() {
+				if _, ok := before[id]; !ok {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+		}
+		for _, stack := range leaked {
+			t.Errorf("Leaked goroutine:\n%s", stack)
+		}
+	})
+}
+
+func waitTracked(t *testing.T, g *Goroutines, component string, want int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if g.Counts()[component] == want {
+			return
+		}
+	}
+	t.Fatalf("Expected %d %s goroutines, but have %v", want, component, g.Counts())
+}
+
+func TestNoLeaksAfterHTTPHandlers(t *testing.T) {
+	expectNoLeaks(t)
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	meta := uploadTo(t, h, "user1", "s1", []byte("audio"))
+	for _, path := range []string{
+		"/chunks/" + meta.ChunkID,
+		"/chunks/" + meta.ChunkID + "/audio",
+		"/chunks/" + meta.ChunkID + "/transcript",
+		"/sessions/user1",
+		"/openapi.json",
+	} {
+		resp, err := http.Get(h.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	req, _ := http.NewRequest("DELETE", h.URL+"/chunks/"+meta.ChunkID, nil)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	req, _ = http.NewRequest("GET", h.URL+"/admin/goroutines", nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report goroutineReport
+	json.NewDecoder(resp.Body).Decode(&report)
+	if report.Components["worker"] != cfg.Workers || report.Components["dispatcher"] != 1 || report.Goroutines < report.Tracked {
+		t.Errorf("Expected the workers and dispatcher in the report, but got %+v", report)
+	}
+}
+
+func TestNoLeaksAfterStreamingClientsHangUp(t *testing.T) {
+	expectNoLeaks(t)
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	resp, err := http.Get(h.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitTracked(t, h.Goroutines, "sse", 1)
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	conn.WriteMessage(websocket.BinaryMessage, []byte("audio"))
+	conn.ReadMessage()
+	waitTracked(t, h.Goroutines, "ws", 1)
+
+	resp.Body.Close()
+	conn.Close()
+	waitTracked(t, h.Goroutines, "sse", 0)
+	waitTracked(t, h.Goroutines, "ws", 0)
+	waitTracked(t, h.Goroutines, "ws_keepalive", 0)
+}
+
+// Hijacked WebSocket connections are not closed by the HTTP server, so
+// stopping the service must close them itself.
+func TestNoLeaksWhenStoppedWithOpenWebSocket(t *testing.T) {
+	expectNoLeaks(t)
+	h := NewHarness(DefaultConfig())
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	waitTracked(t, h.Goroutines, "ws", 1)
+
+	h.Close()
+	if n := h.Goroutines.Total(); n != 0 {
+		t.Errorf("Expected nothing tracked after Close, but have %v", h.Goroutines.Counts())
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("Expected the server to close the WebSocket")
+	}
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	expectNoLeaks(t)
+	cfg := DefaultConfig()
+	cfg.WSIdleTimeout = 200 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	// A client that reads answers the server's pings and stays connected.
+	live, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=live"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer live.Close()
+	acks := make(chan []byte, 1)
+	go func() {
+		for {
+			_, msg, err := live.ReadMessage()
+			if err != nil {
+				close(acks)
+				return
+			}
+			acks <- msg
+		}
+	}()
+
+	// One that never reads never pongs, and is dropped.
+	silent, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=silent"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer silent.Close()
+	waitTracked(t, h.Goroutines, "ws", 2)
+	waitTracked(t, h.Goroutines, "ws", 1)
+
+	time.Sleep(2 * cfg.WSIdleTimeout)
+	live.WriteMessage(websocket.BinaryMessage, []byte("audio"))
+	if msg, ok := <-acks; !ok || !bytes.Contains(msg, []byte(`"ack":true`)) {
+		t.Errorf("Expected the pinged connection to stay open, but got %s", msg)
+	}
+}
+
+// Shutdown waits for in-flight requests, and an SSE stream is never
+// finished, so Run has to end streams itself to shut down promptly.
+func TestShutdownEndsEventStreams(t *testing.T) {
+	expectNoLeaks(t)
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	srv, cancel, errc := runServer(t, cfg, NewMemoryStore())
+
+	resp, err := http.Get("http://" + srv.Addr().String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitTracked(t, srv.Goroutines, "sse", 1)
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, but got %v", err)
+		}
+	case <-time.After(shutdownTimeout):
+		t.Fatal("Run waited out the shutdown timeout for the event stream")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected a prompt shutdown, but it took %v", elapsed)
+	}
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err == nil {
+		t.Error("Expected the event stream to end")
+	}
+}
+
+func TestGoroutinesRefuseWorkAfterWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g := newGoroutines(ctx)
+	g.Go("loop", func(ctx context.Context) { <-ctx.Done() })
+	cancel()
+	g.Wait()
+
+	ran := false
+	g.Go("late", func(context.Context) { ran = true })
+	tracked, done := g.Track(context.Background(), "late")
+	defer done()
+	if ran || tracked.Err() == nil || g.Total() != 0 {
+		t.Errorf("Expected nothing to start after Wait, but have %v", g.Counts())
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+)
+
+// Harness runs the full service (router, store and worker pool) on a random
+// local port so integrations can be tested end to end over real HTTP and
+// WebSocket connections.
+type Harness struct {
+	*Server
+	URL string
+
+	server   *httptest.Server
+	stop     func()
+	inFlight atomic.Int64
+}
+
+// NewHarness runs the service on a fresh MemoryStore.
+func NewHarness(cfg Config) *Harness {
+	return NewHarnessWithStore(cfg, NewMemoryStore())
+}
+
+// NewHarnessWithStore runs the service on an existing store, as if it were
+// restarting against a durable backend.
+func NewHarnessWithStore(cfg Config, store *MemoryStore) *Harness {
+	h := &Harness{Server: New(cfg, store, nil)}
+	h.stop = h.Server.Start()
+	h.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.inFlight.Add(1)
+		defer h.inFlight.Add(-1)
+		h.Handler().ServeHTTP(w, r)
+	}))
+	h.URL = h.server.URL
+	return h
+}
+
+// WSURL returns the ws:// address of path on the harness server.
+func (h *Harness) WSURL(path string) string {
+	return "ws" + strings.TrimPrefix(h.URL, "http") + path
+}
+
+// InFlight reports how many HTTP requests are currently being served.
+func (h *Harness) InFlight() int {
+	return int(h.inFlight.Load())
+}
+
+// Close stops accepting connections, waits for in-flight requests to drain,
+// then stops the workers.
+func (h *Harness) Close() {
+	h.server.Close()
+	h.stop()
+}
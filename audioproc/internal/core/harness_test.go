@@ -0,0 +1,155 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func uploadTo(t *testing.T, h *Harness, userID, sessionID string, body []byte) Metadata {
+	t.Helper()
+	url := fmt.Sprintf("%s/upload?user_id=%s&session_id=%s", h.URL, userID, sessionID)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(body))
+	if err != nil {
+		t.Errorf("Upload failed: %v", err)
+		return Metadata{}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", resp.StatusCode)
+	}
+	var meta Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		t.Errorf("Failed to decode response body: %v", err)
+	}
+	return meta
+}
+
+func TestHarness_ConcurrentUploadsAndRetrieval(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	const n = 20
+	var wg sync.WaitGroup
+	metas := make([]Metadata, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := SineWAV(float64(200+i*10), 100*time.Millisecond, 8000)
+			metas[i] = uploadTo(t, h, "user1", "sess1", body)
+			if want := fmt.Sprintf("%x", sha256.Sum256(body)); metas[i].Checksum != want {
+				t.Errorf("Expected checksum %v, but got %v", want, metas[i].Checksum)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	resp, err := http.Get(h.URL + "/chunks/" + metas[0].ChunkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Metadata
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if got.ChunkID != metas[0].ChunkID || got.Checksum != metas[0].Checksum {
+		t.Errorf("Expected chunk %v, but got %+v", metas[0].ChunkID, got)
+	}
+
+	resp, err = http.Get(h.URL + "/sessions/user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []Metadata
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed) != n {
+		t.Errorf("Expected %v chunks for user1, but got %v", n, len(listed))
+	}
+}
+
+func TestHarness_WebSocketSession(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < 3; i++ {
+		chunk := SineWAV(440, 50*time.Millisecond, 8000)
+		if err := conn.WriteMessage(websocket.BinaryMessage, chunk); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		var ack struct {
+			Ack      bool     `json:"ack"`
+			ChunkID  string   `json:"chunk_id"`
+			Metadata Metadata `json:"metadata"`
+		}
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if !ack.Ack || ack.ChunkID == "" {
+			t.Errorf("Expected an ack with a chunk_id, but got %+v", ack)
+		}
+		if want := fmt.Sprintf("%x", sha256.Sum256(chunk)); ack.Metadata.Checksum != want {
+			t.Errorf("Expected checksum %v, but got %v", want, ack.Metadata.Checksum)
+		}
+		if _, err := h.Store.Get(ack.ChunkID); err != nil {
+			t.Errorf("Expected chunk %v to be stored, but got %v", ack.ChunkID, err)
+		}
+	}
+}
+
+func TestHarness_ShutdownDrainsInFlightUploads(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+
+	body := SineWAV(440, 200*time.Millisecond, 8000)
+	pr, pw := io.Pipe()
+	done := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=sess1", "audio/wav", pr)
+		if err != nil {
+			t.Errorf("Upload failed: %v", err)
+		}
+		done <- resp
+	}()
+
+	pw.Write(body[:len(body)/2])
+	deadline := time.Now().Add(2 * time.Second)
+	for h.InFlight() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		h.Close()
+		close(closed)
+	}()
+
+	pw.Write(body[len(body)/2:])
+	pw.Close()
+
+	resp := <-done
+	<-closed
+	if resp == nil {
+		t.Fatal("Expected a response for the in-flight upload")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code 200, but got %v", resp.StatusCode)
+	}
+	if got := len(h.Store.ListByUser("user1")); got != 1 {
+		t.Errorf("Expected the drained upload to be stored, but got %v chunks", got)
+	}
+}
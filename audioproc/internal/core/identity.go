@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	errUnknownUser         = newKindError(ErrForbidden, "unknown user")
+	errIdentityUnavailable = newKindError(ErrBackendUnavailable, "identity provider unavailable")
+)
+
+// IdentityProvider decides whether a user ID was provisioned. ValidateUser
+// returns errUnknownUser for users that do not exist.
+type IdentityProvider interface {
+	ValidateUser(ctx context.Context, userID string) error
+}
+
+// newIdentityProvider returns nil, accepting every user, unless an allowlist
+// or identity URL is configured.
+func newIdentityProvider(cfg Config, egress *Egress) IdentityProvider {
+	switch {
+	case cfg.IdentityURL != "":
+		return NewHTTPIdentityProvider(cfg, egress)
+	case len(cfg.AllowedUsers) > 0:
+		return NewStaticIdentityProvider(cfg.AllowedUsers)
+	}
+	return nil
+}
+
+// validateUser is a no-op when no provider is configured.
+func validateUser(ctx context.Context, p IdentityProvider, userID string) error {
+	if p == nil {
+		return nil
+	}
+	return p.ValidateUser(ctx, userID)
+}
+
+// StaticIdentityProvider accepts a fixed set of user IDs.
+type StaticIdentityProvider map[string]bool
+
+// NewStaticIdentityProvider accepts exactly users.
+func NewStaticIdentityProvider(users []string) StaticIdentityProvider {
+	p := make(StaticIdentityProvider, len(users))
+	for _, u := range users {
+		p[u] = true
+	}
+	return p
+}
+
+// ValidateUser accepts users in the set.
+func (p StaticIdentityProvider) ValidateUser(ctx context.Context, userID string) error {
+	if !p[userID] {
+		return fmt.Errorf("%w: %q", errUnknownUser, userID)
+	}
+	return nil
+}
+
+// HTTPIdentityProvider asks an external service whether a user exists with
+// GET <URL>?user_id=<id>: 200 means yes, 404 means no, anything else is an
+// outage. Answers are cached for CacheTTL (known users) or NegativeTTL
+// (unknown users), and concurrent lookups of one user share a request.
+// Outages are never cached; FailOpen decides whether they admit the user.
+type HTTPIdentityProvider struct {
+	URL         string
+	Client      *http.Client
+	CacheTTL    time.Duration
+	NegativeTTL time.Duration
+	FailOpen    bool
+	Clock       Clock
+
+	mu    sync.Mutex
+	cache map[string]*identityEntry
+}
+
+type identityEntry struct {
+	ready   chan struct{}
+	err     error
+	expires time.Time
+}
+
+// NewHTTPIdentityProvider checks users against cfg's identity service,
+// calling it through egress.
+func NewHTTPIdentityProvider(cfg Config, egress *Egress) *HTTPIdentityProvider {
+	return &HTTPIdentityProvider{
+		URL:         cfg.IdentityURL,
+		Client:      egress.Client("identity", 5*time.Second, false),
+		CacheTTL:    cfg.IdentityCacheTTL,
+		NegativeTTL: cfg.IdentityNegativeTTL,
+		FailOpen:    cfg.IdentityFailOpen,
+		Clock:       realClock{},
+		cache:       make(map[string]*identityEntry),
+	}
+}
+
+// ValidateUser asks the identity service about userID, caching answers.
+func (p *HTTPIdentityProvider) ValidateUser(ctx context.Context, userID string) error {
+	err := p.lookup(ctx, userID)
+	if errors.Is(err, errIdentityUnavailable) && p.FailOpen {
+		return nil
+	}
+	return err
+}
+
+func (p *HTTPIdentityProvider) lookup(ctx context.Context, userID string) error {
+	p.mu.Lock()
+	if e, ok := p.cache[userID]; ok {
+		select {
+		case <-e.ready:
+			if p.Clock.Now().Before(e.expires) {
+				p.mu.Unlock()
+				return e.err
+			}
+		default:
+			p.mu.Unlock()
+			select {
+			case <-e.ready:
+				return e.err
+			case <-ctx.Done():
+				return fmt.Errorf("%w: %v", errIdentityUnavailable, ctx.Err())
+			}
+		}
+	}
+	e := &identityEntry{ready: make(chan struct{})}
+	p.cache[userID] = e
+	p.mu.Unlock()
+
+	err := p.fetch(ctx, userID)
+
+	p.mu.Lock()
+	e.err = err
+	switch {
+	case err == nil:
+		e.expires = p.Clock.Now().Add(p.CacheTTL)
+	case errors.Is(err, errUnknownUser):
+		e.expires = p.Clock.Now().Add(p.NegativeTTL)
+	default:
+		delete(p.cache, userID)
+	}
+	close(e.ready)
+	p.mu.Unlock()
+	return err
+}
+
+func (p *HTTPIdentityProvider) fetch(ctx context.Context, userID string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.URL+"?user_id="+url.QueryEscape(userID), nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errIdentityUnavailable, err)
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errIdentityUnavailable, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %q", errUnknownUser, userID)
+	}
+	return fmt.Errorf("%w: status %d", errIdentityUnavailable, resp.StatusCode)
+}
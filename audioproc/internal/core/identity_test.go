@@ -0,0 +1,130 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func identityServer(t *testing.T, known string, down *atomic.Bool) (*httptest.Server, *atomic.Int64) {
+	var hits atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		switch {
+		case down.Load():
+			w.WriteHeader(http.StatusBadGateway)
+		case r.URL.Query().Get("user_id") == known:
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestUploadRejectsUnknownUsers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowedUsers = []string{"user1"}
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	for user, want := range map[string]int{"user1": http.StatusOK, "usr1": http.StatusForbidden} {
+		resp, err := http.Post(h.URL+"/upload?user_id="+user+"&session_id=sess1", "audio/wav", strings.NewReader("audio"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected %v for %v, but got %v", want, user, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(h.URL + "/ws?user_id=usr1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected the WS handshake to refuse an unknown user, but got %v", resp.StatusCode)
+	}
+}
+
+func TestHTTPIdentityProvider_Cache(t *testing.T) {
+	var down atomic.Bool
+	srv, hits := identityServer(t, "alice", &down)
+	cfg := DefaultConfig()
+	cfg.IdentityURL = srv.URL
+	p := NewHTTPIdentityProvider(cfg, NewEgress(cfg))
+	clock := NewFakeClock(time.Now())
+	p.Clock = clock
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.ValidateUser(ctx, "alice"); err != nil {
+				t.Errorf("Expected alice to be allowed, but got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := hits.Load(); n != 1 {
+		t.Errorf("Expected concurrent lookups to share one request, but got %v", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := p.ValidateUser(ctx, "mallory"); !errors.Is(err, errUnknownUser) {
+			t.Errorf("Expected errUnknownUser, but got %v", err)
+		}
+	}
+	if n := hits.Load(); n != 2 {
+		t.Errorf("Expected the denial to be cached, but got %v requests", n)
+	}
+
+	clock.Advance(cfg.IdentityNegativeTTL + time.Second)
+	p.ValidateUser(ctx, "mallory")
+	p.ValidateUser(ctx, "alice")
+	if n := hits.Load(); n != 3 {
+		t.Errorf("Expected only the expired denial to be refetched, but got %v requests", n)
+	}
+}
+
+func TestHTTPIdentityProvider_Outage(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	srv, hits := identityServer(t, "alice", &down)
+
+	cfg := DefaultConfig()
+	cfg.IdentityURL = srv.URL
+	for _, failOpen := range []bool{true, false} {
+		cfg.IdentityFailOpen = failOpen
+		err := NewHTTPIdentityProvider(cfg, NewEgress(cfg)).ValidateUser(context.Background(), "mallory")
+		if failOpen && err != nil {
+			t.Errorf("Expected fail-open to admit during an outage, but got %v", err)
+		}
+		if !failOpen && !errors.Is(err, errIdentityUnavailable) {
+			t.Errorf("Expected fail-closed to report the outage, but got %v", err)
+		}
+	}
+
+	p := NewHTTPIdentityProvider(cfg, NewEgress(cfg))
+	p.ValidateUser(context.Background(), "alice")
+	down.Store(false)
+	before := hits.Load()
+	if err := p.ValidateUser(context.Background(), "alice"); err != nil {
+		t.Errorf("Expected alice to be allowed once the provider recovers, but got %v", err)
+	}
+	if hits.Load() != before+1 {
+		t.Errorf("Expected the outage not to be cached")
+	}
+}
@@ -0,0 +1,324 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// indexRebuildBatch is how many records a rebuild indexes per lock hold, so
+// reads and writes keep going while it runs.
+const indexRebuildBatch = 500
+
+var indexStats = expvar.NewMap("store_indexes")
+
+// indexes map user, session and checksum to the IDs of the chunks that
+// have them, deleted chunks included, and index their transcripts for
+// search, and tags their embedded tags. ordered holds each session's
+// visible chunks oldest first, so its newest is the last.
+type indexes struct {
+	byUser     map[string]map[string]bool
+	bySession  map[string]map[string]bool
+	byChecksum map[string]map[string]bool
+	ordered    map[string][]sessionHead
+	text       *textIndex
+	tags       *textIndex
+}
+
+func newIndexes() *indexes {
+	return &indexes{
+		byUser:     make(map[string]map[string]bool),
+		bySession:  make(map[string]map[string]bool),
+		byChecksum: make(map[string]map[string]bool),
+		ordered:    make(map[string][]sessionHead),
+		text:       newTextIndex(searchFields[scopeTranscript]),
+		tags:       newTextIndex(searchFields[scopeTags]),
+	}
+}
+
+func (x *indexes) each(m Metadata, fn func(index map[string]map[string]bool, key string)) {
+	fn(x.byUser, m.UserID)
+	fn(x.bySession, sessionKey(m.UserID, m.SessionID))
+	if m.Checksum != "" {
+		fn(x.byChecksum, m.Checksum)
+	}
+}
+
+func (x *indexes) add(m Metadata) {
+	x.each(m, func(index map[string]map[string]bool, key string) {
+		if index[key] == nil {
+			index[key] = make(map[string]bool)
+		}
+		index[key][m.ChunkID] = true
+	})
+	x.addOrdered(m)
+	x.text.add(m)
+	x.tags.add(m)
+}
+
+// remove drops m from the indexes.
+func (x *indexes) remove(m Metadata) {
+	x.each(m, func(index map[string]map[string]bool, key string) {
+		delete(index[key], m.ChunkID)
+		if len(index[key]) == 0 {
+			delete(index, key)
+		}
+	})
+	x.removeOrdered(m)
+	x.text.remove(m)
+	x.tags.remove(m)
+}
+
+// Index rebuild states reported by IndexStatus.
+const (
+	indexesOK          = "ok"
+	indexesStale       = "stale"
+	indexesRebuilding  = "rebuilding"
+	indexesInterrupted = "interrupted"
+)
+
+// IndexStatus describes the secondary indexes. While they are not ok,
+// lookups that would use them scan every record instead.
+type IndexStatus struct {
+	State string `json:"state"`
+	Done  int    `json:"done,omitempty"`
+	Total int    `json:"total,omitempty"`
+}
+
+// indexState is the MemoryStore's index bookkeeping. next collects a
+// rebuild in progress; writes update it alongside live.
+type indexState struct {
+	live    *indexes
+	next    *indexes
+	trusted bool
+	status  IndexStatus
+}
+
+func (s *MemoryStore) indexLocked(m Metadata) {
+	s.index.live.add(m)
+	if s.index.next != nil {
+		s.index.next.add(m)
+	}
+}
+
+// reheadLocked updates m's session order after m was moved to or out of
+// the trash, which leaves the other indexes as they are.
+func (s *MemoryStore) reheadLocked(m Metadata) {
+	for _, x := range []*indexes{s.index.live, s.index.next} {
+		if x == nil {
+			continue
+		}
+		if m.DeletedAt != nil {
+			x.removeOrdered(m)
+		} else {
+			x.addOrdered(m)
+		}
+	}
+}
+
+func (s *MemoryStore) unindexLocked(m Metadata) {
+	s.index.live.remove(m)
+	if s.index.next != nil {
+		s.index.next.remove(m)
+	}
+}
+
+// indexedLocked returns the chunks an index lists under key, or ok=false
+// when the indexes are not trusted and the caller must scan.
+func (s *MemoryStore) indexedLocked(index func(*indexes) map[string]map[string]bool, key string) (result []Metadata, ok bool) {
+	if !s.index.trusted {
+		return nil, false
+	}
+	for id := range index(s.index.live)[key] {
+		if m, ok := s.lookupLocked(id); ok {
+			result = append(result, m)
+		}
+	}
+	return result, true
+}
+
+// ListBySession returns the visible chunks of one session.
+func (s *MemoryStore) ListBySession(userID, sessionID string) []Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	match := func(m Metadata) bool { return m.UserID == userID && m.SessionID == sessionID }
+	chunks, ok := s.indexedLocked(func(x *indexes) map[string]map[string]bool { return x.bySession }, sessionKey(userID, sessionID))
+	if !ok {
+		return s.listLocked(match)
+	}
+	return visible(chunks, match)
+}
+
+// LastSessionRevision returns the highest revision among a session's
+// visible chunks, or 0 for a session without any.
+func (s *MemoryStore) LastSessionRevision(userID, sessionID string) int {
+	rev := 0
+	for _, m := range s.ListBySession(userID, sessionID) {
+		rev = max(rev, m.SessionRevision)
+	}
+	return rev
+}
+
+// FindByChecksum returns the visible chunks whose audio has this checksum.
+func (s *MemoryStore) FindByChecksum(checksum string) []Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	match := func(m Metadata) bool { return m.Checksum == checksum }
+	chunks, ok := s.indexedLocked(func(x *indexes) map[string]map[string]bool { return x.byChecksum }, checksum)
+	if !ok {
+		return s.listLocked(match)
+	}
+	return visible(chunks, match)
+}
+
+func visible(chunks []Metadata, match func(Metadata) bool) []Metadata {
+	var result []Metadata
+	for _, m := range chunks {
+		if m.DeletedAt == nil && match(m) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// CheckIndexes compares the indexes against the records: every record must
+// be listed under its own keys, and each index must hold as many entries
+// as there are records for it. On drift the indexes stop being used until
+// RebuildIndexes repairs them.
+func (s *MemoryStore) CheckIndexes() (ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	live := s.index.live
+	ok = true
+	records, withChecksum, withText := 0, 0, 0
+	s.eachLocked(func(m Metadata) {
+		records++
+		if m.Checksum != "" {
+			withChecksum++
+		}
+		if _, indexed := live.text.lengths[m.ChunkID]; indexed != (len(tokenize(m.Transcript)) > 0) {
+			ok = false
+		} else if indexed {
+			withText++
+		}
+		live.each(m, func(index map[string]map[string]bool, key string) {
+			if !index[key][m.ChunkID] {
+				ok = false
+			}
+		})
+	})
+	if entries(live.byUser) != records || entries(live.bySession) != records || entries(live.byChecksum) != withChecksum || len(live.text.lengths) != withText {
+		ok = false
+	}
+	if !ok {
+		s.index.trusted = false
+		s.index.status = IndexStatus{State: indexesStale}
+		indexStats.Add("drift_detected", 1)
+	}
+	return ok
+}
+
+func entries(index map[string]map[string]bool) int {
+	n := 0
+	for _, ids := range index {
+		n += len(ids)
+	}
+	return n
+}
+
+// RebuildIndexes rebuilds the indexes from the records a batch at a time.
+// If ctx ends first the partial rebuild is dropped and lookups keep
+// scanning; running it again starts over.
+func (s *MemoryStore) RebuildIndexes(ctx context.Context) error {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.metadata)+len(s.legacy))
+	for id := range s.metadata {
+		ids = append(ids, id)
+	}
+	for id := range s.legacy {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	s.index.next = newIndexes()
+	s.index.status = IndexStatus{State: indexesRebuilding, Total: len(ids)}
+	s.mu.Unlock()
+	indexStats.Add("rebuilds_started", 1)
+	start := time.Now()
+
+	for len(ids) > 0 {
+		if err := ctx.Err(); err != nil {
+			s.mu.Lock()
+			s.index.next = nil
+			s.index.status.State = indexesInterrupted
+			s.mu.Unlock()
+			indexStats.Add("rebuilds_interrupted", 1)
+			return err
+		}
+		batch := ids[:min(indexRebuildBatch, len(ids))]
+		ids = ids[len(batch):]
+		s.mu.Lock()
+		for _, id := range batch {
+			if m, ok := s.lookupLocked(id); ok {
+				s.index.next.add(m)
+			}
+		}
+		s.index.status.Done += len(batch)
+		s.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	s.index.live, s.index.next = s.index.next, nil
+	s.index.trusted = true
+	s.index.status = IndexStatus{State: indexesOK}
+	s.mu.Unlock()
+	indexStats.Add("rebuilds_completed", 1)
+	log.Printf("Rebuilt store indexes in %s", time.Since(start))
+	return nil
+}
+
+// IndexStatus reports whether the indexes are trusted or rebuilding.
+func (s *MemoryStore) IndexStatus() IndexStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.index.status
+}
+
+// RunIndexCheck checks the indexes once at startup and rebuilds them if
+// they have drifted. It runs as a non-critical warm-up, so reads are served
+// meanwhile. The store is durable only as long as the process, so an
+// interrupted rebuild simply runs again on next start.
+func RunIndexCheck(ctx context.Context, store *MemoryStore) error {
+	if store.CheckIndexes() {
+		return nil
+	}
+	log.Println("Store indexes are out of date; rebuilding in the background")
+	if err := store.RebuildIndexes(ctx); err != nil {
+		return fmt.Errorf("index rebuild interrupted: %w", err)
+	}
+	return nil
+}
+
+// handleReadyz reports readiness with details about the store, the
+// requests in flight, the pipeline stages, the warm-ups and read-only
+// mode. It answers 503 until the critical warm-ups have finished. Reads
+// are served while indexes rebuild, uploads while stages are off, and
+// reads in read-only mode, so none of those is a failure.
+func handleReadyz(store *MemoryStore, limits *ConcurrencyLimiter, stages *StageControl, warmups *Warmups, ro *ReadOnly) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, code, statuses := "ready", http.StatusOK, []WarmupStatus{}
+		if warmups != nil {
+			statuses = warmups.Status()
+			if !warmups.Ready() {
+				status, code = "warming_up", http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{"status": status, "indexes": store.IndexStatus(), "in_flight": limits.InFlight(), "stages": stages.States(), "warmups": statuses, "read_only": ro.Enabled()})
+	}
+}
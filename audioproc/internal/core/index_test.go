@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIndexesTrackWrites(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", SessionID: "s1", Checksum: "aa"})
+	store.Save(Metadata{ChunkID: "b", UserID: "user1", SessionID: "s1", Checksum: "bb"})
+	store.Save(Metadata{ChunkID: "b", UserID: "user2", SessionID: "s2", Checksum: "aa"})
+	store.PutRaw("old", json.RawMessage(`{"chunk_id":"old","user_id":"user1","session_id":"s1","timestamp":"2023-05-01T10:00:00Z"}`))
+	store.Delete("a")
+	store.Retention = 0
+	store.PurgeDeleted()
+	store.WithTx(func(tx Store) error {
+		tx.Save(Metadata{ChunkID: "c", UserID: "user1", SessionID: "s1"})
+		return errors.New("rollback")
+	})
+
+	if !store.CheckIndexes() {
+		t.Fatalf("Expected the indexes to match the records")
+	}
+	if got := store.ListBySession("user1", "s1"); len(got) != 1 || got[0].ChunkID != "old" {
+		t.Errorf("Expected only the legacy record in s1, but got %+v", got)
+	}
+	if got := store.FindByChecksum("aa"); len(got) != 1 || got[0].ChunkID != "b" {
+		t.Errorf("Expected b to be found by its new checksum, but got %+v", got)
+	}
+}
+
+func TestIndexDriftIsDetectedAndRepaired(t *testing.T) {
+	store := NewMemoryStore()
+	for _, id := range []string{"a", "b", "c"} {
+		store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "s1", Checksum: "sum-" + id})
+	}
+	// Lose an entry and leave a stale one behind, as an old export would.
+	delete(store.index.live.byUser["user1"], "b")
+	store.index.live.byChecksum["sum-x"] = map[string]bool{"x": true}
+	if n := len(store.ListByUser("user1")); n != 2 {
+		t.Fatalf("Expected the corrupt index to hide a chunk, but got %d", n)
+	}
+
+	if store.CheckIndexes() {
+		t.Fatalf("Expected drift to be detected")
+	}
+	if n := len(store.ListByUser("user1")); n != 3 {
+		t.Errorf("Expected reads to scan while the indexes are stale, but got %d", n)
+	}
+	rr := httptest.NewRecorder()
+	handleReadyz(store, NewConcurrencyLimiter(DefaultConfig()), NewStageControl(), nil, nil)(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var ready struct {
+		Indexes IndexStatus `json:"indexes"`
+	}
+	json.NewDecoder(rr.Body).Decode(&ready)
+	if rr.Code != 200 || ready.Indexes.State != indexesStale {
+		t.Errorf("Expected /readyz to report stale indexes, but got %v %+v", rr.Code, ready)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.RebuildIndexes(ctx); err == nil || store.IndexStatus().State != indexesInterrupted {
+		t.Errorf("Expected a cancelled rebuild to stop, but got %v %+v", err, store.IndexStatus())
+	}
+	if n := len(store.ListByUser("user1")); n != 3 {
+		t.Errorf("Expected reads to keep scanning after an interrupted rebuild, but got %d", n)
+	}
+
+	if err := store.RebuildIndexes(context.Background()); err != nil {
+		t.Fatalf("RebuildIndexes error: %v", err)
+	}
+	if !store.CheckIndexes() || store.IndexStatus().State != indexesOK {
+		t.Errorf("Expected the rebuild to repair the indexes, but got %+v", store.IndexStatus())
+	}
+	if n := len(store.ListByUser("user1")); n != 3 || len(store.FindByChecksum("sum-x")) != 0 {
+		t.Errorf("Expected indexed reads to be correct again")
+	}
+}
+
+func TestRebuildKeepsConcurrentWrites(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 3*indexRebuildBatch; i++ {
+		store.Save(Metadata{ChunkID: fmt.Sprintf("c%d", i), UserID: "user1"})
+	}
+	store.CheckIndexes()
+	store.index.trusted = false
+	done := make(chan error)
+	go func() { done <- store.RebuildIndexes(context.Background()) }()
+	store.Save(Metadata{ChunkID: "late", UserID: "user2", SessionID: "s"})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := store.ListBySession("user2", "s"); len(got) != 1 || !store.CheckIndexes() {
+		t.Errorf("Expected a write during the rebuild to be indexed, but got %+v", got)
+	}
+}
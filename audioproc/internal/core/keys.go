@@ -0,0 +1,280 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var errKeyNotFound = newKindError(ErrNotFound, "API key not found")
+
+// Key scopes. A key with the read scope only may not change anything; a key
+// without scopes may do everything.
+const (
+	scopeRead  = "read"
+	scopeWrite = "write"
+)
+
+// APIKey is a key minted at runtime. Only the SHA-256 of the key is kept;
+// the plaintext is returned once, when the key is created.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Hash      string     `json:"hash"`
+	UserID    string     `json:"user_id"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// validAt reports whether the key may be used at now.
+func (k APIKey) validAt(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// allows reports whether the key's scopes permit a request with method.
+func (k APIKey) allows(method string) bool {
+	if len(k.Scopes) == 0 || slices.Contains(k.Scopes, scopeWrite) {
+		return true
+	}
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaveAPIKey stores key under its hash.
+func (s *MemoryStore) SaveAPIKey(key APIKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKeys[key.Hash] = key
+	s.logEntryLocked(walAPIKeys, key.Hash, key)
+}
+
+// APIKeyByHash looks up a key by the hash of its secret.
+func (s *MemoryStore) APIKeyByHash(hash string) (APIKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.apiKeys[hash]
+	return key, ok
+}
+
+// APIKeys lists every stored key, revoked ones included, oldest first.
+func (s *MemoryStore) APIKeys() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0, len(s.apiKeys))
+	for _, k := range s.apiKeys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// RevokeAPIKey marks the key with that ID revoked at the given time.
+func (s *MemoryStore) RevokeAPIKey(id string, at time.Time) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, k := range s.apiKeys {
+		if k.ID == id {
+			if k.RevokedAt == nil {
+				k.RevokedAt = &at
+				s.apiKeys[hash] = k
+				s.logEntryLocked(walAPIKeys, hash, k)
+			}
+			return k, nil
+		}
+	}
+	return APIKey{}, fmt.Errorf("%w: %s", errKeyNotFound, id)
+}
+
+// KeyRing authenticates requests against the configured static keys and the
+// keys minted through /admin/keys. Stored keys are cached for ttl, so a key
+// revoked or expired by another process stops working within ttl; changes
+// made through this KeyRing take effect immediately.
+type KeyRing struct {
+	static map[string]string
+	store  *MemoryStore
+	clock  Clock
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedKey // by hash
+}
+
+type cachedKey struct {
+	key     APIKey
+	fetched time.Time
+}
+
+// NewKeyRing mints and checks API keys kept in store.
+func NewKeyRing(cfg Config, store *MemoryStore) *KeyRing {
+	return &KeyRing{
+		static: cfg.APIKeys,
+		store:  store,
+		clock:  realClock{},
+		ttl:    cfg.APIKeyCacheTTL,
+		cache:  make(map[string]cachedKey),
+	}
+}
+
+// enabled reports whether requests need a key at all: authentication stays
+// off until a key is configured or minted.
+func (k *KeyRing) enabled() bool {
+	if len(k.static) > 0 {
+		return true
+	}
+	k.store.mu.RLock()
+	defer k.store.mu.RUnlock()
+	return len(k.store.apiKeys) > 0
+}
+
+// Authenticate returns the key a request presents. Static keys carry no
+// scopes. With authentication off every request passes with a zero key.
+func (k *KeyRing) Authenticate(r *http.Request) (APIKey, bool) {
+	if !k.enabled() {
+		return APIKey{}, true
+	}
+	plain := apiKeyFromRequest(r)
+	if plain == "" {
+		return APIKey{}, false
+	}
+	if user, ok := k.static[plain]; ok {
+		return APIKey{UserID: user}, true
+	}
+	key, ok := k.lookup(hashAPIKey(plain))
+	return key, ok && key.validAt(k.clock.Now())
+}
+
+func (k *KeyRing) lookup(hash string) (APIKey, bool) {
+	now := k.clock.Now()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if c, ok := k.cache[hash]; ok && now.Sub(c.fetched) < k.ttl {
+		return c.key, true
+	}
+	key, ok := k.store.APIKeyByHash(hash)
+	if !ok {
+		// Unknown keys are not cached, so guessing cannot grow the cache.
+		delete(k.cache, hash)
+		return APIKey{}, false
+	}
+	k.cache[hash] = cachedKey{key: key, fetched: now}
+	return key, true
+}
+
+func (k *KeyRing) invalidate() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	clear(k.cache)
+}
+
+// Mint stores a new key for userID and returns it with its plaintext. A
+// zero ttl never expires.
+func (k *KeyRing) Mint(userID string, scopes []string, ttl time.Duration) (APIKey, string) {
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	plain := "ak_" + base64.RawURLEncoding.EncodeToString(raw)
+	now := k.clock.Now().UTC().Truncate(time.Second)
+	key := APIKey{
+		ID:        uuid.New().String(),
+		Hash:      hashAPIKey(plain),
+		UserID:    userID,
+		Scopes:    scopes,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.ExpiresAt = &expires
+	}
+	k.store.SaveAPIKey(key)
+	k.invalidate()
+	return key, plain
+}
+
+// Revoke disables the key with the given ID.
+func (k *KeyRing) Revoke(id string) (APIKey, error) {
+	key, err := k.store.RevokeAPIKey(id, k.clock.Now().UTC())
+	k.invalidate()
+	return key, err
+}
+
+// mintedKey is the one response that carries a key's plaintext.
+type mintedKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type mintKeyRequest struct {
+	UserID           string   `json:"user_id"`
+	Scopes           []string `json:"scopes,omitempty"`
+	ExpiresInSeconds int64    `json:"expires_in_seconds,omitempty"`
+}
+
+func handleMintKey(k *KeyRing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mintKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_key", "body must be {\"user_id\": ..., \"scopes\": [...], \"expires_in_seconds\": n}")
+			return
+		}
+		verr := &ValidationError{}
+		if req.UserID == "" {
+			verr.Fields = append(verr.Fields, FieldError{Field: "user_id", Code: "invalid_key", Message: "is required"})
+		}
+		for _, s := range req.Scopes {
+			if s != scopeRead && s != scopeWrite {
+				verr.Fields = append(verr.Fields, FieldError{Field: "scopes", Code: "invalid_key", Message: fmt.Sprintf("%q is not read or write", s)})
+			}
+		}
+		if req.ExpiresInSeconds < 0 {
+			verr.Fields = append(verr.Fields, FieldError{Field: "expires_in_seconds", Code: "invalid_key", Message: "must not be negative"})
+		}
+		if len(verr.Fields) > 0 {
+			writeError(w, verr)
+			return
+		}
+		key, plain := k.Mint(req.UserID, req.Scopes, time.Duration(req.ExpiresInSeconds)*time.Second)
+		k.store.RecordAudit(AuditEvent{Actor: requestActor(r), Action: "key.create", UserID: key.UserID})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(mintedKey{key, plain})
+	}
+}
+
+func handleListKeys(k *KeyRing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(k.store.APIKeys())
+	}
+}
+
+func handleRevokeKey(k *KeyRing) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := k.Revoke(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		k.store.RecordAudit(AuditEvent{Actor: requestActor(r), Action: "key.revoke", UserID: key.UserID})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func keysConfig() Config {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.APIKeys = map[string]string{"ops-key": "ops"}
+	return cfg
+}
+
+func adminKeyRequest(t *testing.T, h *Harness, method, path, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, h.URL+path, strings.NewReader(body))
+	req.Header.Set("X-Admin-Token", "admin")
+	req.Header.Set("Authorization", "Bearer ops-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error: %v", method, path, err)
+	}
+	return resp
+}
+
+func mintKey(t *testing.T, h *Harness, body string) mintedKey {
+	t.Helper()
+	resp := adminKeyRequest(t, h, "POST", "/admin/keys", body)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 minting a key, but got %v", resp.StatusCode)
+	}
+	var key mintedKey
+	json.NewDecoder(resp.Body).Decode(&key)
+	return key
+}
+
+func getWithKey(t *testing.T, h *Harness, path, key string) int {
+	t.Helper()
+	req, _ := http.NewRequest("GET", h.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAPIKeyMintUseRevoke(t *testing.T) {
+	h := NewHarness(keysConfig())
+	defer h.Close()
+
+	key := mintKey(t, h, `{"user_id": "user1", "scopes": ["read", "write"]}`)
+	if !strings.HasPrefix(key.Key, "ak_") || key.Hash != hashAPIKey(key.Key) {
+		t.Fatalf("Expected a plaintext key and its hash, but got %+v", key)
+	}
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusOK {
+		t.Fatalf("Expected the minted key to work, but got %v", status)
+	}
+
+	resp := adminKeyRequest(t, h, "GET", "/admin/keys", "")
+	var listed []APIKey
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed) != 1 || listed[0].ID != key.ID || listed[0].UserID != "user1" {
+		t.Fatalf("Expected the key in the listing, but got %+v", listed)
+	}
+
+	resp = adminKeyRequest(t, h, "DELETE", "/admin/keys/"+key.ID, "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected 204 revoking the key, but got %v", resp.StatusCode)
+	}
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be refused, but got %v", status)
+	}
+	resp = adminKeyRequest(t, h, "DELETE", "/admin/keys/missing", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking an unknown key, but got %v", resp.StatusCode)
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	h := NewHarness(keysConfig())
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Keys.clock = clock
+
+	key := mintKey(t, h, `{"user_id": "user1", "expires_in_seconds": 60}`)
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusOK {
+		t.Fatalf("Expected a fresh key to work, but got %v", status)
+	}
+	clock.Advance(time.Minute)
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusUnauthorized {
+		t.Errorf("Expected an expired key to be refused, but got %v", status)
+	}
+}
+
+// A key revoked behind the KeyRing's back, as by another process sharing the
+// store, keeps working only until its cache entry is stale.
+func TestAPIKeyRevocationWithinCacheTTL(t *testing.T) {
+	h := NewHarness(keysConfig())
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Keys.clock = clock
+
+	key := mintKey(t, h, `{"user_id": "user1"}`)
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusOK {
+		t.Fatalf("Expected the minted key to work, but got %v", status)
+	}
+	h.Store.RevokeAPIKey(key.ID, clock.Now())
+	clock.Advance(h.Config.APIKeyCacheTTL)
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusUnauthorized {
+		t.Errorf("Expected the revocation to apply after the cache TTL, but got %v", status)
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	h := NewHarness(keysConfig())
+	defer h.Close()
+
+	key := mintKey(t, h, `{"user_id": "user1", "scopes": ["read"]}`)
+	if status := getWithKey(t, h, "/sessions/user1", key.Key); status != http.StatusOK {
+		t.Errorf("Expected a read-only key to read, but got %v", status)
+	}
+	req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=user1&session_id=s1", strings.NewReader("audio"))
+	req.Header.Set("Authorization", "Bearer "+key.Key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a read-only key to be refused an upload, but got %v", resp.StatusCode)
+	}
+
+	resp = adminKeyRequest(t, h, "POST", "/admin/keys", `{"scopes": ["everything"]}`)
+	var body struct {
+		Fields []FieldError `json:"fields"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity || len(body.Fields) != 2 {
+		t.Errorf("Expected user_id and scopes to be reported, but got %v %+v", resp.StatusCode, body.Fields)
+	}
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sessionHead is a chunk in its session's order: its ID and the time it
+// is ordered by. A session's head is its newest visible chunk.
+type sessionHead struct {
+	id string
+	at time.Time
+}
+
+// chunkOrderTime is when a chunk counts as recorded for finding a
+// session's newest: the client's recorded_at where it sent one, otherwise
+// the upload time.
+func chunkOrderTime(m Metadata) time.Time {
+	if m.RecordedAt != nil {
+		return *m.RecordedAt
+	}
+	return m.Timestamp
+}
+
+// newerHead orders heads by time, then by chunk ID so ties are stable.
+func newerHead(a, b sessionHead) bool {
+	if !a.at.Equal(b.at) {
+		return a.at.After(b.at)
+	}
+	return a.id > b.id
+}
+
+// compareHeads orders heads oldest first, as the ordered index keeps them.
+func compareHeads(a, b sessionHead) int {
+	switch {
+	case newerHead(b, a):
+		return -1
+	case newerHead(a, b):
+		return 1
+	}
+	return 0
+}
+
+// addOrdered inserts m into its session's ordered chunks if it is visible.
+// Adding a chunk already there, as a rebuild may, changes nothing.
+func (x *indexes) addOrdered(m Metadata) {
+	if m.DeletedAt != nil {
+		return
+	}
+	key := sessionKey(m.UserID, m.SessionID)
+	head := sessionHead{id: m.ChunkID, at: chunkOrderTime(m)}
+	if i, found := slices.BinarySearchFunc(x.ordered[key], head, compareHeads); !found {
+		x.ordered[key] = slices.Insert(x.ordered[key], i, head)
+	}
+}
+
+// removeOrdered drops m from its session's ordered chunks. m must order as
+// it did when it was added, which trashing and restoring leave alone.
+func (x *indexes) removeOrdered(m Metadata) {
+	key := sessionKey(m.UserID, m.SessionID)
+	head := sessionHead{id: m.ChunkID, at: chunkOrderTime(m)}
+	if i, found := slices.BinarySearchFunc(x.ordered[key], head, compareHeads); found {
+		x.ordered[key] = slices.Delete(x.ordered[key], i, i+1)
+		if len(x.ordered[key]) == 0 {
+			delete(x.ordered, key)
+		}
+	}
+}
+
+// head returns the newest visible chunk of the session at key.
+func (x *indexes) head(key string) (sessionHead, bool) {
+	chunks := x.ordered[key]
+	if len(chunks) == 0 {
+		return sessionHead{}, false
+	}
+	return chunks[len(chunks)-1], true
+}
+
+// LatestInSession returns a session's newest visible chunk, by recorded_at
+// where clients sent it. It reads the session's head from the indexes,
+// scanning only while they are not trusted.
+func (s *MemoryStore) LatestInSession(userID, sessionID string) (Metadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.index.trusted {
+		if head, ok := s.index.live.head(sessionKey(userID, sessionID)); ok {
+			if m, ok := s.lookupLocked(head.id); ok {
+				return m, nil
+			}
+		}
+		return Metadata{}, fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	var latest Metadata
+	var head sessionHead
+	for _, m := range s.listLocked(func(m Metadata) bool { return m.UserID == userID && m.SessionID == sessionID }) {
+		if h := (sessionHead{id: m.ChunkID, at: chunkOrderTime(m)}); head.id == "" || newerHead(h, head) {
+			latest, head = m, h
+		}
+	}
+	if head.id == "" {
+		return Metadata{}, fmt.Errorf("session %s: %w", sessionID, ErrNotFound)
+	}
+	return latest, nil
+}
+
+// transcriptETag identifies a chunk's transcript text, so pollers are told
+// when either the newest chunk or its transcript changes.
+func transcriptETag(m Metadata) string {
+	sum := sha256.Sum256([]byte(m.Transcript))
+	return `"` + m.ChunkID + "-" + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag.
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func handleLatestChunk(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := checkUserAccess(cfg, r, vars["user_id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		m, err := store.LatestInSession(vars["user_id"], vars["session_id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m)
+	}
+}
+
+// latestTranscript is the body of GET .../latest/transcript.
+type latestTranscript struct {
+	ChunkID string `json:"chunk_id"`
+	Text    string `json:"text"`
+}
+
+// handleLatestTranscript serves the text of a session's newest chunk with
+// an ETag, answering 304 when If-None-Match already has it.
+func handleLatestTranscript(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := checkUserAccess(cfg, r, vars["user_id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		m, err := store.LatestInSession(vars["user_id"], vars["session_id"])
+		if err == nil {
+			m, err = withFullTranscript(store, m)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		etag := transcriptETag(m)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(latestTranscript{ChunkID: m.ChunkID, Text: m.Transcript})
+	}
+}
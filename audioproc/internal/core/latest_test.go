@@ -0,0 +1,124 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func getLatest(t *testing.T, h *Harness, path, ifNoneMatch string) (int, string, latestTranscript) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", h.URL+path, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s error: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var body latestTranscript
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, resp.Header.Get("ETag"), body
+}
+
+func TestLatestChunkFollowsUploads(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	uploadTo(t, h, "user1", "s1", wav)
+	uploadTo(t, h, "user1", "s2", wav)
+	second := uploadTo(t, h, "user1", "s1", wav)
+	uploadTo(t, h, "user1", "s2", wav)
+
+	if _, _, latest := getLatest(t, h, "/sessions/user1/s1/latest", ""); latest.ChunkID != second.ChunkID {
+		t.Fatalf("Expected %s as the latest, but got %+v", second.ChunkID, latest)
+	}
+	status, etag, body := getLatest(t, h, "/sessions/user1/s1/latest/transcript", "")
+	if status != http.StatusOK || etag == "" || body.ChunkID != second.ChunkID || body.Text != "Hello World" {
+		t.Fatalf("Expected the transcript with an ETag, but got %v %q %+v", status, etag, body)
+	}
+	if status, _, _ := getLatest(t, h, "/sessions/user1/s1/latest/transcript", etag); status != http.StatusNotModified {
+		t.Errorf("Expected 304 while nothing changed, but got %v", status)
+	}
+
+	third := uploadTo(t, h, "user1", "s1", wav)
+	status, newTag, body := getLatest(t, h, "/sessions/user1/s1/latest/transcript", etag)
+	if status != http.StatusOK || newTag == etag || body.ChunkID != third.ChunkID {
+		t.Errorf("Expected the new chunk after an upload, but got %v %q %+v", status, newTag, body)
+	}
+
+	// A chunk recorded earlier but uploaded later does not take over.
+	recorded := time.Now().Add(-time.Hour)
+	h.Store.Save(Metadata{ChunkID: "late", UserID: "user1", SessionID: "s1", Timestamp: time.Now(), RecordedAt: &recorded})
+	if m, _ := h.Store.LatestInSession("user1", "s1"); m.ChunkID != third.ChunkID {
+		t.Errorf("Expected %s to stay latest, but got %s", third.ChunkID, m.ChunkID)
+	}
+
+	// Deleting the head falls back to the next newest.
+	if err := h.Store.Delete(third.ChunkID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, latest := getLatest(t, h, "/sessions/user1/s1/latest", ""); latest.ChunkID != second.ChunkID {
+		t.Errorf("Expected %s after the delete, but got %s", second.ChunkID, latest.ChunkID)
+	}
+	if _, err := h.Store.Restore(third.ChunkID); err != nil {
+		t.Fatal(err)
+	}
+	if m, _ := h.Store.LatestInSession("user1", "s1"); m.ChunkID != third.ChunkID {
+		t.Errorf("Expected %s back after the restore, but got %s", third.ChunkID, m.ChunkID)
+	}
+	if status, _, _ := getLatest(t, h, "/sessions/user1/none/latest", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an empty session, but got %v", status)
+	}
+}
+
+func TestLatestChunkWithoutIndexes(t *testing.T) {
+	store := NewMemoryStore()
+	base := time.Now()
+	for i, id := range []string{"a", "c", "b"} {
+		store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "s1", Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	indexed, _ := store.LatestInSession("user1", "s1")
+	store.mu.Lock()
+	store.index.trusted = false
+	store.mu.Unlock()
+	scanned, _ := store.LatestInSession("user1", "s1")
+	if indexed.ChunkID != "b" || scanned.ChunkID != "b" {
+		t.Errorf("Expected b from both the head and a scan, but got %s and %s", indexed.ChunkID, scanned.ChunkID)
+	}
+}
+
+func TestLatestChunkOrderedIndex(t *testing.T) {
+	store := NewMemoryStore()
+	base := time.Now()
+	for i := range 5 {
+		store.Save(Metadata{ChunkID: fmt.Sprintf("c%d", i), UserID: "user1", SessionID: "s1", Timestamp: base.Add(time.Duration(i) * time.Second)})
+	}
+	// A rebuild can index a chunk a write has already indexed.
+	store.mu.Lock()
+	m, _ := store.lookupLocked("c4")
+	store.index.live.add(m)
+	store.mu.Unlock()
+
+	for _, id := range []string{"c4", "c2", "c3"} {
+		if err := store.Delete(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m, _ := store.LatestInSession("user1", "s1"); m.ChunkID != "c1" {
+		t.Errorf("Expected c1 once the newer chunks are trashed, but got %s", m.ChunkID)
+	}
+	store.mu.RLock()
+	n := len(store.index.live.ordered[sessionKey("user1", "s1")])
+	store.mu.RUnlock()
+	if n != 2 {
+		t.Errorf("Expected 2 visible chunks in the session's order, but got %d", n)
+	}
+	store.Restore("c3")
+	if m, _ := store.LatestInSession("user1", "s1"); m.ChunkID != "c3" {
+		t.Errorf("Expected c3 back after the restore, but got %s", m.ChunkID)
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	concurrencyLimit = expvar.NewFloat("pipeline_concurrency_limit")
+	analysisInFlight = expvar.NewInt("pipeline_inflight")
+	analysisBytes    = expvar.NewInt("pipeline_inflight_bytes")
+)
+
+// AdaptiveLimiter bounds how many chunks are analysed at once using AIMD:
+// each fast completion grows the limit by roughly one per window, while a
+// completion slower than the target latency, or a backed-up queue, cuts it
+// multiplicatively. It also caps the bytes held in analysis.
+type AdaptiveLimiter struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	limit    float64
+	min, max float64
+	inFlight int
+	bytes    int64
+	maxBytes int64
+
+	targetLatency  time.Duration
+	queueDepth     func() int
+	queueThreshold int
+	backoff        float64
+}
+
+// NewAdaptiveLimiter sizes its limit from cfg and the depth reported by
+// queueDepth.
+func NewAdaptiveLimiter(cfg Config, queueDepth func() int) *AdaptiveLimiter {
+	l := &AdaptiveLimiter{
+		limit:          float64(cfg.Workers),
+		min:            float64(cfg.MinConcurrency),
+		max:            float64(cfg.Workers),
+		maxBytes:       cfg.MaxAnalysisBytes,
+		targetLatency:  cfg.TargetLatency,
+		queueDepth:     queueDepth,
+		queueThreshold: cfg.QueueDepthThreshold,
+		backoff:        0.75,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	concurrencyLimit.Set(l.limit)
+	return l
+}
+
+// Limit reports the current effective concurrency.
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Acquire blocks until there is room for another chunk of size bytes. A chunk
+// larger than the byte cap is admitted once nothing else is in flight.
+func (l *AdaptiveLimiter) Acquire(ctx context.Context, size int64) error {
+	stop := context.AfterFunc(ctx, func() {
+		l.mu.Lock()
+		l.cond.Broadcast()
+		l.mu.Unlock()
+	})
+	defer stop()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= int(l.limit) || (l.maxBytes > 0 && l.inFlight > 0 && l.bytes+size > l.maxBytes) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.bytes += size
+	analysisInFlight.Set(int64(l.inFlight))
+	analysisBytes.Set(l.bytes)
+	return nil
+}
+
+// Release returns a slot and feeds the observed latency into the controller.
+func (l *AdaptiveLimiter) Release(size int64, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	l.bytes -= size
+	l.observe(latency)
+	analysisInFlight.Set(int64(l.inFlight))
+	analysisBytes.Set(l.bytes)
+	l.cond.Broadcast()
+}
+
+func (l *AdaptiveLimiter) observe(latency time.Duration) {
+	congested := latency > l.targetLatency
+	if l.queueDepth != nil && l.queueThreshold > 0 && l.queueDepth() > l.queueThreshold && latency > l.targetLatency/2 {
+		congested = true
+	}
+	if congested {
+		l.limit = math.Max(l.min, l.limit*l.backoff)
+	} else {
+		l.limit = math.Min(l.max, l.limit+1/l.limit)
+	}
+	concurrencyLimit.Set(l.limit)
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var loadTest = flag.Bool("loadtest", false, "run the adaptive concurrency load test")
+
+func limiterConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Workers = 8
+	cfg.MinConcurrency = 2
+	cfg.TargetLatency = 50 * time.Millisecond
+	return cfg
+}
+
+func TestAdaptiveLimiter_BacksOffAndRecovers(t *testing.T) {
+	l := NewAdaptiveLimiter(limiterConfig(), nil)
+
+	for i := 0; i < 10; i++ {
+		l.Acquire(context.Background(), 0)
+		l.Release(0, 200*time.Millisecond)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Expected slow completions to back off to the minimum 2, but got %v", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		l.Acquire(context.Background(), 0)
+		l.Release(0, time.Millisecond)
+	}
+	if got := l.Limit(); got != 8 {
+		t.Errorf("Expected fast completions to recover to the maximum 8, but got %v", got)
+	}
+}
+
+func TestAdaptiveLimiter_QueueDepth(t *testing.T) {
+	depth := 100
+	cfg := limiterConfig()
+	l := NewAdaptiveLimiter(cfg, func() int { return depth })
+
+	for i := 0; i < 10; i++ {
+		l.Acquire(context.Background(), 0)
+		l.Release(0, 30*time.Millisecond)
+	}
+	if got := l.Limit(); got != 2 {
+		t.Errorf("Expected a backed-up queue to cut concurrency, but got %v", got)
+	}
+}
+
+func TestAdaptiveLimiter_ByteCap(t *testing.T) {
+	cfg := limiterConfig()
+	cfg.MaxAnalysisBytes = 100
+	l := NewAdaptiveLimiter(cfg, nil)
+
+	if err := l.Acquire(context.Background(), 80); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, 40); err == nil {
+		t.Fatal("Expected acquiring past the byte cap to block")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		l.Acquire(context.Background(), 40)
+		close(acquired)
+	}()
+	l.Release(80, time.Millisecond)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected release to admit the waiting chunk")
+	}
+
+	// A single chunk over the cap still runs once nothing else is in flight.
+	l.Release(40, time.Millisecond)
+	if err := l.Acquire(context.Background(), 500); err != nil {
+		t.Errorf("Expected an oversized chunk to be admitted alone, but got %v", err)
+	}
+}
+
+type slowTranscriber struct {
+	delay atomic.Int64
+}
+
+func (s *slowTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	time.Sleep(time.Duration(s.delay.Load()))
+	return Transcription{Text: "ok"}, nil
+}
+
+// TestAdaptiveLimiter_Load drives the real worker pool through a slow phase
+// and a recovery phase. Run with: go test -run Load -loadtest
+func TestAdaptiveLimiter_Load(t *testing.T) {
+	if !*loadTest {
+		t.Skip("pass -loadtest to run")
+	}
+	cfg := limiterConfig()
+	jobs := make(chan Job, 100)
+	transcriber := &slowTranscriber{}
+	p := NewPipeline(cfg, transcriber, func() int { return len(jobs) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	StartWorkers(ctx, p, cfg.Workers, jobs)
+
+	drive := func(d time.Duration) {
+		var wg sync.WaitGroup
+		deadline := time.Now().Add(d)
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for time.Now().Before(deadline) {
+					submitJob(ctx, jobs, AudioChunk{Data: make([]byte, 1024)})
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	transcriber.delay.Store(int64(200 * time.Millisecond))
+	drive(3 * time.Second)
+	if got := p.Limiter.Limit(); got > cfg.MinConcurrency+1 {
+		t.Errorf("Expected the controller to back off under a slow transcriber, but limit is %v", got)
+	}
+	t.Logf("limit under load: %d", p.Limiter.Limit())
+
+	transcriber.delay.Store(int64(time.Millisecond))
+	drive(3 * time.Second)
+	if got := p.Limiter.Limit(); got != cfg.Workers {
+		t.Errorf("Expected the controller to recover to %v, but limit is %v", cfg.Workers, got)
+	}
+	t.Logf("limit after recovery: %d", p.Limiter.Limit())
+}
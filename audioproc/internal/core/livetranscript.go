@@ -0,0 +1,155 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// transcriptQueue is how many live segments wait for a transcript socket
+// before further ones are dropped for it.
+const transcriptQueue = 256
+
+// transcriptSegment is one chunk's final transcript as pushed by
+// GET /ws/transcript. Cursor is its change feed position: reconnecting
+// with cursor set to the last one received resumes without duplicates.
+// A chunk that is reprocessed is sent again, and the newer segment
+// replaces the older one with the same ChunkID.
+type transcriptSegment struct {
+	Type      string    `json:"type"`
+	Cursor    int64     `json:"cursor"`
+	ChunkID   string    `json:"chunk_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Text      string    `json:"text"`
+	Words     []Word    `json:"words,omitempty"`
+	Replayed  bool      `json:"replayed,omitempty"`
+	// Truncated is set when Text is only the opening of a transcript too
+	// large to push; GET /chunks/{id}/transcript has the rest.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// transcriptTruncated is sent first when the requested cursor is older
+// than the change log, so segments before Cursor may be missing.
+type transcriptTruncated struct {
+	Type   string `json:"type"`
+	Cursor int64  `json:"cursor"`
+}
+
+// hasSegment reports whether m is a finished chunk with a transcript to
+// show.
+func hasSegment(m Metadata) bool {
+	return (m.Status == "processed" || m.Status == "partial") && m.Transcript != ""
+}
+
+func newTranscriptSegment(seq int64, m Metadata, replayed bool) transcriptSegment {
+	return transcriptSegment{Type: "segment", Cursor: seq, ChunkID: m.ChunkID, Timestamp: m.Timestamp.UTC(), Text: m.Transcript, Words: m.Words, Replayed: replayed, Truncated: m.Truncated}
+}
+
+// sessionSegments returns the session's transcript segments saved after
+// since, oldest first, with the change feed position they were read at.
+// Truncated is set when changes after since have left the log.
+func (s *MemoryStore) sessionSegments(userID, sessionID string, since int64) (segments []transcriptSegment, cursor int64, truncated bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.changes) > 0 && since+1 < s.changes[0].Seq {
+		truncated = true
+	}
+	for _, c := range s.changes {
+		m := c.Metadata
+		if c.Seq <= since || c.Op != changeSave || m == nil || m.UserID != userID || m.SessionID != sessionID || !hasSegment(*m) {
+			continue
+		}
+		segments = append(segments, newTranscriptSegment(c.Seq, *m, true))
+	}
+	return segments, s.changeSeq, truncated
+}
+
+// handleTranscriptSocket pushes a session's transcript, chunk by chunk,
+// whether the chunks arrive over HTTP, WebSocket or MQTT. It first sends
+// the segments saved after the cursor parameter, then live ones from the
+// event bus; like handleObserve it subscribes before reading the change
+// feed and skips live events the catch-up covered.
+func handleTranscriptSocket(store *MemoryStore, bus *Bus, keys *KeyRing, g *Goroutines, cfg Config) http.HandlerFunc {
+	upgrader := newUpgrader(cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := checkHandshake(cfg, keys, false, w, r)
+		if !ok {
+			return
+		}
+		userID, sessionID := r.URL.Query().Get("user_id"), r.URL.Query().Get("session_id")
+		if err := validateIDs(cfg, userID, sessionID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		var since int64
+		if v := r.URL.Query().Get("cursor"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				writeError(w, invalidParam("cursor", "invalid_cursor", "cursor must be a non-negative integer"))
+				return
+			}
+			since = n
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.NetConn().SetDeadline(time.Time{})
+		ctx, done := g.TrackStream(r.Context(), "transcript")
+		defer done()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(ctx, func() { ws.Close() })()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := ws.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		events := make(chan Event)
+		unsubscribe := bus.Subscribe("transcript", transcriptQueue, func(ev Event) {
+			if !eventInSession(ev, userID, sessionID) || !hasSegment(*ev.Chunk) {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+			}
+		}, EventChunkProcessed)
+		defer unsubscribe()
+		defer cancel()
+
+		segments, cursor, truncated := store.sessionSegments(userID, sessionID, since)
+		if truncated && ws.WriteJSON(transcriptTruncated{Type: "truncated", Cursor: since}) != nil {
+			return
+		}
+		for _, seg := range segments {
+			if ws.WriteJSON(seg) != nil {
+				return
+			}
+		}
+		cursor = max(cursor, since)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-events:
+				if ev.Seq <= cursor {
+					continue
+				}
+				if ws.WriteJSON(newTranscriptSegment(ev.Seq, *ev.Chunk, false)) != nil {
+					return
+				}
+			}
+		}
+	}
+}
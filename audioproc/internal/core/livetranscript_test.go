@@ -0,0 +1,97 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// numberedTranscriber transcribes the nth chunk as "segment n".
+type numberedTranscriber struct{ n atomic.Int64 }
+
+func (c *numberedTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	words := []string{"segment", strconv.FormatInt(c.n.Add(1), 10)}
+	return Transcription{Text: words[0] + " " + words[1], Words: spreadWords(words, 100)}, nil
+}
+
+func TestTranscriptSocket(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	h.Pipeline.Transcriber = &numberedTranscriber{}
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	upload := func(session string) { uploadTo(t, h, "user1", session, wav) }
+
+	dial := func(cursor int64) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial(h.WSURL(fmt.Sprintf("/ws/transcript?user_id=user1&session_id=s1&cursor=%d", cursor)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	var last int64
+	expect := func(conn *websocket.Conn, text string, replayed bool) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		var seg transcriptSegment
+		if err := conn.ReadJSON(&seg); err != nil {
+			t.Fatal(err)
+		}
+		if seg.Type != "segment" || seg.Text != text || seg.Replayed != replayed || len(seg.Words) != 2 || seg.Cursor <= last {
+			t.Fatalf("Expected %q (replayed %v) after cursor %d, but got %+v", text, replayed, last, seg)
+		}
+		last = seg.Cursor
+	}
+	expectQuiet := func(conn *websocket.Conn) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		var ne net.Error
+		if _, raw, err := conn.ReadMessage(); !errors.As(err, &ne) || !ne.Timeout() {
+			t.Fatalf("Expected no more segments, but got %s %v", raw, err)
+		}
+	}
+
+	upload("s1")
+	upload("other")
+	upload("s1")
+	conn := dial(0)
+	expect(conn, "segment 1", true)
+	expect(conn, "segment 3", true)
+	upload("s1")
+	upload("other")
+	upload("s1")
+	expect(conn, "segment 4", false)
+	expect(conn, "segment 6", false)
+	conn.Close()
+
+	// Reconnecting from the cursor of segment 4 resends only what followed.
+	upload("s1")
+	last = 0
+	conn = dial(lastCursorOf(t, h, "segment 4"))
+	defer conn.Close()
+	expect(conn, "segment 6", true)
+	expect(conn, "segment 7", true)
+	upload("s1")
+	expect(conn, "segment 8", false)
+	expectQuiet(conn)
+}
+
+// lastCursorOf returns the change feed position of the save that gave a
+// chunk the transcript text.
+func lastCursorOf(t *testing.T, h *Harness, text string) int64 {
+	t.Helper()
+	for _, c := range h.Store.Changes(0, 1000).Changes {
+		if c.Op == changeSave && c.Metadata != nil && c.Metadata.Transcript == text {
+			return c.Seq
+		}
+	}
+	t.Fatalf("No save of %q", text)
+	return 0
+}
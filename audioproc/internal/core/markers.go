@@ -0,0 +1,124 @@
+package core
+
+import (
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMarkers caps how many cue points are kept from one file.
+const maxMarkers = 1000
+
+// Marker is a cue point embedded in an uploaded WAV, such as a chapter
+// mark set on a field recorder. OffsetMS is from the start of the chunk.
+type Marker struct {
+	OffsetMS int64  `json:"offset_ms"`
+	Label    string `json:"label,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// readMarkers returns the cue points of a WAV with their labl and note
+// texts from the LIST/adtl chunk, in order. A malformed cue chunk is
+// skipped as a whole; other files have no markers.
+func readMarkers(data []byte) []Marker {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil
+	}
+	var rate uint32
+	var cues map[uint32]*Marker
+	var order []uint32
+	labels, notes := make(map[uint32]string), make(map[uint32]string)
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8 : min(len(data), off+8+size)]
+		switch {
+		case id == "fmt " && len(body) >= 8:
+			rate = binary.LittleEndian.Uint32(body[4:8])
+		case id == "cue " && len(body) >= 4:
+			n := int(binary.LittleEndian.Uint32(body[:4]))
+			if n > maxMarkers || 4+24*n > len(body) {
+				break
+			}
+			cues = make(map[uint32]*Marker, n)
+			order = order[:0]
+			for p := body[4 : 4+24*n]; len(p) >= 24; p = p[24:] {
+				cueID := binary.LittleEndian.Uint32(p[:4])
+				if _, dup := cues[cueID]; dup {
+					continue
+				}
+				// dwSampleOffset; the offset is converted once the sample
+				// rate is known.
+				cues[cueID] = &Marker{OffsetMS: int64(binary.LittleEndian.Uint32(p[20:24]))}
+				order = append(order, cueID)
+			}
+		case id == "LIST" && len(body) >= 4 && string(body[:4]) == "adtl":
+			for sub := body[4:]; len(sub) >= 8; {
+				kind := string(sub[:4])
+				n := int(binary.LittleEndian.Uint32(sub[4:8]))
+				if n > len(sub)-8 {
+					break
+				}
+				if (kind == "labl" || kind == "note") && n >= 4 {
+					cueID := binary.LittleEndian.Uint32(sub[8:12])
+					text, _, _ := strings.Cut(string(sub[12:8+n]), "\x00")
+					if text = strings.TrimSpace(text); utf8.ValidString(text) {
+						if r := []rune(text); len(r) > maxTagValue {
+							text = string(r[:maxTagValue])
+						}
+						if kind == "labl" {
+							labels[cueID] = text
+						} else {
+							notes[cueID] = text
+						}
+					}
+				}
+				sub = sub[min(len(sub), 8+n+n%2):]
+			}
+		}
+		off += 8 + size + size%2
+	}
+	if rate == 0 || len(order) == 0 {
+		return nil
+	}
+	markers := make([]Marker, 0, len(order))
+	for _, cueID := range order {
+		m := *cues[cueID]
+		m.OffsetMS = m.OffsetMS * 1000 / int64(rate)
+		m.Label, m.Note = labels[cueID], notes[cueID]
+		markers = append(markers, m)
+	}
+	sort.SliceStable(markers, func(i, j int) bool { return markers[i].OffsetMS < markers[j].OffsetMS })
+	return markers
+}
+
+// markersBetween returns the markers in [fromMS, toMS), rebased to fromMS,
+// for a piece cut out of the audio they were read from.
+func markersBetween(markers []Marker, fromMS, toMS int64) []Marker {
+	var out []Marker
+	for _, m := range markers {
+		if m.OffsetMS >= fromMS && m.OffsetMS < toMS {
+			m.OffsetMS -= fromMS
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// chunkAnnotations returns a chunk's annotations with its markers among
+// them, by offset. Markers are read-only: their IDs are not stored, so
+// deleting one is a 404.
+func chunkAnnotations(store *MemoryStore, meta Metadata) []Annotation {
+	result := store.Annotations(meta.ChunkID)
+	for i, m := range meta.Markers {
+		text := m.Label
+		if m.Note != "" {
+			text = strings.TrimSpace(text + "\n" + m.Note)
+		}
+		result = append(result, Annotation{ID: "cue-" + strconv.Itoa(i+1), ChunkID: meta.ChunkID, OffsetMS: m.OffsetMS, Text: text, Source: "cue"})
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].OffsetMS < result[j].OffsetMS })
+	return result
+}
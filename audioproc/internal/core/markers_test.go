@@ -0,0 +1,101 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// riffChunk encodes one RIFF chunk, padded to an even length.
+func riffChunk(id string, body []byte) []byte {
+	out := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(body)))
+	out = append(out, body...)
+	if len(body)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// withCues appends a cue chunk for samples, and labels for them, to a WAV.
+// count, when not zero, overrides the number of points the chunk claims.
+func withCues(wav []byte, samples []uint32, labels []string, count int) []byte {
+	cue := binary.LittleEndian.AppendUint32(nil, uint32(max(count, len(samples))))
+	adtl := []byte("adtl")
+	for i, s := range samples {
+		id := uint32(i + 1)
+		cue = binary.LittleEndian.AppendUint32(cue, id)
+		cue = binary.LittleEndian.AppendUint32(cue, s)
+		cue = append(cue, "data"...)
+		cue = append(cue, make([]byte, 8)...)
+		cue = binary.LittleEndian.AppendUint32(cue, s)
+		adtl = append(adtl, riffChunk("labl", append(binary.LittleEndian.AppendUint32(nil, id), labels[i]+"\x00"...))...)
+	}
+	out := append(append([]byte{}, wav...), riffChunk("cue ", cue)...)
+	out = append(out, riffChunk("LIST", adtl)...)
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out
+}
+
+func getBody(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestWAVCueMarkers(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := withCues(SineWAV(440, 2*time.Second, 8000), []uint32{12000, 4000}, []string{"Chorus", "Intro"}, 0)
+	want := []Marker{{OffsetMS: 500, Label: "Intro"}, {OffsetMS: 1500, Label: "Chorus"}}
+
+	meta := uploadTo(t, h, "user1", "s1", wav)
+	if !slices.Equal(meta.Markers, want) || meta.DurationMS != 2000 {
+		t.Fatalf("Expected markers %+v on a 2s chunk, but got %+v %v", want, meta.Markers, meta.DurationMS)
+	}
+
+	var transcript struct{ Markers []Marker }
+	json.Unmarshal([]byte(getBody(t, h.URL+"/chunks/"+meta.ChunkID+"/transcript")), &transcript)
+	if !slices.Equal(transcript.Markers, want) {
+		t.Errorf("Expected the transcript to carry the markers, but got %+v", transcript.Markers)
+	}
+	if vtt := getBody(t, h.URL+"/chunks/"+meta.ChunkID+"/transcript?format=vtt"); !strings.Contains(vtt, "NOTE marker 00:00:00.500 Intro\n") || !strings.Contains(vtt, "NOTE marker 00:00:01.500 Chorus\n") {
+		t.Errorf("Expected NOTE blocks for the markers, but got %q", vtt)
+	}
+	var annotations []Annotation
+	json.Unmarshal([]byte(getBody(t, h.URL+"/chunks/"+meta.ChunkID+"/annotations")), &annotations)
+	if len(annotations) != 2 || annotations[0].Text != "Intro" || annotations[0].Source != "cue" || annotations[1].OffsetMS != 1500 {
+		t.Errorf("Expected the markers among the annotations, but got %+v", annotations)
+	}
+	var listed []Metadata
+	json.Unmarshal([]byte(getBody(t, h.URL+"/sessions/user1")), &listed)
+	if len(listed) != 1 || !slices.Equal(listed[0].Markers, want) {
+		t.Errorf("Expected the session listing to carry the markers, but got %+v", listed)
+	}
+
+	// A trim keeps the markers inside it, rebased.
+	_, trimmed := postTrim(t, h, meta.ChunkID, `{"start_ms": 1000, "end_ms": 2000}`)
+	if !slices.Equal(trimmed.Markers, []Marker{{OffsetMS: 500, Label: "Chorus"}}) {
+		t.Errorf("Expected the trim to keep the chorus marker, but got %+v", trimmed.Markers)
+	}
+}
+
+func TestMalformedCueChunkIsSkipped(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	// The chunk claims five points but holds one.
+	wav := withCues(SineWAV(440, time.Second, 8000), []uint32{4000}, []string{"Only"}, 5)
+	meta := uploadTo(t, h, "user1", "s1", wav)
+	if meta.Status != "processed" || meta.Markers != nil || meta.DurationMS != 1000 {
+		t.Errorf("Expected the upload to succeed without markers, but got %+v", meta)
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	memoryBudgetLimit   = expvar.NewInt("memory_budget_bytes")
+	memoryBudgetInUse   = expvar.NewInt("memory_budget_in_use")
+	memoryBudgetWaiting = expvar.NewInt("memory_budget_waiting")
+	memoryBudgetShed    = expvar.NewInt("memory_budget_shed")
+)
+
+// errResourceExhausted turns a chunk away while the memory budget is used
+// up and the queue is full as well.
+var errResourceExhausted = newKindError(ErrResourceExhausted, "resource exhausted: the memory budget is in use and the queue is full; retry shortly")
+
+// MemoryBudget caps the bytes held by chunks being decoded and transcribed,
+// across all workers. A worker acquires a chunk's share before decoding it
+// and waits, in turn, while there is not enough left; the dispatcher sheds
+// new chunks once workers are waiting and the backlog is full too. A nil
+// *MemoryBudget admits everything.
+type MemoryBudget struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   int64
+	backlog int
+	inUse   int64
+	// waiting holds the waiters in arrival order, so a large chunk is not
+	// starved by small ones that would fit ahead of it.
+	waiting []*int64
+}
+
+// NewMemoryBudget returns a budget of cfg.MemoryBudget bytes, or nil when
+// it is zero.
+func NewMemoryBudget(cfg Config) *MemoryBudget {
+	if cfg.MemoryBudget <= 0 {
+		return nil
+	}
+	b := &MemoryBudget{limit: cfg.MemoryBudget, backlog: cfg.MemoryBudgetBacklog}
+	b.cond = sync.NewCond(&b.mu)
+	memoryBudgetLimit.Set(b.limit)
+	return b
+}
+
+// chunkMemory estimates what processing data holds: the audio itself, the
+// decoded samples and the normalized copy transcription may make.
+func chunkMemory(data []byte) int64 {
+	n := 2 * int64(len(data))
+	if info, err := probeAudio(data); err == nil {
+		n += info.TotalSamples * 8
+	}
+	return n
+}
+
+// InUse reports how many bytes are held.
+func (b *MemoryBudget) InUse() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.inUse
+}
+
+// Acquire blocks until n bytes are free and it is first in line, or ctx
+// ends. The caller defers release, so the bytes come back however
+// processing ends, panics included.
+func (b *MemoryBudget) Acquire(ctx context.Context, n int64) (release func(), err error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		b.cond.Broadcast()
+		b.mu.Unlock()
+	})
+	defer stop()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := &n
+	b.waiting = append(b.waiting, w)
+	for b.waiting[0] != w || b.inUse+n > b.limit && b.inUse > 0 {
+		if err := ctx.Err(); err != nil {
+			b.waiting = slices.DeleteFunc(b.waiting, func(o *int64) bool { return o == w })
+			memoryBudgetWaiting.Set(int64(len(b.waiting)))
+			b.cond.Broadcast()
+			return nil, err
+		}
+		memoryBudgetWaiting.Set(int64(len(b.waiting)))
+		b.cond.Wait()
+	}
+	b.waiting = b.waiting[1:]
+	b.inUse += n
+	memoryBudgetWaiting.Set(int64(len(b.waiting)))
+	memoryBudgetInUse.Set(b.inUse)
+	b.cond.Broadcast()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.inUse -= n
+			memoryBudgetInUse.Set(b.inUse)
+			b.cond.Broadcast()
+		})
+	}, nil
+}
+
+// admit decides whether a chunk needing n bytes may join a queue already
+// holding queued chunks. One that could never fit is refused outright;
+// otherwise chunks are shed only while the budget is exhausted, meaning a
+// worker is waiting for it, and the backlog is full.
+func (b *MemoryBudget) admit(n int64, queued int) error {
+	if b == nil {
+		return nil
+	}
+	if n > b.limit {
+		memoryBudgetShed.Add(1)
+		return invalidField("data", "resource_exhausted", fmt.Sprintf("processing this chunk takes about %d bytes, more than the whole memory budget of %d", n, b.limit))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.backlog > 0 && queued >= b.backlog && (len(b.waiting) > 0 || b.inUse+n > b.limit) {
+		memoryBudgetShed.Add(1)
+		return errResourceExhausted
+	}
+	return nil
+}
+
+// budgetHold shares a chunk's reservation between the worker processing
+// it and the stage goroutines the worker starts. A stage abandoned at the
+// deadline still holds the chunk's decoded audio, so the bytes go back
+// only once the worker and every stage are done with them.
+type budgetHold struct {
+	refs    atomic.Int64
+	release func()
+}
+
+// newBudgetHold returns a hold with one reference, the worker's.
+func newBudgetHold(release func()) *budgetHold {
+	h := &budgetHold{release: release}
+	h.refs.Store(1)
+	return h
+}
+
+func (h *budgetHold) retain() {
+	h.refs.Add(1)
+}
+
+// done drops a reference, releasing the bytes with the last one.
+func (h *budgetHold) done() {
+	if h.refs.Add(-1) == 0 {
+		h.release()
+	}
+}
@@ -0,0 +1,272 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// peakTranscriber records the most chunks it was transcribing at once.
+type peakTranscriber struct {
+	inFlight, peak atomic.Int64
+}
+
+func (p *peakTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	n := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	return Transcription{Text: "ok"}, nil
+}
+
+func postAudio(h *Harness, session string, data []byte) (int, string) {
+	url := fmt.Sprintf("%s/upload?user_id=user1&session_id=%s", h.URL, session)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body.Error
+}
+
+func (b *MemoryBudget) waiters() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.waiting)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMemoryBudgetSerializesProcessing(t *testing.T) {
+	chunk := SineWAV(440, time.Second, 8000)
+	cfg := DefaultConfig()
+	cfg.Workers = 4
+	cfg.MemoryBudget = chunkMemory(chunk)
+	h := NewHarness(cfg)
+	defer h.Close()
+	transcriber := &peakTranscriber{}
+	h.Pipeline.Transcriber = transcriber
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uploadTo(t, h, "user1", fmt.Sprintf("s%d", i), chunk)
+		}()
+	}
+	wg.Wait()
+	if peak := transcriber.peak.Load(); peak != 1 {
+		t.Errorf("Expected a budget of one chunk to process them one at a time, but %d ran at once", peak)
+	}
+	if n := h.Pipeline.Budget.InUse(); n != 0 {
+		t.Errorf("Expected the budget released once processing finished, but %d bytes are held", n)
+	}
+
+	resp, err := http.Get(h.URL + "/debug/vars")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), `"memory_budget_in_use": 0`) || !strings.Contains(string(body), `"memory_budget_bytes"`) {
+		t.Errorf("Expected the budget in /debug/vars, but got %.300s", body)
+	}
+}
+
+func TestMemoryBudgetShedsWhenQueueFull(t *testing.T) {
+	chunk := SineWAV(440, time.Second, 8000)
+	cfg := DefaultConfig()
+	cfg.Workers = 2
+	cfg.MemoryBudget = chunkMemory(chunk)
+	cfg.MemoryBudgetBacklog = 2
+	h := NewHarness(cfg)
+	defer h.Close()
+	gate := &gateTranscriber{entered: make(chan struct{}, 10), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+
+	// One chunk holds the budget, the other worker waits for it and two
+	// more fill the queue.
+	statuses := make(chan int, 4)
+	upload := func(session string) {
+		go func() {
+			status, _ := postAudio(h, session, chunk)
+			statuses <- status
+		}()
+	}
+	upload("s1")
+	<-gate.entered
+	upload("s2")
+	waitFor(t, "a worker to wait for memory", func() bool { return h.Pipeline.Budget.waiters() == 1 })
+	upload("s3")
+	upload("s4")
+	waitFor(t, "the queue to fill", func() bool { return h.dispatcher.Len() == 2 })
+
+	if status, code := postAudio(h, "s5", chunk); status != http.StatusServiceUnavailable || code != "resource_exhausted" {
+		t.Errorf("Expected a chunk past the full queue to be shed with 503, but got %d %q", status, code)
+	}
+	if status, code := postAudio(h, "s6", SineWAV(440, 2*time.Second, 8000)); status != http.StatusUnprocessableEntity || code != "resource_exhausted" {
+		t.Errorf("Expected a chunk bigger than the budget to be refused with 422, but got %d %q", status, code)
+	}
+
+	close(gate.release)
+	for range 4 {
+		if status := <-statuses; status != http.StatusOK {
+			t.Errorf("Expected the admitted chunks to be processed, but got %d", status)
+		}
+	}
+	if n := h.Pipeline.Budget.InUse(); n != 0 {
+		t.Errorf("Expected the budget released once processing finished, but %d bytes are held", n)
+	}
+	if status, _ := postAudio(h, "s7", chunk); status != http.StatusOK {
+		t.Errorf("Expected chunks admitted again once the budget is free, but got %d", status)
+	}
+}
+
+func TestMemoryBudgetShedsWebSocketChunks(t *testing.T) {
+	chunk := SineWAV(440, time.Second, 8000)
+	cfg := DefaultConfig()
+	cfg.Workers = 1
+	cfg.MemoryBudget = chunkMemory(chunk)
+	cfg.MemoryBudgetBacklog = 1
+	h := NewHarness(cfg)
+	defer h.Close()
+	gate := &gateTranscriber{entered: make(chan struct{}, 10), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+
+	// One chunk holds the budget and the worker, and one more fills the
+	// queue.
+	statuses := make(chan int, 2)
+	for _, session := range []string{"s1", "s2"} {
+		go func() {
+			status, _ := postAudio(h, session, chunk)
+			statuses <- status
+		}()
+		if session == "s1" {
+			<-gate.entered
+		}
+	}
+	waitFor(t, "the queue to fill", func() bool { return h.dispatcher.Len() == 1 })
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s3"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: chunk, Seq: 1})
+	if nack, err := readAck(conn); err != nil || nack.Ack || nack.Error != "resource_exhausted" || nack.Seq != 1 {
+		t.Errorf("Expected seq 1 shed as resource_exhausted, but got %+v %v", nack, err)
+	}
+
+	close(gate.release)
+	for range 2 {
+		<-statuses
+	}
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: chunk, Seq: 2})
+	if ack, err := readAck(conn); err != nil || !ack.Ack || ack.Seq != 2 {
+		t.Errorf("Expected the connection kept open and seq 2 acked, but got %+v %v", ack, err)
+	}
+}
+
+func TestMemoryBudgetRelease(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MemoryBudget = 100
+	b := NewMemoryBudget(cfg)
+
+	func() {
+		defer func() { recover() }()
+		release, err := b.Acquire(context.Background(), 60)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		panic("stage failed")
+	}()
+	if n := b.InUse(); n != 0 {
+		t.Fatalf("Expected a panic to release its bytes, but %d are held", n)
+	}
+
+	release, _ := b.Acquire(context.Background(), 60)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := b.Acquire(ctx, 60); err == nil {
+		t.Fatalf("Expected a waiter to give up when its context ends")
+	}
+	release()
+	release()
+	if n := b.InUse(); n != 0 {
+		t.Errorf("Expected releasing twice to count once, but %d bytes are held", n)
+	}
+	// The waiter that gave up is out of line and holds nothing up.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if release, err := b.Acquire(ctx, 100); err != nil {
+		t.Errorf("Expected the whole budget free, but got %v", err)
+	} else {
+		release()
+	}
+
+	var none *MemoryBudget
+	if release, err := none.Acquire(context.Background(), 1<<40); err != nil || none.admit(1<<40, 1000) != nil {
+		t.Errorf("Expected a nil budget to admit everything, but got %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestMemoryBudgetHeldByAbandonedStage(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MemoryBudget = 100
+	b := NewMemoryBudget(cfg)
+	release, err := b.Acquire(context.Background(), 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hold := newBudgetHold(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	exit := make(chan struct{})
+	if runStage(ctx, hold, func() { <-exit }) {
+		t.Fatalf("Expected the stage to be abandoned at the deadline")
+	}
+	hold.done()
+	if n := b.InUse(); n != 60 {
+		t.Errorf("Expected the abandoned stage to keep its bytes, but %d are held", n)
+	}
+	close(exit)
+	for deadline := time.Now().Add(5 * time.Second); b.InUse() != 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := b.InUse(); n != 0 {
+		t.Errorf("Expected the bytes back once the stage exited, but %d are held", n)
+	}
+}
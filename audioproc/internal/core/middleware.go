@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+type ctxKey int
+
+const (
+	userIDKey ctxKey = iota
+	clientIPKey
+	clientDeadlineKey
+)
+
+// authUserID returns the user ID resolved by requireAuth, if any.
+func authUserID(ctx context.Context) string {
+	id, _ := ctx.Value(userIDKey).(string)
+	return id
+}
+
+// rejectUnsafePaths refuses, with 400, paths holding dot-segments or
+// encoded separators. No route has such a path, and the router would
+// otherwise clean them into some other one; they are how traversal
+// through a path parameter is attempted.
+func rejectUnsafePaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := strings.ToLower(r.URL.EscapedPath())
+		unsafe := strings.Contains(raw, "%2f") || strings.Contains(raw, "%5c") || strings.Contains(raw, "%00")
+		for _, seg := range strings.Split(r.URL.Path, "/") {
+			unsafe = unsafe || seg == "." || seg == ".."
+		}
+		if unsafe {
+			writeJSONError(w, http.StatusBadRequest, "invalid_path", "path has dot-segments or encoded separators")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuth rejects requests without a valid API key, and writes with a
+// read-only one. CORS preflights, the WebSocket endpoints, which report their
+// own handshake failures, and share, export and signed audio links, whose
+// token is their credential, pass through.
+func requireAuth(keys *KeyRing) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions || r.URL.Path == "/ws" || r.URL.Path == "/ws/transcript" || isObservePath(r.URL.Path) || r.URL.Path == "/openapi.json" || r.URL.Path == "/version" || r.URL.Path == "/capabilities" || r.URL.Path == receipt.KeysPath || r.URL.Path == "/docs" || strings.HasPrefix(r.URL.Path, "/shared/") || strings.HasPrefix(r.URL.Path, "/exports/") || isSignedAudioRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key, ok := keys.Authenticate(r)
+			if !ok {
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid API key")
+				return
+			}
+			if !key.allows(r.Method) {
+				writeJSONError(w, http.StatusForbidden, "insufficient_scope", "API key is read-only")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDKey, key.UserID)))
+		})
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Hijack is needed by the WebSocket upgrader, which type-asserts for it.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	r.status = http.StatusSwitchingProtocols
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+func (r *statusRecorder) Flush() {
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// requestProtocols counts requests by protocol version, e.g. "HTTP/2.0".
+var requestProtocols = expvar.NewMap("request_protocols")
+
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		requestProtocols.Add(r.Proto, 1)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpResponses.Add(strconv.Itoa(rec.status/100)+"xx", 1)
+		log.Printf("%s %s %d %s", r.Method, r.URL.Path, rec.status, time.Since(start))
+	})
+}
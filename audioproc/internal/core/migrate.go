@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// currentSchemaVersion is the Metadata shape written by Save. Records without
+// a schema_version field predate versioning and are treated as version 1.
+const currentSchemaVersion = 2
+
+// migrations[v] upgrades a record's fields from version v to v+1 in place.
+var migrations = map[int]func(fields map[string]json.RawMessage){
+	// v2 introduced status; every v1 record was a successfully processed chunk.
+	1: func(fields map[string]json.RawMessage) {
+		setDefault(fields, "status", `"processed"`)
+	},
+}
+
+func setDefault(fields map[string]json.RawMessage, key, value string) {
+	if _, ok := fields[key]; !ok {
+		fields[key] = json.RawMessage(value)
+	}
+}
+
+func rawSchemaVersion(raw json.RawMessage) int {
+	var v struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if json.Unmarshal(raw, &v) != nil || v.SchemaVersion == 0 {
+		return 1
+	}
+	return v.SchemaVersion
+}
+
+// upgradeRecord decodes a persisted record of any known version into the
+// current Metadata shape.
+func upgradeRecord(raw json.RawMessage) (Metadata, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return Metadata{}, err
+	}
+	version := rawSchemaVersion(raw)
+	if version > currentSchemaVersion {
+		return Metadata{}, fmt.Errorf("schema version %d is newer than %d", version, currentSchemaVersion)
+	}
+	for ; version < currentSchemaVersion; version++ {
+		migrations[version](fields)
+	}
+	fields["schema_version"] = json.RawMessage(fmt.Sprint(currentSchemaVersion))
+
+	upgraded, err := json.Marshal(fields)
+	if err != nil {
+		return Metadata{}, err
+	}
+	var m Metadata
+	err = json.Unmarshal(upgraded, &m)
+	return m, err
+}
+
+// Migrator rewrites every legacy record under the current schema in the
+// background.
+type Migrator struct {
+	ctx   context.Context
+	store *MemoryStore
+
+	mu      sync.Mutex
+	running bool
+	wg      sync.WaitGroup
+}
+
+// NewMigrator upgrades legacy records in store; passes stop when ctx ends.
+func NewMigrator(ctx context.Context, store *MemoryStore) *Migrator {
+	return &Migrator{ctx: ctx, store: store}
+}
+
+// Start launches a migration pass unless one is already running.
+func (m *Migrator) Start() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		return false
+	}
+	m.running = true
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		m.run()
+		m.mu.Lock()
+		m.running = false
+		m.mu.Unlock()
+	}()
+	return true
+}
+
+func (m *Migrator) run() {
+	for _, id := range m.store.LegacyIDs() {
+		if m.ctx.Err() != nil {
+			return
+		}
+		m.store.UpgradeLegacy(id)
+	}
+	log.Println("Schema migration pass finished")
+}
+
+// Running reports whether a migration pass is in progress.
+func (m *Migrator) Running() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// Wait blocks until any running pass has finished.
+func (m *Migrator) Wait() {
+	m.wg.Wait()
+}
+
+type migrationStatus struct {
+	Running          bool        `json:"running"`
+	CurrentVersion   int         `json:"current_version"`
+	RecordsByVersion map[int]int `json:"records_by_version"`
+}
+
+func handleMigrate(m *Migrator, store *MemoryStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		if r.Method == http.MethodPost {
+			m.Start()
+			status = http.StatusAccepted
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(migrationStatus{
+			Running:          m.Running(),
+			CurrentVersion:   currentSchemaVersion,
+			RecordsByVersion: store.SchemaVersions(),
+		})
+	}
+}
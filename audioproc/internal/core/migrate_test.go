@@ -0,0 +1,79 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const v1Record = `{"chunk_id":"old1","user_id":"user1","session_id":"sess1","timestamp":"2023-05-01T10:00:00Z","checksum":"abc123","fft":"440Hz","transcript":"legacy"}`
+
+func TestSchemaMigration_UpgradeOnRead(t *testing.T) {
+	store := NewMemoryStore()
+	store.PutRaw("old1", json.RawMessage(v1Record))
+
+	req := httptest.NewRequest("GET", "/chunks/old1", nil)
+	rr := httptest.NewRecorder()
+	New(DefaultConfig(), store, nil).Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, but got %v", rr.Code)
+	}
+
+	var got map[string]any
+	json.NewDecoder(rr.Body).Decode(&got)
+	want := map[string]any{
+		"schema_version": float64(currentSchemaVersion),
+		"chunk_id":       "old1",
+		"user_id":        "user1",
+		"session_id":     "sess1",
+		"timestamp":      "2023-05-01T10:00:00Z",
+		"checksum":       "abc123",
+		"fft":            "440Hz",
+		"transcript":     "legacy",
+		"status":         "processed",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Expected %s=%v, but got %v", k, v, got[k])
+		}
+	}
+
+	if v, _ := store.SchemaVersion("old1"); v != 1 {
+		t.Errorf("Expected reads not to rewrite the record, but raw version is %v", v)
+	}
+
+	meta, _ := store.Get("old1")
+	meta.Transcript = "updated"
+	store.Save(meta)
+	if v, _ := store.SchemaVersion("old1"); v != currentSchemaVersion {
+		t.Errorf("Expected save to rewrite the record as v%v, but got v%v", currentSchemaVersion, v)
+	}
+}
+
+func TestSchemaMigration_AdminMigrate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	h.Store.PutRaw("old1", json.RawMessage(v1Record))
+	h.Store.PutRaw("old2", json.RawMessage(`{"chunk_id":"old2","user_id":"user2","timestamp":"2023-05-01T10:00:00Z"}`))
+
+	req, _ := http.NewRequest("POST", h.URL+"/admin/migrate", nil)
+	req.Header.Set("X-Admin-Token", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status code 202, but got %v", resp.StatusCode)
+	}
+
+	h.Migrator.Wait()
+	counts := h.Store.SchemaVersions()
+	if counts[1] != 0 || counts[currentSchemaVersion] != 2 {
+		t.Errorf("Expected all records on v%v, but got %v", currentSchemaVersion, counts)
+	}
+}
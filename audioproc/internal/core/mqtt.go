@@ -0,0 +1,258 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/mqtt"
+	"github.com/google/uuid"
+)
+
+const (
+	mqttMinBackoff = 100 * time.Millisecond
+	mqttKeepAlive  = 30 * time.Second
+	// mqttMaxQueue bounds the chunks received from the broker and waiting
+	// to be handled.
+	mqttMaxQueue = 64
+)
+
+var (
+	mqttConnected = expvar.NewInt("mqtt_connected")
+	mqttStats     = expvar.NewMap("mqtt")
+)
+
+// MQTTBridge feeds audio published by devices over MQTT into the
+// pipeline. A payload published to a topic matching Config.MQTTTopic is
+// ingested as one chunk of the user and session named by the topic, and
+// its metadata, or an error, is published to Config.MQTTResponseTopic.
+//
+// Messages are handled one at a time and acknowledged once the response
+// is published. The bridge keeps a persistent session under its client
+// ID, so the broker redelivers a chunk the bridge did not get to finish,
+// even across reconnects. A redelivery of a chunk already ingested is
+// recognised by its packet ID and answered with the metadata of the first
+// delivery; identical audio published twice is two chunks.
+type MQTTBridge struct {
+	// ReadOnly, when on, has chunks answered with a read_only error. They
+	// are acknowledged, so devices should resend them later.
+	ReadOnly *ReadOnly
+
+	cfg      Config
+	clientID string
+	store    *MemoryStore
+	jobs     chan<- Job
+	sessions *SessionTracker
+	identity IdentityProvider
+	topic    []string
+	filter   string
+
+	// unacked maps the packet IDs of messages ingested but not yet
+	// acknowledged to their chunks. Entries outlive a lost connection
+	// only while the broker keeps the session that would redeliver them.
+	unacked map[uint16]string
+}
+
+// mqttClientID is the client ID the bridge connects with: cfg.MQTTClientID
+// or, when that is empty, one derived from the host name, so that each
+// instance keeps its own session with the broker.
+func mqttClientID(cfg Config) string {
+	if cfg.MQTTClientID != "" {
+		return cfg.MQTTClientID
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = uuid.New().String()
+	}
+	return "audio-processor-" + host
+}
+
+// NewMQTTBridge checks that cfg.MQTTTopic names both the user and the
+// session level.
+func NewMQTTBridge(cfg Config, store *MemoryStore, jobs chan<- Job, sessions *SessionTracker, identity IdentityProvider) (*MQTTBridge, error) {
+	levels := strings.Split(cfg.MQTTTopic, "/")
+	filter := make([]string, len(levels))
+	var user, session int
+	for i, l := range levels {
+		switch l {
+		case "{user}":
+			user++
+		case "{session}":
+			session++
+		default:
+			if strings.ContainsAny(l, "+#{}") {
+				return nil, fmt.Errorf("MQTT topic %q: level %q is not a name, {user} or {session}", cfg.MQTTTopic, l)
+			}
+			filter[i] = l
+			continue
+		}
+		filter[i] = "+"
+	}
+	if user != 1 || session != 1 {
+		return nil, fmt.Errorf("MQTT topic %q must contain {user} and {session} once each", cfg.MQTTTopic)
+	}
+	return &MQTTBridge{
+		cfg:      cfg,
+		clientID: mqttClientID(cfg),
+		store:    store,
+		jobs:     jobs,
+		sessions: sessions,
+		identity: identity,
+		topic:    levels,
+		filter:   strings.Join(filter, "/"),
+		unacked:  make(map[uint16]string),
+	}, nil
+}
+
+// Run keeps a connection to the broker until ctx is cancelled,
+// reconnecting with exponential backoff capped at Config.MQTTMaxBackoff.
+// The mqtt_connected gauge is 1 while connected.
+func (b *MQTTBridge) Run(ctx context.Context) {
+	backoff := mqttMinBackoff
+	for {
+		err := b.connect(ctx, func() { backoff = mqttMinBackoff })
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("MQTT bridge disconnected from %s: %v; reconnecting in %v", b.cfg.MQTTBroker, err, backoff)
+		mqttStats.Add("reconnects", 1)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, b.cfg.MQTTMaxBackoff)
+	}
+}
+
+// connect serves one connection, calling connected once subscribed.
+func (b *MQTTBridge) connect(ctx context.Context, connected func()) error {
+	c, err := mqtt.Dial(ctx, b.cfg.MQTTBroker, mqtt.Options{ClientID: b.clientID, KeepAlive: mqttKeepAlive, MaxQueue: mqttMaxQueue})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if !c.SessionPresent() {
+		// Nothing from the lost session will be redelivered.
+		clear(b.unacked)
+	}
+	if err := c.Subscribe(ctx, b.filter, 1); err != nil {
+		return err
+	}
+	connected()
+	mqttConnected.Set(1)
+	defer mqttConnected.Set(0)
+	log.Printf("MQTT bridge subscribed to %s on %s", b.filter, b.cfg.MQTTBroker)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.Done():
+			return c.Err()
+		case m := <-c.Messages:
+			if err := b.handle(ctx, c, m); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// route reads the user and session from a chunk's topic and names the
+// topic its response goes to. It fails unless topic has the levels of
+// Config.MQTTTopic and the user and session levels are usable in a topic.
+func (b *MQTTBridge) route(topic string) (userID, sessionID, response string, ok bool) {
+	if strings.Count(topic, "/") != len(b.topic)-1 {
+		return "", "", "", false
+	}
+	levels := strings.Split(topic, "/")
+	for i, l := range b.topic {
+		switch l {
+		case "{user}":
+			userID = levels[i]
+		case "{session}":
+			sessionID = levels[i]
+		}
+	}
+	if userID == "" || sessionID == "" || strings.ContainsAny(userID+sessionID, "+#") {
+		return "", "", "", false
+	}
+	var out []string
+	for _, l := range strings.Split(b.cfg.MQTTResponseTopic, "/") {
+		switch l {
+		case "{user}":
+			l = userID
+		case "{session}":
+			l = sessionID
+		}
+		out = append(out, l)
+	}
+	return userID, sessionID, strings.Join(out, "/"), true
+}
+
+// handle ingests m and publishes the outcome, returning an error only if
+// the connection failed. A message on a topic it cannot route is
+// acknowledged and dropped, as there is nowhere to answer it.
+func (b *MQTTBridge) handle(ctx context.Context, c *mqtt.Client, m mqtt.Message) error {
+	mqttStats.Add("received", 1)
+	userID, sessionID, response, ok := b.route(m.Topic)
+	if !ok {
+		mqttStats.Add("unroutable", 1)
+		return c.Ack(m)
+	}
+
+	var body any
+	var meta Metadata
+	var err error
+	id, redelivered := b.unacked[m.PacketID]
+	if redelivered && m.Duplicate {
+		mqttStats.Add("duplicates", 1)
+		meta, err = b.store.Get(id)
+	} else {
+		meta, err = b.ingest(ctx, userID, sessionID, m.Payload)
+		if err == nil && m.QoS > 0 {
+			b.unacked[m.PacketID] = meta.ChunkID
+		}
+	}
+	if err != nil {
+		mqttStats.Add("failed", 1)
+		_, code := errorStatus(err)
+		body = map[string]any{"error": code, "message": errorMessage(err)}
+	} else {
+		body = meta
+	}
+	payload, _ := json.Marshal(body)
+	if err := c.Publish(ctx, response, payload, 1); err != nil {
+		return err
+	}
+	if err := c.Ack(m); err != nil {
+		return err
+	}
+	delete(b.unacked, m.PacketID)
+	return nil
+}
+
+func (b *MQTTBridge) ingest(ctx context.Context, userID, sessionID string, data []byte) (Metadata, error) {
+	if b.ReadOnly.Enabled() {
+		readOnlyRefusals.Add("mqtt", 1)
+		return Metadata{}, b.ReadOnly.err()
+	}
+	if err := validateIDs(b.cfg, userID, sessionID); err != nil {
+		return Metadata{}, err
+	}
+	if err := validateUser(ctx, b.identity, userID); err != nil {
+		return Metadata{}, err
+	}
+	return ingest(ctx, b.store, b.jobs, b.sessions, b.cfg.AudioRules, AudioChunk{
+		ChunkID:   uuid.New().String(),
+		UserID:    userID,
+		SessionID: sessionID,
+		Timestamp: time.Now(),
+		Data:      data,
+		Checksum:  chunkChecksum(data),
+	})
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/mqtt"
+)
+
+func startBroker(t *testing.T) (*mqtt.Broker, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen error: %v", err)
+	}
+	broker := mqtt.NewBroker()
+	go broker.Serve(ln)
+	t.Cleanup(func() { broker.Close() })
+	return broker, "tcp://" + ln.Addr().String()
+}
+
+func waitForMQTT(t *testing.T, connected int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for mqttConnected.Value() != connected {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected mqtt_connected to become %d", connected)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// device connects as an IoT device listening for its session's responses.
+func device(t *testing.T, ctx context.Context, url string) *mqtt.Client {
+	t.Helper()
+	c, err := mqtt.Dial(ctx, url, mqtt.Options{ClientID: "device"})
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	if err := c.Subscribe(ctx, "audio/user1/+/metadata", 1); err != nil {
+		t.Fatalf("Subscribe error: %v", err)
+	}
+	return c
+}
+
+func publishChunk(t *testing.T, ctx context.Context, c *mqtt.Client, topic string, data []byte) map[string]any {
+	t.Helper()
+	if err := c.Publish(ctx, topic, data, 1); err != nil {
+		t.Fatalf("Publish error: %v", err)
+	}
+	select {
+	case m := <-c.Messages:
+		c.Ack(m)
+		var reply map[string]any
+		if err := json.Unmarshal(m.Payload, &reply); err != nil {
+			t.Fatalf("Expected JSON on %s, but got %q", m.Topic, m.Payload)
+		}
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a response to %s", topic)
+		return nil
+	}
+}
+
+func TestMQTTBridge(t *testing.T) {
+	broker, url := startBroker(t)
+	cfg := DefaultConfig()
+	cfg.MQTTBroker = url
+	cfg.MQTTMaxBackoff = 50 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+	waitForMQTT(t, 1)
+	ctx := context.Background()
+	c := device(t, ctx, url)
+	defer c.Close()
+
+	wav := SineWAV(440, time.Second, 8000)
+	first := publishChunk(t, ctx, c, "audio/user1/dev1/chunk", wav)
+	if first["user_id"] != "user1" || first["session_id"] != "dev1" || first["transcript"] != "Hello World" {
+		t.Errorf("Expected the chunk's metadata, but got %v", first)
+	}
+	// The same audio sent again is a new chunk, not a redelivery.
+	if again := publishChunk(t, ctx, c, "audio/user1/dev1/chunk", wav); again["chunk_id"] == first["chunk_id"] {
+		t.Errorf("Expected repeated audio stored as a new chunk, but got %v", again)
+	}
+	if n := len(h.Store.ListByUser("user1")); n != 2 {
+		t.Errorf("Expected 2 stored chunks, but got %d", n)
+	}
+
+	// The bridge reconnects after losing the broker.
+	broker.DropClients()
+	waitForMQTT(t, 0)
+	waitForMQTT(t, 1)
+	c = device(t, ctx, url)
+	defer c.Close()
+	if reply := publishChunk(t, ctx, c, "audio/user1/"+strings.Repeat("s", 200)+"/chunk", wav); reply["error"] != "too_long" {
+		t.Errorf("Expected the invalid session ID to be refused, but got %v", reply)
+	}
+	if reply := publishChunk(t, ctx, c, "audio/user1/dev1/chunk", SineWAV(220, time.Second, 8000)); reply["chunk_id"] == nil {
+		t.Errorf("Expected chunks to flow after reconnecting, but got %v", reply)
+	}
+	if n := len(h.Store.ListByUser("user1")); n != 3 {
+		t.Errorf("Expected 3 stored chunks, but got %d", n)
+	}
+}
+
+func TestMQTTRoute(t *testing.T) {
+	b, err := NewMQTTBridge(DefaultConfig(), NewMemoryStore(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, session, response, ok := b.route("audio/user1/dev1/chunk"); !ok || user != "user1" || session != "dev1" || response != "audio/user1/dev1/metadata" {
+		t.Errorf("Expected user1/dev1 answered on its metadata topic, but got %q %q %q %v", user, session, response, ok)
+	}
+	for _, topic := range []string{"audio/user1/chunk", "audio/user1/dev1/chunk" + strings.Repeat("/x", 1000), "audio//dev1/chunk", "audio/user1/#/chunk"} {
+		if _, _, _, ok := b.route(topic); ok {
+			t.Errorf("Expected %.40q to be unroutable", topic)
+		}
+	}
+	if id := mqttClientID(DefaultConfig()); !strings.HasPrefix(id, "audio-processor-") {
+		t.Errorf("Expected a per-host client ID, but got %q", id)
+	}
+}
+
+func TestMQTTTopicPattern(t *testing.T) {
+	for topic, ok := range map[string]bool{
+		"audio/{user}/{session}/chunk":  true,
+		"{session}/{user}":              true,
+		"audio/{user}/chunk":            false,
+		"audio/+/{user}/{session}":      false,
+		"audio/{user}/{user}/{session}": false,
+	} {
+		cfg := DefaultConfig()
+		cfg.MQTTTopic = topic
+		if _, err := NewMQTTBridge(cfg, NewMemoryStore(), nil, nil, nil); (err == nil) != ok {
+			t.Errorf("%s: expected ok=%v, but got %v", topic, ok, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// Loudness targets accepted by ?normalize=, in dBFS. Loudness is measured
+// as RMS level, a rough stand-in for integrated LUFS.
+const (
+	minNormalizeTarget = -70.0
+	maxNormalizeTarget = 0.0
+)
+
+// parseNormalize reads the normalize parameter of an audio download; ok is
+// false when there is none.
+func parseNormalize(r *http.Request) (target float64, ok bool, err error) {
+	v := r.URL.Query().Get("normalize")
+	if v == "" {
+		return 0, false, nil
+	}
+	target, err = strconv.ParseFloat(v, 64)
+	if err != nil || target < minNormalizeTarget || target > maxNormalizeTarget {
+		return 0, false, invalidParam("normalize", "invalid_normalize",
+			fmt.Sprintf("must be a loudness from %g to %g dBFS", minNormalizeTarget, maxNormalizeTarget))
+	}
+	return target, true, nil
+}
+
+// SetLoudness records a chunk's measured loudness. It is a cached
+// measurement rather than a change to the chunk, so no change is recorded.
+func (s *MemoryStore) SetLoudness(id string, db float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.metadata[id]; ok {
+		m.LoudnessDBFS = &db
+		s.metadata[id] = m
+	}
+}
+
+// normalizeAudio returns data as a 16-bit mono WAV with its RMS level moved
+// to target, and the gain applied in dB. The gain is cut back as far as
+// needed to keep the peak from clipping. The chunk's stored loudness is
+// used when it has one; otherwise it is measured and stored. Silence comes
+// back unchanged.
+func normalizeAudio(store *MemoryStore, meta Metadata, data []byte, target float64) ([]byte, float64, error) {
+	pcm, err := decodeAudio(data)
+	if err != nil {
+		return nil, 0, invalidField("normalize", "unsupported_audio", "only WAV and FLAC audio can be normalized")
+	}
+	var loudness float64
+	measured := meta.LoudnessDBFS != nil
+	if measured {
+		loudness = *meta.LoudnessDBFS
+	} else {
+		db, ok := loudnessDBFS(pcm)
+		if ok {
+			loudness, measured = math.Round(db*100)/100, true
+			store.SetLoudness(meta.ChunkID, loudness)
+		}
+	}
+	peak := 0.0
+	for _, s := range pcm.Samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	gainDB := 0.0
+	if measured && peak > 0 {
+		gainDB = math.Min(target-loudness, -20*math.Log10(peak))
+	}
+	gain := math.Pow(10, gainDB/20)
+	samples := make([]int16, len(pcm.Samples))
+	for i, s := range pcm.Samples {
+		samples[i] = int16(math.Max(-1, math.Min(1, s*gain)) * math.MaxInt16)
+	}
+	return EncodeWAV(samples, pcm.SampleRate), gainDB, nil
+}
+
+// writeChunkAudio serves a chunk's stored audio, normalized and encoded by
+// tc if the request asks for it. The stored bytes are never changed.
+func writeChunkAudio(w http.ResponseWriter, r *http.Request, store *MemoryStore, tc *Transcoder, meta Metadata) {
+	target, normalize, err := parseNormalize(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	encode, transcode, err := tc.parseFormat(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	data, err := store.GetBlob(meta.ChunkID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if transcode {
+		tc.write(w, r, store, meta, data, encode, normalize, target)
+		return
+	}
+	if !normalize {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+		return
+	}
+	out, gainDB, err := normalizeAudio(store, meta, data, target)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("X-Applied-Gain-DB", strconv.FormatFloat(gainDB, 'f', 2, 64))
+	w.Write(out)
+}
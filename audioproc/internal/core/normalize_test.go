@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func downloadAudio(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
+
+func rmsDBFS(t *testing.T, data []byte) float64 {
+	t.Helper()
+	pcm, err := decodeAudio(data)
+	if err != nil {
+		t.Fatalf("Downloaded audio does not decode: %v", err)
+	}
+	db, _ := loudnessDBFS(pcm)
+	return db
+}
+
+func TestNormalizedDownload(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	quiet := samplesWAV(8000, func(i int) float64 { return 0.05 * math.Sin(2*math.Pi*440*float64(i)/8000) })
+	meta := uploadTo(t, h, "user1", "s1", quiet)
+
+	for _, target := range []float64{-16, -30} {
+		resp, body := downloadAudio(t, h.URL+"/chunks/"+meta.ChunkID+"/audio?normalize="+strconv.FormatFloat(target, 'f', -1, 64))
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "audio/wav" {
+			t.Fatalf("Expected normalized WAV, but got %v %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if got := rmsDBFS(t, body); math.Abs(got-target) > 0.5 {
+			t.Errorf("Expected audio at %v dBFS, but it measures %.2f", target, got)
+		}
+		gain, _ := strconv.ParseFloat(resp.Header.Get("X-Applied-Gain-DB"), 64)
+		if math.Abs(gain-(target-*meta.LoudnessDBFS)) > 0.01 {
+			t.Errorf("Expected a gain of %.2f dB in the header, but got %q", target-*meta.LoudnessDBFS, resp.Header.Get("X-Applied-Gain-DB"))
+		}
+	}
+
+	if _, raw := downloadAudio(t, h.URL+"/chunks/"+meta.ChunkID+"/audio"); !bytes.Equal(raw, quiet) {
+		t.Error("Expected the plain download to return the uploaded bytes")
+	}
+	if stored, _ := h.Store.GetBlob(meta.ChunkID); !bytes.Equal(stored, quiet) {
+		t.Error("Expected the stored audio to be unchanged")
+	}
+	if resp, _ := downloadAudio(t, h.URL+"/chunks/"+meta.ChunkID+"/audio?normalize=loud"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid target, but got %v", resp.StatusCode)
+	}
+}
+
+func TestNormalizeClampsGainToAvoidClipping(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	// A 0.9 peak sine sits near -3.9 dBFS; 0 dBFS would need a peak of 1.41.
+	loud := samplesWAV(8000, func(i int) float64 { return 0.9 * math.Sin(2*math.Pi*440*float64(i)/8000) })
+	meta := uploadTo(t, h, "user1", "s1", loud)
+
+	resp, body := downloadAudio(t, h.URL+"/chunks/"+meta.ChunkID+"/audio?normalize=0")
+	gain, _ := strconv.ParseFloat(resp.Header.Get("X-Applied-Gain-DB"), 64)
+	if want := -20 * math.Log10(0.9); math.Abs(gain-want) > 0.05 {
+		t.Errorf("Expected the gain to stop at %.2f dB, but got %v", want, gain)
+	}
+	pcm, _ := decodeAudio(body)
+	clipped := 0
+	for _, s := range pcm.Samples {
+		if math.Abs(s) >= 1 {
+			clipped++
+		}
+	}
+	if clipped > 0 {
+		t.Errorf("Expected no clipped samples, but got %d", clipped)
+	}
+}
+
+// Chunks processed before loudness was recorded are measured on their first
+// normalized download, and the measurement is kept.
+func TestNormalizeStoresMissingMeasurement(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.ShareSecret = "share-secret"
+	h := NewHarness(cfg)
+	defer h.Close()
+	data := SineWAV(440, 500*time.Millisecond, 8000)
+	h.Store.Save(Metadata{ChunkID: "old", UserID: "user1", SessionID: "s1", Timestamp: time.Now()})
+	h.Store.SaveBlob("old", data)
+	changes := h.Store.Changes(0, 100)
+
+	_, _, token := createShare(t, h, "/sessions/user1/s1/share", "")
+	resp, body := downloadAudio(t, h.URL+"/shared/"+token+"/chunks/old/audio?normalize=-20")
+	if resp.StatusCode != http.StatusOK || math.Abs(rmsDBFS(t, body)-(-20)) > 0.5 {
+		t.Fatalf("Expected shared audio normalized to -20 dBFS, but got %v", resp.StatusCode)
+	}
+	meta, _ := h.Store.Get("old")
+	if meta.LoudnessDBFS == nil || math.Abs(*meta.LoudnessDBFS-(-9.03)) > 0.05 {
+		t.Errorf("Expected the measured loudness to be stored, but got %v", meta.LoudnessDBFS)
+	}
+	if after := h.Store.Changes(0, 100); len(after.Changes) != len(changes.Changes) {
+		t.Errorf("Expected storing the measurement not to count as a change")
+	}
+}
+
+// TestNormalizeTrustsFullScaleMeasurement checks that a stored loudness
+// of 0 dBFS counts as measured and is not measured again.
+func TestNormalizeTrustsFullScaleMeasurement(t *testing.T) {
+	store := NewMemoryStore()
+	full := 0.0
+	meta := Metadata{ChunkID: "loud", UserID: "user1", SessionID: "s1", LoudnessDBFS: &full}
+	store.Save(meta)
+	_, gainDB, err := normalizeAudio(store, meta, SineWAV(440, 100*time.Millisecond, 8000), -20)
+	if err != nil || gainDB != -20 {
+		t.Errorf("Expected a gain of -20 dB from the stored 0 dBFS, but got %v %v", gainDB, err)
+	}
+	if m, _ := store.Get("loud"); m.LoudnessDBFS == nil || *m.LoudnessDBFS != 0 {
+		t.Errorf("Expected the stored measurement kept, but got %v", m.LoudnessDBFS)
+	}
+}
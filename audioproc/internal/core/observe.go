@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// observeQueue is how many live events wait for an observer before further
+// ones are dropped for it; replayed events get room on top of this.
+const observeQueue = 64
+
+// observeFrame is what an observer receives for each event about the
+// session. Replayed frames come from the change feed and precede the live
+// ones; Seq is the change feed position of chunk events.
+type observeFrame struct {
+	Type     string          `json:"type"`
+	Seq      int64           `json:"seq,omitempty"`
+	At       time.Time       `json:"at"`
+	Replayed bool            `json:"replayed,omitempty"`
+	Chunk    *Metadata       `json:"chunk,omitempty"`
+	Session  *SessionSummary `json:"session,omitempty"`
+}
+
+// sessionChanges returns the last n chunk changes of a session that map to
+// events, oldest first.
+func (s *MemoryStore) sessionChanges(userID, sessionID string, n int) []Change {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var changes []Change
+	for i := len(s.changes) - 1; i >= 0 && len(changes) < n; i-- {
+		c := s.changes[i]
+		if m := c.Metadata; m != nil && m.UserID == userID && m.SessionID == sessionID && chunkEventType(c.Op, *m) != "" {
+			changes = append(changes, c)
+		}
+	}
+	for i, j := 0, len(changes)-1; i < j; i, j = i+1, j-1 {
+		changes[i], changes[j] = changes[j], changes[i]
+	}
+	return changes
+}
+
+// handleObserve streams a session's chunk events and its close to a
+// WebSocket observer. With replay=N it first sends the session's last N
+// chunk events, up to Config.ObserveReplayMax, marked replayed. It
+// subscribes before reading the change feed, so nothing is missed at the
+// seam, and skips the live events whose changes it replayed.
+//
+// Producers streaming into the session are told how many observers it has
+// whenever one attaches or detaches, and both are audited: the people being
+// recorded may have to be told that someone is listening.
+func handleObserve(store *MemoryStore, bus *Bus, keys *KeyRing, conns *wsConns, g *Goroutines, cfg Config) http.HandlerFunc {
+	upgrader := newUpgrader(cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := checkHandshake(cfg, keys, false, w, r)
+		if !ok {
+			return
+		}
+		userID, sessionID := mux.Vars(r)["user_id"], mux.Vars(r)["session_id"]
+		if err := validateIDs(cfg, userID, sessionID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		replay := 0
+		if v := r.URL.Query().Get("replay"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, invalidParam("replay", "invalid_replay", "replay must be a non-negative integer"))
+				return
+			}
+			replay = min(n, cfg.ObserveReplayMax)
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		ws.NetConn().SetDeadline(time.Time{})
+		actor := "admin"
+		if key, _ := keys.Authenticate(r); key.UserID != "" {
+			actor = key.UserID
+		}
+		key := sessionKey(userID, sessionID)
+		store.RecordAudit(AuditEvent{Actor: actor, Action: "session.observe", UserID: userID, SessionID: sessionID})
+		conns.observerJoined(key)
+		defer func() {
+			conns.observerLeft(key)
+			store.RecordAudit(AuditEvent{Actor: actor, Action: "session.unobserve", UserID: userID, SessionID: sessionID})
+		}()
+		ctx, done := g.TrackStream(r.Context(), "observe")
+		defer done()
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		defer context.AfterFunc(ctx, func() { ws.Close() })()
+		// Observers only listen; reading notices when they hang up.
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := ws.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		events := make(chan Event)
+		unsubscribe := bus.Subscribe("observe", observeQueue+replay, func(ev Event) {
+			if !eventInSession(ev, userID, sessionID) {
+				return
+			}
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+			}
+		}, EventChunkProcessed, EventChunkFailed, EventChunkDeleted, EventSessionClosed)
+		defer unsubscribe()
+		defer cancel()
+
+		history := store.sessionChanges(userID, sessionID, replay)
+		replayed := make(map[int64]bool, len(history))
+		for _, c := range history {
+			replayed[c.Seq] = true
+			frame := observeFrame{Type: chunkEventType(c.Op, *c.Metadata), Seq: c.Seq, At: c.At.UTC(), Replayed: true, Chunk: c.Metadata}
+			if ws.WriteJSON(frame) != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-events:
+				if ev.Chunk != nil && replayed[ev.Seq] {
+					continue
+				}
+				if ws.WriteJSON(observeFrame{Type: ev.Type, Seq: ev.Seq, At: ev.At.UTC(), Chunk: ev.Chunk, Session: ev.Session}) != nil {
+					return
+				}
+				if ev.Type == EventSessionClosed {
+					ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session closed"), time.Now().Add(time.Second))
+					return
+				}
+			}
+		}
+	}
+}
+
+// isObservePath reports whether path is a session observer's, before the
+// router has matched it.
+func isObservePath(path string) bool {
+	return strings.HasPrefix(path, "/sessions/") && strings.HasSuffix(path, "/observe")
+}
+
+func eventInSession(ev Event, userID, sessionID string) bool {
+	switch {
+	case ev.Chunk != nil:
+		return ev.Chunk.UserID == userID && ev.Chunk.SessionID == sessionID
+	case ev.Session != nil:
+		return ev.Session.UserID == userID && ev.Session.SessionID == sessionID
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestObserveReplaysThenGoesLive(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	var ids []string
+	for range 3 {
+		ids = append(ids, uploadTo(t, h, "user1", "s1", wav).ChunkID)
+	}
+	uploadTo(t, h, "user1", "other", wav)
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe?replay=2"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var seq int64
+	next := func() observeFrame {
+		t.Helper()
+		var f observeFrame
+		if err := conn.ReadJSON(&f); err != nil {
+			t.Fatal(err)
+		}
+		if f.Chunk != nil {
+			if f.Seq <= seq {
+				t.Errorf("Expected seq to grow past %d, but got %d", seq, f.Seq)
+			}
+			seq = f.Seq
+		}
+		return f
+	}
+	for _, id := range ids[1:] {
+		if f := next(); !f.Replayed || f.Type != EventChunkProcessed || f.Chunk.ChunkID != id {
+			t.Errorf("Expected %s replayed, but got %+v", id, f)
+		}
+	}
+
+	// Uploads during and after the replay arrive once each, live.
+	live := uploadTo(t, h, "user1", "s1", wav).ChunkID
+	uploadTo(t, h, "user1", "other", wav)
+	if f := next(); f.Replayed || f.Chunk == nil || f.Chunk.ChunkID != live {
+		t.Errorf("Expected %s live, but got %+v", live, f)
+	}
+	h.Sessions.End("user1", "s1")
+	if f := next(); f.Type != EventSessionClosed || f.Replayed || f.Session == nil {
+		t.Errorf("Expected session_closed, but got %+v", f)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the observer to be closed, but got %v", err)
+	}
+}
+
+func TestObserveReplayIsBounded(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ObserveReplayMax = 1
+	h := NewHarness(cfg)
+	defer h.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	uploadTo(t, h, "user1", "s1", wav)
+	last := uploadTo(t, h, "user1", "s1", wav).ChunkID
+
+	if _, resp, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe?replay=-1"), nil); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a negative replay, but got %v", err)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe?replay=50"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var f observeFrame
+	if err := conn.ReadJSON(&f); err != nil || !f.Replayed || f.Chunk.ChunkID != last {
+		t.Fatalf("Expected only %s replayed, but got %+v %v", last, f, err)
+	}
+	h.Sessions.End("user1", "s1")
+	if err := conn.ReadJSON(&f); err != nil || f.Type != EventSessionClosed {
+		t.Errorf("Expected session_closed after one replayed frame, but got %+v %v", f, err)
+	}
+}
+
+// TestObserveSkipsOnlyReplayedEvents delivers a chunk's event late, as a
+// busy bus would after the replay was read: the observer must still get
+// it, since the replay didn't cover it, but not a repeat of one it did.
+func TestObserveSkipsOnlyReplayedEvents(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	uploadTo(t, h, "user1", "s1", wav)
+	uploadTo(t, h, "user1", "s1", wav)
+	changes := h.Store.sessionChanges("user1", "s1", 2)
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe?replay=1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var f observeFrame
+	if err := conn.ReadJSON(&f); err != nil || !f.Replayed || f.Seq != changes[1].Seq {
+		t.Fatalf("Expected seq %d replayed, but got %+v %v", changes[1].Seq, f, err)
+	}
+
+	for _, c := range []Change{changes[1], changes[0]} {
+		h.Store.Events.Publish(Event{Type: EventChunkProcessed, Seq: c.Seq, At: c.At, Chunk: c.Metadata})
+	}
+	h.Sessions.End("user1", "s1")
+	f = observeFrame{}
+	if err := conn.ReadJSON(&f); err != nil || f.Replayed || f.Seq != changes[0].Seq {
+		t.Errorf("Expected seq %d live, but got %+v %v", changes[0].Seq, f, err)
+	}
+	if err := conn.ReadJSON(&f); err != nil || f.Type != EventSessionClosed {
+		t.Errorf("Expected session_closed, but got %+v %v", f, err)
+	}
+}
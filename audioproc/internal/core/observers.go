@@ -0,0 +1,118 @@
+package core
+
+import (
+	"maps"
+	"time"
+)
+
+// observerWriteTimeout bounds the write of an observer count to one
+// producer, so a client that stops reading holds up nobody else.
+const observerWriteTimeout = time.Second
+
+// observerUpdate is the frame telling a producer how many observers are
+// listening to its session.
+func observerUpdate(count int) map[string]any {
+	return map[string]any{"type": "observer_update", "count": count}
+}
+
+// observerJoined counts an observer attaching to the session keyed by key
+// and tells every connection streaming into it the new count. Each count
+// is numbered under the shard lock but written after it is released, and
+// a connection skips counts older than one it was already sent, so the
+// last count a producer sees is the true one however attaches and
+// detaches race.
+func (t *wsConns) observerJoined(key string) {
+	t.updateObservers(key, 1)
+}
+
+// observerLeft undoes observerJoined.
+func (t *wsConns) observerLeft(key string) {
+	t.updateObservers(key, -1)
+}
+
+func (t *wsConns) updateObservers(key string, delta int) {
+	sh := t.shard(key)
+	sh.mu.Lock()
+	n := sh.observers[key] + delta
+	if n > 0 {
+		sh.observers[key] = n
+	} else {
+		delete(sh.observers, key)
+	}
+	seq := t.observerSeq.Add(1)
+	targets := make([]*wsConn, 0, len(sh.conns[key]))
+	for c := range sh.conns[key] {
+		targets = append(targets, c)
+	}
+	sh.mu.Unlock()
+	for _, c := range targets {
+		c.sendObservers(seq, n)
+	}
+}
+
+// sendObservers tells c its session has n observers, unless it was
+// already sent a count numbered after seq.
+func (c *wsConn) sendObservers(seq uint64, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if seq <= c.observerSeq {
+		return
+	}
+	c.observerSeq = seq
+	c.Conn.SetWriteDeadline(time.Now().Add(observerWriteTimeout))
+	c.Conn.WriteJSON(observerUpdate(n))
+	c.Conn.SetWriteDeadline(time.Time{})
+}
+
+// switchSession moves c to another session, as a hello does, and tells it
+// the new session's observer count if that differs from the old one's,
+// writing it after the shards are unlocked.
+// When the sessions are in different shards both are locked, in shard
+// order.
+func (t *wsConns) switchSession(c *wsConn, userID, sessionID string) {
+	to := sessionKey(userID, sessionID)
+	var seq uint64
+	n := -1
+	for {
+		from, _ := c.attachment()
+		i, j := sessionShard(from, len(t.shards)), sessionShard(to, len(t.shards))
+		a, b := t.shards[min(i, j)], t.shards[max(i, j)]
+		a.mu.Lock()
+		if b != a {
+			b.mu.Lock()
+		}
+		now, _ := c.attachment()
+		if now == from {
+			src, dst := t.shards[i], t.shards[j]
+			if src.delete(from, c) {
+				dst.insert(to, c)
+			}
+			c.setSession(userID, sessionID)
+			if dst.observers[to] != src.observers[from] {
+				n, seq = dst.observers[to], t.observerSeq.Add(1)
+			}
+		}
+		if b != a {
+			b.mu.Unlock()
+		}
+		a.mu.Unlock()
+		if now == from {
+			break
+		}
+	}
+	if n >= 0 {
+		c.sendObservers(seq, n)
+	}
+}
+
+// observerCounts returns how many observers each session has, by
+// sessionKey, leaving out sessions without any.
+func (t *wsConns) observerCounts() map[string]int {
+	out := make(map[string]int)
+	for _, sh := range t.shards {
+		sh.mu.Lock()
+		maps.Copy(out, sh.observers)
+		sh.mu.Unlock()
+	}
+	return out
+}
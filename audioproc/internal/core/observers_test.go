@@ -0,0 +1,187 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/gorilla/websocket"
+)
+
+// readAck reads frames from a producer's connection up to the next one
+// that is not an observer update.
+func readAck(conn *websocket.Conn) (client.Ack, error) {
+	for {
+		var ack client.Ack
+		if err := conn.ReadJSON(&ack); err != nil || ack.Type != "observer_update" {
+			return ack, err
+		}
+	}
+}
+
+func readObserverCount(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var update struct {
+		Type  string `json:"type"`
+		Count int    `json:"count"`
+	}
+	if err := conn.ReadJSON(&update); err != nil || update.Type != "observer_update" {
+		t.Fatalf("Expected an observer update, but got %+v %v", update, err)
+	}
+	return update.Count
+}
+
+func TestObserverCounts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 50*time.Millisecond, 8000))
+	producer, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	var observers []*websocket.Conn
+	for range 2 {
+		observer, _, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer observer.Close()
+		observers = append(observers, observer)
+	}
+	var counts []int
+	counts = append(counts, readObserverCount(t, producer), readObserverCount(t, producer))
+	observers[0].Close()
+	counts = append(counts, readObserverCount(t, producer))
+	if counts[0] != 1 || counts[1] != 2 || counts[2] != 1 {
+		t.Errorf("Expected the producer to see counts 1, 2, 1, but got %v", counts)
+	}
+
+	var page AdminSessionPage
+	adminDo(t, h, "GET", "/admin/sessions", nil, &page)
+	if len(page.Sessions) != 1 || page.Sessions[0].Observers != 1 {
+		t.Errorf("Expected the session listed with 1 observer, but got %+v", page.Sessions)
+	}
+	events := h.Store.AuditEvents(func(e AuditEvent) bool { return e.SessionID == "s1" })
+	var observed, unobserved int
+	for _, e := range events {
+		switch e.Action {
+		case "session.observe":
+			observed++
+		case "session.unobserve":
+			unobserved++
+		}
+	}
+	if observed != 2 || unobserved != 1 {
+		t.Errorf("Expected 2 attaches and 1 detach audited, but got %d and %d", observed, unobserved)
+	}
+
+	late, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	if n := readObserverCount(t, late); n != 1 {
+		t.Errorf("Expected a producer connecting later to be told of the observer, but got %d", n)
+	}
+}
+
+func TestObserverCountsRace(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	producer, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+
+	// Each observer attaches and detaches; every other one stays.
+	const n = 20
+	var wg sync.WaitGroup
+	kept := make(chan *websocket.Conn, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			observer, _, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe"), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if i%2 == 0 {
+				observer.Close()
+			} else {
+				kept <- observer
+			}
+		}()
+	}
+	wg.Wait()
+	close(kept)
+	for observer := range kept {
+		defer observer.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && h.wsConns.observerCounts()[sessionKey("user1", "s1")] != n/2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := h.wsConns.observerCounts()[sessionKey("user1", "s1")]; got != n/2 {
+		t.Fatalf("Expected %d observers, but got %d", n/2, got)
+	}
+	// Counts overtaken by a later one may be skipped, but the last one the
+	// producer is sent is the final count.
+	last := -1
+	for {
+		producer.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		var update struct {
+			Count int `json:"count"`
+		}
+		if producer.ReadJSON(&update) != nil {
+			break
+		}
+		last = update.Count
+	}
+	if last != n/2 {
+		t.Errorf("Expected the producer's last count to be %d, but got %d", n/2, last)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: SineWAV(440, 50*time.Millisecond, 8000), Seq: 1})
+	if ack, err := readAck(conn); err != nil || !ack.Ack {
+		t.Errorf("Expected the chunk acked past the observer update, but got %+v %v", ack, err)
+	}
+}
+
+func TestObserverCountsSkipStale(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	producer, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	var c *wsConn
+	for deadline := time.Now().Add(5 * time.Second); c == nil && time.Now().Before(deadline); {
+		h.wsConns.each(func(conn *wsConn) { c = conn })
+		time.Sleep(time.Millisecond)
+	}
+	if c == nil {
+		t.Fatal("Expected the producer's connection to be tracked")
+	}
+
+	// A count written late, after a later one was sent, is skipped.
+	c.sendObservers(5, 2)
+	c.sendObservers(4, 7)
+	c.sendObservers(6, 1)
+	if got := []int{readObserverCount(t, producer), readObserverCount(t, producer)}; got[0] != 2 || got[1] != 1 {
+		t.Errorf("Expected counts 2 then 1, but got %v", got)
+	}
+}
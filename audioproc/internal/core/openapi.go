@@ -0,0 +1,436 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1/audioprocessorv1connect"
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+// apiOperation documents one route for the OpenAPI description. Request
+// and Response are zero values whose types are turned into JSON schemas;
+// RequestType or ResponseType names a non-JSON body instead.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Tag     string
+	Summary string
+	// Admin routes need X-Admin-Token; Public ones need no API key.
+	Admin  bool
+	Public bool
+	Query  []apiParam
+
+	Request      any
+	RequestType  string
+	Status       int
+	Response     any
+	ResponseType string
+}
+
+type apiParam struct {
+	Name        string
+	Type        string
+	Description string
+}
+
+// Response bodies that handlers build as maps.
+type (
+	deletedCount struct {
+		Deleted int `json:"deleted"`
+	}
+	restoredCount struct {
+		Restored int `json:"restored"`
+	}
+	transcriptBody struct {
+		Text    string   `json:"text"`
+		Words   []Word   `json:"words"`
+		Markers []Marker `json:"markers,omitempty"`
+	}
+	readiness struct {
+		Status   string                `json:"status"`
+		Indexes  IndexStatus           `json:"indexes"`
+		InFlight map[string]int        `json:"in_flight"`
+		Stages   map[string]StageState `json:"stages"`
+		Warmups  []WarmupStatus        `json:"warmups"`
+		ReadOnly bool                  `json:"read_only"`
+	}
+	errorBody struct {
+		Error   string       `json:"error"`
+		Message string       `json:"message"`
+		Fields  []FieldError `json:"fields,omitempty"`
+	}
+)
+
+var (
+	fieldsParam    = apiParam{"fields", "string", "Comma-separated Metadata fields to return."}
+	userParam      = apiParam{"user_id", "string", "Only chunks of this user."}
+	transcriptFmt  = apiParam{"format", "string", "json (default), srt or vtt."}
+	normalizeParam = apiParam{"normalize", "number", "Target loudness in dBFS; the audio comes back as WAV, or in the requested format, with the gain applied in X-Applied-Gain-DB."}
+	audioFmtParam  = apiParam{"format", "string", "Encode the audio on the fly, e.g. mp3 or opus, streaming it as it is encoded. Formats the server does not offer get 406."}
+	bitrateParam   = apiParam{"bitrate", "integer", "Bitrate in kbps for format, from those it offers; 406 otherwise. Defaults to the format's own default."}
+	compressParam  = apiParam{"compress", "boolean", "Gzip the audio when Accept-Encoding allows it; other responses are compressed without asking."}
+	monthParam     = apiParam{"month", "string", "YYYY-MM; defaults to the current month."}
+	audioType      = "application/octet-stream, audio/wav, audio/mpeg, audio/ogg"
+	chunkList      = []Metadata{}
+	transcriptType = "application/json, application/x-subrip, text/vtt"
+	// Connect messages are defined in proto/audioprocessor/v1/audio.proto.
+	connectType = "application/proto, application/json"
+)
+
+// apiOperations lists every route newRouter registers. TestOpenAPICoversRoutes
+// fails when the two drift apart.
+var apiOperations = []apiOperation{
+	{Method: "POST", Path: "/upload", Tag: "chunks", Summary: "Upload a chunk of audio. Audio longer than the chunk duration limit is split and answered with a SplitUpload. An X-Request-Deadline or grpc-timeout header bounds the wait; a chunk not processed in time is dropped with 504 and how far it got.",
+		Query: []apiParam{
+			{"user_id", "string", "Owner of the chunk."},
+			{"session_id", "string", "Session the chunk belongs to."},
+			{"priority", "string", "batch, interactive (default) or realtime; realtime needs the admin token."},
+			{"chunk_id", "string", "The client's own ID for the chunk: a UUID or a match for the configured pattern. Reusing one answers with the existing chunk if the audio is the same and 409 otherwise."},
+			{"allow_empty", "boolean", "Answer an empty body with 204 as a heartbeat that keeps the session open, instead of refusing it with 422."},
+			{"recorded_at", "string", "When the audio was recorded, as an RFC 3339 time, for recordings uploaded after the fact."},
+		},
+		RequestType: "application/octet-stream", Response: Metadata{}},
+	{Method: "GET", Path: "/chunks/{id}", Tag: "chunks", Summary: "Get a chunk's metadata.", Query: []apiParam{fieldsParam}, Response: Metadata{}},
+	{Method: "DELETE", Path: "/chunks/{id}", Tag: "chunks", Summary: "Move a chunk to the trash.", Status: http.StatusNoContent},
+	{Method: "PATCH", Path: "/chunks/{id}", Tag: "chunks", Summary: "Correct a chunk's transcript or client metadata.", Request: chunkPatch{}, Response: Metadata{}},
+	{Method: "GET", Path: "/chunks/{id}/revisions", Tag: "chunks", Summary: "List the kept versions of a chunk's metadata, oldest first.", Response: []Revision{}},
+	{Method: "GET", Path: "/chunks/{id}/audio", Tag: "chunks", Summary: "Download a chunk's audio. A URL from POST /chunks/{id}/signed-url works without an API key; bad or expired signatures get 403.", Query: []apiParam{normalizeParam, audioFmtParam, bitrateParam, compressParam}, ResponseType: audioType},
+	{Method: "POST", Path: "/chunks/{id}/signed-url", Tag: "chunks", Summary: "Get a time-limited URL for a chunk's audio that needs no API key, optionally bound to the caller's IP.", Request: signedURLRequest{}, Status: http.StatusCreated, Response: SignedURL{}},
+	{Method: "GET", Path: "/chunks/{id}/similar", Tag: "chunks", Summary: "List acoustically similar chunks.",
+		Query: []apiParam{{"all_users", "boolean", "Search every user's chunks; needs the admin token."}}, Response: []SimilarChunk{}},
+	{Method: "GET", Path: "/checksums/{sha256}", Tag: "chunks", Summary: "List chunks whose audio has this SHA-256, oldest first.",
+		Query: []apiParam{userParam, {"all_users", "boolean", "Search every user's chunks; needs the admin token."}}, Response: chunkList},
+	{Method: "GET", Path: "/search", Tag: "chunks", Summary: "Search transcripts or embedded tags, best matches first.",
+		Query: []apiParam{
+			{"q", "string", "Words to look for; a run of them in order ranks higher."},
+			{"scope", "string", "transcript (default) or tags, to match the title, artist, album and date embedded in the uploaded file."},
+			userParam,
+			{"all_users", "boolean", "Search every user's chunks; needs the admin token."},
+			{"limit", "integer", "Maximum results to return."},
+		},
+		Response: []SearchHit{}},
+	{Method: "POST", Path: "/chunks/{id}/trim", Tag: "chunks", Summary: "Save a span of a chunk's audio, cut on sample boundaries, as a new chunk derived from it. Spans beyond the audio are refused with 422.", Request: trimRequest{}, Status: http.StatusCreated, Response: Metadata{}},
+	{Method: "POST", Path: "/chunks/{id}/annotations", Tag: "annotations", Summary: "Annotate a chunk.", Request: Annotation{}, Status: http.StatusCreated, Response: Annotation{}},
+	{Method: "GET", Path: "/chunks/{id}/annotations", Tag: "annotations", Summary: "List a chunk's annotations.", Response: []Annotation{}},
+	{Method: "DELETE", Path: "/chunks/{id}/annotations/{annotation_id}", Tag: "annotations", Summary: "Delete an annotation.", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/chunks/{id}/transcript", Tag: "transcripts", Summary: "Get a chunk's transcript.", Query: []apiParam{transcriptFmt}, Response: transcriptBody{}, ResponseType: transcriptType},
+	{Method: "POST", Path: "/chunks/{id}/restore", Tag: "chunks", Summary: "Restore a chunk from the trash.", Response: Metadata{}},
+	{Method: "POST", Path: "/chunks/{id}/review", Tag: "transcripts", Summary: "Mark a chunk reviewed, saving the corrected transcript, if given, as a new revision. The chunk leaves the review queue.", Request: reviewRequest{}, Response: Metadata{}},
+	{Method: "GET", Path: "/review/queue", Tag: "transcripts", Summary: "List the chunks whose transcript confidence is under the review threshold and that are not reviewed yet, lowest confidence first.",
+		Query: []apiParam{
+			userParam,
+			{"all_users", "boolean", "List every user's chunks; needs the admin token."},
+			{"limit", "integer", "Maximum chunks to return."},
+		},
+		Response: ReviewQueuePage{}},
+	{Method: "POST", Path: "/chunks/{id}/compare", Tag: "admin", Summary: "Reprocess a chunk's stored audio without saving, and diff the result against its metadata.", Admin: true, Response: ChunkComparison{}},
+	{Method: "GET", Path: "/sessions/{user_id}", Tag: "sessions", Summary: "List a user's chunks.",
+		Query: []apiParam{
+			fieldsParam,
+			{"include_deleted", "boolean", "Include trashed chunks; needs the admin token."},
+			{"include_annotations", "boolean", "Attach each chunk's annotations."},
+			{"collapse", "string", "children groups split chunks under their parent."},
+		},
+		Response: chunkList},
+	{Method: "DELETE", Path: "/sessions/{user_id}/{session_id}", Tag: "sessions", Summary: "Move every chunk of a session to the trash.", Response: deletedCount{}},
+	{Method: "GET", Path: "/sessions/{user_id}/{session_id}/transcript", Tag: "transcripts", Summary: "Get a session's transcript.", Query: []apiParam{transcriptFmt}, Response: transcriptBody{}, ResponseType: transcriptType},
+	{Method: "GET", Path: "/sessions/{user_id}/{session_id}/latest", Tag: "sessions", Summary: "Get a session's newest chunk.", Response: Metadata{}},
+	{Method: "GET", Path: "/sessions/{user_id}/{session_id}/latest/transcript", Tag: "transcripts", Summary: "Get the transcript of a session's newest chunk, with an ETag for If-None-Match polling.", Response: latestTranscript{}},
+	{Method: "POST", Path: "/sessions/{user_id}/{session_id}/unarchive", Tag: "sessions", Summary: "Bring a session's audio back from the archive.", Response: restoredCount{}},
+	{Method: "POST", Path: "/sessions/{user_id}/{session_id}/share", Tag: "sharing", Summary: "Create a share link for a session, optionally good for a limited number of audio downloads.", Admin: true,
+		Request: shareRequest{}, Status: http.StatusCreated, Response: createdShare{}},
+	{Method: "DELETE", Path: "/sessions/{user_id}/{session_id}/share/{share_id}", Tag: "sharing", Summary: "Revoke a share link.", Admin: true, Status: http.StatusNoContent},
+	{Method: "POST", Path: "/sessions/{user_id}/{session_id}/compare", Tag: "admin", Summary: "Start comparing every chunk of a session as POST /chunks/{id}/compare does, counting the chunks that changed per field.", Admin: true, Status: http.StatusAccepted, Response: SessionCompareStatus{}},
+	{Method: "GET", Path: "/sessions/{user_id}/{session_id}/compare/{job_id}", Tag: "admin", Summary: "Get a session comparison's progress and, once done, its result.", Admin: true, Response: SessionCompareStatus{}},
+	{Method: "GET", Path: "/shared/{token}/chunks", Tag: "sharing", Summary: "List the chunks of a shared session.", Public: true, Response: chunkList},
+	{Method: "GET", Path: "/shared/{token}/chunks/{id}", Tag: "sharing", Summary: "Get a chunk of a shared session.", Public: true, Response: Metadata{}},
+	{Method: "GET", Path: "/shared/{token}/chunks/{id}/audio", Tag: "sharing", Summary: "Download audio of a shared session. Each download counts against the link's max_downloads; a used-up link gets 410.", Public: true, Query: []apiParam{normalizeParam, audioFmtParam, bitrateParam, compressParam}, ResponseType: audioType},
+	{Method: "GET", Path: "/ws", Tag: "streaming", Summary: "Stream chunks over a WebSocket; see handleWebSocket for the message protocol.",
+		Query: []apiParam{{"user_id", "string", "Owner of the chunks."}, {"session_id", "string", "Initial session."}}, Status: http.StatusSwitchingProtocols},
+	{Method: "GET", Path: "/sessions/{user_id}/{session_id}/observe", Tag: "streaming", Summary: "Watch a session's chunk events over a WebSocket, optionally replaying past ones first.",
+		Query: []apiParam{{"replay", "integer", "How many past events to send, marked replayed, before the live ones."}}, Status: http.StatusSwitchingProtocols},
+	{Method: "GET", Path: "/ws/transcript", Tag: "streaming", Summary: "Follow a session's transcript over a WebSocket, one segment per processed chunk, however the chunks were uploaded.",
+		Query: []apiParam{{"user_id", "string", "Owner of the session."}, {"session_id", "string", "Session to follow."}, {"cursor", "integer", "Cursor of the last segment received; earlier segments are not sent again."}}, Status: http.StatusSwitchingProtocols},
+	{Method: "GET", Path: "/events", Tag: "streaming", Summary: "Server-sent session events.", ResponseType: "text/event-stream"},
+	{Method: "GET", Path: "/users/{id}/settings", Tag: "settings", Summary: "Get a user's effective processing settings.", Response: UserSettings{}},
+	{Method: "PUT", Path: "/users/{id}/settings", Tag: "settings", Summary: "Override a user's processing settings.", Request: UserSettings{}, Response: UserSettings{}},
+	{Method: "GET", Path: "/users/{id}/billing", Tag: "billing", Summary: "Get the transcription a user was billed for in a month.", Query: []apiParam{monthParam}, Response: BillingUsage{}},
+	{Method: "POST", Path: "/users/{id}/export", Tag: "exports", Summary: "Start building a bundle of everything stored about a user.", Request: exportRequest{}, Status: http.StatusAccepted, Response: ExportStatus{}},
+	{Method: "GET", Path: "/users/{id}/export/{job_id}", Tag: "exports", Summary: "Get an export's progress and, once done, its download link.", Response: ExportStatus{}},
+	{Method: "GET", Path: "/exports/{token}", Tag: "exports", Summary: "Download an export bundle.", Public: true, ResponseType: "application/zip"},
+	{Method: "GET", Path: "/changes", Tag: "admin", Summary: "Read the metadata change feed.", Admin: true,
+		Query: []apiParam{{"since", "integer", "Cursor from the previous page."}, {"limit", "integer", "Maximum changes to return."}}, Response: ChangePage{}},
+	{Method: "POST", Path: "/admin/reprocess", Tag: "admin", Summary: "Start a reprocessing job.", Admin: true, Request: ReprocessFilter{}, Status: http.StatusAccepted, Response: ReprocessStatus{}},
+	{Method: "GET", Path: "/admin/reprocess/{job_id}", Tag: "admin", Summary: "Get a reprocessing job.", Admin: true, Response: ReprocessStatus{}},
+	{Method: "DELETE", Path: "/admin/reprocess/{job_id}", Tag: "admin", Summary: "Cancel a reprocessing job.", Admin: true, Response: ReprocessStatus{}},
+	{Method: "POST", Path: "/admin/reprocess/{job_id}/resume", Tag: "admin", Summary: "Resume an interrupted reprocessing job.", Admin: true, Response: ReprocessStatus{}},
+	{Method: "GET", Path: "/admin/migrate", Tag: "admin", Summary: "Get schema migration status.", Admin: true, Response: migrationStatus{}},
+	{Method: "POST", Path: "/admin/migrate", Tag: "admin", Summary: "Start a schema migration.", Admin: true, Status: http.StatusAccepted, Response: migrationStatus{}},
+	{Method: "GET", Path: "/admin/chunks", Tag: "admin", Summary: "List chunks across users.", Admin: true,
+		Query: []apiParam{userParam, {"source_ip", "string", "Only chunks uploaded from this address or CIDR."}}, Response: chunkList},
+	{Method: "GET", Path: "/admin/sessions", Tag: "admin", Summary: "List sessions across users, most recently active first, with whether a WebSocket is streaming into each.", Admin: true,
+		Query: []apiParam{
+			{"active_since", "string", "Only sessions active since this RFC 3339 time, or within this duration, e.g. 1h."},
+			{"user_id", "string", "Only sessions of this user."},
+			{"limit", "integer", "Maximum sessions to return; defaults to 100."},
+			{"cursor", "string", "next_cursor from the previous page."},
+		}, Response: AdminSessionPage{}},
+	{Method: "GET", Path: "/admin/audit", Tag: "admin", Summary: "List audit events.", Admin: true,
+		Query: []apiParam{userParam, {"actor", "string", "Only events by this actor."}}, Response: []AuditEvent{}},
+	{Method: "PUT", Path: "/admin/recordings/{user_id}/{session_id}", Tag: "admin", Summary: "Record a session's WebSocket connections.", Admin: true, Status: http.StatusNoContent},
+	{Method: "DELETE", Path: "/admin/recordings/{user_id}/{session_id}", Tag: "admin", Summary: "Stop recording a session.", Admin: true, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/admin/recordings/{id}", Tag: "admin", Summary: "Download a session recording.", Admin: true, ResponseType: "application/x-ndjson"},
+	{Method: "POST", Path: "/admin/replay", Tag: "admin", Summary: "Replay a session recording under a new session.", Admin: true,
+		Query: []apiParam{{"speed", "string", "fast skips the recorded timing."}}, RequestType: "application/x-ndjson", Response: ReplayResult{}},
+	{Method: "POST", Path: "/admin/keys", Tag: "admin", Summary: "Mint an API key. The response is the only place the key appears.", Admin: true,
+		Request: mintKeyRequest{}, Status: http.StatusCreated, Response: mintedKey{}},
+	{Method: "GET", Path: "/admin/keys", Tag: "admin", Summary: "List minted API keys by hash.", Admin: true, Response: []APIKey{}},
+	{Method: "DELETE", Path: "/admin/keys/{id}", Tag: "admin", Summary: "Revoke an API key.", Admin: true, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/admin/goroutines", Tag: "admin", Summary: "Count goroutines, by tracked component.", Admin: true, Response: goroutineReport{}},
+	{Method: "GET", Path: "/admin/billing", Tag: "admin", Summary: "Get every user's transcription spend in a month.", Admin: true, Query: []apiParam{monthParam}, Response: billingRollup{}},
+	{Method: "POST", Path: "/admin/faults", Tag: "admin", Summary: "Arm a fault for chaos testing; needs AUDIO_FAULTS=true.", Admin: true, Request: faultRequest{}, Status: http.StatusCreated, Response: Fault{}},
+	{Method: "GET", Path: "/admin/faults", Tag: "admin", Summary: "List armed faults.", Admin: true, Response: []Fault{}},
+	{Method: "DELETE", Path: "/admin/faults/{id}", Tag: "admin", Summary: "Disarm a fault.", Admin: true, Status: http.StatusNoContent},
+	{Method: "POST", Path: "/admin/webhooks", Tag: "admin", Summary: "Subscribe a URL to chunk and session events, optionally for some users only.", Admin: true, Request: WebhookSubscription{}, Status: http.StatusCreated, Response: WebhookSubscription{}},
+	{Method: "GET", Path: "/admin/webhooks", Tag: "admin", Summary: "List webhook subscriptions.", Admin: true, Response: []WebhookSubscription{}},
+	{Method: "GET", Path: "/admin/webhooks/{id}", Tag: "admin", Summary: "Get a webhook subscription.", Admin: true, Response: WebhookSubscription{}},
+	{Method: "PUT", Path: "/admin/webhooks/{id}", Tag: "admin", Summary: "Replace a webhook subscription.", Admin: true, Request: WebhookSubscription{}, Response: WebhookSubscription{}},
+	{Method: "DELETE", Path: "/admin/webhooks/{id}", Tag: "admin", Summary: "Delete a webhook subscription.", Admin: true, Status: http.StatusNoContent},
+	{Method: "GET", Path: "/admin/webhooks/{id}/deliveries", Tag: "admin", Summary: "Recent deliveries to a subscription, newest first.", Admin: true, Response: []WebhookDelivery{}},
+	{Method: "GET", Path: "/admin/reconcile", Tag: "admin", Summary: "Dry-run reconciliation: list orphaned blobs and chunks missing their audio.", Admin: true, Response: ReconcileReport{}},
+	{Method: "GET", Path: "/admin/auto-exports", Tag: "admin", Summary: "Show the session auto-export schedule and its recent runs.", Admin: true, Response: AutoExportStatus{}},
+	{Method: "GET", Path: "/admin/read-only", Tag: "admin", Summary: "Report whether the server is refusing writes for maintenance.", Admin: true, Response: ReadOnlyStatus{}},
+	{Method: "PUT", Path: "/admin/read-only", Tag: "admin", Summary: "Turn read-only mode on or off. While on, writes get 503 with Retry-After and reads keep working.", Admin: true, Request: readOnlyRequest{}, Response: ReadOnlyStatus{}},
+	{Method: "GET", Path: "/admin/stages", Tag: "admin", Summary: "List the switchable pipeline stages and their modes.", Admin: true, Response: map[string]StageState{}},
+	{Method: "POST", Path: "/admin/stages/{name}", Tag: "admin", Summary: "Enable, disable or stub a pipeline stage.", Admin: true, Request: stageRequest{}, Response: StageState{}},
+	{Method: "GET", Path: "/admin/captures", Tag: "admin", Summary: "List debug captures.", Admin: true, Response: []Capture{}},
+	{Method: "GET", Path: "/admin/captures/{id}/body", Tag: "admin", Summary: "Download a debug capture's request body.", Admin: true, ResponseType: "application/octet-stream"},
+	{Method: "GET", Path: "/healthz", Tag: "operations", Summary: "Liveness with the state of each alert rule; \"degraded\" while any alert fires.", Response: health{}},
+	{Method: "GET", Path: "/readyz", Tag: "operations", Summary: "Readiness, including index health and warm-up progress; 503 while warming up.", Response: readiness{}},
+	{Method: "GET", Path: "/debug/vars", Tag: "operations", Summary: "Runtime metrics.", ResponseType: "application/json"},
+	{Method: "GET", Path: "/openapi.json", Tag: "operations", Summary: "This document.", Public: true, ResponseType: "application/json"},
+	{Method: "GET", Path: "/version", Tag: "operations", Summary: "Build information of the running server.", Public: true, Response: BuildInfo{}},
+	{Method: "GET", Path: "/capabilities", Tag: "operations", Summary: "Features, limits and formats of this deployment, from its live configuration.", Public: true, Response: Capabilities{}},
+	{Method: "GET", Path: receipt.KeysPath, Tag: "operations", Summary: "Public keys that verify upload receipts, including retired ones.", Public: true, Response: receipt.KeySet{}},
+	{Method: "GET", Path: "/docs", Tag: "operations", Summary: "Swagger UI, when enabled.", Public: true, ResponseType: "text/html"},
+	{Method: "POST", Path: audioprocessorv1connect.AudioServiceUploadChunkProcedure, Tag: "connect", Summary: "Connect RPC form of POST /upload.", RequestType: connectType, ResponseType: connectType},
+	{Method: "POST", Path: audioprocessorv1connect.AudioServiceGetChunkProcedure, Tag: "connect", Summary: "Connect RPC form of GET /chunks/{id}.", RequestType: connectType, ResponseType: connectType},
+	{Method: "POST", Path: audioprocessorv1connect.AudioServiceListByUserProcedure, Tag: "connect", Summary: "Connect RPC form of GET /sessions/{user_id}.", RequestType: connectType, ResponseType: connectType},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// buildOpenAPI renders apiOperations as an OpenAPI 3 document.
+func buildOpenAPI(cfg Config) map[string]any {
+	schemas := map[string]any{}
+	errorRef := schemaFor(reflect.TypeOf(errorBody{}), schemas)
+	paths := map[string]map[string]any{}
+	for _, op := range apiOperations {
+		var params []any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{"name": q.Name, "in": "query", "description": q.Description, "schema": map[string]any{"type": q.Type}})
+		}
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if content := apiContent(op.Response, op.ResponseType, schemas); content != nil {
+			success["content"] = content
+		}
+		operation := map[string]any{
+			"tags":    []string{op.Tag},
+			"summary": op.Summary,
+			"responses": map[string]any{
+				strconv.Itoa(status): success,
+				"default": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+				},
+			},
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+		if content := apiContent(op.Request, op.RequestType, schemas); content != nil {
+			operation["requestBody"] = map[string]any{"required": op.RequestType != "", "content": content}
+		}
+		switch {
+		case op.Public:
+			operation["security"] = []any{}
+		case op.Admin:
+			operation["security"] = []any{
+				map[string]any{"bearerAuth": []string{}, "adminToken": []string{}},
+				map[string]any{"apiKeyQuery": []string{}, "adminToken": []string{}},
+			}
+		}
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]any{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]any{"title": "audio-processor", "version": apiVersion},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth":  map[string]any{"type": "http", "scheme": "bearer", "description": "API key"},
+				"apiKeyQuery": map[string]any{"type": "apiKey", "in": "query", "name": "api_key"},
+				"adminToken":  map[string]any{"type": "apiKey", "in": "header", "name": "X-Admin-Token"},
+			},
+		},
+		"security": []any{
+			map[string]any{"bearerAuth": []string{}},
+			map[string]any{"apiKeyQuery": []string{}},
+		},
+	}
+	if cfg.PublicURL != "" {
+		doc["servers"] = []any{map[string]any{"url": cfg.PublicURL}}
+	}
+	return doc
+}
+
+func apiContent(body any, contentType string, schemas map[string]any) map[string]any {
+	content := map[string]any{}
+	if body != nil {
+		content["application/json"] = map[string]any{"schema": schemaFor(reflect.TypeOf(body), schemas)}
+	}
+	for _, ct := range strings.Split(contentType, ", ") {
+		if ct != "" && content[ct] == nil {
+			content[ct] = map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}
+		}
+	}
+	if len(content) == 0 {
+		return nil
+	}
+	return content
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaFor describes t as it encodes to JSON. Named structs are added to
+// schemas and referenced.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]any{"description": "Any JSON value."}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := schemaFor(t.Elem(), schemas)
+		if _, ref := s["$ref"]; ref {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas)
+		}
+		name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = map[string]any{} // placeholder for recursive types
+			schemas[name] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaFor(f.Type, schemas)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+	s := map[string]any{"type": "object", "properties": props}
+	if required != nil {
+		s["required"] = required
+	}
+	return s
+}
+
+func handleOpenAPI(cfg Config) http.HandlerFunc {
+	doc, err := json.Marshal(buildOpenAPI(cfg))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+const swaggerUIPage = `<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>audio-processor API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "openapi.json", dom_id: "#ui"});</script>
+</body>
+</html>
+`
+
+func handleSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUIPage))
+}
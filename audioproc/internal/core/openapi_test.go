@@ -0,0 +1,115 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestOpenAPICoversRoutes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SwaggerUI = true
+	srv := New(cfg, NewMemoryStore(), nil)
+
+	documented := map[string]bool{}
+	for _, op := range apiOperations {
+		documented[op.Method+" "+op.Path] = true
+	}
+	registered := map[string]bool{}
+	err := newRouter(srv).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		for _, m := range methods {
+			if m == http.MethodOptions {
+				continue
+			}
+			registered[m+" "+path] = true
+			if !documented[m+" "+path] {
+				t.Errorf("%s %s is registered but missing from the OpenAPI document", m, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("%s is documented but not registered", route)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	resp, err := http.Get(h.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 without credentials, got %d", resp.StatusCode)
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Security  []map[string][]string `json:"security"`
+			Responses map[string]struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas         map[string]any `json:"schemas"`
+			SecuritySchemes map[string]any `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document, got version %q", doc.OpenAPI)
+	}
+	getChunk := doc.Paths["/chunks/{id}"]["get"]
+	if ref := getChunk.Responses["200"].Content["application/json"].Schema["$ref"]; ref != "#/components/schemas/Metadata" {
+		t.Errorf("Expected GET /chunks/{id} to return Metadata, got %v", ref)
+	}
+	if ref := getChunk.Responses["default"].Content["application/json"].Schema["$ref"]; ref != "#/components/schemas/ErrorBody" {
+		t.Errorf("Expected the error envelope as the default response, got %v", ref)
+	}
+	for _, name := range []string{"Metadata", "ErrorBody", "ReprocessStatus"} {
+		if doc.Components.Schemas[name] == nil {
+			t.Errorf("Expected a %s schema", name)
+		}
+	}
+	if _, ok := doc.Components.SecuritySchemes["adminToken"]; !ok {
+		t.Error("Expected the admin token security scheme")
+	}
+	if sec := doc.Paths["/admin/audit"]["get"].Security; len(sec) == 0 || sec[0]["adminToken"] == nil {
+		t.Errorf("Expected /admin/audit to require the admin token, got %v", sec)
+	}
+
+	resp, err = http.Get(h.URL + "/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected /docs to be off by default, got %d", resp.StatusCode)
+	}
+}
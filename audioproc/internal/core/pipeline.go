@@ -0,0 +1,465 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcription is a transcriber's output. Words carries per-word timing
+// relative to the start of the chunk, when the engine provides it.
+type Transcription struct {
+	Text  string
+	Words []Word
+	// Confidence is how sure the transcriber is of the whole text, from 0
+	// to 1. Transcribers that leave it 0 but score their words are taken
+	// to be as sure as their words on average; those that score neither
+	// leave the chunk unscored. See score.
+	Confidence float64
+}
+
+// score returns t's overall confidence, or false if the transcriber
+// reported none.
+func (t Transcription) score() (float64, bool) {
+	if t.Confidence > 0 {
+		return t.Confidence, true
+	}
+	var sum float64
+	for _, w := range t.Words {
+		sum += float64(w.Confidence)
+	}
+	if sum == 0 {
+		return 0, false
+	}
+	return sum / float64(len(t.Words)), true
+}
+
+// Transcriber turns a chunk's audio into text.
+type Transcriber interface {
+	Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error)
+}
+
+// stubTranscriber stands in until a real ASR backend is wired up. It spreads
+// its words evenly over the chunk so word timing can be exercised, and
+// reports confidence for them and the whole text; see
+// Config.StubConfidence.
+type stubTranscriber struct {
+	confidence float64
+}
+
+func (s stubTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	text := "Hello World"
+	durationMS := 1000
+	if info, err := probeAudio(chunk.Data); err == nil && info.SampleRate > 0 {
+		durationMS = int(info.Duration().Milliseconds())
+	}
+	words := spreadWords(strings.Fields(text), durationMS)
+	for i := range words {
+		words[i].Confidence = float32(s.confidence)
+	}
+	return Transcription{Text: text, Words: words, Confidence: s.confidence}, nil
+}
+
+// Analysis is what an Analyzer measured from a chunk's audio.
+// LoudnessDBFS is nil when the loudness was not measured.
+type Analysis struct {
+	DurationMS   int64
+	LoudnessDBFS *float64
+	FFT          string
+	Fingerprint  string
+	Anomalies    []string
+}
+
+// Analyzer measures a chunk's audio. Setting Pipeline.Analyzer replaces the
+// built-in analysis, which decodes the audio itself.
+type Analyzer interface {
+	Analyze(ctx context.Context, chunk AudioChunk) (Analysis, error)
+}
+
+// Pipeline turns audio chunks into Metadata. Workers share one Pipeline, so
+// its Limiter bounds how many of them analyse chunks at once.
+type Pipeline struct {
+	Transcriber Transcriber
+	// Analyzer, when set, measures chunks in place of the built-in
+	// analysis.
+	Analyzer Analyzer
+	Limiter  *AdaptiveLimiter
+	// Budget, when set, is acquired before a chunk is decoded and held
+	// until it is processed.
+	Budget *MemoryBudget
+	// Anomalies, when set, pre-checks decoded audio before transcription.
+	Anomalies *AnomalyDetector
+	// Defaults are the settings for users who have not overridden them.
+	Defaults UserSettings
+	// Stages switches stages off or to a stub at runtime; nil runs them all.
+	Stages *StageControl
+	// Billing, when set, is charged for each chunk transcribed.
+	Billing *BillingMeter
+	// Faults, when set, injects the failures armed on it; see
+	// FaultInjector.
+	Faults *FaultInjector
+	// VerifyChecksums rehashes chunks that arrive with a checksum and
+	// counts the ones that disagree.
+	VerifyChecksums bool
+	// Deadline bounds each Process call; see Config.ProcessingDeadline.
+	Deadline time.Duration
+	// Redaction scrubs transcripts before they leave the pipeline.
+	Redaction []RedactionRule
+}
+
+// processingDeadlines counts chunks saved as partial because they ran out
+// of processing time.
+var processingDeadlines = expvar.NewInt("processing_deadlines")
+
+// The fields each stage fills in, listed in TimedOut when the deadline
+// stops the stage from finishing.
+var (
+	analysisFields      = []string{"fft", "fingerprint", "duration_ms", "loudness_dbfs", "anomalies"}
+	transcriptionFields = []string{"transcript", "words"}
+)
+
+// markTimedOut records that fields were cut off by the processing deadline.
+func (m *Metadata) markTimedOut(fields ...string) {
+	processingDeadlines.Add(1)
+	log.Printf("Chunk %s ran out of processing time; saving it without %s", m.ChunkID, strings.Join(fields, ", "))
+	m.Status = "partial"
+	m.TimedOut = fields
+}
+
+// runStage runs fn unless ctx has ended, and stops waiting for it when ctx
+// ends first. An abandoned fn runs on in the background, so a stage that
+// does not check ctx still cannot hold the worker past the deadline; one
+// that writes through a partialMeta keeps what it finished in time. fn
+// holds a reference to hold until it returns, so the chunk's memory stays
+// budgeted while an abandoned stage still uses it.
+func runStage(ctx context.Context, hold *budgetHold, fn func()) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	done := make(chan struct{})
+	hold.retain()
+	go func() {
+		defer hold.done()
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// partialMeta is the Metadata a stage fills in field by field, so that
+// what it finished can be kept if the worker stops waiting for the rest.
+// Writes after take are dropped.
+type partialMeta struct {
+	mu     sync.Mutex
+	meta   Metadata
+	filled map[string]bool
+	taken  bool
+}
+
+func newPartialMeta(meta Metadata) *partialMeta {
+	return &partialMeta{meta: meta, filled: make(map[string]bool)}
+}
+
+// set applies fn to the Metadata and records field as filled in.
+func (pm *partialMeta) set(field string, fn func(*Metadata)) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if pm.taken {
+		return
+	}
+	fn(&pm.meta)
+	pm.filled[field] = true
+}
+
+// take returns the Metadata as it stands and which of fields were not
+// filled in. Nothing written after it is seen.
+func (pm *partialMeta) take(fields []string) (Metadata, []string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.taken = true
+	var missing []string
+	for _, f := range fields {
+		if !pm.filled[f] {
+			missing = append(missing, f)
+		}
+	}
+	return pm.meta, missing
+}
+
+// checksumMismatches counts chunks whose ingest checksum did not match
+// their data when VerifyChecksums rehashed them.
+var checksumMismatches = expvar.NewInt("checksum_mismatches")
+
+// checksum returns chunk's checksum, trusting the one computed at ingest
+// unless told to verify it.
+func (p *Pipeline) checksum(chunk AudioChunk) string {
+	if chunk.Checksum != "" && !p.VerifyChecksums {
+		return chunk.Checksum
+	}
+	sum := chunkChecksum(chunk.Data)
+	if chunk.Checksum != "" && sum != chunk.Checksum {
+		checksumMismatches.Add(1)
+		log.Printf("Chunk %s: ingest checksum %s does not match its data (%s)", chunk.ChunkID, chunk.Checksum, sum)
+	}
+	return sum
+}
+
+// NewPipeline builds a pipeline whose limiter watches queueDepth, normally
+// the length of the jobs channel.
+func NewPipeline(cfg Config, t Transcriber, queueDepth func() int) *Pipeline {
+	return &Pipeline{
+		Transcriber: t,
+		Limiter:     NewAdaptiveLimiter(cfg, queueDepth),
+		Budget:      NewMemoryBudget(cfg),
+		Anomalies:   NewAnomalyDetector(cfg),
+		Defaults:    cfg.defaultSettings(),
+		Stages:      NewStageControl(),
+		Deadline:    cfg.ProcessingDeadline,
+		Redaction:   cfg.RedactionRules,
+
+		VerifyChecksums: cfg.VerifyChecksums,
+	}
+}
+
+// Process analyses and transcribes chunk. Failures are recorded in the
+// returned Metadata's Status rather than returned, as is running past the
+// Deadline, which leaves the stages that had not finished out. Time spent
+// waiting for the Budget counts against the caller's deadline but not the
+// Deadline.
+func (p *Pipeline) Process(ctx context.Context, chunk AudioChunk) Metadata {
+	if !chunk.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, chunk.deadline)
+		defer cancel()
+	}
+	meta := Metadata{
+		ChunkID:         chunk.ChunkID,
+		UserID:          chunk.UserID,
+		SessionID:       chunk.SessionID,
+		SessionRevision: chunk.SessionRevision,
+		Index:           chunk.Index,
+		Timestamp:       chunk.Timestamp,
+		RecordedAt:      chunk.RecordedAt,
+		ParentChunkID:   chunk.ParentChunkID,
+		OffsetMS:        chunk.OffsetMS,
+		DerivedFrom:     chunk.DerivedFrom,
+		SourceIP:        chunk.SourceIP,
+		ReplayOf:        chunk.ReplayOf,
+		Checksum:        p.checksum(chunk),
+		EmbeddedTags:    ReadTags(chunk.Data),
+		Markers:         chunk.Markers,
+		Status:          "processed",
+
+		ClientMetadata: chunk.ClientMetadata,
+	}
+	if meta.Markers == nil {
+		meta.Markers = readMarkers(chunk.Data)
+	}
+	// The analyzer and transcriber see the checksum of the audio as
+	// received, even once normalization has changed Data.
+	chunk.Checksum = meta.Checksum
+	release, err := p.Budget.Acquire(ctx, chunkMemory(chunk.Data))
+	if err != nil {
+		meta.markTimedOut(append(analysisFields, transcriptionFields...)...)
+		return meta
+	}
+	hold := newBudgetHold(release)
+	defer hold.done()
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+	chunk.progress.enter(progressAnalysis)
+	p.Faults.analysisLatency(ctx)
+	analysed := newPartialMeta(meta)
+	if !runStage(ctx, hold, func() { p.analyse(ctx, analysed, chunk) }) {
+		meta, missing := analysed.take(analysisFields)
+		meta.markTimedOut(append(missing, transcriptionFields...)...)
+		return meta
+	}
+	meta, _ = analysed.take(nil)
+	settings := p.Defaults.merge(chunk.Settings)
+	if !settings.transcribe() && meta.TranscriptSkipReason == "" {
+		meta.TranscriptSkipReason = "transcription is disabled in user settings"
+	}
+	if meta.TranscriptSkipReason != "" {
+		return meta
+	}
+	// Unlike the skips above, a stage switched off at runtime is marked
+	// so the chunk can be reprocessed once it is back. Its stub transcript
+	// is neither billed nor scored.
+	transcriber, stubbed := p.Transcriber, false
+	if mode, reason := p.Stages.skip(stageTranscription); mode != stageEnabled {
+		meta.markSkipped(stageTranscription, reason)
+		if mode == stageDisabled {
+			meta.TranscriptSkipReason = reason
+			return meta
+		}
+		transcriber, stubbed = stubTranscriber{confidence: 1}, true
+	}
+	if p.Faults != nil {
+		transcriber = faultyTranscriber{transcriber, p.Faults}
+	}
+	chunk.Language = settings.Language
+	chunk.progress.enter(progressTranscription)
+	var transcript Transcription
+	finished := runStage(ctx, hold, func() {
+		if settings.normalize() {
+			chunk.Data = normalizeWAV(chunk.Data)
+		}
+		if err = ctx.Err(); err == nil {
+			transcript, err = transcriber.Transcribe(ctx, chunk)
+		}
+	})
+	if !finished || err != nil && ctx.Err() != nil {
+		meta.markTimedOut(transcriptionFields...)
+		return meta
+	}
+	if err != nil {
+		log.Printf("Transcription failed for chunk %s: %v", chunk.ChunkID, err)
+		meta.Status = "failed"
+	} else if !stubbed {
+		if score, ok := transcript.score(); ok {
+			meta.Confidence = &score
+		}
+		if !chunk.DryRun {
+			p.Billing.Record(chunk, meta.DurationMS)
+		}
+	}
+	if len(p.Redaction) > 0 {
+		transcript, meta.Redactions = redactTranscript(p.Redaction, transcript)
+	}
+	meta.Transcript = transcript.Text
+	meta.Words = transcript.Words
+	return meta
+}
+
+// analyse fills in what can be measured from chunk's audio, leaving pm
+// alone for audio that does not decode or that the Analyzer fails on.
+func (p *Pipeline) analyse(ctx context.Context, pm *partialMeta, chunk AudioChunk) {
+	if p.Analyzer == nil {
+		p.measure(ctx, pm, chunk.Data)
+		return
+	}
+	a, err := p.Analyzer.Analyze(ctx, chunk)
+	if err != nil {
+		log.Printf("Analysis failed for chunk %s: %v", chunk.ChunkID, err)
+		return
+	}
+	mode, reason := p.Stages.skip(stageFingerprint)
+	pm.set("fingerprint", func(m *Metadata) {
+		if mode == stageEnabled {
+			m.Fingerprint = a.Fingerprint
+		} else {
+			m.markSkipped(stageFingerprint, reason)
+		}
+	})
+	pm.set("duration_ms", func(m *Metadata) { m.DurationMS = a.DurationMS })
+	pm.set("loudness_dbfs", func(m *Metadata) { m.LoudnessDBFS = a.LoudnessDBFS })
+	pm.set("fft", func(m *Metadata) { m.FFT = a.FFT })
+	pm.set("anomalies", func(m *Metadata) {
+		m.Anomalies = a.Anomalies
+		if p.Anomalies != nil {
+			m.TranscriptSkipReason = p.Anomalies.skipReason(a.Anomalies)
+		}
+	})
+}
+
+// measure is the built-in analysis of decoded audio. It stops between
+// measurements once ctx ends, keeping those already made.
+func (p *Pipeline) measure(ctx context.Context, pm *partialMeta, data []byte) {
+	pcm, err := decodeAudio(data)
+	if err != nil || ctx.Err() != nil {
+		return
+	}
+	pm.set("duration_ms", func(m *Metadata) {
+		m.DurationMS = int64(len(pcm.Samples)) * 1000 / int64(pcm.SampleRate)
+	})
+	if mode, reason := p.Stages.skip(stageFingerprint); mode == stageEnabled {
+		fp := Fingerprint(pcm)
+		pm.set("fingerprint", func(m *Metadata) { m.Fingerprint = fp })
+	} else {
+		pm.set("fingerprint", func(m *Metadata) { m.markSkipped(stageFingerprint, reason) })
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	hz, ok := dominantFrequency(pcm)
+	pm.set("fft", func(m *Metadata) {
+		if ok {
+			m.FFT = fmt.Sprintf("%dHz", int(math.Round(hz)))
+		}
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	db, ok := loudnessDBFS(pcm)
+	pm.set("loudness_dbfs", func(m *Metadata) {
+		if ok {
+			db = math.Round(db*100) / 100
+			m.LoudnessDBFS = &db
+		}
+	})
+	if p.Anomalies == nil {
+		pm.set("anomalies", func(*Metadata) {})
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	anomalies := p.Anomalies.Detect(pcm)
+	pm.set("anomalies", func(m *Metadata) {
+		m.Anomalies = anomalies
+		m.TranscriptSkipReason = p.Anomalies.skipReason(anomalies)
+	})
+}
+
+// Run processes jobs until ctx is cancelled.
+func (p *Pipeline) Run(ctx context.Context, in <-chan Job) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-in:
+			if d := job.Chunk.deadline; !d.IsZero() && !time.Now().Before(d) {
+				// Nobody is waiting for it any more.
+				deadlineStats.Add("dropped_queued", 1)
+				if job.done != nil {
+					job.done()
+				}
+				job.Result <- Metadata{ChunkID: job.Chunk.ChunkID, Status: "failed"}
+				continue
+			}
+			size := int64(len(job.Chunk.Data))
+			if p.Limiter != nil {
+				if err := p.Limiter.Acquire(ctx, size); err != nil {
+					return
+				}
+			}
+			start := time.Now()
+			meta := p.Process(ctx, job.Chunk)
+			if p.Limiter != nil {
+				p.Limiter.Release(size, time.Since(start))
+			}
+			if d := job.Chunk.deadline; d.IsZero() || time.Now().Before(d) {
+				// Otherwise the stage it was cut short in stays reported.
+				job.Chunk.progress.enter(progressDone)
+			}
+			if job.done != nil {
+				job.done()
+			}
+			job.Result <- meta
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// sleepyTranscriber stands in for a stage stuck on a pathological chunk:
+// it sleeps through session "slow" without looking at its context.
+type sleepyTranscriber struct{ d time.Duration }
+
+func (s sleepyTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	if chunk.SessionID == "slow" {
+		time.Sleep(s.d)
+	}
+	return Transcription{Text: "done"}, nil
+}
+
+func TestProcessingDeadlineSavesPartialChunk(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Workers = 1
+	cfg.ProcessingDeadline = 100 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Pipeline.Transcriber = sleepyTranscriber{d: 2 * time.Second}
+	wav := SineWAV(440, 200*time.Millisecond, 8000)
+	deadlines := processingDeadlines.Value()
+
+	slow := uploadTo(t, h, "user1", "slow", wav)
+	if slow.Status != "partial" || !slices.Equal(slow.TimedOut, transcriptionFields) || slow.Transcript != "" {
+		t.Fatalf("Expected a partial chunk missing its transcript, but got %+v", slow)
+	}
+	if slow.Fingerprint == "" || slow.DurationMS != 200 {
+		t.Errorf("Expected the finished analysis to be kept, but got %+v", slow)
+	}
+	if saved, err := h.Store.Get(slow.ChunkID); err != nil || saved.Status != "partial" {
+		t.Errorf("Expected the partial chunk to be saved, but got %+v %v", saved, err)
+	}
+	if processingDeadlines.Value() != deadlines+1 {
+		t.Errorf("Expected the deadline to be counted")
+	}
+
+	// The only worker is free again while the stuck stage still sleeps.
+	start := time.Now()
+	if fast := uploadTo(t, h, "user1", "fast", wav); fast.Status != "processed" || fast.Transcript != "done" {
+		t.Errorf("Expected the next chunk to process normally, but got %+v", fast)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the worker to be free, but the next chunk took %v", elapsed)
+	}
+
+	if f := (ReprocessFilter{PartialOnly: true}); !f.match(slow) {
+		t.Errorf("Expected partial_only to match the partial chunk")
+	}
+}
+
+func TestAnalysisTimeoutKeepsFinishedFields(t *testing.T) {
+	pm := newPartialMeta(Metadata{ChunkID: "c"})
+	pm.set("duration_ms", func(m *Metadata) { m.DurationMS = 200 })
+	pm.set("fingerprint", func(m *Metadata) { m.Fingerprint = "fp" })
+	meta, missing := pm.take(analysisFields)
+	// The abandoned stage finishing late changes nothing.
+	pm.set("fft", func(m *Metadata) { m.FFT = "440Hz" })
+	if meta.DurationMS != 200 || meta.Fingerprint != "fp" || meta.FFT != "" {
+		t.Errorf("Expected only the fields set before take, but got %+v", meta)
+	}
+	if want := []string{"fft", "loudness_dbfs", "anomalies"}; !slices.Equal(missing, want) {
+		t.Errorf("Expected %v missing, but got %v", want, missing)
+	}
+	if again, _ := pm.take(nil); again.FFT != "" {
+		t.Errorf("Expected a write after take to be dropped, but got %+v", again)
+	}
+
+	// Measuring stops at a context that has ended.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	pm = newPartialMeta(Metadata{})
+	(&Pipeline{}).measure(ctx, pm, SineWAV(440, 100*time.Millisecond, 8000))
+	if _, missing := pm.take(analysisFields); !slices.Equal(missing, analysisFields) {
+		t.Errorf("Expected nothing measured after the context ended, but got %v missing", missing)
+	}
+}
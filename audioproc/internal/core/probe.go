@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// wsProbes counts probe frames, which are kept out of the chunk counters.
+var wsProbes = expvar.NewInt("ws_probes")
+
+// wsInboxSize is how many frames a connection reads ahead of the chunk
+// being processed.
+const wsInboxSize = 8
+
+// probeReply answers a probe frame. ClientTS is echoed verbatim; the server
+// timestamps are Unix microseconds. QueueDepth counts the connection's
+// frames received but not yet answered, the one in flight included.
+type probeReply struct {
+	Type         string          `json:"type"`
+	ClientTS     json.RawMessage `json:"client_ts,omitempty"`
+	ServerRecvTS int64           `json:"server_recv_ts"`
+	ServerSendTS int64           `json:"server_send_ts"`
+	QueueDepth   int             `json:"queue_depth"`
+}
+
+// wsInbox reads a connection's frames on their own goroutine, so probes
+// are answered while a chunk is still in the pipeline. Everything else is
+// queued in order for the handler.
+type wsInbox struct {
+	frames chan wsEnvelope
+	busy   atomic.Bool
+}
+
+func newWSInbox() *wsInbox {
+	return &wsInbox{frames: make(chan wsEnvelope, wsInboxSize)}
+}
+
+// next returns the next queued frame, marking the previous one answered.
+// It reports false once the connection is gone.
+func (b *wsInbox) next() (wsEnvelope, bool) {
+	b.busy.Store(false)
+	env, ok := <-b.frames
+	b.busy.Store(ok)
+	return env, ok
+}
+
+func (b *wsInbox) depth() int {
+	n := len(b.frames)
+	if b.busy.Load() {
+		n++
+	}
+	return n
+}
+
+// read reads frames until the connection fails or quit is closed. Probes
+// are neither recorded nor queued.
+func (b *wsInbox) read(conn *wsConn, rec *recording, idle time.Duration, quit <-chan struct{}) {
+	defer close(b.frames)
+	for {
+		if idle > 0 {
+			conn.SetReadDeadline(time.Now().Add(idle))
+		}
+		msgType, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		received := time.Now()
+		env := parseWSEnvelope(msgType, msg)
+		env.received = received
+		if env.Type == "probe" {
+			wsProbes.Add(1)
+			_ = conn.WriteJSON(probeReply{
+				Type:         "probe",
+				ClientTS:     env.ClientTS,
+				ServerRecvTS: received.UnixMicro(),
+				ServerSendTS: time.Now().UnixMicro(),
+				QueueDepth:   b.depth(),
+			})
+			continue
+		}
+		rec.Frame(msgType, msg)
+		select {
+		case b.frames <- env:
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestEstimateLatency(t *testing.T) {
+	at := func(ms int) time.Time { return time.UnixMilli(int64(ms)) }
+	// The server's clock runs a second ahead. The first probe is held up
+	// on the way out; the second takes 50ms out, 10ms at the server and
+	// 60ms back.
+	samples := []client.ProbeSample{
+		{ClientSent: at(0), ServerReceived: at(1300), ServerSent: at(1310), ClientReceived: at(360)},
+		{ClientSent: at(1000), ServerReceived: at(2050), ServerSent: at(2060), ClientReceived: at(1120)},
+	}
+	if rtt := samples[0].RTT(); rtt != 350*time.Millisecond {
+		t.Errorf("Expected the first sample's RTT to be 350ms, but got %v", rtt)
+	}
+	l := client.EstimateLatency(samples)
+	if l.RTT != 110*time.Millisecond || l.Offset != 995*time.Millisecond || l.Samples != 2 {
+		t.Errorf("Expected the fastest sample's 110ms RTT and 995ms offset, but got %+v", l)
+	}
+	if l := client.EstimateLatency(nil); l != (client.Latency{}) {
+		t.Errorf("Expected no estimate without samples, but got %+v", l)
+	}
+}
+
+func TestMeasureLatency(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	ctx := context.Background()
+	c, err := client.Dial(ctx, h.WSURL("/ws"), "user1", "probe")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c.Close()
+
+	before := wsProbes.Value()
+	l, err := c.MeasureLatency(ctx)
+	if err != nil {
+		t.Fatalf("MeasureLatency error: %v", err)
+	}
+	if l.Samples != c.ProbeCount || l.RTT <= 0 || l.QueueDepth != 0 {
+		t.Errorf("Expected %d samples over an idle connection, but got %+v", c.ProbeCount, l)
+	}
+	// Both ends share a clock, so the offset is within the timestamps'
+	// microsecond resolution of the RTT.
+	if l.Offset < -l.RTT-time.Millisecond || l.Offset > l.RTT+time.Millisecond {
+		t.Errorf("Expected an offset near zero, but got %v with RTT %v", l.Offset, l.RTT)
+	}
+	if n := wsProbes.Value() - before; n != int64(c.ProbeCount) {
+		t.Errorf("Expected %d probes counted, but got %d", c.ProbeCount, n)
+	}
+	if _, err := c.Send(ctx, []byte("audio")); err != nil {
+		t.Errorf("Expected chunks to flow after probing, but got %v", err)
+	}
+	if n := len(h.Store.ListByUser("user1")); n != 1 {
+		t.Errorf("Expected only the chunk to be stored, but got %d chunks", n)
+	}
+}
+
+func TestProbeAnsweredWhileChunkInFlight(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	gate := &gateTranscriber{entered: make(chan struct{}, 2), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=probe"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: []byte("first"), Seq: 1})
+	<-gate.entered
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: []byte("second"), Seq: 2})
+	conn.WriteJSON(map[string]any{"type": "probe", "client_ts": 42})
+
+	var reply struct {
+		Type         string `json:"type"`
+		Ack          bool   `json:"ack"`
+		Seq          int64  `json:"seq"`
+		ClientTS     int64  `json:"client_ts"`
+		ServerRecvTS int64  `json:"server_recv_ts"`
+		ServerSendTS int64  `json:"server_send_ts"`
+		QueueDepth   int    `json:"queue_depth"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if reply.Type != "probe" || reply.ClientTS != 42 || reply.ServerSendTS < reply.ServerRecvTS {
+		t.Fatalf("Expected the probe answered ahead of the held chunk, but got %+v", reply)
+	}
+	if reply.QueueDepth != 2 {
+		t.Errorf("Expected a queue depth of 2, but got %d", reply.QueueDepth)
+	}
+
+	close(gate.release)
+	for _, seq := range []int64{1, 2} {
+		reply.Ack, reply.Seq = false, 0
+		if err := conn.ReadJSON(&reply); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if !reply.Ack || reply.Seq != seq {
+			t.Errorf("Expected the ack for seq %d, but got %+v", seq, reply)
+		}
+	}
+}
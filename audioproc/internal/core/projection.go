@@ -0,0 +1,68 @@
+package core
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// metadataFields maps each Metadata JSON name to its struct field index.
+var metadataFields, metadataFieldNames = func() (map[string]int, []string) {
+	fields := make(map[string]int)
+	var names []string
+	t := reflect.TypeOf(Metadata{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = i
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fields, names
+}()
+
+// projection selects Metadata fields by JSON name. A nil projection keeps
+// every field.
+type projection []string
+
+// parseProjection reads the comma-separated fields query parameter. When it
+// names an unknown field it writes a 400 listing the valid ones.
+func parseProjection(w http.ResponseWriter, r *http.Request) (projection, bool) {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil, true
+	}
+	var p projection
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := metadataFields[name]; !ok {
+			writeJSONError(w, http.StatusBadRequest, "unknown_field",
+				"unknown field "+name+"; valid fields are "+strings.Join(metadataFieldNames, ", "))
+			return nil, false
+		}
+		p = append(p, name)
+	}
+	return p, true
+}
+
+// apply copies the selected fields of m straight into a map, so unselected
+// fields are absent from the output rather than zero-valued.
+func (p projection) apply(m Metadata) map[string]any {
+	v := reflect.ValueOf(m)
+	out := make(map[string]any, len(p))
+	for _, name := range p {
+		out[name] = v.Field(metadataFields[name]).Interface()
+	}
+	return out
+}
+
+// one returns m, projected when p selects fields.
+func (p projection) one(m Metadata) any {
+	if p == nil {
+		return m
+	}
+	return p.apply(m)
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestGetChunkProjection(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "chunk1", UserID: "user1", Timestamp: time.Now(), Transcript: "long transcript"})
+	handler := handleGetChunk(store, DefaultConfig())
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/chunks/chunk1?fields=chunk_id,timestamp,status", nil), map[string]string{"id": "chunk1"})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	var got map[string]any
+	json.NewDecoder(rr.Body).Decode(&got)
+	if len(got) != 3 || got["chunk_id"] != "chunk1" {
+		t.Errorf("Expected exactly the three requested fields, but got %v", got)
+	}
+	if _, ok := got["transcript"]; ok {
+		t.Errorf("Expected transcript to be absent")
+	}
+	if status, ok := got["status"]; !ok || status != "" {
+		t.Errorf("Expected a requested empty field to be present, but got %v", got)
+	}
+
+	req = mux.SetURLVars(httptest.NewRequest("GET", "/chunks/chunk1?fields=chunk_id,bogus", nil), map[string]string{"id": "chunk1"})
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "duration_ms") {
+		t.Errorf("Expected 400 listing the valid fields, but got %v: %s", rr.Code, rr.Body)
+	}
+}
+
+func TestListProjection(t *testing.T) {
+	store := NewMemoryStore()
+	for _, id := range []string{"a", "b"} {
+		store.Save(Metadata{ChunkID: id, UserID: "user1", Transcript: "text"})
+	}
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/sessions/user1?fields=chunk_id", nil), map[string]string{"user_id": "user1"})
+	rr := httptest.NewRecorder()
+	handleGetUserSessions(store, DefaultConfig()).ServeHTTP(rr, req)
+	var got []map[string]any
+	json.NewDecoder(rr.Body).Decode(&got)
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 chunks, but got %v", got)
+	}
+	for _, m := range got {
+		if len(m) != 1 || m["chunk_id"] == nil {
+			t.Errorf("Expected only chunk_id, but got %v", m)
+		}
+	}
+}
+
+func TestUploadRecordsDuration(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	meta := uploadTo(t, h, "user1", "sess1", SineWAV(440, 1500*time.Millisecond, 8000))
+	if meta.DurationMS != 1500 {
+		t.Errorf("Expected a duration of 1500ms, but got %v", meta.DurationMS)
+	}
+}
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// quotaHeader reports on upload responses how much of each soft quota a
+// user has used and has left, in percent, e.g.
+// "bytes;used=82.5;remaining=17.5, chunks;used=40;remaining=60".
+const quotaHeader = "X-Quota-Remaining"
+
+// quotaWarnings counts warnings published, keyed by resource and
+// threshold, e.g. "bytes.80", and warnings held back because they could
+// not be recorded, as "mark_failed".
+var quotaWarnings = expvar.NewMap("quota_warnings")
+
+// QuotaWarning is the payload of a quota_warning event: UserID's use of
+// Resource ("bytes" or "chunks") reached Threshold percent of Limit.
+type QuotaWarning struct {
+	UserID    string    `json:"user_id"`
+	Resource  string    `json:"resource"`
+	Threshold int       `json:"threshold"`
+	Used      int64     `json:"used"`
+	Limit     int64     `json:"limit"`
+	Percent   float64   `json:"percent"`
+	At        time.Time `json:"at"`
+}
+
+// QuotaStore is what soft quotas need from a store. Durable backends must
+// keep the warning marks, so that a restart does not repeat the day's
+// warnings.
+type QuotaStore interface {
+	// StorageUsage totals the audio bytes and chunks userID keeps,
+	// leaving out the trash.
+	StorageUsage(userID string) (bytes int64, chunks int)
+	// MarkQuotaWarned records that the warning key was sent to userID on
+	// day, reporting false if it already had been.
+	MarkQuotaWarned(userID, key, day string) (bool, error)
+}
+
+var _ QuotaStore = (*MemoryStore)(nil)
+
+// SoftQuotas warns users as their storage nears Config.QuotaBytes or
+// Config.QuotaChunks. Nothing is refused: when usage reaches one of
+// Config.QuotaWarnAt a quota_warning event is published on the bus, at
+// most once per resource and threshold per day. A nil *SoftQuotas does
+// nothing.
+type SoftQuotas struct {
+	Clock Clock
+
+	store      QuotaStore
+	bus        *Bus
+	bytes      int64
+	chunks     int64
+	thresholds []int
+}
+
+// NewSoftQuotas returns nil unless a quota is configured.
+func NewSoftQuotas(cfg Config, store QuotaStore, bus *Bus) *SoftQuotas {
+	if cfg.QuotaBytes <= 0 && cfg.QuotaChunks <= 0 {
+		return nil
+	}
+	thresholds := slices.Clone(cfg.QuotaWarnAt)
+	slices.Sort(thresholds)
+	return &SoftQuotas{Clock: realClock{}, store: store, bus: bus, bytes: cfg.QuotaBytes, chunks: int64(cfg.QuotaChunks), thresholds: thresholds}
+}
+
+// ParseQuotaThresholds reads comma-separated percentages such as "80,95".
+func ParseQuotaThresholds(s string) ([]int, error) {
+	var thresholds []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 || n > 100 {
+			return nil, fmt.Errorf("quota threshold %q must be a percentage from 1 to 100", field)
+		}
+		thresholds = append(thresholds, n)
+	}
+	return thresholds, nil
+}
+
+type quotaUse struct {
+	resource    string
+	used, limit int64
+}
+
+func (u quotaUse) percent() float64 {
+	return float64(u.used) * 100 / float64(u.limit)
+}
+
+// usage returns userID's use of each configured quota.
+func (q *SoftQuotas) usage(userID string) []quotaUse {
+	bytes, chunks := q.store.StorageUsage(userID)
+	var uses []quotaUse
+	if q.bytes > 0 {
+		uses = append(uses, quotaUse{"bytes", bytes, q.bytes})
+	}
+	if q.chunks > 0 {
+		uses = append(uses, quotaUse{"chunks", int64(chunks), q.chunks})
+	}
+	return uses
+}
+
+// Check publishes a warning for each resource whose highest threshold
+// reached has not been warned about today. Lower thresholds passed on the
+// way are marked too, so a jump from 50% to 96% warns once.
+func (q *SoftQuotas) Check(userID string) {
+	if q == nil {
+		return
+	}
+	now := q.Clock.Now().UTC()
+	day := now.Format(time.DateOnly)
+	for _, u := range q.usage(userID) {
+		pct := u.percent()
+		reached, first := -1, false
+		var err error
+		for _, t := range q.thresholds {
+			if pct < float64(t) {
+				break
+			}
+			reached = t
+			if first, err = q.store.MarkQuotaWarned(userID, u.resource+"."+strconv.Itoa(t), day); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			// Whether the warning went out already is unknown, so it
+			// waits for the next check rather than risk a repeat.
+			quotaWarnings.Add("mark_failed", 1)
+			log.Printf("Quota warning for %s not recorded: %v", userID, err)
+			continue
+		}
+		if reached < 0 || !first {
+			continue
+		}
+		quotaWarnings.Add(u.resource+"."+strconv.Itoa(reached), 1)
+		q.bus.Publish(Event{Type: EventQuotaWarning, At: now, Quota: &QuotaWarning{
+			UserID: userID, Resource: u.resource, Threshold: reached, Used: u.used, Limit: u.limit, Percent: roundPercent(pct), At: now,
+		}})
+	}
+}
+
+func roundPercent(pct float64) float64 {
+	return float64(int64(pct*10+0.5)) / 10
+}
+
+// setHeader reports userID's usage on an upload response.
+func (q *SoftQuotas) setHeader(w http.ResponseWriter, userID string) {
+	if q == nil {
+		return
+	}
+	var parts []string
+	for _, u := range q.usage(userID) {
+		used := roundPercent(u.percent())
+		parts = append(parts, fmt.Sprintf("%s;used=%g;remaining=%g", u.resource, used, roundPercent(max(0, 100-used))))
+	}
+	w.Header().Set(quotaHeader, strings.Join(parts, ", "))
+}
+
+// RunSoftQuotas checks the user of every processed chunk, however it was
+// uploaded, until ctx ends.
+func RunSoftQuotas(ctx context.Context, q *SoftQuotas) {
+	if q == nil {
+		return
+	}
+	unsubscribe := q.bus.Subscribe("soft_quotas", 256, func(ev Event) {
+		q.Check(ev.Chunk.UserID)
+	}, EventChunkProcessed)
+	defer unsubscribe()
+	<-ctx.Done()
+}
+
+// storageTotal is a user's running StorageUsage.
+type storageTotal struct {
+	bytes  int64
+	chunks int
+}
+
+// storageCharge is what one visible chunk adds to its user's total.
+type storageCharge struct {
+	userID string
+	bytes  int64
+}
+
+// StorageUsage totals the stored audio of userID's visible chunks.
+// Archived audio counts at its archived size. The totals are kept up to
+// date as records and blobs are written.
+func (s *MemoryStore) StorageUsage(userID string) (bytes int64, chunks int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t := s.storage[userID]
+	return t.bytes, t.chunks
+}
+
+// chargeLocked brings id's share of its user's storage total up to date
+// after its record or blob was written. Every committed change and blob
+// write calls it.
+func (s *MemoryStore) chargeLocked(id string) {
+	if old, ok := s.charged[id]; ok {
+		t := s.storage[old.userID]
+		t.bytes -= old.bytes
+		t.chunks--
+		if t.chunks == 0 {
+			delete(s.storage, old.userID)
+		} else {
+			s.storage[old.userID] = t
+		}
+		delete(s.charged, id)
+	}
+	m, ok := s.lookupLocked(id)
+	if !ok || m.DeletedAt != nil {
+		return
+	}
+	c := storageCharge{userID: m.UserID}
+	if data, ok := s.blobs[id]; ok {
+		c.bytes = int64(len(data))
+	} else if m.Archive != nil {
+		c.bytes = m.Archive.Size
+	}
+	s.charged[id] = c
+	t := s.storage[c.userID]
+	t.bytes += c.bytes
+	t.chunks++
+	s.storage[c.userID] = t
+}
+
+// chargeAllLocked totals storage afresh, for a store loaded without going
+// through changedLocked.
+func (s *MemoryStore) chargeAllLocked() {
+	clear(s.storage)
+	clear(s.charged)
+	for id := range s.metadata {
+		s.chargeLocked(id)
+	}
+	for id := range s.legacy {
+		s.chargeLocked(id)
+	}
+}
+
+// MarkQuotaWarned records a soft quota warning for the day. The first mark
+// of a new day forgets the earlier days' marks, which no longer hold
+// anything back.
+func (s *MemoryStore) MarkQuotaWarned(userID, key, day string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if day != s.warnedDay {
+		maps.DeleteFunc(s.quotaWarned, func(_, d string) bool { return d != day })
+		s.warnedDay = day
+	}
+	k := userID + "/" + key
+	if s.quotaWarned[k] == day {
+		return false, nil
+	}
+	s.quotaWarned[k] = day
+	s.logEntryLocked(walQuotaWarned, k, day)
+	return true, nil
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSoftQuotaWarnsOncePerThreshold(t *testing.T) {
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	cfg := DefaultConfig()
+	cfg.QuotaChunks = 10
+	cfg.QuotaBytes = int64(100 * len(wav))
+	store := NewMemoryStore()
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	var mu sync.Mutex
+	var warnings []QuotaWarning
+	start := func() *Harness {
+		h := NewHarnessWithStore(cfg, store)
+		h.Quotas.Clock = clock
+		h.Events.Subscribe("test", 16, func(ev Event) {
+			mu.Lock()
+			warnings = append(warnings, *ev.Quota)
+			mu.Unlock()
+		}, EventQuotaWarning)
+		return h
+	}
+	upload := func(h *Harness) string {
+		t.Helper()
+		resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "application/octet-stream", bytes.NewReader(wav))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Upload failed: %v %v", resp, err)
+		}
+		resp.Body.Close()
+		// Quota checks follow processed events, and warnings follow them.
+		h.Events.Flush()
+		h.Events.Flush()
+		return resp.Header.Get(quotaHeader)
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(warnings)
+	}
+
+	h := start()
+	for i := 1; i <= 7; i++ {
+		upload(h)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("Expected no warning below 80%%, but got %d", n)
+	}
+	if got, want := upload(h), "bytes;used=8;remaining=92, chunks;used=80;remaining=20"; got != want {
+		t.Errorf("Expected %s %q, but got %q", quotaHeader, want, got)
+	}
+	upload(h)
+	if got, want := upload(h), "bytes;used=10;remaining=90, chunks;used=100;remaining=0"; got != want {
+		t.Errorf("Expected %s %q, but got %q", quotaHeader, want, got)
+	}
+	upload(h)
+	h.Close()
+
+	// A restart against the same store remembers today's warnings.
+	h = start()
+	defer h.Close()
+	upload(h)
+	mu.Lock()
+	got := append([]QuotaWarning(nil), warnings...)
+	mu.Unlock()
+	if len(got) != 2 {
+		t.Fatalf("Expected exactly two warnings, but got %+v", got)
+	}
+	if w := got[0]; w.UserID != "user1" || w.Resource != "chunks" || w.Threshold != 80 || w.Used != 8 || w.Limit != 10 || w.Percent != 80 {
+		t.Errorf("Expected the 80%% chunk warning first, but got %+v", w)
+	}
+	if w := got[1]; w.Resource != "chunks" || w.Threshold != 95 || w.Used != 10 {
+		t.Errorf("Expected the 95%% chunk warning second, but got %+v", w)
+	}
+
+	// The next day a user still over a threshold is warned again, once.
+	clock.Advance(24 * time.Hour)
+	upload(h)
+	upload(h)
+	if n := count(); n != 3 {
+		t.Errorf("Expected one more warning the next day, but got %d in all", n)
+	}
+}
+
+func TestQuotaWarningsGoToTheirUser(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.QuotaChunks = 1
+	cfg.AdminToken = "admin"
+	cfg.APIKeys = map[string]string{"key1": "user1", "key2": "user2"}
+	h := NewHarness(cfg)
+	// Cleanups run last first, so the streams close before the server.
+	t.Cleanup(h.Close)
+
+	// stream opens /events as key and returns a reader of the quota
+	// warnings it is sent.
+	stream := func(key string, admin bool) func() QuotaWarning {
+		req, _ := http.NewRequest("GET", h.URL+"/events", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		if admin {
+			req.Header.Set("X-Admin-Token", "admin")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		reader := bufio.NewReader(resp.Body)
+		return func() QuotaWarning {
+			t.Helper()
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				if data, ok := strings.CutPrefix(line, "data: "); ok {
+					var w QuotaWarning
+					json.Unmarshal([]byte(data), &w)
+					return w
+				}
+			}
+		}
+	}
+	user2 := stream("key2", false)
+	admin := stream("key1", true)
+
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	for _, key := range []string{"key1", "key2"} {
+		req, _ := http.NewRequest("POST", h.URL+"/upload?user_id=user"+key[3:]+"&session_id=s1", bytes.NewReader(wav))
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		h.Events.Flush()
+		h.Events.Flush()
+	}
+	if w := user2(); w.UserID != "user2" {
+		t.Errorf("Expected user2 to see only its own warning, but got %+v", w)
+	}
+	if w1, w2 := admin(), admin(); w1.UserID != "user1" || w2.UserID != "user2" {
+		t.Errorf("Expected the admin to see both warnings, but got %+v and %+v", w1, w2)
+	}
+}
+
+// failingQuotaStore fails to record warnings.
+type failingQuotaStore struct{ QuotaStore }
+
+func (failingQuotaStore) MarkQuotaWarned(userID, key, day string) (bool, error) {
+	return false, errors.New("disk full")
+}
+
+func TestQuotaWarningNotRecorded(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.QuotaChunks = 1
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1"})
+	bus := NewBus()
+	var published int
+	bus.Subscribe("test", 16, func(Event) { published++ }, EventQuotaWarning)
+	before := expvarInt(quotaWarnings, "mark_failed")
+	NewSoftQuotas(cfg, failingQuotaStore{store}, bus).Check("user1")
+	bus.Flush()
+	if published != 0 || expvarInt(quotaWarnings, "mark_failed") != before+1 {
+		t.Errorf("Expected the warning held back and counted, but got %d published", published)
+	}
+}
+
+func TestStorageUsageRunningTotals(t *testing.T) {
+	store := NewMemoryStore()
+	store.SaveBlob("c1", make([]byte, 100))
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1"})
+	store.Save(Metadata{ChunkID: "c2", UserID: "user1"})
+	store.SaveBlob("c2", make([]byte, 50))
+	if bytes, chunks := store.StorageUsage("user1"); bytes != 150 || chunks != 2 {
+		t.Fatalf("Expected 150 bytes in 2 chunks, but got %d in %d", bytes, chunks)
+	}
+	store.Delete("c1")
+	if bytes, chunks := store.StorageUsage("user1"); bytes != 50 || chunks != 1 {
+		t.Errorf("Expected the trash left out, but got %d in %d", bytes, chunks)
+	}
+	store.Restore("c1")
+	store.Save(Metadata{ChunkID: "c2", UserID: "user2"})
+	if bytes, chunks := store.StorageUsage("user1"); bytes != 100 || chunks != 1 {
+		t.Errorf("Expected c1 back and c2 moved away, but got %d in %d", bytes, chunks)
+	}
+	if bytes, chunks := store.StorageUsage("user2"); bytes != 50 || chunks != 1 {
+		t.Errorf("Expected c2 counted for user2, but got %d in %d", bytes, chunks)
+	}
+}
+
+func TestQuotaWarnedPrunedDaily(t *testing.T) {
+	store := NewMemoryStore()
+	store.MarkQuotaWarned("user1", "bytes.80", "2024-06-01")
+	store.MarkQuotaWarned("user2", "bytes.80", "2024-06-01")
+	if first, _ := store.MarkQuotaWarned("user1", "bytes.80", "2024-06-02"); !first {
+		t.Errorf("Expected a new day to warn again")
+	}
+	if n := len(store.quotaWarned); n != 1 {
+		t.Errorf("Expected only the day's mark kept, but got %d", n)
+	}
+}
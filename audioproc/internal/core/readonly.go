@@ -0,0 +1,148 @@
+package core
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// readOnlyRefusals counts writes refused in read-only mode, keyed by
+// route, "ws" or "mqtt".
+var readOnlyRefusals = expvar.NewMap("read_only_refusals")
+
+// readOnlyExempt are the non-GET routes that write nothing, or that
+// maintenance needs while writes are off.
+var readOnlyExempt = map[string]bool{
+	"/admin/read-only":                         true,
+	"/admin/migrate":                           true,
+	"/chunks/{id}/compare":                     true,
+	"/sessions/{user_id}/{session_id}/compare": true,
+	"/chunks/{id}/signed-url":                  true,
+}
+
+// ReadOnlyStatus is what GET and PUT /admin/read-only return.
+type ReadOnlyStatus struct {
+	ReadOnly bool       `json:"read_only"`
+	Message  string     `json:"message,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+}
+
+// ReadOnly is the maintenance switch. While it is on, reads keep working
+// but uploads and every other write are refused with 503 and Retry-After,
+// WebSocket chunks get a read_only error frame, and the sweepers that
+// write skip their runs. A nil *ReadOnly is never on.
+type ReadOnly struct {
+	Clock Clock
+
+	retryAfter time.Duration
+
+	mu      sync.RWMutex
+	on      bool
+	message string
+	since   time.Time
+}
+
+// defaultReadOnlyMessage is sent when the operator gives no reason.
+const defaultReadOnlyMessage = "the service is read-only for maintenance"
+
+// NewReadOnly starts on if cfg.ReadOnly is set.
+func NewReadOnly(cfg Config) *ReadOnly {
+	ro := &ReadOnly{Clock: realClock{}, retryAfter: cfg.ReadOnlyRetryAfter}
+	if cfg.ReadOnly {
+		ro.Set(true, "")
+	}
+	return ro
+}
+
+// Enabled reports whether writes are refused.
+func (ro *ReadOnly) Enabled() bool {
+	if ro == nil {
+		return false
+	}
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+	return ro.on
+}
+
+// Set turns read-only mode on or off, with message explaining why.
+func (ro *ReadOnly) Set(on bool, message string) ReadOnlyStatus {
+	ro.mu.Lock()
+	if on && !ro.on {
+		ro.since = ro.Clock.Now().UTC()
+	}
+	ro.on = on
+	ro.message = ""
+	if on {
+		ro.message = message
+		if message == "" {
+			ro.message = defaultReadOnlyMessage
+		}
+	}
+	ro.mu.Unlock()
+	return ro.Status()
+}
+
+// Status reports the mode.
+func (ro *ReadOnly) Status() ReadOnlyStatus {
+	if ro == nil {
+		return ReadOnlyStatus{}
+	}
+	ro.mu.RLock()
+	defer ro.mu.RUnlock()
+	if !ro.on {
+		return ReadOnlyStatus{}
+	}
+	since := ro.since
+	return ReadOnlyStatus{ReadOnly: true, Message: ro.message, Since: &since}
+}
+
+// err is returned to writers while the mode is on.
+func (ro *ReadOnly) err() error {
+	return newKindError(ErrReadOnly, ro.Status().Message)
+}
+
+// Middleware refuses requests that could write while the mode is on.
+// Connect RPCs are left to their handlers, which answer in Connect's own
+// error format.
+func (ro *ReadOnly) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ro.Enabled() || r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" || strings.HasPrefix(r.URL.Path, connectService) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, _ := mux.CurrentRoute(r).GetPathTemplate()
+		if readOnlyExempt[route] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		readOnlyRefusals.Add(route, 1)
+		w.Header().Set("Retry-After", strconv.Itoa(int(ro.retryAfter.Seconds())))
+		writeError(w, ro.err())
+	})
+}
+
+type readOnlyRequest struct {
+	ReadOnly *bool  `json:"read_only"`
+	Message  string `json:"message"`
+}
+
+func handleReadOnly(ro *ReadOnly) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" {
+			var req readOnlyRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ReadOnly == nil {
+				writeError(w, invalidField("read_only", "invalid_read_only", "read_only must be true or false"))
+				return
+			}
+			ro.Set(*req.ReadOnly, req.Message)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ro.Status())
+	}
+}
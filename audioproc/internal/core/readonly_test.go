@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestReadOnlyModeRefusesWritesOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	meta := uploadTo(t, h, "user1", "s1", wav)
+
+	var status ReadOnlyStatus
+	if code := adminDo(t, h, "PUT", "/admin/read-only", map[string]any{"read_only": true, "message": "store migration"}, &status); code != http.StatusOK || !status.ReadOnly || status.Message != "store migration" || status.Since == nil {
+		t.Fatalf("Expected read-only mode on, but got %d %+v", code, status)
+	}
+
+	do := func(method, path string, body []byte) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, h.URL+path, bytes.NewReader(body))
+		req.Header.Set("X-Admin-Token", "admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for _, path := range []string{"/chunks/" + meta.ChunkID, "/sessions/user1", "/sessions/user1/s1/transcript", "/chunks/" + meta.ChunkID + "/audio"} {
+		if resp := do("GET", path, nil); resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: expected 200, but got %d", path, resp.StatusCode)
+		}
+	}
+	if resp := do("POST", "/chunks/"+meta.ChunkID+"/compare", nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a dry-run compare to be allowed, but got %d", resp.StatusCode)
+	}
+	for _, w := range []struct{ method, path string }{
+		{"POST", "/upload?user_id=user1&session_id=s1"},
+		{"DELETE", "/chunks/" + meta.ChunkID},
+		{"PATCH", "/chunks/" + meta.ChunkID},
+		{"DELETE", "/sessions/user1/s1"},
+		{"POST", "/admin/reprocess"},
+	} {
+		resp := do(w.method, w.path, wav)
+		var body errorBody
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "60" || body.Error != "read_only" || body.Message != "store migration" {
+			t.Errorf("%s %s: expected 503 read_only with Retry-After, but got %d %q %+v", w.method, w.path, resp.StatusCode, resp.Header.Get("Retry-After"), body)
+		}
+	}
+	if m, _ := h.Store.Get(meta.ChunkID); m.DeletedAt != nil {
+		t.Error("Expected the chunk to survive the refused delete")
+	}
+
+	var ready readiness
+	resp := do("GET", "/readyz", nil)
+	json.NewDecoder(resp.Body).Decode(&ready)
+	if resp.StatusCode != http.StatusOK || !ready.ReadOnly {
+		t.Errorf("Expected /readyz to report read-only and stay ready, but got %d %+v", resp.StatusCode, ready)
+	}
+
+	// Chunk frames are refused on an open socket; observers connect as usual.
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: 1})
+	var nack client.Ack
+	if err := conn.ReadJSON(&nack); err != nil || nack.Ack || nack.Error != "read_only" || nack.Seq != 1 {
+		t.Errorf("Expected seq 1 refused as read_only, but got %+v %v", nack, err)
+	}
+	observer, _, err := websocket.DefaultDialer.Dial(h.WSURL("/sessions/user1/s1/observe"), nil)
+	if err != nil {
+		t.Errorf("Expected observers to keep working, but got %v", err)
+	} else {
+		observer.Close()
+	}
+
+	if code := adminDo(t, h, "PUT", "/admin/read-only", map[string]any{"read_only": false}, &status); code != http.StatusOK || status.ReadOnly {
+		t.Fatalf("Expected read-only mode off, but got %d %+v", code, status)
+	}
+	uploadTo(t, h, "user1", "s1", wav)
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: 2})
+	// The observer coming and going was reported to the producer first.
+	if nack, err = readAck(conn); err != nil || !nack.Ack || nack.Seq != 2 {
+		t.Errorf("Expected seq 2 acked once writes resume, but got %+v %v", nack, err)
+	}
+}
+
+func TestReadOnlyPausesSweepers(t *testing.T) {
+	store := NewMemoryStore()
+	store.Retention = time.Nanosecond
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", SessionID: "s1", Timestamp: time.Now()})
+	store.Delete("c1")
+	ro := NewReadOnly(DefaultConfig())
+	ro.Set(true, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go RunTrashSweeper(ctx, store, ro, time.Millisecond)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := len(store.ListByUserWithDeleted("user1")); n != 1 {
+		t.Fatalf("Expected the trash left alone while read-only, but %d chunks remain", n)
+	}
+	ro.Set(false, "")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.ListByUserWithDeleted("user1")) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the trash purged once writes resume")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// gatedTranscriber holds every chunk until release is closed, telling
+// entered when it has one.
+type gatedTranscriber struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (g gatedTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	g.entered <- struct{}{}
+	<-g.release
+	return Transcription{Text: "late"}, nil
+}
+
+func TestReadOnlyRefusesInFlightChunks(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	gate := gatedTranscriber{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "application/octet-stream", bytes.NewReader(SineWAV(440, 100*time.Millisecond, 8000)))
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	<-gate.entered
+	h.ReadOnly.Set(true, "")
+	close(gate.release)
+	if code := <-status; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected a chunk finishing in read-only mode refused, but got %d", code)
+	}
+	if chunks := h.Store.ListBySession("user1", "s1"); len(chunks) != 0 {
+		t.Errorf("Expected nothing saved, but got %+v", chunks)
+	}
+}
+
+func TestReadOnlyRefusesInFlightWebSocketChunks(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	gate := gatedTranscriber{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	h.Pipeline.Transcriber = gate
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: 1})
+	<-gate.entered
+	h.ReadOnly.Set(true, "store migration")
+	close(gate.release)
+	if nack, err := readAck(conn); err != nil || nack.Ack || nack.Error != "read_only" || nack.Message != "store migration" || nack.Seq != 1 {
+		t.Errorf("Expected seq 1 refused as read_only, but got %+v %v", nack, err)
+	}
+
+	h.ReadOnly.Set(false, "")
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: 2})
+	if ack, err := readAck(conn); err != nil || !ack.Ack || ack.Seq != 2 {
+		t.Errorf("Expected the connection kept open and seq 2 acked, but got %+v %v", ack, err)
+	}
+}
@@ -0,0 +1,71 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+// receiptHeader carries an HTTP upload's receipt as base64url JSON, so the
+// response body keeps its shape whether or not receipts are on.
+const receiptHeader = "X-Upload-Receipt"
+
+// Receipts signs upload receipts with Config.ReceiptKeys. A nil *Receipts
+// issues none.
+type Receipts struct {
+	keys []receipt.Key
+}
+
+// NewReceipts returns nil when no receipt keys are configured.
+func NewReceipts(cfg Config) *Receipts {
+	if len(cfg.ReceiptKeys) == 0 {
+		return nil
+	}
+	return &Receipts{keys: cfg.ReceiptKeys}
+}
+
+// Issue signs a receipt for n bytes with the given checksum, accepted as
+// chunkID at receivedAt.
+func (rs *Receipts) Issue(chunkID, checksum string, n int, receivedAt time.Time) *receipt.Receipt {
+	if rs == nil {
+		return nil
+	}
+	signed := rs.keys[0].Sign(receipt.Receipt{ChunkID: chunkID, Checksum: checksum, Bytes: int64(n), ReceivedAt: receivedAt})
+	return &signed
+}
+
+// setHeader attaches a receipt for the upload to w.
+func (rs *Receipts) setHeader(w http.ResponseWriter, chunkID, checksum string, n int, receivedAt time.Time) {
+	if r := rs.Issue(chunkID, checksum, n, receivedAt); r != nil {
+		raw, _ := json.Marshal(r)
+		w.Header().Set(receiptHeader, base64.RawURLEncoding.EncodeToString(raw))
+	}
+}
+
+// KeySet lists the public half of every configured key, the signing key
+// first and marked active.
+func (rs *Receipts) KeySet() receipt.KeySet {
+	set := receipt.KeySet{Keys: []receipt.PublicKey{}}
+	if rs == nil {
+		return set
+	}
+	for i, k := range rs.keys {
+		pub := k.Public()
+		pub.Active = i == 0
+		set.Keys = append(set.Keys, pub)
+	}
+	return set
+}
+
+// handleReceiptKeys serves GET /.well-known/audio-processor/keys. It needs
+// no API key: anyone holding a receipt may check it.
+func handleReceiptKeys(rs *Receipts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		json.NewEncoder(w).Encode(rs.KeySet())
+	}
+}
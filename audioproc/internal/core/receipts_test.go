@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+func receiptKey(t *testing.T, id string, fill byte) receipt.Key {
+	t.Helper()
+	keys, err := receipt.ParseKeys(id + "=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys[0]
+}
+
+func TestUploadReceipts(t *testing.T) {
+	retired, current := receiptKey(t, "2024-01", 1), receiptKey(t, "2024-06", 2)
+	cfg := DefaultConfig()
+	cfg.APIKeys = map[string]string{"key1": "user1"}
+	cfg.ReceiptKeys = []receipt.Key{current, retired}
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	// The keys need no API key, and list the retired key after the active one.
+	resp, err := http.Get(h.URL + receipt.KeysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys receipt.KeySet
+	json.NewDecoder(resp.Body).Decode(&keys)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(keys.Keys) != 2 || !keys.Keys[0].Active || keys.Keys[1].ID != "2024-01" || keys.Keys[1].Active {
+		t.Fatalf("Expected both keys with the current one active, but got %v %+v", resp.StatusCode, keys)
+	}
+	if err := keys.Verify(retired.Sign(receipt.Receipt{ChunkID: "old", ReceivedAt: time.Now()})); err != nil {
+		t.Errorf("Expected a receipt from before the rotation to verify, but got %v", err)
+	}
+
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	resp, err = http.Post(h.URL+"/upload?user_id=user1&session_id=s1&api_key=key1", "audio/wav", bytes.NewReader(wav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	raw, _ := base64.RawURLEncoding.DecodeString(resp.Header.Get(receiptHeader))
+	var rc receipt.Receipt
+	if err := json.Unmarshal(raw, &rc); err != nil || rc.ChunkID != meta.ChunkID || rc.KeyID != "2024-06" {
+		t.Fatalf("Expected a receipt for %s signed with the current key, but got %+v %v", meta.ChunkID, rc, err)
+	}
+	if err := keys.VerifyData(rc, wav); err != nil {
+		t.Errorf("Expected the upload receipt to verify, but got %v", err)
+	}
+	if rc.Checksum != meta.Checksum || !rc.ReceivedAt.Equal(meta.Timestamp) {
+		t.Errorf("Expected the receipt to agree with the metadata, but got %+v", rc)
+	}
+
+	// The client checks WebSocket receipts itself.
+	ctx := context.Background()
+	c, err := client.Dial(ctx, h.WSURL("/ws")+"?api_key=key1", "user1", "s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ack, err := c.Send(ctx, wav)
+	if err != nil || ack.Receipt == nil || ack.Receipt.ChunkID != ack.ChunkID {
+		t.Fatalf("Expected a verified receipt, but got %+v %v", ack.Receipt, err)
+	}
+
+	// Keys that do not match the signatures make Send fail.
+	forged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(receipt.KeySet{Keys: []receipt.PublicKey{receiptKey(t, "2024-06", 3).Public()}})
+	}))
+	defer forged.Close()
+	c2, err := client.Dial(ctx, h.WSURL("/ws")+"?api_key=key1", "user1", "s3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	c2.KeysURL = forged.URL
+	if _, err := c2.Send(ctx, wav); !errors.Is(err, receipt.ErrBadSignature) {
+		t.Errorf("Expected a bad signature error, but got %v", err)
+	}
+	if c2.Pending() != 0 {
+		t.Errorf("Expected the accepted chunk not to be resent, but %d are pending", c2.Pending())
+	}
+}
+
+func TestNoReceiptsWithoutKeys(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(SineWAV(440, 100*time.Millisecond, 8000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get(receiptHeader); got != "" {
+		t.Errorf("Expected no receipt, but got %q", got)
+	}
+	if body := getBody(t, h.URL+receipt.KeysPath); body != "{\"keys\":[]}\n" {
+		t.Errorf("Expected an empty key set, but got %q", body)
+	}
+}
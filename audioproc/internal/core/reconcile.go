@@ -0,0 +1,209 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// integrityMissingBlob marks metadata whose audio is gone from the blob
+// store.
+const integrityMissingBlob = "missing_blob"
+
+// ReconcileReport lists what a reconciliation pass deleted and flagged, or
+// with DryRun, what it would have.
+type ReconcileReport struct {
+	DryRun bool `json:"dry_run"`
+	// OrphanBlobs are blobs with no metadata, and MissingBlobs metadata
+	// with no blob. Recovered were flagged missing but have their blob
+	// again, so the flag is cleared.
+	OrphanBlobs  []string `json:"orphan_blobs"`
+	MissingBlobs []string `json:"missing_blobs"`
+	Recovered    []string `json:"recovered,omitempty"`
+}
+
+// Reconciler deletes blobs that no metadata points at and flags metadata
+// whose blob is missing. Ingest saves a chunk's blob just before its
+// metadata, so anything younger than Grace is left for a later pass.
+type Reconciler struct {
+	Grace time.Duration
+	// Rate caps deletions and flags per second; 0 is unlimited.
+	Rate float64
+
+	store *MemoryStore
+}
+
+// NewReconciler checks store with cfg's grace period and rate.
+func NewReconciler(cfg Config, store *MemoryStore) *Reconciler {
+	return &Reconciler{Grace: cfg.ReconcileGrace, Rate: cfg.ReconcileRate, store: store}
+}
+
+// Reconcile runs one pass. With dryRun it only reports.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (ReconcileReport, error) {
+	cutoff := r.store.Clock.Now().Add(-r.Grace)
+	report := r.store.reconcileCandidates(cutoff)
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	var limiter <-chan time.Time
+	if r.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.Rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+	wait := func() error {
+		if limiter == nil {
+			return ctx.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-limiter:
+			return nil
+		}
+	}
+
+	// Each step re-checks under the store lock, so a chunk whose upload
+	// finished since the listing is kept as it is.
+	done := ReconcileReport{OrphanBlobs: []string{}, MissingBlobs: []string{}}
+	for _, id := range report.OrphanBlobs {
+		if err := wait(); err != nil {
+			return done, err
+		}
+		if r.store.deleteOrphanBlob(id, cutoff) {
+			done.OrphanBlobs = append(done.OrphanBlobs, id)
+		}
+	}
+	for _, id := range report.MissingBlobs {
+		if err := wait(); err != nil {
+			return done, err
+		}
+		if r.store.setIntegrityStatus(id, integrityMissingBlob) {
+			done.MissingBlobs = append(done.MissingBlobs, id)
+		}
+	}
+	for _, id := range report.Recovered {
+		if err := wait(); err != nil {
+			return done, err
+		}
+		if r.store.setIntegrityStatus(id, "") {
+			done.Recovered = append(done.Recovered, id)
+		}
+	}
+	return done, nil
+}
+
+// reconcileCandidates lists orphaned blobs and metadata missing its blob,
+// both older than cutoff, and flagged metadata whose blob is back. Trashed
+// chunks count as metadata; archived ones have no blob by design.
+func (s *MemoryStore) reconcileCandidates(cutoff time.Time) ReconcileReport {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	report := ReconcileReport{OrphanBlobs: []string{}, MissingBlobs: []string{}}
+	for id := range s.blobs {
+		if !s.blobReferencedLocked(id) && s.blobSaved[id].Before(cutoff) {
+			report.OrphanBlobs = append(report.OrphanBlobs, id)
+		}
+	}
+	s.eachLocked(func(m Metadata) {
+		_, hot := s.blobs[m.ChunkID]
+		switch {
+		case hot && m.IntegrityStatus == integrityMissingBlob:
+			report.Recovered = append(report.Recovered, m.ChunkID)
+		case !hot && m.Archive == nil && m.IntegrityStatus == "" && m.Timestamp.Before(cutoff):
+			report.MissingBlobs = append(report.MissingBlobs, m.ChunkID)
+		}
+	})
+	sort.Strings(report.OrphanBlobs)
+	sort.Strings(report.MissingBlobs)
+	sort.Strings(report.Recovered)
+	return report
+}
+
+// deleteOrphanBlob removes a blob if it still has no metadata and was
+// written before cutoff.
+func (s *MemoryStore) deleteOrphanBlob(id string, cutoff time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[id]; !ok || !s.blobSaved[id].Before(cutoff) {
+		return false
+	}
+	if s.blobReferencedLocked(id) {
+		return false
+	}
+	delete(s.blobs, id)
+	delete(s.blobSaved, id)
+	s.logBlobLocked(id)
+	return true
+}
+
+// setIntegrityStatus flags or, with "", clears a chunk's integrity status.
+// Flagging only applies while the blob is still missing, and clearing only
+// once it is back.
+func (s *MemoryStore) setIntegrityStatus(id, status string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.lookupLocked(id)
+	if !ok || m.IntegrityStatus == status {
+		return false
+	}
+	_, hot := s.blobs[id]
+	if missing := status != ""; missing == hot || m.Archive != nil {
+		return false
+	}
+	m.IntegrityStatus = status
+	m.SchemaVersion = currentSchemaVersion
+	s.metadata[id] = m
+	delete(s.legacy, id)
+	s.changedLocked(changeUpdate, id)
+	return true
+}
+
+// RunReconcileSweeper does nothing unless a reconcile interval is
+// configured. Runs are skipped while ro is on.
+func RunReconcileSweeper(ctx context.Context, r *Reconciler, ro *ReadOnly, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ro.Enabled() {
+				continue
+			}
+			report, err := r.Reconcile(ctx, false)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Reconcile failed: %v", err)
+			}
+			if n := len(report.OrphanBlobs); n > 0 {
+				log.Printf("Deleted %d orphaned blobs", n)
+			}
+			if n := len(report.MissingBlobs); n > 0 {
+				log.Printf("Flagged %d chunks missing their audio", n)
+			}
+		}
+	}
+}
+
+// handleReconcile reports what a reconciliation pass would clean up,
+// without changing anything.
+func handleReconcile(r *Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		report, err := r.Reconcile(req.Context(), true)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
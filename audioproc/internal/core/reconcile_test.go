@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReconcileOrphans(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.ReconcileGrace = time.Hour
+	cfg.ReconcileRate = 0
+	store := NewMemoryStore()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	store.Clock = clock
+	h := NewHarnessWithStore(cfg, store)
+	defer h.Close()
+
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	store.Save(Metadata{ChunkID: "healthy", UserID: "user1", Timestamp: start})
+	store.SaveBlob("healthy", wav)
+	store.SaveBlob("orphan", wav)
+	store.Save(Metadata{ChunkID: "lost", UserID: "user1", Timestamp: start})
+	store.Save(Metadata{ChunkID: "archived", UserID: "user1", Timestamp: start, Archive: &ArchiveLocation{File: "2024-03-01.tar.gz"}})
+	clock.Advance(2 * time.Hour)
+	// An upload in flight has its blob saved but not yet its metadata.
+	store.SaveBlob("uploading", wav)
+	store.Save(Metadata{ChunkID: "recent", UserID: "user1", Timestamp: clock.Now()})
+
+	var dry ReconcileReport
+	if status := getBilling(t, h, "/admin/reconcile", &dry); status != http.StatusOK {
+		t.Fatalf("Expected 200, but got %d", status)
+	}
+	if !dry.DryRun || !slices.Equal(dry.OrphanBlobs, []string{"orphan"}) || !slices.Equal(dry.MissingBlobs, []string{"lost"}) {
+		t.Errorf("Expected the old orphan blob and the lost chunk, but got %+v", dry)
+	}
+	if _, err := store.GetBlob("orphan"); err != nil {
+		t.Errorf("Expected the dry run to leave the orphan blob, but got %v", err)
+	}
+
+	report, err := h.Reconciler.Reconcile(context.Background(), false)
+	if err != nil || !slices.Equal(report.OrphanBlobs, dry.OrphanBlobs) || !slices.Equal(report.MissingBlobs, dry.MissingBlobs) {
+		t.Errorf("Expected the dry run's outcome, but got %+v, %v", report, err)
+	}
+	if _, err := store.GetBlob("orphan"); err == nil {
+		t.Errorf("Expected the orphan blob to be deleted")
+	}
+	for _, id := range []string{"healthy", "uploading"} {
+		if _, err := store.GetBlob(id); err != nil {
+			t.Errorf("%s: expected the blob to be kept, but got %v", id, err)
+		}
+	}
+	for id, want := range map[string]string{"lost": integrityMissingBlob, "healthy": "", "archived": "", "recent": ""} {
+		if m, _ := store.Get(id); m.IntegrityStatus != want {
+			t.Errorf("%s: expected integrity status %q, but got %q", id, want, m.IntegrityStatus)
+		}
+	}
+
+	// A flagged chunk whose audio turns up again is cleared.
+	store.SaveBlob("lost", wav)
+	if report, _ := h.Reconciler.Reconcile(context.Background(), false); !slices.Equal(report.Recovered, []string{"lost"}) {
+		t.Errorf("Expected the lost chunk recovered, but got %+v", report)
+	}
+	if m, _ := store.Get("lost"); m.IntegrityStatus != "" {
+		t.Errorf("Expected the flag cleared, but got %q", m.IntegrityStatus)
+	}
+}
+
+func TestReconcileIsRateLimited(t *testing.T) {
+	store := NewMemoryStore()
+	clock := NewFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	store.Clock = clock
+	for i := 0; i < 5; i++ {
+		store.SaveBlob(fmt.Sprintf("orphan%d", i), []byte("audio"))
+	}
+	clock.Advance(2 * time.Hour)
+	cfg := DefaultConfig()
+	cfg.ReconcileRate = 50
+	r := NewReconciler(cfg, store)
+
+	began := time.Now()
+	report, err := r.Reconcile(context.Background(), false)
+	if err != nil || len(report.OrphanBlobs) != 5 {
+		t.Fatalf("Expected 5 orphans deleted, but got %+v, %v", report, err)
+	}
+	if elapsed := time.Since(began); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected 5 deletions at 50/s to take at least 80ms, but took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store.SaveBlob("late", []byte("audio"))
+	clock.Advance(2 * time.Hour)
+	if _, err := r.Reconcile(ctx, false); err == nil {
+		t.Errorf("Expected a cancelled pass to stop")
+	}
+}
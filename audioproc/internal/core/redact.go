@@ -0,0 +1,174 @@
+package core
+
+import (
+	"expvar"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// redactions counts redacted matches by category.
+var redactions = expvar.NewMap("transcript_redactions")
+
+// RedactionRule replaces matches of Pattern in transcripts with a
+// placeholder naming Category, e.g. "[CARD]".
+type RedactionRule struct {
+	Category string
+	Pattern  *regexp.Regexp
+	// Valid, when set, must accept a match for it to be redacted.
+	Valid func(match string) bool
+}
+
+// builtinRedactions are the rules ParseRedactionRules knows by name.
+var builtinRedactions = map[string]RedactionRule{
+	"card":  {Category: "card", Pattern: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), Valid: luhnValid},
+	"phone": {Category: "phone", Pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{3}\)[ .-]?|\b\d{3}[ .-]?)\d{3}[ .-]?\d{4}\b`)},
+	"email": {Category: "email", Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)},
+}
+
+// luhnValid reports whether the digits in s pass the Luhn check, which
+// every payment card number does and most other digit runs do not.
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// ParseRedactionRules reads semicolon-separated rules, each either the name
+// of a builtin set (card, phone, email) or category=regexp, e.g.
+// "card;phone;email;employee_id=EMP-\d{6}". Rules listed first win where
+// matches overlap.
+func ParseRedactionRules(s string) ([]RedactionRule, error) {
+	var rules []RedactionRule
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		category, pattern, custom := strings.Cut(spec, "=")
+		if !custom {
+			rule, ok := builtinRedactions[spec]
+			if !ok {
+				return nil, fmt.Errorf("redaction rule %q: builtin sets are card, phone and email", spec)
+			}
+			rules = append(rules, rule)
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil || category == "" {
+			return nil, fmt.Errorf("redaction rule %q: want category=regexp", spec)
+		}
+		rules = append(rules, RedactionRule{Category: category, Pattern: re})
+	}
+	return rules, nil
+}
+
+type redactedSpan struct {
+	start, end int
+	category   string
+}
+
+// findRedactions returns the spans of text that rules redact, in order.
+func findRedactions(rules []RedactionRule, text string) []redactedSpan {
+	var spans []redactedSpan
+	for _, rule := range rules {
+	matches:
+		for _, loc := range rule.Pattern.FindAllStringIndex(text, -1) {
+			if rule.Valid != nil && !rule.Valid(text[loc[0]:loc[1]]) {
+				continue
+			}
+			for _, s := range spans {
+				if loc[0] < s.end && s.start < loc[1] {
+					continue matches
+				}
+			}
+			spans = append(spans, redactedSpan{loc[0], loc[1], rule.Category})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	return spans
+}
+
+func redactionPlaceholder(category string) string {
+	return "[" + strings.ToUpper(category) + "]"
+}
+
+// redactTranscript scrubs t with rules and counts what it replaced by
+// category. Words overlapping a redacted span are folded into one word
+// holding the placeholder and spanning their combined time.
+func redactTranscript(rules []RedactionRule, t Transcription) (Transcription, map[string]int) {
+	spans := findRedactions(rules, t.Text)
+	if len(spans) == 0 {
+		return t, nil
+	}
+	counts := make(map[string]int)
+	for _, s := range spans {
+		counts[s.category]++
+		redactions.Add(s.category, 1)
+	}
+
+	words := make([]Word, 0, len(t.Words))
+	cursor, folded := 0, -1
+	for _, w := range t.Words {
+		i := strings.Index(t.Text[cursor:], w.Text)
+		if i < 0 {
+			// A word that is not in the text cannot be placed; scrub it
+			// on its own rather than risk keeping it raw.
+			w.Text = redactAlone(rules, w.Text)
+			words = append(words, w)
+			continue
+		}
+		start := cursor + i
+		cursor = start + len(w.Text)
+		span := -1
+		for j, s := range spans {
+			if start < s.end && s.start < cursor {
+				span = j
+				break
+			}
+		}
+		switch {
+		case span < 0:
+			words = append(words, w)
+		case span == folded:
+			words[len(words)-1].EndMS = w.EndMS
+		default:
+			w.Text = redactionPlaceholder(spans[span].category)
+			words = append(words, w)
+			folded = span
+		}
+	}
+	return Transcription{Text: scrub(t.Text, spans), Words: words}, counts
+}
+
+// scrub replaces spans of text with their placeholders.
+func scrub(text string, spans []redactedSpan) string {
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		b.WriteString(text[last:s.start])
+		b.WriteString(redactionPlaceholder(s.category))
+		last = s.end
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// redactAlone scrubs a single word.
+func redactAlone(rules []RedactionRule, text string) string {
+	return scrub(text, findRedactions(rules, text))
+}
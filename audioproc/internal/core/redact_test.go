@@ -0,0 +1,71 @@
+package core
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scriptTranscriber returns its text as the transcript, one timed word per
+// field.
+type scriptTranscriber string
+
+func (s scriptTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	return Transcription{Text: string(s), Words: spreadWords(strings.Fields(string(s)), 1000)}, nil
+}
+
+func TestRedactTranscriptsBeforeStorage(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RedactionRules, _ = ParseRedactionRules("card;phone;email")
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Pipeline.Transcriber = scriptTranscriber("pay with 4111 1111 1111 1111 not 1234 5678 9012 3456 call (555) 123-4567 or mail jo.smith@example.co.uk thanks")
+
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, time.Second, 8000))
+	want := "pay with [CARD] not 1234 5678 9012 3456 call [PHONE] or mail [EMAIL] thanks"
+	stored, err := h.Store.Get(meta.ChunkID)
+	if err != nil || stored.Transcript != want {
+		t.Fatalf("Expected the stored transcript\n%q\nbut got\n%q %v", want, stored.Transcript, err)
+	}
+	if !maps.Equal(stored.Redactions, map[string]int{"card": 1, "phone": 1, "email": 1}) {
+		t.Errorf("Expected one redaction per category, but got %v", stored.Redactions)
+	}
+	var words []string
+	for _, w := range stored.Words {
+		words = append(words, w.Text)
+	}
+	if got := strings.Join(words, " "); got != want {
+		t.Errorf("Expected the words to be redacted too, but got %q", got)
+	}
+	if card := stored.Words[2]; card.EndMS != stored.Words[3].StartMS || card.EndMS-card.StartMS <= stored.Words[0].EndMS-stored.Words[0].StartMS {
+		t.Errorf("Expected the card's four words folded into one, but got %+v", stored.Words[:4])
+	}
+	for _, raw := range []string{"4111", "jo.smith", "4567"} {
+		if hits := h.Store.SearchTranscripts(raw, "user1", 10); len(hits) != 0 {
+			t.Errorf("Expected %q not to be searchable, but got %+v", raw, hits)
+		}
+	}
+
+	adminDo(t, h, "PATCH", "/chunks/"+meta.ChunkID, map[string]string{"transcript": "my number is +1 555 987 6543"}, nil)
+	if stored, _ := h.Store.Get(meta.ChunkID); stored.Transcript != "my number is [PHONE]" || stored.Redactions["phone"] != 1 {
+		t.Errorf("Expected a patched transcript to be redacted, but got %q %v", stored.Transcript, stored.Redactions)
+	}
+}
+
+func TestParseRedactionRules(t *testing.T) {
+	rules, err := ParseRedactionRules(`email; employee_id=EMP-\d{6}`)
+	if err != nil || len(rules) != 2 {
+		t.Fatalf("Expected two rules, but got %v %v", rules, err)
+	}
+	got, counts := redactTranscript(rules, Transcription{Text: "EMP-004211 is bob@example.com"})
+	if got.Text != "[EMPLOYEE_ID] is [EMAIL]" || counts["employee_id"] != 1 {
+		t.Errorf("Expected the custom rule to apply, but got %q %v", got.Text, counts)
+	}
+	for _, bad := range []string{"ssn", "id=(", "=x"} {
+		if _, err := ParseRedactionRules(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// A replay file is JSON lines: a ReplayHeader followed by one ReplayFrame
+// per inbound WebSocket message, in arrival order.
+const replayVersion = 1
+
+// ReplayHeader describes a recorded WebSocket session. URL and Header are
+// redacted the same way as debug captures.
+type ReplayHeader struct {
+	Version    int         `json:"version"`
+	UserID     string      `json:"user_id"`
+	SessionID  string      `json:"session_id"`
+	RecordedAt time.Time   `json:"recorded_at"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header"`
+}
+
+// ReplayFrame is one inbound message, OffsetMS after the connection opened.
+type ReplayFrame struct {
+	OffsetMS int64  `json:"offset_ms"`
+	Text     bool   `json:"text,omitempty"`
+	Data     []byte `json:"data"`
+}
+
+// SessionRecorder writes replay files to Dir for the WebSocket sessions an
+// operator has enabled. Users in OptOut are never recorded.
+type SessionRecorder struct {
+	Dir    string
+	OptOut map[string]bool
+	Clock  Clock
+
+	mu       sync.Mutex
+	sessions map[string]bool
+}
+
+// NewSessionRecorder writes to cfg.ReplayDir and never records the users in
+// cfg.DebugCaptureOptOut.
+func NewSessionRecorder(cfg Config) *SessionRecorder {
+	rc := &SessionRecorder{
+		Dir:      cfg.ReplayDir,
+		OptOut:   make(map[string]bool),
+		Clock:    realClock{},
+		sessions: make(map[string]bool),
+	}
+	for _, u := range cfg.DebugCaptureOptOut {
+		rc.OptOut[u] = true
+	}
+	return rc
+}
+
+// Enable records the session's next WebSocket connections until Disable.
+func (rc *SessionRecorder) Enable(userID, sessionID string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.sessions[sessionKey(userID, sessionID)] = true
+}
+
+// Disable stops recording a session.
+func (rc *SessionRecorder) Disable(userID, sessionID string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.sessions, sessionKey(userID, sessionID))
+}
+
+func (rc *SessionRecorder) enabled(userID, sessionID string) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.sessions[sessionKey(userID, sessionID)] && !rc.OptOut[userID]
+}
+
+// Start opens a recording of the connection r if its session is enabled.
+// It returns nil, and logs why, when there is nothing to record.
+func (rc *SessionRecorder) Start(r *http.Request, userID, sessionID string) *recording {
+	if rc == nil || !rc.enabled(userID, sessionID) {
+		return nil
+	}
+	if err := os.MkdirAll(rc.Dir, 0o700); err != nil {
+		log.Printf("Recording of session %s not started: %v", sessionKey(userID, sessionID), err)
+		return nil
+	}
+	id := uuid.New().String()
+	f, err := os.OpenFile(filepath.Join(rc.Dir, id+".jsonl"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Printf("Recording of session %s not started: %v", sessionKey(userID, sessionID), err)
+		return nil
+	}
+	rec := &recording{ID: id, f: f, w: bufio.NewWriter(f), clock: rc.Clock, start: rc.Clock.Now()}
+	rec.write(ReplayHeader{
+		Version:    replayVersion,
+		UserID:     userID,
+		SessionID:  sessionID,
+		RecordedAt: rec.start,
+		URL:        redactURL(r),
+		Header:     redactHeader(r.Header),
+	})
+	return rec
+}
+
+// Path is where the recording with the given ID is stored.
+func (rc *SessionRecorder) Path(id string) (string, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return "", fmt.Errorf("%w: recording %s", ErrNotFound, id)
+	}
+	return filepath.Join(rc.Dir, id+".jsonl"), nil
+}
+
+// recording is an open replay file. Its methods do nothing on nil.
+type recording struct {
+	ID    string
+	f     *os.File
+	w     *bufio.Writer
+	clock Clock
+	start time.Time
+	err   error
+}
+
+// Frame appends an inbound message.
+func (rec *recording) Frame(msgType int, msg []byte) {
+	if rec == nil {
+		return
+	}
+	rec.write(ReplayFrame{
+		OffsetMS: rec.clock.Now().Sub(rec.start).Milliseconds(),
+		Text:     msgType == websocket.TextMessage,
+		Data:     msg,
+	})
+}
+
+func (rec *recording) write(v any) {
+	if rec.err != nil {
+		return
+	}
+	line, err := json.Marshal(v)
+	if err == nil {
+		line = append(line, '\n')
+		_, err = rec.w.Write(line)
+	}
+	rec.err = err
+}
+
+func (rec *recording) Close() {
+	if rec == nil {
+		return
+	}
+	if err := errors.Join(rec.err, rec.w.Flush(), rec.f.Close()); err != nil {
+		log.Printf("Recording %s is incomplete: %v", rec.ID, err)
+	}
+}
+
+// ReadReplay parses a replay file.
+func ReadReplay(r io.Reader) (ReplayHeader, []ReplayFrame, error) {
+	dec := json.NewDecoder(r)
+	var header ReplayHeader
+	if err := dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("replay header: %w", err)
+	}
+	if header.Version != replayVersion || header.UserID == "" {
+		return header, nil, fmt.Errorf("unsupported replay file version %d", header.Version)
+	}
+	var frames []ReplayFrame
+	for {
+		var f ReplayFrame
+		err := dec.Decode(&f)
+		if errors.Is(err, io.EOF) {
+			return header, frames, nil
+		}
+		if err != nil {
+			return header, nil, fmt.Errorf("replay frame %d: %w", len(frames), err)
+		}
+		frames = append(frames, f)
+	}
+}
+
+// ReplayResult is what a replay produced.
+type ReplayResult struct {
+	SessionID string     `json:"session_id"`
+	ReplayOf  string     `json:"replay_of"`
+	Chunks    []Metadata `json:"chunks"`
+	// Rejected counts chunks the original connection would have refused,
+	// such as ones failing their checksum.
+	Rejected int `json:"rejected"`
+}
+
+// replaySession feeds recorded frames back through ingest under a new
+// session ID, waiting out the original gaps between frames unless fast is
+// set. It interprets frames the way handleWebSocket does, except that
+// hellos cannot move the replay to another session and seqs are not
+// deduplicated against the original session's acks.
+func replaySession(ctx context.Context, store Store, jobs chan<- Job, sessions *SessionTracker, rules AudioRules, header ReplayHeader, frames []ReplayFrame, fast bool) (ReplayResult, error) {
+	result := ReplayResult{SessionID: uuid.New().String(), ReplayOf: header.SessionID, Chunks: []Metadata{}}
+	start := time.Now()
+	for i := 0; i < len(frames); i++ {
+		f := frames[i]
+		if !fast {
+			if err := sleepCtx(ctx, time.Until(start.Add(time.Duration(f.OffsetMS)*time.Millisecond))); err != nil {
+				return result, err
+			}
+		}
+		env := parseWSEnvelope(frameType(f), f.Data)
+		switch env.Type {
+		case "hello", "checksum", "probe":
+			continue
+		case "end_session":
+			sessions.End(header.UserID, result.SessionID)
+			continue
+		}
+		checksum := env.Checksum
+		if env.Trailer == "checksum" {
+			if i+1 < len(frames) {
+				i++
+				checksum = parseWSEnvelope(frameType(frames[i]), frames[i].Data).Checksum
+			}
+		}
+		sum := chunkChecksum(env.Data)
+		if err := verifyChecksum(sum, checksum); err != nil {
+			result.Rejected++
+			continue
+		}
+		meta, err := ingest(ctx, store, jobs, sessions, rules, AudioChunk{
+			ChunkID:        uuid.New().String(),
+			UserID:         header.UserID,
+			SessionID:      result.SessionID,
+			Timestamp:      time.Now(),
+			Data:           env.Data,
+			Checksum:       sum,
+			ClientMetadata: env.ClientMetadata,
+			ReplayOf:       header.SessionID,
+			Priority:       PriorityBatch,
+		})
+		var invalid *ValidationError
+		if errors.Is(err, errSessionClosed) || errors.As(err, &invalid) {
+			result.Rejected++
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Chunks = append(result.Chunks, meta)
+	}
+	return result, nil
+}
+
+func frameType(f ReplayFrame) int {
+	if f.Text {
+		return websocket.TextMessage
+	}
+	return websocket.BinaryMessage
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleReplay replays the replay file in the request body. speed=fast
+// skips the original timing; otherwise the request lasts as long as the
+// recorded session did. Frames are held to rules as they were live.
+func handleReplay(store Store, jobs chan<- Job, sessions *SessionTracker, rules AudioRules) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clearDeadlines(w)
+		header, frames, err := ReadReplay(r.Body)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_replay", err.Error())
+			return
+		}
+		result, err := replaySession(r.Context(), store, jobs, sessions, rules, header, frames, r.URL.Query().Get("speed") == "fast")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// handleSetRecording turns recording of a session on (PUT) or off (DELETE).
+func handleSetRecording(rc *SessionRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if r.Method == http.MethodDelete {
+			rc.Disable(vars["user_id"], vars["session_id"])
+		} else {
+			rc.Enable(vars["user_id"], vars["session_id"])
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleGetRecording(rc *SessionRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path, err := rc.Path(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			writeError(w, fmt.Errorf("%w: recording %s", ErrNotFound, mux.Vars(r)["id"]))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		http.ServeFile(w, r, path)
+	}
+}
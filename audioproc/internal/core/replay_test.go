@@ -0,0 +1,85 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRecordAndReplaySession(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.ReplayDir = t.TempDir()
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	req, _ := http.NewRequest("PUT", h.URL+"/admin/recordings/rec/live", nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected recording to be enabled, but got %v", resp.StatusCode)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=rec&session_id=live&api_key=secret"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	var original []string
+	for _, chunk := range [][]byte{SineWAV(220, 200*time.Millisecond, 8000), []byte("second"), SineWAV(880, 100*time.Millisecond, 8000)} {
+		conn.WriteMessage(websocket.BinaryMessage, chunk)
+		var ack struct{ Metadata Metadata }
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatal(err)
+		}
+		original = append(original, ack.Metadata.Checksum)
+	}
+	conn.Close()
+
+	var recorded []byte
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		paths, _ := filepath.Glob(filepath.Join(cfg.ReplayDir, "*.jsonl"))
+		if len(paths) == 1 {
+			data, _ := os.ReadFile(paths[0])
+			if _, frames, err := ReadReplay(bytes.NewReader(data)); err == nil && len(frames) == 3 {
+				recorded = data
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if recorded == nil {
+		t.Fatal("Expected a recording with three frames")
+	}
+	if bytes.Contains(recorded, []byte("secret")) {
+		t.Errorf("Expected the API key to be redacted from the recording")
+	}
+
+	req, _ = http.NewRequest("POST", h.URL+"/admin/replay?speed=fast", bytes.NewReader(recorded))
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result ReplayResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK || result.ReplayOf != "live" || result.SessionID == "live" || len(result.Chunks) != 3 {
+		t.Fatalf("Expected three replayed chunks in a new session, but got %v %+v", resp.StatusCode, result)
+	}
+	for i, m := range result.Chunks {
+		if m.Checksum != original[i] || m.ReplayOf != "live" || m.SessionID != result.SessionID {
+			t.Errorf("Expected replayed chunk %d to match the original %s, but got %+v", i, original[i], m)
+		}
+	}
+}
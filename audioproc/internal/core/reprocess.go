@@ -0,0 +1,423 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// ProcessFunc runs a single chunk through the pipeline.
+type ProcessFunc func(ctx context.Context, chunk AudioChunk) (Metadata, error)
+
+// ReprocessFilter selects the chunks a reprocessing job runs over.
+type ReprocessFilter struct {
+	UserID     string     `json:"user_id,omitempty"`
+	SessionID  string     `json:"session_id,omitempty"`
+	From       *time.Time `json:"from,omitempty"`
+	To         *time.Time `json:"to,omitempty"`
+	FailedOnly bool       `json:"failed_only,omitempty"`
+	// SkippedOnly matches chunks processed while a stage was switched off.
+	SkippedOnly bool `json:"skipped_only,omitempty"`
+	// PartialOnly matches chunks saved without some results because they
+	// ran out of processing time.
+	PartialOnly bool `json:"partial_only,omitempty"`
+}
+
+func (f ReprocessFilter) match(m Metadata) bool {
+	return (f.UserID == "" || m.UserID == f.UserID) &&
+		(f.SessionID == "" || m.SessionID == f.SessionID) &&
+		(f.From == nil || !m.Timestamp.Before(*f.From)) &&
+		(f.To == nil || m.Timestamp.Before(*f.To)) &&
+		(!f.FailedOnly || m.Status == "failed") &&
+		(!f.SkippedOnly || len(m.Skipped) > 0) &&
+		(!f.PartialOnly || m.Status == "partial")
+}
+
+const (
+	jobRunning     = "running"
+	jobDone        = "done"
+	jobCancelled   = "cancelled"
+	jobInterrupted = "interrupted"
+)
+
+var (
+	errJobNotFound     = newKindError(ErrNotFound, "reprocess job not found")
+	errJobNotResumable = newKindError(ErrConflict, "job is not interrupted")
+)
+
+// ReprocessStatus is a reprocessing job's progress.
+type ReprocessStatus struct {
+	ID         string          `json:"job_id"`
+	Filter     ReprocessFilter `json:"filter"`
+	State      string          `json:"state"`
+	Total      int             `json:"total"`
+	Processed  int             `json:"processed"`
+	Failed     int             `json:"failed"`
+	Cursor     string          `json:"cursor,omitempty"`
+	ETASeconds float64         `json:"eta_seconds"`
+}
+
+type reprocessJob struct {
+	mu        sync.Mutex
+	status    ReprocessStatus
+	cancel    context.CancelFunc
+	cancelled bool
+	started   time.Time
+	// counts as of Cursor; live counts may run ahead of it.
+	checkpointProcessed, checkpointFailed int
+}
+
+// Reprocessor runs bulk reprocessing jobs in the background. Jobs stop when
+// the context given to NewReprocessor ends and keep a cursor of the last
+// contiguously finished chunk so Resume can pick up where they left off.
+type Reprocessor struct {
+	ctx         context.Context
+	store       *MemoryStore
+	process     ProcessFunc
+	clock       Clock
+	concurrency int
+	rate        float64
+
+	mu   sync.Mutex
+	jobs map[string]*reprocessJob
+	wg   sync.WaitGroup
+}
+
+// NewReprocessor loads job checkpoints from store; jobs that were running
+// when the previous process stopped come back as interrupted.
+func NewReprocessor(ctx context.Context, store *MemoryStore, process ProcessFunc, cfg Config) *Reprocessor {
+	p := &Reprocessor{
+		ctx:         ctx,
+		store:       store,
+		process:     process,
+		clock:       realClock{},
+		concurrency: cfg.ReprocessConcurrency,
+		rate:        cfg.ReprocessRate,
+		jobs:        make(map[string]*reprocessJob),
+	}
+	for _, st := range store.ReprocessCheckpoints() {
+		if st.State == jobRunning {
+			st.State = jobInterrupted
+		}
+		p.jobs[st.ID] = &reprocessJob{status: st, checkpointProcessed: st.Processed, checkpointFailed: st.Failed}
+	}
+	return p
+}
+
+// Start launches a job over the chunks filter matches.
+func (p *Reprocessor) Start(filter ReprocessFilter) ReprocessStatus {
+	job := &reprocessJob{status: ReprocessStatus{ID: uuid.New().String(), Filter: filter}}
+	p.mu.Lock()
+	p.jobs[job.status.ID] = job
+	p.mu.Unlock()
+	return p.launch(job)
+}
+
+// Resume restarts a checkpointed job from its cursor. The job is marked
+// running before its lock is released, so of two Resumes racing only one
+// launches it.
+func (p *Reprocessor) Resume(id string) (ReprocessStatus, error) {
+	job, ok := p.job(id)
+	if !ok {
+		return ReprocessStatus{}, errJobNotFound
+	}
+	job.mu.Lock()
+	if job.status.State != jobInterrupted {
+		job.mu.Unlock()
+		return ReprocessStatus{}, errJobNotResumable
+	}
+	job.status.State = jobRunning
+	job.mu.Unlock()
+	return p.launch(job), nil
+}
+
+// Cancel stops a running job and reports whether there was one.
+func (p *Reprocessor) Cancel(id string) bool {
+	job, ok := p.job(id)
+	if !ok {
+		return false
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.cancelled = true
+	if job.cancel != nil {
+		job.cancel()
+	}
+	return true
+}
+
+// Status returns a job's progress.
+func (p *Reprocessor) Status(id string) (ReprocessStatus, bool) {
+	job, ok := p.job(id)
+	if !ok {
+		return ReprocessStatus{}, false
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	st := job.status
+	if done := st.Processed + st.Failed - job.checkpointProcessed - job.checkpointFailed; st.State == jobRunning && done > 0 {
+		perChunk := p.clock.Now().Sub(job.started) / time.Duration(done)
+		st.ETASeconds = (perChunk * time.Duration(st.Total-st.Processed-st.Failed)).Seconds()
+	}
+	return st, true
+}
+
+// Wait blocks until every running job has stopped.
+func (p *Reprocessor) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Reprocessor) job(id string) (*reprocessJob, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	job, ok := p.jobs[id]
+	return job, ok
+}
+
+func (p *Reprocessor) launch(job *reprocessJob) ReprocessStatus {
+	ctx, cancel := context.WithCancel(p.ctx)
+	job.mu.Lock()
+	job.cancel = cancel
+	job.started = p.clock.Now()
+	job.status.State = jobRunning
+	st := job.status
+	job.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer cancel()
+		p.run(ctx, job)
+	}()
+	return st
+}
+
+func reprocessKey(m Metadata) string {
+	return m.Timestamp.UTC().Format(time.RFC3339Nano) + "|" + m.ChunkID
+}
+
+func (p *Reprocessor) run(ctx context.Context, job *reprocessJob) {
+	job.mu.Lock()
+	filter, cursor := job.status.Filter, job.status.Cursor
+	job.mu.Unlock()
+
+	items := p.store.List(filter.match)
+	sort.Slice(items, func(i, j int) bool { return reprocessKey(items[i]) < reprocessKey(items[j]) })
+	start := sort.Search(len(items), func(i int) bool { return reprocessKey(items[i]) > cursor })
+	if cursor == "" {
+		job.mu.Lock()
+		job.status.Total = len(items)
+		job.mu.Unlock()
+	}
+	items = items[start:]
+
+	var limiter <-chan time.Time
+	if p.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / p.rate))
+		defer ticker.Stop()
+		limiter = ticker.C
+	}
+
+	// outcome[i] is 0 while pending, 1 on success and 2 on failure.
+	outcome := make([]int, len(items))
+	next := 0
+	sem := make(chan struct{}, p.concurrency)
+	var wg sync.WaitGroup
+	record := func(i, result int, meta Metadata) {
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		outcome[i] = result
+		if result == 1 {
+			job.status.Processed++
+		} else {
+			job.status.Failed++
+		}
+		for next < len(items) && outcome[next] != 0 {
+			if outcome[next] == 1 {
+				job.checkpointProcessed++
+			} else {
+				job.checkpointFailed++
+			}
+			job.status.Cursor = reprocessKey(items[next])
+			next++
+		}
+		checkpoint := job.status
+		checkpoint.Processed, checkpoint.Failed = job.checkpointProcessed, job.checkpointFailed
+		// The result and the checkpoint land together, so a crash cannot
+		// leave the cursor past a chunk whose result was never written. A
+		// chunk trashed or purged while it was processed stays that way, and
+		// a failed pass only counts against the job.
+		p.store.withTx(func(tx *memTx) error {
+			if cur, ok := tx.s.lookupLocked(meta.ChunkID); result == 1 && ok && cur.DeletedAt == nil {
+				if err := tx.saveAs(meta, revisionReprocess); err != nil {
+					return err
+				}
+			}
+			tx.SaveReprocessCheckpoint(checkpoint)
+			return nil
+		})
+	}
+
+	// Read-only mode interrupts the job, to be resumed once it is off.
+	// Results that arrive after it came on are left for the resume.
+	var halted atomic.Bool
+launch:
+	for i, m := range items {
+		if p.store.ReadOnly.Enabled() {
+			halted.Store(true)
+			break
+		}
+		if limiter != nil {
+			select {
+			case <-limiter:
+			case <-ctx.Done():
+				break launch
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break launch
+		}
+		wg.Add(1)
+		go func(i int, m Metadata) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if meta, result, ok := p.reprocessOne(ctx, m); ok {
+				if p.store.ReadOnly.Enabled() {
+					halted.Store(true)
+					return
+				}
+				record(i, result, meta)
+			}
+		}(i, m)
+	}
+	wg.Wait()
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	defer func() { p.store.SaveReprocessCheckpoint(job.status) }()
+	switch {
+	case job.cancelled:
+		job.status.State = jobCancelled
+	case ctx.Err() != nil || halted.Load():
+		// Roll live counts back to the checkpoint so a resume doesn't
+		// double count chunks finished past the cursor.
+		job.status.State = jobInterrupted
+		job.status.Processed = job.checkpointProcessed
+		job.status.Failed = job.checkpointFailed
+	default:
+		job.status.State = jobDone
+	}
+}
+
+// storedChunk rebuilds the chunk a stored record was processed from, to be
+// processed again with the user's current settings.
+func storedChunk(m Metadata, data []byte, settings UserSettings) AudioChunk {
+	return AudioChunk{
+		ChunkID:         m.ChunkID,
+		UserID:          m.UserID,
+		SessionID:       m.SessionID,
+		SessionRevision: m.SessionRevision,
+		Timestamp:       m.Timestamp,
+		RecordedAt:      m.RecordedAt,
+		ParentChunkID:   m.ParentChunkID,
+		OffsetMS:        m.OffsetMS,
+		DerivedFrom:     m.DerivedFrom,
+		SourceIP:        m.SourceIP,
+		ReplayOf:        m.ReplayOf,
+		ClientMetadata:  m.ClientMetadata,
+		Markers:         m.Markers,
+		Data:            data,
+		Settings:        settings,
+		Reprocess:       true,
+	}
+}
+
+// reprocessOne returns the metadata to save with 1 on success, or 2 on
+// failure, when the stored record is left as it was. ok is false if the
+// job was stopped before the chunk finished.
+func (p *Reprocessor) reprocessOne(ctx context.Context, m Metadata) (Metadata, int, bool) {
+	data, err := p.store.GetBlob(m.ChunkID)
+	if err == nil {
+		// Reprocessing is a fresh pass, so it follows the current settings.
+		settings, _ := p.store.UserSettings(m.UserID)
+		chunk := storedChunk(m, data, settings)
+		chunk.Priority = PriorityBatch
+		meta, err := p.process(ctx, chunk)
+		if ctx.Err() != nil {
+			return Metadata{}, 0, false
+		}
+		if err == nil {
+			return meta, 1, true
+		}
+	}
+	return Metadata{}, 2, true
+}
+
+func requireAdmin(cfg Config, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(cfg, r) {
+			writeError(w, errAdminRequired)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handleStartReprocess(p *Reprocessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var filter ReprocessFilter
+		if err := json.NewDecoder(r.Body).Decode(&filter); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_filter", err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(p.Start(filter))
+	}
+}
+
+func handleGetReprocess(p *Reprocessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st, ok := p.Status(mux.Vars(r)["job_id"])
+		if !ok {
+			writeError(w, errJobNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+func handleCancelReprocess(p *Reprocessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !p.Cancel(mux.Vars(r)["job_id"]) {
+			writeError(w, errJobNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleResumeReprocess(p *Reprocessor) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st, err := p.Resume(mux.Vars(r)["job_id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(st)
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func seedChunks(store *MemoryStore, n int) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("chunk%02d", i)
+		store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "sess1", Timestamp: base.Add(time.Duration(i) * time.Second)})
+		store.SaveBlob(id, []byte(id))
+	}
+}
+
+func waitForState(t *testing.T, p *Reprocessor, id string, states ...string) ReprocessStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		st, _ := p.Status(id)
+		for _, want := range states {
+			if st.State == want {
+				return st
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	st, _ := p.Status(id)
+	t.Fatalf("Expected job state %v, but got %+v", states, st)
+	return st
+}
+
+func TestReprocessor_CountsAndResume(t *testing.T) {
+	store := NewMemoryStore()
+	seedChunks(store, 50)
+	good, _ := store.Get("chunk07")
+	good.Status, good.Transcript = "processed", "original"
+	store.Save(good)
+
+	var calls atomic.Int64
+	process := func(ctx context.Context, chunk AudioChunk) (Metadata, error) {
+		calls.Add(1)
+		if chunk.ChunkID == "chunk07" {
+			return Metadata{}, errors.New("injected failure")
+		}
+		select {
+		case <-time.After(2 * time.Millisecond):
+		case <-ctx.Done():
+			return Metadata{}, ctx.Err()
+		}
+		return Metadata{ChunkID: chunk.ChunkID, UserID: chunk.UserID, SessionID: chunk.SessionID, Timestamp: chunk.Timestamp, Transcript: "reprocessed"}, nil
+	}
+
+	cfg := DefaultConfig()
+	cfg.ReprocessConcurrency = 2
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	first := NewReprocessor(ctx, store, process, cfg)
+	st := first.Start(ReprocessFilter{UserID: "user1"})
+
+	for calls.Load() < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	shutdown()
+	first.Wait()
+
+	interrupted, _ := first.Status(st.ID)
+	if interrupted.State != jobInterrupted {
+		t.Fatalf("Expected job to be interrupted, but got %v", interrupted.State)
+	}
+	if interrupted.Cursor == "" || interrupted.Processed+interrupted.Failed >= 50 {
+		t.Fatalf("Expected a partial checkpoint, but got %+v", interrupted)
+	}
+
+	// A new reprocessor over the same store picks the checkpoint back up.
+	second := NewReprocessor(context.Background(), store, process, cfg)
+	if _, err := second.Resume(st.ID); err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	done := waitForState(t, second, st.ID, jobDone)
+
+	if done.Total != 50 || done.Processed != 49 || done.Failed != 1 {
+		t.Errorf("Expected 50 total, 49 processed and 1 failed, but got %+v", done)
+	}
+	if m, _ := store.Get("chunk07"); m.Status != "processed" || m.Transcript != "original" {
+		t.Errorf("Expected a failed pass to keep the stored result, but got %q %q", m.Status, m.Transcript)
+	}
+	if m, _ := store.Get("chunk49"); m.Transcript != "reprocessed" {
+		t.Errorf("Expected the last chunk to be reprocessed, but got %q", m.Transcript)
+	}
+
+	retry := second.Start(ReprocessFilter{FailedOnly: true})
+	if st := waitForState(t, second, retry.ID, jobDone); st.Total != 0 {
+		t.Errorf("Expected the failed-only filter to match no chunks, but got %v", st.Total)
+	}
+}
+
+func TestReprocessor_Cancel(t *testing.T) {
+	store := NewMemoryStore()
+	seedChunks(store, 50)
+
+	process := func(ctx context.Context, chunk AudioChunk) (Metadata, error) {
+		<-ctx.Done()
+		return Metadata{}, ctx.Err()
+	}
+	p := NewReprocessor(context.Background(), store, process, DefaultConfig())
+	st := p.Start(ReprocessFilter{})
+	if !p.Cancel(st.ID) {
+		t.Fatal("Expected cancel to find the job")
+	}
+	waitForState(t, p, st.ID, jobCancelled)
+	if _, err := p.Resume(st.ID); !errors.Is(err, errJobNotResumable) {
+		t.Errorf("Expected a cancelled job not to resume, but got %v", err)
+	}
+}
+
+func TestReprocessor_ResumeOnceKeepsTrashed(t *testing.T) {
+	store := NewMemoryStore()
+	seedChunks(store, 5)
+	store.SaveReprocessCheckpoint(ReprocessStatus{ID: "job1", State: jobRunning})
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	process := func(ctx context.Context, chunk AudioChunk) (Metadata, error) {
+		calls.Add(1)
+		<-release
+		return Metadata{ChunkID: chunk.ChunkID, UserID: chunk.UserID, SessionID: chunk.SessionID, Timestamp: chunk.Timestamp, Transcript: "reprocessed"}, nil
+	}
+	p := NewReprocessor(context.Background(), store, process, DefaultConfig())
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := p.Resume("job1")
+			errs <- err
+		}()
+	}
+	first, second := <-errs, <-errs
+	if (first == nil) == (second == nil) || !errors.Is(errors.Join(first, second), errJobNotResumable) {
+		t.Fatalf("Expected one resume to launch the job and the other to be refused, but got %v and %v", first, second)
+	}
+
+	// The chunks are listed before the first is processed.
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	store.Delete("chunk02")
+	close(release)
+	if st := waitForState(t, p, "job1", jobDone); st.Processed != 5 || calls.Load() != 5 {
+		t.Errorf("Expected 5 chunks processed once each, but got %+v after %d calls", st, calls.Load())
+	}
+	if _, err := store.Get("chunk02"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the trashed chunk to stay trashed, but got %v", err)
+	}
+	if m, _ := store.Get("chunk03"); m.Transcript != "reprocessed" {
+		t.Errorf("Expected the other chunks reprocessed, but got %q", m.Transcript)
+	}
+}
@@ -0,0 +1,35 @@
+package core
+
+import "sort"
+
+// SessionAcks is the durable record of which WS sequence numbers a session
+// has had acknowledged. HighWater is the highest seq below which every seq
+// was acked; Above holds acked seqs past a gap, in ascending order.
+type SessionAcks struct {
+	HighWater int64   `json:"high_water"`
+	Above     []int64 `json:"above,omitempty"`
+}
+
+// Acked reports whether seq has been acknowledged.
+func (a SessionAcks) Acked(seq int64) bool {
+	if seq <= a.HighWater {
+		return true
+	}
+	i := sort.Search(len(a.Above), func(i int) bool { return a.Above[i] >= seq })
+	return i < len(a.Above) && a.Above[i] == seq
+}
+
+func (a SessionAcks) record(seq int64) SessionAcks {
+	if a.Acked(seq) {
+		return a
+	}
+	above := append([]int64{}, a.Above...)
+	i := sort.Search(len(above), func(i int) bool { return above[i] >= seq })
+	above = append(above[:i], append([]int64{seq}, above[i:]...)...)
+	hw := a.HighWater
+	for len(above) > 0 && above[0] == hw+1 {
+		hw++
+		above = above[1:]
+	}
+	return SessionAcks{HighWater: hw, Above: above}
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionAcks(t *testing.T) {
+	var a SessionAcks
+	for _, seq := range []int64{1, 2, 4, 5} {
+		a = a.record(seq)
+	}
+	if a.HighWater != 2 || !a.Acked(4) || a.Acked(3) {
+		t.Errorf("Expected high water 2 with 4 and 5 acked past the gap, but got %+v", a)
+	}
+	a = a.record(3)
+	if a.HighWater != 5 || len(a.Above) != 0 {
+		t.Errorf("Expected filling the gap to advance high water to 5, but got %+v", a)
+	}
+}
+
+func TestWebSocketResumeAcrossRestart(t *testing.T) {
+	cfg := DefaultConfig()
+	store := NewMemoryStore()
+	h1 := NewHarnessWithStore(cfg, store)
+	ctx := context.Background()
+
+	c, err := client.Dial(ctx, h1.WSURL("/ws"), "user1", "mobile")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Send(ctx, []byte("chunk")); err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+	}
+
+	// The connection drops while the fourth chunk is in flight.
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := c.Send(expired, []byte("lost")); err == nil {
+		t.Fatal("Expected the send on a dead connection to fail")
+	}
+	if c.Pending() != 1 {
+		t.Fatalf("Expected 1 pending chunk, but got %v", c.Pending())
+	}
+	c.Close()
+	h1.Close()
+
+	h2 := NewHarnessWithStore(cfg, store)
+	defer h2.Close()
+	c.URL = h2.WSURL("/ws")
+	acks, err := c.Resume(ctx)
+	if err != nil {
+		t.Fatalf("Resume error: %v", err)
+	}
+	if len(acks) != 1 || acks[0].Seq != 4 || acks[0].Duplicate {
+		t.Errorf("Expected only seq 4 to be retransmitted, but got %+v", acks)
+	}
+	if n := len(store.ListByUser("user1")); n != 4 {
+		t.Errorf("Expected 4 stored chunks, but got %v", n)
+	}
+
+	// A client that lost its acks retransmits seq 2 after resuming.
+	conn, _, err := websocket.DefaultDialer.Dial(h2.WSURL("/ws?user_id=user1&session_id=mobile"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "hello", Resume: true})
+	var hello struct {
+		LastSeq int64 `json:"last_seq"`
+	}
+	conn.ReadJSON(&hello)
+	if hello.LastSeq != 4 {
+		t.Errorf("Expected last_seq 4, but got %v", hello.LastSeq)
+	}
+	conn.WriteJSON(wsEnvelope{Type: "chunk", Data: []byte("chunk"), Seq: 2})
+	var ack client.Ack
+	conn.ReadJSON(&ack)
+	if !ack.Duplicate {
+		t.Errorf("Expected seq 2 to be acked as a duplicate, but got %+v", ack)
+	}
+	if n := len(store.ListByUser("user1")); n != 4 {
+		t.Errorf("Expected the duplicate not to be reprocessed, but got %v chunks", n)
+	}
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// reviewStats exposes the number of chunks waiting in the review queue
+// and counts the reviews made.
+var (
+	reviewStats   = expvar.NewMap("review_queue")
+	reviewPending = new(expvar.Int)
+)
+
+func init() { reviewStats.Set("pending", reviewPending) }
+
+const (
+	defaultReviewLimit = 50
+	maxReviewLimit     = 500
+)
+
+// ReviewQueue holds the chunks whose transcripts scored under
+// Config.ReviewThreshold and that nobody has reviewed yet. It follows the
+// store's writes, so chunks leave it when they are reviewed, trashed or
+// reprocessed to a better score, and come back when restored.
+type ReviewQueue struct {
+	threshold float64
+
+	mu      sync.Mutex
+	pending map[string]reviewItem
+}
+
+// reviewItem is what the queue keeps of a chunk to order and filter it.
+type reviewItem struct {
+	userID     string
+	confidence float64
+	timestamp  time.Time
+}
+
+// NewReviewQueue queues store's low-confidence chunks and follows its
+// writes from then on. It returns nil, which queues nothing, when
+// cfg.ReviewThreshold is 0.
+func NewReviewQueue(cfg Config, store *MemoryStore) *ReviewQueue {
+	if cfg.ReviewThreshold <= 0 {
+		return nil
+	}
+	q := &ReviewQueue{threshold: cfg.ReviewThreshold, pending: make(map[string]reviewItem)}
+	for _, m := range store.List(q.needsReview) {
+		q.pending[m.ChunkID] = reviewItem{m.UserID, *m.Confidence, m.Timestamp}
+	}
+	reviewPending.Set(int64(len(q.pending)))
+	store.OnWrite(q.write)
+	return q
+}
+
+// needsReview reports whether m belongs in the queue.
+func (q *ReviewQueue) needsReview(m Metadata) bool {
+	return m.Confidence != nil && *m.Confidence < q.threshold && m.ReviewedAt == nil && m.DeletedAt == nil
+}
+
+// write is the store's OnWrite hook. It runs under the store lock.
+func (q *ReviewQueue) write(id string, meta Metadata, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err == nil && q.needsReview(meta) {
+		q.pending[id] = reviewItem{meta.UserID, *meta.Confidence, meta.Timestamp}
+	} else {
+		delete(q.pending, id)
+	}
+	reviewPending.Set(int64(len(q.pending)))
+}
+
+// Pending returns the IDs of the chunks waiting for review, worst score
+// first and oldest first among equals, and how many there are in all.
+// userID, when set, narrows them to one user's chunks. Only the first
+// limit IDs are returned.
+func (q *ReviewQueue) Pending(userID string, limit int) ([]string, int) {
+	if q == nil {
+		return nil, 0
+	}
+	q.mu.Lock()
+	type entry struct {
+		id string
+		reviewItem
+	}
+	var entries []entry
+	for id, it := range q.pending {
+		if userID == "" || it.userID == userID {
+			entries = append(entries, entry{id, it})
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.confidence != b.confidence {
+			return a.confidence < b.confidence
+		}
+		if !a.timestamp.Equal(b.timestamp) {
+			return a.timestamp.Before(b.timestamp)
+		}
+		return a.id < b.id
+	})
+	ids := make([]string, 0, min(limit, len(entries)))
+	for _, e := range entries[:min(limit, len(entries))] {
+		ids = append(ids, e.id)
+	}
+	return ids, len(entries)
+}
+
+// ReviewQueuePage is a response of GET /review/queue. Pending counts every
+// chunk waiting for review in the requested scope, not just those listed.
+type ReviewQueuePage struct {
+	Threshold float64    `json:"threshold"`
+	Pending   int        `json:"pending"`
+	Chunks    []Metadata `json:"chunks"`
+}
+
+func handleReviewQueue(store *MemoryStore, q *ReviewQueue, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		limit := defaultReviewLimit
+		if v := params.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, invalidParam("limit", "invalid_limit", "limit must be a positive integer"))
+				return
+			}
+			limit = min(n, maxReviewLimit)
+		}
+		userID := params.Get("user_id")
+		switch {
+		case params.Get("all_users") == "true":
+			if !isAdmin(cfg, r) {
+				writeError(w, errAdminRequired)
+				return
+			}
+			userID = ""
+		case userID == "":
+			writeError(w, invalidParam("user_id", "missing_user_id", "user_id is required"))
+			return
+		default:
+			if err := checkUserAccess(cfg, r, userID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		ids, pending := q.Pending(userID, limit)
+		page := ReviewQueuePage{Threshold: cfg.ReviewThreshold, Pending: pending, Chunks: []Metadata{}}
+		for _, id := range ids {
+			// A chunk reviewed or deleted since Pending is left out.
+			if m, err := store.Get(id); err == nil {
+				page.Chunks = append(page.Chunks, m)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// reviewRequest marks a chunk reviewed, correcting its transcript when
+// Transcript is set.
+type reviewRequest struct {
+	Transcript *string `json:"transcript,omitempty"`
+}
+
+// handleReviewChunk marks a chunk reviewed as a patch would, saving the
+// corrected transcript, if any, as a new revision. An empty body accepts
+// the transcript as it is.
+func handleReviewChunk(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req reviewRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_review", err.Error())
+			return
+		}
+		meta, err := applyPatch(store, cfg, r, chunkPatch{Transcript: req.Transcript, review: true})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		reviewStats.Add("reviewed", 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+	}
+}
@@ -0,0 +1,137 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// confidenceTranscriber scores each chunk by its session, as if some
+// sessions were recorded in worse conditions than others.
+type confidenceTranscriber map[string]float64
+
+func (c confidenceTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	return Transcription{Text: "something like " + chunk.SessionID, Confidence: c[chunk.SessionID]}, nil
+}
+
+func reviewQueueIDs(t *testing.T, h *Harness, query string) ([]string, int) {
+	t.Helper()
+	var page ReviewQueuePage
+	if status := adminDo(t, h, "GET", "/review/queue?"+query, nil, &page); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, but got %d", status)
+	}
+	ids := make([]string, len(page.Chunks))
+	for i, m := range page.Chunks {
+		ids[i] = m.ChunkID
+	}
+	return ids, page.Pending
+}
+
+func TestReviewQueue(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Pipeline.Transcriber = confidenceTranscriber{"clear": 0.95, "mumbled": 0.3, "garbage": 0.05, "edge": 0.5}
+
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	garbage := uploadTo(t, h, "user1", "garbage", wav)
+	mumbled := uploadTo(t, h, "user1", "mumbled", wav)
+	uploadTo(t, h, "user1", "clear", wav)
+	uploadTo(t, h, "user1", "edge", wav)
+	later := uploadTo(t, h, "user1", "mumbled", wav)
+	other := uploadTo(t, h, "user2", "garbage", wav)
+	if garbage.Confidence == nil || *garbage.Confidence != 0.05 {
+		t.Fatalf("Expected the transcriber's confidence on the chunk, but got %v", garbage.Confidence)
+	}
+
+	ids, pending := reviewQueueIDs(t, h, "user_id=user1")
+	want := []string{garbage.ChunkID, mumbled.ChunkID, later.ChunkID}
+	if pending != len(want) || len(ids) != len(want) || ids[0] != want[0] || ids[1] != want[1] || ids[2] != want[2] {
+		t.Fatalf("Expected %v worst first, but got %v of %d", want, ids, pending)
+	}
+	if ids, pending := reviewQueueIDs(t, h, "all_users=true&limit=1"); pending != 4 || len(ids) != 1 || ids[0] != garbage.ChunkID && ids[0] != other.ChunkID {
+		t.Errorf("Expected the worst of 4 chunks across users, but got %v of %d", ids, pending)
+	}
+	if got := reviewPending.Value(); got != 4 {
+		t.Errorf("Expected 4 pending reviews in the stats, but got %d", got)
+	}
+
+	var reviewed Metadata
+	if status := adminDo(t, h, "POST", "/chunks/"+garbage.ChunkID+"/review", map[string]string{"transcript": "what was really said"}, &reviewed); status != http.StatusOK {
+		t.Fatalf("Expected status code 200, but got %d", status)
+	}
+	if reviewed.Transcript != "what was really said" || reviewed.ReviewedAt == nil {
+		t.Errorf("Expected the corrected transcript marked reviewed, but got %q %v", reviewed.Transcript, reviewed.ReviewedAt)
+	}
+	if revs := h.Store.Revisions(garbage.ChunkID); len(revs) != 2 || revs[1].Cause != revisionReview {
+		t.Errorf("Expected the review saved as a revision, but got %+v", revs)
+	}
+	// A review without a transcript accepts the one there is.
+	if status := adminDo(t, h, "POST", "/chunks/"+mumbled.ChunkID+"/review", nil, &reviewed); status != http.StatusOK || reviewed.Transcript != "something like mumbled" {
+		t.Errorf("Expected the transcript kept, but got %d %q", status, reviewed.Transcript)
+	}
+	if ids, pending := reviewQueueIDs(t, h, "user_id=user1"); pending != 1 || len(ids) != 1 || ids[0] != later.ChunkID {
+		t.Errorf("Expected only %s left, but got %v of %d", later.ChunkID, ids, pending)
+	}
+
+	// Trashed chunks leave the queue and come back when restored.
+	adminDo(t, h, "DELETE", "/chunks/"+later.ChunkID, nil, nil)
+	if _, pending := reviewQueueIDs(t, h, "user_id=user1"); pending != 0 {
+		t.Errorf("Expected a trashed chunk to leave the queue, but got %d pending", pending)
+	}
+	adminDo(t, h, "POST", "/chunks/"+later.ChunkID+"/restore", nil, nil)
+	if _, pending := reviewQueueIDs(t, h, "user_id=user1"); pending != 1 {
+		t.Errorf("Expected a restored chunk back in the queue, but got %d pending", pending)
+	}
+
+	for query, status := range map[string]int{"": http.StatusBadRequest, "all_users=true": http.StatusForbidden, "user_id=user1&limit=0": http.StatusBadRequest} {
+		resp, err := http.Get(h.URL + "/review/queue?" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%q: expected status code %d, but got %d", query, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestReviewQueueFromStubAndStore(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.StubConfidence = 0.2
+	store := NewMemoryStore()
+	h := NewHarnessWithStore(cfg, store)
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	h.Close()
+	if meta.Confidence == nil || *meta.Confidence != 0.2 || meta.Words[0].Confidence != 0.2 {
+		t.Fatalf("Expected the stub's confidence, but got %v %+v", meta.Confidence, meta.Words)
+	}
+
+	// A restarted server finds the chunks already waiting.
+	h = NewHarnessWithStore(cfg, store)
+	defer h.Close()
+	if ids, pending := reviewQueueIDs(t, h, "user_id=user1"); pending != 1 || len(ids) != 1 || ids[0] != meta.ChunkID {
+		t.Errorf("Expected %s waiting for review, but got %v of %d", meta.ChunkID, ids, pending)
+	}
+}
+
+func TestTranscriptionScore(t *testing.T) {
+	for _, tc := range []struct {
+		t      Transcription
+		want   float64
+		scored bool
+	}{
+		{Transcription{Confidence: 0.7, Words: []Word{{Confidence: 0.1}}}, 0.7, true},
+		{Transcription{Words: []Word{{Confidence: 0.5}, {Confidence: 1}}}, 0.75, true},
+		{Transcription{Words: []Word{{Confidence: 0.5}, {}}}, 0.25, true},
+		{Transcription{Text: "no words"}, 0, false},
+		{Transcription{Text: "unscored words", Words: []Word{{}, {}}}, 0, false},
+	} {
+		if got, scored := tc.t.score(); got != tc.want || scored != tc.scored {
+			t.Errorf("Expected %+v to score %v (%v), but got %v (%v)", tc.t, tc.want, tc.scored, got, scored)
+		}
+	}
+}
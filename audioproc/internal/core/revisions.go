@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Revision causes: what wrote a version of a chunk's metadata. Overwrite
+// is any other Save of a chunk that already exists.
+const (
+	revisionInitial   = "initial"
+	revisionReprocess = "reprocess"
+	revisionPatch     = "patch"
+	revisionReview    = "review"
+	revisionOverwrite = "overwrite"
+)
+
+// Revision is one saved version of a chunk's metadata. Revisions of a chunk
+// are numbered from 1; the newest is what Get returns.
+type Revision struct {
+	Revision int       `json:"revision"`
+	Cause    string    `json:"cause"`
+	SavedAt  time.Time `json:"saved_at"`
+	Metadata Metadata  `json:"metadata"`
+}
+
+// recordRevisionLocked appends meta to its chunk's history, keeping the
+// newest RevisionDepth versions. The slice is replaced rather than
+// modified, so a transaction's undo can hold on to the old one.
+func (s *MemoryStore) recordRevisionLocked(meta Metadata, cause string) {
+	if s.RevisionDepth <= 0 {
+		return
+	}
+	old := s.revisions[meta.ChunkID]
+	next := 1
+	if len(old) > 0 {
+		next = old[len(old)-1].Revision + 1
+	}
+	keep := old[max(0, len(old)-s.RevisionDepth+1):]
+	revs := make([]Revision, 0, len(keep)+1)
+	revs = append(revs, keep...)
+	s.revisions[meta.ChunkID] = append(revs, Revision{Revision: next, Cause: cause, SavedAt: s.Clock.Now().UTC(), Metadata: meta})
+}
+
+// logRevisionLocked logs the revision a save of chunk id just recorded.
+func (s *MemoryStore) logRevisionLocked(id string) {
+	if revs := s.revisions[id]; len(revs) > 0 {
+		s.logEntryLocked(walRevisions, id, revs[len(revs)-1])
+	}
+}
+
+// Revisions returns a chunk's kept versions, oldest first.
+func (s *MemoryStore) Revisions(id string) []Revision {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Revision(nil), s.revisions[id]...)
+}
+
+// saveAs is Save recording why the chunk was written. An empty cause is
+// as for saveLocked.
+func (tx *memTx) saveAs(meta Metadata, cause string) error {
+	tx.keep(meta.ChunkID)
+	op := tx.s.saveOpLocked(meta.ChunkID)
+	if blob := tx.s.saveLocked(meta, cause); blob != "" {
+		tx.blobs = append(tx.blobs, blob)
+	}
+	if revs := tx.s.revisions[meta.ChunkID]; len(revs) > 0 {
+		tx.logEntry(walRevisions, meta.ChunkID, revs[len(revs)-1])
+	}
+	tx.changed(op, meta.ChunkID)
+	return nil
+}
+
+func handleListRevisions(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meta, err := store.Get(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		revs := store.Revisions(meta.ChunkID)
+		if revs == nil {
+			revs = []Revision{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(revs)
+	}
+}
+
+// chunkPatch corrects a chunk's metadata. Fields left out are unchanged;
+// a new transcript drops the word timings, which no longer match it.
+type chunkPatch struct {
+	Transcript     *string         `json:"transcript,omitempty"`
+	ClientMetadata json.RawMessage `json:"client_metadata,omitempty"`
+
+	// review marks the chunk reviewed, as POST /chunks/{id}/review does.
+	review bool
+}
+
+func handlePatchChunk(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var patch chunkPatch
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_patch", err.Error())
+			return
+		}
+		if patch.Transcript == nil && patch.ClientMetadata == nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_patch", "patch must set transcript or client_metadata")
+			return
+		}
+		meta, err := applyPatch(store, cfg, r, patch)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+	}
+}
+
+// applyPatch applies patch to the chunk named in r's path, if r's caller
+// may edit it, and saves the result as a new revision.
+func applyPatch(store *MemoryStore, cfg Config, r *http.Request, patch chunkPatch) (Metadata, error) {
+	var clientMeta json.RawMessage
+	if patch.ClientMetadata != nil && !bytes.Equal(bytes.TrimSpace(patch.ClientMetadata), []byte("null")) {
+		var err error
+		if clientMeta, err = validateClientMetadata(patch.ClientMetadata, cfg.MaxClientMetadataBytes); err != nil {
+			return Metadata{}, err
+		}
+	}
+	cause := revisionPatch
+	if patch.review {
+		cause = revisionReview
+	}
+	var meta Metadata
+	err := store.withTx(func(tx *memTx) error {
+		var err error
+		if meta, err = tx.Get(mux.Vars(r)["id"]); err != nil {
+			return err
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			return err
+		}
+		if patch.Transcript != nil && *patch.Transcript != meta.Transcript {
+			// Edits are scrubbed like transcriber output.
+			t, counts := redactTranscript(cfg.RedactionRules, Transcription{Text: *patch.Transcript})
+			meta.Transcript, meta.Words, meta.Redactions = t.Text, nil, counts
+			meta.Truncated, meta.TranscriptBlob = false, ""
+		}
+		if patch.ClientMetadata != nil {
+			meta.ClientMetadata = clientMeta
+		}
+		if patch.review {
+			at := store.Clock.Now().UTC()
+			meta.ReviewedAt = &at
+		}
+		return tx.saveAs(meta, cause)
+	})
+	return meta, err
+}
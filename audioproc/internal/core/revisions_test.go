@@ -0,0 +1,97 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func patchChunk(t *testing.T, h *Harness, id, body string) (int, Metadata) {
+	t.Helper()
+	req, _ := http.NewRequest("PATCH", h.URL+"/chunks/"+id, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PATCH error: %v", err)
+	}
+	defer resp.Body.Close()
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	return resp.StatusCode, meta
+}
+
+func listRevisions(t *testing.T, h *Harness, id string) []Revision {
+	t.Helper()
+	resp, err := http.Get(h.URL + "/chunks/" + id + "/revisions")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, but got %d", resp.StatusCode)
+	}
+	var revs []Revision
+	json.NewDecoder(resp.Body).Decode(&revs)
+	return revs
+}
+
+func TestRevisionsTrackPatchAndReprocess(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, time.Second, 8000))
+
+	if status, _ := patchChunk(t, h, meta.ChunkID, `{}`); status != http.StatusBadRequest {
+		t.Errorf("Expected an empty patch to be refused, but got %d", status)
+	}
+	status, patched := patchChunk(t, h, meta.ChunkID, `{"transcript": "Hello, world"}`)
+	if status != http.StatusOK || patched.Transcript != "Hello, world" || patched.Words != nil {
+		t.Fatalf("Expected the corrected transcript, but got %d %+v", status, patched)
+	}
+	if revs := listRevisions(t, h, meta.ChunkID); len(revs) != 2 || revs[0].Cause != revisionInitial || revs[1].Cause != revisionPatch {
+		t.Fatalf("Expected initial then patch revisions, but got %+v", revs)
+	}
+
+	st := h.Reprocessor.Start(ReprocessFilter{UserID: "user1"})
+	waitForState(t, h.Reprocessor, st.ID, jobDone)
+	patchChunk(t, h, meta.ChunkID, `{"client_metadata": {"reviewed": true}}`)
+
+	// The default depth of 3 drops the initial upload.
+	revs := listRevisions(t, h, meta.ChunkID)
+	var causes []string
+	for i, rev := range revs {
+		causes = append(causes, rev.Cause)
+		if rev.Revision != i+2 || (i > 0 && rev.SavedAt.Before(revs[i-1].SavedAt)) {
+			t.Errorf("Expected revisions 2 to 4 in order, but got %+v", revs)
+		}
+	}
+	if strings.Join(causes, ",") != "patch,reprocess,patch" {
+		t.Errorf("Expected patch, reprocess, patch, but got %v", causes)
+	}
+	if revs[1].Metadata.Transcript != "Hello World" {
+		t.Errorf("Expected reprocessing to overwrite the patched transcript, but got %q", revs[1].Metadata.Transcript)
+	}
+	current, _ := h.Store.Get(meta.ChunkID)
+	if !strings.Contains(string(current.ClientMetadata), "reviewed") || current.Transcript != revs[2].Metadata.Transcript {
+		t.Errorf("Expected Get to return the newest revision, but got %+v", current)
+	}
+}
+
+func TestRevisionsRolledBackWithTx(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", Transcript: "one"})
+	store.withTx(func(tx *memTx) error {
+		tx.saveAs(Metadata{ChunkID: "c1", UserID: "user1", Transcript: "two"}, revisionPatch)
+		return errors.New("abort")
+	})
+	if revs := store.Revisions("c1"); len(revs) != 1 || revs[0].Metadata.Transcript != "one" {
+		t.Errorf("Expected the aborted revision to be undone, but got %+v", revs)
+	}
+
+	store.RevisionDepth = 0
+	store.Save(Metadata{ChunkID: "c2", UserID: "user1"})
+	if revs := store.Revisions("c2"); len(revs) != 0 {
+		t.Errorf("Expected no history at depth 0, but got %+v", revs)
+	}
+}
@@ -0,0 +1,289 @@
+package core
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters: bm25K1 caps how much repeating a term helps, and bm25B
+// how much long transcripts are penalised. A query of several words that
+// appear together, in order, has its score multiplied by phraseBoost.
+const (
+	bm25K1      = 1.2
+	bm25B       = 0.75
+	phraseBoost = 1.5
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true,
+	"by": true, "for": true, "if": true, "in": true, "into": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "so": true, "that": true, "the": true, "their": true, "then": true,
+	"there": true, "these": true, "they": true, "this": true, "to": true, "was": true, "were": true,
+	"will": true, "with": true,
+}
+
+// searchTerm is a word of a transcript or query and its position among all
+// the words, stopwords included, so phrases match across them.
+type searchTerm struct {
+	word string
+	pos  int
+}
+
+// tokenize lowercases text, splits it into runs of letters and digits and
+// drops stopwords.
+func tokenize(text string) []searchTerm {
+	var terms []searchTerm
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		if !stopwords[w] {
+			terms = append(terms, searchTerm{w, i})
+		}
+	}
+	return terms
+}
+
+// Search scopes: what text of a chunk a search matches.
+const (
+	scopeTranscript = "transcript"
+	scopeTags       = "tags"
+)
+
+// searchFields picks each scope's text out of a chunk.
+var searchFields = map[string]func(Metadata) string{
+	scopeTranscript: func(m Metadata) string { return m.Transcript },
+	scopeTags:       func(m Metadata) string { return m.EmbeddedTags.text() },
+}
+
+// textIndex is an inverted index over one text field of chunks: for each
+// term, the positions at which it occurs in each chunk.
+type textIndex struct {
+	field    func(Metadata) string
+	postings map[string]map[string][]int
+	lengths  map[string]int
+	total    int
+}
+
+func newTextIndex(field func(Metadata) string) *textIndex {
+	return &textIndex{field: field, postings: make(map[string]map[string][]int), lengths: make(map[string]int)}
+}
+
+func (x *textIndex) add(m Metadata) {
+	terms := tokenize(x.field(m))
+	if len(terms) == 0 {
+		return
+	}
+	for _, t := range terms {
+		if x.postings[t.word] == nil {
+			x.postings[t.word] = make(map[string][]int)
+		}
+		x.postings[t.word][m.ChunkID] = append(x.postings[t.word][m.ChunkID], t.pos)
+	}
+	x.lengths[m.ChunkID] = len(terms)
+	x.total += len(terms)
+}
+
+func (x *textIndex) remove(m Metadata) {
+	n, ok := x.lengths[m.ChunkID]
+	if !ok {
+		return
+	}
+	for _, t := range tokenize(x.field(m)) {
+		delete(x.postings[t.word], m.ChunkID)
+		if len(x.postings[t.word]) == 0 {
+			delete(x.postings, t.word)
+		}
+	}
+	delete(x.lengths, m.ChunkID)
+	x.total -= n
+}
+
+// score ranks the chunks containing any of the query's terms by BM25. When
+// within is not nil only the chunks it lists are scored, which is cheaper
+// than walking the postings of common terms.
+func (x *textIndex) score(query []searchTerm, within map[string]bool) map[string]float64 {
+	scores := make(map[string]float64)
+	if len(x.lengths) == 0 {
+		return scores
+	}
+	docs := float64(len(x.lengths))
+	avgLen := float64(x.total) / docs
+	add := func(id string, idf float64, positions []int) {
+		tf := float64(len(positions))
+		norm := 1 - bm25B + bm25B*float64(x.lengths[id])/avgLen
+		scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+	}
+	seen := make(map[string]bool)
+	for _, t := range query {
+		if seen[t.word] {
+			continue
+		}
+		seen[t.word] = true
+		postings := x.postings[t.word]
+		df := float64(len(postings))
+		idf := math.Log(1 + (docs-df+0.5)/(df+0.5))
+		if within != nil && len(within) < len(postings) {
+			for id := range within {
+				if positions, ok := postings[id]; ok {
+					add(id, idf, positions)
+				}
+			}
+			continue
+		}
+		for id, positions := range postings {
+			if within == nil || within[id] {
+				add(id, idf, positions)
+			}
+		}
+	}
+	if len(seen) > 1 {
+		for id := range scores {
+			if x.hasPhrase(id, query) {
+				scores[id] *= phraseBoost
+			}
+		}
+	}
+	return scores
+}
+
+// hasPhrase reports whether the query's terms occur in chunk id at the same
+// distances from each other as in the query.
+func (x *textIndex) hasPhrase(id string, query []searchTerm) bool {
+	for _, start := range x.postings[query[0].word][id] {
+		found := true
+		for _, t := range query[1:] {
+			want := start + t.pos - query[0].pos
+			positions := x.postings[t.word][id]
+			i := sort.SearchInts(positions, want)
+			if i == len(positions) || positions[i] != want {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchHit is a chunk matching a search and its relevance.
+type SearchHit struct {
+	Metadata
+	Score float64 `json:"score"`
+}
+
+// SearchTranscripts returns up to limit of userID's visible chunks, or
+// every user's if userID is empty, whose transcripts contain words of
+// query, best first. It uses the text index while the indexes are trusted
+// and otherwise indexes the candidate chunks on the fly.
+func (s *MemoryStore) SearchTranscripts(query, userID string, limit int) []SearchHit {
+	return s.search(scopeTranscript, query, userID, limit)
+}
+
+// SearchTags is SearchTranscripts over the chunks' embedded tags.
+func (s *MemoryStore) SearchTags(query, userID string, limit int) []SearchHit {
+	return s.search(scopeTags, query, userID, limit)
+}
+
+func (s *MemoryStore) search(scope, query, userID string, limit int) []SearchHit {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	match := func(m Metadata) bool { return userID == "" || m.UserID == userID }
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	text := s.index.live.text
+	if scope == scopeTags {
+		text = s.index.live.tags
+	}
+	var within map[string]bool
+	if !s.index.trusted {
+		text = newTextIndex(searchFields[scope])
+		for _, m := range s.listLocked(match) {
+			text.add(m)
+		}
+	} else if userID != "" {
+		within = s.index.live.byUser[userID]
+		if within == nil {
+			return nil
+		}
+	}
+	var hits []SearchHit
+	for id, score := range text.score(terms, within) {
+		if m, ok := s.lookupLocked(id); ok && m.DeletedAt == nil && match(m) {
+			hits = append(hits, SearchHit{Metadata: m, Score: score})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ChunkID < hits[j].ChunkID
+	})
+	return hits[:min(limit, len(hits))]
+}
+
+// handleSearch ranks a user's chunks by how well their transcripts, or with
+// scope=tags their embedded tags, match q. Admins may search every user
+// with all_users=true.
+func handleSearch(store *MemoryStore, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		query := q.Get("q")
+		if len(tokenize(query)) == 0 {
+			writeError(w, invalidParam("q", "missing_query", "q must contain at least one word that is not a stopword"))
+			return
+		}
+		limit := defaultSearchLimit
+		if v := q.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, invalidParam("limit", "invalid_limit", "limit must be a positive integer"))
+				return
+			}
+			limit = min(n, maxSearchLimit)
+		}
+		scope := q.Get("scope")
+		if scope == "" {
+			scope = scopeTranscript
+		}
+		if searchFields[scope] == nil {
+			writeError(w, invalidParam("scope", "invalid_scope", "scope must be transcript or tags"))
+			return
+		}
+		userID := q.Get("user_id")
+		switch {
+		case q.Get("all_users") == "true":
+			if !isAdmin(cfg, r) {
+				writeError(w, errAdminRequired)
+				return
+			}
+			userID = ""
+		case userID == "":
+			writeError(w, invalidParam("user_id", "missing_user_id", "user_id is required"))
+			return
+		default:
+			if err := checkUserAccess(cfg, r, userID); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		hits := store.search(scope, query, userID, limit)
+		if hits == nil {
+			hits = []SearchHit{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hits)
+	}
+}
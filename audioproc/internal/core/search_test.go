@@ -0,0 +1,157 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// searchFixtures are transcripts whose ranking for the queries in
+// TestSearchRanking is known.
+var searchFixtures = map[string]string{
+	"phrase":   "Please turn off the kitchen lights before you leave.",
+	"scatter":  "The lights flicker in the kitchen while I turn the radio off.",
+	"repeat":   "Lights, lights, lights. Every light in the house, lights everywhere.",
+	"long":     "We talked about the weather, the garden, the neighbours, the roof, the car and finally the lights in the hall.",
+	"kitchen":  "The kitchen sink is leaking again.",
+	"unrelate": "Quarterly revenue grew by four percent.",
+}
+
+func searchStore() *MemoryStore {
+	store := NewMemoryStore()
+	for id, text := range searchFixtures {
+		store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "s1", Transcript: text})
+	}
+	return store
+}
+
+func hitIDs(hits []SearchHit) []string {
+	ids := make([]string, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ChunkID
+	}
+	return ids
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Turn OFF the lights, Zoë!")
+	want := []searchTerm{{"turn", 0}, {"off", 1}, {"lights", 3}, {"zoë", 4}}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, but got %v", want, got)
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	store := searchStore()
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		// Term frequency lifts repeat; length holds long back.
+		{"lights", []string{"repeat", "phrase", "scatter", "long"}},
+		// Both chunks contain every word, but only one has them in order.
+		{"turn off the kitchen lights", []string{"phrase", "scatter", "kitchen", "repeat", "long"}},
+		{"kitchen", []string{"kitchen", "phrase", "scatter"}},
+		{"the of and", nil},
+		{"submarine", nil},
+	} {
+		if got := hitIDs(store.SearchTranscripts(tc.query, "", 10)); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%q: expected %v, but got %v", tc.query, tc.want, got)
+		}
+	}
+	if got := store.SearchTranscripts("lights", "user1", 2); len(got) != 2 || got[0].Score < got[1].Score {
+		t.Errorf("Expected the top 2 hits best first, but got %+v", got)
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	store := searchStore()
+	m, _ := store.Get("unrelate")
+	m.Transcript = "Revenue from kitchen lights grew."
+	store.Save(m)
+	store.Delete("repeat")
+
+	if got := hitIDs(store.SearchTranscripts("revenue", "", 10)); fmt.Sprint(got) != "[unrelate]" {
+		t.Errorf("Expected the rewritten transcript to be found, but got %v", got)
+	}
+	if got := hitIDs(store.SearchTranscripts("quarterly", "", 10)); len(got) != 0 {
+		t.Errorf("Expected the old transcript to be gone, but got %v", got)
+	}
+	for _, h := range store.SearchTranscripts("lights", "", 10) {
+		if h.ChunkID == "repeat" {
+			t.Errorf("Expected deleted chunks to be left out")
+		}
+	}
+	if !store.CheckIndexes() {
+		t.Errorf("Expected the text index to match the records")
+	}
+
+	// With the indexes distrusted the same ranking comes from a scan.
+	want := hitIDs(store.SearchTranscripts("turn off the kitchen lights", "", 10))
+	delete(store.index.live.text.lengths, "phrase")
+	if store.CheckIndexes() {
+		t.Fatalf("Expected the text index drift to be detected")
+	}
+	if got := hitIDs(store.SearchTranscripts("turn off the kitchen lights", "", 10)); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected the scan to rank %v, but got %v", want, got)
+	}
+}
+
+func TestSearchEndpoint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	store := searchStore()
+	store.Save(Metadata{ChunkID: "other", UserID: "user2", SessionID: "s1", Transcript: "kitchen lights"})
+	search := handleSearch(store, cfg)
+	get := func(query string, admin bool) (int, []SearchHit) {
+		req := httptest.NewRequest("GET", "/search?"+query, nil)
+		if admin {
+			req.Header.Set("X-Admin-Token", "admin")
+		}
+		rr := httptest.NewRecorder()
+		search(rr, req)
+		var hits []SearchHit
+		json.NewDecoder(rr.Body).Decode(&hits)
+		return rr.Code, hits
+	}
+
+	status, hits := get("q=kitchen+lights&user_id=user1", false)
+	if status != http.StatusOK || len(hits) != 5 || hits[0].ChunkID != "phrase" {
+		t.Errorf("Expected user1's chunks with the phrase first, but got %v %v", status, hitIDs(hits))
+	}
+	if _, hits := get("q=kitchen+lights&all_users=true", true); len(hits) != 6 || hits[0].ChunkID != "other" {
+		t.Errorf("Expected every user's chunks for an admin, but got %v", hitIDs(hits))
+	}
+	for query, want := range map[string]int{
+		"q=kitchen":                    http.StatusBadRequest,
+		"q=the&user_id=user1":          http.StatusBadRequest,
+		"q=kitchen&user_id=u&limit=0":  http.StatusBadRequest,
+		"q=kitchen&all_users=true":     http.StatusForbidden,
+		"q=kitchen&user_id=u&limit=50": http.StatusOK,
+	} {
+		if status, _ := get(query, false); status != want {
+			t.Errorf("%s: expected %v, but got %v", query, want, status)
+		}
+	}
+}
+
+func BenchmarkSearchTranscripts(b *testing.B) {
+	vocabulary := strings.Fields("alpha bravo charlie delta echo foxtrot golf hotel india juliet kilo lima mike november oscar papa quebec romeo sierra tango uniform victor whiskey xray yankee zulu lights kitchen door window meeting budget call")
+	rng := rand.New(rand.NewSource(1))
+	store := NewMemoryStore()
+	for i := 0; i < 200000; i++ {
+		words := make([]string, 8+rng.Intn(24))
+		for j := range words {
+			words[j] = vocabulary[rng.Intn(len(vocabulary))]
+		}
+		store.Save(Metadata{ChunkID: fmt.Sprintf("c%06d", i), UserID: fmt.Sprintf("user%d", i%50), Transcript: strings.Join(words, " ")})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.SearchTranscripts("kitchen lights", "user7", 20)
+	}
+}
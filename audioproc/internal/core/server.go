@@ -0,0 +1,308 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout bounds how long Run waits for in-flight requests to drain.
+const shutdownTimeout = 10 * time.Second
+
+// Server wires the store, pipeline, background loops and HTTP routes into
+// one unit that can be embedded in another program or run by main.
+type Server struct {
+	Config   Config
+	Store    *MemoryStore
+	Pipeline *Pipeline
+
+	Sessions    *SessionTracker
+	Captures    *DebugCapturer
+	Recorder    *SessionRecorder
+	Identity    IdentityProvider
+	Reprocessor *Reprocessor
+	Migrator    *Migrator
+	Archive     *Archive
+	Reconciler  *Reconciler
+	Webhooks    *Webhooks
+	Shares      *ShareLinks
+	Signer      *URLSigner
+	// Receipts is nil unless Config.ReceiptKeys is set.
+	Receipts *Receipts
+	// Quotas is nil unless Config.QuotaBytes or Config.QuotaChunks is set.
+	Quotas *SoftQuotas
+	// Reviews is nil unless Config.ReviewThreshold is set.
+	Reviews *ReviewQueue
+	// ReadOnly refuses writes during maintenance; see Config.ReadOnly.
+	ReadOnly    *ReadOnly
+	Exports     *Exporter
+	AutoExports *AutoExporter
+	Comparisons *SessionComparer
+	Keys        *KeyRing
+	Concurrency *ConcurrencyLimiter
+	// Alerts evaluates Config.AlertRules; /healthz lists their states.
+	Alerts *Alerts
+	// Faults is set when Config.Faults is.
+	Faults *FaultInjector
+	// Warmups run when the server starts; register more before Run.
+	Warmups *Warmups
+	// MQTT is set when Config.MQTTBroker is.
+	MQTT *MQTTBridge
+	// Goroutines tracks the workers, sweepers and streaming handlers
+	// started for this server.
+	Goroutines *Goroutines
+	// Events carries chunk and session notifications; register
+	// subscribers before Run.
+	Events *Bus
+	// Egress makes outbound HTTP clients under Config's proxy and
+	// destination policy; an HTTP transcriber should use one.
+	Egress *Egress
+	// Transcoder encodes audio downloads that ask for ?format=; register
+	// formats before Run.
+	Transcoder *Transcoder
+
+	jobs    chan Job
+	wsConns *wsConns
+	handler http.Handler
+	// dispatcher takes submissions on jobs and feeds them to the workers
+	// by priority.
+	dispatcher *Dispatcher
+
+	// ctx scopes workers, sweepers and background jobs; cancel stops them.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	ready chan struct{}
+	addr  net.Addr
+}
+
+// New builds a Server around store and pipeline. A nil pipeline uses the
+// stub transcriber, and a pipeline without a Limiter gets an adaptive one
+// that watches the server's job queue.
+func New(cfg Config, store *MemoryStore, pipeline *Pipeline) *Server {
+	if pipeline == nil {
+		pipeline = &Pipeline{Transcriber: stubTranscriber{confidence: cfg.StubConfidence}}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{
+		Config:   cfg,
+		Store:    store,
+		Pipeline: pipeline,
+		wsConns:  newWSConns(cfg.SessionShards),
+		ctx:      ctx,
+		cancel:   cancel,
+		ready:    make(chan struct{}),
+	}
+	s.Goroutines = newGoroutines(ctx)
+	s.dispatcher = NewDispatcher()
+	if cfg.OrderedSessions {
+		s.dispatcher = NewOrderedDispatcher(cfg.Workers)
+	}
+	s.dispatcher.LaneDepth = cfg.DispatchLaneDepth
+	s.jobs = s.dispatcher.In
+	s.Events = NewBus()
+	s.Egress = NewEgress(cfg)
+	store.Events = s.Events
+	store.Retention = cfg.TrashRetention
+	store.ChangeLogSize = cfg.ChangeLogSize
+	store.RevisionDepth = cfg.RevisionDepth
+	store.TranscriptInlineMax = cfg.TranscriptInlineMax
+	store.SessionShards = cfg.SessionShards
+	if s.Pipeline.Limiter == nil {
+		s.Pipeline.Limiter = NewAdaptiveLimiter(cfg, s.dispatcher.Len)
+	}
+	if s.Pipeline.Budget == nil {
+		s.Pipeline.Budget = NewMemoryBudget(cfg)
+	}
+	s.dispatcher.Budget = s.Pipeline.Budget
+	if s.Pipeline.Anomalies == nil {
+		s.Pipeline.Anomalies = NewAnomalyDetector(cfg)
+	}
+	if s.Pipeline.Stages == nil {
+		s.Pipeline.Stages = NewStageControl()
+	}
+	if s.Pipeline.Billing == nil {
+		s.Pipeline.Billing = NewBillingMeter(cfg, store)
+	}
+	s.Faults = NewFaultInjector(cfg)
+	if s.Faults != nil {
+		s.Pipeline.Faults = s.Faults
+		s.wsConns.faults = s.Faults
+	}
+	if cfg.VerifyChecksums {
+		s.Pipeline.VerifyChecksums = true
+	}
+	if s.Pipeline.Deadline == 0 {
+		s.Pipeline.Deadline = cfg.ProcessingDeadline
+	}
+	if s.Pipeline.Redaction == nil {
+		s.Pipeline.Redaction = cfg.RedactionRules
+	}
+	if s.Pipeline.Defaults == (UserSettings{}) {
+		s.Pipeline.Defaults = cfg.defaultSettings()
+	}
+
+	s.Sessions = NewSessionTracker(cfg, realClock{})
+	s.Sessions.Events = s.Events
+	s.Sessions.StoredRevision = store.LastSessionRevision
+	s.Captures = NewDebugCapturer(cfg)
+	s.Recorder = NewSessionRecorder(cfg)
+	s.Identity = newIdentityProvider(cfg, s.Egress)
+	s.Reprocessor = NewReprocessor(ctx, store, func(ctx context.Context, chunk AudioChunk) (Metadata, error) {
+		return submitJob(ctx, s.jobs, chunk)
+	}, cfg)
+	s.Migrator = NewMigrator(ctx, store)
+	s.Archive = NewArchive(cfg, store)
+	s.Reconciler = NewReconciler(cfg, store)
+	s.Webhooks = NewWebhooks(cfg, s.Egress, store, s.Events)
+	s.Shares = NewShareLinks(cfg, store)
+	s.Signer = NewURLSigner(cfg, store, s.Shares)
+	s.Transcoder = NewTranscoder(cfg)
+	s.Receipts = NewReceipts(cfg)
+	s.Quotas = NewSoftQuotas(cfg, store, s.Events)
+	s.Reviews = NewReviewQueue(cfg, store)
+	s.ReadOnly = NewReadOnly(cfg)
+	store.ReadOnly = s.ReadOnly
+	s.Exports = NewExporter(cfg, store, s.Goroutines, s.Shares)
+	s.AutoExports = NewAutoExporter(cfg, store, s.Sessions)
+	s.Comparisons = NewSessionComparer(store, s.Pipeline, s.Goroutines)
+	s.Keys = NewKeyRing(cfg, store)
+	s.Concurrency = NewConcurrencyLimiter(cfg)
+	s.Alerts = NewAlerts(cfg, s.Egress, realClock{}, AlertSources{
+		Responses:   httpResponseTotals,
+		QueueDepth:  s.dispatcher.Len,
+		DeadLetters: store.FailedTotal,
+		StoreP99:    store.SaveLatencyP99,
+	})
+	s.Warmups = NewWarmups(cfg)
+	s.Warmups.Register("indexes", false, func(ctx context.Context) error { return RunIndexCheck(ctx, store) })
+	if p, ok := s.Pipeline.Transcriber.(transcriberPinger); ok {
+		s.Warmups.Register("transcriber", true, p.Ping)
+	}
+	if cfg.MQTTBroker != "" {
+		bridge, err := NewMQTTBridge(cfg, store, s.jobs, s.Sessions, s.Identity)
+		if err != nil {
+			log.Printf("MQTT bridge disabled: %v", err)
+		} else {
+			bridge.ReadOnly = s.ReadOnly
+		}
+		s.MQTT = bridge
+	}
+	s.handler = rejectUnsafePaths(newRouter(s))
+	return s
+}
+
+// Handler is the server's router, for mounting in another http.Server.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+// Ready is closed once Run is accepting connections.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Addr is the address Run listens on; it is only valid after Ready.
+func (s *Server) Addr() net.Addr {
+	return s.addr
+}
+
+// Start launches the workers, sweepers and warm-ups and returns a function
+// that stops them and waits for them and any background jobs to finish. Run
+// calls it; programs that serve Handler themselves call it instead.
+func (s *Server) Start() (stop func()) {
+	for i := 0; i < s.Config.Workers; i++ {
+		lane := s.dispatcher.Lane(i)
+		s.Goroutines.Go("worker", func(ctx context.Context) { s.Pipeline.Run(ctx, lane) })
+	}
+	s.Goroutines.Go("dispatcher", s.dispatcher.Run)
+	s.Goroutines.Go("trash_sweeper", func(ctx context.Context) { RunTrashSweeper(ctx, s.Store, s.ReadOnly, s.Config.SweepInterval) })
+	s.Goroutines.Go("session_sweeper", func(ctx context.Context) { RunSessionSweeper(ctx, s.Sessions, s.ReadOnly, s.Config.SweepInterval) })
+	s.Goroutines.Go("session_index_sweeper", func(ctx context.Context) {
+		RunSessionIndexSweeper(ctx, s.Store, s.Config.SessionIdleTimeout, s.Config.SweepInterval)
+	})
+	s.Goroutines.Go("share_sweeper", func(ctx context.Context) { RunShareSweeper(ctx, s.Store, s.Config.SweepInterval) })
+	s.Goroutines.Go("capture_sweeper", func(ctx context.Context) { RunCaptureSweeper(ctx, s.Captures, s.Config.SweepInterval) })
+	s.Goroutines.Go("archive_sweeper", func(ctx context.Context) { RunArchiveSweeper(ctx, s.Archive, s.ReadOnly, s.Config.SweepInterval) })
+	s.Goroutines.Go("reconcile_sweeper", func(ctx context.Context) {
+		RunReconcileSweeper(ctx, s.Reconciler, s.ReadOnly, s.Config.ReconcileInterval)
+	})
+	s.Goroutines.Go("export_sweeper", func(ctx context.Context) { RunExportSweeper(ctx, s.Exports, s.ReadOnly, s.Config.SweepInterval) })
+	s.Goroutines.Go("auto_exports", func(ctx context.Context) { RunAutoExports(ctx, s.AutoExports, s.ReadOnly) })
+	s.Goroutines.Go("soft_quotas", func(ctx context.Context) { RunSoftQuotas(ctx, s.Quotas) })
+	s.Goroutines.Go("alerts", func(ctx context.Context) { RunAlerts(ctx, s.Alerts, s.Config.AlertInterval) })
+	s.Warmups.start(s.Goroutines)
+	s.Webhooks.Start(s.ctx)
+	if s.MQTT != nil {
+		s.Goroutines.Go("mqtt_bridge", s.MQTT.Run)
+	}
+
+	return func() {
+		s.cancel()
+		s.Webhooks.Stop()
+		s.Reprocessor.Wait()
+		s.Migrator.Wait()
+		s.Goroutines.Wait()
+	}
+}
+
+// Run serves until ctx is cancelled, the listener fails or a critical
+// warm-up fails, then drains in-flight requests and stops everything it
+// started. It returns the first fatal error, or nil after a clean shutdown,
+// and does not start at all if the Config has an Err.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.Config.Err(); err != nil {
+		s.cancel()
+		return err
+	}
+	ln, err := net.Listen("tcp", s.Config.Addr)
+	if err != nil {
+		s.cancel()
+		return err
+	}
+	stop := s.Start()
+	defer stop()
+
+	srv := newHTTPServer(s.Config, s.handler)
+	srv.RegisterOnShutdown(s.Goroutines.CloseStreams)
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	s.addr = ln.Addr()
+	close(s.ready)
+	log.Println("Server running on " + s.addr.String())
+
+	var warmupErr error
+	select {
+	case err := <-errc:
+		return err
+	case warmupErr = <-s.Warmups.Failed():
+		log.Printf("Aborting startup: %v", warmupErr)
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+	}
+
+	// Shutdown closes the listener but ignores hijacked WebSocket
+	// connections, so drain those alongside it.
+	drained := make(chan struct{})
+	go func() {
+		s.wsConns.Drain(s.Config.DrainGrace)
+		close(drained)
+	}()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = srv.Shutdown(shutdownCtx)
+	<-drained
+	if warmupErr != nil {
+		return warmupErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := <-errc; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestServerRun(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	srv := New(cfg, NewMemoryStore(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	select {
+	case <-srv.Ready():
+	case err := <-done:
+		t.Fatalf("Run failed to start: %v", err)
+	}
+
+	url := "http://" + srv.Addr().String()
+	resp, err := http.Post(url+"/upload?user_id=user1&session_id=sess1", "audio/wav", strings.NewReader("audio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	if _, err := srv.Store.Get(meta.ChunkID); err != nil {
+		t.Errorf("Expected the uploaded chunk to be stored, but got %v", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
+
+func TestServerRunReportsListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cfg := DefaultConfig()
+	cfg.Addr = ln.Addr().String()
+	if err := New(cfg, NewMemoryStore(), nil).Run(context.Background()); err == nil {
+		t.Errorf("Expected Run to report that the address is in use")
+	}
+}
+
+func TestUploadOverH2C(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.H2C = true
+	srv, cancel, errc := runServer(t, cfg, NewMemoryStore())
+	defer func() { cancel(); <-errc }()
+	url := "http://" + srv.Addr().String() + "/upload?user_id=user1&session_id=h2"
+
+	h2 := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	before := expvarInt(requestProtocols, "HTTP/2.0")
+
+	// Stream a body several times the stream window so the upload has to
+	// wait on flow control updates from the handler's reads.
+	audio := bytes.Repeat([]byte("0123456789abcdef"), 3*h2StreamWindow/16)
+	pr, pw := io.Pipe()
+	go func() {
+		for rest := audio; len(rest) > 0; rest = rest[64<<10:] {
+			pw.Write(rest[:64<<10])
+		}
+		pw.Close()
+	}()
+	resp, err := h2.Post(url, "application/octet-stream", pr)
+	if err != nil {
+		t.Fatalf("h2c upload error: %v", err)
+	}
+	defer resp.Body.Close()
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 || meta.ChunkID == "" {
+		t.Errorf("Expected an HTTP/2 upload to succeed, but got %v %v %+v", resp.Proto, resp.StatusCode, meta)
+	}
+	if data, _ := srv.Store.GetBlob(meta.ChunkID); !bytes.Equal(data, audio) {
+		t.Errorf("Expected the full body to be stored, but got %d of %d bytes", len(data), len(audio))
+	}
+	if got := expvarInt(requestProtocols, "HTTP/2.0"); got != before+1 {
+		t.Errorf("Expected one HTTP/2.0 request to be counted, but got %d", got-before)
+	}
+
+	resp, err = http.Post(url, "application/octet-stream", strings.NewReader("audio"))
+	if err != nil {
+		t.Fatalf("HTTP/1.1 upload error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 1 {
+		t.Errorf("Expected HTTP/1.1 clients to keep working, but got %v %v", resp.Proto, resp.StatusCode)
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+)
+
+// seqConflicts counts client seqs that were already another chunk's
+// index; see ingest.
+var seqConflicts = expvar.NewInt("seq_conflicts")
+
+// errSeqTaken is returned for a client seq that already numbers another
+// chunk of the session.
+var errSeqTaken = newKindError(ErrConflict, "seq already numbers another chunk in this session")
+
+// SessionIndexer numbers chunks within their sessions; see
+// Metadata.Index. Durable backends must keep the numbering in the store,
+// so that instances sharing it never hand out the same index twice.
+type SessionIndexer interface {
+	// ClaimSessionIndex gives chunkID an index in its session and returns
+	// it. A want of 0 takes one past the highest yet given out; otherwise
+	// want is claimed, failing with errSeqTaken if another chunk has it.
+	// Claiming again for the same chunk returns the index it already has.
+	// release gives the index back, for chunks that are never saved.
+	ClaimSessionIndex(userID, sessionID, chunkID string, want int64) (index int64, release func(), err error)
+}
+
+var _ SessionIndexer = (*MemoryStore)(nil)
+
+// sessionIndexes is the numbering of one session: the chunk holding each
+// index, the index of each chunk and the highest given out.
+type sessionIndexes struct {
+	chunks  map[int64]string
+	indexes map[string]int64
+	last    int64
+	// unsaved holds the chunks numbered since the numbering was read from
+	// the store that have not been seen stored yet.
+	unsaved map[string]bool
+	// used is when an index was last claimed.
+	used time.Time
+}
+
+func (x *sessionIndexes) take(index int64, chunkID string) {
+	x.chunks[index] = chunkID
+	x.indexes[chunkID] = index
+	x.last = max(x.last, index)
+}
+
+// give drops chunkID's claim on index. Giving back the highest index
+// lowers last, so the next chunk numbered takes it and leaves no gap.
+func (x *sessionIndexes) give(index int64, chunkID string) {
+	delete(x.chunks, index)
+	delete(x.indexes, chunkID)
+	delete(x.unsaved, chunkID)
+	if index == x.last {
+		x.last = 0
+		for i := range x.chunks {
+			x.last = max(x.last, i)
+		}
+	}
+}
+
+// ClaimSessionIndex numbers chunks under the lock of the session's shard,
+// so concurrent uploads to a session never share an index. A session's
+// numbering is read from its stored chunks the first time it is needed,
+// which carries it across restarts of a store opened from a write-ahead
+// log.
+func (s *MemoryStore) ClaimSessionIndex(userID, sessionID, chunkID string, want int64) (int64, func(), error) {
+	key := sessionKey(userID, sessionID)
+	sh := s.sessionShard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	x := sh.indexes[key]
+	if x == nil {
+		x = &sessionIndexes{chunks: make(map[int64]string), indexes: make(map[string]int64), unsaved: make(map[string]bool)}
+		s.mu.RLock()
+		for _, m := range s.listByUserLocked(userID, true) {
+			if m.SessionID == sessionID && m.Index > 0 {
+				x.take(m.Index, m.ChunkID)
+			}
+		}
+		s.mu.RUnlock()
+		sh.indexes[key] = x
+	}
+	if index, ok := x.indexes[chunkID]; ok && (want == 0 || want == index) {
+		return index, func() {}, nil
+	}
+	index := want
+	if index == 0 {
+		index = x.last + 1
+	} else if holder, ok := x.chunks[index]; ok {
+		return 0, nil, fmt.Errorf("%w: seq %d is chunk %s", errSeqTaken, index, holder)
+	}
+	x.take(index, chunkID)
+	x.unsaved[chunkID] = true
+	x.used = s.Clock.Now()
+	return index, func() {
+		sh.mu.Lock()
+		defer sh.mu.Unlock()
+		if x.chunks[index] == chunkID {
+			x.give(index, chunkID)
+		}
+	}, nil
+}
+
+// EvictSessionIndexes drops the numbering of sessions nobody has claimed
+// an index in for idle, as for closed sessions, and returns how many it
+// dropped. A session whose numbered chunks are not all stored yet is kept,
+// since its numbering is read back from the stored chunks when next
+// needed.
+func (s *MemoryStore) EvictSessionIndexes(idle time.Duration) int {
+	s.sessionShard("")
+	cutoff := s.Clock.Now().Add(-idle)
+	evicted := 0
+	for _, sh := range s.sessions {
+		sh.mu.Lock()
+		s.mu.RLock()
+		for key, x := range sh.indexes {
+			if !x.used.Before(cutoff) {
+				continue
+			}
+			maps.DeleteFunc(x.unsaved, func(id string, _ bool) bool {
+				m, ok := s.lookupLocked(id)
+				return ok && m.Index == x.indexes[id]
+			})
+			if len(x.unsaved) == 0 {
+				delete(sh.indexes, key)
+				evicted++
+			}
+		}
+		s.mu.RUnlock()
+		sh.mu.Unlock()
+	}
+	return evicted
+}
+
+// RunSessionIndexSweeper drops idle sessions' numbering every interval
+// until ctx ends.
+func RunSessionIndexSweeper(ctx context.Context, store *MemoryStore, idle, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := store.EvictSessionIndexes(idle); n > 0 {
+				log.Printf("Dropped the numbering of %d idle sessions", n)
+			}
+		}
+	}
+}
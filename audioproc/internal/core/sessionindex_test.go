@@ -0,0 +1,142 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestConcurrentUploadsNumbered(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, 50*time.Millisecond, 8000)
+
+	var wg sync.WaitGroup
+	metas := make([]Metadata, 50)
+	for i := range metas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			metas[i] = uploadTo(t, h, "user1", "s1", wav)
+		}()
+	}
+	wg.Wait()
+	var indexes []int64
+	for _, m := range metas {
+		indexes = append(indexes, m.Index)
+	}
+	slices.Sort(indexes)
+	for i, index := range indexes {
+		if index != int64(i+1) {
+			t.Fatalf("Expected indexes 1 to 50, but got %v", indexes)
+		}
+	}
+
+	resp, err := http.Get(h.URL + "/sessions/user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var listed []Metadata
+	json.NewDecoder(resp.Body).Decode(&listed)
+	for _, m := range listed {
+		if stored, _ := h.Store.Get(m.ChunkID); m.Index == 0 || m.Index != stored.Index {
+			t.Fatalf("Expected the listing to show each chunk's index, but %s has %d", m.ChunkID, m.Index)
+		}
+	}
+	if other := uploadTo(t, h, "user1", "s2", wav); other.Index != 1 {
+		t.Errorf("Expected each session numbered on its own, but got %d", other.Index)
+	}
+}
+
+func TestClientSeqIndexes(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, 50*time.Millisecond, 8000)
+	uploadTo(t, h, "user1", "s1", wav)
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s1"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, tc := range []struct {
+		seq, index int64
+		conflict   bool
+	}{
+		{seq: 1, index: 2, conflict: true},
+		{seq: 5, index: 5},
+		{seq: 3, index: 3},
+		{seq: 0, index: 6},
+	} {
+		conn.WriteJSON(wsEnvelope{Type: "chunk", Data: wav, Seq: tc.seq})
+		var ack struct {
+			client.Ack
+			SeqConflict bool `json:"seq_conflict"`
+		}
+		if err := conn.ReadJSON(&ack); err != nil || !ack.Ack.Ack || ack.Index != tc.index || ack.SeqConflict != tc.conflict {
+			t.Errorf("seq %d: expected index %d with conflict %v, but got %+v %v", tc.seq, tc.index, tc.conflict, ack, err)
+		}
+	}
+	if meta := uploadTo(t, h, "user1", "s1", wav); meta.Index != 7 {
+		t.Errorf("Expected uploads numbered past the highest seq, but got %d", meta.Index)
+	}
+}
+
+func TestClaimSessionIndex(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", SessionID: "s1", Index: 3})
+
+	index, release, err := store.ClaimSessionIndex("user1", "s1", "b", 0)
+	if err != nil || index != 4 {
+		t.Fatalf("Expected numbering to carry on from the stored chunks, but got %d %v", index, err)
+	}
+	if again, _, _ := store.ClaimSessionIndex("user1", "s1", "b", 0); again != 4 {
+		t.Errorf("Expected claiming again for the same chunk to return its index, but got %d", again)
+	}
+	if _, _, err := store.ClaimSessionIndex("user1", "s1", "c", 3); !errors.Is(err, errSeqTaken) || !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected a conflict for an index in use, but got %v", err)
+	}
+	release()
+	if index, _, err := store.ClaimSessionIndex("user1", "s1", "c", 4); err != nil || index != 4 {
+		t.Errorf("Expected a released index to be free, but got %d %v", index, err)
+	}
+}
+
+func TestSessionIndexesReleaseAndEvict(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	store.Clock = clock
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", SessionID: "s1", Index: 1})
+
+	index, _, _ := store.ClaimSessionIndex("user1", "s1", "b", 0)
+	_, release, _ := store.ClaimSessionIndex("user1", "s1", "c", 0)
+	release()
+	if next, _, _ := store.ClaimSessionIndex("user1", "s1", "d", 0); next != 3 {
+		t.Errorf("Expected the released top index to be given out again, but got %d", next)
+	}
+
+	// b and d are still being processed, so their numbering stays.
+	clock.Advance(time.Hour)
+	if n := store.EvictSessionIndexes(time.Minute); n != 0 {
+		t.Fatalf("Expected unsaved claims to keep the session, but got %d evicted", n)
+	}
+	store.Save(Metadata{ChunkID: "b", UserID: "user1", SessionID: "s1", Index: index})
+	store.Save(Metadata{ChunkID: "d", UserID: "user1", SessionID: "s1", Index: 3})
+	if n := store.EvictSessionIndexes(time.Minute); n != 1 {
+		t.Fatalf("Expected the idle session evicted, but got %d", n)
+	}
+	if next, _, _ := store.ClaimSessionIndex("user1", "s1", "e", 0); next != 4 {
+		t.Errorf("Expected numbering read back from the stored chunks, but got %d", next)
+	}
+	if n := store.EvictSessionIndexes(time.Minute); n != 0 {
+		t.Errorf("Expected a session just used to stay, but got %d evicted", n)
+	}
+}
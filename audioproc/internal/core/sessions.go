@@ -0,0 +1,300 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var errSessionClosed = newKindError(ErrConflict, "session is closed")
+
+// SessionSummary describes a session for listings.
+type SessionSummary struct {
+	UserID        string    `json:"user_id"`
+	SessionID     string    `json:"session_id"`
+	Revision      int       `json:"revision"`
+	Chunks        int       `json:"chunks"`
+	Bytes         int64     `json:"bytes"`
+	FirstActivity time.Time `json:"first_activity"`
+	LastActivity  time.Time `json:"last_activity"`
+	ClosedAt      time.Time `json:"closed_at"`
+	Reason        string    `json:"reason"`
+}
+
+// SessionEvent reports a session opening or closing.
+type SessionEvent struct {
+	Type    string         `json:"type"`
+	Summary SessionSummary `json:"summary"`
+}
+
+type sessionState struct {
+	summary SessionSummary
+	closed  bool
+}
+
+// SessionTracker records activity per user session and closes sessions that
+// go quiet for longer than the idle timeout. A chunk for a closed session
+// either starts a new revision of it or, in strict mode, is rejected.
+//
+// A closed session is kept until it is exported when an AutoExporter
+// follows the tracker, and otherwise for one more idle timeout, so late
+// chunks still find it. Sweep then drops it.
+type SessionTracker struct {
+	clock       Clock
+	idleTimeout time.Duration
+	strict      bool
+	// keepClosed holds closed sessions until Forget; see NewAutoExporter.
+	keepClosed bool
+
+	// Events receives a session_closed event for every closed session.
+	Events *Bus
+	// StoredRevision, when set, returns the last revision stored for a
+	// session the tracker no longer holds, so that a session reopened
+	// after being dropped counts on from it.
+	StoredRevision func(userID, sessionID string) int
+
+	shards []*trackerShard
+}
+
+// trackerShard holds the sessions that hash to it; see sessionShard.
+type trackerShard struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionState
+	// reopened keeps the closed revisions of sessions that were reopened.
+	reopened []SessionSummary
+}
+
+// NewSessionTracker closes sessions idle for cfg.SessionIdleTimeout, and
+// splits them into cfg.SessionShards shards.
+func NewSessionTracker(cfg Config, clock Clock) *SessionTracker {
+	t := &SessionTracker{
+		clock:       clock,
+		idleTimeout: cfg.SessionIdleTimeout,
+		strict:      cfg.StrictSessions,
+		shards:      make([]*trackerShard, max(cfg.SessionShards, 1)),
+	}
+	for i := range t.shards {
+		t.shards[i] = &trackerShard{sessions: make(map[string]*sessionState)}
+	}
+	return t
+}
+
+func (t *SessionTracker) shard(key string) *trackerShard {
+	return t.shards[sessionShard(key, len(t.shards))]
+}
+
+func sessionKey(userID, sessionID string) string {
+	return userID + "/" + sessionID
+}
+
+// Touch records a chunk of size bytes for the session and returns the
+// session revision it belongs to.
+func (t *SessionTracker) Touch(userID, sessionID string, size int) (int, error) {
+	key := sessionKey(userID, sessionID)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	now := t.clock.Now()
+	st, ok := sh.sessions[key]
+	if ok && st.closed {
+		if t.strict {
+			return 0, fmt.Errorf("%w: %s", errSessionClosed, key)
+		}
+		if t.keepClosed {
+			sh.reopened = append(sh.reopened, st.summary)
+		}
+		st = &sessionState{summary: SessionSummary{Revision: st.summary.Revision + 1}}
+		sh.sessions[key] = st
+	} else if !ok {
+		rev := 1
+		if t.StoredRevision != nil {
+			rev += t.StoredRevision(userID, sessionID)
+		}
+		st = &sessionState{summary: SessionSummary{Revision: rev}}
+		sh.sessions[key] = st
+	}
+	if st.summary.Chunks == 0 {
+		st.summary.UserID, st.summary.SessionID = userID, sessionID
+		st.summary.FirstActivity = now
+	}
+	st.summary.Chunks++
+	st.summary.Bytes += int64(size)
+	st.summary.LastActivity = now
+	return st.summary.Revision, nil
+}
+
+// KeepAlive records activity on an open session without adding a chunk,
+// as for an empty heartbeat upload, so it is not closed as idle.
+func (t *SessionTracker) KeepAlive(userID, sessionID string) {
+	key := sessionKey(userID, sessionID)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if st, ok := sh.sessions[key]; ok && !st.closed {
+		st.summary.LastActivity = t.clock.Now()
+	}
+}
+
+// End closes a session at the client's request.
+func (t *SessionTracker) End(userID, sessionID string) (SessionSummary, bool) {
+	key := sessionKey(userID, sessionID)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	st, ok := sh.sessions[key]
+	if !ok || st.closed {
+		sh.mu.Unlock()
+		return SessionSummary{}, false
+	}
+	summary := t.closeLocked(st, "ended")
+	sh.mu.Unlock()
+	t.publish(summary)
+	return summary, true
+}
+
+// Sweep closes every session idle for longer than the timeout, a shard
+// at a time, and drops those closed for as long again that nothing is
+// waiting to export.
+func (t *SessionTracker) Sweep() []SessionSummary {
+	now := t.clock.Now()
+	var closed []SessionSummary
+	for _, sh := range t.shards {
+		sh.mu.Lock()
+		for key, st := range sh.sessions {
+			switch {
+			case !st.closed && now.Sub(st.summary.LastActivity) > t.idleTimeout:
+				closed = append(closed, t.closeLocked(st, "idle"))
+			case st.closed && !t.keepClosed && now.Sub(st.summary.ClosedAt) > t.idleTimeout:
+				delete(sh.sessions, key)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	for _, summary := range closed {
+		t.publish(summary)
+	}
+	return closed
+}
+
+// ClosedBefore returns the session revisions that closed before before,
+// by when they closed.
+func (t *SessionTracker) ClosedBefore(before time.Time) []SessionSummary {
+	var closed []SessionSummary
+	for _, sh := range t.shards {
+		sh.mu.Lock()
+		for _, s := range sh.reopened {
+			if s.ClosedAt.Before(before) {
+				closed = append(closed, s)
+			}
+		}
+		for _, st := range sh.sessions {
+			if st.closed && st.summary.ClosedAt.Before(before) {
+				closed = append(closed, st.summary)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	sort.Slice(closed, func(i, j int) bool { return closed[i].ClosedAt.Before(closed[j].ClosedAt) })
+	return closed
+}
+
+// Forget drops the closed session revision s, as once it has been
+// exported.
+func (t *SessionTracker) Forget(s SessionSummary) {
+	key := sessionKey(s.UserID, s.SessionID)
+	sh := t.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.reopened = slices.DeleteFunc(sh.reopened, func(r SessionSummary) bool {
+		return r.UserID == s.UserID && r.SessionID == s.SessionID && r.Revision == s.Revision
+	})
+	if st, ok := sh.sessions[key]; ok && st.closed && st.summary.Revision == s.Revision {
+		delete(sh.sessions, key)
+	}
+}
+
+func (t *SessionTracker) closeLocked(st *sessionState, reason string) SessionSummary {
+	st.closed = true
+	st.summary.ClosedAt = t.clock.Now()
+	st.summary.Reason = reason
+	return st.summary
+}
+
+func (t *SessionTracker) publish(summary SessionSummary) {
+	t.Events.Publish(Event{Type: EventSessionClosed, At: summary.ClosedAt, Session: &summary})
+}
+
+// RunSessionSweeper closes idle sessions every interval until ctx ends.
+// Sessions are left open while ro is on, since closing one writes.
+func RunSessionSweeper(ctx context.Context, t *SessionTracker, ro *ReadOnly, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if ro.Enabled() {
+				continue
+			}
+			if closed := t.Sweep(); len(closed) > 0 {
+				log.Printf("Closed %d idle sessions", len(closed))
+			}
+		}
+	}
+}
+
+// handleEvents streams session events and quota warnings as server-sent
+// events; a caller with an API key only sees its own user's quota
+// warnings. Events are dropped for clients that fall too far behind.
+func handleEvents(bus *Bus, g *Goroutines, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		ctx, done := g.TrackStream(r.Context(), "sse")
+		defer done()
+		events := make(chan Event)
+		gone := make(chan struct{})
+		unsubscribe := bus.Subscribe("sse", 16, func(ev Event) {
+			select {
+			case events <- ev:
+			case <-gone:
+			}
+		}, EventSessionClosed, EventQuotaWarning)
+		defer unsubscribe()
+		defer close(gone)
+
+		clearDeadlines(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-events:
+				// A quota warning goes only to its user and admins.
+				if ev.Quota != nil && checkUserAccess(cfg, r, ev.Quota.UserID) != nil {
+					continue
+				}
+				var data []byte
+				if ev.Quota != nil {
+					data, _ = json.Marshal(ev.Quota)
+				} else {
+					data, _ = json.Marshal(SessionEvent{Type: ev.Type, Summary: *ev.Session})
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+				flusher.Flush()
+			}
+		}
+	}
+}
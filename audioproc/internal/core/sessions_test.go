@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionTracker_IdleCloseAndReopen(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker := NewSessionTracker(DefaultConfig(), clock)
+	tracker.Events = NewBus()
+	var events []SessionEvent
+	defer tracker.Events.Subscribe("test", 16, func(ev Event) {
+		events = append(events, SessionEvent{Type: ev.Type, Summary: *ev.Session})
+	})()
+
+	tracker.Touch("user1", "sess1", 10)
+	clock.Advance(time.Minute)
+	tracker.Touch("user1", "sess1", 20)
+
+	clock.Advance(DefaultConfig().SessionIdleTimeout)
+	if closed := tracker.Sweep(); len(closed) != 0 {
+		t.Fatalf("Expected no session to close exactly at the timeout, but got %+v", closed)
+	}
+	clock.Advance(time.Second)
+	tracker.Sweep()
+	tracker.Events.Flush()
+
+	if len(events) != 1 || events[0].Type != "session_closed" {
+		t.Fatalf("Expected one session_closed event, but got %+v", events)
+	}
+	s := events[0].Summary
+	if s.Chunks != 2 || s.Bytes != 30 || s.Reason != "idle" || s.LastActivity.Sub(s.FirstActivity) != time.Minute {
+		t.Errorf("Unexpected summary %+v", s)
+	}
+
+	rev, err := tracker.Touch("user1", "sess1", 5)
+	if err != nil || rev != 2 {
+		t.Errorf("Expected a late chunk to open revision 2, but got %v, %v", rev, err)
+	}
+}
+
+func TestSessionTracker_StrictRejectsReopen(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StrictSessions = true
+	clock := NewFakeClock(time.Now())
+	tracker := NewSessionTracker(cfg, clock)
+
+	tracker.Touch("user1", "sess1", 1)
+	clock.Advance(cfg.SessionIdleTimeout + time.Second)
+	tracker.Sweep()
+
+	if _, err := tracker.Touch("user1", "sess1", 1); !errors.Is(err, errSessionClosed) {
+		t.Errorf("Expected errSessionClosed, but got %v", err)
+	}
+	if _, err := tracker.Touch("user1", "sess2", 1); err != nil {
+		t.Errorf("Expected other sessions to be unaffected, but got %v", err)
+	}
+}
+
+func TestSessionTracker_DropsClosedSessions(t *testing.T) {
+	cfg := DefaultConfig()
+	clock := NewFakeClock(time.Now())
+	tracker := NewSessionTracker(cfg, clock)
+	tracker.StoredRevision = func(userID, sessionID string) int { return 3 }
+	held := func() int {
+		n := 0
+		for _, sh := range tracker.shards {
+			n += len(sh.sessions) + len(sh.reopened)
+		}
+		return n
+	}
+
+	tracker.Touch("user1", "sess1", 1)
+	tracker.Touch("user1", "sess2", 1)
+	tracker.End("user1", "sess2")
+	tracker.Touch("user1", "sess2", 1)
+	clock.Advance(cfg.SessionIdleTimeout + time.Second)
+	tracker.Sweep()
+	if n := held(); n != 2 {
+		t.Fatalf("Expected the closed sessions kept for late chunks, but got %d held", n)
+	}
+	clock.Advance(cfg.SessionIdleTimeout + time.Second)
+	tracker.Sweep()
+	if n := held(); n != 0 {
+		t.Errorf("Expected the closed sessions dropped, but got %d held", n)
+	}
+	if rev, err := tracker.Touch("user1", "sess1", 1); err != nil || rev != 4 {
+		t.Errorf("Expected a dropped session to reopen after its stored revision, but got %v, %v", rev, err)
+	}
+
+	// Sessions waiting to be exported stay until they are forgotten.
+	tracker = NewSessionTracker(cfg, clock)
+	tracker.keepClosed = true
+	tracker.Touch("user1", "sess1", 1)
+	summary, _ := tracker.End("user1", "sess1")
+	clock.Advance(2 * (cfg.SessionIdleTimeout + time.Second))
+	tracker.Sweep()
+	if n := held(); n != 1 {
+		t.Fatalf("Expected the closed session kept for export, but got %d held", n)
+	}
+	tracker.Forget(summary)
+	if n := held(); n != 0 {
+		t.Errorf("Expected the exported session dropped, but got %d held", n)
+	}
+}
+
+func TestHarness_EndSessionStreamsSummary(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StrictSessions = true
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	resp, err := http.Get(h.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=live"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.BinaryMessage, []byte("audio"))
+	conn.ReadMessage()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"end_session"}`))
+	var closed SessionEvent
+	conn.ReadJSON(&closed)
+	if closed.Summary.Chunks != 1 || closed.Summary.Reason != "ended" {
+		t.Errorf("Expected a summary of the ended session, but got %+v", closed)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "data: "); ok {
+			data = rest
+		}
+	}
+	var ev SessionEvent
+	json.Unmarshal([]byte(data), &ev)
+	if ev.Type != "session_closed" || ev.Summary.SessionID != "live" {
+		t.Errorf("Expected the SSE stream to carry session_closed, but got %+v", ev)
+	}
+
+	upload, err := http.Post(h.URL+"/upload?user_id=user1&session_id=live", "audio/wav", strings.NewReader("late"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload.Body.Close()
+	if upload.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 for a chunk on a closed session, but got %v", upload.StatusCode)
+	}
+}
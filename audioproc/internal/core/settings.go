@@ -0,0 +1,172 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const maxRetentionHours = 365 * 24
+
+var languageTag = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// UserSettings are a user's processing preferences. Unset fields fall back
+// to the server defaults, so a user only stores what they override.
+// Uploads pick up the settings current when they arrive; changing them
+// does not touch chunks already stored.
+type UserSettings struct {
+	Transcribe *bool `json:"transcribe,omitempty"`
+	// Language is a BCP 47 hint passed to the transcriber, e.g. "en-US".
+	Language string `json:"language,omitempty"`
+	// Normalize scales audio to full volume before transcription.
+	Normalize *bool `json:"normalize,omitempty"`
+	// RetentionHours overrides how long the user's deleted chunks can be
+	// restored.
+	RetentionHours *int `json:"retention_hours,omitempty"`
+}
+
+// defaultSettings are the server-wide settings users fall back to.
+func (cfg Config) defaultSettings() UserSettings {
+	hours := int(cfg.TrashRetention / time.Hour)
+	return UserSettings{
+		Transcribe:     &cfg.Transcribe,
+		Language:       cfg.Language,
+		Normalize:      &cfg.Normalize,
+		RetentionHours: &hours,
+	}
+}
+
+// merge returns s with every field that o sets taken from o.
+func (s UserSettings) merge(o UserSettings) UserSettings {
+	if o.Transcribe != nil {
+		s.Transcribe = o.Transcribe
+	}
+	if o.Language != "" {
+		s.Language = o.Language
+	}
+	if o.Normalize != nil {
+		s.Normalize = o.Normalize
+	}
+	if o.RetentionHours != nil {
+		s.RetentionHours = o.RetentionHours
+	}
+	return s
+}
+
+// transcribe defaults to true when neither the user nor the server says.
+func (s UserSettings) transcribe() bool {
+	return s.Transcribe == nil || *s.Transcribe
+}
+
+func (s UserSettings) normalize() bool {
+	return s.Normalize != nil && *s.Normalize
+}
+
+func validateSettings(s UserSettings) error {
+	if s.Language != "" && !languageTag.MatchString(s.Language) {
+		return invalidField("language", "invalid_settings", fmt.Sprintf("%q is not a language tag like en or en-US", s.Language))
+	}
+	if s.RetentionHours != nil && (*s.RetentionHours < 1 || *s.RetentionHours > maxRetentionHours) {
+		return invalidField("retention_hours", "invalid_settings", fmt.Sprintf("must be 1 to %d", maxRetentionHours))
+	}
+	return nil
+}
+
+// normalizeWAV rescales WAV audio so its loudest sample is at full scale.
+// Audio that doesn't decode, or is silent, comes back unchanged.
+func normalizeWAV(data []byte) []byte {
+	pcm, err := decodeWAV(data)
+	if err != nil {
+		return data
+	}
+	peak := 0.0
+	for _, s := range pcm.Samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	if peak == 0 || peak >= 1 {
+		return data
+	}
+	samples := make([]int16, len(pcm.Samples))
+	for i, s := range pcm.Samples {
+		samples[i] = int16(s / peak * math.MaxInt16)
+	}
+	return EncodeWAV(samples, pcm.SampleRate)
+}
+
+// UserSettings returns a user's overrides.
+func (s *MemoryStore) UserSettings(userID string) (UserSettings, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[userID], nil
+}
+
+// SaveUserSettings replaces a user's overrides.
+func (s *MemoryStore) SaveUserSettings(userID string, settings UserSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[userID] = settings
+	s.logEntryLocked(walSettings, userID, settings)
+	return nil
+}
+
+// retentionLocked is how long userID's deleted chunks stay restorable.
+// Callers hold s.mu.
+func (s *MemoryStore) retentionLocked(userID string) time.Duration {
+	if h := s.settings[userID].RetentionHours; h != nil {
+		return time.Duration(*h) * time.Hour
+	}
+	return s.Retention
+}
+
+// handleGetSettings returns a user's effective settings: their overrides
+// on top of the server defaults.
+func handleGetSettings(store Store, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		settings, err := store.UserSettings(userID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cfg.defaultSettings().merge(settings))
+	}
+}
+
+// handlePutSettings replaces a user's overrides; fields left out go back to
+// the server defaults.
+func handlePutSettings(store Store, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["id"]
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			writeError(w, err)
+			return
+		}
+		var settings UserSettings
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&settings); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_settings_body", err.Error())
+			return
+		}
+		if err := validateSettings(settings); err != nil {
+			writeError(w, err)
+			return
+		}
+		if err := store.SaveUserSettings(userID, settings); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cfg.defaultSettings().merge(settings))
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func putSettings(t *testing.T, h *Harness, userID, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest("PUT", h.URL+"/users/"+userID+"/settings", strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT settings error: %v", err)
+	}
+	return resp
+}
+
+func TestUserSettingsDisableTranscription(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	before := uploadTo(t, h, "quiet", "s", []byte("audio"))
+	resp := putSettings(t, h, "quiet", `{"transcribe": false, "language": "de"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected settings to be saved, but got %v", resp.StatusCode)
+	}
+
+	quiet := uploadTo(t, h, "quiet", "s", []byte("audio"))
+	if quiet.Transcript != "" || quiet.TranscriptSkipReason == "" {
+		t.Errorf("Expected no transcript for a user with transcription off, but got %+v", quiet)
+	}
+	if other := uploadTo(t, h, "other", "s", []byte("audio")); other.Transcript == "" {
+		t.Errorf("Expected other users to keep transcription, but got %+v", other)
+	}
+	if m, _ := h.Store.Get(before.ChunkID); m.Transcript == "" {
+		t.Errorf("Expected chunks uploaded earlier to keep their transcript")
+	}
+
+	resp, err := http.Get(h.URL + "/users/quiet/settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var got UserSettings
+	json.NewDecoder(resp.Body).Decode(&got)
+	if got.transcribe() || got.Language != "de" || !got.normalize() || got.RetentionHours == nil || *got.RetentionHours != 7*24 {
+		t.Errorf("Expected overrides on top of the defaults, but got %+v", got)
+	}
+}
+
+func TestUserSettingsValidation(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	for _, tc := range []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"language": "not a language"}`, http.StatusUnprocessableEntity, "invalid_settings"},
+		{`{"retention_hours": 0}`, http.StatusUnprocessableEntity, "invalid_settings"},
+		{`{"retention_hours": 100000}`, http.StatusUnprocessableEntity, "invalid_settings"},
+		{`{"transcribe": "yes"}`, http.StatusBadRequest, "invalid_settings_body"},
+		{`{"unknown": true}`, http.StatusBadRequest, "invalid_settings_body"},
+	} {
+		resp := putSettings(t, h, "user1", tc.body)
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || e.Error != tc.code {
+			t.Errorf("%s: expected %v %s, but got %v %q", tc.body, tc.status, tc.code, resp.StatusCode, e.Error)
+		}
+	}
+	if s, _ := h.Store.UserSettings("user1"); s != (UserSettings{}) {
+		t.Errorf("Expected invalid settings not to be saved, but got %+v", s)
+	}
+}
+
+func TestUserSettingsOtherUser(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	_, user1 := h.Keys.Mint("user1", nil, 0)
+	_, user2 := h.Keys.Mint("user2", nil, 0)
+	for _, tc := range []struct {
+		method, key string
+		want        int
+	}{
+		{"GET", user1, http.StatusOK},
+		{"PUT", user1, http.StatusOK},
+		{"GET", user2, http.StatusForbidden},
+		{"PUT", user2, http.StatusForbidden},
+	} {
+		// The owner turns transcription off; the other user tries to turn it
+		// back on.
+		body := fmt.Sprintf(`{"transcribe": %t}`, tc.key == user2)
+		req, _ := http.NewRequest(tc.method, h.URL+"/users/user1/settings", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+tc.key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s %s: expected status code %d, but got %d", tc.method, body, tc.want, resp.StatusCode)
+		}
+	}
+	if settings, _ := h.Store.UserSettings("user1"); settings.Transcribe == nil || *settings.Transcribe {
+		t.Errorf("Expected only the owner's update to be saved, but got %+v", settings)
+	}
+}
+
+func TestUserSettingsRetentionOverride(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	store := NewMemoryStore()
+	store.Clock = clock
+	store.Retention = 24 * time.Hour
+	hours := 1
+	store.SaveUserSettings("brief", UserSettings{RetentionHours: &hours})
+	store.Save(Metadata{ChunkID: "a", UserID: "brief"})
+	store.Save(Metadata{ChunkID: "b", UserID: "other"})
+	store.Delete("a")
+	store.Delete("b")
+
+	clock.Advance(2 * time.Hour)
+	if _, err := store.Restore("a"); err != errRetentionExpired {
+		t.Errorf("Expected the user's shorter retention to apply, but got %v", err)
+	}
+	if _, err := store.Restore("b"); err != nil {
+		t.Errorf("Expected the default retention for other users, but got %v", err)
+	}
+}
+
+func TestNormalizeWAV(t *testing.T) {
+	quiet := samplesWAV(100, func(i int) float64 { return 0.25 * float64(i%2*2-1) })
+	pcm, _ := decodeWAV(normalizeWAV(quiet))
+	if peak := pcm.Samples[0]; peak > -0.99 {
+		t.Errorf("Expected the peak to reach full scale, but got %v", peak)
+	}
+	silent := samplesWAV(100, func(int) float64 { return 0 })
+	if got := normalizeWAV(silent); string(got) != string(silent) {
+		t.Errorf("Expected silence to be left alone")
+	}
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// Session-scoped state, such as the WebSocket connection registry, session
+// activity and chunk numbering, is split into shards by session, each
+// behind its own lock, so that traffic on one session does not wait on the
+// lock of every other; see Config.SessionShards. Shards are picked by
+// sessionKey rather than session ID alone, since many users name their
+// sessions alike.
+
+// sessionShard returns which of n shards the session keyed by key belongs
+// to. Fewer than one shard counts as one.
+func sessionShard(key string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// storeShard is the session state a MemoryStore keeps for the sessions in
+// one shard, by sessionKey. Its lock is taken before the store's, never
+// after.
+type storeShard struct {
+	mu      sync.Mutex
+	acks    map[string]SessionAcks
+	indexes map[string]*sessionIndexes
+}
+
+// sessionShard returns the shard holding the state of the session keyed
+// by key, making the shards on first use.
+func (s *MemoryStore) sessionShard(key string) *storeShard {
+	s.sessionsOnce.Do(func() {
+		s.sessions = make([]*storeShard, max(s.SessionShards, 1))
+		for i := range s.sessions {
+			s.sessions[i] = &storeShard{acks: make(map[string]SessionAcks), indexes: make(map[string]*sessionIndexes)}
+		}
+	})
+	return s.sessions[sessionShard(key, len(s.sessions))]
+}
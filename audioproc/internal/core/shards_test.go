@@ -0,0 +1,165 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionShard(t *testing.T) {
+	for _, n := range []int{-1, 0, 1} {
+		if got := sessionShard("user1/s1", n); got != 0 {
+			t.Errorf("Expected %d shards to put everything in shard 0, but got %d", n, got)
+		}
+	}
+	used := make(map[int]bool)
+	for i := range 1000 {
+		key := sessionKey("user1", fmt.Sprintf("s%d", i))
+		got := sessionShard(key, 16)
+		if got < 0 || got >= 16 || got != sessionShard(key, 16) {
+			t.Fatalf("Expected a stable shard below 16 for %s, but got %d", key, got)
+		}
+		used[got] = true
+	}
+	if len(used) != 16 {
+		t.Errorf("Expected sessions spread over all 16 shards, but got %d", len(used))
+	}
+}
+
+// TestSessionShardsUnderLoad streams into, observes and numbers many
+// sessions at once, with producers switching sessions midway. Run it with
+// -race.
+func TestSessionShardsUnderLoad(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SessionShards = 8
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	const sessions, perSession = 32, 20
+	session := func(i int) string { return fmt.Sprintf("s%d", i%sessions) }
+	var wg sync.WaitGroup
+	var conns sync.Map
+	dial := func(path string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(h.WSURL(path), nil)
+		if err != nil {
+			t.Error(err)
+			return nil
+		}
+		conns.Store(conn, true)
+		// Drain whatever the server sends so its writes never block.
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		return conn
+	}
+	defer conns.Range(func(conn, _ any) bool {
+		conn.(*websocket.Conn).Close()
+		return true
+	})
+
+	var claimed sync.Map
+	var duplicates atomic.Int64
+	for i := range sessions {
+		// A producer opens on the session before i and says hello to i.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := dial("/ws?user_id=user1&session_id=" + session(i+sessions-1))
+			if conn != nil {
+				conn.WriteJSON(map[string]any{"type": "hello", "session_id": session(i)})
+			}
+		}()
+		// Two observers attach to i; one of them leaves again.
+		for j := range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if conn := dial("/sessions/user1/" + session(i) + "/observe"); conn != nil && j == 0 {
+					conns.Delete(conn)
+					conn.Close()
+				}
+			}()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := sessionKey("user1", session(i))
+			for n := range perSession {
+				h.Sessions.Touch("user1", session(i), 100)
+				h.Store.RecordAck(key, int64(perSession-n))
+				index, _, err := h.Store.ClaimSessionIndex("user1", session(i), fmt.Sprintf("%s-%d", session(i), n), 0)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, taken := claimed.LoadOrStore(fmt.Sprintf("%s/%d", session(i), index), true); taken {
+					duplicates.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := duplicates.Load(); n != 0 {
+		t.Errorf("Expected every index to be given out once, but got %d given out twice", n)
+	}
+	for i := range sessions {
+		if acks := h.Store.SessionAcks(sessionKey("user1", session(i))); acks.HighWater != perSession {
+			t.Errorf("Expected %s acked up to %d, but got %+v", session(i), perSession, acks)
+		}
+	}
+	// The hellos and the observers that left are handled asynchronously.
+	settled := func() bool {
+		counts, live := h.wsConns.observerCounts(), h.wsConns.live()
+		if len(counts) != sessions || len(live) != sessions {
+			return false
+		}
+		for i := range sessions {
+			if counts[sessionKey("user1", session(i))] != 1 {
+				return false
+			}
+		}
+		return true
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && !settled(); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !settled() {
+		t.Errorf("Expected one producer and one observer on each of %d sessions, but got %v and %v", sessions, h.wsConns.live(), h.wsConns.observerCounts())
+	}
+}
+
+// BenchmarkSessionShards updates many sessions' state in parallel with
+// one shard and with the default number. Compare the two with
+// -mutexprofile.
+func BenchmarkSessionShards(b *testing.B) {
+	for _, shards := range []int{1, DefaultConfig().SessionShards} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			cfg := DefaultConfig()
+			cfg.SessionShards = shards
+			tracker := NewSessionTracker(cfg, realClock{})
+			store := NewMemoryStore()
+			store.SessionShards = shards
+			var next atomic.Int64
+			b.RunParallel(func(pb *testing.PB) {
+				sessionID := fmt.Sprintf("s%d", next.Add(1))
+				key := sessionKey("user1", sessionID)
+				for i := int64(1); pb.Next(); i++ {
+					tracker.Touch("user1", sessionID, 100)
+					store.RecordAck(key, i)
+					if _, release, err := store.ClaimSessionIndex("user1", sessionID, "chunk", 0); err == nil {
+						release()
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errShareNotFound = newKindError(ErrNotFound, "share link not found")
+	errShareExpired  = newKindError(ErrGone, "share link has expired")
+	errShareRevoked  = newKindError(ErrGone, "share link has been revoked")
+	errShareUsedUp   = newKindError(ErrGone, "share link has been used up")
+)
+
+// ShareLink grants read-only access to one session until ExpiresAt. A link
+// with MaxDownloads set stops working altogether once that many audio
+// downloads have been made with it; a single-use link has 1.
+type ShareLink struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	SessionID    string    `json:"session_id"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MaxDownloads int       `json:"max_downloads,omitempty"`
+}
+
+// ShareLinks mints and checks share tokens. A token is the link encoded as
+// JSON and signed with HMAC-SHA256, so nothing but each link's owner,
+// revocation and download count is stored.
+// Without a configured secret a random one is used, and links stop working
+// when the process restarts.
+type ShareLinks struct {
+	store  *MemoryStore
+	secret []byte
+	clock  Clock
+	ttl    time.Duration
+	maxTTL time.Duration
+}
+
+// NewShareLinks signs links with cfg.ShareSecret, or with a random secret
+// when none is set.
+func NewShareLinks(cfg Config, store *MemoryStore) *ShareLinks {
+	secret := []byte(cfg.ShareSecret)
+	if len(secret) == 0 {
+		log.Println("AUDIO_SHARE_SECRET is not set; share links will not survive a restart")
+		secret = make([]byte, 32)
+		rand.Read(secret)
+	}
+	return &ShareLinks{store: store, secret: secret, clock: realClock{}, ttl: cfg.ShareTTL, maxTTL: cfg.ShareMaxTTL}
+}
+
+func (l *ShareLinks) sign(payload string) string {
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Mint returns a new link to a session and its token, and records the
+// link's owner so that only the link's session can revoke it. maxDownloads
+// of 0 allows any number of downloads.
+func (l *ShareLinks) Mint(userID, sessionID string, ttl time.Duration, maxDownloads int) (ShareLink, string) {
+	link := ShareLink{
+		ID:           uuid.New().String(),
+		UserID:       userID,
+		SessionID:    sessionID,
+		ExpiresAt:    l.clock.Now().Add(ttl).UTC().Truncate(time.Second),
+		MaxDownloads: maxDownloads,
+	}
+	l.store.AddShare(link)
+	raw, _ := json.Marshal(link)
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return link, payload + "." + l.sign(payload)
+}
+
+// Verify returns the link a token grants, or an error if the token is
+// forged, expired, revoked or used up.
+func (l *ShareLinks) Verify(token string) (ShareLink, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(l.sign(payload))) {
+		return ShareLink{}, errShareNotFound
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	var link ShareLink
+	if err != nil || json.Unmarshal(raw, &link) != nil {
+		return ShareLink{}, errShareNotFound
+	}
+	if !l.clock.Now().Before(link.ExpiresAt) {
+		return ShareLink{}, errShareExpired
+	}
+	if l.store.ShareRevoked(link.ID) {
+		return ShareLink{}, errShareRevoked
+	}
+	if link.MaxDownloads > 0 && l.store.ShareDownloads(link.ID) >= link.MaxDownloads {
+		return ShareLink{}, errShareUsedUp
+	}
+	return link, nil
+}
+
+// shareState is what the store keeps of a share link: whose session it
+// shares, whether it was revoked and, for a link with a download limit,
+// how often it was used. It is logged to the write-ahead log as it is, and
+// dropped once the link has expired, when Verify refuses the link anyway.
+type shareState struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"user_id,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	Revoked   bool      `json:"revoked,omitempty"`
+	Downloads int       `json:"downloads,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (s *MemoryStore) setShareLocked(st shareState) {
+	s.shares[st.ID] = st
+	s.appendWALLocked(walRecord{Share: &st})
+}
+
+// AddShare records a newly minted link's owner.
+func (s *MemoryStore) AddShare(link ShareLink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setShareLocked(shareState{ID: link.ID, UserID: link.UserID, SessionID: link.SessionID, ExpiresAt: link.ExpiresAt})
+}
+
+// RevokeShare permanently disables the share link with that ID, which
+// must share userID's session sessionID. Links the store has no record of,
+// including those that have expired, are reported as not found.
+func (s *MemoryStore) RevokeShare(id, userID, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.shares[id]
+	if !ok || st.UserID != userID || st.SessionID != sessionID {
+		return errShareNotFound
+	}
+	st.Revoked = true
+	s.setShareLocked(st)
+	return nil
+}
+
+// ShareRevoked reports whether the share link id was revoked.
+func (s *MemoryStore) ShareRevoked(id string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shares[id].Revoked
+}
+
+// ClaimShareDownload counts a download made with link, unless its
+// MaxDownloads have been made already. The check and the count happen
+// together, so concurrent downloads cannot get past the limit. Links
+// without a limit are not counted.
+func (s *MemoryStore) ClaimShareDownload(link ShareLink) bool {
+	if link.MaxDownloads <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.shares[link.ID]
+	if !ok {
+		st = shareState{ID: link.ID, ExpiresAt: link.ExpiresAt}
+	}
+	if st.Downloads >= link.MaxDownloads {
+		return false
+	}
+	st.Downloads++
+	s.setShareLocked(st)
+	return true
+}
+
+// ShareDownloads reports how many downloads share link id has been used
+// for, counting only links with a limit.
+func (s *MemoryStore) ShareDownloads(id string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shares[id].Downloads
+}
+
+// PruneShares forgets the revocations and counts of links expired by now
+// and returns how many it dropped. The drops are not logged: a record
+// replayed for a link that has expired is dropped again by the next
+// prune.
+func (s *MemoryStore) PruneShares(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := len(s.shares)
+	maps.DeleteFunc(s.shares, func(_ string, st shareState) bool { return !now.Before(st.ExpiresAt) })
+	return n - len(s.shares)
+}
+
+// RunShareSweeper prunes expired share links' state every interval until
+// ctx ends.
+func RunShareSweeper(ctx context.Context, store *MemoryStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := store.PruneShares(now); n > 0 {
+				log.Printf("Pruned the state of %d expired share links", n)
+			}
+		}
+	}
+}
+
+// shareRequest is the body of POST .../share. SingleUse is MaxDownloads 1.
+type shareRequest struct {
+	ExpiresInSeconds int64 `json:"expires_in_seconds,omitempty"`
+	SingleUse        bool  `json:"single_use,omitempty"`
+	MaxDownloads     int   `json:"max_downloads,omitempty"`
+}
+
+// createdShare is the one response that carries a link's token.
+type createdShare struct {
+	ShareLink
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+func handleCreateShare(l *ShareLinks, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		var req shareRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid_share", "body must be {\"expires_in_seconds\": n, \"single_use\": bool, \"max_downloads\": n}")
+				return
+			}
+		}
+		ttl := l.ttl
+		if req.ExpiresInSeconds != 0 {
+			ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+		}
+		if ttl <= 0 || ttl > l.maxTTL {
+			writeError(w, invalidField("expires_in_seconds", "invalid_share", fmt.Sprintf("must be 1 to %d", int64(l.maxTTL/time.Second))))
+			return
+		}
+		if req.MaxDownloads < 0 || (req.SingleUse && req.MaxDownloads > 1) {
+			writeError(w, invalidField("max_downloads", "invalid_share", "max_downloads must be positive, and 1 for a single-use link"))
+			return
+		}
+		if req.SingleUse {
+			req.MaxDownloads = 1
+		}
+		if len(l.store.ListBySession(vars["user_id"], vars["session_id"])) == 0 {
+			writeError(w, fmt.Errorf("session %s: %w", vars["session_id"], ErrNotFound))
+			return
+		}
+		link, token := l.Mint(vars["user_id"], vars["session_id"], ttl, req.MaxDownloads)
+		l.store.RecordAudit(AuditEvent{Actor: requestActor(r), Action: "share.create", UserID: link.UserID, SessionID: link.SessionID})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createdShare{link, token, cfg.PublicURL + "/shared/" + token + "/chunks"})
+	}
+}
+
+func handleRevokeShare(l *ShareLinks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := l.store.RevokeShare(vars["share_id"], vars["user_id"], vars["session_id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		l.store.RecordAudit(AuditEvent{Actor: requestActor(r), Action: "share.revoke", UserID: vars["user_id"], SessionID: vars["session_id"]})
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// shared verifies the request's share token and records the access as
+// action before calling next. Chunks outside the shared session are
+// reported as missing. Audio downloads count against the link's
+// MaxDownloads.
+func (l *ShareLinks) shared(action string, next func(w http.ResponseWriter, r *http.Request, link ShareLink, chunk Metadata)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		link, err := l.Verify(vars["token"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var chunk Metadata
+		if id, ok := vars["id"]; ok {
+			chunk, err = l.store.Get(id)
+			if err == nil && (chunk.UserID != link.UserID || chunk.SessionID != link.SessionID) {
+				err = errNotFound
+			}
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		if action == "shared.audio" && !l.store.ClaimShareDownload(link) {
+			writeError(w, errShareUsedUp)
+			return
+		}
+		l.store.RecordAudit(AuditEvent{Actor: "share:" + link.ID, Action: action, UserID: link.UserID, SessionID: link.SessionID, ChunkID: chunk.ChunkID})
+		next(w, r, link, chunk)
+	}
+}
+
+func handleSharedList(l *ShareLinks) http.HandlerFunc {
+	return l.shared("shared.list", func(w http.ResponseWriter, r *http.Request, link ShareLink, _ Metadata) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.store.ListBySession(link.UserID, link.SessionID))
+	})
+}
+
+func handleSharedChunk(l *ShareLinks) http.HandlerFunc {
+	return l.shared("shared.read", func(w http.ResponseWriter, r *http.Request, _ ShareLink, chunk Metadata) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(chunk)
+	})
+}
+
+func handleSharedAudio(l *ShareLinks, tc *Transcoder) http.HandlerFunc {
+	return l.shared("shared.audio", func(w http.ResponseWriter, r *http.Request, _ ShareLink, chunk Metadata) {
+		writeChunkAudio(w, r, l.store, tc, chunk)
+	})
+}
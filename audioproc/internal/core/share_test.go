@@ -0,0 +1,218 @@
+package core
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createShare(t *testing.T, h *Harness, path, body string) (int, string, string) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", h.URL+path, strings.NewReader(body))
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Share error: %v", err)
+	}
+	defer resp.Body.Close()
+	var link struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&link)
+	return resp.StatusCode, link.ID, link.Token
+}
+
+func sharedGet(t *testing.T, h *Harness, token, path string) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(h.URL + "/shared/" + token + path)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, body
+}
+
+func shareConfig() Config {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	cfg.ShareSecret = "share-secret"
+	return cfg
+}
+
+func TestShareLinkAccessAndScope(t *testing.T) {
+	h := NewHarness(shareConfig())
+	defer h.Close()
+	shared := uploadTo(t, h, "user1", "s1", []byte("shared audio"))
+	other := uploadTo(t, h, "user1", "s2", []byte("private audio"))
+
+	status, id, token := createShare(t, h, "/sessions/user1/s1/share", "")
+	if status != http.StatusCreated || token == "" {
+		t.Fatalf("Expected a share link, but got %v", status)
+	}
+
+	status, body := sharedGet(t, h, token, "/chunks")
+	var listed []Metadata
+	json.Unmarshal(body, &listed)
+	if status != http.StatusOK || len(listed) != 1 || listed[0].ChunkID != shared.ChunkID {
+		t.Errorf("Expected the session listing, but got %v %s", status, body)
+	}
+	if status, body := sharedGet(t, h, token, "/chunks/"+shared.ChunkID); status != http.StatusOK || !strings.Contains(string(body), shared.ChunkID) {
+		t.Errorf("Expected chunk metadata, but got %v %s", status, body)
+	}
+	if status, body := sharedGet(t, h, token, "/chunks/"+shared.ChunkID+"/audio"); status != http.StatusOK || string(body) != "shared audio" {
+		t.Errorf("Expected the chunk audio, but got %v %q", status, body)
+	}
+
+	for _, path := range []string{"/chunks/" + other.ChunkID, "/chunks/" + other.ChunkID + "/audio"} {
+		if status, _ := sharedGet(t, h, token, path); status != http.StatusNotFound {
+			t.Errorf("%s: expected another session to be out of scope, but got %v", path, status)
+		}
+	}
+	forged := strings.Replace(token, token[:4], "AAAA", 1)
+	if status, _ := sharedGet(t, h, forged, "/chunks"); status != http.StatusNotFound {
+		t.Errorf("Expected a tampered token to be refused, but got %v", status)
+	}
+
+	events := h.Store.AuditEvents(func(e AuditEvent) bool { return e.Actor == "share:"+id })
+	if len(events) != 3 || events[0].Action != "shared.list" || events[2].ChunkID != shared.ChunkID {
+		t.Errorf("Expected three audited accesses by the token, but got %+v", events)
+	}
+
+	if status, _, _ := createShare(t, h, "/sessions/user1/missing/share", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 sharing an empty session, but got %v", status)
+	}
+	if status, _, _ := createShare(t, h, "/sessions/user1/s1/share", `{"expires_in_seconds": 99999999}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a too-long expiry to be refused, but got %v", status)
+	}
+}
+
+func TestShareLinkExpiry(t *testing.T) {
+	h := NewHarness(shareConfig())
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Shares.clock = clock
+	uploadTo(t, h, "user1", "s1", []byte("audio"))
+
+	_, _, token := createShare(t, h, "/sessions/user1/s1/share", `{"expires_in_seconds": 60}`)
+	if status, _ := sharedGet(t, h, token, "/chunks"); status != http.StatusOK {
+		t.Fatalf("Expected a fresh link to work, but got %v", status)
+	}
+	clock.Advance(time.Minute)
+	if status, _ := sharedGet(t, h, token, "/chunks"); status != http.StatusGone {
+		t.Errorf("Expected an expired link to be gone, but got %v", status)
+	}
+}
+
+func TestShareLinkRevocationPersists(t *testing.T) {
+	cfg := shareConfig()
+	h := NewHarness(cfg)
+	uploadTo(t, h, "user1", "s1", []byte("audio"))
+	_, id, token := createShare(t, h, "/sessions/user1/s1/share", "")
+	_, _, kept := createShare(t, h, "/sessions/user1/s1/share", "")
+
+	// A link can only be revoked through the session it shares.
+	for _, path := range []string{"/sessions/user2/s1/share/", "/sessions/user1/s2/share/"} {
+		req, _ := http.NewRequest("DELETE", h.URL+path+id, nil)
+		req.Header.Set("X-Admin-Token", "admin")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: expected 404, but got %v", path, resp.StatusCode)
+		}
+	}
+	if status, _ := sharedGet(t, h, token, "/chunks"); status != http.StatusOK {
+		t.Fatalf("Expected the link to survive revocation through another session, but got %v", status)
+	}
+
+	req, _ := http.NewRequest("DELETE", h.URL+"/sessions/user1/s1/share/"+id, nil)
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected the link to be revoked, but got %v, %v", resp, err)
+	}
+	resp.Body.Close()
+	h.Close()
+
+	h = NewHarnessWithStore(cfg, h.Store)
+	defer h.Close()
+	if status, _ := sharedGet(t, h, token, "/chunks"); status != http.StatusGone {
+		t.Errorf("Expected the revocation to survive a restart, but got %v", status)
+	}
+	if status, _ := sharedGet(t, h, kept, "/chunks"); status != http.StatusOK {
+		t.Errorf("Expected other links to keep working, but got %v", status)
+	}
+}
+
+func TestShareLinkDownloadLimit(t *testing.T) {
+	h := NewHarness(shareConfig())
+	defer h.Close()
+	chunk := uploadTo(t, h, "user1", "s1", []byte("shared audio"))
+	audio := "/chunks/" + chunk.ChunkID + "/audio"
+
+	_, _, limited := createShare(t, h, "/sessions/user1/s1/share", `{"max_downloads": 2}`)
+	for i := range 2 {
+		if status, _ := sharedGet(t, h, limited, audio); status != http.StatusOK {
+			t.Fatalf("download %d: expected 200, but got %v", i+1, status)
+		}
+	}
+	for _, path := range []string{audio, "/chunks"} {
+		if status, _ := sharedGet(t, h, limited, path); status != http.StatusGone {
+			t.Errorf("%s: expected an exhausted link to be gone, but got %v", path, status)
+		}
+	}
+
+	_, _, once := createShare(t, h, "/sessions/user1/s1/share", `{"single_use": true}`)
+	sharedGet(t, h, once, "/chunks")
+	if status, _ := sharedGet(t, h, once, audio); status != http.StatusOK {
+		t.Errorf("Expected listing not to use up a single-use link, but got %v", status)
+	}
+	if status, _ := sharedGet(t, h, once, audio); status != http.StatusGone {
+		t.Errorf("Expected a single-use link to work once, but got %v", status)
+	}
+
+	for _, body := range []string{`{"max_downloads": -1}`, `{"single_use": true, "max_downloads": 3}`} {
+		if status, _, _ := createShare(t, h, "/sessions/user1/s1/share", body); status != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422, but got %v", body, status)
+		}
+	}
+}
+
+func TestShareStateLoggedAndPruned(t *testing.T) {
+	cfg := walConfig(t.TempDir())
+	cfg.WALCompactEvery = 3
+	store := openWALStore(t, cfg)
+	now := time.Now()
+	limited := ShareLink{ID: "limited", MaxDownloads: 2, ExpiresAt: now.Add(time.Hour)}
+	unlimited := ShareLink{ID: "unlimited", ExpiresAt: now.Add(time.Hour)}
+	store.ClaimShareDownload(limited)
+	store.ClaimShareDownload(unlimited)
+	store.AddShare(ShareLink{ID: "revoked", UserID: "user1", SessionID: "s1", ExpiresAt: now.Add(2 * time.Hour)})
+	store.RevokeShare("revoked", "user1", "s1")
+	store.ClaimShareDownload(limited)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openWALStore(t, cfg)
+	defer store.Close()
+	if store.ClaimShareDownload(limited) || !store.ShareRevoked("revoked") {
+		t.Errorf("Expected the count and the revocation to survive a restart, but got %+v", store.shares)
+	}
+	if _, counted := store.shares["unlimited"]; counted {
+		t.Errorf("Expected a link without a limit not to be counted")
+	}
+	if n := store.PruneShares(now.Add(time.Hour)); n != 1 || store.ShareDownloads("limited") != 0 || !store.ShareRevoked("revoked") {
+		t.Errorf("Expected only the expired link pruned, but got %d pruned and %+v", n, store.shares)
+	}
+	if n := store.PruneShares(now.Add(2 * time.Hour)); n != 1 || len(store.shares) != 0 {
+		t.Errorf("Expected the revocation pruned at its expiry, but got %d pruned and %+v", n, store.shares)
+	}
+}
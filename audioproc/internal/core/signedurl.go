@@ -0,0 +1,168 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	errSignatureInvalid = newKindError(ErrForbidden, "signature does not match")
+	errSignatureExpired = newKindError(ErrForbidden, "signed URL has expired")
+)
+
+// Presigner hands out URLs that the blob backend serves itself, such as S3
+// presigned GETs, so the audio does not pass through this service.
+type Presigner interface {
+	Presign(ctx context.Context, chunk Metadata, ttl time.Duration) (string, error)
+}
+
+// SignedURL is a time-limited link to a chunk's audio that needs no API
+// key. Direct is set when the URL points at the blob backend rather than
+// at GET /chunks/{id}/audio.
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// IP is the client address the URL is bound to, if any.
+	IP     string `json:"ip,omitempty"`
+	Direct bool   `json:"direct,omitempty"`
+}
+
+// URLSigner signs audio download URLs with the share links' secret. The
+// signature covers the path, the expiry and the bound IP, so none of them
+// can be changed without invalidating it.
+type URLSigner struct {
+	store  *MemoryStore
+	secret func(string) string
+	clock  Clock
+	ttl    time.Duration
+	maxTTL time.Duration
+	// Presigner, when set, is preferred over signing our own URLs.
+	Presigner Presigner
+}
+
+// NewURLSigner signs with the secret of shares.
+func NewURLSigner(cfg Config, store *MemoryStore, shares *ShareLinks) *URLSigner {
+	return &URLSigner{store: store, secret: shares.sign, clock: realClock{}, ttl: cfg.SignedURLTTL, maxTTL: cfg.SignedURLMaxTTL}
+}
+
+func (s *URLSigner) signature(path string, expires int64, ip string) string {
+	return s.secret("audio:" + path + "|" + strconv.FormatInt(expires, 10) + "|" + ip)
+}
+
+// Sign returns a URL, relative to base, for chunk's audio that works until
+// ttl has passed and, if ip is set, only from that address.
+func (s *URLSigner) Sign(ctx context.Context, base string, chunk Metadata, ttl time.Duration, ip string) SignedURL {
+	expires := s.clock.Now().Add(ttl).UTC().Truncate(time.Second)
+	if s.Presigner != nil {
+		u, err := s.Presigner.Presign(ctx, chunk, ttl)
+		if err == nil {
+			return SignedURL{URL: u, ExpiresAt: expires, Direct: true}
+		}
+		log.Printf("Presigning chunk %s failed, signing an API URL instead: %v", chunk.ChunkID, err)
+	}
+	path := "/chunks/" + chunk.ChunkID + "/audio"
+	q := url.Values{"expires": {strconv.FormatInt(expires.Unix(), 10)}}
+	if ip != "" {
+		q.Set("ip", ip)
+	}
+	q.Set("sig", s.signature(path, expires.Unix(), ip))
+	return SignedURL{URL: base + path + "?" + q.Encode(), ExpiresAt: expires, IP: ip}
+}
+
+// Verify checks the signature on r, made for the client at ip.
+func (s *URLSigner) Verify(r *http.Request, ip string) error {
+	q := r.URL.Query()
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(q.Get("sig")), []byte(s.signature(r.URL.Path, expires, q.Get("ip")))) {
+		return errSignatureInvalid
+	}
+	if bound := q.Get("ip"); bound != "" && bound != ip {
+		return errSignatureInvalid
+	}
+	if !s.clock.Now().Before(time.Unix(expires, 0)) {
+		return errSignatureExpired
+	}
+	return nil
+}
+
+// isSignedAudioRequest reports whether r downloads audio with a signed URL,
+// which stands in for the API key.
+func isSignedAudioRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/chunks/") && strings.HasSuffix(r.URL.Path, "/audio") && r.URL.Query().Has("sig")
+}
+
+// signedAudio serves signed downloads itself and leaves the rest to next.
+func (s *URLSigner) signedAudio(tc *Transcoder, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isSignedAudioRequest(r) {
+			next(w, r)
+			return
+		}
+		if err := s.Verify(r, clientIPFrom(r.Context())); err != nil {
+			writeError(w, err)
+			return
+		}
+		meta, err := s.store.Get(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		s.store.RecordAudit(AuditEvent{Actor: "signed_url", Action: "signed_url.read", UserID: meta.UserID, SessionID: meta.SessionID, ChunkID: meta.ChunkID})
+		writeChunkAudio(w, r, s.store, tc, meta)
+	}
+}
+
+type signedURLRequest struct {
+	ExpiresInSeconds int64 `json:"expires_in_seconds,omitempty"`
+	// BindIP limits the URL to the address of the client asking for it.
+	BindIP bool `json:"bind_ip,omitempty"`
+}
+
+func handleCreateSignedURL(s *URLSigner, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req signedURLRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid_signed_url", "body must be {\"expires_in_seconds\": n, \"bind_ip\": bool}")
+				return
+			}
+		}
+		ttl := s.ttl
+		if req.ExpiresInSeconds != 0 {
+			ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+		}
+		if ttl <= 0 || ttl > s.maxTTL {
+			writeError(w, invalidField("expires_in_seconds", "invalid_signed_url", fmt.Sprintf("must be 1 to %d", int64(s.maxTTL/time.Second))))
+			return
+		}
+		id := mux.Vars(r)["id"]
+		meta, err := s.store.Get(id)
+		if err != nil {
+			writeChunkError(w, s.store, id, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, meta.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		var ip string
+		if req.BindIP {
+			ip = clientIPFrom(r.Context())
+		}
+		signed := s.Sign(r.Context(), cfg.PublicURL, meta, ttl, ip)
+		s.store.RecordAudit(AuditEvent{Actor: requestActor(r), Action: "signed_url.create", UserID: meta.UserID, SessionID: meta.SessionID, ChunkID: meta.ChunkID})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(signed)
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createSignedURL(t *testing.T, h *Harness, id, body string) (int, SignedURL) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", h.URL+"/chunks/"+id+"/signed-url", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer key1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var signed SignedURL
+	json.NewDecoder(resp.Body).Decode(&signed)
+	return resp.StatusCode, signed
+}
+
+func getSigned(t *testing.T, h *Harness, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(h.URL + url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestSignedAudioURL(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIKeys = map[string]string{"key1": "user1"}
+	h := NewHarness(cfg)
+	defer h.Close()
+	clock := NewFakeClock(time.Now())
+	h.Signer.clock = clock
+	h.Store.Save(Metadata{ChunkID: "chunk1", UserID: "user1", SessionID: "s1"})
+	h.Store.SaveBlob("chunk1", []byte("audio bytes"))
+
+	if status, _ := getSigned(t, h, "/chunks/chunk1/audio"); status != http.StatusUnauthorized {
+		t.Fatalf("Expected plain downloads to need a key, but got %v", status)
+	}
+	status, signed := createSignedURL(t, h, "chunk1", `{"expires_in_seconds": 60, "bind_ip": true}`)
+	if status != http.StatusCreated || signed.IP != "127.0.0.1" || signed.Direct {
+		t.Fatalf("Expected an IP-bound URL, but got %v %+v", status, signed)
+	}
+	if status, body := getSigned(t, h, signed.URL); status != http.StatusOK || body != "audio bytes" {
+		t.Errorf("Expected the signed URL to serve the audio, but got %v %q", status, body)
+	}
+
+	for _, tampered := range []string{
+		strings.Replace(signed.URL, "chunk1", "chunk2", 1),
+		strings.Replace(signed.URL, "ip=127.0.0.1", "ip=10.0.0.1", 1),
+		strings.Replace(signed.URL, "sig=", "sig=x", 1),
+		strings.Replace(signed.URL, "expires=", "expires=9", 1),
+	} {
+		if status, _ := getSigned(t, h, tampered); status != http.StatusForbidden {
+			t.Errorf("%s: expected 403, but got %v", tampered, status)
+		}
+	}
+	req := httptest.NewRequest("GET", signed.URL, nil)
+	if err := h.Signer.Verify(req, "10.0.0.2"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("Expected another client's address to be refused, but got %v", err)
+	}
+
+	clock.Advance(time.Minute)
+	if status, _ := getSigned(t, h, signed.URL); status != http.StatusForbidden {
+		t.Errorf("Expected 403 once expired, but got %v", status)
+	}
+	if status, _ := createSignedURL(t, h, "chunk1", `{"expires_in_seconds": 999999}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 beyond the maximum lifetime, but got %v", status)
+	}
+
+	events := h.Store.AuditEvents(func(e AuditEvent) bool { return e.ChunkID == "chunk1" })
+	if len(events) != 2 || events[0].Action != "signed_url.create" || events[0].Actor != "user1" || events[1].Action != "signed_url.read" {
+		t.Errorf("Expected the issuance and the download audited, but got %+v", events)
+	}
+}
+
+// fakePresigner stands in for an S3 backend's request signer.
+type fakePresigner struct{ err error }
+
+func (p fakePresigner) Presign(ctx context.Context, chunk Metadata, ttl time.Duration) (string, error) {
+	return "https://bucket.s3.example/" + chunk.ChunkID + "?X-Amz-Expires=" + ttl.String(), p.err
+}
+
+func TestSignedAudioURLPresigned(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	h.Store.Save(Metadata{ChunkID: "chunk1", UserID: "user1"})
+
+	h.Signer.Presigner = fakePresigner{}
+	status, signed := createSignedURL(t, h, "chunk1", "")
+	if status != http.StatusCreated || !signed.Direct || signed.URL != "https://bucket.s3.example/chunk1?X-Amz-Expires=15m0s" {
+		t.Errorf("Expected the presigned backend URL, but got %v %+v", status, signed)
+	}
+
+	h.Signer.Presigner = fakePresigner{err: errors.New("no credentials")}
+	if _, signed := createSignedURL(t, h, "chunk1", ""); signed.Direct || !strings.HasPrefix(signed.URL, "/chunks/chunk1/audio?") {
+		t.Errorf("Expected a failed presign to fall back to an API URL, but got %+v", signed)
+	}
+}
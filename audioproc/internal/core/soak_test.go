@@ -0,0 +1,116 @@
+//go:build soak
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestSoakGoroutines runs 10,000 mixed requests, streaming ones included,
+// and checks the server ends up tracking exactly the goroutines it started
+// with. Run it with: go test -tags soak -run Soak
+func TestSoakGoroutines(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WSIdleTimeout = time.Second
+	h := NewHarness(cfg)
+	defer h.Close()
+	// Sweepers that are disabled or run once exit shortly after start.
+	baseline := h.Goroutines.Counts()
+	for {
+		time.Sleep(50 * time.Millisecond)
+		counts := h.Goroutines.Counts()
+		if fmt.Sprint(counts) == fmt.Sprint(baseline) {
+			break
+		}
+		baseline = counts
+	}
+
+	const requests, clients = 10000, 32
+	var wg sync.WaitGroup
+	work := make(chan int)
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				if err := soakRequest(h, i); err != nil {
+					t.Errorf("Request %d: %v", i, err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < requests; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		counts := h.Goroutines.Counts()
+		if fmt.Sprint(counts) == fmt.Sprint(baseline) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected to track %v after the soak, but have %v", baseline, counts)
+		}
+	}
+}
+
+func soakRequest(h *Harness, i int) error {
+	user, session := fmt.Sprintf("user%d", i%7), fmt.Sprintf("s%d", i%31)
+	get := func(path string) error {
+		resp, err := http.Get(h.URL + path)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+	switch i % 8 {
+	case 0, 1, 2:
+		resp, err := http.Post(h.URL+"/upload?user_id="+user+"&session_id="+session, "application/octet-stream", bytes.NewReader([]byte("audio")))
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		return resp.Body.Close()
+	case 3:
+		return get("/sessions/" + user)
+	case 4:
+		return get("/chunks/missing")
+	case 5:
+		// A subscriber that hangs up straight away.
+		resp, err := http.Get(h.URL + "/events")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	case 6:
+		conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id="+user+"&session_id="+session), nil)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte("audio")); err != nil {
+			return err
+		}
+		_, _, err = conn.ReadMessage()
+		return err
+	default:
+		// A WebSocket dropped without a close frame.
+		conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id="+user+"&session_id="+session), nil)
+		if err != nil {
+			return err
+		}
+		return conn.NetConn().Close()
+	}
+}
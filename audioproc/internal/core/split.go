@@ -0,0 +1,162 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Splitting looks for the quietest splitFrame in the last quarter of each
+// piece and cuts there if it is below splitSilence; otherwise it cuts at
+// the limit.
+const (
+	splitFrame   = 20 * time.Millisecond
+	splitSilence = 0.01
+)
+
+// splitPoints returns the sample offsets at which to cut n samples into
+// pieces of at most limit samples.
+func splitPoints(samples []float64, rate int, limit int) []int {
+	frame := max(1, int(splitFrame.Seconds()*float64(rate)))
+	var cuts []int
+	for start := 0; len(samples)-start > limit; {
+		end := start + limit
+		cut, quietest := end, math.Inf(1)
+		for f := end - frame; f >= start+limit*3/4; f -= frame {
+			if rms := frameRMS(samples[f : f+frame]); rms < quietest {
+				cut, quietest = f+frame/2, rms
+			}
+		}
+		if quietest > splitSilence {
+			cut = end
+		}
+		cuts = append(cuts, cut)
+		start = cut
+	}
+	return cuts
+}
+
+func frameRMS(samples []float64) float64 {
+	var sum float64
+	for _, s := range samples {
+		sum += s * s
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// splitChunk cuts decodable audio longer than limit into child chunks, preferring
+// silent points, and returns nil if chunk needs no splitting. Children get
+// new IDs, link back to chunk through ParentChunkID, and are re-encoded as
+// mono 16-bit WAV.
+func splitChunk(chunk AudioChunk, limit time.Duration) []AudioChunk {
+	if limit <= 0 {
+		return nil
+	}
+	pcm, err := decodeAudio(chunk.Data)
+	if err != nil {
+		return nil
+	}
+	maxSamples := int(limit.Seconds() * float64(pcm.SampleRate))
+	if maxSamples <= 0 || len(pcm.Samples) <= maxSamples {
+		return nil
+	}
+	bounds := append(append([]int{0}, splitPoints(pcm.Samples, pcm.SampleRate, maxSamples)...), len(pcm.Samples))
+	markers := chunk.Markers
+	if markers == nil {
+		markers = readMarkers(chunk.Data)
+	}
+	children := make([]AudioChunk, len(bounds)-1)
+	for i := range children {
+		from, to := bounds[i], bounds[i+1]
+		samples := make([]int16, to-from)
+		for j, s := range pcm.Samples[from:to] {
+			samples[j] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, s*32768)))
+		}
+		child := chunk
+		child.ChunkID = uuid.New().String()
+		child.ParentChunkID = chunk.ChunkID
+		child.OffsetMS = int64(from) * 1000 / int64(pcm.SampleRate)
+		child.Markers = markersBetween(markers, child.OffsetMS, int64(to)*1000/int64(pcm.SampleRate))
+		child.Data = EncodeWAV(samples, pcm.SampleRate)
+		child.Checksum = ""
+		children[i] = child
+	}
+	return children
+}
+
+// splitUpload is the upload response for audio that was split.
+type splitUpload struct {
+	ParentChunkID string     `json:"parent_chunk_id"`
+	Chunks        []Metadata `json:"chunks"`
+}
+
+// ingestSplit ingests the pieces of a split upload in order, stopping at
+// the first failure.
+func ingestSplit(ctx context.Context, store Store, jobs chan<- Job, sessions *SessionTracker, children []AudioChunk) ([]Metadata, error) {
+	metas := make([]Metadata, 0, len(children))
+	for _, child := range children {
+		meta, err := ingest(ctx, store, jobs, sessions, AudioRules{}, child)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %s at %dms: %w", child.ChunkID, child.OffsetMS, err)
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// collapsedChunk is a session listing entry. Chunks split on upload are
+// shown as one entry for the parent, with the pieces in Children.
+type collapsedChunk struct {
+	Metadata
+	Children []Metadata `json:"children,omitempty"`
+}
+
+// collapseChildren groups split chunks under their parent, in the order the
+// first piece of each parent appears.
+func collapseChildren(chunks []Metadata) []collapsedChunk {
+	var result []collapsedChunk
+	parents := make(map[string]int)
+	for _, m := range chunks {
+		if m.ParentChunkID == "" {
+			result = append(result, collapsedChunk{Metadata: m})
+			continue
+		}
+		i, ok := parents[m.ParentChunkID]
+		if !ok {
+			i = len(result)
+			parents[m.ParentChunkID] = i
+			result = append(result, collapsedChunk{Metadata: Metadata{
+				ChunkID:   m.ParentChunkID,
+				UserID:    m.UserID,
+				SessionID: m.SessionID,
+				Timestamp: m.Timestamp,
+				Status:    "processed",
+			}})
+		}
+		result[i].Children = append(result[i].Children, m)
+	}
+	for i := range result {
+		p := &result[i]
+		if len(p.Children) == 0 {
+			continue
+		}
+		sort.Slice(p.Children, func(a, b int) bool { return p.Children[a].OffsetMS < p.Children[b].OffsetMS })
+		var texts []string
+		for _, c := range p.Children {
+			p.DurationMS += c.DurationMS
+			if c.Transcript != "" {
+				texts = append(texts, c.Transcript)
+			}
+			if c.Status == "failed" {
+				p.Status = "failed"
+			}
+		}
+		p.Transcript = strings.Join(texts, " ")
+	}
+	return result
+}
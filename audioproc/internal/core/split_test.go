@@ -0,0 +1,108 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// toneWithGaps synthesizes 8kHz audio alternating tone and silence; the
+// durations are given in milliseconds and start with a tone.
+func toneWithGaps(ms ...int) []byte {
+	const rate = 8000
+	var samples []int16
+	for i, d := range ms {
+		for j := 0; j < d*rate/1000; j++ {
+			var v float64
+			if i%2 == 0 {
+				v = 0.5 * math.Sin(2*math.Pi*440*float64(len(samples))/rate)
+			}
+			samples = append(samples, int16(v*math.MaxInt16))
+		}
+	}
+	return EncodeWAV(samples, rate)
+}
+
+func TestSplitChunkCutsAtSilence(t *testing.T) {
+	chunk := AudioChunk{ChunkID: "parent", Data: toneWithGaps(8000, 500, 8000, 500, 5000)}
+	children := splitChunk(chunk, 10*time.Second)
+	if len(children) != 3 {
+		t.Fatalf("Expected 3 pieces, but got %d", len(children))
+	}
+	for i, want := range []int64{0, 8250, 16750} {
+		if got := children[i].OffsetMS; math.Abs(float64(got-want)) > 250 {
+			t.Errorf("Expected piece %d to start near %dms, but got %dms", i, want, got)
+		}
+		if children[i].ParentChunkID != "parent" || children[i].ChunkID == "parent" {
+			t.Errorf("Expected piece %d to link to its parent, but got %+v", i, children[i])
+		}
+	}
+}
+
+func TestSplitChunkHardCutsWithoutSilence(t *testing.T) {
+	children := splitChunk(AudioChunk{Data: SineWAV(440, 25*time.Second, 8000)}, 10*time.Second)
+	if len(children) != 3 || children[1].OffsetMS != 10000 || children[2].OffsetMS != 20000 {
+		t.Fatalf("Expected cuts at the limit, but got %d pieces", len(children))
+	}
+	if splitChunk(AudioChunk{Data: SineWAV(440, 5*time.Second, 8000)}, 10*time.Second) != nil {
+		t.Errorf("Expected short audio to stay whole")
+	}
+}
+
+func TestUploadSplitsLongAudio(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxChunkDuration = 10 * time.Second
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	resp, err := http.Post(h.URL+"/upload?user_id=long&session_id=s", "audio/wav", bytes.NewReader(toneWithGaps(8000, 500, 8000, 500, 5000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var split splitUpload
+	if err := json.NewDecoder(resp.Body).Decode(&split); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || split.ParentChunkID == "" || len(split.Chunks) != 3 {
+		t.Fatalf("Expected 3 pieces of one parent, but got %v %+v", resp.StatusCode, split)
+	}
+	var total int64
+	for _, m := range split.Chunks {
+		if m.ParentChunkID != split.ParentChunkID || m.DurationMS == 0 {
+			t.Errorf("Expected each piece processed under the parent, but got %+v", m)
+		}
+		total += m.DurationMS
+	}
+	uploadTo(t, h, "long", "s", SineWAV(440, time.Second, 8000))
+
+	resp, err = http.Get(h.URL + "/sessions/long?collapse=children")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var listed []collapsedChunk
+	json.NewDecoder(resp.Body).Decode(&listed)
+	if len(listed) != 2 {
+		t.Fatalf("Expected the parent and the short chunk, but got %+v", listed)
+	}
+	parent := listed[0]
+	if len(parent.Children) == 0 {
+		parent = listed[1]
+	}
+	if parent.ChunkID != split.ParentChunkID || len(parent.Children) != 3 || parent.DurationMS != total {
+		t.Errorf("Expected the pieces collapsed under the parent, but got %+v", parent)
+	}
+
+	resp, err = http.Get(h.URL + "/sessions/long?collapse=children&fields=chunk_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected collapse with fields to be rejected, but got %v", resp.StatusCode)
+	}
+}
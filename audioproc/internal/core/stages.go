@@ -0,0 +1,177 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Pipeline stages that can be switched at runtime.
+const (
+	stageTranscription = "transcription"
+	stageFingerprint   = "fingerprint"
+)
+
+// Stage modes. A disabled stage is skipped; a stubbed one runs a stand-in
+// whose output is marked as skipped all the same, so it can be backfilled.
+const (
+	stageEnabled  = "enabled"
+	stageDisabled = "disabled"
+	stageStub     = "stub"
+)
+
+var (
+	stageModes = expvar.NewMap("pipeline_stage_modes")
+	stageSkips = expvar.NewMap("pipeline_stage_skips")
+
+	errUnknownStage = newKindError(ErrNotFound, "unknown pipeline stage")
+)
+
+// StageState is a stage's mode and, unless enabled, why and since when.
+type StageState struct {
+	Mode   string    `json:"mode"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since,omitempty"`
+}
+
+// StageControl holds the runtime mode of each switchable stage. Workers
+// read it per chunk without locking, so a change applies to the next chunk
+// processed.
+type StageControl struct {
+	stages map[string]*atomic.Pointer[StageState]
+	// stubs lists the stages that have a stand-in.
+	stubs map[string]bool
+}
+
+// NewStageControl returns a StageControl with every stage on.
+func NewStageControl() *StageControl {
+	c := &StageControl{
+		stages: make(map[string]*atomic.Pointer[StageState]),
+		stubs:  map[string]bool{stageTranscription: true},
+	}
+	for _, name := range []string{stageTranscription, stageFingerprint} {
+		c.stages[name] = new(atomic.Pointer[StageState])
+		c.stages[name].Store(&StageState{Mode: stageEnabled})
+		stageModes.Set(name, modeVar(stageEnabled))
+		stageSkips.Add(name, 0)
+	}
+	return c
+}
+
+func modeVar(mode string) *expvar.String {
+	v := new(expvar.String)
+	v.Set(mode)
+	return v
+}
+
+// Mode returns a stage's state. A nil StageControl has every stage enabled.
+func (c *StageControl) Mode(name string) StageState {
+	if c == nil || c.stages[name] == nil {
+		return StageState{Mode: stageEnabled}
+	}
+	return *c.stages[name].Load()
+}
+
+// skip returns a stage's mode and, unless it is enabled, a reason to
+// record on the chunk, counting the skip.
+func (c *StageControl) skip(name string) (mode, reason string) {
+	st := c.Mode(name)
+	if st.Mode == stageEnabled {
+		return st.Mode, ""
+	}
+	stageSkips.Add(name, 1)
+	reason = name + " is disabled"
+	if st.Mode == stageStub {
+		reason = name + " is stubbed"
+	}
+	if st.Reason != "" {
+		reason += ": " + st.Reason
+	}
+	return st.Mode, reason
+}
+
+// markSkipped records that stage did not run normally on m.
+func (m *Metadata) markSkipped(stage, reason string) {
+	if m.Skipped == nil {
+		m.Skipped = make(map[string]string)
+	}
+	m.Skipped[stage] = reason
+}
+
+// Set switches a stage to mode.
+func (c *StageControl) Set(name, mode, reason string) (StageState, error) {
+	p := c.stages[name]
+	if p == nil {
+		return StageState{}, errUnknownStage
+	}
+	switch {
+	case mode == stageStub && !c.stubs[name]:
+		return StageState{}, invalidField("mode", "invalid_mode", name+" has no stub")
+	case mode != stageEnabled && mode != stageDisabled && mode != stageStub:
+		return StageState{}, invalidField("mode", "invalid_mode", "mode must be enabled, disabled or stub")
+	}
+	st := StageState{Mode: mode}
+	if mode != stageEnabled {
+		st.Reason = reason
+		st.Since = time.Now().UTC()
+	}
+	p.Store(&st)
+	stageModes.Set(name, modeVar(mode))
+	return st, nil
+}
+
+// States returns every stage's state by name.
+func (c *StageControl) States() map[string]StageState {
+	states := make(map[string]StageState, len(c.stages))
+	for name := range c.stages {
+		states[name] = c.Mode(name)
+	}
+	return states
+}
+
+// stageRequest switches a stage: mode wins over enabled when both are set.
+type stageRequest struct {
+	Enabled *bool  `json:"enabled,omitempty"`
+	Mode    string `json:"mode,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+func handleSetStage(stages *StageControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req stageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_stage_body", err.Error())
+			return
+		}
+		mode := req.Mode
+		if mode == "" && req.Enabled != nil {
+			mode = stageDisabled
+			if *req.Enabled {
+				mode = stageEnabled
+			}
+		}
+		name := mux.Vars(r)["name"]
+		st, err := stages.Set(name, mode, req.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		log.Printf("Pipeline stage %s is now %s (%s)", name, st.Mode, requestActor(r))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+	}
+}
+
+func handleListStages(stages *StageControl) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stages.States())
+	}
+}
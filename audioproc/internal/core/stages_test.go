@@ -0,0 +1,116 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setStage(t *testing.T, h *Harness, name, body string) (int, StageState) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", h.URL+"/admin/stages/"+name, strings.NewReader(body))
+	req.Header.Set("X-Admin-Token", "admin")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	var st StageState
+	json.NewDecoder(resp.Body).Decode(&st)
+	return resp.StatusCode, st
+}
+
+func readyStages(t *testing.T, h *Harness) map[string]StageState {
+	t.Helper()
+	resp, err := http.Get(h.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	var ready struct {
+		Stages map[string]StageState `json:"stages"`
+	}
+	json.NewDecoder(resp.Body).Decode(&ready)
+	return ready.Stages
+}
+
+func TestDisabledTranscriptionIsBackfilled(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	transcriber := &countingTranscriber{}
+	h.Pipeline.Transcriber = transcriber
+	wav := SineWAV(440, time.Second, 8000)
+
+	status, st := setStage(t, h, "transcription", `{"enabled": false, "reason": "provider outage"}`)
+	if status != http.StatusOK || st.Mode != stageDisabled || st.Reason != "provider outage" {
+		t.Fatalf("Expected transcription to be disabled, but got %v %+v", status, st)
+	}
+	if got := readyStages(t, h)[stageTranscription]; got.Mode != stageDisabled {
+		t.Errorf("Expected /readyz to report the stage disabled, but got %+v", got)
+	}
+	skips := stageSkips.Get(stageTranscription).String()
+
+	skipped := uploadTo(t, h, "user1", "s1", wav)
+	if skipped.Transcript != "" || skipped.Skipped[stageTranscription] != "transcription is disabled: provider outage" || skipped.TranscriptSkipReason == "" {
+		t.Errorf("Expected the chunk marked as skipped, but got %+v", skipped)
+	}
+	if skipped.Fingerprint == "" {
+		t.Errorf("Expected the other stages to keep running")
+	}
+	if transcriber.calls.Load() != 0 {
+		t.Errorf("Expected the transcriber not to be called")
+	}
+	if stageSkips.Get(stageTranscription).String() == skips {
+		t.Errorf("Expected the skip to be counted")
+	}
+
+	setStage(t, h, "transcription", `{"enabled": true}`)
+	processed := uploadTo(t, h, "user1", "s1", wav)
+	if processed.Transcript != "speech" || processed.Skipped != nil {
+		t.Errorf("Expected transcription back on, but got %+v", processed)
+	}
+
+	job := h.Reprocessor.Start(ReprocessFilter{SkippedOnly: true})
+	if done := waitForState(t, h.Reprocessor, job.ID, jobDone); done.Total != 1 || done.Processed != 1 {
+		t.Errorf("Expected only the skipped chunk to be reprocessed, but got %+v", done)
+	}
+	backfilled, _ := h.Store.Get(skipped.ChunkID)
+	if backfilled.Transcript != "speech" || backfilled.Skipped != nil || backfilled.TranscriptSkipReason != "" {
+		t.Errorf("Expected the chunk to be backfilled, but got %+v", backfilled)
+	}
+}
+
+func TestStubbedAndDisabledStages(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	h := NewHarness(cfg)
+	defer h.Close()
+	h.Pipeline.Transcriber = &countingTranscriber{}
+	wav := SineWAV(440, time.Second, 8000)
+
+	setStage(t, h, "transcription", `{"mode": "stub"}`)
+	setStage(t, h, "fingerprint", `{"enabled": false}`)
+	meta := uploadTo(t, h, "user1", "s1", wav)
+	if meta.Transcript != "Hello World" || meta.Skipped[stageTranscription] != "transcription is stubbed" {
+		t.Errorf("Expected a stub transcript marked as skipped, but got %+v", meta)
+	}
+	if meta.Fingerprint != "" || meta.Skipped[stageFingerprint] == "" {
+		t.Errorf("Expected the fingerprint to be skipped, but got %+v", meta)
+	}
+
+	for name, body := range map[string]string{
+		"fingerprint":   `{"mode": "stub"}`,
+		"transcription": `{"mode": "sometimes"}`,
+	} {
+		if status, _ := setStage(t, h, name, body); status != http.StatusUnprocessableEntity {
+			t.Errorf("%s %s: expected 422, but got %v", name, body, status)
+		}
+	}
+	if status, _ := setStage(t, h, "mixing", `{"enabled": false}`); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown stage, but got %v", status)
+	}
+}
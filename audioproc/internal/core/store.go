@@ -0,0 +1,24 @@
+package core
+
+// Store persists chunk metadata and audio. Implementations return the
+// exported error kinds (ErrNotFound, ErrConflict, ErrBackendUnavailable, ...)
+// so handlers can translate them with writeError.
+type Store interface {
+	ChunkReader
+	Save(meta Metadata) error
+	Delete(id string) error
+	Restore(id string) (Metadata, error)
+	ListByUser(userID string) []Metadata
+	List(match func(Metadata) bool) []Metadata
+	SaveBlob(id string, data []byte) error
+	GetBlob(id string) ([]byte, error)
+	// Changes pages through the metadata change feed. Durable backends
+	// should keep cursors valid across restarts.
+	Changes(since int64, limit int) ChangePage
+	// UserSettings returns a user's stored overrides, which are empty for
+	// users who never saved any.
+	UserSettings(userID string) (UserSettings, error)
+	SaveUserSettings(userID string, settings UserSettings) error
+}
+
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// wsStreamFormat declares, in a hello, that the client will stream raw
+// 16-bit little-endian PCM in this format rather than send whole chunks.
+type wsStreamFormat struct {
+	SampleRate int `json:"sample_rate"`
+	Channels   int `json:"channels"`
+}
+
+func (f wsStreamFormat) validate() error {
+	if f.SampleRate < 8000 || f.SampleRate > 192000 {
+		return invalidField("stream.sample_rate", "invalid_stream", "sample_rate must be between 8000 and 192000")
+	}
+	if f.Channels < 1 || f.Channels > 8 {
+		return invalidField("stream.channels", "invalid_stream", "channels must be between 1 and 8")
+	}
+	return nil
+}
+
+// wsStream accumulates a streaming connection's PCM and cuts it into WAV
+// chunks of chunkBytes, a whole number of frames.
+type wsStream struct {
+	format     wsStreamFormat
+	chunkBytes int
+	buf        []byte
+	// consumed counts the stream bytes cut into chunks so far, and seq the
+	// chunks.
+	consumed int64
+	seq      int64
+}
+
+func newWSStream(cfg Config, f wsStreamFormat) *wsStream {
+	frame := 2 * f.Channels
+	n := int(cfg.WSStreamChunkDuration.Seconds() * float64(f.SampleRate) * float64(frame))
+	n = min(n, cfg.WSStreamChunkBytes) / frame * frame
+	return &wsStream{format: f, chunkBytes: max(n, frame)}
+}
+
+func (s *wsStream) write(data []byte) {
+	s.buf = append(s.buf, data...)
+}
+
+// cut returns the next full chunk as WAV, or with final whatever whole
+// frames remain; a trailing partial frame is dropped.
+func (s *wsStream) cut(final bool) ([]byte, bool) {
+	n := s.chunkBytes
+	if len(s.buf) < n {
+		if !final {
+			return nil, false
+		}
+		frame := 2 * s.format.Channels
+		if n = len(s.buf) / frame * frame; n == 0 {
+			s.buf = nil
+			return nil, false
+		}
+	}
+	wav := encodePCMWAV(s.buf[:n], s.format)
+	s.buf = append(s.buf[:0], s.buf[n:]...)
+	s.consumed += int64(n)
+	s.seq++
+	return wav, true
+}
+
+// encodePCMWAV wraps interleaved 16-bit PCM in a canonical RIFF/WAVE
+// container.
+func encodePCMWAV(pcm []byte, f wsStreamFormat) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(f.Channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(f.SampleRate))
+	binary.Write(&buf, binary.LittleEndian, uint32(f.SampleRate*2*f.Channels))
+	binary.Write(&buf, binary.LittleEndian, uint16(2*f.Channels))
+	binary.Write(&buf, binary.LittleEndian, uint16(16))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
@@ -0,0 +1,120 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialStream(t *testing.T, h *Harness, session string, format wsStreamFormat) (*websocket.Conn, int) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id="+session), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	conn.WriteJSON(wsEnvelope{Type: "hello", Stream: &format})
+	var hello struct {
+		Stream struct {
+			ChunkBytes int `json:"chunk_bytes"`
+		} `json:"stream"`
+	}
+	if err := conn.ReadJSON(&hello); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	return conn, hello.Stream.ChunkBytes
+}
+
+func TestWSStreamCutsChunks(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WSStreamChunkDuration = time.Second
+	h := NewHarness(cfg)
+	defer h.Close()
+	conn, chunkBytes := dialStream(t, h, "stream", wsStreamFormat{SampleRate: 8000, Channels: 1})
+	defer conn.Close()
+	if chunkBytes != 16000 {
+		t.Fatalf("Expected 1s chunks of 16000 bytes, but got %d", chunkBytes)
+	}
+
+	// 3.5 chunks' worth of sine, in frames that do not line up with them.
+	pcm := SineWAV(440, 3500*time.Millisecond, 8000)[44:]
+	for len(pcm) > 0 {
+		n := min(3001, len(pcm))
+		conn.WriteMessage(websocket.BinaryMessage, pcm[:n])
+		pcm = pcm[n:]
+	}
+	conn.WriteJSON(wsEnvelope{Type: "end_session"})
+
+	type streamAck struct {
+		Type     string   `json:"type"`
+		Seq      int64    `json:"seq"`
+		Offset   int64    `json:"offset"`
+		Metadata Metadata `json:"metadata"`
+	}
+	want := []struct {
+		offset     int64
+		durationMS int64
+	}{{16000, 1000}, {32000, 1000}, {48000, 1000}, {56000, 500}}
+	for i, w := range want {
+		var ack streamAck
+		if err := conn.ReadJSON(&ack); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+		if ack.Type != "stream_ack" || ack.Seq != int64(i+1) || ack.Offset != w.offset || ack.Metadata.DurationMS != w.durationMS {
+			t.Errorf("Chunk %d: expected offset %d and %dms, but got %+v", i+1, w.offset, w.durationMS, ack)
+		}
+		if ack.Metadata.Transcript == "" {
+			t.Errorf("Chunk %d: expected it to be transcribed", i+1)
+		}
+	}
+	var closed SessionEvent
+	if conn.ReadJSON(&closed); closed.Type != "session_closed" || closed.Summary.Chunks != 4 {
+		t.Errorf("Expected the session closed after 4 chunks, but got %+v", closed)
+	}
+}
+
+func TestWSStreamFlushesOnClose(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WSStreamChunkBytes = 8000
+	h := NewHarness(cfg)
+	defer h.Close()
+	conn, chunkBytes := dialStream(t, h, "stereo", wsStreamFormat{SampleRate: 8000, Channels: 2})
+	if chunkBytes != 8000 {
+		t.Fatalf("Expected the byte limit to cut first, but got chunks of %d bytes", chunkBytes)
+	}
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 10001))
+	var ack map[string]any
+	conn.ReadJSON(&ack)
+	conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(h.Store.ListByUser("user1")) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the partial chunk to be flushed on close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var total int64
+	for _, m := range h.Store.ListByUser("user1") {
+		total += m.DurationMS
+	}
+	// 10000 bytes of whole 4-byte frames at 8kHz; the odd byte is dropped.
+	if total != 312 {
+		t.Errorf("Expected 312ms of audio across both chunks, but got %d", total)
+	}
+}
+
+func TestWSStreamRejectsBadFormat(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	conn, _, err := websocket.DefaultDialer.Dial(h.WSURL("/ws?user_id=user1&session_id=s"), nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(wsEnvelope{Type: "hello", Stream: &wsStreamFormat{SampleRate: 100, Channels: 1}})
+	var reply map[string]any
+	if conn.ReadJSON(&reply); reply["error"] != "invalid_stream" {
+		t.Errorf("Expected invalid_stream, but got %v", reply)
+	}
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxTagBytes bounds how much of a file's tag area is parsed, so a huge
+// ID3 tag (cover art, say) costs no more than a small one. Frames past it
+// are ignored. maxTagValue caps each stored value, in runes.
+const (
+	maxTagBytes = 64 << 10
+	maxTagValue = 256
+)
+
+// EmbeddedTags are the descriptive tags found in an uploaded file: ID3v2
+// frames of MP3s and the RIFF INFO list of WAVs.
+type EmbeddedTags struct {
+	Title  string `json:"title,omitempty"`
+	Artist string `json:"artist,omitempty"`
+	Album  string `json:"album,omitempty"`
+	// Date is the recording date as the file gives it, often just a year.
+	Date string `json:"date,omitempty"`
+}
+
+// text is the tags' values joined for searching.
+func (t *EmbeddedTags) text() string {
+	if t == nil {
+		return ""
+	}
+	return strings.Join([]string{t.Title, t.Artist, t.Album, t.Date}, " ")
+}
+
+// set stores value under the field name, keeping the first value found.
+func (t *EmbeddedTags) set(field, value string) {
+	value = strings.TrimSpace(value)
+	if !utf8.ValidString(value) || value == "" {
+		return
+	}
+	if r := []rune(value); len(r) > maxTagValue {
+		value = string(r[:maxTagValue])
+	}
+	var dst *string
+	switch field {
+	case "title":
+		dst = &t.Title
+	case "artist":
+		dst = &t.Artist
+	case "album":
+		dst = &t.Album
+	case "date":
+		dst = &t.Date
+	}
+	if dst != nil && *dst == "" {
+		*dst = value
+	}
+}
+
+// ReadTags returns the tags embedded in data, or nil if it has none.
+// Malformed frames are skipped; the tags found before and after them are
+// kept.
+func ReadTags(data []byte) *EmbeddedTags {
+	var tags EmbeddedTags
+	switch {
+	case bytes.HasPrefix(data, []byte("ID3")):
+		readID3(data, &tags)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		readRIFFInfo(data, &tags)
+	}
+	if tags == (EmbeddedTags{}) {
+		return nil
+	}
+	return &tags
+}
+
+// id3Frames maps the ID3v2.3/2.4 and v2.2 frame IDs read to tag fields.
+var id3Frames = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TDRC": "date", "TYER": "date", "TYE": "date",
+}
+
+// readID3 reads the text frames of an ID3v2.2, 2.3 or 2.4 tag.
+func readID3(data []byte, tags *EmbeddedTags) {
+	if len(data) < 10 {
+		return
+	}
+	version, flags := data[3], data[5]
+	if version < 2 || version > 4 {
+		return
+	}
+	size := syncsafe(data[6:10])
+	body := data[10:min(len(data), 10+size, 10+maxTagBytes)]
+	if flags&0x40 != 0 && version >= 3 && len(body) >= 4 {
+		ext := int(binary.BigEndian.Uint32(body[:4]))
+		if version == 3 {
+			ext += 4
+		} else {
+			ext = syncsafe(body[:4])
+		}
+		if ext > len(body) {
+			return
+		}
+		body = body[ext:]
+	}
+
+	idLen, headerLen := 4, 10
+	if version == 2 {
+		idLen, headerLen = 3, 6
+	}
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var n int
+		switch version {
+		case 2:
+			n = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			n = int(binary.BigEndian.Uint32(body[4:8]))
+		default:
+			n = syncsafe(body[4:8])
+		}
+		if n < 0 || n > len(body)-headerLen {
+			// A size running past the tag leaves nothing to resynchronise on.
+			return
+		}
+		frame := body[headerLen : headerLen+n]
+		body = body[headerLen+n:]
+		if field, ok := id3Frames[id]; ok {
+			if value, ok := id3Text(frame); ok {
+				tags.set(field, value)
+			}
+		}
+	}
+}
+
+// id3Text decodes a text frame: an encoding byte, then the text in
+// ISO-8859-1, UTF-16 with a BOM, UTF-16BE or UTF-8. Frames with several
+// NUL-separated values yield the first.
+func id3Text(frame []byte) (string, bool) {
+	if len(frame) < 1 {
+		return "", false
+	}
+	text := frame[1:]
+	switch frame[0] {
+	case 0:
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		s, _, _ := strings.Cut(string(runes), "\x00")
+		return s, true
+	case 1, 2:
+		if len(text)%2 != 0 {
+			return "", false
+		}
+		bigEndian := frame[0] == 2
+		if frame[0] == 1 {
+			if len(text) < 2 {
+				return "", false
+			}
+			switch {
+			case text[0] == 0xfe && text[1] == 0xff:
+				bigEndian = true
+			case text[0] == 0xff && text[1] == 0xfe:
+			default:
+				return "", false
+			}
+			text = text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			u := binary.LittleEndian.Uint16(text[i:])
+			if bigEndian {
+				u = binary.BigEndian.Uint16(text[i:])
+			}
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		return string(utf16.Decode(units)), true
+	case 3:
+		s, _, _ := strings.Cut(string(text), "\x00")
+		return s, utf8.ValidString(s)
+	}
+	return "", false
+}
+
+// syncsafe decodes a 28-bit integer stored seven bits per byte.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// riffInfoFields maps the RIFF INFO chunks read to tag fields.
+var riffInfoFields = map[string]string{
+	"INAM": "title",
+	"IART": "artist",
+	"IPRD": "album",
+	"ICRD": "date",
+}
+
+// readRIFFInfo reads the LIST/INFO chunk of a WAV file.
+func readRIFFInfo(data []byte, tags *EmbeddedTags) {
+	parsed := 0
+	for off := 12; off+8 <= len(data) && parsed < maxTagBytes; {
+		id := string(data[off : off+4])
+		size := int(binary.LittleEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8 : min(len(data), off+8+size)]
+		if id == "LIST" && len(body) >= 4 && string(body[:4]) == "INFO" {
+			info := body[4:min(len(body), maxTagBytes-parsed)]
+			parsed += len(info)
+			for len(info) >= 8 {
+				sub := string(info[:4])
+				n := int(binary.LittleEndian.Uint32(info[4:8]))
+				if n > len(info)-8 {
+					break
+				}
+				if field, ok := riffInfoFields[sub]; ok {
+					value, _, _ := strings.Cut(string(info[8:8+n]), "\x00")
+					tags.set(field, value)
+				}
+				info = info[min(len(info), 8+n+n%2):]
+			}
+		}
+		off += 8 + size + size%2
+	}
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// id3Frame builds an ID3v2.3 frame.
+func id3Frame(id string, body []byte) []byte {
+	frame := []byte(id)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+	return append(append(frame, 0, 0), body...)
+}
+
+func utf16Text(s string) []byte {
+	b := []byte{1, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+// id3File is an ID3v2.3 tag of frames in front of fake MP3 audio.
+func id3File(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 16)...) // padding
+	size := len(body)
+	tag := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	return append(append(tag, body...), 0xff, 0xfb, 0x90, 0x00)
+}
+
+func TestReadID3Tags(t *testing.T) {
+	data := id3File(
+		id3Frame("TIT2", append([]byte{3}, "Épisode 12: Café"...)),
+		id3Frame("TPE1", []byte{9, 'x'}),             // unknown encoding
+		id3Frame("TALB", []byte{1, 0xff, 0xfe, 'a'}), // odd-length UTF-16
+		id3Frame("TPE1", utf16Text("Zoë Ünal")),
+		id3Frame("APIC", bytes.Repeat([]byte{0}, 100)),
+		id3Frame("TYER", []byte("\x002024")),
+	)
+	got := ReadTags(data)
+	want := EmbeddedTags{Title: "Épisode 12: Café", Artist: "Zoë Ünal", Date: "2024"}
+	if got == nil || *got != want {
+		t.Errorf("Expected %+v, but got %+v", want, got)
+	}
+
+	// A frame whose size runs past the tag ends parsing but keeps what was read.
+	truncated := id3File(id3Frame("TIT2", []byte("\x00Kept")), []byte("TALB\x7f\xff\xff\xff\x00\x00"))
+	if got := ReadTags(truncated); got == nil || got.Title != "Kept" || got.Album != "" {
+		t.Errorf("Expected the title before the broken frame, but got %+v", got)
+	}
+	// Frames past maxTagBytes are not parsed.
+	big := id3File(id3Frame("PRIV", make([]byte, maxTagBytes)), id3Frame("TIT2", []byte("\x00Too far")))
+	if got := ReadTags(big); got != nil {
+		t.Errorf("Expected nothing past the size limit, but got %+v", got)
+	}
+	if got := ReadTags([]byte("ID3")); got != nil {
+		t.Errorf("Expected nothing from a bare header, but got %+v", got)
+	}
+}
+
+func TestReadRIFFInfoTags(t *testing.T) {
+	info := []byte("INFO")
+	for _, kv := range [][2]string{{"INAM", "Standup"}, {"IART", "Team Blue"}, {"ICRD", "2024-05-01"}} {
+		info = append(info, kv[0]...)
+		info = binary.LittleEndian.AppendUint32(info, uint32(len(kv[1])+1))
+		info = append(append(info, kv[1]...), 0)
+		if (len(kv[1])+1)%2 == 1 {
+			info = append(info, 0)
+		}
+	}
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	wav = append(wav, "LIST"...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(info)))
+	wav = append(wav, info...)
+
+	got := ReadTags(wav)
+	want := EmbeddedTags{Title: "Standup", Artist: "Team Blue", Date: "2024-05-01"}
+	if got == nil || *got != want {
+		t.Errorf("Expected %+v, but got %+v", want, got)
+	}
+	if _, err := decodeAudio(wav); err != nil {
+		t.Errorf("Expected the tagged WAV to still decode, but got %v", err)
+	}
+}
+
+func TestSearchEmbeddedTags(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	episode := uploadTo(t, h, "user1", "s1", id3File(id3Frame("TIT2", append([]byte{3}, "Quarterly roadmap review"...))))
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	if episode.EmbeddedTags == nil || episode.EmbeddedTags.Title != "Quarterly roadmap review" {
+		t.Fatalf("Expected the title in the metadata, but got %+v", episode.EmbeddedTags)
+	}
+
+	search := func(query string) (int, []SearchHit) {
+		resp, err := http.Get(h.URL + "/search?user_id=user1&" + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var hits []SearchHit
+		json.NewDecoder(resp.Body).Decode(&hits)
+		return resp.StatusCode, hits
+	}
+	if _, hits := search("q=roadmap&scope=tags"); len(hits) != 1 || hits[0].ChunkID != episode.ChunkID {
+		t.Errorf("Expected the tagged chunk, but got %+v", hits)
+	}
+	if _, hits := search("q=roadmap"); len(hits) != 0 {
+		t.Errorf("Expected transcript search to ignore tags, but got %+v", hits)
+	}
+	if status, _ := search("q=roadmap&scope=everything"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown scope, but got %v", status)
+	}
+
+	// Without trusted indexes the tags are indexed on the fly.
+	h.Store.mu.Lock()
+	h.Store.index.trusted = false
+	h.Store.mu.Unlock()
+	if hits := h.Store.SearchTags("QUARTERLY", "user1", 10); len(hits) != 1 || !strings.Contains(hits[0].EmbeddedTags.Title, "Quarterly") {
+		t.Errorf("Expected the scan to find the tag, but got %+v", hits)
+	}
+}
@@ -0,0 +1,207 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var requestTimeouts = expvar.NewMap("request_timeouts")
+
+// streamingRoutes hold their connection open indefinitely and clear the
+// server's deadlines themselves.
+var streamingRoutes = map[string]bool{"/ws": true, "/ws/transcript": true, "/events": true, "/admin/replay": true, "/sessions/{user_id}/{session_id}/observe": true}
+
+// HTTP/2 limits for h2c. The upload windows let a stream keep a chunk
+// flowing without waiting on the handler to read each frame.
+const (
+	h2MaxStreams       = 250
+	h2StreamWindow     = 1 << 20
+	h2ConnectionWindow = 8 << 20
+)
+
+// newHTTPServer applies the configured connection limits, so a client that
+// trickles its headers or body cannot hold a connection open forever. With
+// H2C it also accepts cleartext HTTP/2, where the read and write timeouts
+// apply per stream rather than to the whole connection.
+func newHTTPServer(cfg Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	if cfg.H2C {
+		h2s := &http2.Server{
+			MaxConcurrentStreams:         h2MaxStreams,
+			MaxUploadBufferPerStream:     h2StreamWindow,
+			MaxUploadBufferPerConnection: h2ConnectionWindow,
+		}
+		// Registers h2s for graceful shutdown alongside srv.
+		if err := http2.ConfigureServer(srv, h2s); err != nil {
+			log.Printf("Serving HTTP/1 only: %v", err)
+			return srv
+		}
+		srv.Handler = h2c.NewHandler(handler, h2s)
+	}
+	return srv
+}
+
+// clearDeadlines lifts the server's read and write timeouts for a streaming
+// response.
+func clearDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+}
+
+// routeTimeouts gives every non-streaming route a deadline: UploadTimeout
+// for uploads and HandlerTimeout for everything else.
+func routeTimeouts(cfg Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, _ := mux.CurrentRoute(r).GetPathTemplate()
+			d := cfg.HandlerTimeout
+			if route == "/upload" {
+				d = cfg.UploadTimeout
+			}
+			if streamingRoutes[route] || isTranscodedDownload(route, r) || d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			withTimeout(route, d, next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// withTimeout runs next with a deadline of d, buffering its response. If
+// the deadline passes first, the client gets 408 when it had not finished
+// sending the body and 503 otherwise, and whatever next writes later is
+// discarded.
+func withTimeout(route string, d time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline := time.Now().Add(d)
+		// Unblocks body reads on real connections; recorders don't support it.
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(deadline)
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		body := &trackedBody{ReadCloser: r.Body}
+		r = r.WithContext(ctx)
+		r.Body = body
+		tw := &timeoutWriter{header: make(http.Header), status: http.StatusOK}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			rc.SetReadDeadline(time.Time{})
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			if tw.status == http.StatusRequestTimeout {
+				// next noticed the cut-off body before we did.
+				requestTimeouts.Add("read", 1)
+			}
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(tw.status)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if r.ContentLength != 0 && !body.eof.Load() {
+				// Leave the expired deadline in place and drop the
+				// connection rather than wait for the rest of the body.
+				w.Header().Set("Connection", "close")
+				requestTimeouts.Add("read", 1)
+				writeReadTimeout(w)
+				return
+			}
+			rc.SetReadDeadline(time.Time{})
+			requestTimeouts.Add(route, 1)
+			writeJSONError(w, http.StatusServiceUnavailable, "timeout", "request took longer than "+d.String())
+		}
+	})
+}
+
+// isTimeout reports whether err is a deadline expiring, so handlers can
+// answer with writeReadTimeout when a body read was cut off.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, context.DeadlineExceeded)
+}
+
+func writeReadTimeout(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusRequestTimeout, "request_timeout", "request body was not received in time")
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	eof atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.eof.Store(true)
+	}
+	return n, err
+}
+
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	wrote    bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wrote {
+		return
+	}
+	tw.status, tw.wrote = status, true
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wrote = true
+	return tw.buf.Write(p)
+}
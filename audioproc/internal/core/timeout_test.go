@@ -0,0 +1,138 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func expvarInt(m *expvar.Map, key string) int64 {
+	if v, ok := m.Get(key).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
+func decodeErrorCode(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected a JSON error envelope: %v", err)
+	}
+	return body.Error
+}
+
+func TestSlowBodyTimesOut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.UploadTimeout = 200 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+	before := expvarInt(requestTimeouts, "read")
+
+	conn, err := net.Dial("tcp", h.server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "POST /upload?user_id=user1&session_id=slow HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\n0123456789")
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Expected a response before the body was finished: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON 408, but got %v %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if code := decodeErrorCode(t, resp); code != "request_timeout" {
+		t.Errorf("Expected request_timeout, but got %v", code)
+	}
+	if expvarInt(requestTimeouts, "read") != before+1 {
+		t.Errorf("Expected the timeout to be counted")
+	}
+}
+
+func TestSlowHandlerTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	slow := withTimeout("/slow", 50*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte("too late"))
+	}))
+	rr := httptest.NewRecorder()
+	slow.ServeHTTP(rr, httptest.NewRequest("GET", "/slow", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON 503, but got %v %v", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if requestTimeouts.Get("/slow") == nil {
+		t.Errorf("Expected the timeout to be counted per route")
+	}
+
+	fast := withTimeout("/fast", time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("done"))
+	}))
+	rr = httptest.NewRecorder()
+	fast.ServeHTTP(rr, httptest.NewRequest("GET", "/fast", nil))
+	if rr.Code != http.StatusCreated || rr.Header().Get("X-Test") != "yes" || rr.Body.String() != "done" {
+		t.Errorf("Expected a fast response to pass through, but got %v %v %q", rr.Code, rr.Header(), rr.Body)
+	}
+}
+
+func TestStreamingRoutesOutliveTimeouts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.HandlerTimeout = 100 * time.Millisecond
+	cfg.ReadTimeout = 100 * time.Millisecond
+	cfg.WriteTimeout = 100 * time.Millisecond
+	srv, cancel, errc := runServer(t, cfg, NewMemoryStore())
+	defer func() { cancel(); <-errc }()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.Addr().String()+"/ws?user_id=user1&session_id=s", nil)
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(300 * time.Millisecond)
+	conn.WriteMessage(websocket.BinaryMessage, []byte("audio"))
+	var ack map[string]any
+	if err := conn.ReadJSON(&ack); err != nil || ack["ack"] != true {
+		t.Errorf("Expected the WebSocket to outlive the server timeouts, but got %v, %v", ack, err)
+	}
+}
+
+func TestSlowHeadersAreCut(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.ReadHeaderTimeout = 100 * time.Millisecond
+	srv, cancel, errc := runServer(t, cfg, NewMemoryStore())
+	defer func() { cancel(); <-errc }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "GET /debug/vars HTTP/1.1\r\nHost: te")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1024)); err == nil {
+		// The server may answer 408 before closing; either way it must not wait.
+		if _, err := conn.Read(make([]byte, 1024)); err == nil {
+			t.Errorf("Expected the server to drop a connection with trickling headers")
+		}
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Errorf("Expected the server to drop the connection, but it kept waiting")
+	}
+}
@@ -0,0 +1,425 @@
+package core
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var transcodeStats = expvar.NewMap("transcode")
+
+var (
+	// errUntranscodable is returned for audio in a format that can't be
+	// decoded, or a format or bitrate no encoder offers.
+	errUntranscodable = newKindError(ErrNotAcceptable, "audio cannot be transcoded to the requested format")
+	errTranscodeBusy  = newKindError(ErrResourceExhausted, "too many audio encodes in progress; retry shortly")
+)
+
+// audioRoutes serve a chunk's audio and accept ?format=.
+var audioRoutes = map[string]bool{"/chunks/{id}/audio": true, "/shared/{token}/chunks/{id}/audio": true}
+
+// Encoder compresses decoded audio for download.
+type Encoder interface {
+	// Encode writes pcm to w at bitrate kbps. Output is streamed to the
+	// client as it is written, so Encode should write as it goes.
+	Encode(ctx context.Context, w io.Writer, pcm PCM, bitrate int) error
+}
+
+// AudioFormat is a format audio downloads can ask for with ?format=.
+type AudioFormat struct {
+	ContentType string
+	// Bitrates are the kbps ?bitrate= may ask for; the first is the
+	// default.
+	Bitrates []int
+	Encoder  Encoder
+}
+
+// Transcoder encodes chunk audio on download and keeps the results, up to
+// a budget in bytes, so a chunk played again in the same format and
+// bitrate is served without encoding it again. Downloads of the same
+// encoding while it is being made wait for it rather than starting
+// another. Normalized downloads are encoded every time.
+//
+// Encodes are limited in number and in how long each may run, since the
+// public share routes reach them too.
+type Transcoder struct {
+	maxBytes int64
+	slots    chan struct{}
+	wait     time.Duration
+	timeout  time.Duration
+
+	mu      sync.Mutex
+	formats map[string]AudioFormat
+	entries map[transcodeKey]*list.Element
+	lru     *list.List
+	size    int64
+	calls   map[transcodeKey]*transcodeCall
+}
+
+// transcodeCall is an encode in progress that other downloads of the same
+// encoding wait for.
+type transcodeCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// transcodeKey names an encoded artifact. The checksum keeps a chunk ID
+// reused for different audio from being served the old encoding.
+type transcodeKey struct {
+	chunkID  string
+	checksum string
+	format   string
+	bitrate  int
+}
+
+type transcodeEntry struct {
+	key  transcodeKey
+	data []byte
+}
+
+// NewTranscoder caches up to cfg.TranscodeCacheBytes of encoded audio and
+// runs up to cfg.TranscodeConcurrency encodes at once, each for up to
+// cfg.TranscodeTimeout; either is unlimited when 0. With cfg.FFmpegPath set, mp3 and opus are encoded by ffmpeg;
+// register other formats with Register.
+func NewTranscoder(cfg Config) *Transcoder {
+	t := &Transcoder{
+		maxBytes: cfg.TranscodeCacheBytes,
+		wait:     cfg.ConcurrencyWait,
+		timeout:  cfg.TranscodeTimeout,
+		formats:  make(map[string]AudioFormat),
+		entries:  make(map[transcodeKey]*list.Element),
+		lru:      list.New(),
+		calls:    make(map[transcodeKey]*transcodeCall),
+	}
+	if cfg.TranscodeConcurrency > 0 {
+		t.slots = make(chan struct{}, cfg.TranscodeConcurrency)
+	}
+	if cfg.FFmpegPath != "" {
+		for name, f := range FFmpegFormats(cfg.FFmpegPath) {
+			t.Register(name, f)
+		}
+	}
+	return t
+}
+
+// Register offers f as ?format=name, replacing any format of that name.
+func (t *Transcoder) Register(name string, f AudioFormat) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.formats[name] = f
+}
+
+// offered maps the registered formats to their bitrates.
+func (t *Transcoder) offered() map[string][]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	offered := make(map[string][]int, len(t.formats))
+	for name, f := range t.formats {
+		if len(f.Bitrates) > 0 {
+			offered[name] = f.Bitrates
+		}
+	}
+	return offered
+}
+
+// transcodeRequest is a download's ?format= and ?bitrate=.
+type transcodeRequest struct {
+	name    string
+	format  AudioFormat
+	bitrate int
+}
+
+// parseFormat reads the format and bitrate an audio download asks for; ok
+// is false when it asks for the stored audio as is.
+func (t *Transcoder) parseFormat(r *http.Request) (req transcodeRequest, ok bool, err error) {
+	q := r.URL.Query()
+	name, rate := q.Get("format"), q.Get("bitrate")
+	if name == "" {
+		if rate != "" {
+			return req, false, invalidParam("bitrate", "invalid_bitrate", "only applies with format")
+		}
+		return req, false, nil
+	}
+	t.mu.Lock()
+	f, found := t.formats[name]
+	t.mu.Unlock()
+	if !found || len(f.Bitrates) == 0 {
+		return req, false, fmt.Errorf("%w: %s is not offered", errUntranscodable, name)
+	}
+	bitrate := f.Bitrates[0]
+	if rate != "" {
+		if bitrate, err = strconv.Atoi(rate); err != nil || bitrate <= 0 {
+			return req, false, invalidParam("bitrate", "invalid_bitrate", "must be a positive number of kbps")
+		}
+		if !slices.Contains(f.Bitrates, bitrate) {
+			return req, false, fmt.Errorf("%w: %s is offered at %s kbps", errUntranscodable, name, joinInts(f.Bitrates))
+		}
+	}
+	return transcodeRequest{name: name, format: f, bitrate: bitrate}, true, nil
+}
+
+func joinInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ", ")
+}
+
+// isTranscodedDownload reports whether r asks for audio to be encoded.
+// Those responses stream as they are encoded rather than being buffered,
+// so like the streaming routes they are bounded by the write timeout.
+func isTranscodedDownload(route string, r *http.Request) bool {
+	return audioRoutes[route] && r.URL.Query().Get("format") != ""
+}
+
+func (t *Transcoder) get(key transcodeKey) ([]byte, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	el, ok := t.entries[key]
+	if !ok {
+		return nil, false
+	}
+	t.lru.MoveToFront(el)
+	return el.Value.(*transcodeEntry).data, true
+}
+
+// put keeps data under key, evicting the least recently served encodings
+// to stay within budget. Anything larger than the whole budget is not
+// kept.
+func (t *Transcoder) put(key transcodeKey, data []byte) {
+	size := int64(len(data))
+	if size > t.maxBytes {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if el, ok := t.entries[key]; ok {
+		t.size -= int64(len(el.Value.(*transcodeEntry).data))
+		t.lru.Remove(el)
+	}
+	t.entries[key] = t.lru.PushFront(&transcodeEntry{key: key, data: data})
+	t.size += size
+	for t.size > t.maxBytes {
+		oldest := t.lru.Remove(t.lru.Back()).(*transcodeEntry)
+		delete(t.entries, oldest.key)
+		t.size -= int64(len(oldest.data))
+		transcodeStats.Add("evictions", 1)
+	}
+}
+
+// acquire takes an encode slot, waiting up to the configured wait for one.
+func (t *Transcoder) acquire(ctx context.Context) error {
+	if t.slots == nil {
+		return nil
+	}
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(t.wait)
+	defer timer.Stop()
+	select {
+	case t.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		transcodeStats.Add("busy", 1)
+		return errTranscodeBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Transcoder) release() {
+	if t.slots != nil {
+		<-t.slots
+	}
+}
+
+// serveEncoded writes a finished encoding.
+func serveEncoded(w http.ResponseWriter, req transcodeRequest, out []byte) {
+	w.Header().Set("Content-Type", req.format.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.Header().Set("X-Audio-Bitrate", strconv.Itoa(req.bitrate))
+	w.Write(out)
+}
+
+// write serves data encoded as req asks, from the cache when it can, or by
+// waiting for a download already encoding it. Otherwise the encoding is
+// streamed with chunked transfer as it is produced; an encoder that fails
+// part way through aborts the response so the client can't mistake it for
+// a whole file.
+func (t *Transcoder) write(w http.ResponseWriter, r *http.Request, store *MemoryStore, meta Metadata, data []byte, req transcodeRequest, normalize bool, target float64) {
+	key := transcodeKey{chunkID: meta.ChunkID, checksum: meta.Checksum, format: req.name, bitrate: req.bitrate}
+	var call *transcodeCall
+	if normalize {
+		out, gainDB, err := normalizeAudio(store, meta, data, target)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("X-Applied-Gain-DB", strconv.FormatFloat(gainDB, 'f', 2, 64))
+		data = out
+	} else {
+		if out, ok := t.get(key); ok {
+			transcodeStats.Add("cache_hits", 1)
+			serveEncoded(w, req, out)
+			return
+		}
+		t.mu.Lock()
+		if c, ok := t.calls[key]; ok {
+			t.mu.Unlock()
+			transcodeStats.Add("coalesced", 1)
+			select {
+			case <-c.done:
+			case <-r.Context().Done():
+				return
+			}
+			if c.err != nil {
+				writeError(w, c.err)
+				return
+			}
+			serveEncoded(w, req, c.data)
+			return
+		}
+		call = &transcodeCall{done: make(chan struct{})}
+		t.calls[key] = call
+		t.mu.Unlock()
+		defer func() {
+			t.mu.Lock()
+			delete(t.calls, key)
+			t.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	fail := func(err error) {
+		if call != nil {
+			call.err = err
+		}
+		writeError(w, err)
+	}
+	pcm, err := decodeAudio(data)
+	if err != nil {
+		fail(fmt.Errorf("%w: only WAV and FLAC audio can be transcoded", errUntranscodable))
+		return
+	}
+	if err := t.acquire(r.Context()); err != nil {
+		fail(err)
+		return
+	}
+	defer t.release()
+
+	transcodeStats.Add("encodes", 1)
+	w.Header().Set("Content-Type", req.format.ContentType)
+	w.Header().Set("X-Audio-Bitrate", strconv.Itoa(req.bitrate))
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+	}
+	defer cancel()
+	out := &flushWriter{w: w, rc: http.NewResponseController(w)}
+	var buf bytes.Buffer
+	if err := req.format.Encoder.Encode(ctx, io.MultiWriter(out, &buf), pcm, req.bitrate); err != nil {
+		transcodeStats.Add("errors", 1)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			transcodeStats.Add("timeouts", 1)
+		}
+		log.Printf("Encoding chunk %s as %s at %d kbps: %v", meta.ChunkID, req.name, req.bitrate, err)
+		if !out.wrote {
+			fail(fmt.Errorf("encoding audio: %w", err))
+			return
+		}
+		if call != nil {
+			call.err = fmt.Errorf("encoding audio: %w", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+	if call != nil {
+		call.data = buf.Bytes()
+		t.put(key, call.data)
+	}
+}
+
+// flushWriter sends each write to the client as it is made.
+type flushWriter struct {
+	w     io.Writer
+	rc    *http.ResponseController
+	wrote bool
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if n > 0 {
+		f.wrote = true
+		f.rc.Flush()
+	}
+	return n, err
+}
+
+// CommandEncoder encodes by running a program, such as ffmpeg, that reads
+// a WAV on stdin and writes the encoded audio to stdout. "{bitrate}" in
+// Args is replaced by the bitrate in kbps.
+type CommandEncoder struct {
+	Path string
+	Args []string
+}
+
+func (c CommandEncoder) Encode(ctx context.Context, w io.Writer, pcm PCM, bitrate int) error {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		args[i] = strings.ReplaceAll(a, "{bitrate}", strconv.Itoa(bitrate))
+	}
+	cmd := exec.CommandContext(ctx, c.Path, args...)
+	cmd.Stdin = bytes.NewReader(EncodeWAV(pcmInt16(pcm), pcm.SampleRate))
+	cmd.Stdout = w
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+func pcmInt16(pcm PCM) []int16 {
+	samples := make([]int16, len(pcm.Samples))
+	for i, s := range pcm.Samples {
+		samples[i] = int16(math.Max(-1, math.Min(1, s)) * math.MaxInt16)
+	}
+	return samples
+}
+
+// FFmpegFormats are mp3 and Ogg Opus encoded by the ffmpeg at path.
+func FFmpegFormats(path string) map[string]AudioFormat {
+	ffmpeg := func(args ...string) CommandEncoder {
+		return CommandEncoder{Path: path, Args: append([]string{"-hide_banner", "-loglevel", "error", "-f", "wav", "-i", "pipe:0"}, append(args, "-b:a", "{bitrate}k", "pipe:1")...)}
+	}
+	return map[string]AudioFormat{
+		"mp3":  {ContentType: "audio/mpeg", Bitrates: []int{128, 64, 96, 192, 256, 320}, Encoder: ffmpeg("-f", "mp3", "-c:a", "libmp3lame")},
+		"opus": {ContentType: "audio/ogg; codecs=opus", Bitrates: []int{32, 16, 24, 48, 64, 96, 128}, Encoder: ffmpeg("-f", "ogg", "-c:a", "libopus")},
+	}
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os/exec"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubEncoder wraps the PCM as 16-bit samples between a header naming the
+// bitrate and a trailer, writing in two parts so the response streams.
+type stubEncoder struct {
+	calls atomic.Int64
+	err   error
+}
+
+func (e *stubEncoder) Encode(_ context.Context, w io.Writer, pcm PCM, bitrate int) error {
+	e.calls.Add(1)
+	if e.err != nil {
+		return e.err
+	}
+	fmt.Fprintf(w, "STUB %d kbps\n", bitrate)
+	binary.Write(w, binary.LittleEndian, pcmInt16(pcm))
+	_, err := io.WriteString(w, "\nEND")
+	return err
+}
+
+func stubFormat(e *stubEncoder) AudioFormat {
+	return AudioFormat{ContentType: "audio/x-stub", Bitrates: []int{64, 128}, Encoder: e}
+}
+
+func TestTranscodedDownload(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	enc := &stubEncoder{}
+	h.Transcoder.Register("stub", stubFormat(enc))
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	meta := uploadTo(t, h, "user1", "s1", wav)
+	url := h.URL + "/chunks/" + meta.ChunkID + "/audio?format=stub"
+
+	resp, body := downloadAudio(t, url)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "audio/x-stub" || resp.Header.Get("X-Audio-Bitrate") != "64" {
+		t.Fatalf("Expected stub audio at the default bitrate, but got %d %v", resp.StatusCode, resp.Header)
+	}
+	if !slices.Equal(resp.TransferEncoding, []string{"chunked"}) {
+		t.Errorf("Expected the encoding to stream with chunked transfer, but got %v", resp.TransferEncoding)
+	}
+	pcm, _ := decodeWAV(wav)
+	var want bytes.Buffer
+	want.WriteString("STUB 64 kbps\n")
+	binary.Write(&want, binary.LittleEndian, pcmInt16(pcm))
+	want.WriteString("\nEND")
+	if !bytes.Equal(body, want.Bytes()) {
+		t.Errorf("Expected the stub to wrap the chunk's PCM, but got %d bytes starting %q", len(body), body[:min(len(body), 16)])
+	}
+
+	resp, again := downloadAudio(t, url)
+	if !bytes.Equal(again, body) || enc.calls.Load() != 1 {
+		t.Errorf("Expected the second download from the cache, but the encoder ran %d times", enc.calls.Load())
+	}
+	if resp.ContentLength != int64(len(body)) {
+		t.Errorf("Expected a cached encoding to have a length, but got %d", resp.ContentLength)
+	}
+
+	if _, body := downloadAudio(t, url+"&bitrate=128"); !bytes.HasPrefix(body, []byte("STUB 128 kbps\n")) || enc.calls.Load() != 2 {
+		t.Errorf("Expected another bitrate to be encoded separately, but got %q after %d encodes", body[:min(len(body), 16)], enc.calls.Load())
+	}
+	downloadAudio(t, url+"&normalize=-20")
+	downloadAudio(t, url+"&normalize=-20")
+	if enc.calls.Load() != 4 {
+		t.Errorf("Expected normalized downloads to be encoded every time, but the encoder ran %d times", enc.calls.Load())
+	}
+	if _, body := downloadAudio(t, h.URL+"/chunks/"+meta.ChunkID+"/audio"); !bytes.Equal(body, wav) {
+		t.Errorf("Expected the stored audio without format")
+	}
+}
+
+func TestTranscodeUnsupported(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	h.Transcoder.Register("stub", stubFormat(&stubEncoder{}))
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	opaque := uploadTo(t, h, "user1", "s1", []byte("not audio at all"))
+
+	for _, tc := range []struct {
+		id, query string
+		status    int
+	}{
+		{meta.ChunkID, "format=mp3", http.StatusNotAcceptable},
+		{meta.ChunkID, "format=stub&bitrate=320", http.StatusNotAcceptable},
+		{opaque.ChunkID, "format=stub", http.StatusNotAcceptable},
+		{meta.ChunkID, "format=stub&bitrate=fast", http.StatusBadRequest},
+		{meta.ChunkID, "bitrate=64", http.StatusBadRequest},
+	} {
+		if resp, _ := downloadAudio(t, h.URL+"/chunks/"+tc.id+"/audio?"+tc.query); resp.StatusCode != tc.status {
+			t.Errorf("%s: expected %d, but got %d", tc.query, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestTranscodeEncoderFailure(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	enc := &stubEncoder{err: errors.New("encoder crashed")}
+	h.Transcoder.Register("stub", stubFormat(enc))
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	url := h.URL + "/chunks/" + meta.ChunkID + "/audio?format=stub"
+
+	if resp, _ := downloadAudio(t, url); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected 500 from a failed encode, but got %d", resp.StatusCode)
+	}
+	downloadAudio(t, url)
+	if enc.calls.Load() != 2 {
+		t.Errorf("Expected a failed encode not to be cached")
+	}
+}
+
+func TestTranscodeCacheEvicts(t *testing.T) {
+	tc := NewTranscoder(Config{TranscodeCacheBytes: 10})
+	tc.put(transcodeKey{chunkID: "a"}, make([]byte, 6))
+	tc.put(transcodeKey{chunkID: "b"}, make([]byte, 4))
+	tc.get(transcodeKey{chunkID: "a"})
+	tc.put(transcodeKey{chunkID: "c"}, make([]byte, 4))
+	if _, ok := tc.get(transcodeKey{chunkID: "b"}); ok {
+		t.Errorf("Expected the least recently served encoding to be evicted")
+	}
+	if _, ok := tc.get(transcodeKey{chunkID: "a"}); !ok {
+		t.Errorf("Expected the recently served encoding to be kept")
+	}
+	tc.put(transcodeKey{chunkID: "d"}, make([]byte, 11))
+	if _, ok := tc.get(transcodeKey{chunkID: "d"}); ok || tc.size != 10 {
+		t.Errorf("Expected an encoding over budget not to be kept, but the cache holds %d bytes", tc.size)
+	}
+}
+
+func TestCommandEncoder(t *testing.T) {
+	cat, err := exec.LookPath("cat")
+	if err != nil {
+		t.Skip("cat is not installed")
+	}
+	pcm := PCM{Samples: []float64{0, 0.5, -0.5, 1}, SampleRate: 8000}
+	var out bytes.Buffer
+	if err := (CommandEncoder{Path: cat}).Encode(context.Background(), &out, pcm, 64); err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeWAV(out.Bytes())
+	if err != nil || got.SampleRate != 8000 || len(got.Samples) != 4 || math.Abs(got.Samples[1]-0.5) > 0.001 {
+		t.Errorf("Expected the program to be fed the PCM as WAV, but got %+v %v", got, err)
+	}
+
+	failing := CommandEncoder{Path: "sh", Args: []string{"-c", "echo unsupported bitrate {bitrate} >&2; exit 1"}}
+	if err := failing.Encode(context.Background(), io.Discard, pcm, 48); err == nil || err.Error() != "exit status 1: unsupported bitrate 48" {
+		t.Errorf("Expected the program's error, but got %v", err)
+	}
+}
+
+// blockingEncoder encodes nothing until released, or until its context
+// ends.
+type blockingEncoder struct {
+	calls   atomic.Int64
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *blockingEncoder) Encode(ctx context.Context, w io.Writer, _ PCM, _ int) error {
+	e.calls.Add(1)
+	e.started <- struct{}{}
+	select {
+	case <-e.release:
+		_, err := io.WriteString(w, "ENCODED")
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestTranscodeCoalescesAndBounds(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TranscodeConcurrency = 1
+	h := NewHarness(cfg)
+	defer h.Close()
+	enc := &blockingEncoder{started: make(chan struct{}, 4), release: make(chan struct{})}
+	h.Transcoder.Register("stub", AudioFormat{ContentType: "audio/x-stub", Bitrates: []int{64, 128}, Encoder: enc})
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	url := h.URL + "/chunks/" + meta.ChunkID + "/audio?format=stub"
+
+	bodies := make(chan []byte, 3)
+	go func() {
+		_, body := downloadAudio(t, url)
+		bodies <- body
+	}()
+	<-enc.started
+	coalesced := expvarInt(transcodeStats, "coalesced")
+	for range 2 {
+		go func() {
+			_, body := downloadAudio(t, url)
+			bodies <- body
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); expvarInt(transcodeStats, "coalesced") < coalesced+2 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The one slot is taken, so another encoding is refused.
+	if resp, _ := downloadAudio(t, url+"&bitrate=128"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with every encode slot busy, but got %d", resp.StatusCode)
+	}
+
+	close(enc.release)
+	for range 3 {
+		if body := <-bodies; string(body) != "ENCODED" {
+			t.Errorf("Expected every download of the encoding to get it, but got %q", body)
+		}
+	}
+	if n := enc.calls.Load(); n != 1 {
+		t.Errorf("Expected concurrent downloads of one encoding to encode once, but got %d encodes", n)
+	}
+}
+
+func TestTranscodeTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TranscodeTimeout = 50 * time.Millisecond
+	h := NewHarness(cfg)
+	defer h.Close()
+	enc := &blockingEncoder{started: make(chan struct{}, 1), release: make(chan struct{})}
+	h.Transcoder.Register("stub", AudioFormat{ContentType: "audio/x-stub", Bitrates: []int{64}, Encoder: enc})
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+
+	timeouts := expvarInt(transcodeStats, "timeouts")
+	if resp, _ := downloadAudio(t, h.URL+"/chunks/"+meta.ChunkID+"/audio?format=stub"); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected an encode past its timeout to fail, but got %d", resp.StatusCode)
+	}
+	if got := expvarInt(transcodeStats, "timeouts"); got != timeouts+1 {
+		t.Errorf("Expected the timeout counted, but got %d", got-timeouts)
+	}
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// Word is one transcribed word with its timing in milliseconds.
+type Word struct {
+	Text       string  `json:"text"`
+	StartMS    int     `json:"start_ms"`
+	EndMS      int     `json:"end_ms"`
+	Confidence float32 `json:"confidence"`
+}
+
+// spreadWords times words evenly across durationMS.
+func spreadWords(texts []string, durationMS int) []Word {
+	words := make([]Word, len(texts))
+	for i, text := range texts {
+		words[i] = Word{
+			Text:       text,
+			StartMS:    i * durationMS / len(texts),
+			EndMS:      (i + 1) * durationMS / len(texts),
+			Confidence: 1,
+		}
+	}
+	return words
+}
+
+// sessionTimeline merges the words and markers of a session's chunks onto
+// one timeline, offsetting each chunk by the duration of the chunks before
+// it.
+func sessionTimeline(chunks []Metadata) ([]Word, []Marker) {
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Timestamp.Before(chunks[j].Timestamp) })
+	var words []Word
+	var markers []Marker
+	offset := 0
+	for _, m := range chunks {
+		for _, w := range m.Words {
+			w.StartMS += offset
+			w.EndMS += offset
+			words = append(words, w)
+		}
+		for _, mk := range m.Markers {
+			mk.OffsetMS += int64(offset)
+			markers = append(markers, mk)
+		}
+		switch {
+		case m.DurationMS > 0:
+			offset += int(m.DurationMS)
+		case len(m.Words) > 0:
+			offset += m.Words[len(m.Words)-1].EndMS
+		}
+	}
+	return words, markers
+}
+
+// Subtitle cues follow common broadcast guidance: at most two lines of 42
+// characters, at most 7 seconds on screen, and a new cue after a pause.
+const (
+	maxLineChars  = 42
+	maxCueLines   = 2
+	maxCueMS      = 7000
+	maxCuePauseMS = 1500
+)
+
+type cue struct {
+	StartMS, EndMS int
+	Lines          []string
+}
+
+func buildCues(words []Word) []cue {
+	var cues []cue
+	var cur *cue
+	for _, w := range words {
+		if cur != nil && (w.StartMS-cur.EndMS > maxCuePauseMS || w.EndMS-cur.StartMS > maxCueMS) {
+			cur = nil
+		}
+		if cur != nil {
+			last := &cur.Lines[len(cur.Lines)-1]
+			switch {
+			case utf8.RuneCountInString(*last)+1+utf8.RuneCountInString(w.Text) <= maxLineChars:
+				*last += " " + w.Text
+			case len(cur.Lines) < maxCueLines:
+				cur.Lines = append(cur.Lines, w.Text)
+			default:
+				cur = nil
+			}
+			if cur != nil {
+				cur.EndMS = w.EndMS
+				continue
+			}
+		}
+		cues = append(cues, cue{StartMS: w.StartMS, EndMS: w.EndMS, Lines: []string{w.Text}})
+		cur = &cues[len(cues)-1]
+	}
+	return cues
+}
+
+// subtitleTime formats ms as HH:MM:SS followed by sep and milliseconds.
+func subtitleTime(ms int, sep string) string {
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+func writeSRT(w io.Writer, words []Word) {
+	for i, c := range buildCues(words) {
+		fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1, subtitleTime(c.StartMS, ","), subtitleTime(c.EndMS, ","), strings.Join(c.Lines, "\n"))
+	}
+}
+
+var (
+	vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	// vttNoteEscaper keeps a marker label on one line of a NOTE block,
+	// which must not contain "-->".
+	vttNoteEscaper = strings.NewReplacer("\n", " ", "\r", " ", "-->", "->")
+)
+
+// writeVTT writes markers as NOTE blocks ahead of the cues, where players
+// ignore them and scrubbers can pick them up.
+func writeVTT(w io.Writer, words []Word, markers []Marker) {
+	fmt.Fprint(w, "WEBVTT\n\n")
+	for _, m := range markers {
+		fmt.Fprintf(w, "NOTE marker %s %s\n\n", subtitleTime(int(m.OffsetMS), "."), vttNoteEscaper.Replace(m.Label))
+	}
+	for _, c := range buildCues(words) {
+		fmt.Fprintf(w, "%s --> %s\n%s\n\n", subtitleTime(c.StartMS, "."), subtitleTime(c.EndMS, "."), vttEscaper.Replace(strings.Join(c.Lines, "\n")))
+	}
+}
+
+var transcriptContentTypes = map[string]string{
+	"json": "application/json",
+	"srt":  "application/x-subrip",
+	"vtt":  "text/vtt",
+}
+
+func writeTranscript(w http.ResponseWriter, r *http.Request, text string, words []Word, markers []Marker) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	contentType, ok := transcriptContentTypes[format]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "unknown_format", "format must be json, srt or vtt")
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	switch format {
+	case "srt":
+		writeSRT(w, words)
+	case "vtt":
+		writeVTT(w, words, markers)
+	default:
+		if words == nil {
+			words = []Word{}
+		}
+		body := map[string]any{"text": text, "words": words}
+		if len(markers) > 0 {
+			body["markers"] = markers
+		}
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func handleGetTranscript(store Store, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		m, err := store.Get(id)
+		if err != nil {
+			writeChunkError(w, store, id, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, m.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		if m, err = withFullTranscript(store, m); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeTranscript(w, r, m.Transcript, m.Words, m.Markers)
+	}
+}
+
+func handleGetSessionTranscript(store Store, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := checkUserAccess(cfg, r, vars["user_id"]); err != nil {
+			writeError(w, err)
+			return
+		}
+		var chunks []Metadata
+		for _, m := range store.ListByUser(vars["user_id"]) {
+			if m.SessionID != vars["session_id"] {
+				continue
+			}
+			m, err := withFullTranscript(store, m)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			chunks = append(chunks, m)
+		}
+		if len(chunks) == 0 {
+			writeError(w, fmt.Errorf("session %s: %w", vars["session_id"], ErrNotFound))
+			return
+		}
+		words, markers := sessionTimeline(chunks)
+		texts := make([]string, len(chunks))
+		for i, m := range chunks {
+			texts[i] = m.Transcript
+		}
+		writeTranscript(w, r, strings.Join(texts, " "), words, markers)
+	}
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// goldenWords exercises line wrapping, the two-line limit, a pause, the
+// seven-second limit, escaping and timestamps past the hour.
+func goldenWords() []Word {
+	var words []Word
+	at := 0
+	add := func(text string, gap, length int) {
+		at += gap
+		words = append(words, Word{Text: text, StartMS: at, EndMS: at + length, Confidence: 0.9})
+		at += length
+	}
+	for _, text := range strings.Fields("The quick brown fox jumps over the lazy dog while the band plays on and on until dawn") {
+		add(text, 50, 250)
+	}
+	add("<pause>", 2000, 400)
+	add("&", 100, 300)
+	for i := 0; i < 12; i++ {
+		add("slow", 0, 900)
+	}
+	at = 3599500
+	add("hour", 0, 1000)
+	return words
+}
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		os.MkdirAll("testdata", 0o755)
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s mismatch (run with -update to accept):\n--- got ---\n%s\n--- want ---\n%s", name, got, want)
+	}
+}
+
+func TestSubtitleGolden(t *testing.T) {
+	var srt, vtt bytes.Buffer
+	writeSRT(&srt, goldenWords())
+	writeVTT(&vtt, goldenWords(), nil)
+	checkGolden(t, "transcript.srt.golden", srt.Bytes())
+	checkGolden(t, "transcript.vtt.golden", vtt.Bytes())
+}
+
+func TestSubtitleTime(t *testing.T) {
+	for ms, want := range map[int]string{
+		0:        "00:00:00,000",
+		999:      "00:00:00,999",
+		61001:    "00:01:01,001",
+		3600000:  "01:00:00,000",
+		86399999: "23:59:59,999",
+	} {
+		if got := subtitleTime(ms, ","); got != want {
+			t.Errorf("subtitleTime(%d): expected %s, but got %s", ms, want, got)
+		}
+	}
+}
+
+func TestSessionTranscriptMergesOffsets(t *testing.T) {
+	store := NewMemoryStore()
+	base := time.Now()
+	store.Save(Metadata{ChunkID: "b", UserID: "user1", SessionID: "s", Timestamp: base.Add(time.Second), DurationMS: 1000,
+		Transcript: "third fourth", Words: spreadWords([]string{"third", "fourth"}, 1000)})
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", SessionID: "s", Timestamp: base, DurationMS: 2000,
+		Transcript: "first second", Words: spreadWords([]string{"first", "second"}, 2000)})
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/sessions/user1/s/transcript", nil), map[string]string{"user_id": "user1", "session_id": "s"})
+	rr := httptest.NewRecorder()
+	handleGetSessionTranscript(store, DefaultConfig()).ServeHTTP(rr, req)
+	var got struct {
+		Text  string `json:"text"`
+		Words []Word `json:"words"`
+	}
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got.Text != "first second third fourth" || len(got.Words) != 4 {
+		t.Fatalf("Expected the chunks merged in order, but got %+v", got)
+	}
+	if w := got.Words[2]; w.Text != "third" || w.StartMS != 2000 || w.EndMS != 2500 {
+		t.Errorf("Expected the second chunk offset by the first one's duration, but got %+v", w)
+	}
+}
+
+func TestTranscriptEndpoint(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=sess1", "audio/wav", bytes.NewReader(SineWAV(440, 2*time.Second, 8000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	if len(meta.Words) != 2 || meta.Words[1].StartMS != 1000 || meta.Words[1].EndMS != 2000 {
+		t.Fatalf("Expected the stub to spread two words over 2s, but got %+v", meta.Words)
+	}
+
+	resp, err = http.Get(h.URL + "/chunks/" + meta.ChunkID + "/transcript?format=vtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	resp.Body.Close()
+	if want := "WEBVTT\n\n00:00:00.000 --> 00:00:02.000\nHello World\n\n"; body.String() != want {
+		t.Errorf("Expected %q, but got %q", want, body.String())
+	}
+
+	resp, err = http.Get(h.URL + "/chunks/" + meta.ChunkID + "/transcript?format=docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown format, but got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// transcriptPreviewBytes bounds the preview left on Metadata when a
+// transcript is stored out of line.
+const transcriptPreviewBytes = 1024
+
+// transcriptBlobSuffix names the blob holding a chunk's out-of-line
+// transcript. Chunk IDs never contain '/', so it cannot be another chunk's
+// audio.
+const transcriptBlobSuffix = "/transcript"
+
+func transcriptBlobID(chunkID string) string {
+	return chunkID + transcriptBlobSuffix
+}
+
+// storedTranscript is what a transcript blob holds.
+type storedTranscript struct {
+	Text  string `json:"text"`
+	Words []Word `json:"words,omitempty"`
+}
+
+// transcriptPreview cuts text to at most n bytes, at the last space if
+// there is one in the second half, so the preview does not end mid-word.
+func transcriptPreview(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	for n > 0 && !utf8.RuneStart(text[n]) {
+		n--
+	}
+	text = text[:n]
+	if i := strings.LastIndexByte(text, ' '); i > n/2 {
+		text = text[:i]
+	}
+	return text
+}
+
+// storeTranscriptLocked moves meta's transcript and words into the blob
+// store when together they take more than TranscriptInlineMax bytes,
+// leaving a preview behind, and drops the blob of an older version that
+// was out of line when meta is not. Metadata that already points at its
+// blob, as Get returns it, is left as it is. It returns the ID of the blob
+// it wrote or dropped, for the caller to log, or "". Callers hold s.mu.
+func (s *MemoryStore) storeTranscriptLocked(meta *Metadata, old Metadata) string {
+	id := transcriptBlobID(meta.ChunkID)
+	if meta.TranscriptBlob != "" {
+		return ""
+	}
+	if s.TranscriptInlineMax > 0 && len(meta.Transcript)+len(meta.Words)*minWordJSON > s.TranscriptInlineMax {
+		data, err := json.Marshal(storedTranscript{Text: meta.Transcript, Words: meta.Words})
+		if err == nil && len(data) > s.TranscriptInlineMax {
+			s.blobs[id] = data
+			s.blobSaved[id] = s.Clock.Now()
+			meta.Transcript = transcriptPreview(meta.Transcript, transcriptPreviewBytes)
+			meta.Words = nil
+			meta.Truncated = true
+			meta.TranscriptBlob = id
+			return id
+		}
+	}
+	if old.TranscriptBlob != "" {
+		delete(s.blobs, old.TranscriptBlob)
+		delete(s.blobSaved, old.TranscriptBlob)
+		return old.TranscriptBlob
+	}
+	return ""
+}
+
+// minWordJSON is the fewest bytes a Word encodes to, so a cheap lower bound
+// on a transcript's size can rule most of them out before encoding.
+const minWordJSON = len(`{"text":"","start_ms":0,"end_ms":0,"confidence":0},`)
+
+// blobReferencedLocked reports whether any metadata points at blob id: a
+// chunk's audio, or the transcript it keeps out of line. Callers hold s.mu.
+func (s *MemoryStore) blobReferencedLocked(id string) bool {
+	if owner, ok := strings.CutSuffix(id, transcriptBlobSuffix); ok {
+		m, found := s.lookupLocked(owner)
+		return found && m.TranscriptBlob == id
+	}
+	_, ok := s.lookupLocked(id)
+	return ok
+}
+
+// withFullTranscript returns m with the whole of its transcript and word
+// timings inline, reading them back from the blob store if they were kept
+// out of line.
+func withFullTranscript(store Store, m Metadata) (Metadata, error) {
+	if m.TranscriptBlob == "" {
+		return m, nil
+	}
+	data, err := store.GetBlob(m.TranscriptBlob)
+	if err != nil {
+		return m, fmt.Errorf("reading the transcript of chunk %s: %w", m.ChunkID, err)
+	}
+	var t storedTranscript
+	if err := json.Unmarshal(data, &t); err != nil {
+		return m, fmt.Errorf("decoding the transcript of chunk %s: %w", m.ChunkID, err)
+	}
+	m.Transcript, m.Words = t.Text, t.Words
+	m.Truncated, m.TranscriptBlob = false, ""
+	return m, nil
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// longTranscriber transcribes every chunk as about 2MB of words.
+type longTranscriber struct{}
+
+func (longTranscriber) Transcribe(ctx context.Context, chunk AudioChunk) (Transcription, error) {
+	texts := strings.Fields(strings.Repeat("lorem ipsum dolor sit amet ", 75000))
+	return Transcription{Text: strings.Join(texts, " "), Words: spreadWords(texts[:20000], 600000)}, nil
+}
+
+func TestLargeTranscriptsStoredOutOfLine(t *testing.T) {
+	h := NewHarness(exportConfig(t))
+	defer h.Close()
+	h.Pipeline.Transcriber = longTranscriber{}
+	full, _ := longTranscriber{}.Transcribe(context.Background(), AudioChunk{})
+	meta := uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+
+	resp, err := http.Get(h.URL + "/sessions/user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var listed []Metadata
+	json.Unmarshal(body, &listed)
+	if len(body) > 16<<10 || len(listed) != 1 || !listed[0].Truncated || listed[0].Words != nil {
+		t.Fatalf("Expected a small listing marked truncated, but got %d bytes: %.300s", len(body), body)
+	}
+	if preview := listed[0].Transcript; preview == "" || len(preview) > transcriptPreviewBytes || !strings.HasPrefix(full.Text, preview) {
+		t.Errorf("Expected the opening of the transcript as a preview, but got %d bytes", len(preview))
+	}
+	if stored, _ := h.Store.Get(meta.ChunkID); len(stored.Transcript) > transcriptPreviewBytes {
+		t.Errorf("Expected the store to hold only the preview inline, but it holds %d bytes", len(stored.Transcript))
+	}
+
+	resp, err = http.Get(h.URL + "/chunks/" + meta.ChunkID + "/transcript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transcript struct {
+		Text  string `json:"text"`
+		Words []Word `json:"words"`
+	}
+	json.NewDecoder(resp.Body).Decode(&transcript)
+	resp.Body.Close()
+	if transcript.Text != full.Text || len(transcript.Words) != len(full.Words) {
+		t.Errorf("Expected the whole transcript, but got %d bytes and %d words", len(transcript.Text), len(transcript.Words))
+	}
+
+	_, key := h.Keys.Mint("user1", nil, 0)
+	_, started := exportRequestAs(t, h, "POST", "/users/user1/export", key, `{}`)
+	st := waitExport(t, h, "user1", started.ID, key)
+	resp, err = http.Get(h.URL + st.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	var exported Metadata
+	json.NewDecoder(bytes.NewReader(readZip(t, data)["metadata.ndjson"])).Decode(&exported)
+	if exported.Transcript != full.Text || exported.Truncated || exported.TranscriptBlob != "" {
+		t.Errorf("Expected the export to inline the whole transcript, but got %d bytes", len(exported.Transcript))
+	}
+}
+
+func TestTranscriptBlobLifecycle(t *testing.T) {
+	store := NewMemoryStore()
+	store.TranscriptInlineMax = 0
+	long := strings.Repeat("word ", 1000)
+	store.Save(Metadata{ChunkID: "c1", UserID: "user1", SessionID: "s1", Transcript: long})
+	if m, _ := store.Get("c1"); m.Truncated || m.Transcript != long {
+		t.Fatalf("Expected transcripts inline with no limit, but got %+v", m)
+	}
+
+	// Records saved inline move out of line on their next save.
+	store.TranscriptInlineMax = 1000
+	m, _ := store.Get("c1")
+	store.Save(m)
+	m, _ = store.Get("c1")
+	if !m.Truncated || m.TranscriptBlob != transcriptBlobID("c1") || len(m.Transcript) > transcriptPreviewBytes {
+		t.Fatalf("Expected the transcript moved out of line, but got %+v", m)
+	}
+	if full, err := withFullTranscript(store, m); err != nil || full.Transcript != long {
+		t.Errorf("Expected the whole transcript back, but got %d bytes %v", len(full.Transcript), err)
+	}
+	store.Save(m)
+	if full, _ := withFullTranscript(store, m); full.Transcript != long {
+		t.Errorf("Expected saving what Get returned to keep the transcript, but got %d bytes", len(full.Transcript))
+	}
+	if report := store.reconcileCandidates(time.Now().Add(time.Hour)); len(report.OrphanBlobs) != 0 {
+		t.Errorf("Expected the transcript blob not to count as an orphan, but got %v", report.OrphanBlobs)
+	}
+
+	m.Transcript, m.Truncated, m.TranscriptBlob = "short", false, ""
+	store.Save(m)
+	if _, err := store.GetBlob(transcriptBlobID("c1")); err == nil {
+		t.Errorf("Expected the blob dropped once the transcript fits inline")
+	}
+}
+
+func TestTranscriptPreview(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"one two three four", 12, "one two"},
+		{"onetwothreefour", 7, "onetwot"},
+		{"héllo", 2, "h"},
+	} {
+		if got := transcriptPreview(tc.text, tc.n); got != tc.want {
+			t.Errorf("%q to %d bytes: expected %q, but got %q", tc.text, tc.n, tc.want, got)
+		}
+	}
+}
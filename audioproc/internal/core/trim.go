@@ -0,0 +1,104 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// trimRequest is the body of POST /chunks/{id}/trim: the span to keep, in
+// milliseconds from the start of the source.
+type trimRequest struct {
+	StartMS int64 `json:"start_ms"`
+	EndMS   int64 `json:"end_ms"`
+}
+
+// trimChunk cuts [startMS, endMS) out of source's audio as a new chunk
+// derived from it. Cuts fall on the nearest earlier sample, and the piece
+// is re-encoded as mono 16-bit WAV, so the same trim of the same source
+// always yields the same bytes.
+func trimChunk(source Metadata, data []byte, req trimRequest) (AudioChunk, error) {
+	pcm, err := decodeAudio(data)
+	if err != nil {
+		return AudioChunk{}, invalidField("audio", "undecodable_audio", "the chunk's audio cannot be decoded for trimming")
+	}
+	durationMS := int64(len(pcm.Samples)) * 1000 / int64(pcm.SampleRate)
+	switch {
+	case req.StartMS < 0:
+		return AudioChunk{}, invalidField("start_ms", "invalid_trim", "start_ms must not be negative")
+	case req.EndMS <= req.StartMS:
+		return AudioChunk{}, invalidField("end_ms", "invalid_trim", "end_ms must be after start_ms")
+	case req.EndMS > durationMS:
+		return AudioChunk{}, invalidField("end_ms", "invalid_trim", fmt.Sprintf("%d is beyond the chunk's %dms duration", req.EndMS, durationMS))
+	}
+	from := int(req.StartMS * int64(pcm.SampleRate) / 1000)
+	to := int(req.EndMS * int64(pcm.SampleRate) / 1000)
+	samples := make([]int16, to-from)
+	for i, s := range pcm.Samples[from:to] {
+		samples[i] = int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, s*32768)))
+	}
+
+	chunk := AudioChunk{
+		ChunkID:     uuid.New().String(),
+		UserID:      source.UserID,
+		SessionID:   source.SessionID,
+		Timestamp:   time.Now(),
+		Data:        EncodeWAV(samples, pcm.SampleRate),
+		DerivedFrom: source.ChunkID,
+		OffsetMS:    req.StartMS,
+		Priority:    PriorityInteractive,
+		Markers:     markersBetween(source.Markers, req.StartMS, req.EndMS),
+	}
+	if source.RecordedAt != nil {
+		at := source.RecordedAt.Add(time.Duration(req.StartMS) * time.Millisecond)
+		chunk.RecordedAt = &at
+	}
+	return chunk, nil
+}
+
+// handleTrimChunk saves a span of a chunk's audio as a new chunk, run
+// through the pipeline like an upload, and returns its metadata.
+func handleTrimChunk(store *MemoryStore, jobs chan<- Job, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		source, err := store.Get(id)
+		if err != nil {
+			writeChunkError(w, store, id, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, source.UserID); err != nil {
+			writeError(w, err)
+			return
+		}
+		var req trimRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_trim", "body must be {\"start_ms\": ..., \"end_ms\": ...}")
+			return
+		}
+		data, err := store.GetBlob(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		chunk, err := trimChunk(source, data, req)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		// A trim is not new activity in the session, so it is not tracked.
+		meta, err := ingest(r.Context(), store, jobs, nil, cfg.AudioRules, chunk)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Location", "/chunks/"+meta.ChunkID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(meta)
+	}
+}
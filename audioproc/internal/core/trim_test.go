@@ -0,0 +1,63 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func postTrim(t *testing.T, h *Harness, id string, body string) (int, Metadata) {
+	t.Helper()
+	resp, err := http.Post(h.URL+"/chunks/"+id+"/trim", "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var m Metadata
+	json.NewDecoder(resp.Body).Decode(&m)
+	return resp.StatusCode, m
+}
+
+func TestTrimChunk(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	wav := SineWAV(440, time.Second, 8000)
+	source := uploadTo(t, h, "user1", "s1", wav)
+
+	status, child := postTrim(t, h, source.ChunkID, `{"start_ms": 250, "end_ms": 750}`)
+	if status != http.StatusCreated || child.DerivedFrom != source.ChunkID || child.OffsetMS != 250 || child.DurationMS != 500 || child.Transcript == "" {
+		t.Fatalf("Expected a 500ms chunk derived from the source, but got %v %+v", status, child)
+	}
+	data, err := h.Store.GetBlob(child.ChunkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := decodeAudio(data)
+	want, _ := decodeAudio(wav)
+	if !slices.Equal(got.Samples, want.Samples[2000:6000]) {
+		t.Errorf("Expected exactly samples 2000 to 6000 of the source")
+	}
+	if _, again := postTrim(t, h, source.ChunkID, `{"start_ms": 250, "end_ms": 750}`); again.Checksum != child.Checksum || again.ChunkID == child.ChunkID {
+		t.Errorf("Expected the same trim to give a new chunk with the same checksum, but got %s and %s", child.Checksum, again.Checksum)
+	}
+
+	for _, body := range []string{`{"start_ms": 500, "end_ms": 1001}`, `{"start_ms": 500, "end_ms": 500}`, `{"start_ms": -1, "end_ms": 10}`} {
+		if status, _ := postTrim(t, h, source.ChunkID, body); status != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected 422, but got %v", body, status)
+		}
+	}
+	if status, _ := postTrim(t, h, "missing", `{"start_ms": 0, "end_ms": 10}`); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown chunk, but got %v", status)
+	}
+
+	// Derived chunks outlive their source.
+	if err := h.Store.Delete(source.ChunkID); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := h.Store.Get(child.ChunkID); err != nil || m.DerivedFrom != source.ChunkID {
+		t.Errorf("Expected the derived chunk to survive its source, but got %+v %v", m, err)
+	}
+}
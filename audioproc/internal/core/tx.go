@@ -0,0 +1,206 @@
+package core
+
+// Transactional is implemented by stores that can apply several writes as
+// one. WithTx runs fn against a view of the store: if fn returns nil its
+// writes are applied together, and if it returns an error none of them
+// are. Reads through tx see the transaction's own writes.
+type Transactional interface {
+	WithTx(fn func(tx Store) error) error
+}
+
+// withTx runs fn in a transaction when store supports them. Other stores
+// get fn applied directly, so a failure part way leaves earlier writes in
+// place.
+func withTx(store Store, fn func(tx Store) error) error {
+	if ts, ok := store.(Transactional); ok {
+		return ts.WithTx(fn)
+	}
+	return fn(store)
+}
+
+var _ Transactional = (*MemoryStore)(nil)
+
+// WithTx holds the store lock for the whole of fn, so fn must only use tx
+// and never s itself. Writes are applied as they happen and undone on
+// error; change notifications are held back until commit.
+func (s *MemoryStore) WithTx(fn func(tx Store) error) error {
+	return s.withTx(func(tx *memTx) error { return fn(tx) })
+}
+
+func (s *MemoryStore) withTx(fn func(tx *memTx) error) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := &memTx{s: s, kept: make(map[string]bool)}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.rollback()
+		return err
+	}
+	for _, id := range tx.blobs {
+		s.logBlobLocked(id)
+		s.chargeLocked(id)
+	}
+	for _, e := range tx.entries {
+		s.logEntryLocked(e.table, e.key, e.v)
+	}
+	for _, c := range tx.changes {
+		s.changedLocked(c.op, c.id)
+	}
+	return nil
+}
+
+// memTx is a MemoryStore transaction. It runs under the store lock and
+// keeps an undo entry for every record it touches.
+type memTx struct {
+	s       *MemoryStore
+	undo    []func()
+	kept    map[string]bool
+	changes []struct{ op, id string }
+	// blobs lists the blobs written, and entries the other bookkeeping,
+	// which are logged on commit.
+	blobs   []string
+	entries []txEntry
+}
+
+type txEntry struct {
+	table, key string
+	v          any
+}
+
+var _ Store = (*memTx)(nil)
+
+// keep records how a chunk looked before the transaction first touched it.
+func (tx *memTx) keep(id string) {
+	if tx.kept[id] {
+		return
+	}
+	tx.kept[id] = true
+	s := tx.s
+	meta, hasMeta := s.metadata[id]
+	raw, hasRaw := s.legacy[id]
+	blob, hasBlob := s.blobs[id]
+	saved, hasSaved := s.blobSaved[id]
+	tid := transcriptBlobID(id)
+	transcript, hasTranscript := s.blobs[tid]
+	transcriptSaved, hasTranscriptSaved := s.blobSaved[tid]
+	revs, hasRevs := s.revisions[id]
+	tx.undo = append(tx.undo, func() {
+		if cur, ok := s.lookupLocked(id); ok {
+			s.unindexLocked(cur)
+		}
+		restoreEntry(s.metadata, id, meta, hasMeta)
+		restoreEntry(s.legacy, id, raw, hasRaw)
+		restoreEntry(s.blobs, id, blob, hasBlob)
+		restoreEntry(s.blobSaved, id, saved, hasSaved)
+		restoreEntry(s.blobs, tid, transcript, hasTranscript)
+		restoreEntry(s.blobSaved, tid, transcriptSaved, hasTranscriptSaved)
+		restoreEntry(s.revisions, id, revs, hasRevs)
+		if old, ok := s.lookupLocked(id); ok {
+			s.indexLocked(old)
+		}
+	})
+}
+
+// logEntry logs, on commit, that table holds v under key.
+func (tx *memTx) logEntry(table, key string, v any) {
+	tx.entries = append(tx.entries, txEntry{table, key, v})
+}
+
+func (tx *memTx) changed(op, id string) {
+	tx.changes = append(tx.changes, struct{ op, id string }{op, id})
+}
+
+func (tx *memTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+}
+
+func restoreEntry[V any](m map[string]V, k string, v V, ok bool) {
+	if ok {
+		m[k] = v
+	} else {
+		delete(m, k)
+	}
+}
+
+func (tx *memTx) Get(id string) (Metadata, error) {
+	return tx.s.getLocked(id)
+}
+
+func (tx *memTx) Save(meta Metadata) error {
+	return tx.saveAs(meta, "")
+}
+
+func (tx *memTx) Delete(id string) error {
+	tx.keep(id)
+	if err := tx.s.deleteLocked(id); err != nil {
+		return err
+	}
+	tx.changed(changeDelete, id)
+	return nil
+}
+
+func (tx *memTx) Restore(id string) (Metadata, error) {
+	tx.keep(id)
+	m, err := tx.s.restoreLocked(id)
+	if err != nil {
+		return Metadata{}, err
+	}
+	tx.changed(changeRestore, id)
+	return m, nil
+}
+
+func (tx *memTx) ListByUser(userID string) []Metadata {
+	return tx.s.listByUserLocked(userID, false)
+}
+
+func (tx *memTx) List(match func(Metadata) bool) []Metadata {
+	return tx.s.listLocked(match)
+}
+
+func (tx *memTx) SaveBlob(id string, data []byte) error {
+	tx.keep(id)
+	tx.s.blobs[id] = data
+	tx.s.blobSaved[id] = tx.s.Clock.Now()
+	tx.blobs = append(tx.blobs, id)
+	return nil
+}
+
+func (tx *memTx) GetBlob(id string) ([]byte, error) {
+	data, ok := tx.s.blobs[id]
+	m, _ := tx.s.lookupLocked(id)
+	return tx.s.blobOrArchive(id, data, ok, m)
+}
+
+// Changes only shows committed changes; the transaction's own appear once
+// it commits.
+func (tx *memTx) Changes(since int64, limit int) ChangePage {
+	return tx.s.changesLocked(since, limit)
+}
+
+func (tx *memTx) UserSettings(userID string) (UserSettings, error) {
+	return tx.s.settings[userID], nil
+}
+
+func (tx *memTx) SaveUserSettings(userID string, settings UserSettings) error {
+	s := tx.s
+	old, ok := s.settings[userID]
+	tx.undo = append(tx.undo, func() { restoreEntry(s.settings, userID, old, ok) })
+	s.settings[userID] = settings
+	tx.logEntry(walSettings, userID, settings)
+	return nil
+}
+
+func (tx *memTx) SaveReprocessCheckpoint(st ReprocessStatus) {
+	s := tx.s
+	old, ok := s.checkpoints[st.ID]
+	tx.undo = append(tx.undo, func() { restoreEntry(s.checkpoints, st.ID, old, ok) })
+	s.checkpoints[st.ID] = st
+	tx.logEntry(walCheckpoints, st.ID, st)
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMemoryStoreTxAllOrNothing(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(Metadata{ChunkID: "a", UserID: "user1", Transcript: "old"})
+	store.SaveBlob("a", []byte("old audio"))
+	before := store.Changes(0, 100).Cursor
+
+	boom := errors.New("boom")
+	err := store.WithTx(func(tx Store) error {
+		tx.Save(Metadata{ChunkID: "a", UserID: "user1", Transcript: "new"})
+		tx.SaveBlob("a", []byte("new audio"))
+		tx.Save(Metadata{ChunkID: "b", UserID: "user1"})
+		if m, err := tx.Get("b"); err != nil || m.ChunkID != "b" {
+			t.Errorf("Expected the transaction to see its own writes, but got %+v, %v", m, err)
+		}
+		if err := tx.Delete("a"); err != nil {
+			t.Errorf("Delete error: %v", err)
+		}
+		return boom
+	})
+	if err != boom {
+		t.Fatalf("Expected the transaction error back, but got %v", err)
+	}
+	if m, err := store.Get("a"); err != nil || m.Transcript != "old" {
+		t.Errorf("Expected a to be rolled back, but got %+v, %v", m, err)
+	}
+	if data, _ := store.GetBlob("a"); string(data) != "old audio" {
+		t.Errorf("Expected the blob to be rolled back, but got %q", data)
+	}
+	if _, err := store.Get("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected b to be discarded, but got %v", err)
+	}
+	if page := store.Changes(before, 100); len(page.Changes) != 0 {
+		t.Errorf("Expected no changes from a rolled back transaction, but got %+v", page.Changes)
+	}
+
+	err = store.WithTx(func(tx Store) error {
+		tx.Save(Metadata{ChunkID: "b", UserID: "user1"})
+		return tx.Delete("a")
+	})
+	if err != nil {
+		t.Fatalf("WithTx error: %v", err)
+	}
+	if n := len(store.ListByUser("user1")); n != 1 {
+		t.Errorf("Expected the committed writes to apply, but got %d chunks", n)
+	}
+	if page := store.Changes(before, 100); len(page.Changes) != 2 || page.Changes[1].Op != changeDelete {
+		t.Errorf("Expected both changes once committed, but got %+v", page.Changes)
+	}
+}
+
+// flakyStore fails deletes of one chunk inside transactions.
+type flakyStore struct {
+	*MemoryStore
+	failID string
+}
+
+func (f flakyStore) WithTx(fn func(tx Store) error) error {
+	return f.MemoryStore.WithTx(func(tx Store) error { return fn(flakyTx{tx, f.failID}) })
+}
+
+type flakyTx struct {
+	Store
+	failID string
+}
+
+func (f flakyTx) Delete(id string) error {
+	if id == f.failID {
+		return newKindError(ErrBackendUnavailable, "disk full")
+	}
+	return f.Store.Delete(id)
+}
+
+func TestDeleteSessionIsAtomic(t *testing.T) {
+	store := NewMemoryStore()
+	for _, id := range []string{"c1", "c2", "c3"} {
+		store.Save(Metadata{ChunkID: id, UserID: "user1", SessionID: "s"})
+	}
+	store.Save(Metadata{ChunkID: "other", UserID: "user1", SessionID: "t"})
+	deleteSession := func(s Store) *httptest.ResponseRecorder {
+		r := mux.NewRouter()
+		r.HandleFunc("/sessions/{user_id}/{session_id}", handleDeleteSession(s, DefaultConfig())).Methods("DELETE")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("DELETE", "/sessions/user1/s", nil))
+		return rr
+	}
+
+	if rr := deleteSession(flakyStore{store, "c2"}); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when a delete fails, but got %v %s", rr.Code, rr.Body)
+	}
+	if n := len(store.ListByUser("user1")); n != 4 {
+		t.Errorf("Expected no chunk to be deleted after a failure, but %d remain", n)
+	}
+
+	rr := deleteSession(store)
+	var body map[string]int
+	json.NewDecoder(rr.Body).Decode(&body)
+	if rr.Code != http.StatusOK || body["deleted"] != 3 {
+		t.Errorf("Expected 3 chunks deleted, but got %v %v", rr.Code, body)
+	}
+	if left := store.ListByUser("user1"); len(left) != 1 || left[0].ChunkID != "other" {
+		t.Errorf("Expected only the other session to remain, but got %+v", left)
+	}
+	if rr := deleteSession(store); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an empty session, but got %v", rr.Code)
+	}
+}
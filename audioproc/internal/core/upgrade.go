@@ -0,0 +1,77 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+var wsRefusals = expvar.NewMap("ws_upgrade_refusals")
+
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "message": message})
+}
+
+// originAllowed applies the configured origin policy. Requests without an
+// Origin header come from non-browser clients and are always allowed; with
+// no allowlist configured only same-host origins pass.
+func originAllowed(cfg Config, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || cfg.AllowAllOrigins {
+		return true
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && len(cfg.AllowedOrigins) == 0 && strings.EqualFold(u.Host, r.Host)
+}
+
+// apiKeyFromRequest reads a key from "Authorization: Bearer" or, for browser
+// WebSocket clients that cannot set headers, the api_key query parameter.
+func apiKeyFromRequest(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+func newUpgrader(cfg Config) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return originAllowed(cfg, r) },
+	}
+}
+
+// checkHandshake refuses a WebSocket handshake before upgrading, writing a
+// JSON error and recording the reason. Sockets that write, as /ws does with
+// the chunks streamed over it, refuse read-only keys. It returns the request
+// carrying the key's user, as requireAuth does for other routes, or false if
+// the request was refused.
+func checkHandshake(cfg Config, keys *KeyRing, write bool, w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if !originAllowed(cfg, r) {
+		wsRefusals.Add("origin", 1)
+		writeJSONError(w, http.StatusForbidden, "origin_not_allowed", "origin "+r.Header.Get("Origin")+" is not allowed")
+		return nil, false
+	}
+	key, ok := keys.Authenticate(r)
+	if !ok {
+		wsRefusals.Add("auth", 1)
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "missing or invalid API key")
+		return nil, false
+	}
+	if write && !key.allows(http.MethodPost) {
+		wsRefusals.Add("scope", 1)
+		writeJSONError(w, http.StatusForbidden, "insufficient_scope", "API key is read-only")
+		return nil, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), userIDKey, key.UserID)), true
+}
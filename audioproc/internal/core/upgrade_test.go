@@ -0,0 +1,102 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketHandshake(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowedOrigins = []string{"https://app.example.com"}
+	cfg.APIKeys = map[string]string{"key1": "user1"}
+	h := NewHarness(cfg)
+	defer h.Close()
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "allowed origin",
+			header:     http.Header{"Origin": {"https://app.example.com"}, "Authorization": {"Bearer key1"}},
+			wantStatus: http.StatusSwitchingProtocols,
+		},
+		{
+			name:       "blocked origin",
+			header:     http.Header{"Origin": {"https://evil.example.com"}, "Authorization": {"Bearer key1"}},
+			wantStatus: http.StatusForbidden,
+			wantError:  "origin_not_allowed",
+		},
+		{
+			name:       "missing credentials",
+			header:     http.Header{"Origin": {"https://app.example.com"}},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "unauthorized",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := websocket.DefaultDialer.Dial(h.WSURL("/ws"), tt.header)
+			if conn != nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatalf("Expected a handshake response, but got error %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Expected status code %v, but got %v", tt.wantStatus, resp.StatusCode)
+			}
+			if tt.wantError == "" {
+				return
+			}
+			var body map[string]string
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode refusal body: %v", err)
+			}
+			if body["error"] != tt.wantError {
+				t.Errorf("Expected error %v, but got %v", tt.wantError, body["error"])
+			}
+		})
+	}
+
+	if got := wsRefusals.Get("origin"); got == nil || got.String() == "0" {
+		t.Errorf("Expected origin refusals to be counted")
+	}
+}
+
+func TestWebSocketHandshakeScope(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.APIKeys = map[string]string{"key1": "user1"}
+	h := NewHarness(cfg)
+	defer h.Close()
+	_, readOnly := h.Keys.Mint("user1", []string{scopeRead}, 0)
+	header := http.Header{"Authorization": {"Bearer " + readOnly}}
+
+	for path, want := range map[string]int{
+		"/ws?user_id=user1&session_id=s1":            http.StatusForbidden,
+		"/sessions/user1/s1/observe":                 http.StatusSwitchingProtocols,
+		"/ws/transcript?user_id=user1&session_id=s1": http.StatusSwitchingProtocols,
+	} {
+		conn, resp, err := websocket.DefaultDialer.Dial(h.WSURL(path), header)
+		if conn != nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatalf("%s: expected a handshake response, but got error %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: expected status code %d for a read-only key, but got %d", path, want, resp.StatusCode)
+		}
+	}
+	if got := wsRefusals.Get("scope"); got == nil || got.String() == "0" {
+		t.Errorf("Expected scope refusals to be counted")
+	}
+}
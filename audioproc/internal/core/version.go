@@ -0,0 +1,161 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"sync"
+)
+
+// Build details, set when linking:
+//
+//	go build -ldflags "-X github.com/Kundhavi2798/audio-processor/audioproc/internal/core.Version=1.4.0 \
+//		-X github.com/Kundhavi2798/audio-processor/audioproc/internal/core.Commit=$(git rev-parse HEAD) \
+//		-X github.com/Kundhavi2798/audio-processor/audioproc/internal/core.BuildDate=$(date -u +%FT%TZ)"
+//
+// Commit and BuildDate fall back to what the Go toolchain stamped into the
+// binary from version control.
+var (
+	Version   = "dev"
+	Commit    string
+	BuildDate string
+)
+
+// apiVersion is the version of the HTTP API, as in /openapi.json. It
+// changes only when a change would break existing clients.
+const apiVersion = "1"
+
+// BuildInfo identifies the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var readBuildInfo = sync.OnceValue(func() BuildInfo {
+	b := BuildInfo{Version: Version, Commit: Commit, BuildDate: BuildDate}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	b.GoVersion = info.GoVersion
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && b.Commit == "":
+			b.Commit = s.Value
+		case s.Key == "vcs.time" && b.BuildDate == "":
+			b.BuildDate = s.Value
+		}
+	}
+	return b
+})
+
+// CurrentBuild returns the running binary's build details.
+func CurrentBuild() BuildInfo {
+	return readBuildInfo()
+}
+
+// Capabilities describes what a deployment offers, built from its live
+// configuration so clients can adapt to it rather than assume.
+type Capabilities struct {
+	APIVersion string `json:"api_version"`
+	// Features maps each optional feature to whether it is on.
+	Features map[string]bool `json:"features"`
+	Limits   Limits          `json:"limits"`
+	Formats  FormatSupport   `json:"formats"`
+}
+
+// Limits are the bounds a client can run into; zero means unlimited.
+type Limits struct {
+	MaxChunkDurationMS     int64          `json:"max_chunk_duration_ms"`
+	MaxAudioDurationMS     int64          `json:"max_audio_duration_ms"`
+	MinUploadBytes         int            `json:"min_upload_bytes"`
+	MaxClientMetadataBytes int            `json:"max_client_metadata_bytes"`
+	MaxConnectBodyBytes    int64          `json:"max_connect_body_bytes"`
+	MaxConcurrentRequests  int            `json:"max_concurrent_requests"`
+	RouteConcurrency       map[string]int `json:"route_concurrency,omitempty"`
+	MaxStreams             int            `json:"max_streams"`
+	QuotaBytes             int64          `json:"quota_bytes"`
+	QuotaChunks            int            `json:"quota_chunks"`
+	UploadTimeoutMS        int64          `json:"upload_timeout_ms"`
+}
+
+// FormatSupport lists the formats accepted on upload and offered on
+// download. Download formats map to the bitrates they offer in kbps.
+type FormatSupport struct {
+	Upload      []string         `json:"upload"`
+	Download    map[string][]int `json:"download"`
+	Transcripts []string         `json:"transcripts"`
+}
+
+// capabilities builds the document from s's configuration and the state
+// that can change while it runs, such as read-only mode.
+func (s *Server) capabilities() Capabilities {
+	cfg := s.Config
+	upload := cfg.AudioRules.Formats
+	if len(upload) == 0 {
+		for _, f := range audioFormats {
+			upload = append(upload, f.name)
+		}
+		upload = append(upload, audioFormatUnknown)
+	}
+	transcripts := make([]string, 0, len(transcriptContentTypes))
+	for f := range transcriptContentTypes {
+		transcripts = append(transcripts, f)
+	}
+	slices.Sort(transcripts)
+	download := s.Transcoder.offered()
+	return Capabilities{
+		APIVersion: apiVersion,
+		Features: map[string]bool{
+			"transcription":         cfg.Transcribe,
+			"normalization":         cfg.Normalize,
+			"search":                true,
+			"similarity_search":     cfg.FingerprintThreshold > 0,
+			"idempotent_chunk_ids":  true,
+			"checksum_verification": cfg.VerifyChecksums,
+			"connect_rpc":           true,
+			"h2c":                   cfg.H2C,
+			"compression":           cfg.Compress,
+			"transcoding":           len(download) > 0,
+			"chunk_splitting":       cfg.MaxChunkDuration > 0,
+			"ordered_sessions":      cfg.OrderedSessions,
+			"strict_sessions":       cfg.StrictSessions,
+			"receipts":              len(cfg.ReceiptKeys) > 0,
+			"archive":               cfg.ArchiveAfter > 0,
+			"auto_export":           cfg.AutoExportSchedule != nil,
+			"mqtt":                  cfg.MQTTBroker != "",
+			"durable_store":         cfg.WALDir != "",
+			"read_only":             s.ReadOnly.Enabled(),
+			"swagger_ui":            cfg.SwaggerUI,
+		},
+		Limits: Limits{
+			MaxChunkDurationMS:     cfg.MaxChunkDuration.Milliseconds(),
+			MaxAudioDurationMS:     cfg.AudioRules.MaxDuration.Milliseconds(),
+			MinUploadBytes:         cfg.AudioRules.MinBytes,
+			MaxClientMetadataBytes: cfg.MaxClientMetadataBytes,
+			MaxConnectBodyBytes:    connectMaxBodyBytes,
+			MaxConcurrentRequests:  cfg.MaxConcurrentRequests,
+			RouteConcurrency:       cfg.RouteConcurrency,
+			MaxStreams:             cfg.MaxStreams,
+			QuotaBytes:             cfg.QuotaBytes,
+			QuotaChunks:            cfg.QuotaChunks,
+			UploadTimeoutMS:        cfg.UploadTimeout.Milliseconds(),
+		},
+		Formats: FormatSupport{Upload: upload, Download: download, Transcripts: transcripts},
+	}
+}
+
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CurrentBuild())
+}
+
+func handleCapabilities(s *Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.capabilities())
+	}
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+)
+
+func getCapabilities(t *testing.T, h *Harness) Capabilities {
+	t.Helper()
+	resp, err := http.Get(h.URL + "/capabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var caps Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		t.Fatalf("Failed to decode capabilities: %v", err)
+	}
+	return caps
+}
+
+func TestVersion(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	defer h.Close()
+	resp, err := http.Get(h.URL + "/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info BuildInfo
+	json.NewDecoder(resp.Body).Decode(&info)
+	if resp.StatusCode != http.StatusOK || info.Version != Version || info.GoVersion == "" {
+		t.Errorf("Expected the build info, but got %d %+v", resp.StatusCode, info)
+	}
+}
+
+func TestCapabilitiesFollowConfig(t *testing.T) {
+	h := NewHarness(DefaultConfig())
+	caps := getCapabilities(t, h)
+	h.Close()
+	if caps.APIVersion != apiVersion || !caps.Features["compression"] || caps.Features["transcoding"] || len(caps.Formats.Download) != 0 {
+		t.Errorf("Expected compression on and transcoding off by default, but got %+v", caps)
+	}
+	if !slices.Equal(caps.Formats.Upload, []string{"wav", "flac", audioFormatUnknown}) || !slices.Equal(caps.Formats.Transcripts, []string{"json", "srt", "vtt"}) {
+		t.Errorf("Expected every format, but got %+v", caps.Formats)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Compress = false
+	cfg.FFmpegPath = "ffmpeg"
+	cfg.QuotaChunks = 500
+	cfg.AudioRules.Formats = []string{"flac"}
+	h = NewHarness(cfg)
+	defer h.Close()
+	caps = getCapabilities(t, h)
+	if caps.Features["compression"] || !caps.Features["transcoding"] {
+		t.Errorf("Expected compression off and transcoding on, but got %v", caps.Features)
+	}
+	if got := caps.Formats.Download["mp3"]; len(got) == 0 || got[0] != 128 || caps.Formats.Download["opus"] == nil {
+		t.Errorf("Expected mp3 and opus downloads, but got %v", caps.Formats.Download)
+	}
+	if caps.Limits.QuotaChunks != 500 || !slices.Equal(caps.Formats.Upload, []string{"flac"}) {
+		t.Errorf("Expected the configured limits, but got %+v %v", caps.Limits, caps.Formats.Upload)
+	}
+
+	h.ReadOnly.Set(true, "")
+	if !getCapabilities(t, h).Features["read_only"] {
+		t.Errorf("Expected read-only mode to show as soon as it is switched on")
+	}
+}
+
+func TestClientFetchesCapabilities(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OrderedSessions = true
+	h := NewHarness(cfg)
+	defer h.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := client.Dial(ctx, h.WSURL("/ws"), "user1", "s1")
+	if err != nil {
+		t.Fatalf("Dial error: %v", err)
+	}
+	defer c.Close()
+	if !c.Capabilities.Has("ordered_sessions") || c.Capabilities.Has("mqtt") || c.Capabilities.APIVersion != apiVersion {
+		t.Errorf("Expected the server's capabilities on connect, but got %+v", c.Capabilities)
+	}
+}
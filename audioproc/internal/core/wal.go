@@ -0,0 +1,682 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WAL sync policies; see Config.WALSync.
+const (
+	WALSyncAlways   = "always"
+	WALSyncInterval = "interval"
+	WALSyncNever    = "never"
+)
+
+// The files kept in Config.WALDir. The snapshot is only ever replaced
+// whole, by renaming a finished walSnapshotTemp over it, and the log by
+// renaming walLogTemp over it. Blob contents live in walBlobDir, each in a
+// file named for its SHA-256, and records only refer to them.
+const (
+	walLogFile      = "wal.log"
+	walLogTemp      = "wal.log.tmp"
+	walSnapshotFile = "snapshot.log"
+	walSnapshotTemp = "snapshot.log.tmp"
+	walBlobDir      = "blobs"
+	walBlobTemp     = ".tmp-"
+)
+
+// walHeaderSize is the length and CRC-32 that precede each record.
+const walHeaderSize = 8
+
+var walStats = expvar.NewMap("wal")
+
+// walRecord is one entry of the log or snapshot: a chunk change as
+// recorded in the change feed, a blob write, a share link's state, or an
+// entry of the store's other bookkeeping.
+type walRecord struct {
+	Change *Change     `json:"change,omitempty"`
+	Blob   *walBlob    `json:"blob,omitempty"`
+	Share  *shareState `json:"share,omitempty"`
+	Entry  *walEntry   `json:"entry,omitempty"`
+}
+
+// walBlob is a chunk's audio as it stood after a write: the name of the
+// file in walBlobDir holding it or, in logs written before blobs had
+// files, the data itself. Dropped means the store no longer holds it.
+type walBlob struct {
+	ID      string    `json:"id"`
+	File    string    `json:"file,omitempty"`
+	Data    []byte    `json:"data,omitempty"`
+	SavedAt time.Time `json:"saved_at"`
+	Dropped bool      `json:"dropped,omitempty"`
+}
+
+// wal is an open write-ahead log. Appends happen under the store lock;
+// the interval syncer, SaveBlob's file writes and most of a compaction run
+// outside it.
+type wal struct {
+	dir          string
+	f            *os.File
+	w            *bufio.Writer
+	sync         string
+	compactEvery int
+	// records counts what was appended since the last compaction began.
+	records int
+	// files maps each blob the store holds to its file. compacting is set
+	// while a compaction runs, and closing once Close has begun. These
+	// three are guarded by the store lock.
+	files      map[string]string
+	compacting bool
+	closing    bool
+	// acks copies each session's acks for snapshots, which cannot take the
+	// session shards' locks. It too is guarded by the store lock.
+	acks        map[string]SessionAcks
+	compactions sync.WaitGroup
+	stop        chan struct{}
+	stopped     chan struct{}
+	// fmu keeps the interval syncer off a log file being replaced.
+	fmu sync.Mutex
+
+	// pending counts, per blob file, the writers that have not logged it
+	// yet, so that compaction leaves the file alone.
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+// OpenMemoryStore returns NewMemoryStore, made durable when cfg.WALDir is
+// set: the snapshot and log found there are replayed to rebuild the store,
+// and every chunk change and blob write from then on is logged before the
+// call making it returns. A torn record at the end of the log, as a crash
+// mid-write leaves, is cut off with a warning.
+//
+// The log covers chunks, their audio, revision history and the change
+// feed's cursor, and the bookkeeping kept beside them: settings, usage,
+// quota warnings, API keys, webhook subscriptions and their delivery
+// counts, annotations, auto-exports, reprocessing checkpoints and WS acks.
+// The audit trail, the recent webhook deliveries and uploads in flight
+// start empty after a restart. Call Close when done with the store.
+func OpenMemoryStore(cfg Config) (*MemoryStore, error) {
+	s := NewMemoryStore()
+	s.ChangeLogSize = cfg.ChangeLogSize
+	// Replay trims revision history and places acks in shards as
+	// configured, so these are set before it.
+	s.RevisionDepth = cfg.RevisionDepth
+	s.SessionShards = cfg.SessionShards
+	if cfg.WALDir == "" {
+		return s, nil
+	}
+	blobDir := filepath.Join(cfg.WALDir, walBlobDir)
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating WAL directory: %w", err)
+	}
+	files, err := s.replayWAL(cfg.WALDir)
+	if err != nil {
+		return nil, err
+	}
+	s.chargeAllLocked()
+	// Nothing writes blob files yet, so any half-written one is garbage.
+	if temps, err := filepath.Glob(filepath.Join(blobDir, walBlobTemp+"*")); err == nil {
+		for _, t := range temps {
+			os.Remove(t)
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(cfg.WALDir, walLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening WAL: %w", err)
+	}
+	w := &wal{
+		dir:          cfg.WALDir,
+		f:            f,
+		w:            bufio.NewWriter(f),
+		sync:         cfg.WALSync,
+		compactEvery: cfg.WALCompactEvery,
+		files:        files,
+		acks:         make(map[string]SessionAcks),
+		pending:      make(map[string]int),
+	}
+	for _, sh := range s.sessions {
+		maps.Copy(w.acks, sh.acks)
+	}
+	// Logs written before blobs had files carry the audio inline; give
+	// those blobs files so snapshots can refer to them.
+	for id, data := range s.blobs {
+		if files[id] == "" {
+			name, err := w.writeBlobFile(data)
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("moving blob %s out of the WAL: %w", id, err)
+			}
+			w.release(name)
+			files[id] = name
+		}
+	}
+	if w.sync == WALSyncInterval && cfg.WALSyncInterval > 0 {
+		w.stop, w.stopped = make(chan struct{}), make(chan struct{})
+		go w.syncEvery(cfg.WALSyncInterval)
+	}
+	s.wal = w
+	s.logging.Store(true)
+	return s, nil
+}
+
+// Close waits for a running compaction, then flushes and closes the
+// store's write-ahead log, if it has one. Writes after Close are no longer
+// logged.
+func (s *MemoryStore) Close() error {
+	s.mu.Lock()
+	w := s.wal
+	if w != nil {
+		w.closing = true
+	}
+	s.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	w.compactions.Wait()
+	s.mu.Lock()
+	s.wal = nil
+	s.logging.Store(false)
+	s.mu.Unlock()
+	if w.stop != nil {
+		close(w.stop)
+		<-w.stopped
+	}
+	err := w.w.Flush()
+	if err == nil {
+		err = w.f.Sync()
+	}
+	return errors.Join(err, w.f.Close())
+}
+
+func (w *wal) syncEvery(interval time.Duration) {
+	defer close(w.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.fmu.Lock()
+			err := w.f.Sync()
+			w.fmu.Unlock()
+			if err != nil {
+				walStats.Add("errors", 1)
+				log.Printf("Syncing WAL: %v", err)
+			}
+		}
+	}
+}
+
+// appendWALLocked logs rec, starting a compaction once the log is long
+// enough. A write that fails is logged and counted; the change stays in
+// memory.
+func (s *MemoryStore) appendWALLocked(rec walRecord) {
+	w := s.wal
+	if w == nil {
+		return
+	}
+	err := writeWALRecord(w.w, rec)
+	if err == nil {
+		err = w.w.Flush()
+	}
+	if err == nil && w.sync == WALSyncAlways {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		walStats.Add("errors", 1)
+		log.Printf("Appending to WAL: %v", err)
+		return
+	}
+	walStats.Add("records", 1)
+	w.records++
+	if w.compactEvery > 0 && w.records >= w.compactEvery && !w.compacting && !w.closing {
+		s.startCompactionLocked()
+	}
+}
+
+// writeBlobFile stores data in the blob directory under its SHA-256 and
+// returns the file's name, keeping compaction off it until release. The
+// file is synced unless the log never is.
+func (w *wal) writeBlobFile(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:])
+	w.mu.Lock()
+	w.pending[name]++
+	w.mu.Unlock()
+	dir := filepath.Join(w.dir, walBlobDir)
+	if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+		return name, nil
+	}
+	f, err := os.CreateTemp(dir, walBlobTemp+"*")
+	if err == nil {
+		_, err = f.Write(data)
+		if err == nil && w.sync != WALSyncNever {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), filepath.Join(dir, name))
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		w.release(name)
+		return "", err
+	}
+	return name, nil
+}
+
+// release lets compaction collect a blob file again.
+func (w *wal) release(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending[name]--; w.pending[name] <= 0 {
+		delete(w.pending, name)
+	}
+}
+
+// logBlobLocked logs the current state of a chunk's blob, writing its
+// file first. SaveBlob, which writes the file before taking the lock,
+// calls logBlobFileLocked instead.
+func (s *MemoryStore) logBlobLocked(id string) {
+	w := s.wal
+	if w == nil {
+		return
+	}
+	data, ok := s.blobs[id]
+	if !ok {
+		s.logBlobFileLocked(id, "")
+		return
+	}
+	name, err := w.writeBlobFile(data)
+	if err != nil {
+		walStats.Add("errors", 1)
+		log.Printf("Writing WAL blob %s: %v", id, err)
+		return
+	}
+	defer w.release(name)
+	s.logBlobFileLocked(id, name)
+}
+
+// logBlobFileLocked logs that blob id is now held in file, or that it was
+// dropped when file is empty.
+func (s *MemoryStore) logBlobFileLocked(id, file string) {
+	w := s.wal
+	if file == "" {
+		delete(w.files, id)
+		s.appendWALLocked(walRecord{Blob: &walBlob{ID: id, Dropped: true}})
+		return
+	}
+	w.files[id] = file
+	s.appendWALLocked(walRecord{Blob: &walBlob{ID: id, File: file, SavedAt: s.blobSaved[id]}})
+}
+
+// walSnapshot is a copy of the store taken under its lock, so that the
+// snapshot can be written without it. Blobs are copied as the names of
+// their files, which keeps the copy cheap.
+type walSnapshot struct {
+	seq     int64
+	at      time.Time
+	metas   []Metadata
+	blobs   []walBlob
+	shares  []shareState
+	entries []walEntry
+	// covered is how many bytes of the log the snapshot replaces.
+	covered int64
+}
+
+// startCompactionLocked copies the store and writes the copy to a new
+// snapshot in the background.
+func (s *MemoryStore) startCompactionLocked() {
+	w := s.wal
+	info, err := w.f.Stat()
+	if err != nil {
+		walStats.Add("errors", 1)
+		log.Printf("Compacting WAL: %v", err)
+		return
+	}
+	snap := &walSnapshot{seq: s.changeSeq, at: s.Clock.Now(), covered: info.Size()}
+	s.eachLocked(func(m Metadata) { snap.metas = append(snap.metas, m) })
+	for id, file := range w.files {
+		snap.blobs = append(snap.blobs, walBlob{ID: id, File: file, SavedAt: s.blobSaved[id]})
+	}
+	for _, st := range s.shares {
+		snap.shares = append(snap.shares, st)
+	}
+	snap.entries = s.snapshotEntriesLocked()
+	w.compacting = true
+	w.records = 0
+	w.compactions.Add(1)
+	go func() {
+		defer w.compactions.Done()
+		if err := s.compactWAL(w, snap); err != nil {
+			walStats.Add("errors", 1)
+			log.Printf("Compacting WAL: %v", err)
+		}
+		s.mu.Lock()
+		w.compacting = false
+		s.mu.Unlock()
+	}()
+}
+
+// compactWAL writes snap to a new snapshot, then cuts the part of the log
+// it covers and collects the blob files nothing refers to any more. Only
+// the cut, which copies what was logged meanwhile, and the collection take
+// the store lock. A crash before the cut replays the whole log over the new
+// snapshot, which ends in the same state because every record carries the
+// whole of what it wrote.
+func (s *MemoryStore) compactWAL(w *wal, snap *walSnapshot) error {
+	tmp := filepath.Join(w.dir, walSnapshotTemp)
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, m := range snap.metas {
+		if err == nil {
+			err = writeWALRecord(bw, walRecord{Change: &Change{Seq: snap.seq, Op: changeSave, ChunkID: m.ChunkID, At: snap.at, Metadata: &m}})
+		}
+	}
+	if err == nil && len(snap.metas) == 0 {
+		// An empty snapshot still has to carry the cursor.
+		err = writeWALRecord(bw, walRecord{Change: &Change{Seq: snap.seq, Op: changePurge, At: snap.at}})
+	}
+	for _, b := range snap.blobs {
+		if err == nil {
+			err = writeWALRecord(bw, walRecord{Blob: &b})
+		}
+	}
+	for _, st := range snap.shares {
+		if err == nil {
+			err = writeWALRecord(bw, walRecord{Share: &st})
+		}
+	}
+	for _, e := range snap.entries {
+		if err == nil {
+			err = writeWALRecord(bw, walRecord{Entry: &e})
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(w.dir, walSnapshotFile))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.mu.Lock()
+	err = w.cutLogLocked(snap.covered)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	walStats.Add("compactions", 1)
+	keep := make(map[string]bool, len(snap.blobs))
+	for _, b := range snap.blobs {
+		keep[b.File] = true
+	}
+	s.collectBlobFiles(w, keep)
+	return nil
+}
+
+// cutLogLocked replaces the log with what was appended after its first n
+// bytes.
+func (w *wal) cutLogLocked(n int64) error {
+	path, tmp := filepath.Join(w.dir, walLogFile), filepath.Join(w.dir, walLogTemp)
+	old, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	if _, err := old.Seek(n, io.SeekStart); err != nil {
+		return err
+	}
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, old)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	w.fmu.Lock()
+	w.f.Close()
+	w.f, w.w = f, bufio.NewWriter(f)
+	w.fmu.Unlock()
+	return nil
+}
+
+// collectBlobFiles removes the blob files that neither the store, the
+// snapshot's blobs in keep nor a writer yet to log them refers to.
+func (s *MemoryStore) collectBlobFiles(w *wal, keep map[string]bool) {
+	entries, err := os.ReadDir(filepath.Join(w.dir, walBlobDir))
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keep = maps.Clone(keep)
+	for _, file := range w.files {
+		keep[file] = true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, e := range entries {
+		name := e.Name()
+		if keep[name] || w.pending[name] > 0 || strings.HasPrefix(name, walBlobTemp) {
+			continue
+		}
+		if os.Remove(filepath.Join(w.dir, walBlobDir, name)) == nil {
+			walStats.Add("blob_files_collected", 1)
+		}
+	}
+}
+
+// writeWALRecord frames rec as its length, its CRC-32 and its JSON.
+func writeWALRecord(w io.Writer, rec walRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var header [walHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// readWALFile calls fn for each intact record of the file at path and
+// returns the offset just past the last one. A short or damaged record
+// ends the read and is returned as err alongside that offset.
+func readWALFile(path string, fn func(walRecord)) (good int64, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	var header [walHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return good, nil
+		} else if err != nil {
+			return good, fmt.Errorf("torn record header: %w", err)
+		}
+		n := int64(binary.BigEndian.Uint32(header[:4]))
+		if n > info.Size()-good-walHeaderSize {
+			return good, errors.New("torn record: length runs past the end of the file")
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return good, fmt.Errorf("torn record: %w", err)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return good, errors.New("record checksum mismatch")
+		}
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			return good, fmt.Errorf("undecodable record: %w", err)
+		}
+		fn(rec)
+		good += int64(walHeaderSize + len(payload))
+	}
+}
+
+// replayWAL rebuilds the store from the snapshot and log in dir, cutting
+// a damaged tail off the log so appends resume after its last good record.
+// It returns the file holding each blob.
+func (s *MemoryStore) replayWAL(dir string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	start := time.Now()
+	files := make(map[string]string)
+	snapshot := filepath.Join(dir, walSnapshotFile)
+	if _, err := readWALFile(snapshot, func(rec walRecord) { s.replayLocked(dir, rec, false, files) }); err != nil {
+		walStats.Add("damaged_snapshots", 1)
+		log.Printf("WAL snapshot %s is damaged, keeping the records before the damage: %v", snapshot, err)
+	}
+	logged := 0
+	path := filepath.Join(dir, walLogFile)
+	good, err := readWALFile(path, func(rec walRecord) {
+		s.replayLocked(dir, rec, true, files)
+		logged++
+	})
+	if err != nil {
+		walStats.Add("truncated_tails", 1)
+		log.Printf("Truncating WAL %s at byte %d: %v", path, good, err)
+		if err := os.Truncate(path, good); err != nil {
+			return nil, fmt.Errorf("truncating damaged WAL: %w", err)
+		}
+	}
+	x := newIndexes()
+	s.eachLocked(x.add)
+	s.index.live = x
+	log.Printf("Replayed %d chunks from WAL in %s (%d log records)", len(s.metadata)+len(s.legacy), time.Since(start), logged)
+	return files, nil
+}
+
+// replayLocked applies one record, noting in files which file holds each
+// blob read. Changes from the log, as opposed to the snapshot, also go
+// back into the change feed, and records the snapshot already covers only
+// move the cursor forward.
+func (s *MemoryStore) replayLocked(dir string, rec walRecord, fromLog bool, files map[string]string) {
+	if b := rec.Blob; b != nil {
+		s.replayBlobLocked(dir, b, files)
+		return
+	}
+	if st := rec.Share; st != nil {
+		s.shares[st.ID] = *st
+		return
+	}
+	if e := rec.Entry; e != nil {
+		s.replayEntryLocked(e)
+		return
+	}
+	c := rec.Change
+	if c == nil {
+		return
+	}
+	if c.ChunkID != "" {
+		delete(s.legacy, c.ChunkID)
+		if c.Metadata != nil {
+			s.metadata[c.ChunkID] = *c.Metadata
+		} else {
+			delete(s.metadata, c.ChunkID)
+			delete(s.revisions, c.ChunkID)
+			delete(s.annotations, c.ChunkID)
+			for _, id := range []string{c.ChunkID, transcriptBlobID(c.ChunkID)} {
+				delete(s.blobs, id)
+				delete(s.blobSaved, id)
+				delete(files, id)
+			}
+		}
+	}
+	if c.Seq <= s.changeSeq {
+		return
+	}
+	s.changeSeq = c.Seq
+	if fromLog {
+		s.changes = append(s.changes, *c)
+		if len(s.changes) > s.ChangeLogSize {
+			s.changes = s.changes[len(s.changes)-s.ChangeLogSize:]
+		}
+	}
+}
+
+// replayBlobLocked applies a blob record. A blob whose file cannot be read
+// is left out with a warning, and reconciliation then reports its chunk as
+// missing its audio.
+func (s *MemoryStore) replayBlobLocked(dir string, b *walBlob, files map[string]string) {
+	delete(files, b.ID)
+	if b.Dropped {
+		delete(s.blobs, b.ID)
+		delete(s.blobSaved, b.ID)
+		return
+	}
+	data := b.Data
+	if b.File != "" {
+		var err error
+		if filepath.Base(b.File) != b.File {
+			err = fmt.Errorf("invalid blob file name %q", b.File)
+		} else {
+			data, err = os.ReadFile(filepath.Join(dir, walBlobDir, b.File))
+		}
+		if err != nil {
+			walStats.Add("missing_blob_files", 1)
+			log.Printf("Replaying WAL blob %s: %v", b.ID, err)
+			delete(s.blobs, b.ID)
+			delete(s.blobSaved, b.ID)
+			return
+		}
+		files[b.ID] = b.File
+	}
+	s.blobs[b.ID] = data
+	s.blobSaved[b.ID] = b.SavedAt
+}
@@ -0,0 +1,500 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func walConfig(dir string) Config {
+	cfg := DefaultConfig()
+	cfg.WALDir = dir
+	cfg.WALSync = WALSyncAlways
+	return cfg
+}
+
+func openWALStore(t *testing.T, cfg Config) *MemoryStore {
+	t.Helper()
+	s, err := OpenMemoryStore(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	return s
+}
+
+func TestWALReplaysAfterRestart(t *testing.T) {
+	cfg := walConfig(t.TempDir())
+	s := openWALStore(t, cfg)
+	s.Save(Metadata{ChunkID: "c1", UserID: "user1", SessionID: "s1", Checksum: "sum1", Transcript: "kitchen lights"})
+	s.SaveBlob("c1", []byte("audio1"))
+	s.Save(Metadata{ChunkID: "c2", UserID: "user1", SessionID: "s1"})
+	s.Delete("c2")
+	s.Save(Metadata{ChunkID: "c3", UserID: "user2", SessionID: "s2"})
+	s.SaveBlob("c3", []byte("audio3"))
+	s.PurgeDeleted()
+	s.WithTx(func(tx Store) error {
+		tx.SaveBlob("c4", []byte("rolled back"))
+		tx.Save(Metadata{ChunkID: "c4", UserID: "user1"})
+		return errors.New("abort")
+	})
+	cursor := s.Changes(0, 100).Cursor
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openWALStore(t, cfg)
+	defer s.Close()
+	if m, err := s.Get("c1"); err != nil || m.Transcript != "kitchen lights" {
+		t.Errorf("Expected c1 to survive the restart, but got %+v %v", m, err)
+	}
+	if data, err := s.GetBlob("c1"); err != nil || string(data) != "audio1" {
+		t.Errorf("Expected c1's audio to survive the restart, but got %q %v", data, err)
+	}
+	if _, err := s.Get("c2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected c2 to stay deleted, but got %v", err)
+	}
+	if len(s.ListByUserWithDeleted("user1")) != 2 {
+		t.Errorf("Expected c2 to be back in the trash")
+	}
+	if _, err := s.Get("c4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the rolled back transaction to stay out of the log, but got %v", err)
+	}
+	if _, err := s.GetBlob("c4"); err == nil {
+		t.Errorf("Expected the rolled back blob to stay out of the log")
+	}
+	if got := s.FindByChecksum("sum1"); len(got) != 1 || len(s.ListBySession("user1", "s1")) != 1 {
+		t.Errorf("Expected the indexes to be rebuilt, but got %+v", got)
+	}
+	if page := s.Changes(cursor, 100); page.Truncated || len(page.Changes) != 0 {
+		t.Errorf("Expected the change feed cursor to stay valid, but got %+v", page)
+	}
+	s.Save(Metadata{ChunkID: "c5", UserID: "user1"})
+	if page := s.Changes(cursor, 100); len(page.Changes) != 1 || page.Changes[0].Seq != cursor+1 {
+		t.Errorf("Expected numbering to continue after the restart, but got %+v", page)
+	}
+}
+
+func TestWALTruncatedTailRecovers(t *testing.T) {
+	dir := t.TempDir()
+	s := openWALStore(t, walConfig(dir))
+	const n = 5
+	var ends []int64
+	path := filepath.Join(dir, walLogFile)
+	for i := range n {
+		s.Save(Metadata{ChunkID: fmt.Sprintf("c%d", i), UserID: "user1"})
+		info, _ := os.Stat(path)
+		ends = append(ends, info.Size())
+	}
+	s.Close()
+	full, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the log at every offset, as a crash mid-write would, and expect
+	// exactly the records that were completely written back.
+	for cut := int64(0); cut <= int64(len(full)); cut++ {
+		crashed := t.TempDir()
+		os.WriteFile(filepath.Join(crashed, walLogFile), full[:cut], 0o644)
+		s := openWALStore(t, walConfig(crashed))
+		want := 0
+		for _, end := range ends {
+			if end <= cut {
+				want++
+			}
+		}
+		if got := len(s.ListByUser("user1")); got != want {
+			t.Fatalf("Cut at %d: expected %d chunks, but got %d", cut, want, got)
+		}
+		s.Save(Metadata{ChunkID: "after", UserID: "user1"})
+		s.Close()
+		s = openWALStore(t, walConfig(crashed))
+		if _, err := s.Get("after"); err != nil || len(s.ListByUser("user1")) != want+1 {
+			t.Fatalf("Cut at %d: expected writes after recovery to survive another restart", cut)
+		}
+		s.Close()
+	}
+}
+
+func TestWALCorruptRecordTruncatesTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openWALStore(t, walConfig(dir))
+	path := filepath.Join(dir, walLogFile)
+	s.Save(Metadata{ChunkID: "c1", UserID: "user1"})
+	info, _ := os.Stat(path)
+	s.Save(Metadata{ChunkID: "c2", UserID: "user1"})
+	s.Save(Metadata{ChunkID: "c3", UserID: "user1"})
+	s.Close()
+
+	data, _ := os.ReadFile(path)
+	i := info.Size() + walHeaderSize + 5
+	data[i] ^= 0xff
+	os.WriteFile(path, data, 0o644)
+	truncated := expvarInt(walStats, "truncated_tails")
+
+	s = openWALStore(t, walConfig(dir))
+	defer s.Close()
+	if got := s.ListByUser("user1"); len(got) != 1 || got[0].ChunkID != "c1" {
+		t.Errorf("Expected only the records before the damage, but got %+v", got)
+	}
+	if after, _ := os.Stat(path); after.Size() != info.Size() {
+		t.Errorf("Expected the log to be cut at %d, but it is %d bytes", info.Size(), after.Size())
+	}
+	if expvarInt(walStats, "truncated_tails") != truncated+1 {
+		t.Errorf("Expected the truncation to be counted")
+	}
+}
+
+func TestWALCompaction(t *testing.T) {
+	dir := t.TempDir()
+	cfg := walConfig(dir)
+	cfg.WALCompactEvery = 4
+	s := openWALStore(t, cfg)
+	for i := range 10 {
+		id := fmt.Sprintf("c%d", i)
+		s.Save(Metadata{ChunkID: id, UserID: "user1"})
+		s.SaveBlob(id, []byte(id))
+	}
+	s.Delete("c0")
+	cursor := s.Changes(0, 100).Cursor
+	s.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, walSnapshotFile)); err != nil {
+		t.Fatalf("Expected a snapshot, but got %v", err)
+	}
+	snapshot, _ := os.ReadFile(filepath.Join(dir, walSnapshotFile))
+	log, _ := os.ReadFile(filepath.Join(dir, walLogFile))
+
+	s = openWALStore(t, cfg)
+	if got := len(s.ListByUser("user1")); got != 9 {
+		t.Errorf("Expected 9 visible chunks from the snapshot and log, but got %d", got)
+	}
+	if data, err := s.GetBlob("c9"); err != nil || string(data) != "c9" {
+		t.Errorf("Expected audio from the snapshot, but got %q %v", data, err)
+	}
+	if page := s.Changes(cursor, 100); page.Truncated {
+		t.Errorf("Expected the cursor to survive compaction, but got %+v", page)
+	}
+	s.Close()
+
+	// A crash after the snapshot was replaced but before the log was
+	// emptied replays the old log over the new snapshot.
+	crashed := t.TempDir()
+	os.WriteFile(filepath.Join(crashed, walSnapshotFile), snapshot, 0o644)
+	os.WriteFile(filepath.Join(crashed, walLogFile), append(bytes.Clone(log), log...), 0o644)
+	s = openWALStore(t, walConfig(crashed))
+	defer s.Close()
+	if got := len(s.ListByUser("user1")); got != 9 {
+		t.Errorf("Expected replaying the log twice to change nothing, but got %d chunks", got)
+	}
+}
+
+func TestWALBlobFiles(t *testing.T) {
+	dir := t.TempDir()
+	cfg := walConfig(dir)
+	cfg.WALCompactEvery = 3
+	s := openWALStore(t, cfg)
+	audio := bytes.Repeat([]byte("pcm!"), 1000)
+	s.Save(Metadata{ChunkID: "c1", UserID: "user1"})
+	s.SaveBlob("c1", audio)
+	log, _ := os.ReadFile(filepath.Join(dir, walLogFile))
+	if bytes.Contains(log, []byte("cGNtIXBjbSFwY20h")) || len(log) > len(audio) {
+		t.Errorf("Expected the log to refer to the audio, but it is %d bytes", len(log))
+	}
+	files, _ := os.ReadDir(filepath.Join(dir, walBlobDir))
+	if len(files) != 1 {
+		t.Fatalf("Expected one blob file, but got %d", len(files))
+	}
+
+	// Once a compaction no longer needs the file, it is collected.
+	s.Save(Metadata{ChunkID: "c2", UserID: "user1"})
+	s.SaveBlob("c2", []byte("other audio"))
+	s.Retention = -time.Hour
+	s.Delete("c1")
+	s.PurgeDeleted()
+	// Compactions run in the background; the second from now started
+	// after the purge.
+	compactions := expvarInt(walStats, "compactions")
+	n := 0
+	for deadline := time.Now().Add(5 * time.Second); expvarInt(walStats, "compactions") < compactions+2 && time.Now().Before(deadline); n++ {
+		s.Save(Metadata{ChunkID: fmt.Sprintf("d%d", n), UserID: "user1"})
+		time.Sleep(time.Millisecond)
+	}
+	s.Close()
+	files, _ = os.ReadDir(filepath.Join(dir, walBlobDir))
+	if len(files) != 1 {
+		t.Errorf("Expected only c2's blob file left, but got %d files", len(files))
+	}
+	s = openWALStore(t, cfg)
+	if data, err := s.GetBlob("c2"); err != nil || string(data) != "other audio" {
+		t.Errorf("Expected c2's audio back from its file, but got %q %v", data, err)
+	}
+	if got := len(s.ListByUser("user1")); got != n+1 {
+		t.Errorf("Expected %d chunks, but got %d", n+1, got)
+	}
+	s.Close()
+
+	// A log from before blob files, with the audio inline, still replays.
+	old := t.TempDir()
+	var buf bytes.Buffer
+	writeWALRecord(&buf, walRecord{Blob: &walBlob{ID: "c9", Data: []byte("inline")}})
+	os.WriteFile(filepath.Join(old, walLogFile), buf.Bytes(), 0o644)
+	s = openWALStore(t, walConfig(old))
+	defer s.Close()
+	if data, err := s.GetBlob("c9"); err != nil || string(data) != "inline" {
+		t.Errorf("Expected inline audio from an old log, but got %q %v", data, err)
+	}
+	if files, _ := os.ReadDir(filepath.Join(old, walBlobDir)); len(files) != 1 {
+		t.Errorf("Expected the inline audio moved to a file, but got %d files", len(files))
+	}
+}
+
+// TestWALCompactionUnderLoad writes from many goroutines while the log is
+// compacted in the background. Run it with -race.
+func TestWALCompactionUnderLoad(t *testing.T) {
+	dir := t.TempDir()
+	cfg := walConfig(dir)
+	cfg.WALSync = WALSyncNever
+	cfg.WALCompactEvery = 20
+	s := openWALStore(t, cfg)
+	done := make(chan bool)
+	for g := range 8 {
+		go func() {
+			for i := range 50 {
+				id := fmt.Sprintf("g%d-%d", g, i)
+				s.SaveBlob(id, []byte(id))
+				s.Save(Metadata{ChunkID: id, UserID: "user1"})
+			}
+			done <- true
+		}()
+	}
+	for range 8 {
+		<-done
+	}
+	s.Close()
+	if expvarInt(walStats, "compactions") == 0 {
+		t.Fatalf("Expected the log to be compacted")
+	}
+
+	s = openWALStore(t, cfg)
+	defer s.Close()
+	if got := len(s.ListByUser("user1")); got != 400 {
+		t.Errorf("Expected all 400 chunks back, but got %d", got)
+	}
+	if data, err := s.GetBlob("g7-49"); err != nil || string(data) != "g7-49" {
+		t.Errorf("Expected the last blob back, but got %q %v", data, err)
+	}
+}
+
+// TestWALKeepsBookkeeping restarts a store after each kind of bookkeeping
+// is written, with the writes replayed from the log, from a snapshot, and
+// from a log replayed twice as a crash mid-compaction leaves it.
+func TestWALKeepsBookkeeping(t *testing.T) {
+	at := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name  string
+		write func(s *MemoryStore)
+		check func(t *testing.T, s *MemoryStore)
+	}{
+		{
+			name: "settings",
+			write: func(s *MemoryStore) {
+				s.SaveUserSettings("user1", UserSettings{Language: "de-DE"})
+				s.WithTx(func(tx Store) error { return tx.SaveUserSettings("user2", UserSettings{Language: "fr-FR"}) })
+				s.WithTx(func(tx Store) error {
+					tx.SaveUserSettings("user3", UserSettings{Language: "es-ES"})
+					return errors.New("abort")
+				})
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				for user, want := range map[string]string{"user1": "de-DE", "user2": "fr-FR", "user3": ""} {
+					if got, _ := s.UserSettings(user); got.Language != want {
+						t.Errorf("Expected %s's language to be %q, but got %q", user, want, got.Language)
+					}
+				}
+			},
+		},
+		{
+			name: "usage",
+			write: func(s *MemoryStore) {
+				s.AddUsage(BillingUsage{UserID: "user1", Month: "2026-10", Chunks: 1, Seconds: 2})
+				s.AddUsage(BillingUsage{UserID: "user1", Month: "2026-10", Chunks: 1, Seconds: 3})
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if got := s.Usage("user1", "2026-10"); got.Chunks != 2 || got.Seconds != 5 {
+					t.Errorf("Expected 2 chunks and 5 seconds billed, but got %+v", got)
+				}
+			},
+		},
+		{
+			name: "quota marks",
+			write: func(s *MemoryStore) {
+				s.MarkQuotaWarned("user1", "chunks", "2026-10-15")
+				s.MarkQuotaWarned("user1", "bytes", "2026-10-16")
+				s.MarkQuotaWarned("user2", "chunks", "2026-10-16")
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if first, _ := s.MarkQuotaWarned("user2", "chunks", "2026-10-16"); first {
+					t.Errorf("Expected today's warning to stay marked")
+				}
+				if first, _ := s.MarkQuotaWarned("user1", "chunks", "2026-10-16"); !first {
+					t.Errorf("Expected yesterday's mark to be forgotten")
+				}
+			},
+		},
+		{
+			name: "api keys",
+			write: func(s *MemoryStore) {
+				s.SaveAPIKey(APIKey{ID: "k1", Hash: "h1", UserID: "user1", CreatedAt: at})
+				s.SaveAPIKey(APIKey{ID: "k2", Hash: "h2", UserID: "user1", CreatedAt: at})
+				s.RevokeAPIKey("k2", at.Add(time.Hour))
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if k, ok := s.APIKeyByHash("h1"); !ok || k.UserID != "user1" || k.RevokedAt != nil {
+					t.Errorf("Expected k1 back, but got %+v %v", k, ok)
+				}
+				if k, ok := s.APIKeyByHash("h2"); !ok || k.RevokedAt == nil {
+					t.Errorf("Expected k2 to stay revoked, but got %+v %v", k, ok)
+				}
+			},
+		},
+		{
+			name: "webhooks",
+			write: func(s *MemoryStore) {
+				s.SaveWebhook(WebhookSubscription{ID: "wal-sub1", URL: "http://example.com/1", Events: []string{EventChunkProcessed}, CreatedAt: at})
+				s.SaveWebhook(WebhookSubscription{ID: "wal-sub2", URL: "http://example.com/2", Events: []string{EventChunkFailed}, CreatedAt: at})
+				s.countWebhook("wal-sub1", "attempts")
+				s.countWebhook("wal-sub1", "attempts")
+				s.countWebhook("wal-sub1", "delivered")
+				s.countWebhook("wal-sub2", "attempts")
+				s.DeleteWebhook("wal-sub2")
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if subs := s.Webhooks(); len(subs) != 1 || subs[0].URL != "http://example.com/1" {
+					t.Errorf("Expected only wal-sub1 back, but got %+v", subs)
+				}
+				if got := s.webhookCount("wal-sub2", "attempts"); got != 0 {
+					t.Errorf("Expected the deleted subscription's counters to stay dropped, but got %d", got)
+				}
+				cfg := DefaultConfig()
+				wh := NewWebhooks(cfg, NewEgress(cfg), s, NewBus())
+				wh.Start(context.Background())
+				defer wh.Stop()
+				if got := webhookDeliveries.Get("wal-sub1.attempts"); got == nil || got.String() != "2" {
+					t.Errorf("Expected 2 attempts counted after the restart, but got %v", got)
+				}
+			},
+		},
+		{
+			name: "annotations",
+			write: func(s *MemoryStore) {
+				s.Save(Metadata{ChunkID: "c1", UserID: "user1"})
+				s.AddAnnotation(Annotation{ID: "a1", ChunkID: "c1", Text: "first"})
+				s.AddAnnotation(Annotation{ID: "a2", ChunkID: "c1", OffsetMS: 10, Text: "second"})
+				s.DeleteAnnotation("c1", "a1")
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if got := s.Annotations("c1"); len(got) != 1 || got[0].ID != "a2" {
+					t.Errorf("Expected only a2 back, but got %+v", got)
+				}
+			},
+		},
+		{
+			name: "exports",
+			write: func(s *MemoryStore) {
+				s.MarkSessionExported("user1/s1/1", at)
+				s.MarkSessionExported("user1/s2/1", at)
+				s.ForgetSessionExported("user1/s2/1")
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if got, ok := s.SessionExported("user1/s1/1"); !ok || !got.Equal(at) {
+					t.Errorf("Expected s1's export back, but got %v %v", got, ok)
+				}
+				if _, ok := s.SessionExported("user1/s2/1"); ok {
+					t.Errorf("Expected s2's export to stay forgotten")
+				}
+			},
+		},
+		{
+			name: "reprocess checkpoints",
+			write: func(s *MemoryStore) {
+				s.SaveReprocessCheckpoint(ReprocessStatus{ID: "job1", State: "running", Total: 10, Processed: 4, Cursor: "c4"})
+				s.WithTx(func(tx Store) error {
+					tx.(*memTx).SaveReprocessCheckpoint(ReprocessStatus{ID: "job2", State: "running", Total: 5, Processed: 1, Cursor: "c1"})
+					return nil
+				})
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				got := s.ReprocessCheckpoints()
+				sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+				if len(got) != 2 || got[0].Cursor != "c4" || got[0].Processed != 4 || got[1].Cursor != "c1" {
+					t.Errorf("Expected both jobs' progress back, but got %+v", got)
+				}
+			},
+		},
+		{
+			name: "acks",
+			write: func(s *MemoryStore) {
+				for _, seq := range []int64{1, 2, 4} {
+					s.RecordAck("user1/s1", seq)
+				}
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				if got := s.SessionAcks("user1/s1"); got.HighWater != 2 || len(got.Above) != 1 || got.Above[0] != 4 {
+					t.Errorf("Expected acks up to 2 and 4, but got %+v", got)
+				}
+			},
+		},
+		{
+			name: "revisions",
+			write: func(s *MemoryStore) {
+				for _, text := range []string{"one", "two", "three"} {
+					s.Save(Metadata{ChunkID: "c1", UserID: "user1", Transcript: text})
+				}
+				s.WithTx(func(tx Store) error {
+					return tx.(*memTx).saveAs(Metadata{ChunkID: "c1", UserID: "user1", Transcript: "four"}, revisionReprocess)
+				})
+			},
+			check: func(t *testing.T, s *MemoryStore) {
+				revs := s.Revisions("c1")
+				if len(revs) != 3 || revs[0].Revision != 2 || revs[2].Revision != 4 || revs[2].Cause != revisionReprocess || revs[2].Metadata.Transcript != "four" {
+					t.Fatalf("Expected revisions 2 to 4 back, but got %+v", revs)
+				}
+				s.Save(Metadata{ChunkID: "c1", UserID: "user1", Transcript: "five"})
+				if revs := s.Revisions("c1"); revs[len(revs)-1].Revision != 5 {
+					t.Errorf("Expected numbering to continue after the restart, but got %+v", revs)
+				}
+			},
+		},
+	}
+	for _, c := range cases {
+		for _, mode := range []string{"log", "snapshot", "log twice"} {
+			t.Run(c.name+"/"+mode, func(t *testing.T) {
+				dir := t.TempDir()
+				cfg := walConfig(dir)
+				cfg.RevisionDepth = 3
+				if mode == "snapshot" {
+					cfg.WALCompactEvery = 1
+				}
+				s := openWALStore(t, cfg)
+				c.write(s)
+				if err := s.Close(); err != nil {
+					t.Fatal(err)
+				}
+				if mode == "log twice" {
+					log, _ := os.ReadFile(filepath.Join(dir, walLogFile))
+					os.WriteFile(filepath.Join(dir, walLogFile), append(bytes.Clone(log), log...), 0o644)
+				}
+				s = openWALStore(t, cfg)
+				defer s.Close()
+				c.check(t, s)
+			})
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"maps"
+	"time"
+)
+
+// The tables of bookkeeping the write-ahead log keeps besides chunks, blobs
+// and share links, as named in their entries.
+const (
+	walSettings      = "settings"
+	walUsage         = "usage"
+	walQuotaWarned   = "quota_warned"
+	walCheckpoints   = "checkpoints"
+	walAPIKeys       = "api_keys"
+	walWebhooks      = "webhooks"
+	walWebhookCounts = "webhook_counts"
+	walAnnotations   = "annotations"
+	walExported      = "exported"
+	walRevisions     = "revisions"
+	walAcks          = "acks"
+)
+
+// walEntry is one value of a table as it stood after a write, or its
+// removal when Value is empty. Like the other records it carries the whole
+// of what was written, so replaying it twice ends in the same state.
+type walEntry struct {
+	Table string          `json:"table"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// walTable is how one table is written to a snapshot and read back. Both
+// run under the store lock.
+type walTable struct {
+	// each calls fn with every value a snapshot has to carry.
+	each func(s *MemoryStore, fn func(key string, v any))
+	// set applies a logged entry; an empty value removes the key.
+	set func(s *MemoryStore, key string, value json.RawMessage) error
+}
+
+var walTables = map[string]walTable{
+	walSettings:      mapTable(func(s *MemoryStore) map[string]UserSettings { return s.settings }),
+	walUsage:         mapTable(func(s *MemoryStore) map[string]BillingUsage { return s.usage }),
+	walCheckpoints:   mapTable(func(s *MemoryStore) map[string]ReprocessStatus { return s.checkpoints }),
+	walAPIKeys:       mapTable(func(s *MemoryStore) map[string]APIKey { return s.apiKeys }),
+	walWebhooks:      mapTable(func(s *MemoryStore) map[string]WebhookSubscription { return s.webhooks }),
+	walWebhookCounts: mapTable(func(s *MemoryStore) map[string]int64 { return s.hookCounts }),
+	walAnnotations:   mapTable(func(s *MemoryStore) map[string][]Annotation { return s.annotations }),
+	walExported:      mapTable(func(s *MemoryStore) map[string]time.Time { return s.exported }),
+	walQuotaWarned:   {each: eachQuotaWarned, set: setQuotaWarned},
+	walRevisions:     {each: eachRevision, set: setRevision},
+	walAcks: {
+		each: func(s *MemoryStore, fn func(string, any)) {
+			for key, acks := range s.wal.acks {
+				fn(key, acks)
+			}
+		},
+		// Replay runs before the store is shared, so the shard needs no
+		// lock here.
+		set: func(s *MemoryStore, key string, value json.RawMessage) error {
+			return setEntry(s.sessionShard(key).acks, key, value)
+		},
+	},
+}
+
+// mapTable is the table kept in the map m returns.
+func mapTable[V any](m func(s *MemoryStore) map[string]V) walTable {
+	return walTable{
+		each: func(s *MemoryStore, fn func(string, any)) {
+			for key, v := range m(s) {
+				fn(key, v)
+			}
+		},
+		set: func(s *MemoryStore, key string, value json.RawMessage) error {
+			return setEntry(m(s), key, value)
+		},
+	}
+}
+
+func setEntry[V any](m map[string]V, key string, value json.RawMessage) error {
+	if len(value) == 0 {
+		delete(m, key)
+		return nil
+	}
+	var v V
+	if err := json.Unmarshal(value, &v); err != nil {
+		return err
+	}
+	m[key] = v
+	return nil
+}
+
+func eachQuotaWarned(s *MemoryStore, fn func(string, any)) {
+	for key, day := range s.quotaWarned {
+		fn(key, day)
+	}
+}
+
+// setQuotaWarned replays a quota mark as MarkQuotaWarned made it, except
+// that a mark from before the day already replayed is ignored rather than
+// starting that day over, which keeps replay from depending on whether the
+// log before a snapshot is replayed again.
+func setQuotaWarned(s *MemoryStore, key string, value json.RawMessage) error {
+	if len(value) == 0 {
+		delete(s.quotaWarned, key)
+		return nil
+	}
+	var day string
+	if err := json.Unmarshal(value, &day); err != nil {
+		return err
+	}
+	switch {
+	case day < s.warnedDay:
+		return nil
+	case day > s.warnedDay:
+		maps.DeleteFunc(s.quotaWarned, func(_, d string) bool { return d != day })
+		s.warnedDay = day
+	}
+	s.quotaWarned[key] = day
+	return nil
+}
+
+// eachRevision lists a chunk's revisions one by one, oldest first, as they
+// were logged.
+func eachRevision(s *MemoryStore, fn func(string, any)) {
+	for id, revs := range s.revisions {
+		for _, r := range revs {
+			fn(id, r)
+		}
+	}
+}
+
+// setRevision appends a logged revision to its chunk's history unless the
+// history already has it.
+func setRevision(s *MemoryStore, id string, value json.RawMessage) error {
+	if len(value) == 0 {
+		delete(s.revisions, id)
+		return nil
+	}
+	var r Revision
+	if err := json.Unmarshal(value, &r); err != nil {
+		return err
+	}
+	revs := s.revisions[id]
+	if s.RevisionDepth <= 0 || len(revs) > 0 && revs[len(revs)-1].Revision >= r.Revision {
+		return nil
+	}
+	revs = append(revs[max(0, len(revs)-s.RevisionDepth+1):len(revs):len(revs)], r)
+	s.revisions[id] = revs
+	return nil
+}
+
+// newWALEntry encodes v as the value of key in table; a nil v removes it.
+func newWALEntry(table, key string, v any) (walEntry, error) {
+	e := walEntry{Table: table, Key: key}
+	if v == nil {
+		return e, nil
+	}
+	value, err := json.Marshal(v)
+	e.Value = value
+	return e, err
+}
+
+// logEntryLocked logs that table now holds v under key, or nothing when v
+// is nil.
+func (s *MemoryStore) logEntryLocked(table, key string, v any) {
+	if s.wal == nil {
+		return
+	}
+	e, err := newWALEntry(table, key, v)
+	if err != nil {
+		walStats.Add("errors", 1)
+		log.Printf("Logging %s %s: %v", table, key, err)
+		return
+	}
+	s.appendWALLocked(walRecord{Entry: &e})
+}
+
+// logAcks logs a session's acks. The caller holds the session's shard lock,
+// which comes before the store lock, so acks are logged in the order they
+// were recorded; the store lock is only taken while the log is open.
+func (s *MemoryStore) logAcks(key string, acks SessionAcks) {
+	if !s.logging.Load() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wal != nil {
+		s.wal.acks[key] = acks
+		s.logEntryLocked(walAcks, key, acks)
+	}
+}
+
+// replayEntryLocked applies an entry. One of an unknown table or that
+// cannot be decoded is skipped with a warning.
+func (s *MemoryStore) replayEntryLocked(e *walEntry) {
+	t, ok := walTables[e.Table]
+	if !ok {
+		log.Printf("Skipping WAL entry for unknown table %q", e.Table)
+		return
+	}
+	if err := t.set(s, e.Key, e.Value); err != nil {
+		walStats.Add("undecodable_entries", 1)
+		log.Printf("Skipping WAL %s entry %s: %v", e.Table, e.Key, err)
+	}
+}
+
+// snapshotEntriesLocked encodes every table for a snapshot.
+func (s *MemoryStore) snapshotEntriesLocked() []walEntry {
+	var entries []walEntry
+	for table, t := range walTables {
+		t.each(s, func(key string, v any) {
+			e, err := newWALEntry(table, key, v)
+			if err != nil {
+				walStats.Add("errors", 1)
+				log.Printf("Snapshotting %s %s: %v", table, key, err)
+				return
+			}
+			entries = append(entries, e)
+		})
+	}
+	return entries
+}
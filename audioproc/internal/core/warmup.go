@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Warm-up states reported by /readyz.
+const (
+	warmupPending = "pending"
+	warmupRunning = "running"
+	warmupDone    = "done"
+	warmupFailed  = "failed"
+)
+
+// WarmupStatus is one warm-up's progress as reported by /readyz.
+type WarmupStatus struct {
+	Name       string `json:"name"`
+	Critical   bool   `json:"critical"`
+	State      string `json:"state"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+}
+
+// transcriberPinger is implemented by transcribers that can check they
+// reach their backend; the server runs the check as a critical warm-up.
+type transcriberPinger interface {
+	Ping(ctx context.Context) error
+}
+
+type warmup struct {
+	status WarmupStatus
+	run    func(ctx context.Context) error
+}
+
+// Warmups runs the slow parts of startup concurrently once the server
+// starts. Until every critical warm-up has finished /readyz reports the
+// server as warming up, and a critical failure, including running past
+// Config.WarmupTimeout, aborts Run. Non-critical warm-ups are not timed out;
+// they keep running in the background and only their status is reported.
+type Warmups struct {
+	timeout time.Duration
+
+	mu       sync.Mutex
+	warmups  []*warmup
+	started  bool
+	pending  int // critical warm-ups not yet done
+	ready    chan struct{}
+	failed   chan error
+	failOnce sync.Once
+}
+
+// NewWarmups times critical warm-ups out after cfg.WarmupTimeout.
+func NewWarmups(cfg Config) *Warmups {
+	return &Warmups{timeout: cfg.WarmupTimeout, ready: make(chan struct{}), failed: make(chan error, 1)}
+}
+
+// Register adds a warm-up. fn must return when its context ends. Warm-ups
+// registered after the server has started never run.
+func (w *Warmups) Register(name string, critical bool, fn func(ctx context.Context) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.started {
+		log.Printf("Warm-up %s registered after startup; ignoring it", name)
+		return
+	}
+	w.warmups = append(w.warmups, &warmup{status: WarmupStatus{Name: name, Critical: critical, State: warmupPending}, run: fn})
+}
+
+// start launches every registered warm-up on g.
+func (w *Warmups) start(g *Goroutines) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.started = true
+	for _, wu := range w.warmups {
+		if wu.status.Critical {
+			w.pending++
+		}
+		wu.status.State = warmupRunning
+		g.Go("warmup", func(ctx context.Context) { w.run(ctx, wu) })
+	}
+	if w.pending == 0 {
+		close(w.ready)
+	}
+}
+
+func (w *Warmups) run(ctx context.Context, wu *warmup) {
+	start := time.Now()
+	if wu.status.Critical && w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+	err := wu.run(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", w.timeout)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	wu.status.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		wu.status.State, wu.status.Error = warmupFailed, err.Error()
+		if !wu.status.Critical {
+			log.Printf("Warm-up %s failed: %v", wu.status.Name, err)
+			return
+		}
+		w.failOnce.Do(func() { w.failed <- fmt.Errorf("critical warm-up %s failed: %w", wu.status.Name, err) })
+		return
+	}
+	wu.status.State = warmupDone
+	log.Printf("Warm-up %s finished in %dms", wu.status.Name, wu.status.DurationMS)
+	if wu.status.Critical {
+		if w.pending--; w.pending == 0 {
+			close(w.ready)
+		}
+	}
+}
+
+// Ready reports whether every critical warm-up has finished.
+func (w *Warmups) Ready() bool {
+	select {
+	case <-w.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Failed delivers the first critical warm-up failure.
+func (w *Warmups) Failed() <-chan error {
+	return w.failed
+}
+
+// Status returns every warm-up's progress in registration order.
+func (w *Warmups) Status() []WarmupStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	statuses := make([]WarmupStatus, len(w.warmups))
+	for i, wu := range w.warmups {
+		statuses[i] = wu.status
+	}
+	return statuses
+}
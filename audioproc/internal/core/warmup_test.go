@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, srv *Server) (done chan error, cancel func()) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done = make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	select {
+	case <-srv.Ready():
+	case err := <-done:
+		t.Fatalf("Run failed to start: %v", err)
+	}
+	return done, cancel
+}
+
+func getReadyz(t *testing.T, srv *Server) (int, readiness) {
+	t.Helper()
+	resp, err := http.Get("http://" + srv.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ready readiness
+	json.NewDecoder(resp.Body).Decode(&ready)
+	return resp.StatusCode, ready
+}
+
+func warmupState(ready readiness, name string) WarmupStatus {
+	for _, st := range ready.Warmups {
+		if st.Name == name {
+			return st
+		}
+	}
+	return WarmupStatus{}
+}
+
+func TestWarmupsGateReadiness(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	srv := New(cfg, NewMemoryStore(), nil)
+	release := make(chan struct{})
+	srv.Warmups.Register("slow", true, func(ctx context.Context) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	srv.Warmups.Register("flaky", false, func(ctx context.Context) error { return errors.New("cache unreachable") })
+	done, cancel := startServer(t, srv)
+	defer cancel()
+
+	code, ready := getReadyz(t, srv)
+	if code != http.StatusServiceUnavailable || ready.Status != "warming_up" || warmupState(ready, "slow").State != warmupRunning {
+		t.Errorf("Expected 503 while the critical warm-up runs, but got %d %+v", code, ready)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for code != http.StatusOK && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		code, ready = getReadyz(t, srv)
+	}
+	if code != http.StatusOK || ready.Status != "ready" || warmupState(ready, "slow").State != warmupDone {
+		t.Errorf("Expected ready once the critical warm-up finished, but got %d %+v", code, ready)
+	}
+	if st := warmupState(ready, "flaky"); st.State != warmupFailed || st.Error != "cache unreachable" {
+		t.Errorf("Expected the non-critical failure reported, but got %+v", st)
+	}
+	if st := warmupState(ready, "indexes"); st.State != warmupDone || st.Critical {
+		t.Errorf("Expected the index check as a non-critical warm-up, but got %+v", st)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected a clean shutdown, but got %v", err)
+	}
+}
+
+func TestCriticalWarmupFailureAbortsRun(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.WarmupTimeout = 50 * time.Millisecond
+	srv := New(cfg, NewMemoryStore(), nil)
+	srv.Warmups.Register("hang", true, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	done, cancel := startServer(t, srv)
+	defer cancel()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "critical warm-up hang failed: timed out") {
+			t.Errorf("Expected the timed-out warm-up to abort startup, but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the critical warm-up failed")
+	}
+}
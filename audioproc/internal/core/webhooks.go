@@ -0,0 +1,526 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/webhook"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// webhookQueue is how many events wait for a subscription's deliveries
+// before further ones are dropped for it.
+const webhookQueue = 256
+
+// webhookMaxAttempts bounds a subscription's retry policy.
+const webhookMaxAttempts = 10
+
+// webhookEvents are the event types a subscription may filter on.
+var webhookEvents = []string{EventChunkProcessed, EventChunkFailed, EventSessionClosed, EventChunkDeleted, EventQuotaWarning}
+
+var (
+	// webhookDeliveries counts attempts, deliveries and failures, keyed
+	// "<subscription id>.<outcome>". The store keeps the counts too, so
+	// that they survive a restart.
+	webhookDeliveries = expvar.NewMap("webhook_deliveries")
+	webhookOutcomes   = []string{"attempts", "delivered", "failed"}
+
+	errWebhookNotFound = newKindError(ErrNotFound, "webhook subscription not found")
+)
+
+// WebhookRetry is a subscription's retry policy. A failed attempt is
+// retried after BackoffMS, doubling each time, until MaxAttempts have been
+// made.
+type WebhookRetry struct {
+	MaxAttempts int   `json:"max_attempts"`
+	BackoffMS   int64 `json:"backoff_ms"`
+}
+
+// WebhookSubscription posts the events of the listed types to URL. UserIDs,
+// when set, limit it to events about those users' chunks and sessions.
+// Deliveries are signed with Secret, which is generated unless the
+// subscription sets one; see package webhook for how receivers check them.
+type WebhookSubscription struct {
+	ID        string            `json:"id"`
+	URL       string            `json:"url"`
+	Events    []string          `json:"events"`
+	UserIDs   []string          `json:"user_ids,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Retry     WebhookRetry      `json:"retry"`
+	Secret    string            `json:"secret,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// newWebhookSecret returns a random signing secret.
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (sub *WebhookSubscription) validate(cfg Config) error {
+	if u, err := url.Parse(sub.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalidField("url", "invalid_webhook", "url must be an absolute http or https URL")
+	}
+	if len(sub.Events) == 0 {
+		return invalidField("events", "invalid_webhook", "events must list at least one event type")
+	}
+	for _, ev := range sub.Events {
+		if !slices.Contains(webhookEvents, ev) {
+			return invalidField("events", "invalid_webhook", "events must be "+strings.Join(webhookEvents, ", "))
+		}
+	}
+	for name := range sub.Headers {
+		if http.CanonicalHeaderKey(name) == "Content-Type" || strings.HasPrefix(http.CanonicalHeaderKey(name), "X-Webhook-") {
+			return invalidField("headers", "invalid_webhook", name+" is set by the server")
+		}
+	}
+	if sub.Secret != "" && len(sub.Secret) < 16 {
+		return invalidField("secret", "invalid_webhook", "secret must be at least 16 characters")
+	}
+	if sub.Retry == (WebhookRetry{}) {
+		sub.Retry = WebhookRetry{MaxAttempts: cfg.WebhookMaxAttempts, BackoffMS: cfg.WebhookBackoff.Milliseconds()}
+	}
+	if sub.Retry.MaxAttempts < 1 || sub.Retry.MaxAttempts > webhookMaxAttempts {
+		return invalidField("retry.max_attempts", "invalid_webhook", fmt.Sprintf("max_attempts must be 1 to %d", webhookMaxAttempts))
+	}
+	if sub.Retry.BackoffMS < 0 || sub.Retry.BackoffMS > time.Minute.Milliseconds() {
+		return invalidField("retry.backoff_ms", "invalid_webhook", "backoff_ms must be 0 to 60000")
+	}
+	return nil
+}
+
+// wants reports whether sub should receive ev.
+func (sub WebhookSubscription) wants(ev Event) bool {
+	if len(sub.UserIDs) == 0 {
+		return true
+	}
+	var userID string
+	switch {
+	case ev.Chunk != nil:
+		userID = ev.Chunk.UserID
+	case ev.Session != nil:
+		userID = ev.Session.UserID
+	case ev.Quota != nil:
+		userID = ev.Quota.UserID
+	}
+	return slices.Contains(sub.UserIDs, userID)
+}
+
+// SaveWebhook stores a subscription, replacing one with the same ID.
+func (s *MemoryStore) SaveWebhook(sub WebhookSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhooks[sub.ID] = sub
+	s.logEntryLocked(walWebhooks, sub.ID, sub)
+}
+
+// Webhook returns the subscription with the given ID.
+func (s *MemoryStore) Webhook(id string) (WebhookSubscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.webhooks[id]
+	if !ok {
+		return WebhookSubscription{}, errWebhookNotFound
+	}
+	return sub, nil
+}
+
+// Webhooks lists the stored subscriptions, oldest first.
+func (s *MemoryStore) Webhooks() []WebhookSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := make([]WebhookSubscription, 0, len(s.webhooks))
+	for _, sub := range s.webhooks {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subs[i].ID < subs[j].ID
+	})
+	return subs
+}
+
+// DeleteWebhook removes a subscription.
+func (s *MemoryStore) DeleteWebhook(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return errWebhookNotFound
+	}
+	delete(s.webhooks, id)
+	s.logEntryLocked(walWebhooks, id, nil)
+	for _, outcome := range webhookOutcomes {
+		if _, ok := s.hookCounts[id+"."+outcome]; ok {
+			delete(s.hookCounts, id+"."+outcome)
+			s.logEntryLocked(walWebhookCounts, id+"."+outcome, nil)
+		}
+	}
+	return nil
+}
+
+// countWebhook adds one to a subscription's outcome counter, unless the
+// subscription is gone, and reports whether it did.
+func (s *MemoryStore) countWebhook(id, outcome string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.webhooks[id]; !ok {
+		return false
+	}
+	key := id + "." + outcome
+	s.hookCounts[key]++
+	s.logEntryLocked(walWebhookCounts, key, s.hookCounts[key])
+	return true
+}
+
+// webhookCount returns a subscription's outcome counter.
+func (s *MemoryStore) webhookCount(id, outcome string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hookCounts[id+"."+outcome]
+}
+
+// WebhookDelivery is the outcome of delivering one event to one
+// subscription, after any retries.
+type WebhookDelivery struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscription_id"`
+	Event          string    `json:"event"`
+	At             time.Time `json:"at"`
+	Attempts       int       `json:"attempts"`
+	Delivered      bool      `json:"delivered"`
+	StatusCode     int       `json:"status_code,omitempty"`
+	Error          string    `json:"error,omitempty"`
+}
+
+// webhookPayload is the body posted to subscribers.
+type webhookPayload struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	At      time.Time       `json:"at"`
+	Chunk   *Metadata       `json:"chunk,omitempty"`
+	Session *SessionSummary `json:"session,omitempty"`
+	Quota   *QuotaWarning   `json:"quota,omitempty"`
+}
+
+// Webhooks delivers bus events to the subscriptions kept in the store. Each
+// subscription has its own bus queue and delivery goroutine, so one that is
+// slow or retrying does not hold up the others. The newest deliveries are
+// kept for inspection.
+type Webhooks struct {
+	Client *http.Client
+
+	store  *MemoryStore
+	bus    *Bus
+	recent int
+
+	mu         sync.Mutex
+	ctx        context.Context
+	active     map[string]*activeWebhook
+	deliveries []WebhookDelivery
+}
+
+type activeWebhook struct {
+	cancel      context.CancelFunc
+	unsubscribe func()
+}
+
+// NewWebhooks delivers events published on bus to the subscriptions in
+// store through egress once Start is called.
+func NewWebhooks(cfg Config, egress *Egress, store *MemoryStore, bus *Bus) *Webhooks {
+	return &Webhooks{
+		Client: egress.Client("webhooks", cfg.WebhookTimeout, true),
+		store:  store,
+		bus:    bus,
+		recent: cfg.WebhookRecentDeliveries,
+		active: make(map[string]*activeWebhook),
+	}
+}
+
+// Start subscribes every stored subscription. Deliveries stop when ctx ends
+// or Stop is called.
+func (wh *Webhooks) Start(ctx context.Context) {
+	wh.mu.Lock()
+	wh.ctx = ctx
+	wh.mu.Unlock()
+	for _, sub := range wh.store.Webhooks() {
+		for _, outcome := range webhookOutcomes {
+			if n := wh.store.webhookCount(sub.ID, outcome); n > 0 {
+				v := new(expvar.Int)
+				v.Set(n)
+				webhookDeliveries.Set(sub.ID+"."+outcome, v)
+			}
+		}
+		wh.activate(sub)
+	}
+}
+
+// Stop ends every subscription's deliveries and waits for them.
+func (wh *Webhooks) Stop() {
+	wh.mu.Lock()
+	active := wh.active
+	wh.active = make(map[string]*activeWebhook)
+	wh.ctx = nil
+	wh.mu.Unlock()
+	for _, a := range active {
+		a.stop()
+	}
+}
+
+// activate starts delivering to sub, replacing any earlier version of it.
+func (wh *Webhooks) activate(sub WebhookSubscription) {
+	wh.mu.Lock()
+	old := wh.active[sub.ID]
+	delete(wh.active, sub.ID)
+	if wh.ctx != nil {
+		ctx, cancel := context.WithCancel(wh.ctx)
+		unsubscribe := wh.bus.Subscribe("webhook:"+sub.ID, webhookQueue, func(ev Event) {
+			if sub.wants(ev) {
+				wh.deliver(ctx, sub, ev)
+			}
+		}, sub.Events...)
+		wh.active[sub.ID] = &activeWebhook{cancel: cancel, unsubscribe: unsubscribe}
+	}
+	wh.mu.Unlock()
+	old.stop()
+}
+
+// deactivate stops delivering to a deleted subscription and drops its
+// counters.
+func (wh *Webhooks) deactivate(id string) {
+	wh.mu.Lock()
+	a := wh.active[id]
+	delete(wh.active, id)
+	wh.mu.Unlock()
+	a.stop()
+	for _, outcome := range webhookOutcomes {
+		webhookDeliveries.Delete(id + "." + outcome)
+	}
+}
+
+func (a *activeWebhook) stop() {
+	if a != nil {
+		a.cancel()
+		a.unsubscribe()
+	}
+}
+
+// deliver posts ev to sub, retrying as its policy allows, and records the
+// outcome.
+func (wh *Webhooks) deliver(ctx context.Context, sub WebhookSubscription, ev Event) {
+	payload := webhookPayload{ID: uuid.New().String(), Type: ev.Type, At: ev.At.UTC(), Chunk: ev.Chunk, Session: ev.Session, Quota: ev.Quota}
+	body, _ := json.Marshal(payload)
+	d := WebhookDelivery{ID: payload.ID, SubscriptionID: sub.ID, Event: ev.Type, At: payload.At}
+	backoff := time.Duration(sub.Retry.BackoffMS) * time.Millisecond
+	for d.Attempts < sub.Retry.MaxAttempts {
+		if d.Attempts > 0 {
+			select {
+			case <-ctx.Done():
+				d.Error = ctx.Err().Error()
+				wh.record(d)
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		d.Attempts++
+		wh.countDelivery(ctx, sub.ID, "attempts")
+		status, err := wh.post(ctx, sub, payload.ID, body)
+		d.StatusCode, d.Error = status, ""
+		if err == nil {
+			d.Delivered = true
+			break
+		}
+		d.Error = err.Error()
+		if errors.Is(err, ErrEgressDenied) {
+			// Retrying cannot get past the policy.
+			break
+		}
+	}
+	if d.Delivered {
+		wh.countDelivery(ctx, sub.ID, "delivered")
+	} else {
+		wh.countDelivery(ctx, sub.ID, "failed")
+		log.Printf("Webhook %s gave up on %s event after %d attempts: %s", sub.ID, ev.Type, d.Attempts, d.Error)
+	}
+	wh.record(d)
+}
+
+// countDelivery adds one to a subscription's outcome counter. Nothing is
+// counted once ctx is done or the store no longer has the subscription, so
+// a delivery still in flight when its subscription is deleted cannot bring
+// the dropped counters back.
+func (wh *Webhooks) countDelivery(ctx context.Context, id, outcome string) {
+	if ctx.Err() != nil {
+		return
+	}
+	if wh.store.countWebhook(id, outcome) {
+		webhookDeliveries.Add(id+"."+outcome, 1)
+	}
+}
+
+// post makes one delivery attempt; any status outside 2xx is a failure.
+// Each attempt is signed with its own time and nonce.
+func (wh *Webhooks) post(ctx context.Context, sub WebhookSubscription, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	for name, value := range sub.Headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Subscription", sub.ID)
+	req.Header.Set("X-Webhook-Delivery", deliveryID)
+	webhook.Sign(req.Header, sub.Secret, wh.store.Clock.Now(), uuid.New().String(), body)
+	resp, err := wh.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New(resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func (wh *Webhooks) record(d WebhookDelivery) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	wh.deliveries = append(wh.deliveries, d)
+	if len(wh.deliveries) > wh.recent {
+		wh.deliveries = wh.deliveries[len(wh.deliveries)-wh.recent:]
+	}
+}
+
+// Deliveries returns the recent deliveries to a subscription, newest first.
+func (wh *Webhooks) Deliveries(id string) []WebhookDelivery {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	result := []WebhookDelivery{}
+	for i := len(wh.deliveries) - 1; i >= 0; i-- {
+		if wh.deliveries[i].SubscriptionID == id {
+			result = append(result, wh.deliveries[i])
+		}
+	}
+	return result
+}
+
+func decodeWebhook(w http.ResponseWriter, r *http.Request, cfg Config) (WebhookSubscription, bool) {
+	var sub WebhookSubscription
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sub); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_webhook_body", err.Error())
+		return sub, false
+	}
+	if err := sub.validate(cfg); err != nil {
+		writeError(w, err)
+		return sub, false
+	}
+	return sub, true
+}
+
+func handleCreateWebhook(wh *Webhooks, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, ok := decodeWebhook(w, r, cfg)
+		if !ok {
+			return
+		}
+		sub.ID = uuid.New().String()
+		sub.CreatedAt = wh.store.Clock.Now().UTC()
+		if sub.Secret == "" {
+			sub.Secret = newWebhookSecret()
+		}
+		wh.store.SaveWebhook(sub)
+		wh.activate(sub)
+		log.Printf("Created webhook %s for %s (%s)", sub.ID, strings.Join(sub.Events, ","), requestActor(r))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+	}
+}
+
+func handleListWebhooks(wh *Webhooks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wh.store.Webhooks())
+	}
+}
+
+func handleGetWebhook(wh *Webhooks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sub, err := wh.store.Webhook(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sub)
+	}
+}
+
+func handleUpdateWebhook(wh *Webhooks, cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		old, err := wh.store.Webhook(mux.Vars(r)["id"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		sub, ok := decodeWebhook(w, r, cfg)
+		if !ok {
+			return
+		}
+		sub.ID, sub.CreatedAt = old.ID, old.CreatedAt
+		if sub.Secret == "" {
+			sub.Secret = old.Secret
+		}
+		wh.store.SaveWebhook(sub)
+		wh.activate(sub)
+		log.Printf("Updated webhook %s (%s)", sub.ID, requestActor(r))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sub)
+	}
+}
+
+func handleDeleteWebhook(wh *Webhooks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := wh.store.DeleteWebhook(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		wh.deactivate(id)
+		log.Printf("Deleted webhook %s (%s)", id, requestActor(r))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func handleWebhookDeliveries(wh *Webhooks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := wh.store.Webhook(id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(wh.Deliveries(id))
+	}
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/webhook"
+)
+
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []webhookPayload
+	headers  []http.Header
+}
+
+// newWebhookReceiver answers each delivery with the next of statuses, then
+// with 204.
+func newWebhookReceiver(statuses ...int) *webhookReceiver {
+	rc := &webhookReceiver{}
+	var calls atomic.Int64
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := int(calls.Add(1)) - 1; n < len(statuses) {
+			w.WriteHeader(statuses[n])
+			return
+		}
+		var p webhookPayload
+		json.NewDecoder(r.Body).Decode(&p)
+		rc.mu.Lock()
+		rc.payloads = append(rc.payloads, p)
+		rc.headers = append(rc.headers, r.Header.Clone())
+		rc.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return rc
+}
+
+func (rc *webhookReceiver) received() []webhookPayload {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]webhookPayload(nil), rc.payloads...)
+}
+
+func webhookHarness() *Harness {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "admin"
+	// The receivers listen on loopback, which webhooks may only reach
+	// when it is allowlisted.
+	cfg.EgressAllow = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	return NewHarness(cfg)
+}
+
+func TestWebhookSubscriptionsFilterEvents(t *testing.T) {
+	h := webhookHarness()
+	defer h.Close()
+	billing, chunks := newWebhookReceiver(), newWebhookReceiver()
+	defer billing.Close()
+	defer chunks.Close()
+
+	var billingSub, chunkSub WebhookSubscription
+	if status := adminDo(t, h, "POST", "/admin/webhooks", WebhookSubscription{URL: billing.URL, Events: []string{EventSessionClosed}, UserIDs: []string{"tenant1"}}, &billingSub); status != http.StatusCreated {
+		t.Fatalf("Expected 201, but got %d", status)
+	}
+	adminDo(t, h, "POST", "/admin/webhooks", WebhookSubscription{
+		URL:     chunks.URL,
+		Events:  []string{EventChunkProcessed, EventChunkFailed},
+		Headers: map[string]string{"Authorization": "Bearer hook"},
+	}, &chunkSub)
+	if chunkSub.Retry.MaxAttempts != 3 {
+		t.Errorf("Expected the default retry policy, but got %+v", chunkSub.Retry)
+	}
+
+	wav := SineWAV(440, 100*time.Millisecond, 8000)
+	uploadTo(t, h, "tenant1", "s1", wav)
+	uploadTo(t, h, "tenant2", "s1", wav)
+	h.Sessions.End("tenant1", "s1")
+	h.Sessions.End("tenant2", "s1")
+	h.Events.Flush()
+
+	if got := billing.received(); len(got) != 1 || got[0].Type != EventSessionClosed || got[0].Session.UserID != "tenant1" {
+		t.Errorf("Expected only tenant1's session_closed, but got %+v", got)
+	}
+	got := chunks.received()
+	if len(got) != 2 || got[0].Type != EventChunkProcessed || got[1].Type != EventChunkProcessed || got[0].Chunk == nil {
+		t.Errorf("Expected both uploads as chunk_processed, but got %+v", got)
+	}
+	if len(got) > 0 {
+		if hdr := chunks.headers[0]; hdr.Get("Authorization") != "Bearer hook" || hdr.Get("X-Webhook-Subscription") != chunkSub.ID {
+			t.Errorf("Expected the custom and subscription headers, but got %v", hdr)
+		}
+	}
+
+	var subs []WebhookSubscription
+	if adminDo(t, h, "GET", "/admin/webhooks", nil, &subs); len(subs) != 2 || subs[0].ID != billingSub.ID {
+		t.Errorf("Expected both subscriptions oldest first, but got %+v", subs)
+	}
+	if status := adminDo(t, h, "DELETE", "/admin/webhooks/"+chunkSub.ID, nil, nil); status != http.StatusNoContent {
+		t.Fatalf("Expected 204, but got %d", status)
+	}
+	uploadTo(t, h, "tenant1", "s2", wav)
+	h.Events.Flush()
+	if n := len(chunks.received()); n != 2 {
+		t.Errorf("Expected no deliveries after the subscription was deleted, but got %d", n)
+	}
+}
+
+func TestWebhookRetriesAndDeliveryLog(t *testing.T) {
+	h := webhookHarness()
+	defer h.Close()
+	rc := newWebhookReceiver(http.StatusServiceUnavailable, http.StatusInternalServerError)
+	defer rc.Close()
+
+	var reply map[string]any
+	if status := adminDo(t, h, "POST", "/admin/webhooks", WebhookSubscription{URL: "ftp://example.com", Events: []string{EventChunkProcessed}}, &reply); status != http.StatusUnprocessableEntity || reply["error"] != "invalid_webhook" {
+		t.Errorf("Expected a non-HTTP URL to be refused, but got %d %v", status, reply)
+	}
+	var sub WebhookSubscription
+	adminDo(t, h, "POST", "/admin/webhooks", WebhookSubscription{URL: rc.URL, Events: []string{EventChunkProcessed}, Retry: WebhookRetry{MaxAttempts: 3, BackoffMS: 1}}, &sub)
+
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	h.Events.Flush()
+
+	var deliveries []WebhookDelivery
+	adminDo(t, h, "GET", "/admin/webhooks/"+sub.ID+"/deliveries", nil, &deliveries)
+	if len(deliveries) != 1 || !deliveries[0].Delivered || deliveries[0].Attempts != 3 || deliveries[0].StatusCode != http.StatusNoContent {
+		t.Errorf("Expected one delivery after 3 attempts, but got %+v", deliveries)
+	}
+	if got := webhookDeliveries.Get(sub.ID + ".attempts").String(); got != "3" {
+		t.Errorf("Expected 3 attempts counted, but got %s", got)
+	}
+
+	// Updating the subscription to fewer attempts makes the next one fail.
+	rc.Close()
+	adminDo(t, h, "PUT", "/admin/webhooks/"+sub.ID, WebhookSubscription{URL: rc.URL, Events: []string{EventChunkProcessed}, Retry: WebhookRetry{MaxAttempts: 1}}, nil)
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	h.Events.Flush()
+	adminDo(t, h, "GET", "/admin/webhooks/"+sub.ID+"/deliveries", nil, &deliveries)
+	if len(deliveries) != 2 || deliveries[0].Delivered || deliveries[0].Attempts != 1 || deliveries[0].Error == "" {
+		t.Errorf("Expected the newest delivery to have failed once, but got %+v", deliveries)
+	}
+
+	// Deleting the subscription drops its counters.
+	adminDo(t, h, "DELETE", "/admin/webhooks/"+sub.ID, nil, nil)
+	for _, outcome := range []string{"attempts", "delivered", "failed"} {
+		if v := webhookDeliveries.Get(sub.ID + "." + outcome); v != nil {
+			t.Errorf("Expected the %s counter dropped, but got %s", outcome, v)
+		}
+	}
+}
+
+func TestWebhookDeliveriesSigned(t *testing.T) {
+	h := webhookHarness()
+	defer h.Close()
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan delivery, 1)
+	rc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- delivery{r.Header.Clone(), body}
+	}))
+	defer rc.Close()
+
+	var sub WebhookSubscription
+	adminDo(t, h, "POST", "/admin/webhooks", WebhookSubscription{URL: rc.URL, Events: []string{EventChunkProcessed}}, &sub)
+	if !strings.HasPrefix(sub.Secret, "whsec_") {
+		t.Fatalf("Expected a generated secret, but got %q", sub.Secret)
+	}
+	uploadTo(t, h, "user1", "s1", SineWAV(440, 100*time.Millisecond, 8000))
+	d := <-got
+
+	v := &webhook.Verifier{Secret: sub.Secret, Nonces: webhook.NewMemoryNonces()}
+	if err := v.Verify(d.header, d.body); err != nil {
+		t.Errorf("Expected the delivery to verify with the subscription's secret, but got %v", err)
+	}
+	if err := v.Verify(d.header, d.body); !errors.Is(err, webhook.ErrReplayed) {
+		t.Errorf("Expected the same delivery again to be refused as a replay, but got %v", err)
+	}
+
+	var updated WebhookSubscription
+	adminDo(t, h, "PUT", "/admin/webhooks/"+sub.ID, WebhookSubscription{URL: rc.URL, Events: []string{EventChunkProcessed}}, &updated)
+	if updated.Secret != sub.Secret {
+		t.Errorf("Expected an update without a secret to keep the old one")
+	}
+}
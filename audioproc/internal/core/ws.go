@@ -0,0 +1,515 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// wsEnvelope is a chunk or control message sent as a JSON text frame. Binary
+// frames, and text frames that are not an envelope, are treated as raw audio.
+type wsEnvelope struct {
+	Type           string          `json:"type"`
+	Data           []byte          `json:"data"`
+	ClientMetadata json.RawMessage `json:"client_metadata,omitempty"`
+
+	// Checksum verifies Data, or the chunk before it on a "checksum" frame.
+	// Trailer "checksum" announces that such a frame follows the chunk.
+	Checksum string `json:"checksum,omitempty"`
+	Trailer  string `json:"trailer,omitempty"`
+
+	// Seq numbers chunks within a session so a reconnecting client can ask,
+	// via a hello with Resume set, which ones the server already acked.
+	Seq       int64  `json:"seq,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	Resume    bool   `json:"resume,omitempty"`
+
+	// ClientTS is echoed back in the reply to a "probe" frame.
+	ClientTS json.RawMessage `json:"client_ts,omitempty"`
+
+	// ChunkID, when set, is the client's own ID for the chunk; see
+	// chunkIDFor.
+	ChunkID string `json:"chunk_id,omitempty"`
+	// AllowEmpty turns a chunk frame without audio into a heartbeat that
+	// keeps the session open instead of an empty_audio error.
+	AllowEmpty bool `json:"allow_empty,omitempty"`
+	// DeadlineMS, on a chunk, is how long the client will wait for its
+	// ack. A chunk not processed in time is dropped with a
+	// deadline_exceeded error saying how far it got.
+	DeadlineMS int64 `json:"deadline_ms,omitempty"`
+
+	// Stream, on a hello, switches the connection to streaming mode.
+	Stream *wsStreamFormat `json:"stream,omitempty"`
+
+	// ClientTime, on a hello, is the client's clock, from which the server
+	// measures its skew. RecordedAt, on a chunk, is when the client
+	// captured it by that clock.
+	ClientTime *time.Time `json:"client_time,omitempty"`
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+
+	// received is when the server read the frame.
+	received time.Time
+}
+
+var wsControlTypes = map[string]bool{"chunk": true, "checksum": true, "end_session": true, "hello": true, "probe": true}
+
+func parseWSEnvelope(msgType int, msg []byte) wsEnvelope {
+	if msgType == websocket.TextMessage {
+		var env wsEnvelope
+		if err := json.Unmarshal(msg, &env); err == nil && wsControlTypes[env.Type] {
+			return env
+		}
+	}
+	return wsEnvelope{Type: "chunk", Data: msg}
+}
+
+func wsError(code, message string) map[string]any {
+	return map[string]any{"type": "error", "error": code, "message": message}
+}
+
+// wsValidationError is the error frame for err, carrying its details.
+func wsValidationError(err *ValidationError) map[string]any {
+	frame := wsError(err.code(), err.Error())
+	if err.Details != nil {
+		frame["details"] = err.Details
+	}
+	return frame
+}
+
+// wsIngestError is the error frame for a chunk ingest refused, as by the
+// memory budget or read-only mode. The connection stays open for the
+// client to retry.
+func wsIngestError(err error) map[string]any {
+	if errors.Is(err, ErrReadOnly) {
+		readOnlyRefusals.Add("ws", 1)
+	}
+	_, code := errorStatus(err)
+	return wsError(code, errorMessage(err))
+}
+
+// handleWebSocket streams chunks for the session named by the user_id and
+// session_id query parameters, or by a hello message. An end_session message
+// closes the session.
+//
+// Chunks carrying a seq are acknowledged durably per session. A hello with
+// resume set is answered with last_seq, the highest contiguous acked seq, so
+// a reconnecting client retransmits only what is missing; retransmitted
+// seqs that were already acked are acked as duplicates and not reprocessed.
+// A chunk's seq is also its index in the session. Chunks without a seq,
+// and those whose seq another chunk already has as its index, are given
+// the next free index; the latter are acked with seq_conflict set. Acks
+// carry the index either way.
+//
+// Connections to a session enabled on recorder are recorded frame by frame
+// for later replay; see SessionRecorder.
+//
+// A {"type": "probe", "client_ts": ...} frame may be sent at any time. It is
+// answered as soon as it is read, even while a chunk is in flight, with the
+// server's receive and send times and the connection's queue depth; see
+// probeReply. Probes never reach the pipeline.
+//
+// A chunk frame without audio is refused with empty_audio unless it sets
+// allow_empty, which makes it a heartbeat: the session is kept open and
+// the frame is answered with {"type": "heartbeat"}.
+//
+// A chunk the server cannot take right now, because its memory budget or
+// dispatch lane is full or it went read-only while the chunk was processed,
+// is answered with an error frame carrying the code an HTTP upload would
+// get, such as resource_exhausted or read_only, and its seq. The connection
+// stays open so the client can retry.
+//
+// A hello with a stream format switches the connection to streaming mode:
+// binary frames, or the data of chunk frames, are then raw PCM that the
+// server cuts into chunks every WSStreamChunkDuration or
+// WSStreamChunkBytes, numbering them from 1. Each chunk is acked with a
+// stream_ack carrying its seq and the stream offset, in bytes, that it
+// reaches. end_session and closing the connection flush the final partial
+// chunk.
+//
+// A hello with client_time is answered with clock_offset_ms, how far the
+// client's clock runs ahead of the server's. Past WSClockSkewThreshold the
+// server either sends a clock_skew warning after the hello reply or, with
+// WSClockSkewAction "reject", an error carrying the offset before closing
+// the connection. The recorded_at of later chunks is moved onto the
+// server's clock, and the client's value kept in client_metadata as
+// raw_recorded_at.
+//
+// The server pings every half WSIdleTimeout and closes connections that
+// stay silent, pongs included, for a whole one.
+//
+// During shutdown the server sends {"type": "draining", "deadline": ...};
+// chunks sent after that are refused with "draining" so the client can
+// retry them on another server. See wsConns.Drain.
+//
+// In read-only mode chunks and end_session are answered with a read_only
+// error frame; the connection stays open. See ReadOnly.
+func handleWebSocket(store *MemoryStore, jobs chan Job, sessions *SessionTracker, identity IdentityProvider, keys *KeyRing, receipts *Receipts, ro *ReadOnly, g *Goroutines, conns *wsConns, recorder *SessionRecorder, cfg Config) http.HandlerFunc {
+	upgrader := newUpgrader(cfg)
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, ok := checkHandshake(cfg, keys, true, w, r)
+		if !ok {
+			return
+		}
+		userID, sessionID := r.URL.Query().Get("user_id"), r.URL.Query().Get("session_id")
+		if userID == "" {
+			userID = "user1"
+		}
+		if sessionID == "" {
+			sessionID = "sess1"
+		}
+		if err := validateIDs(cfg, userID, sessionID); err != nil {
+			wsRefusals.Add("invalid_ids", 1)
+			writeError(w, err)
+			return
+		}
+		if err := checkUserAccess(cfg, r, userID); err != nil {
+			wsRefusals.Add("other_user", 1)
+			writeError(w, err)
+			return
+		}
+		if err := validateUser(r.Context(), identity, userID); err != nil {
+			wsRefusals.Add("unknown_user", 1)
+			writeError(w, err)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			wsRefusals.Add("upgrade_failed", 1)
+			return
+		}
+		defer ws.Close()
+		// The server's read and write timeouts survive the hijack.
+		ws.NetConn().SetDeadline(time.Time{})
+		conn := conns.add(ws, userID, sessionID)
+		defer conns.remove(conn)
+		rec := recorder.Start(r, userID, sessionID)
+		defer rec.Close()
+
+		// A hijacked connection's request context outlives the server, so
+		// tie the handler to the server's lifetime and close the socket,
+		// unblocking the read below, when it stops.
+		ctx, done := g.Track(r.Context(), "ws")
+		defer done()
+		defer context.AfterFunc(ctx, func() { ws.Close() })()
+		if idle := cfg.WSIdleTimeout; idle > 0 {
+			ws.SetPongHandler(func(string) error { return ws.SetReadDeadline(time.Now().Add(idle)) })
+			pingCtx, pinged := g.Track(ctx, "ws_keepalive")
+			go func() {
+				defer pinged()
+				keepAlive(pingCtx, conn, idle/2)
+			}()
+		}
+
+		// Frames are read on their own goroutine, which must be gone
+		// before the recording is closed.
+		inbox := newWSInbox()
+		quit, stopped := make(chan struct{}), make(chan struct{})
+		_, readDone := g.Track(ctx, "ws_reader")
+		go func() {
+			defer close(stopped)
+			defer readDone()
+			inbox.read(conn, rec, cfg.WSIdleTimeout, quit)
+		}()
+		defer func() {
+			close(quit)
+			ws.Close()
+			<-stopped
+		}()
+
+		var stream *wsStream
+		// skew is the client's clock offset from its last timed hello.
+		var skew time.Duration
+		// flush ingests the stream's complete chunks, or with final all of
+		// it, and reports whether the connection can carry on.
+		flush := func(final bool) bool {
+			for stream != nil {
+				wav, ok := stream.cut(final)
+				if !ok {
+					return true
+				}
+				meta, err := ingest(ctx, store, jobs, sessions, cfg.AudioRules, AudioChunk{
+					ChunkID:   uuid.New().String(),
+					UserID:    userID,
+					SessionID: sessionID,
+					Timestamp: time.Now(),
+					Data:      wav,
+					Priority:  PriorityRealtime,
+				})
+				var invalid *ValidationError
+				switch {
+				case errors.Is(err, errSessionClosed):
+					_ = conn.WriteJSON(wsError("session_closed", err.Error()))
+				case errors.As(err, &invalid):
+					_ = conn.WriteJSON(wsValidationError(invalid))
+				case err != nil && ctx.Err() != nil:
+					return false
+				case err != nil:
+					nack := wsIngestError(err)
+					nack["seq"], nack["offset"] = stream.seq, stream.consumed
+					_ = conn.WriteJSON(nack)
+				default:
+					_ = conn.WriteJSON(map[string]any{
+						"type":     "stream_ack",
+						"ack":      true,
+						"seq":      stream.seq,
+						"offset":   stream.consumed,
+						"chunk_id": meta.ChunkID,
+						"index":    meta.Index,
+						"metadata": meta,
+					})
+				}
+			}
+			return true
+		}
+
+		for {
+			env, ok := inbox.next()
+			if !ok {
+				flush(true)
+				return
+			}
+			if env.Type == "hello" {
+				if env.SessionID != "" {
+					if err := validateIDs(cfg, userID, env.SessionID); err != nil {
+						reply := wsError("invalid_request", err.Error())
+						reply["fields"] = err.(*ValidationError).Fields
+						_ = conn.WriteJSON(reply)
+						continue
+					}
+					sessionID = env.SessionID
+					conns.switchSession(conn, userID, sessionID)
+				}
+				reply := map[string]any{"type": "hello", "session_id": sessionID}
+				if env.Stream != nil {
+					if err := env.Stream.validate(); err != nil {
+						_ = conn.WriteJSON(wsError("invalid_stream", err.Error()))
+						continue
+					}
+					if !flush(true) {
+						return
+					}
+					stream = newWSStream(cfg, *env.Stream)
+					reply["stream"] = map[string]any{"sample_rate": env.Stream.SampleRate, "channels": env.Stream.Channels, "chunk_bytes": stream.chunkBytes}
+				}
+				if env.Resume {
+					reply["last_seq"] = store.SessionAcks(sessionKey(userID, sessionID)).HighWater
+				}
+				var skewed map[string]any
+				if env.ClientTime != nil {
+					skew = clockOffset(*env.ClientTime, env.received)
+					observeClockSkew(skew)
+					reply["clock_offset_ms"] = skew.Milliseconds()
+					if threshold := cfg.WSClockSkewThreshold; threshold > 0 && max(skew, -skew) > threshold {
+						skewed = map[string]any{"offset_ms": skew.Milliseconds(), "threshold_ms": threshold.Milliseconds()}
+					}
+				}
+				if skewed != nil && cfg.WSClockSkewAction == clockSkewReject {
+					wsRefusals.Add("clock_skew", 1)
+					reply := wsError("clock_skew", "client clock is off by "+skew.String())
+					maps.Copy(reply, skewed)
+					_ = conn.WriteJSON(reply)
+					return
+				}
+				_ = conn.WriteJSON(reply)
+				if skewed != nil {
+					skewed["type"] = "clock_skew"
+					skewed["message"] = "client clock is off by " + skew.String() + "; recorded_at values are being corrected"
+					_ = conn.WriteJSON(skewed)
+				}
+				continue
+			}
+			if env.Type == "end_session" && ro.Enabled() {
+				readOnlyRefusals.Add("ws", 1)
+				_ = conn.WriteJSON(wsError("read_only", ro.Status().Message))
+				continue
+			}
+			if env.Type == "end_session" {
+				if !flush(true) {
+					return
+				}
+				if summary, ok := sessions.End(userID, sessionID); ok {
+					_ = conn.WriteJSON(SessionEvent{Type: "session_closed", Summary: summary})
+				} else {
+					_ = conn.WriteJSON(wsError("session_not_open", "no open session "+sessionKey(userID, sessionID)))
+				}
+				continue
+			}
+			if env.Type == "checksum" {
+				_ = conn.WriteJSON(wsError("unexpected_checksum", "checksum frame without a chunk announcing a trailer"))
+				continue
+			}
+			if conn.isDraining() {
+				nack := wsError("draining", "server is shutting down; retry on a new connection")
+				if env.Seq > 0 {
+					nack["seq"] = env.Seq
+				}
+				if stream != nil {
+					nack["offset"] = stream.consumed
+				}
+				_ = conn.WriteJSON(nack)
+				continue
+			}
+			if ro.Enabled() {
+				readOnlyRefusals.Add("ws", 1)
+				nack := wsError("read_only", ro.Status().Message)
+				if env.Seq > 0 {
+					nack["seq"] = env.Seq
+				}
+				_ = conn.WriteJSON(nack)
+				continue
+			}
+			if stream != nil {
+				stream.write(env.Data)
+				if !flush(false) {
+					return
+				}
+				continue
+			}
+			clientMeta, err := validateClientMetadata(env.ClientMetadata, cfg.MaxClientMetadataBytes)
+			if err != nil {
+				_ = conn.WriteJSON(wsError("invalid_client_metadata", err.Error()))
+				continue
+			}
+			checksum := env.Checksum
+			if env.Trailer == "checksum" {
+				trailer, ok := inbox.next()
+				if !ok {
+					return
+				}
+				if trailer.Type != "checksum" {
+					_ = conn.WriteJSON(wsError("checksum_missing", "expected a checksum frame after the chunk"))
+					continue
+				}
+				checksum = trailer.Checksum
+			}
+			sum := chunkChecksum(env.Data)
+			if err := verifyChecksum(sum, checksum); err != nil {
+				_ = conn.WriteJSON(wsError("checksum_mismatch", err.Error()))
+				continue
+			}
+			ackKey := sessionKey(userID, sessionID)
+			if env.Seq > 0 && store.SessionAcks(ackKey).Acked(env.Seq) {
+				_ = conn.WriteJSON(map[string]any{"ack": true, "seq": env.Seq, "duplicate": true})
+				continue
+			}
+
+			chunkID, err := chunkIDFor(cfg, env.ChunkID)
+			var invalid *ValidationError
+			if errors.As(err, &invalid) {
+				_ = conn.WriteJSON(wsValidationError(invalid))
+				continue
+			}
+			if emptyAudio(env.Data) && env.AllowEmpty {
+				sessions.KeepAlive(userID, sessionID)
+				_ = conn.WriteJSON(map[string]any{"type": "heartbeat"})
+				continue
+			}
+			release := func() {}
+			if env.ChunkID != "" {
+				existing, claimed, err := store.claimChunkID(chunkID, userID, sum)
+				var conflict *chunkIDConflict
+				switch {
+				case errors.As(err, &conflict):
+					reply := conflict.body()
+					reply["type"] = "error"
+					_ = conn.WriteJSON(reply)
+					continue
+				case err != nil:
+					_, code := errorStatus(err)
+					_ = conn.WriteJSON(wsError(code, errorMessage(err)))
+					continue
+				case existing != nil:
+					ack := map[string]any{"ack": true, "chunk_id": existing.ChunkID, "index": existing.Index, "metadata": existing, "transcript": existing.Transcript, "duplicate": true}
+					if rc := receipts.Issue(existing.ChunkID, sum, len(env.Data), existing.Timestamp); rc != nil {
+						ack["receipt"] = rc
+					}
+					if env.Seq > 0 {
+						store.RecordAck(ackKey, env.Seq)
+						ack["seq"] = env.Seq
+					}
+					_ = conn.WriteJSON(ack)
+					continue
+				}
+				release = claimed
+			}
+
+			chunk := AudioChunk{
+				ChunkID:   chunkID,
+				UserID:    userID,
+				SessionID: sessionID,
+				Timestamp: time.Now(),
+				Data:      env.Data,
+				Checksum:  sum,
+				Priority:  PriorityRealtime,
+				Index:     env.Seq,
+
+				ClientMetadata: clientMeta,
+			}
+			if env.RecordedAt != nil {
+				recordedAt, meta := correctRecordedAt(*env.RecordedAt, skew, clientMeta)
+				chunk.RecordedAt, chunk.ClientMetadata = &recordedAt, meta
+			}
+
+			chunkCtx, cancel := ctx, context.CancelFunc(func() {})
+			if env.DeadlineMS > 0 {
+				chunkCtx, cancel = withClientDeadline(ctx, time.Duration(env.DeadlineMS)*time.Millisecond)
+			}
+			meta, err := ingest(chunkCtx, store, jobs, sessions, cfg.AudioRules, chunk)
+			cancel()
+			release()
+			if errors.Is(err, errSessionClosed) {
+				_ = conn.WriteJSON(wsError("session_closed", err.Error()))
+				continue
+			}
+			var missed *deadlineError
+			if errors.As(err, &missed) {
+				nack := wsError("deadline_exceeded", err.Error())
+				nack["progress"] = missed.progress
+				if env.Seq > 0 {
+					nack["seq"] = env.Seq
+				}
+				_ = conn.WriteJSON(nack)
+				continue
+			}
+			if errors.As(err, &invalid) {
+				_ = conn.WriteJSON(wsValidationError(invalid))
+				continue
+			}
+			if err != nil && ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				nack := wsIngestError(err)
+				if env.Seq > 0 {
+					nack["seq"] = env.Seq
+				}
+				_ = conn.WriteJSON(nack)
+				continue
+			}
+			ack := map[string]any{
+				"ack":        true,
+				"chunk_id":   meta.ChunkID,
+				"index":      meta.Index,
+				"metadata":   meta,
+				"transcript": meta.Transcript,
+			}
+			if rc := receipts.Issue(meta.ChunkID, sum, len(env.Data), chunk.Timestamp); rc != nil {
+				ack["receipt"] = rc
+			}
+			if env.Seq > 0 {
+				store.RecordAck(ackKey, env.Seq)
+				ack["seq"] = env.Seq
+				if meta.Index != env.Seq {
+					ack["seq_conflict"] = true
+				}
+			}
+			_ = conn.WriteJSON(ack)
+		}
+	}
+}
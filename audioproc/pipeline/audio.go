@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// PCM is decoded audio downmixed to mono, with samples in [-1, 1].
+type PCM = core.PCM
+
+// Fingerprint returns the hex-encoded per-frame band masks of pcm.
+func Fingerprint(pcm PCM) string {
+	return core.Fingerprint(pcm)
+}
+
+// FingerprintDistance returns 0 for identical audio up to 1 for unrelated
+// audio: the share of differing peak bits at the best alignment of the two
+// fingerprints, shifted up to 64 frames either way.
+func FingerprintDistance(a, b string) float64 {
+	return core.FingerprintDistance(a, b)
+}
+
+// SimilarChunk is a match returned by GET /chunks/{id}/similar.
+type SimilarChunk = core.SimilarChunk
+
+// SineWAV generates a mono 16-bit PCM WAV file containing a sine tone, for
+// use as a deterministic audio fixture.
+func SineWAV(freqHz float64, duration time.Duration, sampleRate int) []byte {
+	return core.SineWAV(freqHz, duration, sampleRate)
+}
+
+// EncodeWAV wraps mono 16-bit samples in a canonical RIFF/WAVE container.
+func EncodeWAV(samples []int16, sampleRate int) []byte {
+	return core.EncodeWAV(samples, sampleRate)
+}
+
+// StreamInfo describes an audio stream without decoding it.
+type StreamInfo = core.StreamInfo
+
+// Marker is a cue point embedded in an uploaded WAV, such as a chapter
+// mark set on a field recorder. OffsetMS is from the start of the chunk.
+type Marker = core.Marker
+
+// EmbeddedTags are the descriptive tags found in an uploaded file: ID3v2
+// frames of MP3s and the RIFF INFO list of WAVs.
+type EmbeddedTags = core.EmbeddedTags
+
+// ReadTags returns the tags embedded in data, or nil if it has none.
+// Malformed frames are skipped; the tags found before and after them are
+// kept.
+func ReadTags(data []byte) *EmbeddedTags {
+	return core.ReadTags(data)
+}
+
+// Encoder compresses decoded audio for download.
+type Encoder = core.Encoder
+
+// AudioFormat is a format audio downloads can ask for with ?format=.
+type AudioFormat = core.AudioFormat
+
+// Transcoder encodes chunk audio on download and keeps the results, up to
+// a budget in bytes, so a chunk played again in the same format and
+// bitrate is served without encoding it again. Downloads of the same
+// encoding while it is being made wait for it rather than starting
+// another. Normalized downloads are encoded every time.
+type Transcoder = core.Transcoder
+
+// NewTranscoder caches up to cfg.TranscodeCacheBytes of encoded audio and
+// runs up to cfg.TranscodeConcurrency encodes at once, each for up to
+// cfg.TranscodeTimeout; either is unlimited when 0. With cfg.FFmpegPath set, mp3 and opus are encoded by ffmpeg;
+// register other formats with Register.
+func NewTranscoder(cfg audioproc.Config) *Transcoder {
+	return core.NewTranscoder(cfg)
+}
+
+// CommandEncoder encodes by running a program, such as ffmpeg, that reads
+// a WAV on stdin and writes the encoded audio to stdout. "{bitrate}" in
+// Args is replaced by the bitrate in kbps.
+type CommandEncoder = core.CommandEncoder
+
+// FFmpegFormats are mp3 and Ogg Opus encoded by the ffmpeg at path.
+func FFmpegFormats(path string) map[string]AudioFormat {
+	return core.FFmpegFormats(path)
+}
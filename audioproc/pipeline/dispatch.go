@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// Priority is a processing class. The zero value is interactive, the class
+// of plain HTTP uploads.
+type Priority = core.Priority
+
+const (
+	PriorityInteractive = core.PriorityInteractive
+	PriorityRealtime    = core.PriorityRealtime
+	PriorityBatch       = core.PriorityBatch
+)
+
+// Dispatcher sits between submitters and pipeline workers, queueing jobs
+// per priority class and handing them out in weighted order. Submitters
+// send on In as they would to a plain jobs channel; workers read Out, or
+// in ordered mode their own Lane.
+type Dispatcher = core.Dispatcher
+
+// NewDispatcher returns a Dispatcher that feeds every worker from one
+// shared lane.
+func NewDispatcher() *Dispatcher {
+	return core.NewDispatcher()
+}
+
+// NewOrderedDispatcher returns a Dispatcher in ordered mode with one lane
+// per worker.
+func NewOrderedDispatcher(workers int) *Dispatcher {
+	return core.NewOrderedDispatcher(workers)
+}
+
+// AdaptiveLimiter bounds how many chunks are analysed at once using AIMD:
+// each fast completion grows the limit by roughly one per window, while a
+// completion slower than the target latency, or a backed-up queue, cuts it
+// multiplicatively. It also caps the bytes held in analysis.
+type AdaptiveLimiter = core.AdaptiveLimiter
+
+// NewAdaptiveLimiter sizes its limit from cfg and the depth reported by
+// queueDepth.
+func NewAdaptiveLimiter(cfg audioproc.Config, queueDepth func() int) *AdaptiveLimiter {
+	return core.NewAdaptiveLimiter(cfg, queueDepth)
+}
+
+// MemoryBudget caps the bytes held by chunks being decoded and transcribed,
+// across all workers. A worker acquires a chunk's share before decoding it
+// and waits, in turn, while there is not enough left; the dispatcher sheds
+// new chunks once workers are waiting and the backlog is full too. A nil
+// *MemoryBudget admits everything.
+type MemoryBudget = core.MemoryBudget
+
+// NewMemoryBudget returns a budget of cfg.MemoryBudget bytes, or nil when
+// it is zero.
+func NewMemoryBudget(cfg audioproc.Config) *MemoryBudget {
+	return core.NewMemoryBudget(cfg)
+}
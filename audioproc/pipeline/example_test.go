@@ -0,0 +1,24 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/audioproctest"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+)
+
+// Run the pipeline without a server, with a transcriber of your own.
+func ExamplePipeline_Process() {
+	p := pipeline.NewPipeline(audioproc.DefaultConfig(), audioproctest.NewFakeTranscriber("good morning"), func() int { return 0 })
+	meta := p.Process(context.Background(), pipeline.AudioChunk{
+		ChunkID:   "c1",
+		UserID:    "user1",
+		SessionID: "s1",
+		Data:      pipeline.SineWAV(440, time.Second, 8000),
+	})
+	fmt.Println(meta.Status, meta.Transcript, meta.DurationMS)
+	// Output: processed good morning 1000
+}
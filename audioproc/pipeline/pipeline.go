@@ -0,0 +1,180 @@
+// Package pipeline turns uploaded audio into Metadata: Pipeline analyses
+// and transcribes an AudioChunk, and Dispatcher feeds its workers by
+// priority class.
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// AnomalyDetector flags audio that is not worth transcribing: silence from
+// muted mics, a signal stuck at one value, or a heavy DC offset from broken
+// hardware. Samples within Tolerance of each other count as constant, and
+// a mean beyond DCOffsetThreshold counts as an offset. With
+// SkipTranscription, silent and constant chunks never reach the transcriber.
+type AnomalyDetector = core.AnomalyDetector
+
+// NewAnomalyDetector builds a detector with cfg's anomaly thresholds.
+func NewAnomalyDetector(cfg audioproc.Config) *AnomalyDetector {
+	return core.NewAnomalyDetector(cfg)
+}
+
+// AudioChunk is an uploaded piece of audio on its way through the pipeline.
+type AudioChunk = core.AudioChunk
+
+// Job is a chunk queued for the workers; its Metadata is sent on Result.
+type Job = core.Job
+
+// TransformStage runs a worker with the default stub pipeline.
+func TransformStage(ctx context.Context, in <-chan Job) {
+	core.TransformStage(ctx, in)
+}
+
+// StartWorkers runs n pipeline workers until ctx is cancelled. The returned
+// WaitGroup completes once every worker has exited.
+func StartWorkers(ctx context.Context, p *Pipeline, n int, jobs <-chan Job) *sync.WaitGroup {
+	return core.StartWorkers(ctx, p, n, jobs)
+}
+
+// AudioRules limit the audio uploads accept, judged from the stream's
+// header. The zero value accepts anything.
+type AudioRules = core.AudioRules
+
+// AudioDetails is what the server could tell about audio it refused, sent
+// as the error's details so the client can see what to fix. Limit is the
+// configured bound of the broken Rule.
+type AudioDetails = core.AudioDetails
+
+// Capture is a quarantined copy of one upload, kept so a bad result can be
+// reproduced. The body is stored next to it in <id>.body.
+type Capture = core.Capture
+
+// DebugCapturer writes raw uploads for selected users, or a random sample of
+// all users, to Dir. Users in OptOut are never captured. Captures count
+// against MaxCount and MaxBytes and are purged after TTL.
+type DebugCapturer = core.DebugCapturer
+
+// NewDebugCapturer returns a capturer that is idle until a capture is
+// started.
+func NewDebugCapturer(cfg audioproc.Config) *DebugCapturer {
+	return core.NewDebugCapturer(cfg)
+}
+
+// RunCaptureSweeper ends expired captures every interval until ctx ends.
+func RunCaptureSweeper(ctx context.Context, c *DebugCapturer, interval time.Duration) {
+	core.RunCaptureSweeper(ctx, c, interval)
+}
+
+// DeadlineProgress is how far a chunk got before its caller's deadline
+// passed: the stage it was in, the stages it finished and how long it
+// had been since the deadline was set.
+type DeadlineProgress = core.DeadlineProgress
+
+// Fault is an armed failure. Probability applies to transcription_error,
+// LatencyMS to analysis_latency and EveryN to drop_ack. Injected counts
+// how often it has fired.
+type Fault = core.Fault
+
+// FaultInjector holds the faults armed for chaos testing. It only exists
+// when Config.Faults is set; a nil FaultInjector injects nothing, so the
+// pipeline's fault hooks cost nothing in production.
+type FaultInjector = core.FaultInjector
+
+// NewFaultInjector returns nil unless fault injection is enabled.
+func NewFaultInjector(cfg audioproc.Config) *FaultInjector {
+	return core.NewFaultInjector(cfg)
+}
+
+// Transcription is a transcriber's output. Words carries per-word timing
+// relative to the start of the chunk, when the engine provides it.
+type Transcription = core.Transcription
+
+// Transcriber turns a chunk's audio into text.
+type Transcriber = core.Transcriber
+
+// Analysis is what an Analyzer measured from a chunk's audio.
+// LoudnessDBFS is nil when the loudness was not measured.
+type Analysis = core.Analysis
+
+// Analyzer measures a chunk's audio. Setting Pipeline.Analyzer replaces the
+// built-in analysis, which decodes the audio itself.
+type Analyzer = core.Analyzer
+
+// Pipeline turns audio chunks into Metadata. Workers share one Pipeline, so
+// its Limiter bounds how many of them analyse chunks at once.
+type Pipeline = core.Pipeline
+
+// NewPipeline builds a pipeline whose limiter watches queueDepth, normally
+// the length of the jobs channel.
+func NewPipeline(cfg audioproc.Config, t Transcriber, queueDepth func() int) *Pipeline {
+	return core.NewPipeline(cfg, t, queueDepth)
+}
+
+// RedactionRule replaces matches of Pattern in transcripts with a
+// placeholder naming Category, e.g. "[CARD]".
+type RedactionRule = core.RedactionRule
+
+// ParseRedactionRules reads semicolon-separated rules, each either the name
+// of a builtin set (card, phone, email) or category=regexp, e.g.
+// "card;phone;email;employee_id=EMP-\d{6}". Rules listed first win where
+// matches overlap.
+func ParseRedactionRules(s string) ([]RedactionRule, error) {
+	return core.ParseRedactionRules(s)
+}
+
+// ProcessFunc runs a single chunk through the pipeline.
+type ProcessFunc = core.ProcessFunc
+
+// ReprocessFilter selects the chunks a reprocessing job runs over.
+type ReprocessFilter = core.ReprocessFilter
+
+// ReprocessStatus is a reprocessing job's progress.
+type ReprocessStatus = core.ReprocessStatus
+
+// Reprocessor runs bulk reprocessing jobs in the background. Jobs stop when
+// the context given to NewReprocessor ends and keep a cursor of the last
+// contiguously finished chunk so Resume can pick up where they left off.
+type Reprocessor = core.Reprocessor
+
+// NewReprocessor loads job checkpoints from store; jobs that were running
+// when the previous process stopped come back as interrupted.
+func NewReprocessor(ctx context.Context, st *store.MemoryStore, process ProcessFunc, cfg audioproc.Config) *Reprocessor {
+	return core.NewReprocessor(ctx, st, process, cfg)
+}
+
+// StageState is a stage's mode and, unless enabled, why and since when.
+type StageState = core.StageState
+
+// StageControl holds the runtime mode of each switchable stage. Workers
+// read it per chunk without locking, so a change applies to the next chunk
+// processed.
+type StageControl = core.StageControl
+
+// NewStageControl returns a StageControl with every stage on.
+func NewStageControl() *StageControl {
+	return core.NewStageControl()
+}
+
+// Word is one transcribed word with its timing in milliseconds.
+type Word = core.Word
+
+// WarmupStatus is one warm-up's progress as reported by /readyz.
+type WarmupStatus = core.WarmupStatus
+
+// Warmups runs the slow parts of startup concurrently once the server
+// starts. Until every critical warm-up has finished /readyz reports the
+// server as warming up, and a critical failure, including running past
+// Config.WarmupTimeout, aborts Run. Non-critical warm-ups are not timed out;
+// they keep running in the background and only their status is reported.
+type Warmups = core.Warmups
+
+// NewWarmups times critical warm-ups out after cfg.WarmupTimeout.
+func NewWarmups(cfg audioproc.Config) *Warmups {
+	return core.NewWarmups(cfg)
+}
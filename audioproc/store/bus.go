@@ -0,0 +1,30 @@
+package store
+
+import (
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// Event types published on the Bus.
+const (
+	EventChunkProcessed = core.EventChunkProcessed
+	EventChunkFailed    = core.EventChunkFailed
+	EventChunkDeleted   = core.EventChunkDeleted
+	EventSessionClosed  = core.EventSessionClosed
+	EventQuotaWarning   = core.EventQuotaWarning
+)
+
+// Event is a notification published on the Bus. Chunk is set for chunk
+// events, Session for session events and Quota for quota warnings. Seq is
+// the change feed position of chunk events.
+type Event = core.Event
+
+// Bus fans events out to subscribers without making publishers wait for
+// them. Each subscriber has its own bounded queue drained by its own
+// goroutine; when the queue is full the event is dropped for that
+// subscriber only.
+type Bus = core.Bus
+
+// NewBus returns a Bus with no subscribers.
+func NewBus() *Bus {
+	return core.NewBus()
+}
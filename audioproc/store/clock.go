@@ -0,0 +1,18 @@
+package store
+
+import (
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// Clock tells the time; tests substitute a FakeClock.
+type Clock = core.Clock
+
+// FakeClock is a manually advanced Clock for tests.
+type FakeClock = core.FakeClock
+
+// NewFakeClock returns a FakeClock stopped at start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return core.NewFakeClock(start)
+}
@@ -0,0 +1,49 @@
+package store_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+func ExampleMemoryStore() {
+	s := store.NewMemoryStore()
+	s.Save(store.Metadata{ChunkID: "c1", UserID: "user1", SessionID: "s1", Transcript: "hello"})
+	s.Save(store.Metadata{ChunkID: "c2", UserID: "user2", SessionID: "s1"})
+
+	meta, _ := s.Get("c1")
+	fmt.Println(meta.Transcript, len(s.ListByUser("user1")))
+	if _, err := s.Get("c3"); errors.Is(err, audioproc.ErrNotFound) {
+		fmt.Println("c3 not found")
+	}
+	// Output:
+	// hello 1
+	// c3 not found
+}
+
+// Keep the store across restarts in a write-ahead log.
+func ExampleOpenMemoryStore() {
+	dir, _ := os.MkdirTemp("", "wal")
+	defer os.RemoveAll(dir)
+	cfg := audioproc.DefaultConfig()
+	cfg.WALDir = dir
+
+	s, err := store.OpenMemoryStore(cfg)
+	if err != nil {
+		panic(err)
+	}
+	s.Save(store.Metadata{ChunkID: "c1", UserID: "user1", Transcript: "hello"})
+	s.Close()
+
+	s, err = store.OpenMemoryStore(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer s.Close()
+	meta, _ := s.Get("c1")
+	fmt.Println(meta.Transcript)
+	// Output: hello
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// ArchiveLocation points at one chunk inside a day's archive file.
+type ArchiveLocation = core.ArchiveLocation
+
+// Archive moves the audio of old chunks into one compressed archive file per
+// day and serves it back on demand. Each chunk is appended as its own gzip
+// member holding a single tar entry, so the file as a whole reads as a
+// .tar.gz while any chunk can be extracted from its offset without
+// decompressing the rest of the day.
+type Archive = core.Archive
+
+// NewArchive builds an archive for store and makes store read through it.
+func NewArchive(cfg audioproc.Config, store *MemoryStore) *Archive {
+	return core.NewArchive(cfg, store)
+}
+
+// RunArchiveSweeper does nothing unless an archive threshold is configured.
+// Sweeps are skipped while ro is on.
+func RunArchiveSweeper(ctx context.Context, a *Archive, ro *ReadOnly, interval time.Duration) {
+	core.RunArchiveSweeper(ctx, a, ro, interval)
+}
+
+// ChunkReader reads chunk metadata by ID; Store and CachedReader both
+// implement it.
+type ChunkReader = core.ChunkReader
+
+// CachedReader is an optional LRU in front of a slower store. Misses read
+// through to the backend and writes made through this instance are written
+// through to the cache, so a chunk just saved or patched is served without
+// a round trip. Entries expire after a TTL; writes made by other instances
+// are only seen once the entry expires or a client sends
+// Cache-Control: no-cache.
+type CachedReader = core.CachedReader
+
+// NewCachedReader caches up to size entries read from backend for ttl.
+func NewCachedReader(backend ChunkReader, size int, ttl time.Duration) *CachedReader {
+	return core.NewCachedReader(backend, size, ttl)
+}
+
+// IndexStatus describes the secondary indexes. While they are not ok,
+// lookups that would use them scan every record instead.
+type IndexStatus = core.IndexStatus
+
+// RunIndexCheck checks the indexes once at startup and rebuilds them if
+// they have drifted. It runs as a non-critical warm-up, so reads are served
+// meanwhile. The store is durable only as long as the process, so an
+// interrupted rebuild simply runs again on next start.
+func RunIndexCheck(ctx context.Context, store *MemoryStore) error {
+	return core.RunIndexCheck(ctx, store)
+}
+
+// Migrator rewrites every legacy record under the current schema in the
+// background.
+type Migrator = core.Migrator
+
+// NewMigrator upgrades legacy records in store; passes stop when ctx ends.
+func NewMigrator(ctx context.Context, store *MemoryStore) *Migrator {
+	return core.NewMigrator(ctx, store)
+}
+
+// ReadOnlyStatus is what GET and PUT /admin/read-only return.
+type ReadOnlyStatus = core.ReadOnlyStatus
+
+// ReadOnly is the maintenance switch. While it is on, reads keep working
+// but uploads and every other write are refused with 503 and Retry-After,
+// WebSocket chunks get a read_only error frame, and the sweepers that
+// write skip their runs. A nil *ReadOnly is never on.
+type ReadOnly = core.ReadOnly
+
+// NewReadOnly starts on if cfg.ReadOnly is set.
+func NewReadOnly(cfg audioproc.Config) *ReadOnly {
+	return core.NewReadOnly(cfg)
+}
+
+// ReconcileReport lists what a reconciliation pass deleted and flagged, or
+// with DryRun, what it would have.
+type ReconcileReport = core.ReconcileReport
+
+// Reconciler deletes blobs that no metadata points at and flags metadata
+// whose blob is missing. Ingest saves a chunk's blob just before its
+// metadata, so anything younger than Grace is left for a later pass.
+type Reconciler = core.Reconciler
+
+// NewReconciler checks store with cfg's grace period and rate.
+func NewReconciler(cfg audioproc.Config, store *MemoryStore) *Reconciler {
+	return core.NewReconciler(cfg, store)
+}
+
+// RunReconcileSweeper does nothing unless a reconcile interval is
+// configured. Runs are skipped while ro is on.
+func RunReconcileSweeper(ctx context.Context, r *Reconciler, ro *ReadOnly, interval time.Duration) {
+	core.RunReconcileSweeper(ctx, r, ro, interval)
+}
+
+// SessionIndexer numbers chunks within their sessions; see
+// Metadata.Index. Durable backends must keep the numbering in the store,
+// so that instances sharing it never hand out the same index twice.
+type SessionIndexer = core.SessionIndexer
+
+// RunSessionIndexSweeper drops idle sessions' numbering every interval
+// until ctx ends.
+func RunSessionIndexSweeper(ctx context.Context, store *MemoryStore, idle, interval time.Duration) {
+	core.RunSessionIndexSweeper(ctx, store, idle, interval)
+}
@@ -0,0 +1,89 @@
+// Package store keeps chunk metadata and audio. MemoryStore is the
+// built-in backend, made durable with OpenMemoryStore's write-ahead log;
+// other backends implement Store.
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// Annotation is a reviewer comment anchored at an offset within a chunk.
+type Annotation = core.Annotation
+
+// Metadata is what the service stores and returns for a processed chunk.
+type Metadata = core.Metadata
+
+// MemoryStore is the in-process Store. It also keeps the bookkeeping the
+// server needs beyond chunks: settings, checkpoints, acks, keys and usage.
+type MemoryStore = core.MemoryStore
+
+// NewMemoryStore returns an empty store with default retention.
+func NewMemoryStore() *MemoryStore {
+	return core.NewMemoryStore()
+}
+
+// RunTrashSweeper purges expired trash every interval until ctx ends,
+// skipping runs while ro is on.
+func RunTrashSweeper(ctx context.Context, store *MemoryStore, ro *ReadOnly, interval time.Duration) {
+	core.RunTrashSweeper(ctx, store, ro, interval)
+}
+
+// AuditEvent records who touched whose data. Actor is the authenticated
+// user, "admin" for the admin token, "share:<id>" for a share link, or
+// "signed_url" for a signed audio URL.
+type AuditEvent = core.AuditEvent
+
+// BillingUsage is the transcription a user was billed for in a month.
+type BillingUsage = core.BillingUsage
+
+// Change is one entry of the metadata change feed. Seq increases by one per
+// change and doubles as the cursor. Metadata is the record as it stood
+// after the change; it is omitted for purges.
+type Change = core.Change
+
+// ChangePage is a slice of the change feed. Cursor is the value to pass as
+// since on the next call. Truncated means changes after since are no longer
+// available, so the caller must resync from a full listing before applying
+// Changes.
+type ChangePage = core.ChangePage
+
+// APIKey is a key minted at runtime. Only the SHA-256 of the key is kept;
+// the plaintext is returned once, when the key is created.
+type APIKey = core.APIKey
+
+// QuotaStore is what soft quotas need from a store. Durable backends must
+// keep the warning marks, so that a restart does not repeat the day's
+// warnings.
+type QuotaStore = core.QuotaStore
+
+// SessionAcks is the durable record of which WS sequence numbers a session
+// has had acknowledged. HighWater is the highest seq below which every seq
+// was acked; Above holds acked seqs past a gap, in ascending order.
+type SessionAcks = core.SessionAcks
+
+// Revision is one saved version of a chunk's metadata. Revisions of a chunk
+// are numbered from 1; the newest is what Get returns.
+type Revision = core.Revision
+
+// SearchHit is a chunk matching a search and its relevance.
+type SearchHit = core.SearchHit
+
+// UserSettings are a user's processing preferences. Unset fields fall back
+// to the server defaults, so a user only stores what they override.
+// Uploads pick up the settings current when they arrive; changing them
+// does not touch chunks already stored.
+type UserSettings = core.UserSettings
+
+// Store persists chunk metadata and audio. Implementations return the
+// exported error kinds (ErrNotFound, ErrConflict, ErrBackendUnavailable, ...)
+// so the api handlers can translate them to HTTP statuses.
+type Store = core.Store
+
+// Transactional is implemented by stores that can apply several writes as
+// one. WithTx runs fn against a view of the store: if fn returns nil its
+// writes are applied together, and if it returns an error none of them
+// are. Reads through tx see the transaction's own writes.
+type Transactional = core.Transactional
@@ -0,0 +1,22 @@
+package store
+
+import (
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/internal/core"
+)
+
+// WAL sync policies; see Config.WALSync.
+const (
+	WALSyncAlways   = core.WALSyncAlways
+	WALSyncInterval = core.WALSyncInterval
+	WALSyncNever    = core.WALSyncNever
+)
+
+// OpenMemoryStore returns NewMemoryStore, made durable when cfg.WALDir is
+// set: the snapshot and log found there are replayed to rebuild the store,
+// and every chunk change and blob write from then on is logged before the
+// call making it returns. A torn record at the end of the log, as a crash
+// mid-write leaves, is cut off with a warning.
+func OpenMemoryStore(cfg audioproc.Config) (*MemoryStore, error) {
+	return core.OpenMemoryStore(cfg)
+}
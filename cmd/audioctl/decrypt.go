@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Kundhavi2798/audio-processor/envelope"
+)
+
+// runDecrypt turns an export bundle downloaded with a passphrase back into
+// the zip it was built as. The passphrase comes from -passphrase or
+// AUDIO_EXPORT_PASSPHRASE, so it need not appear in the shell history. A
+// wrong passphrase is an auth error. Without -o the zip itself is the
+// output; with it, the result is the file written.
+func runDecrypt(o *output, args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	passphrase := fs.String("passphrase", os.Getenv("AUDIO_EXPORT_PASSPHRASE"), "passphrase the export was started with")
+	out := fs.String("o", "", "write the zip here instead of to stdout")
+	o.flags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("decrypt: need exactly one bundle file")
+	}
+	if *passphrase == "" {
+		return usageError("decrypt: no passphrase; set -passphrase or AUDIO_EXPORT_PASSPHRASE")
+	}
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if *out == "" {
+		return decrypt(o.stdout, in, *passphrase)
+	}
+
+	// Write beside the destination and rename, so a wrong passphrase or a
+	// damaged bundle leaves no partial zip behind.
+	f, err := os.CreateTemp(filepath.Dir(*out), ".decrypt-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := decrypt(f, in, *passphrase); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), *out); err != nil {
+		return err
+	}
+	result := struct {
+		File string `json:"file"`
+	}{*out}
+	return o.result(result, func(io.Writer) {}, *out)
+}
+
+func decrypt(w io.Writer, r io.Reader, passphrase string) error {
+	plain, err := envelope.NewReader(r, passphrase)
+	if err == nil {
+		_, err = io.Copy(w, plain)
+	}
+	switch {
+	case errors.Is(err, envelope.ErrDecrypt):
+		return wrapError("decrypt_failed", exitAuth, fmt.Errorf("decrypt: %w", err))
+	case errors.Is(err, envelope.ErrFormat):
+		return wrapError("not_a_bundle", exitValidation, fmt.Errorf("decrypt: %w", err))
+	case err != nil:
+		return fmt.Errorf("decrypt: %w", err)
+	}
+	return nil
+}
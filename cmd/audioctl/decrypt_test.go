@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+)
+
+// exportBundle runs an export of user1's data on a test server and returns
+// the downloaded bundle.
+func exportBundle(t *testing.T, body string) []byte {
+	t.Helper()
+	cfg := audioproc.DefaultConfig()
+	cfg.ExportDir = t.TempDir()
+	h := api.NewHarness(cfg)
+	defer h.Close()
+	wav := pipeline.SineWAV(440, 100*time.Millisecond, 8000)
+	if resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(wav)); err != nil {
+		t.Fatal(err)
+	} else {
+		resp.Body.Close()
+	}
+
+	resp, err := http.Post(h.URL+"/users/user1/export", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st api.ExportStatus
+	json.NewDecoder(resp.Body).Decode(&st)
+	resp.Body.Close()
+	for deadline := time.Now().Add(10 * time.Second); st.DownloadURL == ""; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) || st.Error != "" {
+			t.Fatalf("Export did not finish: %+v", st)
+		}
+		resp, err := http.Get(h.URL + "/users/user1/export/" + st.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(resp.Body).Decode(&st)
+		resp.Body.Close()
+	}
+	resp, err = http.Get(h.URL + st.DownloadURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return data
+}
+
+func zipFiles(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Not a zip: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestDecryptExport(t *testing.T) {
+	sealed := exportBundle(t, `{"include_audio": true, "passphrase": "correct horse"}`)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "export.zip.enc")
+	os.WriteFile(in, sealed, 0o600)
+
+	out := filepath.Join(dir, "export.zip")
+	if code := run([]string{"decrypt", "-passphrase", "wrong horse", "-o", out, in}, io.Discard, io.Discard); code != exitAuth {
+		t.Fatalf("Expected a wrong passphrase to exit %d, but got %d", exitAuth, code)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("Expected no output after a failed decrypt, but got %v", err)
+	}
+
+	t.Setenv("AUDIO_EXPORT_PASSPHRASE", "correct horse")
+	var stdout bytes.Buffer
+	if code := run([]string{"decrypt", "-quiet", "-o", out, in}, &stdout, io.Discard); code != exitOK || stdout.String() != out+"\n" {
+		t.Fatalf("Expected the output file named, but got %d %q", code, stdout.String())
+	}
+	data, _ := os.ReadFile(out)
+	files := zipFiles(t, data)
+	var manifest api.ExportManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil || manifest.UserID != "user1" || !manifest.IncludeAudio {
+		t.Fatalf("Expected user1's manifest, but got %+v %v", manifest, err)
+	}
+	// Every file matches what the manifest recorded when it was built.
+	for _, f := range manifest.Files {
+		if int64(len(files[f.Name])) != f.Bytes {
+			t.Errorf("%s: expected %d bytes, but got %d", f.Name, f.Bytes, len(files[f.Name]))
+		}
+	}
+	if !strings.Contains(files["metadata.ndjson"], `"user_id":"user1"`) {
+		t.Errorf("Expected the chunk metadata, but got %s", files["metadata.ndjson"])
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+)
+
+// ImportConfig describes a backfill of recordings already on disk. Files
+// under Root whose path matches Layout are uploaded as chunks of the user
+// and session the layout names, Concurrency at a time and no more than
+// Rate a second.
+type ImportConfig struct {
+	URL        string
+	APIKey     string
+	AdminToken string
+	Root       string
+	// Layout maps a file's path below Root to its owner, with {user},
+	// {session} and {file} standing for one path element each and {*}
+	// for one that is ignored, e.g. "{user}/{session}/{file}".
+	Layout      string
+	Extensions  []string
+	Concurrency int
+	Rate        float64
+	// RecordedAt is where each chunk's recorded_at comes from: "tags",
+	// the date embedded in the file when it gives at least a day and the
+	// modification time otherwise; "mtime"; or "none".
+	RecordedAt string
+	// Journal, when set, records each file's outcome as it happens.
+	// Files it lists as imported or skipped are not looked at again, so
+	// an interrupted import picks up where it stopped.
+	Journal string
+}
+
+// Import outcomes.
+const (
+	importImported = "imported"
+	importSkipped  = "skipped"
+	importFailed   = "failed"
+)
+
+// ImportResult is the outcome for one file, and a line of the journal.
+type ImportResult struct {
+	Path       string     `json:"path"`
+	Size       int64      `json:"size"`
+	ModTime    time.Time  `json:"mod_time"`
+	Status     string     `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	UserID     string     `json:"user_id,omitempty"`
+	SessionID  string     `json:"session_id,omitempty"`
+	Checksum   string     `json:"checksum,omitempty"`
+	ChunkID    string     `json:"chunk_id,omitempty"`
+	RecordedAt *time.Time `json:"recorded_at,omitempty"`
+}
+
+// ImportReport sums up an import. Results lists every file considered, in
+// path order.
+type ImportReport struct {
+	Imported int            `json:"imported"`
+	Skipped  int            `json:"skipped"`
+	Failed   int            `json:"failed"`
+	Results  []ImportResult `json:"results"`
+}
+
+// runImport imports a directory. The result's identifiers are the IDs of
+// the chunks imported, and files that failed to import exit 1.
+func runImport(o *output, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	cfg := ImportConfig{}
+	fs.StringVar(&cfg.URL, "url", "http://localhost:8080", "server base URL")
+	fs.StringVar(&cfg.APIKey, "api-key", "", "API key sent with every request")
+	fs.StringVar(&cfg.AdminToken, "admin-token", os.Getenv("AUDIO_ADMIN_TOKEN"), "admin token, for importing on behalf of many users")
+	fs.StringVar(&cfg.Layout, "layout", "{user}/{session}/{file}", "path below the directory naming each file's user and session")
+	exts := fs.String("ext", ".wav,.mp3,.flac", "comma-separated file extensions to import")
+	fs.IntVar(&cfg.Concurrency, "concurrency", 4, "uploads in flight at once")
+	fs.Float64Var(&cfg.Rate, "rate", 0, "most uploads started per second (default: unlimited)")
+	fs.StringVar(&cfg.RecordedAt, "recorded-at", "tags", "where recorded_at comes from: tags, mtime or none")
+	fs.StringVar(&cfg.Journal, "journal", "", "progress journal to resume from and append to")
+	o.flags(fs)
+	jsonOut := fs.Bool("json", false, "same as -output json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if *jsonOut {
+		o.format = "json"
+	}
+	if fs.NArg() != 1 {
+		return usageError("import: need exactly one directory")
+	}
+	cfg.Root = fs.Arg(0)
+	cfg.Extensions = strings.Split(*exts, ",")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report, err := RunImport(ctx, cfg)
+	var ids []string
+	for _, res := range report.Results {
+		if res.Status == importImported {
+			ids = append(ids, res.ChunkID)
+		}
+	}
+	if err := o.result(report, report.WriteText, ids...); err != nil {
+		return err
+	}
+	if err == nil && report.Failed > 0 {
+		err = fmt.Errorf("import: %d files failed", report.Failed)
+	}
+	return err
+}
+
+// RunImport imports the files under cfg.Root and reports what became of
+// each. It stops early, with ctx's error, when ctx is cancelled; files in
+// flight then are left out of the journal and tried again next time.
+func RunImport(ctx context.Context, cfg ImportConfig) (ImportReport, error) {
+	layout, err := compileLayout(cfg.Layout)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	switch cfg.RecordedAt {
+	case "tags", "mtime", "none":
+	default:
+		return ImportReport{}, usageError("import: recorded-at must be tags, mtime or none")
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	journal, err := openJournal(cfg.Journal)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer journal.Close()
+
+	files := make(chan ImportResult)
+	var (
+		mu      sync.Mutex
+		results []ImportResult
+	)
+	record := func(res ImportResult, journaled bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, res)
+		if journaled {
+			journal.append(res)
+		}
+	}
+	var pace <-chan time.Time
+	if cfg.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.Rate))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+	im := &importer{cfg: cfg, pace: pace}
+	var wg sync.WaitGroup
+	for range cfg.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for res := range files {
+				res = im.importFile(ctx, res)
+				if ctx.Err() != nil {
+					continue
+				}
+				record(res, true)
+			}
+		}()
+	}
+
+	walkErr := filepath.WalkDir(cfg.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !hasExtension(path, cfg.Extensions) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(cfg.Root, path)
+		res := ImportResult{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()}
+		if prev, ok := journal.done[res.Path]; ok && prev.Size == res.Size && prev.ModTime.Equal(res.ModTime) {
+			prev.Status, prev.Reason = importSkipped, "already "+prev.Status+" according to the journal"
+			record(prev, false)
+			return nil
+		}
+		m := layout.FindStringSubmatch(res.Path)
+		if m == nil {
+			res.Status, res.Reason = importSkipped, "path does not match the layout"
+			record(res, true)
+			return nil
+		}
+		res.UserID, res.SessionID = m[layout.SubexpIndex("user")], m[layout.SubexpIndex("session")]
+		select {
+		case files <- res:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	close(files)
+	wg.Wait()
+
+	report := ImportReport{Results: results}
+	sort.Slice(report.Results, func(i, j int) bool { return report.Results[i].Path < report.Results[j].Path })
+	for _, res := range report.Results {
+		switch res.Status {
+		case importImported:
+			report.Imported++
+		case importSkipped:
+			report.Skipped++
+		case importFailed:
+			report.Failed++
+		}
+	}
+	return report, errors.Join(walkErr, journal.err)
+}
+
+// compileLayout turns a layout into a pattern matching whole slash
+// separated paths, with a group for each of user, session and file.
+func compileLayout(layout string) (*regexp.Regexp, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	rest := layout
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			pattern.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, usageError("import: unclosed { in layout %q", layout)
+		}
+		pattern.WriteString(regexp.QuoteMeta(rest[:open]))
+		switch name := rest[open+1 : open+end]; name {
+		case "user", "session", "file":
+			if strings.Contains(pattern.String(), "(?P<"+name+">") {
+				return nil, usageError("import: {%s} appears twice in layout %q", name, layout)
+			}
+			pattern.WriteString("(?P<" + name + ">[^/]+)")
+		case "*":
+			pattern.WriteString("[^/]+")
+		default:
+			return nil, usageError("import: unknown {%s} in layout %q", name, layout)
+		}
+		rest = rest[open+end+1:]
+	}
+	pattern.WriteString("$")
+	re := regexp.MustCompile(pattern.String())
+	if re.SubexpIndex("user") < 0 || re.SubexpIndex("session") < 0 {
+		return nil, usageError("import: layout %q must name {user} and {session}", layout)
+	}
+	return re, nil
+}
+
+func hasExtension(path string, exts []string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range exts {
+		if strings.ToLower(strings.TrimSpace(e)) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// importSignatures are the leading bytes a file with each extension must
+// start with, so a file that is plainly not what its name says fails here
+// rather than being stored as opaque audio.
+var importSignatures = map[string]func([]byte) bool{
+	".wav": func(b []byte) bool {
+		return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WAVE"
+	},
+	".flac": func(b []byte) bool { return bytes.HasPrefix(b, []byte("fLaC")) },
+	".mp3": func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("ID3")) || len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0
+	},
+}
+
+type importer struct {
+	cfg  ImportConfig
+	pace <-chan time.Time
+}
+
+// importFile uploads one file unless the server already has its audio in
+// the same session.
+func (im *importer) importFile(ctx context.Context, res ImportResult) ImportResult {
+	fail := func(format string, args ...any) ImportResult {
+		res.Status, res.Reason = importFailed, fmt.Sprintf(format, args...)
+		return res
+	}
+	data, err := os.ReadFile(filepath.Join(im.cfg.Root, filepath.FromSlash(res.Path)))
+	if err != nil {
+		return fail("%v", err)
+	}
+	ext := strings.ToLower(filepath.Ext(res.Path))
+	if ok := importSignatures[ext]; ok != nil && !ok(data) {
+		return fail("not a %s file", strings.ToUpper(ext[1:]))
+	}
+	sum := sha256.Sum256(data)
+	res.Checksum = hex.EncodeToString(sum[:])
+
+	existing, err := im.findExisting(ctx, res)
+	if err != nil {
+		return fail("checking for an earlier import: %v", err)
+	}
+	if existing != "" {
+		res.Status, res.Reason, res.ChunkID = importSkipped, "already uploaded as chunk "+existing, existing
+		return res
+	}
+	res.RecordedAt = recordedAt(im.cfg.RecordedAt, data, res.ModTime)
+
+	if im.pace != nil {
+		select {
+		case <-im.pace:
+		case <-ctx.Done():
+			return fail("%v", ctx.Err())
+		}
+	}
+	chunkID, err := im.upload(ctx, res, data)
+	if err != nil {
+		return fail("%v", err)
+	}
+	res.Status, res.ChunkID = importImported, chunkID
+	return res
+}
+
+// recordedAt is when the audio was recorded, by the given source.
+func recordedAt(source string, data []byte, modTime time.Time) *time.Time {
+	switch source {
+	case "none":
+		return nil
+	case "tags":
+		if tags := pipeline.ReadTags(data); tags != nil {
+			if t, ok := parseTagDate(tags.Date); ok {
+				return &t
+			}
+		}
+	}
+	t := modTime.UTC().Truncate(time.Second)
+	return &t
+}
+
+// tagDateLayouts are the embedded date formats precise enough to stand
+// for when a recording was made. Those without a zone are local time.
+var tagDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+func parseTagDate(s string) (time.Time, bool) {
+	for _, layout := range tagDateLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// findExisting returns the ID of a chunk in res's session with the same
+// audio, if the server has one.
+func (im *importer) findExisting(ctx context.Context, res ImportResult) (string, error) {
+	q := url.Values{"user_id": {res.UserID}}
+	var chunks []struct {
+		ChunkID   string `json:"chunk_id"`
+		SessionID string `json:"session_id"`
+	}
+	if err := im.do(ctx, "GET", "/checksums/"+res.Checksum+"?"+q.Encode(), nil, &chunks); err != nil {
+		return "", err
+	}
+	for _, c := range chunks {
+		if c.SessionID == res.SessionID {
+			return c.ChunkID, nil
+		}
+	}
+	return "", nil
+}
+
+func (im *importer) upload(ctx context.Context, res ImportResult, data []byte) (string, error) {
+	q := url.Values{"user_id": {res.UserID}, "session_id": {res.SessionID}, "priority": {"batch"}}
+	if res.RecordedAt != nil {
+		q.Set("recorded_at", res.RecordedAt.Format(time.RFC3339))
+	}
+	// Audio longer than the server's chunk limit comes back split.
+	var reply struct {
+		ChunkID       string `json:"chunk_id"`
+		ParentChunkID string `json:"parent_chunk_id"`
+	}
+	if err := im.do(ctx, "POST", "/upload?"+q.Encode(), data, &reply); err != nil {
+		return "", err
+	}
+	if reply.ParentChunkID != "" {
+		return reply.ParentChunkID, nil
+	}
+	return reply.ChunkID, nil
+}
+
+// do sends a request and decodes a 200 reply into out. Other replies are
+// returned as errors carrying the server's message.
+func (im *importer) do(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(im.cfg.URL, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if im.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+im.cfg.APIKey)
+	}
+	if im.cfg.AdminToken != "" {
+		req.Header.Set("X-Admin-Token", im.cfg.AdminToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// importJournal is the append-only record of outcomes. done holds the
+// last imported or skipped outcome of each path found when it was opened.
+type importJournal struct {
+	f    *os.File
+	w    *bufio.Writer
+	done map[string]ImportResult
+	err  error
+}
+
+// openJournal reads the journal at path and opens it for appending. A
+// torn last line, as an interrupted write leaves, is ignored. An empty
+// path keeps no journal.
+func openJournal(path string) (*importJournal, error) {
+	j := &importJournal{done: make(map[string]ImportResult)}
+	if path == "" {
+		return j, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var res ImportResult
+		if json.Unmarshal(line, &res) != nil {
+			continue
+		}
+		if res.Status == importFailed {
+			delete(j.done, res.Path)
+		} else {
+			j.done[res.Path] = res
+		}
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if err := os.Truncate(path, int64(bytes.LastIndexByte(data, '\n')+1)); err != nil {
+			return nil, err
+		}
+	}
+	if j.f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, err
+	}
+	j.w = bufio.NewWriter(j.f)
+	return j, nil
+}
+
+// append records res, flushing at once so that what has been recorded
+// survives the process being killed.
+func (j *importJournal) append(res ImportResult) {
+	if j.f == nil || j.err != nil {
+		return
+	}
+	line, _ := json.Marshal(res)
+	j.w.Write(append(line, '\n'))
+	j.err = j.w.Flush()
+}
+
+func (j *importJournal) Close() error {
+	if j.f == nil {
+		return nil
+	}
+	return j.f.Close()
+}
+
+// WriteText prints the totals, then every file that was not imported
+// with the reason why.
+func (r ImportReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "imported %d, skipped %d, failed %d\n", r.Imported, r.Skipped, r.Failed)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, res := range r.Results {
+		if res.Status != importImported {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", res.Status, res.Path, res.Reason)
+		}
+	}
+	tw.Flush()
+}
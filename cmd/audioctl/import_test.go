@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// taggedWAV appends a LIST/INFO chunk recording date to wav.
+func taggedWAV(wav []byte, date string) []byte {
+	info := []byte("INFOICRD")
+	info = binary.LittleEndian.AppendUint32(info, uint32(len(date)+1))
+	info = append(append(info, date...), 0)
+	if (len(date)+1)%2 == 1 {
+		info = append(info, 0)
+	}
+	wav = append(wav, "LIST"...)
+	wav = binary.LittleEndian.AppendUint32(wav, uint32(len(info)))
+	return append(wav, info...)
+}
+
+func writeTree(t *testing.T, root string, files map[string][]byte) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func importStatuses(report ImportReport) map[string]string {
+	got := make(map[string]string)
+	for _, res := range report.Results {
+		got[res.Path] = res.Status
+	}
+	return got
+}
+
+func TestImport(t *testing.T) {
+	h := api.NewHarness(audioproc.DefaultConfig())
+	defer h.Close()
+	root := t.TempDir()
+	writeTree(t, root, map[string][]byte{
+		"alice/s1/a.wav":       pipeline.SineWAV(440, 100*time.Millisecond, 8000),
+		"alice/s1/b.wav":       taggedWAV(pipeline.SineWAV(550, 100*time.Millisecond, 8000), "2024-05-01 09:30:00"),
+		"alice/s2/c.wav":       pipeline.SineWAV(660, 100*time.Millisecond, 8000),
+		"bob/s1/d.mp3":         append([]byte("ID3\x03\x00\x00\x00\x00\x00\x00"), make([]byte, 256)...),
+		"bob/s1/broken.wav":    []byte("this is not a wav file"),
+		"bob/s1/notes.txt":     []byte("notes"),
+		"stray.wav":            pipeline.SineWAV(770, 100*time.Millisecond, 8000),
+		"alice/s1/extra/e.wav": pipeline.SineWAV(880, 100*time.Millisecond, 8000),
+	})
+	mtime := time.Date(2023, 3, 4, 5, 6, 7, 0, time.UTC)
+	os.Chtimes(filepath.Join(root, "alice/s1/a.wav"), mtime, mtime)
+	cfg := ImportConfig{
+		URL:         h.URL,
+		Root:        root,
+		Layout:      "{user}/{session}/{file}",
+		Extensions:  []string{".wav", ".mp3"},
+		Concurrency: 3,
+		RecordedAt:  "tags",
+		Journal:     filepath.Join(t.TempDir(), "journal.jsonl"),
+	}
+
+	report, err := RunImport(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 4 || report.Failed != 1 || report.Skipped != 2 {
+		t.Fatalf("Expected 4 imported, 1 failed and 2 skipped, but got %+v", report)
+	}
+	got := importStatuses(report)
+	if got["bob/s1/broken.wav"] != importFailed || got["stray.wav"] != importSkipped || got["alice/s1/extra/e.wav"] != importSkipped {
+		t.Errorf("Expected the corrupt file to fail and those off the layout to be skipped, but got %v", got)
+	}
+	if _, ok := got["bob/s1/notes.txt"]; ok {
+		t.Errorf("Expected files with other extensions to be ignored")
+	}
+	for _, res := range report.Results {
+		if res.Path == "bob/s1/broken.wav" && res.Reason != "not a WAV file" {
+			t.Errorf("Expected the failure's reason, but got %q", res.Reason)
+		}
+	}
+
+	chunks := h.Store.List(func(store.Metadata) bool { return true })
+	if len(chunks) != 4 {
+		t.Fatalf("Expected 4 chunks on the server, but got %d", len(chunks))
+	}
+	want := map[string]time.Time{
+		"alice/s1/a.wav": mtime,
+		"alice/s1/b.wav": time.Date(2024, 5, 1, 9, 30, 0, 0, time.Local),
+	}
+	for _, res := range report.Results {
+		at, ok := want[res.Path]
+		if !ok {
+			continue
+		}
+		for _, m := range chunks {
+			if m.ChunkID == res.ChunkID && (m.RecordedAt == nil || !m.RecordedAt.Equal(at)) {
+				t.Errorf("%s: expected recorded_at %v, but got %v", res.Path, at, m.RecordedAt)
+			}
+		}
+	}
+	if len(h.Store.ListBySession("alice", "s1")) != 2 || len(h.Store.ListBySession("bob", "s1")) != 1 {
+		t.Errorf("Expected the chunks in the sessions their paths name")
+	}
+
+	report, err = RunImport(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 0 || report.Failed != 1 || report.Skipped != 6 {
+		t.Errorf("Expected a resumed import to retry only the failure, but got %+v", report)
+	}
+
+	cfg.Journal = ""
+	report, err = RunImport(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Imported != 0 || report.Skipped != 6 {
+		t.Errorf("Expected files already on the server to be skipped by checksum, but got %+v", report)
+	}
+	for _, res := range report.Results {
+		if res.Path == "alice/s2/c.wav" && !strings.HasPrefix(res.Reason, "already uploaded as chunk ") {
+			t.Errorf("Expected the duplicate's chunk in the reason, but got %q", res.Reason)
+		}
+	}
+	if n := len(h.Store.List(func(store.Metadata) bool { return true })); n != 4 {
+		t.Errorf("Expected no more chunks, but the server has %d", n)
+	}
+}
+
+func TestImportRate(t *testing.T) {
+	h := api.NewHarness(audioproc.DefaultConfig())
+	defer h.Close()
+	root := t.TempDir()
+	writeTree(t, root, map[string][]byte{
+		"u/s/1.wav": pipeline.SineWAV(300, 50*time.Millisecond, 8000),
+		"u/s/2.wav": pipeline.SineWAV(400, 50*time.Millisecond, 8000),
+		"u/s/3.wav": pipeline.SineWAV(500, 50*time.Millisecond, 8000),
+	})
+	start := time.Now()
+	report, err := RunImport(context.Background(), ImportConfig{
+		URL: h.URL, Root: root, Layout: "{user}/{session}/{file}", Extensions: []string{".wav"},
+		Concurrency: 3, Rate: 20, RecordedAt: "none",
+	})
+	if err != nil || report.Imported != 3 {
+		t.Fatalf("Expected 3 imports, but got %+v %v", report, err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected 3 uploads at 20/s to take at least 150ms, but took %v", elapsed)
+	}
+}
+
+func TestCompileLayout(t *testing.T) {
+	re, err := compileLayout("{*}/{user}/sessions/{session}/{file}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := re.FindStringSubmatch("2024/alice/sessions/s.1/x.wav")
+	if m == nil || m[re.SubexpIndex("user")] != "alice" || m[re.SubexpIndex("session")] != "s.1" {
+		t.Errorf("Expected alice and s.1, but got %q", m)
+	}
+	if re.MatchString("alice/sessions/s1/x.wav") {
+		t.Errorf("Expected each placeholder to stand for exactly one path element")
+	}
+	for _, layout := range []string{"{user}/{file}", "{user}/{session}/{user}", "{user}/{session}/{name}", "{user}/{session"} {
+		if _, err := compileLayout(layout); err == nil {
+			t.Errorf("%s: expected an error", layout)
+		}
+	}
+}
+
+func TestParseTagDate(t *testing.T) {
+	for _, tc := range []struct {
+		in string
+		ok bool
+	}{
+		{"2024-05-01", true},
+		{"2024-05-01T09:30:00Z", true},
+		{"2024-05-01 09:30", true},
+		{"2024", false},
+		{"May 2024", false},
+	} {
+		if _, ok := parseTagDate(tc.in); ok != tc.ok {
+			t.Errorf("%q: expected %v, but got %v", tc.in, tc.ok, ok)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+)
+
+// LoadTestConfig describes a load test. Each virtual client streams chunks
+// of ChunkDuration synthetic audio every Interval until Duration is up.
+// Clients start evenly spread over Warmup.
+type LoadTestConfig struct {
+	URL           string
+	APIKey        string
+	Clients       int
+	WSFraction    float64
+	Warmup        time.Duration
+	Duration      time.Duration
+	ChunkDuration time.Duration
+	SampleRate    int
+	Interval      time.Duration
+}
+
+// runLoadTest runs a load test. Its report has no identifier, so -quiet
+// prints nothing.
+func runLoadTest(o *output, args []string) error {
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	cfg := LoadTestConfig{}
+	fs.StringVar(&cfg.URL, "url", "http://localhost:8080", "server base URL")
+	fs.StringVar(&cfg.APIKey, "api-key", "", "API key sent with every request")
+	fs.IntVar(&cfg.Clients, "clients", 10, "number of concurrent virtual clients")
+	fs.Float64Var(&cfg.WSFraction, "ws-fraction", 0.5, "fraction of clients streaming over WebSocket instead of HTTP uploads")
+	fs.DurationVar(&cfg.Warmup, "warmup", 10*time.Second, "period over which clients are started")
+	fs.DurationVar(&cfg.Duration, "duration", time.Minute, "total length of the test, including warmup")
+	fs.DurationVar(&cfg.ChunkDuration, "chunk-duration", time.Second, "audio per chunk")
+	fs.IntVar(&cfg.SampleRate, "sample-rate", 16000, "sample rate of the synthetic audio")
+	fs.DurationVar(&cfg.Interval, "interval", 0, "time between a client's chunks (default: chunk-duration, i.e. real time)")
+	o.flags(fs)
+	jsonOut := fs.Bool("json", false, "same as -output json")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if *jsonOut {
+		o.format = "json"
+	}
+	if cfg.Clients <= 0 || cfg.WSFraction < 0 || cfg.WSFraction > 1 {
+		return usageError("loadtest: need clients > 0 and 0 <= ws-fraction <= 1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := RunLoadTest(ctx, cfg)
+	return o.result(report, report.WriteText)
+}
+
+// RunLoadTest runs until cfg.Duration elapses or ctx is cancelled, then
+// waits for in-flight requests and reports what it saw.
+func RunLoadTest(ctx context.Context, cfg LoadTestConfig) Report {
+	if cfg.Interval <= 0 {
+		cfg.Interval = cfg.ChunkDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	chunk := syntheticWAV(cfg.ChunkDuration, cfg.SampleRate)
+	stats := NewStats()
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i, offset := range rampSchedule(cfg.Clients, cfg.Warmup) {
+		wg.Add(1)
+		go func(i int, offset time.Duration) {
+			defer wg.Done()
+			timer := time.NewTimer(offset)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			userID := fmt.Sprintf("loadtest-%d", i)
+			sessionID := fmt.Sprintf("loadtest-%d-%d", i, start.UnixNano())
+			if clientKind(i, cfg.WSFraction) == "ws" {
+				runWSClient(ctx, cfg, userID, sessionID, chunk, stats)
+			} else {
+				runHTTPClient(ctx, cfg, userID, sessionID, chunk, stats)
+			}
+		}(i, offset)
+	}
+	wg.Wait()
+	return stats.Report(time.Since(start))
+}
+
+// rampSchedule returns the start offset of each of n clients, spread evenly
+// over warmup so the last client starts one step before it ends.
+func rampSchedule(n int, warmup time.Duration) []time.Duration {
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		offsets[i] = warmup * time.Duration(i) / time.Duration(n)
+	}
+	return offsets
+}
+
+// clientKind interleaves WebSocket and HTTP clients so that every prefix of
+// the ramp has roughly wsFraction WebSocket clients.
+func clientKind(i int, wsFraction float64) string {
+	if math.Floor(float64(i+1)*wsFraction) > math.Floor(float64(i)*wsFraction) {
+		return "ws"
+	}
+	return "http"
+}
+
+func runHTTPClient(ctx context.Context, cfg LoadTestConfig, userID, sessionID string, chunk []byte, stats *Stats) {
+	q := url.Values{"user_id": {userID}, "session_id": {sessionID}}
+	target := strings.TrimRight(cfg.URL, "/") + "/upload?" + q.Encode()
+	each(ctx, cfg.Interval, func() {
+		req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(chunk))
+		if err != nil {
+			stats.Record("http", 0, 0, err)
+			return
+		}
+		if cfg.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+		}
+		began := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		stats.Record("http", time.Since(began), len(chunk), err)
+	})
+}
+
+func runWSClient(ctx context.Context, cfg LoadTestConfig, userID, sessionID string, chunk []byte, stats *Stats) {
+	wsURL, err := websocketURL(cfg)
+	if err != nil {
+		stats.Record("ws", 0, 0, err)
+		return
+	}
+	var c *client.Client
+	defer func() {
+		if c != nil {
+			c.Close()
+		}
+	}()
+	each(ctx, cfg.Interval, func() {
+		if c == nil {
+			if c, err = client.Dial(ctx, wsURL, userID, sessionID); err != nil {
+				if ctx.Err() == nil {
+					stats.Record("ws", 0, 0, err)
+				}
+				return
+			}
+			// Unblock a pending read when the test is interrupted.
+			conn := c
+			context.AfterFunc(ctx, func() { conn.Close() })
+		}
+		began := time.Now()
+		_, err := c.Send(ctx, chunk)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			c.Close()
+			c = nil
+		}
+		stats.Record("ws", time.Since(began), len(chunk), err)
+	})
+}
+
+func websocketURL(cfg LoadTestConfig) (string, error) {
+	u, err := url.Parse(strings.TrimRight(cfg.URL, "/") + "/ws")
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	if cfg.APIKey != "" {
+		u.RawQuery = url.Values{"api_key": {cfg.APIKey}}.Encode()
+	}
+	return u.String(), nil
+}
+
+// each calls fn immediately and then every interval until ctx is done.
+// Slow calls delay the next one rather than overlapping it.
+func each(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syntheticWAV returns a mono 16-bit WAV of a 440Hz tone.
+func syntheticWAV(d time.Duration, sampleRate int) []byte {
+	n := int(d.Seconds() * float64(sampleRate))
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+2*n))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, []uint32{16})
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, 1})
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(sampleRate), uint32(2 * sampleRate)})
+	binary.Write(&buf, binary.LittleEndian, []uint16{2, 16})
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(2*n))
+	for i := 0; i < n; i++ {
+		v := math.Sin(2 * math.Pi * 440 * float64(i) / float64(sampleRate))
+		binary.Write(&buf, binary.LittleEndian, int16(v*0.5*math.MaxInt16))
+	}
+	return buf.Bytes()
+}
+
+// Stats collects per-request outcomes from all clients.
+type Stats struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+	bytes     int64
+}
+
+func NewStats() *Stats {
+	return &Stats{latencies: make(map[string][]time.Duration), errors: make(map[string]int)}
+}
+
+// Record notes one request of kind. Latency and bytes only count toward
+// percentiles and throughput when the request succeeded.
+func (s *Stats) Record(kind string, latency time.Duration, n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.errors[kind]++
+		if _, ok := s.latencies[kind]; !ok {
+			s.latencies[kind] = nil
+		}
+		return
+	}
+	s.latencies[kind] = append(s.latencies[kind], latency)
+	s.bytes += int64(n)
+}
+
+type KindReport struct {
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	P50MS     float64 `json:"p50_ms"`
+	P90MS     float64 `json:"p90_ms"`
+	P99MS     float64 `json:"p99_ms"`
+	MaxMS     float64 `json:"max_ms"`
+}
+
+type Report struct {
+	ElapsedSeconds float64               `json:"elapsed_seconds"`
+	Requests       int                   `json:"requests"`
+	Errors         int                   `json:"errors"`
+	ErrorRate      float64               `json:"error_rate"`
+	ChunksPerSec   float64               `json:"chunks_per_second"`
+	BytesPerSec    float64               `json:"bytes_per_second"`
+	ByKind         map[string]KindReport `json:"by_kind"`
+}
+
+func (s *Stats) Report(elapsed time.Duration) Report {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := Report{ElapsedSeconds: elapsed.Seconds(), ByKind: make(map[string]KindReport)}
+	ok := 0
+	for kind, lat := range s.latencies {
+		sorted := append([]time.Duration(nil), lat...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		k := KindReport{
+			Requests: len(sorted) + s.errors[kind],
+			Errors:   s.errors[kind],
+			P50MS:    ms(percentile(sorted, 50)),
+			P90MS:    ms(percentile(sorted, 90)),
+			P99MS:    ms(percentile(sorted, 99)),
+			MaxMS:    ms(percentile(sorted, 100)),
+		}
+		k.ErrorRate = rate(k.Errors, k.Requests)
+		r.ByKind[kind] = k
+		r.Requests += k.Requests
+		r.Errors += k.Errors
+		ok += len(sorted)
+	}
+	r.ErrorRate = rate(r.Errors, r.Requests)
+	if elapsed > 0 {
+		r.ChunksPerSec = float64(ok) / elapsed.Seconds()
+		r.BytesPerSec = float64(s.bytes) / elapsed.Seconds()
+	}
+	return r
+}
+
+// percentile uses the nearest-rank method on sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+func rate(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+func (r Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "elapsed %.1fs  requests %d  errors %d (%.2f%%)  throughput %.1f chunks/s  %.1f KB/s\n",
+		r.ElapsedSeconds, r.Requests, r.Errors, 100*r.ErrorRate, r.ChunksPerSec, r.BytesPerSec/1024)
+	kinds := make([]string, 0, len(r.ByKind))
+	for kind := range r.ByKind {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "kind\trequests\terrors\tp50\tp90\tp99\tmax")
+	for _, kind := range kinds {
+		k := r.ByKind[kind]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1fms\t%.1fms\t%.1fms\t%.1fms\n", kind, k.Requests, k.Errors, k.P50MS, k.P90MS, k.P99MS, k.MaxMS)
+	}
+	tw.Flush()
+}
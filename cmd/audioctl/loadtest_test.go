@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRampSchedule(t *testing.T) {
+	got := rampSchedule(4, 4*time.Second)
+	want := []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected offsets %v, but got %v", want, got)
+		}
+	}
+	for _, d := range rampSchedule(3, 0) {
+		if d != 0 {
+			t.Errorf("Expected every client to start at once without warmup, but got %v", d)
+		}
+	}
+
+	ws := 0
+	for i := 0; i < 10; i++ {
+		if clientKind(i, 0.3) == "ws" {
+			ws++
+		}
+	}
+	if ws != 3 {
+		t.Errorf("Expected 3 of 10 clients on WebSocket, but got %d", ws)
+	}
+	if clientKind(0, 0) != "http" || clientKind(0, 1) != "ws" {
+		t.Errorf("Expected fractions 0 and 1 to select a single kind")
+	}
+}
+
+func TestStatsReport(t *testing.T) {
+	stats := NewStats()
+	for i := 1; i <= 100; i++ {
+		stats.Record("http", time.Duration(i)*time.Millisecond, 10, nil)
+	}
+	stats.Record("http", 0, 0, errors.New("status 503"))
+	stats.Record("ws", 0, 0, errors.New("dial failed"))
+
+	r := stats.Report(10 * time.Second)
+	h := r.ByKind["http"]
+	if h.Requests != 101 || h.Errors != 1 || h.P50MS != 50 || h.P90MS != 90 || h.P99MS != 99 || h.MaxMS != 100 {
+		t.Errorf("Unexpected http report %+v", h)
+	}
+	if ws := r.ByKind["ws"]; ws.Requests != 1 || ws.ErrorRate != 1 {
+		t.Errorf("Expected the failed ws client to be reported, but got %+v", ws)
+	}
+	if r.Requests != 102 || r.Errors != 2 || r.ChunksPerSec != 10 || r.BytesPerSec != 100 {
+		t.Errorf("Unexpected totals %+v", r)
+	}
+
+	var text strings.Builder
+	r.WriteText(&text)
+	if !strings.Contains(text.String(), "http  101") {
+		t.Errorf("Expected a row per kind, but got:\n%s", text.String())
+	}
+}
+
+// fakeServer acks uploads and WebSocket chunks the way audio-processor does.
+func fakeServer(t *testing.T, uploads, chunks *atomic.Int64) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
+		uploads.Add(1)
+		json.NewEncoder(w).Encode(map[string]string{"chunk_id": "x"})
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var env struct {
+				Type string `json:"type"`
+				Seq  int64  `json:"seq"`
+			}
+			if err := conn.ReadJSON(&env); err != nil {
+				return
+			}
+			if env.Type == "hello" {
+				conn.WriteJSON(map[string]any{"type": "hello", "last_seq": 0})
+				continue
+			}
+			chunks.Add(1)
+			conn.WriteJSON(map[string]any{"ack": true, "seq": env.Seq, "chunk_id": "x"})
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRunLoadTest(t *testing.T) {
+	var uploads, chunks atomic.Int64
+	srv := fakeServer(t, &uploads, &chunks)
+
+	r := RunLoadTest(context.Background(), LoadTestConfig{
+		URL:           srv.URL,
+		Clients:       4,
+		WSFraction:    0.5,
+		Warmup:        100 * time.Millisecond,
+		Duration:      400 * time.Millisecond,
+		ChunkDuration: 20 * time.Millisecond,
+		SampleRate:    8000,
+	})
+	if r.Errors != 0 {
+		t.Errorf("Expected no errors, but got %+v", r)
+	}
+	// Requests cut off by the end of the test reach the server but are
+	// not reported.
+	if got := r.ByKind["http"].Requests; got == 0 || int64(got) > uploads.Load() {
+		t.Errorf("Expected up to %d http requests to be reported, but got %d", uploads.Load(), got)
+	}
+	if got := r.ByKind["ws"].Requests; got == 0 || int64(got) > chunks.Load() {
+		t.Errorf("Expected up to %d ws chunks to be reported, but got %d", chunks.Load(), got)
+	}
+	if r.ChunksPerSec <= 0 || r.BytesPerSec <= 0 {
+		t.Errorf("Expected positive throughput, but got %+v", r)
+	}
+}
+
+func TestRunLoadTestStopsOnCancel(t *testing.T) {
+	var uploads, chunks atomic.Int64
+	srv := fakeServer(t, &uploads, &chunks)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	RunLoadTest(ctx, LoadTestConfig{
+		URL:           srv.URL,
+		Clients:       2,
+		WSFraction:    0.5,
+		Duration:      time.Minute,
+		ChunkDuration: 10 * time.Millisecond,
+		SampleRate:    8000,
+	})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected cancellation to stop the test promptly, but it took %v", elapsed)
+	}
+}
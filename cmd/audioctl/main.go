@@ -0,0 +1,69 @@
+// Command audioctl is an operator tool for audio-processor servers.
+//
+// Usage:
+//
+//	audioctl loadtest [flags]
+//	audioctl replay [flags] <file>
+//	audioctl decrypt [flags] <file>
+//	audioctl verify [flags] <file>
+//	audioctl import [flags] <dir>
+//	audioctl wait [flags] chunk <id> | export <user_id> <job_id> | reprocess <job_id>
+//
+// Every command takes -output text|json and -quiet. Results go to stdout:
+// as text, as JSON with -output json, or with -quiet as just the primary
+// identifier, one per line. Errors go to stderr, as
+// {"error": {"code", "message", "exit_code", "status", "details"}} with
+// -output json, where status and details are the HTTP status and the
+// server's error body of a failed request.
+//
+// The exit status is
+//
+//	0  success
+//	1  any other failure, such as files that failed to import
+//	2  a validation error: bad flags or arguments, or a request the server
+//	   refused as invalid
+//	3  not found, on the server or on disk
+//	4  the server refused the credentials
+//	5  a server error, an unreachable server, or a job that failed
+//	6  a timeout
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+var commands = map[string]func(o *output, args []string) error{
+	"loadtest": runLoadTest,
+	"replay":   runReplay,
+	"decrypt":  runDecrypt,
+	"verify":   runVerify,
+	"import":   runImport,
+	"wait":     runWait,
+}
+
+const usage = "usage: audioctl loadtest [flags] | replay [flags] <file> | decrypt [flags] <file> | verify [flags] <file> | import [flags] <dir> | wait [flags] chunk <id> | export <user_id> <job_id> | reprocess <job_id>"
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command args name and returns its exit status.
+func run(args []string, stdout, stderr io.Writer) int {
+	o := &output{stdout: stdout, stderr: stderr, format: "text"}
+	if len(args) < 1 || commands[args[0]] == nil {
+		fmt.Fprintln(stderr, usage)
+		return exitValidation
+	}
+	err := commands[args[0]](o, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	if err != nil {
+		return o.fail(err)
+	}
+	return exitOK
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Exit codes; see the package comment.
+const (
+	exitOK         = 0
+	exitFailed     = 1
+	exitValidation = 2
+	exitNotFound   = 3
+	exitAuth       = 4
+	exitServer     = 5
+	exitTimeout    = 6
+)
+
+// output is where a command writes: its result to stdout, in the format
+// the -output and -quiet flags chose, and nothing but errors to stderr.
+type output struct {
+	stdout, stderr io.Writer
+	format         string
+	quiet          bool
+}
+
+// flags registers -output and -quiet on fs.
+func (o *output) flags(fs *flag.FlagSet) {
+	fs.StringVar(&o.format, "output", "text", "result format: text or json")
+	fs.BoolVar(&o.quiet, "quiet", false, "print only the primary identifier of the result")
+}
+
+// parse parses args into fs, reporting bad flags and a bad -output as
+// validation errors.
+func (o *output) parse(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(o.stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError("%s: %v", fs.Name(), err)
+	}
+	if o.format != "text" && o.format != "json" {
+		return usageError("%s: -output must be text or json", fs.Name())
+	}
+	return nil
+}
+
+// json reports whether results are written as JSON.
+func (o *output) json() bool { return o.format == "json" && !o.quiet }
+
+// result writes a command's result: ids one per line when quiet, v as JSON
+// with -output json, and text otherwise. Commands whose result has no
+// identifier print nothing when quiet.
+func (o *output) result(v any, text func(w io.Writer), ids ...string) error {
+	switch {
+	case o.quiet:
+		for _, id := range ids {
+			fmt.Fprintln(o.stdout, id)
+		}
+	case o.json():
+		enc := json.NewEncoder(o.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	default:
+		text(o.stdout)
+	}
+	return nil
+}
+
+// fail writes err to stderr, as {"error": {...}} with -output json, and
+// returns the exit code it maps to.
+func (o *output) fail(err error) int {
+	e := classify(err)
+	if o.format == "json" {
+		json.NewEncoder(o.stderr).Encode(struct {
+			Error *cliError `json:"error"`
+		}{e})
+	} else {
+		fmt.Fprintln(o.stderr, "audioctl:", e.Message)
+	}
+	return e.ExitCode
+}
+
+// cliError is a failure as scripts see it: the exit code, a
+// machine-readable code, and for failed requests the HTTP status and the
+// server's error body.
+type cliError struct {
+	Code     string          `json:"code"`
+	Message  string          `json:"message"`
+	ExitCode int             `json:"exit_code"`
+	Status   int             `json:"status,omitempty"`
+	Details  json.RawMessage `json:"details,omitempty"`
+
+	err error
+}
+
+func (e *cliError) Error() string { return e.Message }
+func (e *cliError) Unwrap() error { return e.err }
+
+// usageError reports a command line that does not make sense.
+func usageError(format string, args ...any) error {
+	return &cliError{Code: "usage", Message: fmt.Sprintf(format, args...), ExitCode: exitValidation}
+}
+
+// wrapError gives err a code and exit code, keeping it for errors.Is.
+func wrapError(code string, exitCode int, err error) error {
+	return &cliError{Code: code, Message: err.Error(), ExitCode: exitCode, err: err}
+}
+
+// apiError turns a reply the command did not expect into an error whose
+// exit code follows from its status. The message keeps the server's code
+// and message when it sent the usual {"error": ..., "message": ...} body.
+func apiError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	e := &cliError{Code: fmt.Sprintf("http_%d", resp.StatusCode), Status: resp.StatusCode, ExitCode: statusExitCode(resp.StatusCode)}
+	var body struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(raw, &body) == nil && body.Error != "" {
+		e.Code = body.Error
+		e.Message = fmt.Sprintf("%s: %s: %s", resp.Status, body.Error, body.Message)
+		e.Details = raw
+	} else {
+		e.Message = fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+	return e
+}
+
+func statusExitCode(status int) int {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return exitAuth
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return exitNotFound
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return exitTimeout
+	case status >= 500 || status == http.StatusTooManyRequests:
+		return exitServer
+	default:
+		return exitValidation
+	}
+}
+
+// classify finds err's exit code. Errors that are not cliErrors are
+// recognised by kind: timeouts, files that do not exist and servers that
+// cannot be reached; anything else exits 1.
+func classify(err error) *cliError {
+	var e *cliError
+	if errors.As(err, &e) {
+		// Keep the context a command added when it wrapped e.
+		c := *e
+		c.Message = err.Error()
+		return &c
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return &cliError{Code: "timeout", Message: err.Error(), ExitCode: exitTimeout, err: err}
+	case errors.Is(err, fs.ErrNotExist):
+		return &cliError{Code: "not_found", Message: err.Error(), ExitCode: exitNotFound, err: err}
+	case errors.As(err, &netErr):
+		return &cliError{Code: "unreachable", Message: err.Error(), ExitCode: exitServer, err: err}
+	}
+	return &cliError{Code: "failed", Message: err.Error(), ExitCode: exitFailed, err: err}
+}
+
+// apiClient sends a command's requests to a server.
+type apiClient struct {
+	url        string
+	apiKey     string
+	adminToken string
+}
+
+// flags registers -url, -api-key and -admin-token on fs.
+func (c *apiClient) flags(fs *flag.FlagSet) {
+	fs.StringVar(&c.url, "url", "http://localhost:8080", "server base URL")
+	fs.StringVar(&c.apiKey, "api-key", "", "API key sent with every request")
+	fs.StringVar(&c.adminToken, "admin-token", os.Getenv("AUDIO_ADMIN_TOKEN"), "admin token")
+}
+
+// do sends a request, returning the response for any 2xx status and an
+// apiError for anything else.
+func (c *apiClient) do(ctx context.Context, method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.url, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if c.adminToken != "" {
+		req.Header.Set("X-Admin-Token", c.adminToken)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, apiError(resp)
+	}
+	return resp, nil
+}
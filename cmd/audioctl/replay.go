@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+)
+
+// runReplay posts a replay file recorded by the server back to it, which
+// re-injects the frames under a new session marked replay_of. The new
+// session's ID is the result's identifier.
+func runReplay(o *output, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	var c apiClient
+	c.flags(fs)
+	o.flags(fs)
+	fast := fs.Bool("fast", false, "send frames back to back instead of with their original timing")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("replay: need exactly one replay file")
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	path := "/admin/replay"
+	if *fast {
+		path += "?speed=fast"
+	}
+	resp, err := c.do(context.Background(), "POST", path, "application/x-ndjson", f)
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	defer resp.Body.Close()
+	var result api.ReplayResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	return o.result(result, func(w io.Writer) {
+		fmt.Fprintf(w, "replayed %s as session %s: %d chunks, %d rejected\n", result.ReplayOf, result.SessionID, len(result.Chunks), result.Rejected)
+	}, result.SessionID)
+}
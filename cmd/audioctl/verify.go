@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc/client"
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+// runVerify checks an upload receipt against the server's published keys
+// and the audio file it was issued for. The receipt is the X-Upload-Receipt
+// header value or the receipt JSON from a WebSocket ack, given inline or
+// as @file. A receipt that does not verify is a validation error, and the
+// verified receipt's chunk ID is the result's identifier.
+func runVerify(o *output, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	server := fs.String("server", "http://localhost:8080", "server that issued the receipt")
+	keysURL := fs.String("keys", "", "fetch keys from this URL instead of the server's well-known path")
+	raw := fs.String("receipt", "", "the receipt, or @file to read it from a file")
+	o.flags(fs)
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *raw == "" {
+		return usageError("verify: need -receipt and exactly one audio file")
+	}
+	r, err := parseReceipt(*raw)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *keysURL == "" {
+		*keysURL = strings.TrimRight(*server, "/") + receipt.KeysPath
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	keys, err := client.FetchKeys(ctx, http.DefaultClient, *keysURL)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	if err := keys.VerifyData(r, data); err != nil {
+		return wrapError("verification_failed", exitValidation, fmt.Errorf("verify: %w", err))
+	}
+	return o.result(r, func(w io.Writer) {
+		fmt.Fprintf(w, "ok: chunk %s, %d bytes, received %s, signed with key %s\n", r.ChunkID, r.Bytes, r.ReceivedAt.Format(time.RFC3339), r.KeyID)
+	}, r.ChunkID)
+}
+
+func parseReceipt(s string) (receipt.Receipt, error) {
+	if name, ok := strings.CutPrefix(s, "@"); ok {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return receipt.Receipt{}, err
+		}
+		s = string(b)
+	}
+	s = strings.TrimSpace(s)
+	raw := []byte(s)
+	if !strings.HasPrefix(s, "{") {
+		var err error
+		if raw, err = base64.RawURLEncoding.DecodeString(s); err != nil {
+			return receipt.Receipt{}, usageError("verify: receipt is neither JSON nor a receipt header")
+		}
+	}
+	var r receipt.Receipt
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return receipt.Receipt{}, usageError("verify: %v", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/receipt"
+)
+
+func TestVerifyReceipt(t *testing.T) {
+	cfg := audioproc.DefaultConfig()
+	cfg.ReceiptKeys, _ = receipt.ParseKeys("k1=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32)))
+	h := api.NewHarness(cfg)
+	defer h.Close()
+	wav := pipeline.SineWAV(440, 100*time.Millisecond, 8000)
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(wav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	header := resp.Header.Get("X-Upload-Receipt")
+
+	dir := t.TempDir()
+	audio := filepath.Join(dir, "chunk.wav")
+	os.WriteFile(audio, wav, 0o600)
+	var out bytes.Buffer
+	o := &output{stdout: &out, stderr: io.Discard}
+	if err := runVerify(o, []string{"-server", h.URL, "-receipt", header, audio}); err != nil || !strings.HasPrefix(out.String(), "ok: chunk ") {
+		t.Fatalf("Expected the receipt to verify, but got %v %q", err, out.String())
+	}
+
+	wav[len(wav)-1] ^= 1
+	os.WriteFile(audio, wav, 0o600)
+	err = runVerify(o, []string{"-server", h.URL, "-receipt", header, audio})
+	if !errors.Is(err, receipt.ErrMismatch) || classify(err).ExitCode != exitValidation {
+		t.Errorf("Expected a changed file not to match, but got %v", err)
+	}
+}
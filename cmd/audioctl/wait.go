@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// waitTarget is something wait polls: a chunk still being processed, an
+// export or a reprocessing job.
+type waitTarget struct {
+	kind, id, path string
+}
+
+// parseWaitTarget reads wait's arguments.
+func parseWaitTarget(args []string) (waitTarget, error) {
+	switch {
+	case len(args) == 2 && args[0] == "chunk":
+		return waitTarget{"chunk", args[1], "/chunks/" + url.PathEscape(args[1])}, nil
+	case len(args) == 3 && args[0] == "export":
+		return waitTarget{"export", args[2], "/users/" + url.PathEscape(args[1]) + "/export/" + url.PathEscape(args[2])}, nil
+	case len(args) == 2 && args[0] == "reprocess":
+		return waitTarget{"reprocess", args[1], "/admin/reprocess/" + url.PathEscape(args[1])}, nil
+	}
+	return waitTarget{}, usageError("wait: need chunk <id>, export <user_id> <job_id> or reprocess <job_id>")
+}
+
+// finished reports whether a poll that got status and state found the
+// target done, and whether it succeeded. A chunk is done once it is saved,
+// which the server answers 202 until; jobs are done once they stop
+// running.
+func (t waitTarget) finished(status int, state string) (done, ok bool) {
+	if t.kind == "chunk" {
+		return status != http.StatusAccepted, state != "failed"
+	}
+	return state != "running", state == "done"
+}
+
+// runWait polls a chunk or job until it is done, then prints its final
+// status, whose identifier is the chunk or job ID. A target that ends in
+// failure exits 5 and one still going at -timeout exits 6.
+func runWait(o *output, args []string) error {
+	fs := flag.NewFlagSet("wait", flag.ContinueOnError)
+	var c apiClient
+	c.flags(fs)
+	o.flags(fs)
+	timeout := fs.Duration("timeout", 5*time.Minute, "give up after this long")
+	interval := fs.Duration("interval", time.Second, "time between polls")
+	if err := o.parse(fs, args); err != nil {
+		return err
+	}
+	t, err := parseWaitTarget(fs.Args())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	raw, state, err := poll(ctx, &c, t, *interval)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return wrapError("timeout", exitTimeout, fmt.Errorf("wait: %s %s is not done after %s: %w", t.kind, t.id, *timeout, err))
+	}
+	if err != nil {
+		return fmt.Errorf("wait: %w", err)
+	}
+	return o.result(raw, func(w io.Writer) {
+		fmt.Fprintf(w, "%s %s: %s\n", t.kind, t.id, state)
+	}, t.id)
+}
+
+// poll fetches t every interval until it is done, returning its final
+// status body and state.
+func poll(ctx context.Context, c *apiClient, t waitTarget, interval time.Duration) (json.RawMessage, string, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		resp, err := c.do(ctx, "GET", t.path, "", nil)
+		if err != nil {
+			return nil, "", err
+		}
+		raw, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, "", err
+		}
+		var st struct {
+			Status string `json:"status"`
+			State  string `json:"state"`
+		}
+		json.Unmarshal(raw, &st)
+		state := st.State
+		if state == "" {
+			state = st.Status
+		}
+		if done, ok := t.finished(resp.StatusCode, state); done {
+			if !ok {
+				return nil, "", &cliError{Code: "job_failed", Message: fmt.Sprintf("%s %s ended %s", t.kind, t.id, state), ExitCode: exitServer, Details: raw}
+			}
+			return raw, state, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/pipeline"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+// statusServer answers as a server would for chunks in each state.
+func statusServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	reply := func(status int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/chunks/ok", reply(http.StatusOK, `{"chunk_id":"ok","status":"processed"}`))
+	mux.Handle("/chunks/pending", reply(http.StatusAccepted, `{"chunk_id":"pending","status":"processing"}`))
+	mux.Handle("/chunks/failed", reply(http.StatusOK, `{"chunk_id":"failed","status":"failed"}`))
+	mux.Handle("/chunks/missing", reply(http.StatusNotFound, `{"error":"not_found","message":"chunk not found"}`))
+	mux.Handle("/chunks/secret", reply(http.StatusUnauthorized, `{"error":"unauthorized","message":"API key required"}`))
+	mux.Handle("/chunks/broken", reply(http.StatusInternalServerError, `boom`))
+	mux.Handle("/admin/replay", reply(http.StatusUnprocessableEntity, `{"error":"invalid_recording","message":"line 1 is not a frame"}`))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestExitCodes(t *testing.T) {
+	srv := statusServer(t)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	recording := filepath.Join(t.TempDir(), "recording.ndjson")
+	os.WriteFile(recording, []byte("not a frame\n"), 0o600)
+	imports := t.TempDir()
+	writeTree(t, imports, map[string][]byte{"user1/s1/a.wav": []byte("not a wav file")})
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"done", []string{"wait", "-url", srv.URL, "chunk", "ok"}, exitOK},
+		{"no command", nil, exitValidation},
+		{"no target", []string{"wait", "-url", srv.URL}, exitValidation},
+		{"bad flag", []string{"wait", "-bogus", "chunk", "ok"}, exitValidation},
+		{"bad output", []string{"wait", "-output", "yaml", "chunk", "ok"}, exitValidation},
+		{"refused request", []string{"replay", "-url", srv.URL, recording}, exitValidation},
+		{"not found", []string{"wait", "-url", srv.URL, "chunk", "missing"}, exitNotFound},
+		{"missing file", []string{"replay", "-url", srv.URL, "no-such-file"}, exitNotFound},
+		{"auth", []string{"wait", "-url", srv.URL, "chunk", "secret"}, exitAuth},
+		{"server error", []string{"wait", "-url", srv.URL, "chunk", "broken"}, exitServer},
+		{"failed", []string{"wait", "-url", srv.URL, "chunk", "failed"}, exitServer},
+		{"unreachable", []string{"wait", "-url", closed.URL, "chunk", "ok"}, exitServer},
+		{"failed files", []string{"import", "-url", srv.URL, "-quiet", imports}, exitFailed},
+		{"timeout", []string{"wait", "-url", srv.URL, "-timeout", "50ms", "-interval", "10ms", "chunk", "pending"}, exitTimeout},
+	} {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.args, &stdout, &stderr)
+		if code != tc.code {
+			t.Errorf("%s: expected exit code %d, but got %d: %s", tc.name, tc.code, code, stderr.String())
+		}
+		if code != exitOK && (stdout.Len() != 0 || stderr.Len() == 0) {
+			t.Errorf("%s: expected the error on stderr only, but got stdout %q, stderr %q", tc.name, stdout.String(), stderr.String())
+		}
+	}
+}
+
+func TestOutputModes(t *testing.T) {
+	srv := statusServer(t)
+	for _, tc := range []struct {
+		flags []string
+		want  string
+	}{
+		{nil, "chunk ok: processed\n"},
+		{[]string{"-quiet"}, "ok\n"},
+		{[]string{"-output", "json", "-quiet"}, "ok\n"},
+	} {
+		var stdout, stderr bytes.Buffer
+		args := append(append([]string{"wait", "-url", srv.URL}, tc.flags...), "chunk", "ok")
+		if code := run(args, &stdout, &stderr); code != exitOK || stdout.String() != tc.want {
+			t.Errorf("%v: expected %q, but got %d %q %s", tc.flags, tc.want, code, stdout.String(), stderr.String())
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	run([]string{"wait", "-url", srv.URL, "-output", "json", "chunk", "ok"}, &stdout, &stderr)
+	var meta struct {
+		ChunkID string `json:"chunk_id"`
+		Status  string `json:"status"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &meta); err != nil || meta.ChunkID != "ok" || meta.Status != "processed" {
+		t.Errorf("Expected the chunk's status as JSON, but got %q %v", stdout.String(), err)
+	}
+
+	stdout.Reset()
+	run([]string{"wait", "-url", srv.URL, "-output", "json", "chunk", "missing"}, &stdout, &stderr)
+	var failure struct {
+		Error struct {
+			Code     string `json:"code"`
+			Message  string `json:"message"`
+			ExitCode int    `json:"exit_code"`
+			Status   int    `json:"status"`
+			Details  struct {
+				Message string `json:"message"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &failure); err != nil || stdout.Len() != 0 {
+		t.Fatalf("Expected a JSON error on stderr only, but got %q %q %v", stdout.String(), stderr.String(), err)
+	}
+	if e := failure.Error; e.Code != "not_found" || e.ExitCode != exitNotFound || e.Status != http.StatusNotFound || e.Details.Message != "chunk not found" || e.Message == "" {
+		t.Errorf("Expected the server's error in the details, but got %+v", e)
+	}
+
+	stderr.Reset()
+	run([]string{"wait", "-url", srv.URL, "chunk", "missing"}, &stdout, &stderr)
+	if got := stderr.String(); !strings.HasPrefix(got, "audioctl: wait: 404 Not Found: not_found: chunk not found") {
+		t.Errorf("Expected a text error, but got %q", got)
+	}
+}
+
+func TestWaitForExport(t *testing.T) {
+	cfg := audioproc.DefaultConfig()
+	cfg.ExportDir = t.TempDir()
+	cfg.AdminToken = "admin"
+	h := api.NewHarness(cfg)
+	defer h.Close()
+	resp, err := http.Post(h.URL+"/upload?user_id=user1&session_id=s1", "audio/wav", bytes.NewReader(pipeline.SineWAV(440, 100*time.Millisecond, 8000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta store.Metadata
+	json.NewDecoder(resp.Body).Decode(&meta)
+	resp.Body.Close()
+	resp, err = http.Post(h.URL+"/users/user1/export", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started api.ExportStatus
+	json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"wait", "-url", h.URL, "-interval", "10ms", "-output", "json", "export", "user1", started.ID}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected the export to finish, but got %d: %s", code, stderr.String())
+	}
+	var st api.ExportStatus
+	if err := json.Unmarshal(stdout.Bytes(), &st); err != nil || st.State != "done" || st.DownloadURL == "" {
+		t.Errorf("Expected the finished export, but got %q %v", stdout.String(), err)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"wait", "-url", h.URL, "-quiet", "chunk", meta.ChunkID}, &stdout, &stderr); code != exitOK || stdout.String() != meta.ChunkID+"\n" {
+		t.Errorf("Expected the chunk ID, but got %d %q", code, stdout.String())
+	}
+	if code := run([]string{"wait", "-url", h.URL, "reprocess", "no-such-job"}, &stdout, &stderr); code != exitAuth {
+		t.Errorf("Expected a job without the admin token to exit %d, but got %d", exitAuth, code)
+	}
+	if code := run([]string{"wait", "-url", h.URL, "-admin-token", "admin", "reprocess", "no-such-job"}, &stdout, &stderr); code != exitNotFound {
+		t.Errorf("Expected an unknown job to exit %d, but got %d", exitNotFound, code)
+	}
+}
@@ -0,0 +1,33 @@
+// Command server runs an audio-processor server configured from AUDIO_*
+// environment variables until it receives SIGINT or SIGTERM.
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/Kundhavi2798/audio-processor/audioproc"
+	"github.com/Kundhavi2798/audio-processor/audioproc/api"
+	"github.com/Kundhavi2798/audio-processor/audioproc/store"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg := audioproc.LoadConfig()
+	st, err := store.OpenMemoryStore(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv := api.New(cfg, st, nil)
+	err = srv.Run(ctx)
+	if cerr := st.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,235 @@
+// Package envelope encrypts export bundles with a passphrase, so a bundle
+// shared outside the service is useless without it. The server writes the
+// format and audioctl decrypt reads it.
+//
+// An envelope is a header followed by segments. The header holds a magic
+// string, the PBKDF2-SHA256 salt and iteration count, and the segment size.
+// Each segment is up to that many bytes of plaintext sealed with AES-256-GCM
+// under the derived key, with the header as additional data and a nonce of
+// the segment's index and a flag marking the last one, so segments cannot be
+// reordered, dropped or cut off unnoticed.
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	magic      = "APENV01\n"
+	saltSize   = 16
+	headerSize = len(magic) + saltSize + 4 + 4
+	keySize    = 32
+)
+
+// Default parameters for new envelopes.
+const (
+	DefaultIterations  = 600_000
+	DefaultSegmentSize = 64 << 10
+)
+
+// Scheme and KDF name the format in Info.
+const (
+	Scheme = "aes-256-gcm-stream"
+	KDF    = "pbkdf2-sha256"
+)
+
+var (
+	// ErrDecrypt is returned for a wrong passphrase and for data that was
+	// altered or truncated; the two cannot be told apart.
+	ErrDecrypt = errors.New("envelope: wrong passphrase or corrupt data")
+	// ErrFormat is returned for data that is not an envelope.
+	ErrFormat = errors.New("envelope: not an encrypted bundle")
+)
+
+// Info describes how an envelope was sealed, without the secrets.
+type Info struct {
+	Scheme      string `json:"scheme"`
+	KDF         string `json:"kdf"`
+	Iterations  int    `json:"iterations"`
+	SegmentSize int    `json:"segment_size"`
+}
+
+// Options tune new envelopes; zero fields take the defaults.
+type Options struct {
+	Iterations  int
+	SegmentSize int
+}
+
+// NewWriter starts an envelope on w sealed with passphrase. Everything
+// written is encrypted a segment at a time; Close seals the last segment and
+// must be called for the envelope to be readable.
+func NewWriter(w io.Writer, passphrase string, opts Options) (io.WriteCloser, Info, error) {
+	if opts.Iterations <= 0 {
+		opts.Iterations = DefaultIterations
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	salt := header[len(magic) : len(magic)+saltSize]
+	if _, err := rand.Read(salt); err != nil {
+		return nil, Info{}, err
+	}
+	binary.BigEndian.PutUint32(header[len(magic)+saltSize:], uint32(opts.Iterations))
+	binary.BigEndian.PutUint32(header[len(magic)+saltSize+4:], uint32(opts.SegmentSize))
+	aead, err := newAEAD(passphrase, salt, opts.Iterations)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, Info{}, err
+	}
+	info := Info{Scheme: Scheme, KDF: KDF, Iterations: opts.Iterations, SegmentSize: opts.SegmentSize}
+	return &writer{w: w, aead: aead, header: header, buf: make([]byte, 0, opts.SegmentSize)}, info, nil
+}
+
+// NewReader opens the envelope on r with passphrase and returns a reader of
+// its plaintext. A wrong passphrase is reported by the first Read.
+func NewReader(r io.Reader, passphrase string) (io.Reader, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(magic)]) != magic {
+		return nil, ErrFormat
+	}
+	salt := header[len(magic) : len(magic)+saltSize]
+	iterations := int(binary.BigEndian.Uint32(header[len(magic)+saltSize:]))
+	segmentSize := int(binary.BigEndian.Uint32(header[len(magic)+saltSize+4:]))
+	if iterations <= 0 || segmentSize <= 0 || segmentSize > 16<<20 {
+		return nil, ErrFormat
+	}
+	aead, err := newAEAD(passphrase, salt, iterations)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		r:      bufio.NewReaderSize(r, segmentSize+aead.Overhead()+1),
+		aead:   aead,
+		header: header,
+		sealed: make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func newAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce is the segment's index followed by a byte that is 1 only for the
+// last segment.
+func nonce(index uint64, last bool) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[3:11], index)
+	if last {
+		n[11] = 1
+	}
+	return n
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	buf    []byte
+	index  uint64
+	closed bool
+}
+
+// Write buffers p, sealing full segments once more data follows them, so
+// the last segment is only sealed by Close.
+func (e *writer) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errors.New("envelope: write after close")
+	}
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == cap(e.buf) {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *writer) seal(last bool) error {
+	sealed := e.aead.Seal(nil, nonce(e.index, last), e.buf, e.header)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// Close seals the last segment. It does not close the underlying writer.
+func (e *writer) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(true)
+}
+
+type reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	sealed []byte
+	plain  []byte
+	index  uint64
+	done   bool
+}
+
+func (d *reader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next segment. A segment is the last one when
+// nothing follows it.
+func (d *reader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%w: missing final segment", ErrDecrypt)
+		}
+		return err
+	}
+	_, peekErr := d.r.Peek(1)
+	last := errors.Is(peekErr, io.EOF)
+	plain, err := d.aead.Open(d.sealed[:0:0], nonce(d.index, last), d.sealed[:n], d.header)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.index++
+	d.plain = plain
+	d.done = last
+	return nil
+}
@@ -0,0 +1,78 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+var fast = Options{Iterations: 1000, SegmentSize: 16}
+
+func seal(t *testing.T, plain []byte, passphrase string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, _, err := NewWriter(&buf, passphrase, fast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd-sized writes cross segment boundaries.
+	for len(plain) > 0 {
+		n := min(len(plain), 7)
+		if _, err := w.Write(plain[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plain = plain[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func open(sealed []byte, passphrase string) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(sealed), passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestRoundTrip(t *testing.T) {
+	// Sizes around the segment size, where the last segment is empty, short
+	// or full.
+	for _, size := range []int{0, 1, 15, 16, 17, 32, 100} {
+		plain := bytes.Repeat([]byte{'a'}, size)
+		for i := range plain {
+			plain[i] = byte(i)
+		}
+		got, err := open(seal(t, plain, "correct horse"), "correct horse")
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: Expected the plaintext back, but got %d bytes, %v", size, len(got), err)
+		}
+	}
+}
+
+func TestRejectsWrongPassphraseAndTampering(t *testing.T) {
+	plain := bytes.Repeat([]byte("bundle "), 10)
+	sealed := seal(t, plain, "correct horse")
+	if bytes.Contains(sealed, []byte("bundle")) {
+		t.Fatal("Expected the plaintext to be hidden")
+	}
+	if _, err := open(sealed, "wrong horse"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a wrong passphrase, but got %v", err)
+	}
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := open(flipped, "correct horse"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a flipped bit, but got %v", err)
+	}
+	// Cutting off whole segments leaves a non-final segment last.
+	segment := fast.SegmentSize + 16
+	if _, err := open(sealed[:headerSize+2*segment], "correct horse"); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a truncated envelope, but got %v", err)
+	}
+	if _, err := open([]byte("PK\x03\x04 plain zip"), "correct horse"); !errors.Is(err, ErrFormat) {
+		t.Errorf("Expected ErrFormat for a plain file, but got %v", err)
+	}
+}
@@ -0,0 +1,988 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: audioprocessor/v1/audio.proto
+
+package audioprocessorv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type UploadChunkRequest struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	UserId    string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	SessionId string                 `protobuf:"bytes,2,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	Data      []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// client_metadata is opaque client context, stored and returned with
+	// the chunk. Nested objects are allowed; arrays are not.
+	ClientMetadata *structpb.Struct `protobuf:"bytes,4,opt,name=client_metadata,json=clientMetadata,proto3" json:"client_metadata,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *UploadChunkRequest) Reset() {
+	*x = UploadChunkRequest{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadChunkRequest) ProtoMessage() {}
+
+func (x *UploadChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadChunkRequest.ProtoReflect.Descriptor instead.
+func (*UploadChunkRequest) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *UploadChunkRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *UploadChunkRequest) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *UploadChunkRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *UploadChunkRequest) GetClientMetadata() *structpb.Struct {
+	if x != nil {
+		return x.ClientMetadata
+	}
+	return nil
+}
+
+type GetChunkRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ChunkId       string                 `protobuf:"bytes,1,opt,name=chunk_id,json=chunkId,proto3" json:"chunk_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetChunkRequest) Reset() {
+	*x = GetChunkRequest{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetChunkRequest) ProtoMessage() {}
+
+func (x *GetChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetChunkRequest.ProtoReflect.Descriptor instead.
+func (*GetChunkRequest) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetChunkRequest) GetChunkId() string {
+	if x != nil {
+		return x.ChunkId
+	}
+	return ""
+}
+
+type ListByUserRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	UserId        string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListByUserRequest) Reset() {
+	*x = ListByUserRequest{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListByUserRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListByUserRequest) ProtoMessage() {}
+
+func (x *ListByUserRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListByUserRequest.ProtoReflect.Descriptor instead.
+func (*ListByUserRequest) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListByUserRequest) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ListByUserResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Chunks        []*Chunk               `protobuf:"bytes,1,rep,name=chunks,proto3" json:"chunks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListByUserResponse) Reset() {
+	*x = ListByUserResponse{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListByUserResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListByUserResponse) ProtoMessage() {}
+
+func (x *ListByUserResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListByUserResponse.ProtoReflect.Descriptor instead.
+func (*ListByUserResponse) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListByUserResponse) GetChunks() []*Chunk {
+	if x != nil {
+		return x.Chunks
+	}
+	return nil
+}
+
+// Chunk is a chunk's metadata. Fields match the REST form's JSON; see the
+// Metadata type for what each one holds.
+type Chunk struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	SchemaVersion        int32                  `protobuf:"varint,1,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	ChunkId              string                 `protobuf:"bytes,2,opt,name=chunk_id,json=chunkId,proto3" json:"chunk_id,omitempty"`
+	UserId               string                 `protobuf:"bytes,3,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	SessionId            string                 `protobuf:"bytes,4,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
+	SessionRevision      int32                  `protobuf:"varint,5,opt,name=session_revision,json=sessionRevision,proto3" json:"session_revision,omitempty"`
+	Index                int64                  `protobuf:"varint,6,opt,name=index,proto3" json:"index,omitempty"`
+	Timestamp            *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	RecordedAt           *timestamppb.Timestamp `protobuf:"bytes,8,opt,name=recorded_at,json=recordedAt,proto3" json:"recorded_at,omitempty"`
+	ParentChunkId        string                 `protobuf:"bytes,9,opt,name=parent_chunk_id,json=parentChunkId,proto3" json:"parent_chunk_id,omitempty"`
+	OffsetMs             int64                  `protobuf:"varint,10,opt,name=offset_ms,json=offsetMs,proto3" json:"offset_ms,omitempty"`
+	DerivedFrom          string                 `protobuf:"bytes,11,opt,name=derived_from,json=derivedFrom,proto3" json:"derived_from,omitempty"`
+	SourceIp             string                 `protobuf:"bytes,12,opt,name=source_ip,json=sourceIp,proto3" json:"source_ip,omitempty"`
+	ReplayOf             string                 `protobuf:"bytes,13,opt,name=replay_of,json=replayOf,proto3" json:"replay_of,omitempty"`
+	DurationMs           int64                  `protobuf:"varint,14,opt,name=duration_ms,json=durationMs,proto3" json:"duration_ms,omitempty"`
+	LoudnessDbfs         *float64               `protobuf:"fixed64,15,opt,name=loudness_dbfs,json=loudnessDbfs,proto3,oneof" json:"loudness_dbfs,omitempty"`
+	Checksum             string                 `protobuf:"bytes,16,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Fft                  string                 `protobuf:"bytes,17,opt,name=fft,proto3" json:"fft,omitempty"`
+	Transcript           string                 `protobuf:"bytes,18,opt,name=transcript,proto3" json:"transcript,omitempty"`
+	Words                []*Word                `protobuf:"bytes,19,rep,name=words,proto3" json:"words,omitempty"`
+	Truncated            bool                   `protobuf:"varint,20,opt,name=truncated,proto3" json:"truncated,omitempty"`
+	TranscriptBlob       string                 `protobuf:"bytes,21,opt,name=transcript_blob,json=transcriptBlob,proto3" json:"transcript_blob,omitempty"`
+	Confidence           *float64               `protobuf:"fixed64,22,opt,name=confidence,proto3,oneof" json:"confidence,omitempty"`
+	ReviewedAt           *timestamppb.Timestamp `protobuf:"bytes,23,opt,name=reviewed_at,json=reviewedAt,proto3" json:"reviewed_at,omitempty"`
+	Anomalies            []string               `protobuf:"bytes,24,rep,name=anomalies,proto3" json:"anomalies,omitempty"`
+	TranscriptSkipReason string                 `protobuf:"bytes,25,opt,name=transcript_skip_reason,json=transcriptSkipReason,proto3" json:"transcript_skip_reason,omitempty"`
+	Skipped              map[string]string      `protobuf:"bytes,26,rep,name=skipped,proto3" json:"skipped,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Redactions           map[string]int32       `protobuf:"bytes,27,rep,name=redactions,proto3" json:"redactions,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	TimedOut             []string               `protobuf:"bytes,28,rep,name=timed_out,json=timedOut,proto3" json:"timed_out,omitempty"`
+	Markers              []*Marker              `protobuf:"bytes,29,rep,name=markers,proto3" json:"markers,omitempty"`
+	EmbeddedTags         *EmbeddedTags          `protobuf:"bytes,30,opt,name=embedded_tags,json=embeddedTags,proto3" json:"embedded_tags,omitempty"`
+	Fingerprint          string                 `protobuf:"bytes,31,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	Status               string                 `protobuf:"bytes,32,opt,name=status,proto3" json:"status,omitempty"`
+	DeletedAt            *timestamppb.Timestamp `protobuf:"bytes,33,opt,name=deleted_at,json=deletedAt,proto3" json:"deleted_at,omitempty"`
+	Archive              *ArchiveLocation       `protobuf:"bytes,34,opt,name=archive,proto3" json:"archive,omitempty"`
+	IntegrityStatus      string                 `protobuf:"bytes,35,opt,name=integrity_status,json=integrityStatus,proto3" json:"integrity_status,omitempty"`
+	ClientMetadata       *structpb.Struct       `protobuf:"bytes,36,opt,name=client_metadata,json=clientMetadata,proto3" json:"client_metadata,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *Chunk) Reset() {
+	*x = Chunk{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Chunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Chunk) ProtoMessage() {}
+
+func (x *Chunk) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Chunk.ProtoReflect.Descriptor instead.
+func (*Chunk) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Chunk) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *Chunk) GetChunkId() string {
+	if x != nil {
+		return x.ChunkId
+	}
+	return ""
+}
+
+func (x *Chunk) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Chunk) GetSessionId() string {
+	if x != nil {
+		return x.SessionId
+	}
+	return ""
+}
+
+func (x *Chunk) GetSessionRevision() int32 {
+	if x != nil {
+		return x.SessionRevision
+	}
+	return 0
+}
+
+func (x *Chunk) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *Chunk) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *Chunk) GetRecordedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.RecordedAt
+	}
+	return nil
+}
+
+func (x *Chunk) GetParentChunkId() string {
+	if x != nil {
+		return x.ParentChunkId
+	}
+	return ""
+}
+
+func (x *Chunk) GetOffsetMs() int64 {
+	if x != nil {
+		return x.OffsetMs
+	}
+	return 0
+}
+
+func (x *Chunk) GetDerivedFrom() string {
+	if x != nil {
+		return x.DerivedFrom
+	}
+	return ""
+}
+
+func (x *Chunk) GetSourceIp() string {
+	if x != nil {
+		return x.SourceIp
+	}
+	return ""
+}
+
+func (x *Chunk) GetReplayOf() string {
+	if x != nil {
+		return x.ReplayOf
+	}
+	return ""
+}
+
+func (x *Chunk) GetDurationMs() int64 {
+	if x != nil {
+		return x.DurationMs
+	}
+	return 0
+}
+
+func (x *Chunk) GetLoudnessDbfs() float64 {
+	if x != nil && x.LoudnessDbfs != nil {
+		return *x.LoudnessDbfs
+	}
+	return 0
+}
+
+func (x *Chunk) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *Chunk) GetFft() string {
+	if x != nil {
+		return x.Fft
+	}
+	return ""
+}
+
+func (x *Chunk) GetTranscript() string {
+	if x != nil {
+		return x.Transcript
+	}
+	return ""
+}
+
+func (x *Chunk) GetWords() []*Word {
+	if x != nil {
+		return x.Words
+	}
+	return nil
+}
+
+func (x *Chunk) GetTruncated() bool {
+	if x != nil {
+		return x.Truncated
+	}
+	return false
+}
+
+func (x *Chunk) GetTranscriptBlob() string {
+	if x != nil {
+		return x.TranscriptBlob
+	}
+	return ""
+}
+
+func (x *Chunk) GetConfidence() float64 {
+	if x != nil && x.Confidence != nil {
+		return *x.Confidence
+	}
+	return 0
+}
+
+func (x *Chunk) GetReviewedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ReviewedAt
+	}
+	return nil
+}
+
+func (x *Chunk) GetAnomalies() []string {
+	if x != nil {
+		return x.Anomalies
+	}
+	return nil
+}
+
+func (x *Chunk) GetTranscriptSkipReason() string {
+	if x != nil {
+		return x.TranscriptSkipReason
+	}
+	return ""
+}
+
+func (x *Chunk) GetSkipped() map[string]string {
+	if x != nil {
+		return x.Skipped
+	}
+	return nil
+}
+
+func (x *Chunk) GetRedactions() map[string]int32 {
+	if x != nil {
+		return x.Redactions
+	}
+	return nil
+}
+
+func (x *Chunk) GetTimedOut() []string {
+	if x != nil {
+		return x.TimedOut
+	}
+	return nil
+}
+
+func (x *Chunk) GetMarkers() []*Marker {
+	if x != nil {
+		return x.Markers
+	}
+	return nil
+}
+
+func (x *Chunk) GetEmbeddedTags() *EmbeddedTags {
+	if x != nil {
+		return x.EmbeddedTags
+	}
+	return nil
+}
+
+func (x *Chunk) GetFingerprint() string {
+	if x != nil {
+		return x.Fingerprint
+	}
+	return ""
+}
+
+func (x *Chunk) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Chunk) GetDeletedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DeletedAt
+	}
+	return nil
+}
+
+func (x *Chunk) GetArchive() *ArchiveLocation {
+	if x != nil {
+		return x.Archive
+	}
+	return nil
+}
+
+func (x *Chunk) GetIntegrityStatus() string {
+	if x != nil {
+		return x.IntegrityStatus
+	}
+	return ""
+}
+
+func (x *Chunk) GetClientMetadata() *structpb.Struct {
+	if x != nil {
+		return x.ClientMetadata
+	}
+	return nil
+}
+
+type Word struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Text          string                 `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	StartMs       int32                  `protobuf:"varint,2,opt,name=start_ms,json=startMs,proto3" json:"start_ms,omitempty"`
+	EndMs         int32                  `protobuf:"varint,3,opt,name=end_ms,json=endMs,proto3" json:"end_ms,omitempty"`
+	Confidence    float32                `protobuf:"fixed32,4,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Word) Reset() {
+	*x = Word{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Word) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Word) ProtoMessage() {}
+
+func (x *Word) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Word.ProtoReflect.Descriptor instead.
+func (*Word) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Word) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *Word) GetStartMs() int32 {
+	if x != nil {
+		return x.StartMs
+	}
+	return 0
+}
+
+func (x *Word) GetEndMs() int32 {
+	if x != nil {
+		return x.EndMs
+	}
+	return 0
+}
+
+func (x *Word) GetConfidence() float32 {
+	if x != nil {
+		return x.Confidence
+	}
+	return 0
+}
+
+type Marker struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	OffsetMs      int64                  `protobuf:"varint,1,opt,name=offset_ms,json=offsetMs,proto3" json:"offset_ms,omitempty"`
+	Label         string                 `protobuf:"bytes,2,opt,name=label,proto3" json:"label,omitempty"`
+	Note          string                 `protobuf:"bytes,3,opt,name=note,proto3" json:"note,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Marker) Reset() {
+	*x = Marker{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Marker) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Marker) ProtoMessage() {}
+
+func (x *Marker) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Marker.ProtoReflect.Descriptor instead.
+func (*Marker) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Marker) GetOffsetMs() int64 {
+	if x != nil {
+		return x.OffsetMs
+	}
+	return 0
+}
+
+func (x *Marker) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Marker) GetNote() string {
+	if x != nil {
+		return x.Note
+	}
+	return ""
+}
+
+type EmbeddedTags struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Title         string                 `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Artist        string                 `protobuf:"bytes,2,opt,name=artist,proto3" json:"artist,omitempty"`
+	Album         string                 `protobuf:"bytes,3,opt,name=album,proto3" json:"album,omitempty"`
+	Date          string                 `protobuf:"bytes,4,opt,name=date,proto3" json:"date,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EmbeddedTags) Reset() {
+	*x = EmbeddedTags{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EmbeddedTags) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EmbeddedTags) ProtoMessage() {}
+
+func (x *EmbeddedTags) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EmbeddedTags.ProtoReflect.Descriptor instead.
+func (*EmbeddedTags) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *EmbeddedTags) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *EmbeddedTags) GetArtist() string {
+	if x != nil {
+		return x.Artist
+	}
+	return ""
+}
+
+func (x *EmbeddedTags) GetAlbum() string {
+	if x != nil {
+		return x.Album
+	}
+	return ""
+}
+
+func (x *EmbeddedTags) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+type ArchiveLocation struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	File          string                 `protobuf:"bytes,1,opt,name=file,proto3" json:"file,omitempty"`
+	Offset        int64                  `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Size          int64                  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ArchiveLocation) Reset() {
+	*x = ArchiveLocation{}
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ArchiveLocation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArchiveLocation) ProtoMessage() {}
+
+func (x *ArchiveLocation) ProtoReflect() protoreflect.Message {
+	mi := &file_audioprocessor_v1_audio_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArchiveLocation.ProtoReflect.Descriptor instead.
+func (*ArchiveLocation) Descriptor() ([]byte, []int) {
+	return file_audioprocessor_v1_audio_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ArchiveLocation) GetFile() string {
+	if x != nil {
+		return x.File
+	}
+	return ""
+}
+
+func (x *ArchiveLocation) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ArchiveLocation) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+var File_audioprocessor_v1_audio_proto protoreflect.FileDescriptor
+
+const file_audioprocessor_v1_audio_proto_rawDesc = "" +
+	"\n" +
+	"\x1daudioprocessor/v1/audio.proto\x12\x11audioprocessor.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xa2\x01\n" +
+	"\x12UploadChunkRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x02 \x01(\tR\tsessionId\x12\x12\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12@\n" +
+	"\x0fclient_metadata\x18\x04 \x01(\v2\x17.google.protobuf.StructR\x0eclientMetadata\",\n" +
+	"\x0fGetChunkRequest\x12\x19\n" +
+	"\bchunk_id\x18\x01 \x01(\tR\achunkId\",\n" +
+	"\x11ListByUserRequest\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\"F\n" +
+	"\x12ListByUserResponse\x120\n" +
+	"\x06chunks\x18\x01 \x03(\v2\x18.audioprocessor.v1.ChunkR\x06chunks\"\xff\f\n" +
+	"\x05Chunk\x12%\n" +
+	"\x0eschema_version\x18\x01 \x01(\x05R\rschemaVersion\x12\x19\n" +
+	"\bchunk_id\x18\x02 \x01(\tR\achunkId\x12\x17\n" +
+	"\auser_id\x18\x03 \x01(\tR\x06userId\x12\x1d\n" +
+	"\n" +
+	"session_id\x18\x04 \x01(\tR\tsessionId\x12)\n" +
+	"\x10session_revision\x18\x05 \x01(\x05R\x0fsessionRevision\x12\x14\n" +
+	"\x05index\x18\x06 \x01(\x03R\x05index\x128\n" +
+	"\ttimestamp\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12;\n" +
+	"\vrecorded_at\x18\b \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"recordedAt\x12&\n" +
+	"\x0fparent_chunk_id\x18\t \x01(\tR\rparentChunkId\x12\x1b\n" +
+	"\toffset_ms\x18\n" +
+	" \x01(\x03R\boffsetMs\x12!\n" +
+	"\fderived_from\x18\v \x01(\tR\vderivedFrom\x12\x1b\n" +
+	"\tsource_ip\x18\f \x01(\tR\bsourceIp\x12\x1b\n" +
+	"\treplay_of\x18\r \x01(\tR\breplayOf\x12\x1f\n" +
+	"\vduration_ms\x18\x0e \x01(\x03R\n" +
+	"durationMs\x12(\n" +
+	"\rloudness_dbfs\x18\x0f \x01(\x01H\x00R\floudnessDbfs\x88\x01\x01\x12\x1a\n" +
+	"\bchecksum\x18\x10 \x01(\tR\bchecksum\x12\x10\n" +
+	"\x03fft\x18\x11 \x01(\tR\x03fft\x12\x1e\n" +
+	"\n" +
+	"transcript\x18\x12 \x01(\tR\n" +
+	"transcript\x12-\n" +
+	"\x05words\x18\x13 \x03(\v2\x17.audioprocessor.v1.WordR\x05words\x12\x1c\n" +
+	"\ttruncated\x18\x14 \x01(\bR\ttruncated\x12'\n" +
+	"\x0ftranscript_blob\x18\x15 \x01(\tR\x0etranscriptBlob\x12#\n" +
+	"\n" +
+	"confidence\x18\x16 \x01(\x01H\x01R\n" +
+	"confidence\x88\x01\x01\x12;\n" +
+	"\vreviewed_at\x18\x17 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"reviewedAt\x12\x1c\n" +
+	"\tanomalies\x18\x18 \x03(\tR\tanomalies\x124\n" +
+	"\x16transcript_skip_reason\x18\x19 \x01(\tR\x14transcriptSkipReason\x12?\n" +
+	"\askipped\x18\x1a \x03(\v2%.audioprocessor.v1.Chunk.SkippedEntryR\askipped\x12H\n" +
+	"\n" +
+	"redactions\x18\x1b \x03(\v2(.audioprocessor.v1.Chunk.RedactionsEntryR\n" +
+	"redactions\x12\x1b\n" +
+	"\ttimed_out\x18\x1c \x03(\tR\btimedOut\x123\n" +
+	"\amarkers\x18\x1d \x03(\v2\x19.audioprocessor.v1.MarkerR\amarkers\x12D\n" +
+	"\rembedded_tags\x18\x1e \x01(\v2\x1f.audioprocessor.v1.EmbeddedTagsR\fembeddedTags\x12 \n" +
+	"\vfingerprint\x18\x1f \x01(\tR\vfingerprint\x12\x16\n" +
+	"\x06status\x18  \x01(\tR\x06status\x129\n" +
+	"\n" +
+	"deleted_at\x18! \x01(\v2\x1a.google.protobuf.TimestampR\tdeletedAt\x12<\n" +
+	"\aarchive\x18\" \x01(\v2\".audioprocessor.v1.ArchiveLocationR\aarchive\x12)\n" +
+	"\x10integrity_status\x18# \x01(\tR\x0fintegrityStatus\x12@\n" +
+	"\x0fclient_metadata\x18$ \x01(\v2\x17.google.protobuf.StructR\x0eclientMetadata\x1a:\n" +
+	"\fSkippedEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a=\n" +
+	"\x0fRedactionsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01B\x10\n" +
+	"\x0e_loudness_dbfsB\r\n" +
+	"\v_confidence\"l\n" +
+	"\x04Word\x12\x12\n" +
+	"\x04text\x18\x01 \x01(\tR\x04text\x12\x19\n" +
+	"\bstart_ms\x18\x02 \x01(\x05R\astartMs\x12\x15\n" +
+	"\x06end_ms\x18\x03 \x01(\x05R\x05endMs\x12\x1e\n" +
+	"\n" +
+	"confidence\x18\x04 \x01(\x02R\n" +
+	"confidence\"O\n" +
+	"\x06Marker\x12\x1b\n" +
+	"\toffset_ms\x18\x01 \x01(\x03R\boffsetMs\x12\x14\n" +
+	"\x05label\x18\x02 \x01(\tR\x05label\x12\x12\n" +
+	"\x04note\x18\x03 \x01(\tR\x04note\"f\n" +
+	"\fEmbeddedTags\x12\x14\n" +
+	"\x05title\x18\x01 \x01(\tR\x05title\x12\x16\n" +
+	"\x06artist\x18\x02 \x01(\tR\x06artist\x12\x14\n" +
+	"\x05album\x18\x03 \x01(\tR\x05album\x12\x12\n" +
+	"\x04date\x18\x04 \x01(\tR\x04date\"Q\n" +
+	"\x0fArchiveLocation\x12\x12\n" +
+	"\x04file\x18\x01 \x01(\tR\x04file\x12\x16\n" +
+	"\x06offset\x18\x02 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04size\x18\x03 \x01(\x03R\x04size2\x83\x02\n" +
+	"\fAudioService\x12N\n" +
+	"\vUploadChunk\x12%.audioprocessor.v1.UploadChunkRequest\x1a\x18.audioprocessor.v1.Chunk\x12H\n" +
+	"\bGetChunk\x12\".audioprocessor.v1.GetChunkRequest\x1a\x18.audioprocessor.v1.Chunk\x12Y\n" +
+	"\n" +
+	"ListByUser\x12$.audioprocessor.v1.ListByUserRequest\x1a%.audioprocessor.v1.ListByUserResponseBPZNgithub.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1;audioprocessorv1b\x06proto3"
+
+var (
+	file_audioprocessor_v1_audio_proto_rawDescOnce sync.Once
+	file_audioprocessor_v1_audio_proto_rawDescData []byte
+)
+
+func file_audioprocessor_v1_audio_proto_rawDescGZIP() []byte {
+	file_audioprocessor_v1_audio_proto_rawDescOnce.Do(func() {
+		file_audioprocessor_v1_audio_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_audioprocessor_v1_audio_proto_rawDesc), len(file_audioprocessor_v1_audio_proto_rawDesc)))
+	})
+	return file_audioprocessor_v1_audio_proto_rawDescData
+}
+
+var file_audioprocessor_v1_audio_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_audioprocessor_v1_audio_proto_goTypes = []any{
+	(*UploadChunkRequest)(nil),    // 0: audioprocessor.v1.UploadChunkRequest
+	(*GetChunkRequest)(nil),       // 1: audioprocessor.v1.GetChunkRequest
+	(*ListByUserRequest)(nil),     // 2: audioprocessor.v1.ListByUserRequest
+	(*ListByUserResponse)(nil),    // 3: audioprocessor.v1.ListByUserResponse
+	(*Chunk)(nil),                 // 4: audioprocessor.v1.Chunk
+	(*Word)(nil),                  // 5: audioprocessor.v1.Word
+	(*Marker)(nil),                // 6: audioprocessor.v1.Marker
+	(*EmbeddedTags)(nil),          // 7: audioprocessor.v1.EmbeddedTags
+	(*ArchiveLocation)(nil),       // 8: audioprocessor.v1.ArchiveLocation
+	nil,                           // 9: audioprocessor.v1.Chunk.SkippedEntry
+	nil,                           // 10: audioprocessor.v1.Chunk.RedactionsEntry
+	(*structpb.Struct)(nil),       // 11: google.protobuf.Struct
+	(*timestamppb.Timestamp)(nil), // 12: google.protobuf.Timestamp
+}
+var file_audioprocessor_v1_audio_proto_depIdxs = []int32{
+	11, // 0: audioprocessor.v1.UploadChunkRequest.client_metadata:type_name -> google.protobuf.Struct
+	4,  // 1: audioprocessor.v1.ListByUserResponse.chunks:type_name -> audioprocessor.v1.Chunk
+	12, // 2: audioprocessor.v1.Chunk.timestamp:type_name -> google.protobuf.Timestamp
+	12, // 3: audioprocessor.v1.Chunk.recorded_at:type_name -> google.protobuf.Timestamp
+	5,  // 4: audioprocessor.v1.Chunk.words:type_name -> audioprocessor.v1.Word
+	12, // 5: audioprocessor.v1.Chunk.reviewed_at:type_name -> google.protobuf.Timestamp
+	9,  // 6: audioprocessor.v1.Chunk.skipped:type_name -> audioprocessor.v1.Chunk.SkippedEntry
+	10, // 7: audioprocessor.v1.Chunk.redactions:type_name -> audioprocessor.v1.Chunk.RedactionsEntry
+	6,  // 8: audioprocessor.v1.Chunk.markers:type_name -> audioprocessor.v1.Marker
+	7,  // 9: audioprocessor.v1.Chunk.embedded_tags:type_name -> audioprocessor.v1.EmbeddedTags
+	12, // 10: audioprocessor.v1.Chunk.deleted_at:type_name -> google.protobuf.Timestamp
+	8,  // 11: audioprocessor.v1.Chunk.archive:type_name -> audioprocessor.v1.ArchiveLocation
+	11, // 12: audioprocessor.v1.Chunk.client_metadata:type_name -> google.protobuf.Struct
+	0,  // 13: audioprocessor.v1.AudioService.UploadChunk:input_type -> audioprocessor.v1.UploadChunkRequest
+	1,  // 14: audioprocessor.v1.AudioService.GetChunk:input_type -> audioprocessor.v1.GetChunkRequest
+	2,  // 15: audioprocessor.v1.AudioService.ListByUser:input_type -> audioprocessor.v1.ListByUserRequest
+	4,  // 16: audioprocessor.v1.AudioService.UploadChunk:output_type -> audioprocessor.v1.Chunk
+	4,  // 17: audioprocessor.v1.AudioService.GetChunk:output_type -> audioprocessor.v1.Chunk
+	3,  // 18: audioprocessor.v1.AudioService.ListByUser:output_type -> audioprocessor.v1.ListByUserResponse
+	16, // [16:19] is the sub-list for method output_type
+	13, // [13:16] is the sub-list for method input_type
+	13, // [13:13] is the sub-list for extension type_name
+	13, // [13:13] is the sub-list for extension extendee
+	0,  // [0:13] is the sub-list for field type_name
+}
+
+func init() { file_audioprocessor_v1_audio_proto_init() }
+func file_audioprocessor_v1_audio_proto_init() {
+	if File_audioprocessor_v1_audio_proto != nil {
+		return
+	}
+	file_audioprocessor_v1_audio_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_audioprocessor_v1_audio_proto_rawDesc), len(file_audioprocessor_v1_audio_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_audioprocessor_v1_audio_proto_goTypes,
+		DependencyIndexes: file_audioprocessor_v1_audio_proto_depIdxs,
+		MessageInfos:      file_audioprocessor_v1_audio_proto_msgTypes,
+	}.Build()
+	File_audioprocessor_v1_audio_proto = out.File
+	file_audioprocessor_v1_audio_proto_goTypes = nil
+	file_audioprocessor_v1_audio_proto_depIdxs = nil
+}
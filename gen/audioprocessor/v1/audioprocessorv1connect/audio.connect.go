@@ -0,0 +1,171 @@
+// Code generated by protoc-gen-connect-go. DO NOT EDIT.
+//
+// Source: audioprocessor/v1/audio.proto
+
+package audioprocessorv1connect
+
+import (
+	connect "connectrpc.com/connect"
+	context "context"
+	errors "errors"
+	v1 "github.com/Kundhavi2798/audio-processor/gen/audioprocessor/v1"
+	http "net/http"
+	strings "strings"
+)
+
+// This is a compile-time assertion to ensure that this generated file and the connect package are
+// compatible. If you get a compiler error that this constant is not defined, this code was
+// generated with a version of connect newer than the one compiled into your binary. You can fix the
+// problem by either regenerating this code with an older version of connect or updating the connect
+// version compiled into your binary.
+const _ = connect.IsAtLeastVersion1_13_0
+
+const (
+	// AudioServiceName is the fully-qualified name of the AudioService service.
+	AudioServiceName = "audioprocessor.v1.AudioService"
+)
+
+// These constants are the fully-qualified names of the RPCs defined in this package. They're
+// exposed at runtime as Spec.Procedure and as the final two segments of the HTTP route.
+//
+// Note that these are different from the fully-qualified method names used by
+// google.golang.org/protobuf/reflect/protoreflect. To convert from these constants to
+// reflection-formatted method names, remove the leading slash and convert the remaining slash to a
+// period.
+const (
+	// AudioServiceUploadChunkProcedure is the fully-qualified name of the AudioService's UploadChunk
+	// RPC.
+	AudioServiceUploadChunkProcedure = "/audioprocessor.v1.AudioService/UploadChunk"
+	// AudioServiceGetChunkProcedure is the fully-qualified name of the AudioService's GetChunk RPC.
+	AudioServiceGetChunkProcedure = "/audioprocessor.v1.AudioService/GetChunk"
+	// AudioServiceListByUserProcedure is the fully-qualified name of the AudioService's ListByUser RPC.
+	AudioServiceListByUserProcedure = "/audioprocessor.v1.AudioService/ListByUser"
+)
+
+// AudioServiceClient is a client for the audioprocessor.v1.AudioService service.
+type AudioServiceClient interface {
+	// UploadChunk stores and processes one chunk, as POST /upload does.
+	UploadChunk(context.Context, *connect.Request[v1.UploadChunkRequest]) (*connect.Response[v1.Chunk], error)
+	// GetChunk returns a chunk's metadata, as GET /chunks/{id} does.
+	GetChunk(context.Context, *connect.Request[v1.GetChunkRequest]) (*connect.Response[v1.Chunk], error)
+	// ListByUser returns a user's chunks, as GET /sessions/{user_id} does.
+	ListByUser(context.Context, *connect.Request[v1.ListByUserRequest]) (*connect.Response[v1.ListByUserResponse], error)
+}
+
+// NewAudioServiceClient constructs a client for the audioprocessor.v1.AudioService service. By
+// default, it uses the Connect protocol with the binary Protobuf Codec, asks for gzipped responses,
+// and sends uncompressed requests. To use the gRPC or gRPC-Web protocols, supply the
+// connect.WithGRPC() or connect.WithGRPCWeb() options.
+//
+// The URL supplied here should be the base URL for the Connect or gRPC server (for example,
+// http://api.acme.com or https://acme.com/grpc).
+func NewAudioServiceClient(httpClient connect.HTTPClient, baseURL string, opts ...connect.ClientOption) AudioServiceClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	audioServiceMethods := v1.File_audioprocessor_v1_audio_proto.Services().ByName("AudioService").Methods()
+	return &audioServiceClient{
+		uploadChunk: connect.NewClient[v1.UploadChunkRequest, v1.Chunk](
+			httpClient,
+			baseURL+AudioServiceUploadChunkProcedure,
+			connect.WithSchema(audioServiceMethods.ByName("UploadChunk")),
+			connect.WithClientOptions(opts...),
+		),
+		getChunk: connect.NewClient[v1.GetChunkRequest, v1.Chunk](
+			httpClient,
+			baseURL+AudioServiceGetChunkProcedure,
+			connect.WithSchema(audioServiceMethods.ByName("GetChunk")),
+			connect.WithClientOptions(opts...),
+		),
+		listByUser: connect.NewClient[v1.ListByUserRequest, v1.ListByUserResponse](
+			httpClient,
+			baseURL+AudioServiceListByUserProcedure,
+			connect.WithSchema(audioServiceMethods.ByName("ListByUser")),
+			connect.WithClientOptions(opts...),
+		),
+	}
+}
+
+// audioServiceClient implements AudioServiceClient.
+type audioServiceClient struct {
+	uploadChunk *connect.Client[v1.UploadChunkRequest, v1.Chunk]
+	getChunk    *connect.Client[v1.GetChunkRequest, v1.Chunk]
+	listByUser  *connect.Client[v1.ListByUserRequest, v1.ListByUserResponse]
+}
+
+// UploadChunk calls audioprocessor.v1.AudioService.UploadChunk.
+func (c *audioServiceClient) UploadChunk(ctx context.Context, req *connect.Request[v1.UploadChunkRequest]) (*connect.Response[v1.Chunk], error) {
+	return c.uploadChunk.CallUnary(ctx, req)
+}
+
+// GetChunk calls audioprocessor.v1.AudioService.GetChunk.
+func (c *audioServiceClient) GetChunk(ctx context.Context, req *connect.Request[v1.GetChunkRequest]) (*connect.Response[v1.Chunk], error) {
+	return c.getChunk.CallUnary(ctx, req)
+}
+
+// ListByUser calls audioprocessor.v1.AudioService.ListByUser.
+func (c *audioServiceClient) ListByUser(ctx context.Context, req *connect.Request[v1.ListByUserRequest]) (*connect.Response[v1.ListByUserResponse], error) {
+	return c.listByUser.CallUnary(ctx, req)
+}
+
+// AudioServiceHandler is an implementation of the audioprocessor.v1.AudioService service.
+type AudioServiceHandler interface {
+	// UploadChunk stores and processes one chunk, as POST /upload does.
+	UploadChunk(context.Context, *connect.Request[v1.UploadChunkRequest]) (*connect.Response[v1.Chunk], error)
+	// GetChunk returns a chunk's metadata, as GET /chunks/{id} does.
+	GetChunk(context.Context, *connect.Request[v1.GetChunkRequest]) (*connect.Response[v1.Chunk], error)
+	// ListByUser returns a user's chunks, as GET /sessions/{user_id} does.
+	ListByUser(context.Context, *connect.Request[v1.ListByUserRequest]) (*connect.Response[v1.ListByUserResponse], error)
+}
+
+// NewAudioServiceHandler builds an HTTP handler from the service implementation. It returns the
+// path on which to mount the handler and the handler itself.
+//
+// By default, handlers support the Connect, gRPC, and gRPC-Web protocols with the binary Protobuf
+// and JSON codecs. They also support gzip compression.
+func NewAudioServiceHandler(svc AudioServiceHandler, opts ...connect.HandlerOption) (string, http.Handler) {
+	audioServiceMethods := v1.File_audioprocessor_v1_audio_proto.Services().ByName("AudioService").Methods()
+	audioServiceUploadChunkHandler := connect.NewUnaryHandler(
+		AudioServiceUploadChunkProcedure,
+		svc.UploadChunk,
+		connect.WithSchema(audioServiceMethods.ByName("UploadChunk")),
+		connect.WithHandlerOptions(opts...),
+	)
+	audioServiceGetChunkHandler := connect.NewUnaryHandler(
+		AudioServiceGetChunkProcedure,
+		svc.GetChunk,
+		connect.WithSchema(audioServiceMethods.ByName("GetChunk")),
+		connect.WithHandlerOptions(opts...),
+	)
+	audioServiceListByUserHandler := connect.NewUnaryHandler(
+		AudioServiceListByUserProcedure,
+		svc.ListByUser,
+		connect.WithSchema(audioServiceMethods.ByName("ListByUser")),
+		connect.WithHandlerOptions(opts...),
+	)
+	return "/audioprocessor.v1.AudioService/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case AudioServiceUploadChunkProcedure:
+			audioServiceUploadChunkHandler.ServeHTTP(w, r)
+		case AudioServiceGetChunkProcedure:
+			audioServiceGetChunkHandler.ServeHTTP(w, r)
+		case AudioServiceListByUserProcedure:
+			audioServiceListByUserHandler.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// UnimplementedAudioServiceHandler returns CodeUnimplemented from all methods.
+type UnimplementedAudioServiceHandler struct{}
+
+func (UnimplementedAudioServiceHandler) UploadChunk(context.Context, *connect.Request[v1.UploadChunkRequest]) (*connect.Response[v1.Chunk], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("audioprocessor.v1.AudioService.UploadChunk is not implemented"))
+}
+
+func (UnimplementedAudioServiceHandler) GetChunk(context.Context, *connect.Request[v1.GetChunkRequest]) (*connect.Response[v1.Chunk], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("audioprocessor.v1.AudioService.GetChunk is not implemented"))
+}
+
+func (UnimplementedAudioServiceHandler) ListByUser(context.Context, *connect.Request[v1.ListByUserRequest]) (*connect.Response[v1.ListByUserResponse], error) {
+	return nil, connect.NewError(connect.CodeUnimplemented, errors.New("audioprocessor.v1.AudioService.ListByUser is not implemented"))
+}
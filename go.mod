@@ -0,0 +1,14 @@
+module github.com/Kundhavi2798/audio-processor
+
+go 1.25.0
+
+require (
+	connectrpc.com/connect v1.19.1
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/net v0.57.0
+	google.golang.org/protobuf v1.36.11
+)
+
+require golang.org/x/text v0.40.0 // indirect
@@ -0,0 +1,203 @@
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Broker routes messages between the clients connected to it. It keeps no
+// state across connections, so it suits tests and single-host setups
+// rather than fleets of devices.
+type Broker struct {
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[*brokerConn]bool
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type subscription struct {
+	filter string
+	qos    byte
+}
+
+type brokerConn struct {
+	conn net.Conn
+	wmu  sync.Mutex
+
+	// subs and nextID are guarded by the Broker's lock.
+	subs   []subscription
+	nextID uint16
+}
+
+func NewBroker() *Broker {
+	return &Broker{conns: make(map[*brokerConn]bool)}
+}
+
+// Serve accepts clients on ln until Close.
+func (b *Broker) Serve(ln net.Listener) error {
+	b.mu.Lock()
+	b.ln = ln
+	b.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			b.mu.Lock()
+			closed := b.closed
+			b.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		c := &brokerConn{conn: conn}
+		b.mu.Lock()
+		b.conns[c] = true
+		b.mu.Unlock()
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.serveConn(c)
+		}()
+	}
+}
+
+// DropClients disconnects every client without stopping the broker, as
+// a network failure would.
+func (b *Broker) DropClients() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.conns {
+		c.conn.Close()
+	}
+}
+
+// Clients is the number of connected clients.
+func (b *Broker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.conns)
+}
+
+// Close stops accepting clients and disconnects the connected ones.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	var err error
+	if b.ln != nil {
+		err = b.ln.Close()
+	}
+	b.mu.Unlock()
+	b.DropClients()
+	b.wg.Wait()
+	return err
+}
+
+func (c *brokerConn) write(p packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(p.encode())
+	return err
+}
+
+func (b *Broker) serveConn(c *brokerConn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, c)
+		b.mu.Unlock()
+		c.conn.Close()
+	}()
+	r := bufio.NewReader(c.conn)
+	if p, err := readPacket(r); err != nil || p.kind != typeConnect {
+		return
+	}
+	if c.write(packet{kind: typeConnAck, body: []byte{0, 0}}) != nil {
+		return
+	}
+	for {
+		p, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		switch p.kind {
+		case typePublish:
+			m, err := parsePublish(p)
+			if err != nil {
+				return
+			}
+			if m.QoS > 0 && c.write(idPacket(typePubAck, m.PacketID)) != nil {
+				return
+			}
+			b.route(m)
+		case typeSubscribe:
+			if b.subscribe(c, p) != nil {
+				return
+			}
+		case typePingReq:
+			if c.write(packet{kind: typePingResp}) != nil {
+				return
+			}
+		case typeDisconnect:
+			return
+		}
+	}
+}
+
+func (b *Broker) subscribe(c *brokerConn, p packet) error {
+	d := decoder{b: p.body}
+	id := d.uint16()
+	var subs []subscription
+	for d.err == nil && len(d.b) > 0 {
+		subs = append(subs, subscription{filter: d.string(), qos: min(d.byte(), 1)})
+	}
+	if d.err != nil || len(subs) == 0 {
+		return errMalformed
+	}
+	ack := binary.BigEndian.AppendUint16(nil, id)
+	for _, s := range subs {
+		ack = append(ack, s.qos)
+	}
+	b.mu.Lock()
+	c.subs = append(c.subs, subs...)
+	b.mu.Unlock()
+	return c.write(packet{kind: typeSubAck, body: ack})
+}
+
+// route delivers m once to each client with a matching subscription, at
+// the lower of the two QoS levels. Deliveries are not retried.
+func (b *Broker) route(m Message) {
+	type delivery struct {
+		c *brokerConn
+		m Message
+	}
+	var out []delivery
+	b.mu.Lock()
+	for c := range b.conns {
+		matched := false
+		var qos byte
+		for _, s := range c.subs {
+			if Match(s.filter, m.Topic) {
+				matched, qos = true, max(qos, s.qos)
+			}
+		}
+		if !matched {
+			continue
+		}
+		d := Message{Topic: m.Topic, Payload: m.Payload, QoS: min(qos, m.QoS)}
+		if d.QoS > 0 {
+			if c.nextID++; c.nextID == 0 {
+				c.nextID = 1
+			}
+			d.PacketID = c.nextID
+		}
+		out = append(out, delivery{c, d})
+	}
+	b.mu.Unlock()
+	for _, d := range out {
+		if d.c.write(d.m.packet()) != nil {
+			d.c.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by calls on a client whose connection has ended.
+	ErrClosed = errors.New("mqtt: connection closed")
+	// ErrQueueFull ends a connection whose consumer fell more than
+	// Options.MaxQueue messages behind.
+	ErrQueueFull = errors.New("mqtt: message queue full")
+)
+
+// defaultMaxQueue is the MaxQueue used when Options leave it zero.
+const defaultMaxQueue = 256
+
+// Options configure a connection. A zero KeepAlive uses a minute.
+//
+// Unless CleanSession is set the broker keeps the client's session under
+// ClientID across connections, redelivering the QoS 1 messages it did not
+// see acknowledged, so every concurrent client needs its own ClientID.
+//
+// MaxQueue bounds the messages received but not yet taken from Messages,
+// 256 when zero. A client that falls further behind is disconnected with
+// ErrQueueFull, leaving the unacknowledged messages to be redelivered.
+type Options struct {
+	ClientID     string
+	Username     string
+	Password     string
+	KeepAlive    time.Duration
+	CleanSession bool
+	MaxQueue     int
+}
+
+// Client is a connection to a broker. Messages for its subscriptions
+// arrive on Messages; QoS 1 messages must be acknowledged with Ack once
+// handled, so the broker redelivers them if the client fails first.
+type Client struct {
+	Messages <-chan Message
+
+	conn     net.Conn
+	messages chan Message
+	// queue holds messages read but not yet taken from Messages, so the
+	// reader never blocks and acks keep flowing to a slow consumer. It
+	// holds at most maxQueue.
+	queue    []Message
+	queued   chan struct{}
+	maxQueue int
+
+	sessionPresent bool
+
+	wmu sync.Mutex // serializes writes
+
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan []byte
+
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// Dial connects to broker, a tcp:// or mqtt:// URL, and waits for the
+// broker to accept the session.
+func Dial(ctx context.Context, broker string, opts Options) (*Client, error) {
+	u, err := url.Parse(broker)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "tcp" && u.Scheme != "mqtt" {
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if opts.KeepAlive <= 0 {
+		opts.KeepAlive = time.Minute
+	}
+	if opts.MaxQueue <= 0 {
+		opts.MaxQueue = defaultMaxQueue
+	}
+	if u.User != nil && opts.Username == "" {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+
+	r := bufio.NewReader(conn)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(connectPacket(opts).encode()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack, err := readPacket(r)
+	if err == nil && (ack.kind != typeConnAck || len(ack.body) != 2) {
+		err = errMalformed
+	}
+	if err == nil && ack.body[1] != 0 {
+		err = fmt.Errorf("mqtt: connection refused with code %d", ack.body[1])
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	c := &Client{
+		conn:           conn,
+		messages:       make(chan Message),
+		queued:         make(chan struct{}, 1),
+		maxQueue:       opts.MaxQueue,
+		sessionPresent: ack.body[0]&0x01 != 0,
+		pending:        make(map[uint16]chan []byte),
+		done:           make(chan struct{}),
+	}
+	c.Messages = c.messages
+	go c.read(r, opts.KeepAlive)
+	go c.deliver()
+	go c.ping(opts.KeepAlive)
+	return c, nil
+}
+
+func connectPacket(opts Options) packet {
+	var flags byte
+	if opts.CleanSession {
+		flags |= 0x02
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.KeepAlive/time.Second))
+	body = appendString(body, opts.ClientID)
+	if opts.Username != "" {
+		body = appendString(body, opts.Username)
+		if opts.Password != "" {
+			body = appendString(body, opts.Password)
+		}
+	}
+	return packet{kind: typeConnect, body: body}
+}
+
+// SessionPresent reports whether the broker resumed a session it kept
+// for the client ID, and so may redeliver messages sent before.
+func (c *Client) SessionPresent() bool {
+	return c.sessionPresent
+}
+
+// Done is closed when the connection ends; Err then says why.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *Client) Err() error {
+	<-c.done
+	return c.err
+}
+
+// Close disconnects from the broker.
+func (c *Client) Close() error {
+	c.write(packet{kind: typeDisconnect})
+	c.fail(ErrClosed)
+	return nil
+}
+
+func (c *Client) fail(err error) {
+	c.once.Do(func() {
+		c.err = err
+		c.conn.Close()
+		close(c.done)
+	})
+}
+
+func (c *Client) write(p packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.conn.Write(p.encode()); err != nil {
+		c.fail(err)
+		return err
+	}
+	return nil
+}
+
+// read dispatches incoming packets until the connection fails or stays
+// silent for longer than the keep-alive allows.
+func (c *Client) read(r *bufio.Reader, keepAlive time.Duration) {
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		p, err := readPacket(r)
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch p.kind {
+		case typePublish:
+			m, err := parsePublish(p)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			c.mu.Lock()
+			full := len(c.queue) >= c.maxQueue
+			if !full {
+				c.queue = append(c.queue, m)
+			}
+			c.mu.Unlock()
+			if full {
+				c.fail(ErrQueueFull)
+				return
+			}
+			select {
+			case c.queued <- struct{}{}:
+			default:
+			}
+		case typePubAck, typeSubAck:
+			d := decoder{b: p.body}
+			id := d.uint16()
+			c.mu.Lock()
+			ch := c.pending[id]
+			delete(c.pending, id)
+			c.mu.Unlock()
+			if ch != nil {
+				ch <- d.b
+			}
+		}
+	}
+}
+
+// deliver moves queued messages to Messages in the order they arrived.
+func (c *Client) deliver() {
+	for {
+		c.mu.Lock()
+		var next Message
+		ok := len(c.queue) > 0
+		if ok {
+			next, c.queue = c.queue[0], c.queue[1:]
+		}
+		c.mu.Unlock()
+		if !ok {
+			select {
+			case <-c.queued:
+				continue
+			case <-c.done:
+				return
+			}
+		}
+		select {
+		case c.messages <- next:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) ping(keepAlive time.Duration) {
+	t := time.NewTicker(keepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			c.write(packet{kind: typePingReq})
+		}
+	}
+}
+
+// request sends a packet built around a fresh packet ID and waits for the
+// broker's acknowledgement of it, returning the rest of the ack's body.
+func (c *Client) request(ctx context.Context, build func(id uint16) packet) ([]byte, error) {
+	ch := make(chan []byte, 1)
+	c.mu.Lock()
+	c.nextID++
+	if c.nextID == 0 {
+		c.nextID = 1
+	}
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+	if err := c.write(build(id)); err != nil {
+		return nil, err
+	}
+	select {
+	case body := <-ch:
+		return body, nil
+	case <-c.done:
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Subscribe subscribes to filter at up to QoS qos.
+func (c *Client) Subscribe(ctx context.Context, filter string, qos byte) error {
+	codes, err := c.request(ctx, func(id uint16) packet {
+		body := binary.BigEndian.AppendUint16(nil, id)
+		body = appendString(body, filter)
+		return packet{kind: typeSubscribe, flags: 0x02, body: append(body, qos)}
+	})
+	if err != nil {
+		return err
+	}
+	if len(codes) != 1 || codes[0] > 1 {
+		return fmt.Errorf("mqtt: subscription to %s refused", filter)
+	}
+	return nil
+}
+
+// Publish sends payload to topic. At QoS 1 it waits for the broker to
+// acknowledge it.
+func (c *Client) Publish(ctx context.Context, topic string, payload []byte, qos byte) error {
+	m := Message{Topic: topic, Payload: payload, QoS: qos}
+	if qos == 0 {
+		return c.write(m.packet())
+	}
+	_, err := c.request(ctx, func(id uint16) packet {
+		m.PacketID = id
+		return m.packet()
+	})
+	return err
+}
+
+// Ack acknowledges a QoS 1 message; it does nothing for QoS 0.
+func (c *Client) Ack(m Message) error {
+	if m.QoS == 0 {
+		return nil
+	}
+	return c.write(idPacket(typePubAck, m.PacketID))
+}
@@ -0,0 +1,62 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectCleanSession(t *testing.T) {
+	for clean, want := range map[bool]byte{true: 0x02, false: 0} {
+		body := connectPacket(Options{ClientID: "c", CleanSession: clean}).body
+		// "MQTT", the protocol level, then the flags.
+		if got := body[7] & 0x02; got != want {
+			t.Errorf("CleanSession %v: expected flag %#x, but got %#x", clean, want, got)
+		}
+	}
+}
+
+func TestClientQueueBound(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := NewBroker()
+	go broker.Serve(ln)
+	defer broker.Close()
+	url := "tcp://" + ln.Addr().String()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	slow, err := Dial(ctx, url, Options{ClientID: "slow", MaxQueue: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	if err := slow.Subscribe(ctx, "t", 1); err != nil {
+		t.Fatal(err)
+	}
+	pub, err := Dial(ctx, url, Options{ClientID: "pub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Close()
+
+	// Nothing reads slow.Messages: deliver takes one message and blocks,
+	// two more fill the queue and the next overflows it.
+	for range 4 {
+		if err := pub.Publish(ctx, "t", []byte("x"), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-slow.Done():
+		if !errors.Is(slow.Err(), ErrQueueFull) {
+			t.Errorf("Expected ErrQueueFull, but got %v", slow.Err())
+		}
+	case <-ctx.Done():
+		t.Fatal("Expected the slow client to be disconnected")
+	}
+}
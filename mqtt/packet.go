@@ -0,0 +1,181 @@
+// Package mqtt is a minimal MQTT 3.1.1 client and broker: enough of the
+// protocol for devices to publish audio at QoS 0 or 1 and to receive
+// replies, without retained messages or wills. The client can ask the
+// broker to keep its session across connections; this broker keeps none.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Packet types, in the high nibble of the fixed header.
+const (
+	typeConnect    = 1
+	typeConnAck    = 2
+	typePublish    = 3
+	typePubAck     = 4
+	typeSubscribe  = 8
+	typeSubAck     = 9
+	typePingReq    = 12
+	typePingResp   = 13
+	typeDisconnect = 14
+
+	protocolLevel = 4
+)
+
+var errMalformed = errors.New("mqtt: malformed packet")
+
+// packet is a control packet's fixed header flags and its variable header
+// and payload.
+type packet struct {
+	kind  byte
+	flags byte
+	body  []byte
+}
+
+func readPacket(r *bufio.Reader) (packet, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return packet{}, err
+	}
+	var n, shift int
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return packet{}, err
+		}
+		n |= int(b&0x7f) << shift
+		if b&0x80 == 0 {
+			break
+		}
+		if shift += 7; shift > 21 {
+			return packet{}, errMalformed
+		}
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return packet{}, err
+	}
+	return packet{kind: first >> 4, flags: first & 0x0f, body: body}, nil
+}
+
+func (p packet) encode() []byte {
+	out := []byte{p.kind<<4 | p.flags}
+	n := len(p.body)
+	for {
+		b := byte(n & 0x7f)
+		if n >>= 7; n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, p.body...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// decoder reads the fields of a packet body in order, remembering the
+// first error.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) uint16() uint16 {
+	if len(d.b) < 2 {
+		d.err = errMalformed
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.b)
+	d.b = d.b[2:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if len(d.b) < 1 {
+		d.err = errMalformed
+		return 0
+	}
+	v := d.b[0]
+	d.b = d.b[1:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := int(d.uint16())
+	if len(d.b) < n {
+		d.err = errMalformed
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// Message is an application message. PacketID is only set on QoS 1
+// messages, and Duplicate when the sender is redelivering one.
+type Message struct {
+	Topic     string
+	Payload   []byte
+	QoS       byte
+	Duplicate bool
+	PacketID  uint16
+}
+
+func (m Message) packet() packet {
+	flags := m.QoS << 1
+	if m.Duplicate {
+		flags |= 0x08
+	}
+	body := appendString(nil, m.Topic)
+	if m.QoS > 0 {
+		body = binary.BigEndian.AppendUint16(body, m.PacketID)
+	}
+	return packet{kind: typePublish, flags: flags, body: append(body, m.Payload...)}
+}
+
+func parsePublish(p packet) (Message, error) {
+	d := decoder{b: p.body}
+	m := Message{Topic: d.string(), QoS: p.flags >> 1 & 0x03, Duplicate: p.flags&0x08 != 0}
+	if m.QoS > 1 {
+		return Message{}, fmt.Errorf("mqtt: QoS %d is not supported", m.QoS)
+	}
+	if m.QoS > 0 {
+		m.PacketID = d.uint16()
+	}
+	if d.err != nil {
+		return Message{}, d.err
+	}
+	m.Payload = d.b
+	return m, nil
+}
+
+func idPacket(kind byte, id uint16) packet {
+	return packet{kind: kind, body: binary.BigEndian.AppendUint16(nil, id)}
+}
+
+// Match reports whether topic matches filter, in which + stands for one
+// level and a trailing # for any number of them.
+func Match(filter, topic string) bool {
+	fs, ts := strings.Split(filter, "/"), strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i == len(ts) || (f != "+" && f != ts[i]) {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,44 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestPublishRoundTrip(t *testing.T) {
+	for _, m := range []Message{
+		{Topic: "audio/u/s/chunk", Payload: []byte("abc"), QoS: 1, PacketID: 7, Duplicate: true},
+		{Topic: "t", Payload: bytes.Repeat([]byte{1}, 200000)},
+	} {
+		p, err := readPacket(bufio.NewReader(bytes.NewReader(m.packet().encode())))
+		if err != nil {
+			t.Fatalf("readPacket error: %v", err)
+		}
+		got, err := parsePublish(p)
+		if err != nil {
+			t.Fatalf("parsePublish error: %v", err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("Expected %+v back, but got %+v", m.Topic, got.Topic)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		filter, topic string
+		want          bool
+	}{
+		{"audio/+/+/chunk", "audio/u/s/chunk", true},
+		{"audio/+/+/chunk", "audio/u/chunk", false},
+		{"audio/+/+/chunk", "audio/u/s/chunk/x", false},
+		{"audio/#", "audio/u/s", true},
+		{"audio/u", "audio/v", false},
+	} {
+		if got := Match(tc.filter, tc.topic); got != tc.want {
+			t.Errorf("Match(%q, %q): expected %v, but got %v", tc.filter, tc.topic, tc.want, got)
+		}
+	}
+}
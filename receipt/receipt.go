@@ -0,0 +1,148 @@
+// Package receipt signs and verifies upload receipts: the server's signed
+// statement that it accepted a chunk with a given checksum and size at a
+// given time. The server signs them and the client package and audioctl
+// verify them.
+//
+// A receipt is signed with Ed25519 over a fixed text form of its fields,
+// so the JSON it travels in can be reformatted without breaking it. Each
+// signature names the key it was made with, and servers publish every key
+// they still vouch for, so receipts outlive a key rotation.
+package receipt
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeysPath is where servers publish their receipt keys.
+const KeysPath = "/.well-known/audio-processor/keys"
+
+// Algorithm names the signature scheme in published keys.
+const Algorithm = "Ed25519"
+
+var (
+	// ErrUnknownKey means the receipt names a key the key set lacks.
+	ErrUnknownKey = errors.New("receipt: signed with an unknown key")
+	// ErrBadSignature means the receipt was altered or not signed by its key.
+	ErrBadSignature = errors.New("receipt: signature does not match")
+	// ErrMismatch means the receipt is genuine but for other bytes.
+	ErrMismatch = errors.New("receipt: does not match the data")
+)
+
+// Receipt says the server accepted Bytes bytes with SHA-256 Checksum as
+// chunk ChunkID at ReceivedAt.
+type Receipt struct {
+	ChunkID    string    `json:"chunk_id"`
+	Checksum   string    `json:"checksum"`
+	Bytes      int64     `json:"bytes"`
+	ReceivedAt time.Time `json:"received_at"`
+	KeyID      string    `json:"key_id"`
+	Signature  []byte    `json:"signature"`
+}
+
+// payload is the text the signature covers.
+func (r Receipt) payload() []byte {
+	return []byte(strings.Join([]string{
+		"audio-processor-receipt/v1",
+		r.ChunkID,
+		r.Checksum,
+		strconv.FormatInt(r.Bytes, 10),
+		r.ReceivedAt.UTC().Format(time.RFC3339Nano),
+		r.KeyID,
+	}, "\n"))
+}
+
+// Matches reports whether data is the content the receipt is for.
+func (r Receipt) Matches(data []byte) bool {
+	sum := sha256.Sum256(data)
+	return int64(len(data)) == r.Bytes && hex.EncodeToString(sum[:]) == r.Checksum
+}
+
+// Key is a signing key and the ID receipts name it by.
+type Key struct {
+	ID      string
+	Private ed25519.PrivateKey
+}
+
+// Sign fills in r's KeyID and Signature.
+func (k Key) Sign(r Receipt) Receipt {
+	r.ReceivedAt = r.ReceivedAt.UTC()
+	r.KeyID = k.ID
+	r.Signature = ed25519.Sign(k.Private, r.payload())
+	return r
+}
+
+// Public returns the key as published.
+func (k Key) Public() PublicKey {
+	return PublicKey{ID: k.ID, Algorithm: Algorithm, Key: k.Private.Public().(ed25519.PublicKey)}
+}
+
+// ParseKeys reads comma-separated id=seed pairs, where seed is the
+// base64-encoded 32-byte Ed25519 seed, e.g. "2024-06=q83v...". The first
+// key signs; the rest are kept so receipts they signed still verify.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(spec, "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("receipt key %q: want id=seed", spec)
+		}
+		seed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("receipt key %q: seed must be %d base64-encoded bytes", id, ed25519.SeedSize)
+		}
+		keys = append(keys, Key{ID: id, Private: ed25519.NewKeyFromSeed(seed)})
+	}
+	return keys, nil
+}
+
+// PublicKey is a verification key as served at KeysPath.
+type PublicKey struct {
+	ID        string            `json:"key_id"`
+	Algorithm string            `json:"alg"`
+	Key       ed25519.PublicKey `json:"public_key"`
+	// Active marks the key new receipts are signed with.
+	Active bool `json:"active,omitempty"`
+}
+
+// KeySet is the body served at KeysPath.
+type KeySet struct {
+	Keys []PublicKey `json:"keys"`
+}
+
+// Verify checks that r was signed by a key in the set and not altered
+// since.
+func (s KeySet) Verify(r Receipt) error {
+	for _, k := range s.Keys {
+		if k.ID != r.KeyID {
+			continue
+		}
+		if k.Algorithm != Algorithm || len(k.Key) != ed25519.PublicKeySize || !ed25519.Verify(k.Key, r.payload(), r.Signature) {
+			return ErrBadSignature
+		}
+		return nil
+	}
+	return ErrUnknownKey
+}
+
+// VerifyData is Verify plus a check that r is for data.
+func (s KeySet) VerifyData(r Receipt, data []byte) error {
+	if err := s.Verify(r); err != nil {
+		return err
+	}
+	if !r.Matches(data) {
+		return ErrMismatch
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package receipt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testKey(t *testing.T, id string, fill byte) Key {
+	t.Helper()
+	keys, err := ParseKeys(id + "=" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{fill}, 32)))
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Expected one key, but got %v %v", keys, err)
+	}
+	return keys[0]
+}
+
+func receiptFor(data []byte) Receipt {
+	sum := sha256.Sum256(data)
+	return Receipt{ChunkID: "c1", Checksum: hex.EncodeToString(sum[:]), Bytes: int64(len(data)), ReceivedAt: time.Date(2024, 6, 1, 12, 0, 0, 123456789, time.FixedZone("x", 3600))}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	key := testKey(t, "k1", 1)
+	data := []byte("RIFF audio")
+	signed := key.Sign(receiptFor(data))
+	keys := KeySet{Keys: []PublicKey{key.Public()}}
+
+	// The receipt survives a round trip through JSON.
+	raw, _ := json.Marshal(signed)
+	var decoded Receipt
+	json.Unmarshal(raw, &decoded)
+	if err := keys.VerifyData(decoded, data); err != nil {
+		t.Fatalf("Expected the receipt to verify, but got %v", err)
+	}
+	if err := keys.VerifyData(decoded, []byte("other audio")); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Expected other data not to match, but got %v", err)
+	}
+}
+
+func TestTamperedReceiptFails(t *testing.T) {
+	key := testKey(t, "k1", 1)
+	keys := KeySet{Keys: []PublicKey{key.Public()}}
+	signed := key.Sign(receiptFor([]byte("RIFF audio")))
+
+	tampered := signed
+	tampered.Checksum = hex.EncodeToString(make([]byte, 32))
+	if err := keys.Verify(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected a changed checksum to break the signature, but got %v", err)
+	}
+	tampered = signed
+	tampered.Bytes++
+	if err := keys.Verify(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected a changed size to break the signature, but got %v", err)
+	}
+	forged := testKey(t, "k1", 2).Sign(receiptFor([]byte("RIFF audio")))
+	if err := keys.Verify(forged); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected a receipt signed by another key to fail, but got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, current := testKey(t, "2024-01", 1), testKey(t, "2024-06", 2)
+	oldReceipt := old.Sign(receiptFor([]byte("before")))
+
+	// After rotation the old key is still published, so its receipts verify.
+	rotated := KeySet{Keys: []PublicKey{current.Public(), old.Public()}}
+	if err := rotated.Verify(oldReceipt); err != nil {
+		t.Errorf("Expected a receipt from the old key to verify, but got %v", err)
+	}
+	if err := rotated.Verify(current.Sign(receiptFor([]byte("after")))); err != nil {
+		t.Errorf("Expected a receipt from the new key to verify, but got %v", err)
+	}
+	// Once it is withdrawn they no longer do.
+	withdrawn := KeySet{Keys: []PublicKey{current.Public()}}
+	if err := withdrawn.Verify(oldReceipt); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected an unknown key error, but got %v", err)
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	keys, err := ParseKeys(" a=" + seed + ", b=" + seed + ",")
+	if err != nil || len(keys) != 2 || keys[0].ID != "a" || keys[1].ID != "b" {
+		t.Fatalf("Expected keys a and b, but got %v %v", keys, err)
+	}
+	for _, bad := range []string{"a", "=" + seed, "a=short", "a=!!"} {
+		if _, err := ParseKeys(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
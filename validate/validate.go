@@ -0,0 +1,93 @@
+// Package validate checks the identifiers clients send with audio, so the
+// server and the client package apply the same rules.
+package validate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Field error codes.
+const (
+	CodeRequired          = "required"
+	CodeTooLong           = "too_long"
+	CodeInvalidCharacters = "invalid_characters"
+	CodeInvalidUUID       = "invalid_uuid"
+)
+
+// FieldError describes one invalid field. Code is stable and meant for
+// programs; Message is for people.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a request.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid " + strings.Join(msgs, "; ")
+}
+
+var (
+	defaultAllowed = regexp.MustCompile(`^[A-Za-z0-9._:@-]+$`)
+	uuidPattern    = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Rules constrain user and session IDs. Every ID must be non-empty and at
+// most MaxLength characters; Allowed, when set, must match the whole ID.
+// With SessionUUID, session IDs must instead be UUIDs.
+type Rules struct {
+	MaxLength   int
+	Allowed     *regexp.Regexp
+	SessionUUID bool
+}
+
+// DefaultRules allow up to 128 ASCII letters, digits and ._:@- characters.
+func DefaultRules() Rules {
+	return Rules{MaxLength: 128, Allowed: defaultAllowed}
+}
+
+// Check reports the first problem with value and true, or false if value
+// is valid.
+func (r Rules) Check(field, value string) (FieldError, bool) {
+	invalid := func(code, format string, args ...any) (FieldError, bool) {
+		return FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)}, true
+	}
+	switch {
+	case value == "":
+		return invalid(CodeRequired, "must not be empty")
+	case r.MaxLength > 0 && utf8.RuneCountInString(value) > r.MaxLength:
+		return invalid(CodeTooLong, "must be at most %d characters", r.MaxLength)
+	case field == "session_id" && r.SessionUUID:
+		if !uuidPattern.MatchString(value) {
+			return invalid(CodeInvalidUUID, "must be a UUID")
+		}
+	case r.Allowed != nil && !r.Allowed.MatchString(value):
+		return invalid(CodeInvalidCharacters, "must match %s", r.Allowed)
+	}
+	return FieldError{}, false
+}
+
+// IDs checks a user and session ID together and returns Errors naming each
+// one that is invalid, or nil.
+func (r Rules) IDs(userID, sessionID string) error {
+	var errs Errors
+	if fe, bad := r.Check("user_id", userID); bad {
+		errs = append(errs, fe)
+	}
+	if fe, bad := r.Check("session_id", sessionID); bad {
+		errs = append(errs, fe)
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
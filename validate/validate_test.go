@@ -0,0 +1,63 @@
+package validate
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	uuid := "6f1c2b9e-3a4d-4e5f-8a7b-9c0d1e2f3a4b"
+	cases := []struct {
+		name  string
+		rules Rules
+		field string
+		value string
+		code  string
+	}{
+		{"valid", DefaultRules(), "user_id", "user-1@example.com", ""},
+		{"empty", DefaultRules(), "user_id", "", CodeRequired},
+		{"at max length", DefaultRules(), "user_id", strings.Repeat("a", 128), ""},
+		{"too long", DefaultRules(), "user_id", strings.Repeat("a", 129), CodeTooLong},
+		{"long multibyte counts runes", Rules{MaxLength: 3}, "user_id", "äöü", ""},
+		{"control character", DefaultRules(), "session_id", "s\n1", CodeInvalidCharacters},
+		{"space", DefaultRules(), "session_id", "my session", CodeInvalidCharacters},
+		{"non-ascii", DefaultRules(), "user_id", "jürgen", CodeInvalidCharacters},
+		{"custom charset", Rules{Allowed: regexp.MustCompile(`^[a-z]+$`)}, "user_id", "abc1", CodeInvalidCharacters},
+		{"no length limit", Rules{}, "user_id", strings.Repeat("a", 10000), ""},
+		{"uuid session", Rules{SessionUUID: true}, "session_id", uuid, ""},
+		{"non-uuid session", Rules{SessionUUID: true}, "session_id", "sess1", CodeInvalidUUID},
+		{"uuid rule skips users", Rules{SessionUUID: true}, "user_id", "user1", ""},
+		{"uuid still required", Rules{SessionUUID: true}, "session_id", "", CodeRequired},
+	}
+	for _, tc := range cases {
+		fe, bad := tc.rules.Check(tc.field, tc.value)
+		got := ""
+		if bad {
+			got = fe.Code
+		}
+		if got != tc.code {
+			t.Errorf("%s: expected code %q, but got %q", tc.name, tc.code, got)
+		}
+		if bad && (fe.Field != tc.field || fe.Message == "") {
+			t.Errorf("%s: expected the field and a message, but got %+v", tc.name, fe)
+		}
+	}
+}
+
+func TestIDsReportsEveryField(t *testing.T) {
+	err := DefaultRules().IDs("", "bad session")
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected Errors, but got %v", err)
+	}
+	codes := []string{errs[0].Field + "=" + errs[0].Code, errs[1].Field + "=" + errs[1].Code}
+	if want := []string{"user_id=required", "session_id=invalid_characters"}; len(errs) != 2 || !reflect.DeepEqual(codes, want) {
+		t.Errorf("Expected %v, but got %+v", want, errs)
+	}
+	if err := DefaultRules().IDs("user1", "sess1"); err != nil {
+		t.Errorf("Expected valid IDs to pass, but got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package webhook signs and verifies audio-processor webhook deliveries.
+// The server signs every delivery attempt and receivers written in Go can
+// check them with a Verifier.
+//
+// Each attempt carries the time it was sent and a random nonce, and its
+// signature is an HMAC-SHA256, keyed with the subscription's secret, over
+// both and the body. A receiver should:
+//
+//   - verify the signature over the raw body, before parsing it;
+//   - refuse deliveries whose timestamp is more than a few minutes from its
+//     own clock, so a captured delivery cannot be sent again later;
+//   - remember each nonce for that long and refuse any it has seen, so it
+//     cannot be sent again sooner;
+//   - treat anything it cannot check, such as a missing header, as a
+//     failure.
+//
+// Retries of a delivery are signed afresh, with a new timestamp and nonce,
+// and keep the X-Webhook-Delivery ID of the first attempt, which is the key
+// to deduplicate processing on.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The headers a signed delivery carries.
+const (
+	TimestampHeader = "X-Webhook-Timestamp"
+	NonceHeader     = "X-Webhook-Nonce"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// DefaultTolerance is how far a delivery's timestamp may be from the
+// receiver's clock when a Verifier sets none.
+const DefaultTolerance = 5 * time.Minute
+
+// maxBody bounds what VerifyRequest reads.
+const maxBody = 10 << 20
+
+var (
+	// ErrUnsigned means a signature header is missing or malformed.
+	ErrUnsigned = errors.New("webhook: delivery is not signed")
+	// ErrBadSignature means the delivery was altered or signed with
+	// another secret.
+	ErrBadSignature = errors.New("webhook: signature does not match")
+	// ErrStale means the delivery was signed too long ago, or claims to
+	// be from the future.
+	ErrStale = errors.New("webhook: timestamp is outside the tolerance")
+	// ErrReplayed means the delivery's nonce was seen before.
+	ErrReplayed = errors.New("webhook: nonce has already been used")
+)
+
+// Signature returns the signature of body sent at ts with nonce, as it
+// appears in SignatureHeader.
+func Signature(secret string, ts time.Time, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s.", ts.Unix(), nonce)
+	mac.Write(body)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets the headers of a delivery of body sent at ts with nonce.
+func Sign(h http.Header, secret string, ts time.Time, nonce string, body []byte) {
+	h.Set(TimestampHeader, strconv.FormatInt(ts.Unix(), 10))
+	h.Set(NonceHeader, nonce)
+	h.Set(SignatureHeader, Signature(secret, ts, nonce, body))
+}
+
+// NonceStore remembers the nonces of verified deliveries. Receivers that
+// run more than one instance need one they all share.
+type NonceStore interface {
+	// Seen records nonce until the given time, when it can no longer
+	// verify anyway, and reports whether it was already recorded.
+	Seen(nonce string, until time.Time) (bool, error)
+}
+
+// MemoryNonces is a NonceStore for a receiver running as one process.
+type MemoryNonces struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewMemoryNonces returns an empty MemoryNonces.
+func NewMemoryNonces() *MemoryNonces {
+	return &MemoryNonces{seen: make(map[string]time.Time)}
+}
+
+// Seen records nonce, forgetting those whose time has passed by the wall
+// clock.
+func (m *MemoryNonces) Seen(nonce string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for n, t := range m.seen {
+		if now.After(t) {
+			delete(m.seen, n)
+		}
+	}
+	if _, ok := m.seen[nonce]; ok {
+		return true, nil
+	}
+	m.seen[nonce] = until
+	return false, nil
+}
+
+// Verifier checks signed deliveries. It fails closed: without a secret or
+// a nonce store nothing verifies.
+type Verifier struct {
+	Secret string
+	// Tolerance is how far a delivery's timestamp may be from Now; 0 is
+	// DefaultTolerance.
+	Tolerance time.Duration
+	Nonces    NonceStore
+	// Now is the receiver's clock; nil is time.Now.
+	Now func() time.Time
+}
+
+// Verify checks the signature headers in h against body. The nonce is only
+// recorded once the signature and timestamp check out, so forged
+// deliveries cannot fill the nonce store.
+func (v *Verifier) Verify(h http.Header, body []byte) error {
+	if v.Secret == "" || v.Nonces == nil {
+		return errors.New("webhook: verifier has no secret or nonce store")
+	}
+	unix, err := strconv.ParseInt(h.Get(TimestampHeader), 10, 64)
+	nonce, sig := h.Get(NonceHeader), h.Get(SignatureHeader)
+	if err != nil || nonce == "" || sig == "" {
+		return ErrUnsigned
+	}
+	ts := time.Unix(unix, 0)
+	if !hmac.Equal([]byte(sig), []byte(Signature(v.Secret, ts, nonce, body))) {
+		return ErrBadSignature
+	}
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if ts.Before(now.Add(-tolerance)) || ts.After(now.Add(tolerance)) {
+		return ErrStale
+	}
+	seen, err := v.Nonces.Seen(nonce, ts.Add(tolerance))
+	if err != nil {
+		return fmt.Errorf("webhook: checking nonce: %w", err)
+	}
+	if seen {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// VerifyRequest reads r's body, up to 10MB, and verifies it. The body is
+// returned for the caller to parse only if it verified.
+func (v *Verifier) VerifyRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBody {
+		return nil, errors.New("webhook: body is too large")
+	}
+	if err := v.Verify(r.Header, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signed(secret string, ts time.Time, nonce string, body []byte) http.Header {
+	h := make(http.Header)
+	Sign(h, secret, ts, nonce, body)
+	return h
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	v := &Verifier{Secret: "s3cret", Nonces: NewMemoryNonces(), Now: func() time.Time { return now }}
+	body := []byte(`{"type":"chunk.processed"}`)
+
+	if err := v.Verify(signed("s3cret", now.Add(-time.Minute), "n1", body), body); err != nil {
+		t.Fatalf("Expected a fresh delivery to verify, but got %v", err)
+	}
+	for _, tc := range []struct {
+		name string
+		h    http.Header
+		body []byte
+		want error
+	}{
+		{"replayed nonce", signed("s3cret", now, "n1", body), body, ErrReplayed},
+		{"stale", signed("s3cret", now.Add(-6*time.Minute), "n2", body), body, ErrStale},
+		{"from the future", signed("s3cret", now.Add(6*time.Minute), "n3", body), body, ErrStale},
+		{"other secret", signed("other", now, "n4", body), body, ErrBadSignature},
+		{"altered body", signed("s3cret", now, "n5", body), []byte(`{"type":"chunk.deleted"}`), ErrBadSignature},
+		{"unsigned", http.Header{}, body, ErrUnsigned},
+	} {
+		if err := v.Verify(tc.h, tc.body); !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, but got %v", tc.name, tc.want, err)
+		}
+	}
+	// A stale delivery's nonce was not recorded; only the signature and
+	// timestamp decide, so the nonce is still usable once.
+	if err := v.Verify(signed("s3cret", now, "n2", body), body); err != nil {
+		t.Errorf("Expected a refused delivery's nonce to stay unused, but got %v", err)
+	}
+
+	for _, bad := range []*Verifier{{Nonces: NewMemoryNonces()}, {Secret: "s3cret"}} {
+		if err := bad.Verify(signed("", now, "n6", body), body); err == nil {
+			t.Errorf("Expected a verifier without a secret or nonce store to refuse everything")
+		}
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	v := &Verifier{Secret: "s3cret", Nonces: NewMemoryNonces()}
+	body := `{"id":"d1"}`
+	r := httptest.NewRequest("POST", "/hook", strings.NewReader(body))
+	Sign(r.Header, "s3cret", time.Now(), "n1", []byte(body))
+	if got, err := v.VerifyRequest(r); err != nil || string(got) != body {
+		t.Errorf("Expected the body back, but got %q %v", got, err)
+	}
+}